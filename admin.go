@@ -0,0 +1,377 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// slowQueryLogSize is the number of slow queries kept per handler.
+const slowQueryLogSize = 100
+
+// instances holds the provisioned handlers in the process, so the admin API
+// can report on them. During a config reload the old and new handlers are
+// both listed until the old config is cleaned up.
+var instances struct {
+	sync.Mutex
+	list []*HTMLFromDuckDB
+}
+
+// registerInstance adds a provisioned handler to the instances list.
+func registerInstance(h *HTMLFromDuckDB) {
+	instances.Lock()
+	defer instances.Unlock()
+	instances.list = append(instances.list, h)
+}
+
+// unregisterInstance removes a handler from the instances list.
+func unregisterInstance(h *HTMLFromDuckDB) {
+	instances.Lock()
+	defer instances.Unlock()
+	for i, inst := range instances.list {
+		if inst == h {
+			instances.list = append(instances.list[:i], instances.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// liveInstances returns a snapshot of the instances list.
+func liveInstances() []*HTMLFromDuckDB {
+	instances.Lock()
+	defer instances.Unlock()
+	return append([]*HTMLFromDuckDB(nil), instances.list...)
+}
+
+// instanceName identifies the handler in the admin API, metrics and logs.
+func (h *HTMLFromDuckDB) instanceName() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	if h.BasePath != "" {
+		return h.Table + "@" + h.BasePath
+	}
+	return h.Table
+}
+
+// checkInstanceName rejects a handler named like another one in the same
+// config, since the admin API, metrics and Render could only reach one of
+// them. Handlers of the config a reload replaces are still listed while the
+// new one is provisioned, and may share names with it.
+func (h *HTMLFromDuckDB) checkInstanceName() error {
+	name := h.instanceName()
+	for _, inst := range liveInstances() {
+		if inst.config == h.config && inst.instanceName() == name {
+			return fmt.Errorf("another html_from_duckdb handler is named %q; set a unique name", name)
+		}
+	}
+	return nil
+}
+
+// errNothingToSwap is returned by swapNow for handlers that don't swap
+// copies of their database in.
+var errNothingToSwap = errors.New("nothing to swap without sync, load_into_memory or dataset_refresh_interval")
+
+// SwapResult is the response to a swap request.
+type SwapResult struct {
+	Swapped bool `json:"swapped"`
+}
+
+// swapNow does what the next sync, memory reload or dataset refresh would
+// do right away: it checks for a new copy of the database and, if there is
+// one, swaps it in.
+func (h *HTMLFromDuckDB) swapNow(ctx context.Context) (SwapResult, error) {
+	var swapped bool
+	var err error
+	switch {
+	case h.replica == nil:
+		return SwapResult{}, errNothingToSwap
+	case h.replica.config != nil:
+		swapped, err = h.syncReplica(ctx)
+	case h.LoadIntoMemory:
+		swapped, err = h.reloadMemory(ctx)
+	default:
+		swapped, err = h.refreshDatasets(ctx)
+	}
+	return SwapResult{Swapped: swapped}, err
+}
+
+// SlowQuery is an entry in the slow query log.
+type SlowQuery struct {
+	Time       time.Time `json:"time"`
+	Endpoint   string    `json:"endpoint"`
+	SQL        string    `json:"sql"`
+	DurationMs int64     `json:"duration_ms"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// slowQueryLog is a fixed-size ring buffer of the most recent slow queries.
+type slowQueryLog struct {
+	mu      sync.Mutex
+	entries []SlowQuery
+	next    int
+	full    bool
+}
+
+func newSlowQueryLog(size int) *slowQueryLog {
+	return &slowQueryLog{entries: make([]SlowQuery, size)}
+}
+
+// add records a slow query, overwriting the oldest entry when full.
+func (l *slowQueryLog) add(q SlowQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = q
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// snapshot returns the logged queries, newest first.
+func (l *slowQueryLog) snapshot() []SlowQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	out := make([]SlowQuery, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return out
+}
+
+// observeQuery adds a query's time to the request's {duckdb.query_ms} and
+// records it in the slow query log, logging a warning, if it took at least
+// slow_query_threshold.
+func (h *HTMLFromDuckDB) observeQuery(ctx context.Context, endpoint, query string, elapsed time.Duration) {
+	requestInfoFrom(ctx).addQueryTime(elapsed)
+	if h.slowLog == nil || h.slowAfter <= 0 || elapsed < h.slowAfter {
+		return
+	}
+	h.slowLog.add(SlowQuery{
+		Time:       time.Now(),
+		Endpoint:   endpoint,
+		SQL:        truncateForLog(query, 1000),
+		DurationMs: elapsed.Milliseconds(),
+		RequestID:  requestIDFrom(ctx),
+	})
+	h.log(ctx).Warn("slow query",
+		zap.String("endpoint", endpoint),
+		zap.String("sql", truncateForLog(query, 200)),
+		zap.Duration("duration", elapsed))
+}
+
+// MacroInfo describes a macro defined in a handler's database.
+type MacroInfo struct {
+	Name       string   `json:"name"`
+	Schema     string   `json:"schema"`
+	Type       string   `json:"type"`
+	Parameters []string `json:"parameters"`
+}
+
+// macros lists the user-defined scalar and table macros in the database.
+func (h *HTMLFromDuckDB) macros(ctx context.Context) ([]MacroInfo, error) {
+	rows, err := h.database().QueryContext(ctx, `SELECT function_name, schema_name, function_type, parameters
+		FROM duckdb_functions()
+		WHERE function_type IN ('macro', 'table_macro') AND NOT internal
+		ORDER BY function_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	macros := []MacroInfo{}
+	for rows.Next() {
+		var m MacroInfo
+		var params any
+		if err := rows.Scan(&m.Name, &m.Schema, &m.Type, &params); err != nil {
+			return nil, err
+		}
+		m.Parameters = []string{}
+		list, _ := params.([]any)
+		for _, p := range list {
+			m.Parameters = append(m.Parameters, fmt.Sprint(p))
+		}
+		macros = append(macros, m)
+	}
+	return macros, rows.Err()
+}
+
+// AdminAPI is a module that serves operational information about the
+// html_from_duckdb handlers on Caddy's admin endpoint:
+//
+//	GET /duckdb/config        handler configuration (auth tokens redacted)
+//	GET /duckdb/pools         connection pool statistics
+//	GET /duckdb/slow_queries  recent queries slower than slow_query_threshold
+//	GET /duckdb/macros        macros defined in each handler's database
+//	GET /duckdb/cache         response cache statistics
+//	POST /duckdb/purge?tag=   drop cached pages and ESI responses by cache tag
+//	POST /duckdb/swap         sync, reload or refresh a handler's database now
+//
+// Each route returns a JSON object keyed by instance name; ?instance=
+// limits the output to one handler, and is required for swap. While a config reload is in progress
+// the newest handler with a given name is reported.
+type AdminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.duckdb",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the admin routes.
+func (a *AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{{
+		Pattern: "/duckdb/",
+		Handler: caddy.AdminHandlerFunc(a.serveAdmin),
+	}}
+}
+
+// serveAdmin dispatches /duckdb/* admin requests.
+func (a *AdminAPI) serveAdmin(w http.ResponseWriter, r *http.Request) error {
+	resource := strings.Trim(strings.TrimPrefix(r.URL.Path, "/duckdb"), "/")
+	method := http.MethodGet
+	if resource == "purge" || resource == "swap" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	var report func(ctx context.Context, h *HTMLFromDuckDB) (any, error)
+	switch resource {
+	case "config":
+		report = func(_ context.Context, h *HTMLFromDuckDB) (any, error) {
+			return h.configSummary(), nil
+		}
+	case "pools":
+		report = func(_ context.Context, h *HTMLFromDuckDB) (any, error) {
+			return h.poolStats(), nil
+		}
+	case "slow_queries":
+		report = func(_ context.Context, h *HTMLFromDuckDB) (any, error) {
+			return h.slowLog.snapshot(), nil
+		}
+	case "macros":
+		report = func(ctx context.Context, h *HTMLFromDuckDB) (any, error) {
+			return h.macros(ctx)
+		}
+	case "cache":
+		report = func(_ context.Context, h *HTMLFromDuckDB) (any, error) {
+			return h.cacheStats(), nil
+		}
+	case "purge":
+		tags := r.URL.Query()["tag"]
+		if len(tags) == 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("tag is required"),
+			}
+		}
+		report = func(ctx context.Context, h *HTMLFromDuckDB) (any, error) {
+			n := h.purgeTags(tags...)
+			h.audit(ctx, auditCachePurge, adminActor(r), nil, map[string]any{"tags": tags, "purged": n})
+			return PurgeResult{Purged: n}, nil
+		}
+	case "swap":
+		if r.URL.Query().Get("instance") == "" {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("instance is required"),
+			}
+		}
+		// Downloads and loads aren't queries, so query_timeout doesn't
+		// apply to them.
+		report = func(_ context.Context, h *HTMLFromDuckDB) (any, error) {
+			return h.swapNow(r.Context())
+		}
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown resource %q", r.URL.Path),
+		}
+	}
+
+	want := r.URL.Query().Get("instance")
+	out := make(map[string]any)
+	for _, h := range liveInstances() {
+		name := h.instanceName()
+		if want != "" && name != want {
+			continue
+		}
+		ctx, cancel := h.queryContext(r.Context())
+		v, err := report(ctx, h)
+		cancel()
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errNothingToSwap) {
+				status = http.StatusBadRequest
+			}
+			return caddy.APIError{
+				HTTPStatus: status,
+				Err:        fmt.Errorf("instance %s: %v", name, err),
+			}
+		}
+		out[name] = v
+	}
+	if want != "" && len(out) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown instance %q", want),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(out)
+}
+
+// configSummary returns the handler's configuration with auth tokens
+// redacted.
+func (h *HTMLFromDuckDB) configSummary() HTMLFromDuckDB {
+	cfg := *h
+	if len(cfg.AuthTokens) > 0 {
+		cfg.AuthTokens = make([]string, len(h.AuthTokens))
+		for i := range cfg.AuthTokens {
+			cfg.AuthTokens[i] = "REDACTED"
+		}
+	}
+	if h.Webhooks != nil && h.Webhooks.Secret != "" {
+		webhooks := *h.Webhooks
+		webhooks.Secret = "REDACTED"
+		cfg.Webhooks = &webhooks
+	}
+	if h.Sync != nil && h.Sync.Token != "" {
+		sync := *h.Sync
+		sync.Token = "REDACTED"
+		cfg.Sync = &sync
+	}
+	if h.Backup != nil && h.Backup.Token != "" {
+		backup := *h.Backup
+		backup.Token = "REDACTED"
+		cfg.Backup = &backup
+	}
+	return cfg
+}
+
+// Interface guards
+var _ caddy.AdminRouter = (*AdminAPI)(nil)
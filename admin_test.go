@@ -0,0 +1,229 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	log := newSlowQueryLog(3)
+	if got := log.snapshot(); len(got) != 0 {
+		t.Fatalf("expected empty log, got %v", got)
+	}
+	for i := 1; i <= 5; i++ {
+		log.add(SlowQuery{SQL: fmt.Sprint(i)})
+	}
+	got := log.snapshot()
+	if len(got) != 3 || got[0].SQL != "5" || got[1].SQL != "4" || got[2].SQL != "3" {
+		t.Errorf("expected newest three queries, got %+v", got)
+	}
+}
+
+func TestObserveQuery(t *testing.T) {
+	h := &HTMLFromDuckDB{
+		slowAfter: 100 * time.Millisecond,
+		slowLog:   newSlowQueryLog(slowQueryLogSize),
+		logger:    zap.NewNop(),
+	}
+	h.observeQuery(context.Background(), "record", "SELECT 1", 10*time.Millisecond)
+	h.observeQuery(context.Background(), "index", "SELECT 2", 150*time.Millisecond)
+
+	got := h.slowLog.snapshot()
+	if len(got) != 1 || got[0].Endpoint != "index" || got[0].DurationMs != 150 {
+		t.Errorf("unexpected slow log: %+v", got)
+	}
+
+	h.slowAfter = 0
+	h.observeQuery(context.Background(), "index", "SELECT 3", time.Hour)
+	if len(h.slowLog.snapshot()) != 1 {
+		t.Error("threshold 0 should disable the slow query log")
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	tests := []struct {
+		h    HTMLFromDuckDB
+		want string
+	}{
+		{HTMLFromDuckDB{Table: "html"}, "html"},
+		{HTMLFromDuckDB{Table: "html", BasePath: "/works"}, "html@/works"},
+		{HTMLFromDuckDB{Name: "docs", Table: "html", BasePath: "/works"}, "docs"},
+	}
+	for _, tt := range tests {
+		if got := tt.h.instanceName(); got != tt.want {
+			t.Errorf("instanceName() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`
+		CREATE TABLE works (id VARCHAR, html VARCHAR);
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE SELECT 'index' AS html;
+	`); err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:         db,
+		Table:      "works",
+		BasePath:   "/works",
+		AuthTokens: []string{"secret"},
+		slowLog:    newSlowQueryLog(slowQueryLogSize),
+		logger:     zap.NewNop(),
+	}
+	h.slowLog.add(SlowQuery{Endpoint: "index", SQL: "SELECT 1", DurationMs: 1500})
+	registerInstance(h)
+	defer unregisterInstance(h)
+
+	api := &AdminAPI{}
+	get := func(t *testing.T, target string) (map[string]json.RawMessage, error) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := api.serveAdmin(rec, httptest.NewRequest("GET", target, nil)); err != nil {
+			return nil, err
+		}
+		var out map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, rec.Body.String())
+		}
+		return out, nil
+	}
+
+	t.Run("config redacts tokens", func(t *testing.T) {
+		out, err := get(t, "/duckdb/config?instance=works@/works")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var cfg HTMLFromDuckDB
+		if err := json.Unmarshal(out["works@/works"], &cfg); err != nil {
+			t.Fatalf("invalid config: %v", err)
+		}
+		if cfg.Table != "works" || len(cfg.AuthTokens) != 1 || cfg.AuthTokens[0] != "REDACTED" {
+			t.Errorf("unexpected config: %+v", cfg)
+		}
+		if h.AuthTokens[0] != "secret" {
+			t.Error("redaction modified the handler")
+		}
+	})
+
+	t.Run("pools", func(t *testing.T) {
+		out, err := get(t, "/duckdb/pools?instance=works@/works")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var stats PoolStats
+		if err := json.Unmarshal(out["works@/works"], &stats); err != nil {
+			t.Fatalf("invalid pool stats: %v", err)
+		}
+	})
+
+	t.Run("slow queries", func(t *testing.T) {
+		out, err := get(t, "/duckdb/slow_queries?instance=works@/works")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var queries []SlowQuery
+		if err := json.Unmarshal(out["works@/works"], &queries); err != nil {
+			t.Fatalf("invalid slow queries: %v", err)
+		}
+		if len(queries) != 1 || queries[0].DurationMs != 1500 {
+			t.Errorf("unexpected slow queries: %+v", queries)
+		}
+	})
+
+	t.Run("macros", func(t *testing.T) {
+		out, err := get(t, "/duckdb/macros?instance=works@/works")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var macros []MacroInfo
+		if err := json.Unmarshal(out["works@/works"], &macros); err != nil {
+			t.Fatalf("invalid macros: %v", err)
+		}
+		if len(macros) != 1 || macros[0].Name != "render_index" || macros[0].Type != "table_macro" || len(macros[0].Parameters) != 2 {
+			t.Errorf("unexpected macros: %+v", macros)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			target string
+			status int
+		}{
+			{"/duckdb/unknown", http.StatusNotFound},
+			{"/duckdb/config?instance=missing", http.StatusNotFound},
+		}
+		for _, tt := range tests {
+			_, err := get(t, tt.target)
+			var apiErr caddy.APIError
+			if !errors.As(err, &apiErr) || apiErr.HTTPStatus != tt.status {
+				t.Errorf("%s: expected %d, got %v", tt.target, tt.status, err)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		err := api.serveAdmin(rec, httptest.NewRequest("POST", "/duckdb/config", nil))
+		var apiErr caddy.APIError
+		if !errors.As(err, &apiErr) || apiErr.HTTPStatus != http.StatusMethodNotAllowed {
+			t.Errorf("POST: expected 405, got %v", err)
+		}
+	})
+}
+
+func TestProvision_DuplicateInstanceName(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := writeRecordsDB(t, map[string]string{"1": "<p>One</p>"})
+	first := &HTMLFromDuckDB{DatabasePath: path, Table: "html"}
+	if err := first.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer first.Cleanup()
+
+	// Within a config, names must be unique, derived ones included
+	for _, h := range []*HTMLFromDuckDB{
+		{DatabasePath: path, Table: "html"},
+		{DatabasePath: path, Table: "other", Name: "html"},
+	} {
+		if err := h.Provision(ctx); err == nil || !strings.Contains(err.Error(), `another html_from_duckdb handler is named "html"`) {
+			t.Errorf("error = %v", err)
+			if err == nil {
+				h.Cleanup()
+			}
+		}
+	}
+	named := &HTMLFromDuckDB{DatabasePath: path, Table: "html", Name: "second"}
+	if err := named.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	named.Cleanup()
+
+	// The next config, provisioned on a reload while this one still runs,
+	// may reuse the name.
+	reloadCtx, cancelReload := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancelReload()
+	reloaded := &HTMLFromDuckDB{DatabasePath: path, Table: "html"}
+	if err := reloaded.Provision(reloadCtx); err != nil {
+		t.Fatalf("Provision reloaded: %v", err)
+	}
+	reloaded.Cleanup()
+}
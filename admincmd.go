@@ -0,0 +1,113 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "duckdb",
+		Usage: "<config|pools|slow-queries|macros|cache|purge|swap> [--instance <name>] [--tag <tag>] [--address <interface>]",
+		Short: "Inspects and manages the html_from_duckdb handlers of a running Caddy",
+		Long: `
+Calls the /duckdb/ routes of a running Caddy's admin API and prints the
+JSON response, keyed by handler instance name:
+
+	config        handler configuration, with secrets redacted
+	pools         connection pool statistics and settings
+	slow-queries  recent queries slower than slow_query_threshold
+	macros        macros defined in each handler's database
+	cache         response cache statistics
+	purge         drops cached pages with any of the --tag cache tags
+	swap          syncs, reloads or refreshes the --instance handler's
+	              database now instead of at the next interval
+
+--instance limits the command to the handler with that name (its name
+option, or table[@base_path]). The admin API address is taken from
+--address, or the config given with --config, or the default.`,
+		CobraFunc: configureDuckDBCommand,
+	})
+}
+
+// configureDuckDBCommand sets up the duckdb command's arguments and flags.
+func configureDuckDBCommand(cmd *cobra.Command) {
+	cmd.Args = cobra.ExactArgs(1)
+	cmd.Flags().StringP("instance", "i", "", "Name of the handler instance")
+	cmd.Flags().StringSliceP("tag", "t", nil, "Cache tag to purge (may be repeated)")
+	cmd.Flags().StringP("config", "c", "", "Configuration file to read the admin address from")
+	cmd.Flags().StringP("adapter", "a", "", "Name of config adapter to apply")
+	cmd.Flags().String("address", "", "Address of the administration API listener, if different from config")
+	cmd.RunE = caddycmd.WrapCommandFuncForCobra(cmdDuckDB)
+}
+
+// cmdDuckDB runs the duckdb command, printing the admin API's response.
+func cmdDuckDB(fl caddycmd.Flags) (int, error) {
+	if err := duckdbAdminRequest(fl, os.Stdout); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// duckdbAdminRequest sends the admin API request for the command's action
+// and writes the indented JSON response to w.
+func duckdbAdminRequest(fl caddycmd.Flags, w io.Writer) error {
+	action := fl.Arg(0)
+	method := http.MethodGet
+	query := url.Values{}
+	switch action {
+	case "config", "pools", "slow-queries", "macros", "cache":
+	case "purge":
+		method = http.MethodPost
+		tags, _ := fl.GetStringSlice("tag")
+		if len(tags) == 0 {
+			return fmt.Errorf("purge requires --tag")
+		}
+		query["tag"] = tags
+	case "swap":
+		method = http.MethodPost
+		if fl.String("instance") == "" {
+			return fmt.Errorf("swap requires --instance")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	if instance := fl.String("instance"); instance != "" {
+		query.Set("instance", instance)
+	}
+
+	adminAddr, err := caddycmd.DetermineAdminAPIAddress(fl.String("address"), nil, fl.String("config"), fl.String("adapter"))
+	if err != nil {
+		return fmt.Errorf("couldn't determine admin API address: %v", err)
+	}
+
+	uri := "/duckdb/" + strings.ReplaceAll(action, "-", "_")
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	resp, err := caddycmd.AdminAPIRequest(adminAddr, method, uri, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(w)
+	return err
+}
@@ -0,0 +1,116 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+func TestDuckDBCommand(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := writeRecordsDB(t, map[string]string{"1": "<p>One</p>"})
+	docs := &HTMLFromDuckDB{
+		Name:                 "docs",
+		DatabasePath:         path,
+		Table:                "html",
+		LoadIntoMemory:       true,
+		MemoryReloadInterval: "0",
+	}
+	if err := docs.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer docs.Cleanup()
+	plain := &HTMLFromDuckDB{Name: "plain", Table: "html"}
+	if err := plain.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer plain.Cleanup()
+
+	// The admin API, as Caddy's admin endpoint would serve it
+	api := &AdminAPI{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := api.serveAdmin(w, r); err != nil {
+			var apiErr caddy.APIError
+			errors.As(err, &apiErr)
+			http.Error(w, err.Error(), apiErr.HTTPStatus)
+		}
+	}))
+	defer srv.Close()
+
+	swapped := func(raw json.RawMessage) bool {
+		var res SwapResult
+		json.Unmarshal(raw, &res)
+		return res.Swapped
+	}
+
+	run := func(args ...string) (map[string]json.RawMessage, error) {
+		cmd := &cobra.Command{}
+		configureDuckDBCommand(cmd)
+		if err := cmd.ParseFlags(append(args, "--address", strings.TrimPrefix(srv.URL, "http://"))); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := duckdbAdminRequest(caddycmd.Flags{FlagSet: cmd.Flags()}, &out); err != nil {
+			return nil, err
+		}
+		var got map[string]json.RawMessage
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON %q: %v", out.String(), err)
+		}
+		return got, nil
+	}
+
+	got, err := run("slow-queries")
+	if err != nil || len(got) != 2 || got["docs"] == nil || got["plain"] == nil {
+		t.Errorf("slow-queries = %s, err = %v", got, err)
+	}
+	if got, err := run("config", "--instance", "plain"); err != nil || len(got) != 1 || got["plain"] == nil {
+		t.Errorf("config --instance plain = %s, err = %v", got, err)
+	}
+
+	// Nothing changed yet, so there is nothing to swap in
+	if got, err := run("swap", "--instance", "docs"); err != nil || got["docs"] == nil || swapped(got["docs"]) {
+		t.Errorf("swap = %s, err = %v", got, err)
+	}
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO html VALUES ('2', '<p>Two</p>')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if got, err := run("swap", "-i", "docs"); err != nil || !swapped(got["docs"]) {
+		t.Errorf("swap = %s, err = %v", got, err)
+	}
+	if html, err := docs.RenderRecord(context.Background(), "2"); err != nil || html != "<p>Two</p>" {
+		t.Errorf("after swap: %q, %v", html, err)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"swap"}, "swap requires --instance"},
+		{[]string{"swap", "--instance", "plain"}, "HTTP 400"},
+		{[]string{"swap", "--instance", "nope"}, "HTTP 404"},
+		{[]string{"purge"}, "purge requires --tag"},
+		{[]string{"vacuum"}, `unknown action "vacuum"`},
+	} {
+		if _, err := run(tc.args...); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: error = %v, want %q", tc.args, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// apiMediaType is the JSON:API media type used for API responses.
+const apiMediaType = "application/vnd.api+json"
+
+// apiResource is a JSON:API resource object for one row.
+type apiResource struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id"`
+	Attributes map[string]any `json:"attributes"`
+	Links      *apiLinks      `json:"links,omitempty"`
+}
+
+// apiLinks holds JSON:API links. Only self is set for single resources.
+type apiLinks struct {
+	Self  string `json:"self"`
+	First string `json:"first,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+}
+
+// apiDocument is a JSON:API top-level document.
+type apiDocument struct {
+	Data   any        `json:"data,omitempty"`
+	Links  *apiLinks  `json:"links,omitempty"`
+	Meta   *apiMeta   `json:"meta,omitempty"`
+	Errors []apiError `json:"errors,omitempty"`
+}
+
+// apiMeta holds pagination details for collection responses.
+type apiMeta struct {
+	Page     int `json:"page"`
+	PageSize int `json:"page_size"`
+}
+
+// apiError is a JSON:API error object.
+type apiError struct {
+	Status string `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// apiSelectColumns returns the select list for API queries. The ID column is
+// always selected first so every resource has an id.
+func (h *HTMLFromDuckDB) apiSelectColumns() string {
+	if len(h.APIColumns) == 0 {
+		return "*"
+	}
+	cols := []string{sanitizeIdentifier(h.IDColumn)}
+	for _, col := range h.APIColumns {
+		if col != h.IDColumn {
+			cols = append(cols, sanitizeIdentifier(col))
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
+// apiID returns the record ID from an API request path, or an empty string
+// for the collection. Behind an endpoint matcher the ID is whatever follows
+// the api_path segment.
+func (h *HTMLFromDuckDB) apiID(r *http.Request) string {
+	rest, ok := h.endpointRest(r, h.APIPath)
+	if !ok {
+		_, rest, _ = strings.Cut(r.URL.Path, "/"+h.APIPath+"/")
+	}
+	return strings.Trim(rest, "/")
+}
+
+// serveAPI serves records as JSON:API documents: a single record at
+// {api_path}/{id} and a paginated collection at {api_path}?page=N.
+func (h *HTMLFromDuckDB) serveAPI(w http.ResponseWriter, r *http.Request) error {
+	base := h.endpointPath(h.APIPath)
+	id := h.apiID(r)
+	requestInfoFrom(r.Context()).setID(id)
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	var doc apiDocument
+	if id != "" {
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+			h.apiSelectColumns(),
+			sanitizeIdentifier(h.Table),
+			sanitizeIdentifier(h.IDColumn))
+		if h.WhereClause != "" {
+			query += fmt.Sprintf(" AND (%s)", h.WhereClause)
+		}
+		if cond := h.goneCondition(); cond != "" {
+			query += " AND NOT " + cond
+		}
+
+		resources, err := h.queryAPIResources(ctx, query, id)
+		if err != nil {
+			h.logFailure(r.Context(), "api query failed", zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		if len(resources) == 0 {
+			if gone, err := h.isGone(ctx, id); err != nil {
+				h.log(r.Context()).Warn("gone check failed", zap.String("id", id), zap.Error(err))
+			} else if gone {
+				return writeAPIError(w, http.StatusGone, "Gone", fmt.Sprintf("record %q has been deleted", id))
+			}
+			return writeAPIError(w, http.StatusNotFound, "Not Found", fmt.Sprintf("no record with id %q", id))
+		}
+		doc.Data = resources[0]
+		doc.Links = &apiLinks{Self: base + "/" + url.PathEscape(id)}
+	} else {
+		pageNum := 1
+		if page := r.URL.Query().Get("page"); page != "" {
+			p, err := strconv.Atoi(page)
+			if err != nil || p < 1 {
+				return writeAPIError(w, http.StatusBadRequest, "Bad Request", "page must be a positive integer")
+			}
+			pageNum = p
+		}
+
+		// Fetch one extra row to know whether there is a next page without
+		// counting the whole table.
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE true", h.apiSelectColumns(), sanitizeIdentifier(h.Table))
+		if h.WhereClause != "" {
+			query += fmt.Sprintf(" AND (%s)", h.WhereClause)
+		}
+		if cond := h.goneCondition(); cond != "" {
+			query += " AND NOT " + cond
+		}
+		query += fmt.Sprintf(" ORDER BY %s LIMIT %d OFFSET %d",
+			sanitizeIdentifier(h.IDColumn),
+			h.APIPageSize+1,
+			(pageNum-1)*h.APIPageSize)
+
+		resources, err := h.queryAPIResources(ctx, query)
+		if err != nil {
+			h.logFailure(r.Context(), "api query failed", zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		if pageNum > 1 && len(resources) == 0 {
+			return writeAPIError(w, http.StatusNotFound, "Not Found", fmt.Sprintf("page %d is out of range", pageNum))
+		}
+
+		links := &apiLinks{
+			Self:  fmt.Sprintf("%s?page=%d", base, pageNum),
+			First: base + "?page=1",
+		}
+		if pageNum > 1 {
+			links.Prev = fmt.Sprintf("%s?page=%d", base, pageNum-1)
+		}
+		if len(resources) > h.APIPageSize {
+			resources = resources[:h.APIPageSize]
+			links.Next = fmt.Sprintf("%s?page=%d", base, pageNum+1)
+		}
+		for _, res := range resources {
+			res.Links = &apiLinks{Self: base + "/" + url.PathEscape(res.ID)}
+		}
+		if resources == nil {
+			resources = []*apiResource{}
+		}
+
+		doc.Data = resources
+		doc.Links = links
+		doc.Meta = &apiMeta{Page: pageNum, PageSize: h.APIPageSize}
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if len(h.transformers) > 0 {
+		if body, err = h.transform(r, apiMediaType, body); err != nil {
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+	}
+
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+	if notModified(w, r, generateETag(string(body))) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", apiMediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		h.logFailure(r.Context(), "failed to write response", zap.Error(err))
+		return err
+	}
+
+	h.log(r.Context()).Debug("served api response",
+		zap.String("id", id),
+		zap.Int("size", len(body)))
+
+	return nil
+}
+
+// queryAPIResources runs query and converts each row to a resource object.
+func (h *HTMLFromDuckDB) queryAPIResources(ctx context.Context, query string, args ...any) ([]*apiResource, error) {
+	start := time.Now()
+	defer func() { h.observeQuery(ctx, "api", query, time.Since(start)) }()
+
+	rows, err := h.queryRetry(ctx, "api", h.queries(ctx, "api"), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	var resources []*apiResource
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		res := &apiResource{
+			Type:       h.Table,
+			Attributes: make(map[string]any, len(cols)),
+		}
+		for i, col := range cols {
+			if col.Name() == h.IDColumn {
+				res.ID = fmt.Sprint(values[i])
+				continue
+			}
+			res.Attributes[col.Name()] = h.ValueEncoding.jsonValue(values[i], col.DatabaseTypeName())
+		}
+		resources = append(resources, res)
+	}
+	return resources, rows.Err()
+}
+
+// jsonFloat returns nil for values JSON can't represent (NaN, ±Inf).
+func jsonFloat(f float64) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
+
+// writeAPIError writes a JSON:API error document.
+func writeAPIError(w http.ResponseWriter, status int, title, detail string) error {
+	body, err := json.Marshal(apiDocument{Errors: []apiError{{
+		Status: strconv.Itoa(status),
+		Title:  title,
+		Detail: detail,
+	}}})
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	w.Header().Set("Content-Type", apiMediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+	return err
+}
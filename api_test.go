@@ -0,0 +1,155 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_API(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, title VARCHAR, price DECIMAL(10, 2), uid UUID);
+		INSERT INTO html VALUES
+			('a', '<p>A</p>', 'First', 12.50, '6ba7b810-9dad-11d1-80b4-00c04fd430c8'),
+			('b', '<p>B</p>', 'Second', 3.00, NULL),
+			('c', '<p>C</p>', 'Third', NULL, NULL);
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up table: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		Table:       "html",
+		HTMLColumn:  "html",
+		IDColumn:    "id",
+		APIPath:     "api",
+		APIPageSize: 2,
+		db:          db,
+		logger:      zap.NewNop(),
+	}
+
+	get := func(t *testing.T, h *HTMLFromDuckDB, target string) (*httptest.ResponseRecorder, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		var doc map[string]any
+		if rec.Code != http.StatusNotModified {
+			if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+			}
+		}
+		return rec, doc
+	}
+
+	t.Run("single record", func(t *testing.T) {
+		rec, doc := get(t, handler, "/api/a")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != apiMediaType {
+			t.Errorf("Content-Type = %q, want %q", ct, apiMediaType)
+		}
+
+		data := doc["data"].(map[string]any)
+		if data["id"] != "a" || data["type"] != "html" {
+			t.Errorf("unexpected resource identity: %v", data)
+		}
+		attrs := data["attributes"].(map[string]any)
+		if attrs["title"] != "First" {
+			t.Errorf("title = %v, want First", attrs["title"])
+		}
+		if !strings.Contains(rec.Body.String(), `"price":12.5`) {
+			t.Errorf("decimal should encode as a number, got %s", rec.Body.String())
+		}
+		if attrs["uid"] != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+			t.Errorf("uid = %v, want UUID string", attrs["uid"])
+		}
+		if _, ok := attrs["id"]; ok {
+			t.Error("id column should not be repeated in attributes")
+		}
+	})
+
+	t.Run("missing record is a JSON 404", func(t *testing.T) {
+		rec, doc := get(t, handler, "/api/zzz")
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+		errs, ok := doc["errors"].([]any)
+		if !ok || len(errs) != 1 || errs[0].(map[string]any)["status"] != "404" {
+			t.Errorf("expected a JSON:API error object, got %v", doc)
+		}
+	})
+
+	t.Run("paginated collection", func(t *testing.T) {
+		_, doc := get(t, handler, "/api")
+		if n := len(doc["data"].([]any)); n != 2 {
+			t.Errorf("page 1 has %d records, want 2", n)
+		}
+		links := doc["links"].(map[string]any)
+		if links["next"] != "/api?page=2" {
+			t.Errorf("next = %v, want /api?page=2", links["next"])
+		}
+		if _, ok := links["prev"]; ok {
+			t.Error("first page should have no prev link")
+		}
+
+		_, doc = get(t, handler, "/api?page=2")
+		data := doc["data"].([]any)
+		if len(data) != 1 || data[0].(map[string]any)["id"] != "c" {
+			t.Errorf("page 2 = %v, want only record c", data)
+		}
+		links = doc["links"].(map[string]any)
+		if links["prev"] != "/api?page=1" {
+			t.Errorf("prev = %v, want /api?page=1", links["prev"])
+		}
+		if _, ok := links["next"]; ok {
+			t.Error("last page should have no next link")
+		}
+	})
+
+	t.Run("page out of range and invalid page", func(t *testing.T) {
+		if rec, _ := get(t, handler, "/api?page=9"); rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+		if rec, _ := get(t, handler, "/api?page=x"); rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("column projection", func(t *testing.T) {
+		projected := *handler
+		projected.APIColumns = []string{"title"}
+		_, doc := get(t, &projected, "/api/b")
+		attrs := doc["data"].(map[string]any)["attributes"].(map[string]any)
+		if len(attrs) != 1 || attrs["title"] != "Second" {
+			t.Errorf("attributes = %v, want only title", attrs)
+		}
+	})
+
+	t.Run("conditional request", func(t *testing.T) {
+		rec, _ := get(t, handler, "/api/a")
+		req := httptest.NewRequest(http.MethodGet, "/api/a", nil)
+		req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+		rec2 := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec2, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		if rec2.Code != http.StatusNotModified {
+			t.Errorf("status = %d, want %d", rec2.Code, http.StatusNotModified)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Scopes of the endpoints protected by auth_tokens and api_keys_table.
+// Keys with the scope "*" may call all of them.
+const (
+	scopeQuery   = "query"
+	scopeExport  = "export"
+	scopeExplain = "explain"
+	scopeHealth  = "health"
+)
+
+// apiKeyCacheTTL is how long API key lookups are cached, so a key added to
+// or removed from api_keys_table takes effect within that time.
+const apiKeyCacheTTL = time.Minute
+
+// apiKeyCacheSize is the number of API keys cached per handler.
+const apiKeyCacheSize = 1024
+
+// apiKeyMissCacheSize is the number of unknown tokens cached per handler.
+// Misses have their own cache, so a client sending random tokens can't
+// evict valid keys.
+const apiKeyMissCacheSize = 128
+
+// keyGrant is a cached api_keys_table lookup.
+type keyGrant struct {
+	valid   bool
+	scopes  []string
+	expires time.Time
+
+	// perMinute and perDay override the quota's limits for the key when
+	// valid.
+	perMinute, perDay sql.NullInt64
+}
+
+// usable reports whether the grant is for a key that exists and hasn't
+// expired at now.
+func (g keyGrant) usable(now time.Time) bool {
+	return g.valid && (g.expires.IsZero() || now.Before(g.expires))
+}
+
+// allows reports whether the grant lets its key call an endpoint with scope
+// at now.
+func (g keyGrant) allows(scope string, now time.Time) bool {
+	if !g.usable(now) {
+		return false
+	}
+	return slices.Contains(g.scopes, scope) || slices.Contains(g.scopes, "*")
+}
+
+// hasAuth reports whether any bearer tokens can be accepted, from
+// auth_tokens or api_keys_table.
+func (h *HTMLFromDuckDB) hasAuth() bool {
+	return len(h.AuthTokens) > 0 || h.APIKeysTable != ""
+}
+
+// keyAuthorized reports whether token is a key in api_keys_table that may
+// call endpoints with scope. Lookup errors deny access.
+func (h *HTMLFromDuckDB) keyAuthorized(ctx context.Context, token, scope string) bool {
+	if h.APIKeysTable == "" {
+		return false
+	}
+	_, grant, err := h.keyGrant(ctx, token)
+	if err != nil {
+		h.logFailure(ctx, "api key lookup failed", zap.Error(err))
+		return false
+	}
+	return grant.allows(scope, time.Now())
+}
+
+// keyGrant returns the hash of token and its grant from api_keys_table.
+// Keys are stored as hex SHA-256 hashes, and lookups are cached for a
+// minute, keys and unknown tokens separately.
+func (h *HTMLFromDuckDB) keyGrant(ctx context.Context, token string) (string, keyGrant, error) {
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+	if h.apiKeys != nil {
+		if grant, ok := h.apiKeys.get(hash); ok {
+			return hash, grant, nil
+		}
+	}
+	if h.apiKeyMisses != nil {
+		if _, ok := h.apiKeyMisses.get(hash); ok {
+			return hash, keyGrant{}, nil
+		}
+	}
+	grant, err := h.lookupKeyGrant(ctx, hash)
+	if err != nil {
+		return hash, keyGrant{}, err
+	}
+	switch {
+	case grant.valid && h.apiKeys != nil:
+		h.apiKeys.add(hash, grant)
+	case !grant.valid && h.apiKeyMisses != nil:
+		h.apiKeyMisses.add(hash, struct{}{})
+	}
+	return hash, grant, nil
+}
+
+// lookupKeyGrant reads the scopes, expiry and quota limits of the key with
+// the given hash from api_keys_table. The per_minute and per_day columns
+// are optional, so they are read from the row as JSON, where a missing
+// column is NULL.
+func (h *HTMLFromDuckDB) lookupKeyGrant(ctx context.Context, hash string) (keyGrant, error) {
+	query := fmt.Sprintf(`SELECT scopes, expires_at,
+		TRY_CAST(to_json(k)->>'per_minute' AS BIGINT),
+		TRY_CAST(to_json(k)->>'per_day' AS BIGINT)
+		FROM %s k WHERE key_hash = ?`,
+		sanitizeQualifiedIdentifier(h.APIKeysTable))
+	ctx, cancel := h.queryContext(ctx)
+	defer cancel()
+	var scopes any
+	var expires sql.NullTime
+	grant := keyGrant{valid: true}
+	start := time.Now()
+	err := h.database().QueryRowContext(ctx, tagQuery(ctx, query), hash).Scan(&scopes, &expires, &grant.perMinute, &grant.perDay)
+	h.observeQuery(ctx, "api_key", query, time.Since(start))
+	if err == sql.ErrNoRows {
+		return keyGrant{}, nil
+	}
+	if err != nil {
+		return keyGrant{}, err
+	}
+	grant.expires = expires.Time
+	grant.scopes, err = parseList(scopes, "scopes")
+	if err != nil {
+		return keyGrant{}, err
+	}
+	return grant, nil
+}
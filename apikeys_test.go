@@ -0,0 +1,140 @@
+package caddyhtmlduckdb
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestKeyGrantAllows(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		grant keyGrant
+		want  bool
+	}{
+		{"unknown key", keyGrant{}, false},
+		{"scope", keyGrant{valid: true, scopes: []string{"export", "query"}}, true},
+		{"other scope", keyGrant{valid: true, scopes: []string{"export"}}, false},
+		{"all scopes", keyGrant{valid: true, scopes: []string{"*"}}, true},
+		{"not expired", keyGrant{valid: true, scopes: []string{"query"}, expires: now.Add(time.Second)}, true},
+		{"expired", keyGrant{valid: true, scopes: []string{"query"}, expires: now}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.grant.allows(scopeQuery, now); got != tt.want {
+			t.Errorf("%s: allows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServeHTTP_APIKeys(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		CREATE SCHEMA auth;
+		CREATE TABLE auth.api_keys (key_hash VARCHAR, scopes VARCHAR[], expires_at TIMESTAMP);
+		INSERT INTO auth.api_keys VALUES
+			(sha256('analyst'), ['query'], NULL),
+			(sha256('replica'), ['export'], NULL),
+			(sha256('admin'), ['*'], NULL),
+			(sha256('former'), ['query'], TIMESTAMP '2000-01-01');
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:           db,
+		Table:        "html",
+		HTMLColumn:   "html",
+		IDColumn:     "id",
+		QueryPath:    "_query",
+		APIKeysTable: "auth.api_keys",
+		apiKeys:      newLRUCache[keyGrant](apiKeyCacheSize, apiKeyCacheTTL),
+		apiKeyMisses: newLRUCache[struct{}](apiKeyMissCacheSize, apiKeyCacheTTL),
+		logger:       zap.NewNop(),
+	}
+	query := func(t *testing.T, token string) int {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/_query?sql=SELECT+1", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		return rec.Code
+	}
+
+	for token, want := range map[string]int{
+		"analyst": http.StatusOK,
+		"admin":   http.StatusOK,
+		"replica": http.StatusUnauthorized,
+		"former":  http.StatusUnauthorized,
+		"unknown": http.StatusUnauthorized,
+	} {
+		if got := query(t, token); got != want {
+			t.Errorf("%s: status = %d, want %d", token, got, want)
+		}
+	}
+
+	t.Run("lookups are cached", func(t *testing.T) {
+		if _, err := db.Exec(`DELETE FROM auth.api_keys WHERE key_hash = sha256('analyst')`); err != nil {
+			t.Fatal(err)
+		}
+		if got := query(t, "analyst"); got != http.StatusOK {
+			t.Errorf("status = %d, want cached key to be accepted", got)
+		}
+		sum := sha256.Sum256([]byte("analyst"))
+		h.apiKeys.remove(hex.EncodeToString(sum[:]))
+		if got := query(t, "analyst"); got != http.StatusUnauthorized {
+			t.Errorf("status = %d, want removed key to be refused", got)
+		}
+	})
+
+	t.Run("misses don't evict keys", func(t *testing.T) {
+		keys := h.apiKeys.stats().Entries
+		for i := range apiKeyMissCacheSize + 1 {
+			query(t, fmt.Sprintf("random-%d", i))
+		}
+		if got := h.apiKeys.stats().Entries; got != keys {
+			t.Errorf("key cache entries = %d, want %d", got, keys)
+		}
+		if got := h.apiKeyMisses.stats().Entries; got != apiKeyMissCacheSize {
+			t.Errorf("miss cache entries = %d, want %d", got, apiKeyMissCacheSize)
+		}
+	})
+
+	t.Run("lookup errors deny", func(t *testing.T) {
+		h.APIKeysTable = "missing_table"
+		defer func() { h.APIKeysTable = "auth.api_keys" }()
+		if got := query(t, "not-cached"); got != http.StatusUnauthorized {
+			t.Errorf("status = %d", got)
+		}
+	})
+}
+
+func TestParseAPIKeysTable(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		query_path _query
+		api_keys_table api_keys
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.APIKeysTable != "api_keys" || !h.hasAuth() {
+		t.Errorf("APIKeysTable = %q", h.APIKeysTable)
+	}
+}
@@ -0,0 +1,101 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Audited administrative actions.
+const (
+	auditCachePurge   = "cache_purge"
+	auditDatabaseSwap = "database_swap"
+	auditInitSQL      = "init_sql"
+)
+
+// Audit outcomes.
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+// auditTimeout bounds an audit table write outside a request.
+const auditTimeout = 5 * time.Second
+
+// provisionAudit creates the audit table if it doesn't exist.
+func (h *HTMLFromDuckDB) provisionAudit(ctx context.Context) error {
+	if h.AuditTable == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, auditTimeout)
+	defer cancel()
+	_, err := h.database().ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		time TIMESTAMPTZ NOT NULL,
+		instance VARCHAR NOT NULL,
+		action VARCHAR NOT NULL,
+		actor VARCHAR,
+		outcome VARCHAR NOT NULL,
+		error VARCHAR,
+		details JSON
+	)`, sanitizeQualifiedIdentifier(h.AuditTable)))
+	if err != nil {
+		return fmt.Errorf("failed to create audit_table: %v", err)
+	}
+	return nil
+}
+
+// audit records an administrative action by actor in the log and, with
+// audit_table, in the database. err is the action's failure, if any.
+// Audit table writes that fail are logged but don't affect the action.
+func (h *HTMLFromDuckDB) audit(ctx context.Context, action, actor string, err error, details map[string]any) {
+	outcome, errText := auditSuccess, ""
+	if err != nil {
+		outcome, errText = auditFailure, err.Error()
+	}
+	fields := []zap.Field{
+		zap.String("action", action),
+		zap.String("actor", actor),
+		zap.String("outcome", outcome),
+		zap.Any("details", details),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	h.logger.Info("audit", fields...)
+
+	if h.AuditTable == "" {
+		return
+	}
+	var detailsJSON any
+	if len(details) > 0 {
+		b, jsonErr := json.Marshal(details)
+		if jsonErr != nil {
+			h.logger.Error("audit details not encodable", zap.String("action", action), zap.Error(jsonErr))
+		} else {
+			detailsJSON = string(b)
+		}
+	}
+	var errValue any
+	if errText != "" {
+		errValue = errText
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditTimeout)
+	defer cancel()
+	query := fmt.Sprintf("INSERT INTO %s VALUES (?, ?, ?, ?, ?, ?, ?)", sanitizeQualifiedIdentifier(h.AuditTable))
+	if _, execErr := h.database().ExecContext(ctx, query,
+		time.Now(), h.instanceName(), action, actor, outcome, errValue, detailsJSON); execErr != nil {
+		h.logger.Error("audit table write failed", zap.String("action", action), zap.Error(execErr))
+	}
+}
+
+// adminActor names the client of an admin API request for the audit trail.
+func adminActor(r *http.Request) string {
+	if r.RemoteAddr == "" {
+		return "admin"
+	}
+	return "admin " + r.RemoteAddr
+}
@@ -0,0 +1,89 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestAudit(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	h := &HTMLFromDuckDB{
+		db:         db,
+		Name:       "audit-test",
+		Table:      "html",
+		AuditTable: "audit_log",
+		logger:     zap.NewNop(),
+	}
+	if err := h.provisionAudit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Provisioning again keeps the existing table
+	if err := h.provisionAudit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("admin purge", func(t *testing.T) {
+		registerInstance(h)
+		defer unregisterInstance(h)
+		req := httptest.NewRequest(http.MethodPost, "/duckdb/purge?tag=nav", nil)
+		req.RemoteAddr = "127.0.0.1:4000"
+		if err := (&AdminAPI{}).serveAdmin(httptest.NewRecorder(), req); err != nil {
+			t.Fatalf("purge: %v", err)
+		}
+
+		var instance, action, actor, outcome, details string
+		var errText sql.NullString
+		err := db.QueryRow(`SELECT instance, action, actor, outcome, error, details::VARCHAR FROM audit_log`).
+			Scan(&instance, &action, &actor, &outcome, &errText, &details)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance != "audit-test" || action != auditCachePurge || actor != "admin 127.0.0.1:4000" ||
+			outcome != auditSuccess || errText.Valid || details != `{"purged":0,"tags":["nav"]}` {
+			t.Errorf("row = %q %q %q %q %v %q", instance, action, actor, outcome, errText, details)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		h.audit(context.Background(), auditDatabaseSwap, "sync", errors.New("invalid database"), nil)
+		var outcome, errText string
+		err := db.QueryRow(`SELECT outcome, error FROM audit_log WHERE action = 'database_swap'`).Scan(&outcome, &errText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if outcome != auditFailure || errText != "invalid database" {
+			t.Errorf("outcome = %q, error = %q", outcome, errText)
+		}
+	})
+
+	t.Run("write errors don't panic", func(t *testing.T) {
+		h.AuditTable = "missing.audit_log"
+		defer func() { h.AuditTable = "audit_log" }()
+		h.audit(context.Background(), auditCachePurge, "admin", nil, nil)
+	})
+}
+
+func TestParseAuditTable(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		audit_table audit.events
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.AuditTable != "audit.events" {
+		t.Errorf("AuditTable = %q", h.AuditTable)
+	}
+}
@@ -0,0 +1,106 @@
+package caddyhtmlduckdb
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// bearerToken returns the token from an "Authorization: Bearer <token>"
+// request header, or an empty string.
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authorized reports whether the request carries one of the configured
+// auth tokens, which may call every endpoint, or a key from api_keys_table
+// with the given scope. Tokens are compared in constant time. Without
+// configured tokens or keys no request is authorized, so protected endpoints
+// stay closed.
+func (h *HTMLFromDuckDB) authorized(r *http.Request, scope string) bool {
+	token := bearerToken(r)
+	if token == "" {
+		return false
+	}
+	return h.isAuthToken(token) || h.keyAuthorized(r.Context(), token, scope)
+}
+
+// isAuthToken reports whether token is one of the auth_tokens, comparing
+// in constant time.
+func (h *HTMLFromDuckDB) isAuthToken(token string) bool {
+	ok := false
+	for _, want := range h.AuthTokens {
+		if want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// unauthorized writes a 401 response asking for a bearer token.
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="html_from_duckdb"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// clientAllowed reports whether the client IP is in one of ranges. The IP
+// is the one Caddy determined, which honors trusted_proxies.
+func (h *HTMLFromDuckDB) clientAllowed(r *http.Request, ranges []netip.Prefix) bool {
+	if len(ranges) == 0 {
+		return false
+	}
+	ip, err := netip.ParseAddr(clientAddr(r))
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range ranges {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the client IP Caddy determined for r, which honors
+// trusted_proxies, falling back to the peer address.
+func clientAddr(r *http.Request) string {
+	address, _ := caddyhttp.GetVar(r.Context(), caddyhttp.ClientIPVarKey).(string)
+	if address == "" {
+		address = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		}
+	}
+	return address
+}
+
+// parseIPRanges parses IP addresses and CIDR ranges.
+func parseIPRanges(values []string) ([]netip.Prefix, error) {
+	var ranges []netip.Prefix
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, prefix.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address: %q", value)
+		}
+		ranges = append(ranges, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	return ranges, nil
+}
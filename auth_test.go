@@ -0,0 +1,179 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestParseIPRanges(t *testing.T) {
+	ranges, err := parseIPRanges([]string{"10.0.0.0/8", "192.168.1.7", "::1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("parseIPRanges: %v", err)
+	}
+	if len(ranges) != 4 || ranges[1].String() != "192.168.1.7/32" || ranges[2].String() != "::1/128" {
+		t.Errorf("ranges = %v", ranges)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "localhost", ""} {
+		if _, err := parseIPRanges([]string{bad}); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestClientAllowed(t *testing.T) {
+	ranges, _ := parseIPRanges([]string{"10.0.0.0/8", "192.168.1.7"})
+	h := &HTMLFromDuckDB{}
+	tests := []struct {
+		remoteAddr string
+		clientIP   string
+		want       bool
+	}{
+		{"10.1.2.3:5000", "", true},
+		{"192.168.1.7:5000", "", true},
+		{"192.168.1.8:5000", "", false},
+		{"[::ffff:10.0.0.1]:5000", "", true},
+		// Caddy's client IP (after trusted_proxies) wins over the peer
+		{"10.1.2.3:5000", "203.0.113.9", false},
+		{"203.0.113.9:5000", "10.9.9.9", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/_health", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.clientIP != "" {
+			ctx := context.WithValue(r.Context(), caddyhttp.VarsCtxKey, map[string]any{caddyhttp.ClientIPVarKey: tt.clientIP})
+			r = r.WithContext(ctx)
+		}
+		if got := h.clientAllowed(r, ranges); got != tt.want {
+			t.Errorf("clientAllowed(%s, %q) = %v, want %v", tt.remoteAddr, tt.clientIP, got, tt.want)
+		}
+	}
+}
+
+func TestServeHTTP_HealthAuth(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR)`); err != nil {
+		t.Fatal(err)
+	}
+
+	allow, _ := parseIPRanges([]string{"10.0.0.0/8"})
+	newHandler := func(auth, redact bool) *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{
+			db:                 db,
+			Table:              "html",
+			HealthEnabled:      true,
+			HealthPath:         "_health",
+			HealthDetailed:     true,
+			IndexEnabled:       true,
+			IndexMacro:         "missing_index",
+			AuthTokens:         []string{"secret"},
+			HealthAuth:         auth,
+			HealthRedactErrors: redact,
+			healthAllow:        allow,
+			logger:             zap.NewNop(),
+		}
+	}
+	get := func(t *testing.T, h *HTMLFromDuckDB, remoteAddr, token string) (int, map[string]any) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/_health", nil)
+		r.RemoteAddr = remoteAddr
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		var body map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	t.Run("auth", func(t *testing.T) {
+		h := newHandler(true, false)
+		tests := []struct {
+			name       string
+			remoteAddr string
+			token      string
+			status     int
+		}{
+			{"no token", "203.0.113.9:5000", "", http.StatusUnauthorized},
+			{"wrong token", "203.0.113.9:5000", "nope", http.StatusUnauthorized},
+			{"token", "203.0.113.9:5000", "secret", http.StatusServiceUnavailable},
+			{"allowed IP", "10.0.0.5:5000", "", http.StatusServiceUnavailable},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if status, _ := get(t, h, tt.remoteAddr, tt.token); status != tt.status {
+					t.Errorf("status = %d, want %d", status, tt.status)
+				}
+			})
+		}
+	})
+
+	t.Run("redact errors", func(t *testing.T) {
+		h := newHandler(false, true)
+		status, body := get(t, h, "203.0.113.9:5000", "")
+		if status != http.StatusServiceUnavailable || body["status"] != "unhealthy" {
+			t.Fatalf("status = %d, body = %v", status, body)
+		}
+		if _, ok := body["pool"]; ok {
+			t.Error("pool stats should be redacted")
+		}
+		check := body["checks"].(map[string]any)["index_macro"].(map[string]any)
+		if check["code"] != "macro_not_found" || check["error"] != nil || check["name"] != nil {
+			t.Errorf("index_macro = %v", check)
+		}
+
+		// Trusted callers still get the details
+		_, body = get(t, h, "203.0.113.9:5000", "secret")
+		check = body["checks"].(map[string]any)["index_macro"].(map[string]any)
+		if check["error"] != "macro not found" || check["name"] != "missing_index" || body["pool"] == nil {
+			t.Errorf("index_macro = %v", check)
+		}
+	})
+}
+
+func TestProvision_HealthAuthErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"no tokens or ranges": {Table: "html", HealthAuth: true},
+		"bad range":           {Table: "html", HealthAllow: []string{"10.0.0.0/99"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestParseHealthAuth(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		health_auth true
+		health_allow 10.0.0.0/8 127.0.0.1
+		health_redact_errors true
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if !h.HealthAuth || !h.HealthRedactErrors || len(h.HealthAllow) != 2 || h.HealthAllow[1] != "127.0.0.1" {
+		t.Errorf("HealthAuth = %v, HealthRedactErrors = %v, HealthAllow = %v", h.HealthAuth, h.HealthRedactErrors, h.HealthAllow)
+	}
+}
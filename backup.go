@@ -0,0 +1,349 @@
+package caddyhtmlduckdb
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// backupShutdownTimeout bounds the backup taken when the handler shuts down.
+const backupShutdownTimeout = 5 * time.Minute
+
+// Backup copies the database to another location on a schedule and when the
+// handler shuts down. Each backup is a consistent snapshot named
+// <database name>-<UTC timestamp>.
+type Backup struct {
+	// Destination is a local directory, an http(s) URL that backups are PUT
+	// under, or (with format export) a path DuckDB can write to, such as
+	// s3://bucket/prefix using the secrets set up by init_sql_file.
+	Destination string `json:"destination"`
+
+	// Interval is how often a backup is taken; "0" only backs up on
+	// shutdown.
+	// Default: "1h"
+	Interval string `json:"interval,omitempty"`
+
+	// OnShutdown takes a backup when the last handler using the database
+	// shuts down.
+	// Default: true
+	OnShutdown *bool `json:"on_shutdown,omitempty"`
+
+	// Format is "duckdb" for a DuckDB file or "export" for EXPORT DATABASE
+	// output in Parquet (zipped for http(s) destinations).
+	// Default: "duckdb"
+	Format string `json:"format,omitempty"`
+
+	// Token is sent as a bearer token with http(s) uploads. Optional.
+	Token string `json:"token,omitempty"`
+}
+
+// backups is the backup state of a handler.
+type backups struct {
+	config   *Backup
+	interval time.Duration
+	stop     context.CancelFunc
+	done     chan struct{}
+
+	// mu serializes backups.
+	mu sync.Mutex
+}
+
+// provisionBackup validates the backup configuration and starts the
+// periodic backups.
+func (h *HTMLFromDuckDB) provisionBackup(ctx context.Context) error {
+	c := h.Backup
+	if c.Destination == "" {
+		return fmt.Errorf("backup requires a destination")
+	}
+	if c.Interval == "" {
+		c.Interval = "1h"
+	}
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil || interval < 0 {
+		return fmt.Errorf("invalid backup interval: %q", c.Interval)
+	}
+	if c.Format == "" {
+		c.Format = exportDuckDB
+	}
+	switch c.Format {
+	case exportDuckDB:
+		if !isHTTPURL(c.Destination) && strings.Contains(c.Destination, "://") {
+			return fmt.Errorf("backup format duckdb needs a local or http(s) destination, use format export for %q", c.Destination)
+		}
+	case exportArchive:
+	default:
+		return fmt.Errorf("unsupported backup format: %q", c.Format)
+	}
+
+	b := &backups{config: c, interval: interval, done: make(chan struct{})}
+	h.backups = b
+	if interval == 0 {
+		close(b.done)
+		return nil
+	}
+	backupCtx, stop := context.WithCancel(ctx)
+	b.stop = stop
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-backupCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := h.runBackup(backupCtx); err != nil && backupCtx.Err() == nil {
+				h.logger.Error("backup failed", zap.String("destination", c.Destination), zap.Error(err))
+			}
+		}
+	}()
+	return nil
+}
+
+// stopBackups stops the periodic backups and, if this handler is the last
+// one using the database, takes the shutdown backup.
+func (h *HTMLFromDuckDB) stopBackups() {
+	b := h.backups
+	if b.stop != nil {
+		b.stop()
+	}
+	<-b.done
+	if b.config.OnShutdown != nil && !*b.config.OnShutdown {
+		return
+	}
+	// On a reload the new config keeps using the pool; only back up when it
+	// is about to be closed.
+	if h.pool == nil {
+		return
+	}
+	if refs, ok := pools.References(h.pool.key); !ok || refs > 1 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backupShutdownTimeout)
+	defer cancel()
+	if _, err := h.runBackup(ctx); err != nil {
+		h.logger.Error("shutdown backup failed", zap.String("destination", b.config.Destination), zap.Error(err))
+	}
+}
+
+// runBackup checkpoints a writable database and stores a snapshot at the
+// backup destination. It returns where the backup was written.
+func (h *HTMLFromDuckDB) runBackup(ctx context.Context) (string, error) {
+	b := h.backups
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.config
+	start := time.Now()
+
+	// Fold the WAL into the database file, so the local copy is as current
+	// as the backup. The snapshot is consistent either way, so a checkpoint
+	// that can't run right now (a transaction is open) is not an error.
+	if !*h.ReadOnly {
+		if _, err := h.database().ExecContext(ctx, "CHECKPOINT"); err != nil {
+			h.logger.Debug("checkpoint before backup skipped", zap.Error(err))
+		}
+	}
+
+	name := h.exportName() + "-" + start.UTC().Format("20060102T150405Z")
+	var location string
+	var err error
+	switch {
+	case isHTTPURL(c.Destination):
+		location, err = h.uploadBackup(ctx, name)
+	case c.Format == exportArchive:
+		location = strings.TrimSuffix(c.Destination, "/") + "/" + name
+		stmt := fmt.Sprintf("EXPORT DATABASE '%s' (FORMAT parquet)", escapeSQLString(location))
+		_, err = h.database().ExecContext(ctx, stmt)
+	default:
+		location, err = h.copyBackup(ctx, name)
+	}
+	if err != nil {
+		return "", err
+	}
+	h.logger.Info("database backed up",
+		zap.String("destination", location),
+		zap.Duration("duration", time.Since(start)))
+	return location, nil
+}
+
+// copyBackup writes a DuckDB snapshot to the local destination directory.
+func (h *HTMLFromDuckDB) copyBackup(ctx context.Context, name string) (string, error) {
+	dir := h.backups.config.Destination
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name+".duckdb")
+	tmpPath := filepath.Join(dir, "."+name+".duckdb.tmp")
+	defer os.Remove(tmpPath)
+	if err := h.exportDatabase(ctx, tmpPath); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// uploadBackup writes a snapshot to a temporary directory and PUTs it to
+// <destination>/<file>.
+func (h *HTMLFromDuckDB) uploadBackup(ctx context.Context, name string) (string, error) {
+	c := h.backups.config
+	dir, err := os.MkdirTemp(h.TempDirectory, "html_from_duckdb-backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	var path string
+	if c.Format == exportArchive {
+		exportDir := filepath.Join(dir, name)
+		stmt := fmt.Sprintf("EXPORT DATABASE '%s' (FORMAT parquet)", escapeSQLString(exportDir))
+		if _, err := h.database().ExecContext(ctx, stmt); err != nil {
+			return "", err
+		}
+		path = filepath.Join(dir, name+".zip")
+		if err := zipDirectory(exportDir, path); err != nil {
+			return "", err
+		}
+	} else {
+		path = filepath.Join(dir, name+".duckdb")
+		if err := h.exportDatabase(ctx, path); err != nil {
+			return "", err
+		}
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	location := strings.TrimSuffix(c.Destination, "/") + "/" + filepath.Base(path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, location, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = info.Size()
+	digest, _ := hex.DecodeString(sum)
+	req.Header.Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest)+":")
+	if c.Format == exportArchive {
+		req.Header.Set("Content-Type", "application/zip")
+	} else {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("upload failed: status %d", resp.StatusCode)
+	}
+	return location, nil
+}
+
+// zipDirectory writes the regular files in dir to a zip file at path.
+func zipDirectory(dir, path string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := addZipFile(zw, filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// isHTTPURL reports whether s is an http or https URL.
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// parseBackup parses a backup directive:
+//
+//	backup <destination> {
+//	    interval <duration>
+//	    on_shutdown <bool>
+//	    format duckdb|export
+//	    token <token>
+//	}
+func parseBackup(d *caddyfile.Dispenser) (*Backup, error) {
+	b := &Backup{}
+	if d.NextArg() {
+		b.Destination = d.Val()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "destination":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			b.Destination = d.Val()
+
+		case "interval":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			b.Interval = d.Val()
+
+		case "on_shutdown":
+			var onShutdown bool
+			if err := parseBoolArg(d, &onShutdown); err != nil {
+				return nil, err
+			}
+			b.OnShutdown = &onShutdown
+
+		case "format":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			b.Format = d.Val()
+
+		case "token":
+			if d.NextArg() {
+				b.Token = d.Val()
+			}
+			// No error if empty - allows {$BACKUP_TOKEN:} with empty default
+
+		default:
+			return nil, d.Errf("unrecognized backup subdirective: %s", d.Val())
+		}
+	}
+	return b, nil
+}
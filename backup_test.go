@@ -0,0 +1,266 @@
+package caddyhtmlduckdb
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// newBackupHandler provisions a writable handler on a new database with a
+// backup block.
+func newBackupHandler(t *testing.T, ctx caddy.Context, backup *Backup) *HTMLFromDuckDB {
+	t.Helper()
+	readOnly := false
+	h := &HTMLFromDuckDB{
+		DatabasePath: filepath.Join(t.TempDir(), "works.duckdb"),
+		Table:        "html",
+		ReadOnly:     &readOnly,
+		InitSQLFile:  writeInitSQL(t, "CREATE TABLE IF NOT EXISTS html (id VARCHAR, html VARCHAR)"),
+		Backup:       backup,
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if _, err := h.database().Exec("INSERT INTO html VALUES ('a', '<p>a</p>')"); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// writeInitSQL writes an init SQL file and returns its path.
+func writeInitSQL(t *testing.T, sql string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "init.sql")
+	if err := os.WriteFile(path, []byte(sql), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// countBackupRows returns the number of html rows in a backup file.
+func countBackupRows(t *testing.T, path string) int {
+	t.Helper()
+	db, err := sql.Open("duckdb", path+"?access_mode=READ_ONLY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM html").Scan(&n); err != nil {
+		t.Fatalf("backup is not readable: %v", err)
+	}
+	return n
+}
+
+func TestBackup_Local(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	dest := filepath.Join(t.TempDir(), "backups")
+	h := newBackupHandler(t, ctx, &Backup{Destination: dest, Interval: "0"})
+
+	path, err := h.runBackup(ctx)
+	if err != nil || !strings.HasPrefix(filepath.Base(path), "works-") || !strings.HasSuffix(path, ".duckdb") {
+		t.Fatalf("runBackup = %q, %v", path, err)
+	}
+	if n := countBackupRows(t, path); n != 1 {
+		t.Errorf("backup has %d rows, want 1", n)
+	}
+
+	t.Run("shutdown backup", func(t *testing.T) {
+		if _, err := h.database().Exec("INSERT INTO html VALUES ('b', '<p>b</p>')"); err != nil {
+			t.Fatal(err)
+		}
+		// The shutdown backup may get the same timestamp.
+		os.Remove(path)
+		if err := h.Cleanup(); err != nil {
+			t.Fatalf("Cleanup: %v", err)
+		}
+		files, _ := filepath.Glob(filepath.Join(dest, "works-*.duckdb"))
+		if len(files) != 1 {
+			t.Fatalf("backups = %v", files)
+		}
+		if n := countBackupRows(t, files[0]); n != 2 {
+			t.Errorf("shutdown backup has %d rows, want 2", n)
+		}
+	})
+}
+
+func TestBackup_SharedPoolShutdown(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	// While another handler still uses the pool (as on a reload), Cleanup
+	// does not take a backup.
+	dest := t.TempDir()
+	h := newBackupHandler(t, ctx, &Backup{Destination: dest, Interval: "0"})
+	other := &HTMLFromDuckDB{
+		DatabasePath: h.DatabasePath,
+		Table:        "html",
+		ReadOnly:     h.ReadOnly,
+		InitSQLFile:  h.InitSQLFile,
+	}
+	reloadCtx, cancelReload := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancelReload()
+	if err := other.Provision(reloadCtx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer other.Cleanup()
+	if err := h.Cleanup(); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dest, "*.duckdb")); len(files) != 0 {
+		t.Errorf("unexpected backups %v", files)
+	}
+}
+
+func TestBackup_Export(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	dest := t.TempDir()
+	off := false
+	h := newBackupHandler(t, ctx, &Backup{Destination: dest, Interval: "0", Format: "export", OnShutdown: &off})
+	defer h.Cleanup()
+
+	path, err := h.runBackup(ctx)
+	if err != nil {
+		t.Fatalf("runBackup: %v", err)
+	}
+	for _, name := range []string{"schema.sql", "load.sql", "html.parquet"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			t.Errorf("export lacks %s: %v", name, err)
+		}
+	}
+}
+
+func TestBackup_HTTP(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	var mu sync.Mutex
+	uploads := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("Repr-Digest") != "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		uploads[r.URL.Path] = body
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	off := false
+	backup := &Backup{Destination: srv.URL + "/backups/", Interval: "0", Token: "secret", OnShutdown: &off}
+	h := newBackupHandler(t, ctx, backup)
+	defer h.Cleanup()
+
+	location, err := h.runBackup(ctx)
+	if err != nil || !strings.HasPrefix(location, srv.URL+"/backups/works-") {
+		t.Fatalf("runBackup = %q, %v", location, err)
+	}
+	mu.Lock()
+	body := uploads[strings.TrimPrefix(location, srv.URL)]
+	mu.Unlock()
+	if !bytes.HasPrefix(body[8:], []byte("DUCK")) {
+		t.Error("upload is not a DuckDB file")
+	}
+
+	t.Run("export zip", func(t *testing.T) {
+		backup.Format = "export"
+		defer func() { backup.Format = "duckdb" }()
+		location, err := h.runBackup(ctx)
+		if err != nil || !strings.HasSuffix(location, ".zip") {
+			t.Fatalf("runBackup = %q, %v", location, err)
+		}
+		mu.Lock()
+		body := uploads[strings.TrimPrefix(location, srv.URL)]
+		mu.Unlock()
+		if _, err := zip.NewReader(bytes.NewReader(body), int64(len(body))); err != nil {
+			t.Errorf("upload is not a zip: %v", err)
+		}
+	})
+
+	t.Run("rejected upload", func(t *testing.T) {
+		backup.Token = "wrong"
+		defer func() { backup.Token = "secret" }()
+		if _, err := h.runBackup(ctx); err == nil || !strings.Contains(err.Error(), "status 403") {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestBackup_ProvisionErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	tests := []struct {
+		name   string
+		backup *Backup
+	}{
+		{"no destination", &Backup{}},
+		{"bad interval", &Backup{Destination: t.TempDir(), Interval: "often"}},
+		{"bad format", &Backup{Destination: t.TempDir(), Format: "csv"}},
+		{"duckdb to object storage", &Backup{Destination: "s3://bucket/backups"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTMLFromDuckDB{Table: "html", Backup: tt.backup}
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestParseBackup(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		backup https://backups.example/works {
+			interval 15m
+			on_shutdown false
+			format export
+			token secret
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	b := h.Backup
+	if b == nil || b.Destination != "https://backups.example/works" || b.Interval != "15m" ||
+		b.OnShutdown == nil || *b.OnShutdown || b.Format != "export" || b.Token != "secret" {
+		t.Errorf("unexpected backup: %+v", b)
+	}
+
+	d = caddyfile.NewTestDispenser(`html_from_duckdb {
+		backup /backups {
+			keep 7
+		}
+	}`)
+	if err := (&HTMLFromDuckDB{}).UnmarshalCaddyfile(d); err == nil {
+		t.Error("expected error for unknown subdirective")
+	}
+}
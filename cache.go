@@ -0,0 +1,301 @@
+package caddyhtmlduckdb
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lruCache is a size-bounded LRU cache whose entries optionally expire after
+// a TTL. It is safe for concurrent use.
+type lruCache[V any] struct {
+	mu    sync.Mutex
+	max   int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+
+	hits, misses, evictions uint64
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// CacheStats reports a cache's size and effectiveness.
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	MaxSize   int    `json:"max_size"`
+	TTL       string `json:"ttl,omitempty"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// newLRUCache returns a cache holding at most max entries. A ttl of zero
+// means entries only leave the cache when evicted or removed.
+func newLRUCache[V any](max int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{
+		max:   max,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the value for key if present and not expired.
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*lruEntry[V])
+		if e.expires.IsZero() || time.Now().Before(e.expires) {
+			c.ll.MoveToFront(el)
+			c.hits++
+			return e.value, true
+		}
+		c.removeElement(el)
+	}
+	c.misses++
+	var zero V
+	return zero, false
+}
+
+// add stores value under key, evicting the least recently used entry if the
+// cache is full.
+func (c *lruCache[V]) add(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		e := el.Value.(*lruEntry[V])
+		e.value, e.expires = value, expires
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry[V]{key: key, value: value, expires: expires})
+	if c.max > 0 && c.ll.Len() > c.max {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// remove deletes key from the cache.
+func (c *lruCache[V]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// removeFunc deletes the entries whose value match reports true for, and
+// returns how many it deleted.
+func (c *lruCache[V]) removeFunc(match func(V) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*lruEntry[V]).value) {
+			c.removeElement(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// purge empties the cache.
+func (c *lruCache[V]) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.items)
+}
+
+func (c *lruCache[V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry[V]).key)
+}
+
+// stats returns the cache's current statistics.
+func (c *lruCache[V]) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{
+		Entries:   c.ll.Len(),
+		MaxSize:   c.max,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if c.ttl > 0 {
+		s.TTL = c.ttl.String()
+	}
+	return s
+}
+
+// indexCacheSize is the number of rendered index pages kept per handler.
+const indexCacheSize = 256
+
+// negativeCacheSize is the number of not-found IDs remembered per handler.
+const negativeCacheSize = 10000
+
+// esiCacheSize is the number of ESI include responses kept per handler.
+const esiCacheSize = 1024
+
+// indexPage is a rendered index page in the index cache.
+type indexPage struct {
+	html    string
+	etag    string
+	version string
+	// tags are the page's cache tags, for purges by tag.
+	tags []string
+	// bodies holds the page's compressed copies, for compress.
+	bodies *encodedBodies
+}
+
+// esiEntry is an ESI include response in the ESI cache.
+type esiEntry struct {
+	html string
+	tags []string
+}
+
+// cacheStats returns the statistics of the handler's enabled caches, keyed
+// by cache name.
+func (h *HTMLFromDuckDB) cacheStats() map[string]CacheStats {
+	stats := make(map[string]CacheStats)
+	if h.indexCache != nil {
+		stats["index"] = h.indexCache.stats()
+	}
+	if h.notFound != nil {
+		stats["not_found"] = h.notFound.stats()
+	}
+	if h.esiCache != nil {
+		stats["esi"] = h.esiCache.stats()
+	}
+	if h.tileCache != nil {
+		stats["tile"] = h.tileCache.stats()
+	}
+	return stats
+}
+
+// queryWatermark runs a watermark query (index_version_query or
+// invalidate_query) and returns its single value as a string.
+func (h *HTMLFromDuckDB) queryWatermark(ctx context.Context, endpoint, query string) (string, error) {
+	start := time.Now()
+	var v any
+	err := h.database().QueryRowContext(ctx, tagQuery(ctx, query)).Scan(&v)
+	h.observeQuery(ctx, endpoint, query, time.Since(start))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(v), nil
+}
+
+// watermark holds the last result of invalidate_query.
+type watermark struct {
+	mu    sync.RWMutex
+	value string
+}
+
+func (wm *watermark) load() string {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return wm.value
+}
+
+// swap stores v and returns the previous value.
+func (wm *watermark) swap(v string) string {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	old := wm.value
+	wm.value = v
+	return old
+}
+
+// startWatermarkPoll reads the initial watermark, failing if the query
+// doesn't work, and then polls it every interval until Cleanup.
+func (h *HTMLFromDuckDB) startWatermarkPoll(ctx context.Context, interval time.Duration) error {
+	qctx, cancel := h.queryContext(ctx)
+	v, err := h.queryWatermark(qctx, "invalidate", h.InvalidateQuery)
+	cancel()
+	if err != nil {
+		return err
+	}
+	h.watermark = &watermark{value: v}
+
+	pollCtx, stop := context.WithCancel(ctx)
+	h.stopPoll = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pollCtx.Done():
+				return
+			case <-ticker.C:
+				h.checkWatermark(pollCtx)
+			}
+		}
+	}()
+	return nil
+}
+
+// checkWatermark runs invalidate_query and flushes the caches if the
+// watermark moved. It reports whether it did.
+func (h *HTMLFromDuckDB) checkWatermark(ctx context.Context) bool {
+	qctx, cancel := h.queryContext(ctx)
+	defer cancel()
+	v, err := h.queryWatermark(qctx, "invalidate", h.InvalidateQuery)
+	if err != nil {
+		if ctx.Err() == nil {
+			h.log(ctx).Warn("invalidate query failed", zap.Error(err))
+		}
+		return false
+	}
+	old := h.watermark.swap(v)
+	if old == v {
+		return false
+	}
+	h.flushCaches()
+	h.log(ctx).Info("watermark changed, caches flushed",
+		zap.String("old", old),
+		zap.String("new", v))
+	h.notify(eventCacheFlush, map[string]any{"old": old, "new": v})
+	return true
+}
+
+// flushCaches empties the handler's response caches.
+func (h *HTMLFromDuckDB) flushCaches() {
+	if h.indexCache != nil {
+		h.indexCache.purge()
+	}
+	if h.notFound != nil {
+		h.notFound.purge()
+	}
+	if h.esiCache != nil {
+		h.esiCache.purge()
+	}
+	if h.tileCache != nil {
+		h.tileCache.purge()
+	}
+}
+
+// queryContext applies query_timeout to ctx.
+func (h *HTMLFromDuckDB) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.timeout > 0 {
+		return context.WithTimeout(ctx, h.timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,342 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestLRUCache(t *testing.T) {
+	t.Run("evicts least recently used", func(t *testing.T) {
+		c := newLRUCache[int](2, 0)
+		c.add("a", 1)
+		c.add("b", 2)
+		c.get("a")
+		c.add("c", 3)
+
+		if _, ok := c.get("b"); ok {
+			t.Error("expected b to be evicted")
+		}
+		if v, ok := c.get("a"); !ok || v != 1 {
+			t.Errorf("get(a) = %d, %v", v, ok)
+		}
+		if v, ok := c.get("c"); !ok || v != 3 {
+			t.Errorf("get(c) = %d, %v", v, ok)
+		}
+
+		s := c.stats()
+		if s.Entries != 2 || s.Evictions != 1 || s.Hits != 3 || s.Misses != 1 {
+			t.Errorf("unexpected stats: %+v", s)
+		}
+	})
+
+	t.Run("expires after ttl", func(t *testing.T) {
+		c := newLRUCache[string](10, 20*time.Millisecond)
+		c.add("a", "x")
+		if _, ok := c.get("a"); !ok {
+			t.Fatal("expected hit before ttl")
+		}
+		time.Sleep(30 * time.Millisecond)
+		if _, ok := c.get("a"); ok {
+			t.Error("expected entry to expire")
+		}
+		if c.stats().Entries != 0 {
+			t.Error("expired entry should be removed")
+		}
+	})
+
+	t.Run("remove and purge", func(t *testing.T) {
+		c := newLRUCache[int](10, 0)
+		c.add("a", 1)
+		c.add("b", 2)
+		c.remove("a")
+		if _, ok := c.get("a"); ok {
+			t.Error("expected a to be removed")
+		}
+		c.purge()
+		if _, ok := c.get("b"); ok || c.stats().Entries != 0 {
+			t.Error("expected empty cache after purge")
+		}
+	})
+}
+
+func TestServeHTTP_IndexCache(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	setIndex := func(t *testing.T, body string) {
+		t.Helper()
+		if _, err := db.Exec(`CREATE OR REPLACE MACRO render_index(page := 1, base_path := '') AS TABLE
+			SELECT '` + body + ` ' || page AS html`); err != nil {
+			t.Fatalf("failed to create index macro: %v", err)
+		}
+	}
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR, updated_at INTEGER);
+		INSERT INTO html VALUES ('a', '<p>a</p>', 1)`); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	setIndex(t, "v1")
+
+	newHandler := func(ttl time.Duration, versionQuery string) *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{
+			db:                db,
+			Table:             "html",
+			HTMLColumn:        "html",
+			IDColumn:          "id",
+			IndexEnabled:      true,
+			IndexMacro:        "render_index",
+			IndexVersionQuery: versionQuery,
+			indexCache:        newLRUCache[indexPage](indexCacheSize, ttl),
+			logger:            zap.NewNop(),
+		}
+	}
+	get := func(t *testing.T, h *HTMLFromDuckDB, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("version query drives etag and cache", func(t *testing.T) {
+		setIndex(t, "v1")
+		h := newHandler(0, "SELECT max(updated_at) FROM html")
+
+		rec := get(t, h, "")
+		if rec.Body.String() != "v1 1" {
+			t.Fatalf("unexpected body %q", rec.Body.String())
+		}
+		etag := rec.Header().Get("ETag")
+
+		// The macro changes but the watermark doesn't: the cached page and
+		// the watermark ETag are still served.
+		setIndex(t, "v2")
+		if rec := get(t, h, etag); rec.Code != http.StatusNotModified {
+			t.Errorf("expected 304, got %d", rec.Code)
+		}
+		if rec := get(t, h, ""); rec.Body.String() != "v1 1" || rec.Header().Get("ETag") != etag {
+			t.Errorf("expected cached page, got %q", rec.Body.String())
+		}
+
+		// Moving the watermark invalidates both.
+		if _, err := db.Exec(`UPDATE html SET updated_at = 2`); err != nil {
+			t.Fatal(err)
+		}
+		rec = get(t, h, etag)
+		if rec.Code != http.StatusOK || rec.Body.String() != "v2 1" {
+			t.Errorf("expected fresh page, got %d %q", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("ETag") == etag {
+			t.Error("expected a new ETag")
+		}
+	})
+
+	t.Run("ttl without version query", func(t *testing.T) {
+		setIndex(t, "v1")
+		h := newHandler(50*time.Millisecond, "")
+
+		if rec := get(t, h, ""); rec.Body.String() != "v1 1" {
+			t.Fatalf("unexpected body %q", rec.Body.String())
+		}
+		setIndex(t, "v2")
+		if rec := get(t, h, ""); rec.Body.String() != "v1 1" {
+			t.Errorf("expected cached page, got %q", rec.Body.String())
+		}
+		time.Sleep(60 * time.Millisecond)
+		if rec := get(t, h, ""); rec.Body.String() != "v2 1" {
+			t.Errorf("expected page rebuilt after ttl, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("failing version query bypasses cache", func(t *testing.T) {
+		setIndex(t, "v1")
+		h := newHandler(time.Hour, "SELECT max(missing) FROM html")
+
+		get(t, h, "")
+		setIndex(t, "v2")
+		if rec := get(t, h, ""); rec.Code != http.StatusOK || rec.Body.String() != "v2 1" {
+			t.Errorf("expected uncached page, got %d %q", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestCheckWatermark(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR, updated_at INTEGER);
+		INSERT INTO html VALUES ('a', '<p>a</p>', 1);
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE
+		SELECT string_agg(id, ',') AS html FROM html`); err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:              db,
+		Table:           "html",
+		HTMLColumn:      "html",
+		IDColumn:        "id",
+		IndexEnabled:    true,
+		IndexMacro:      "render_index",
+		InvalidateQuery: "SELECT max(updated_at) FROM html",
+		indexCache:      newLRUCache[indexPage](indexCacheSize, 0),
+		watermark:       &watermark{value: "1"},
+		logger:          zap.NewNop(),
+	}
+	get := func(t *testing.T, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec
+	}
+
+	rec := get(t, "")
+	etag := rec.Header().Get("ETag")
+	if rec.Body.String() != "a" || etag == "" {
+		t.Fatalf("unexpected response %q %q", rec.Body.String(), etag)
+	}
+
+	// Until the watermark is polled, the cached page and its ETag stand.
+	if _, err := db.Exec(`INSERT INTO html VALUES ('b', '<p>b</p>', 2)`); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(t, etag); rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 before polling, got %d", rec.Code)
+	}
+
+	if !h.checkWatermark(context.Background()) {
+		t.Fatal("expected watermark change")
+	}
+	if h.checkWatermark(context.Background()) {
+		t.Error("unchanged watermark should not flush")
+	}
+	if h.indexCache.stats().Entries != 0 {
+		t.Error("expected index cache to be flushed")
+	}
+	rec = get(t, etag)
+	if rec.Code != http.StatusOK || rec.Body.String() != "a,b" || rec.Header().Get("ETag") == etag {
+		t.Errorf("expected fresh index, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProvision_InvalidateQuery(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	readOnly := false
+
+	h := &HTMLFromDuckDB{Table: "html", ReadOnly: &readOnly, InvalidateQuery: "SELECT max(x) FROM missing"}
+	if err := h.Provision(ctx); err == nil {
+		h.Cleanup()
+		t.Error("expected error for failing invalidate_query")
+	}
+
+	h = &HTMLFromDuckDB{Table: "html", ReadOnly: &readOnly, InvalidateQuery: "SELECT 1", InvalidateInterval: "5ms"}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if h.watermark.load() != "1" || h.indexCache == nil {
+		t.Errorf("expected watermark and index cache, got %q %v", h.watermark.load(), h.indexCache)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := h.Cleanup(); err != nil {
+		t.Errorf("Cleanup: %v", err)
+	}
+}
+
+func TestServeHTTP_NegativeCache(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR)`); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:               db,
+		Table:            "html",
+		HTMLColumn:       "html",
+		IDColumn:         "id",
+		NotFoundRedirect: "/missing",
+		notFound:         newLRUCache[struct{}](negativeCacheSize, time.Hour),
+		logger:           zap.NewNop(),
+	}
+	get := func(t *testing.T) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest("GET", "/new", nil), emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec
+	}
+
+	if rec := get(t); rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d", rec.Code)
+	}
+
+	// The record appears, but the cached miss still answers until flushed.
+	if _, err := db.Exec(`INSERT INTO html VALUES ('new', '<p>new</p>')`); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(t); rec.Code != http.StatusFound {
+		t.Errorf("expected cached miss, got %d", rec.Code)
+	}
+	if s := h.notFound.stats(); s.Hits != 1 || s.Entries != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	h.flushCaches()
+	if rec := get(t); rec.Code != http.StatusOK || rec.Body.String() != "<p>new</p>" {
+		t.Errorf("expected record after flush, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProvision_InvalidateQueryReleasesPools(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	primary := writeRecordsDB(t, map[string]string{"a": "<p>a</p>"})
+	shadow := writeRecordsDB(t, map[string]string{"a": "<p>a</p>"})
+	for _, h := range []*HTMLFromDuckDB{
+		{InvalidateQuery: "SELECT max(missing) FROM html"},
+		{InvalidateQuery: "SELECT 1", InvalidateInterval: "soon"},
+	} {
+		h.DatabasePath, h.ShadowDatabasePath, h.Table = primary, shadow, "html"
+		if err := h.Provision(ctx); err == nil {
+			h.Cleanup()
+			t.Fatal("expected Provision to fail")
+		}
+		pools.Range(func(key, _ any) bool {
+			if cfg := key.(poolConfig); strings.Contains(cfg.connStr, primary) || strings.Contains(cfg.connStr, shadow) {
+				t.Errorf("pool for %s still open after a failed provision", cfg.connStr)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// canaryMaxRows caps the rows a canary query reads.
+const canaryMaxRows = 1000
+
+// canaryQuery returns the query the detailed health check runs to exercise
+// a macro, called the way the handler calls it, with health_canary_id and
+// health_canary_term standing in for request parameters.
+func (h *HTMLFromDuckDB) canaryQuery(checkName, macro string) string {
+	id := escapeSQLString(h.HealthCanaryID)
+	basePath := escapeSQLString(h.BasePath)
+	macro = sanitizeIdentifier(macro)
+	switch checkName {
+	case "index_macro":
+		return fmt.Sprintf("SELECT * FROM %s(page := 1, base_path := '%s')", macro, basePath)
+	case "search_macro":
+		return fmt.Sprintf("SELECT * FROM %s(term := '%s', base_path := '%s')", macro, escapeSQLString(h.HealthCanaryTerm), basePath)
+	case "not_found_macro":
+		return fmt.Sprintf("SELECT * FROM %s(id := '%s', path := '%s')", macro, id, escapeSQLString(h.BasePath+"/"+h.HealthCanaryID))
+	case "table_macro", "table_footer_macro":
+		return fmt.Sprintf("SELECT * FROM %s(base_path := '%s')", macro, basePath)
+	default:
+		// record_macro, record routes and preload_macro take the record id
+		return fmt.Sprintf("SELECT * FROM %s(id := '%s')", macro, id)
+	}
+}
+
+// runCanary runs a canary query to completion and returns how many rows it
+// produced. Reading the rows, rather than counting them in SQL, makes DuckDB
+// evaluate every column, so errors raised while rendering show up.
+func (h *HTMLFromDuckDB) runCanary(ctx context.Context, query string) (int64, error) {
+	rows, err := h.database().QueryContext(ctx, tagQuery(ctx, fmt.Sprintf("SELECT * FROM (%s) LIMIT %d", query, canaryMaxRows)))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
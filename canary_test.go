@@ -0,0 +1,106 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_HealthCanary(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('w1', '<p>one</p>'), ('w2', '<p>two</p>');
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE
+			SELECT '<li>' || id || '</li>' AS html FROM html;
+		CREATE MACRO render_search(term := '', base_path := '') AS TABLE
+			SELECT html FROM html WHERE html LIKE '%' || term || '%';
+		CREATE MACRO render_record(id) AS TABLE
+			SELECT html FROM html WHERE html.id = id;
+		CREATE MACRO render_broken(id) AS TABLE
+			SELECT error('spatial extension not loaded') AS html;
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	health := func(t *testing.T, h *HTMLFromDuckDB) (int, HealthResponse) {
+		t.Helper()
+		h.db = db
+		h.Table = "html"
+		h.HealthEnabled = true
+		h.HealthPath = "_health"
+		h.logger = zap.NewNop()
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_health", nil), emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		var resp HealthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return rec.Code, resp
+	}
+
+	t.Run("reports rows and latency", func(t *testing.T) {
+		status, resp := health(t, &HTMLFromDuckDB{
+			HealthDetailed:   true,
+			HealthCanaryID:   "w2",
+			HealthCanaryTerm: "one",
+			IndexEnabled:     true,
+			IndexMacro:       "render_index",
+			SearchEnabled:    true,
+			SearchMacro:      "render_search",
+			RecordMacro:      "render_record",
+		})
+		if status != http.StatusOK {
+			t.Fatalf("status = %d: %+v", status, resp.Checks)
+		}
+		for check, want := range map[string]int64{"index_macro": 2, "search_macro": 1, "record_macro": 1} {
+			c := resp.Checks[check]
+			if c == nil || c.Rows == nil || *c.Rows != want || c.CanaryLatencyMs == nil {
+				t.Errorf("%s = %+v, want %d rows", check, c, want)
+			}
+		}
+	})
+
+	t.Run("broken macro is unhealthy", func(t *testing.T) {
+		status, resp := health(t, &HTMLFromDuckDB{HealthDetailed: true, HealthCanaryID: "w1", RecordMacro: "render_broken"})
+		c := resp.Checks["record_macro"]
+		if status != http.StatusServiceUnavailable || c == nil || !strings.Contains(c.Error, "spatial extension not loaded") {
+			t.Errorf("status = %d, record_macro = %+v", status, c)
+		}
+	})
+
+	t.Run("not run without detailed", func(t *testing.T) {
+		status, resp := health(t, &HTMLFromDuckDB{RecordMacro: "render_broken"})
+		if c := resp.Checks["record_macro"]; status != http.StatusOK || c.Rows != nil {
+			t.Errorf("status = %d, record_macro = %+v", status, c)
+		}
+	})
+}
+
+func TestParseHealthCanary(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		health_canary_id w123
+		health_canary_term "dublin core"
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.HealthCanaryID != "w123" || h.HealthCanaryTerm != "dublin core" {
+		t.Errorf("HealthCanaryID = %q, HealthCanaryTerm = %q", h.HealthCanaryID, h.HealthCanaryTerm)
+	}
+}
@@ -0,0 +1,103 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Databases a request is routed to with canary_database_path.
+const (
+	routePrimary = "primary"
+	routeCanary  = "canary"
+)
+
+// databaseRequests observes the duration of requests by the database they
+// were routed to, so the canary can be compared with the primary.
+var databaseRequests = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "caddy_html_duckdb_database_request_duration_seconds",
+	Help:    "Duration of requests by the database they were routed to (primary or canary).",
+	Buckets: prometheus.DefBuckets,
+}, []string{"instance", "database"})
+
+// databaseErrors counts the requests that failed with a server error, by
+// the database they were routed to.
+var databaseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "caddy_html_duckdb_database_errors_total",
+	Help: "Requests that failed with a server error, by the database they were routed to (primary or canary).",
+}, []string{"instance", "database"})
+
+// provisionCanary opens canary_database_path read-only, sharing the pool
+// like the handler's own.
+func (h *HTMLFromDuckDB) provisionCanary(ctx context.Context, cfg poolConfig, settings poolSettings) error {
+	if h.CanaryDatabasePath == "" {
+		return nil
+	}
+	h.canaryPercent = 10
+	if h.CanaryPercent != nil {
+		h.canaryPercent = *h.CanaryPercent
+	}
+	if h.canaryPercent < 0 || h.canaryPercent > 100 {
+		return fmt.Errorf("canary_percent must be between 0 and 100")
+	}
+	if h.indexCache != nil || h.notFound != nil || h.esiCache != nil || h.tileCache != nil {
+		return fmt.Errorf("canary_database_path can't be combined with the index, negative, ESI or tile cache")
+	}
+	pool, err := h.acquireReadOnlyPool(ctx, cfg, settings, h.CanaryDatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to open canary_database_path: %v", err)
+	}
+	h.canary = pool
+	return nil
+}
+
+// releaseCanary releases the canary database's pool.
+func (h *HTMLFromDuckDB) releaseCanary() {
+	if h.canary == nil {
+		return
+	}
+	if err := releasePool(h.canary); err != nil {
+		h.logger.Warn("failed to release canary database", zap.Error(err))
+	}
+	h.canary = nil
+}
+
+// routeCanary routes canary_percent of clients to the canary database and
+// returns r with the routing applied, and the database it was routed to.
+// Clients are bucketed by a hash of their address, so each one keeps
+// seeing the same database. Requests that selected a database with
+// database_selector aren't routed.
+func (h *HTMLFromDuckDB) routeCanary(r *http.Request) (*http.Request, string) {
+	if h.canary == nil {
+		return r, ""
+	}
+	if _, selected := r.Context().Value(selectedDatabaseKey{}).(*dbPool); selected {
+		return r, ""
+	}
+	sum := sha256.Sum256([]byte(h.CanaryDatabasePath + "\x00" + clientAddr(r)))
+	if float64(binary.BigEndian.Uint64(sum[:8])%10000) >= h.canaryPercent*100 {
+		return r, routePrimary
+	}
+	return r.WithContext(context.WithValue(r.Context(), selectedDatabaseKey{}, h.canary)), routeCanary
+}
+
+// observeDatabase records a request routed to database that started at
+// start and ended with err.
+func (h *HTMLFromDuckDB) observeDatabase(database string, start time.Time, err error) {
+	if database == "" {
+		return
+	}
+	databaseRequests.WithLabelValues(h.instanceName(), database).Observe(time.Since(start).Seconds())
+	var herr caddyhttp.HandlerError
+	if err != nil && (!errors.As(err, &herr) || herr.StatusCode >= 500) {
+		databaseErrors.WithLabelValues(h.instanceName(), database).Inc()
+	}
+}
@@ -0,0 +1,142 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCanaryDatabase(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	percent := 100.0
+	h := &HTMLFromDuckDB{
+		Name:               "canary-test",
+		DatabasePath:       writeRecordsDB(t, map[string]string{"a": "<p>primary</p>"}),
+		Table:              "html",
+		CanaryDatabasePath: writeRecordsDB(t, map[string]string{"a": "<p>canary</p>"}),
+		CanaryPercent:      &percent,
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	errorsBefore := testutil.ToFloat64(databaseErrors.WithLabelValues("canary-test", routeCanary))
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	if rec.Body.String() != "<p>canary</p>" {
+		t.Errorf("body = %q, want the canary's", rec.Body.String())
+	}
+	// Missing records aren't errors.
+	if err := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/b", nil), emptyNextHandler()); err == nil {
+		t.Error("expected 404")
+	}
+	if got := testutil.ToFloat64(databaseErrors.WithLabelValues("canary-test", routeCanary)) - errorsBefore; got != 0 {
+		t.Errorf("errors = %v, want 0", got)
+	}
+	if n := testutil.CollectAndCount(databaseRequests, "caddy_html_duckdb_database_request_duration_seconds"); n == 0 {
+		t.Error("no request durations observed")
+	}
+}
+
+func TestProvision_CanaryPercentZero(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	percent := 0.0
+	h := &HTMLFromDuckDB{
+		Name:               "canary-zero",
+		Table:              "html",
+		CanaryDatabasePath: writeRecordsDB(t, map[string]string{"a": "<p>canary</p>"}),
+		CanaryPercent:      &percent,
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	if h.canaryPercent != 0 {
+		t.Fatalf("canaryPercent = %v, want 0", h.canaryPercent)
+	}
+	if _, database := h.routeCanary(httptest.NewRequest(http.MethodGet, "/a", nil)); database != routePrimary {
+		t.Errorf("routed to %s, want %s", database, routePrimary)
+	}
+
+	h = &HTMLFromDuckDB{Name: "canary-default", Table: "html", CanaryDatabasePath: h.CanaryDatabasePath}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	if h.canaryPercent != 10 {
+		t.Errorf("canaryPercent = %v, want the default 10", h.canaryPercent)
+	}
+}
+
+func TestRouteCanary_Percent(t *testing.T) {
+	h := &HTMLFromDuckDB{CanaryDatabasePath: "next.duckdb", canaryPercent: 25, canary: &dbPool{}}
+	counts := map[string]int{}
+	for i := range 1000 {
+		addr := fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+		route := func() (*http.Request, string) {
+			r := httptest.NewRequest(http.MethodGet, "/a", nil)
+			r.RemoteAddr = addr
+			return h.routeCanary(r)
+		}
+		r, database := route()
+		counts[database]++
+		if _, selected := r.Context().Value(selectedDatabaseKey{}).(*dbPool); selected != (database == routeCanary) {
+			t.Fatalf("%s: routed to %s, selected = %v", addr, database, selected)
+		}
+		// Clients keep their database.
+		if _, again := route(); again != database {
+			t.Fatalf("%s: routed to %s, then %s", addr, database, again)
+		}
+	}
+	if counts[routeCanary] < 200 || counts[routeCanary] > 300 {
+		t.Errorf("counts = %v, want about 250 canary", counts)
+	}
+}
+
+func TestProvision_CanaryErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := writeRecordsDB(t, map[string]string{"a": "<p>canary</p>"})
+	percent := 150.0
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"percent":        {Table: "html", CanaryDatabasePath: path, CanaryPercent: &percent},
+		"negative cache": {Table: "html", CanaryDatabasePath: path, NegativeCacheTTL: "1m"},
+		"missing file":   {Table: "html", CanaryDatabasePath: filepath.Join(t.TempDir(), "none.duckdb")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseCanary(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		canary_database_path /srv/next/works.duckdb
+		canary_percent 5
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.CanaryDatabasePath != "/srv/next/works.duckdb" || h.CanaryPercent == nil || *h.CanaryPercent != 5 {
+		t.Errorf("CanaryDatabasePath = %q, CanaryPercent = %v", h.CanaryDatabasePath, h.CanaryPercent)
+	}
+}
@@ -0,0 +1,72 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// statusClientClosedRequest is the status of requests the client abandoned,
+// as in Caddy's reverse proxy and nginx. The client never sees it, but it
+// keeps aborts apart from server errors in access logs and metrics.
+const statusClientClosedRequest = 499
+
+// errShuttingDown is the cause of requests canceled by draining.
+var errShuttingDown = errors.New("handler is shutting down")
+
+// canceledRequests counts requests the client disconnected from before the
+// response was complete, which cancels their queries.
+var canceledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "caddy_html_duckdb_canceled_requests_total",
+	Help: "Requests whose queries were canceled because the client disconnected, by endpoint.",
+}, []string{"instance", "endpoint"})
+
+// clientGone reports whether ctx was canceled because the client
+// disconnected, rather than by query_timeout or shutdown draining.
+func clientGone(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), context.Canceled)
+}
+
+// logFailure logs a failed query or response write as an error, unless the
+// client has disconnected: that interrupts the query and breaks the
+// connection, which is expected and logged at debug level instead.
+func (h *HTMLFromDuckDB) logFailure(ctx context.Context, msg string, fields ...zap.Field) {
+	if clientGone(ctx) {
+		h.log(ctx).Debug(msg+" (client disconnected)", fields...)
+		return
+	}
+	h.log(ctx).Error(msg, fields...)
+}
+
+// clientAborted logs and counts a request the client disconnected from. A
+// failed request is returned as a 499 error so it isn't reported as a
+// server error; one that already wrote its response keeps err (nil).
+func (h *HTMLFromDuckDB) clientAborted(r *http.Request, err error) error {
+	info := requestInfoFrom(r.Context())
+	endpoint := "record"
+	var fields []zap.Field
+	if info != nil {
+		if info.endpoint != "" {
+			endpoint = info.endpoint
+		}
+		fields = append(fields, zap.Duration("query_time", info.queryTime))
+	}
+	canceledRequests.WithLabelValues(h.instanceName(), endpoint).Inc()
+	h.log(r.Context()).Info("client disconnected", append(fields,
+		zap.String("endpoint", endpoint),
+		zap.String("path", r.URL.Path),
+		zap.String("client", clientAddr(r)))...)
+	if err == nil {
+		return nil
+	}
+	var herr caddyhttp.HandlerError
+	if errors.As(err, &herr) {
+		herr.StatusCode = statusClientClosedRequest
+		return herr
+	}
+	return caddyhttp.Error(statusClientClosedRequest, err)
+}
@@ -0,0 +1,83 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestClientGone(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOut, cancelTimeout := context.WithTimeout(context.Background(), 0)
+	defer cancelTimeout()
+	drained, cancelDrain := context.WithCancelCause(context.Background())
+	cancelDrain(errShuttingDown)
+	query, cancelQuery := context.WithTimeout(canceled, time.Hour)
+	defer cancelQuery()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{"live", context.Background(), false},
+		{"client disconnected", canceled, true},
+		{"query timeout", timedOut, false},
+		{"shutdown", drained, false},
+		{"query of a disconnected client", query, true},
+	}
+	for _, tt := range tests {
+		if got := clientGone(tt.ctx); got != tt.want {
+			t.Errorf("%s: clientGone = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServeHTTP_ClientDisconnect(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`CREATE VIEW html AS
+		SELECT 'slow' AS id, (SELECT sum(i) FROM range(1000000000000) t(i))::VARCHAR AS html`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:         db,
+		Name:       "cancel-test",
+		Table:      "html",
+		IDColumn:   "id",
+		HTMLColumn: "html",
+		logger:     zap.NewNop(),
+	}
+	counter := canceledRequests.WithLabelValues("cancel-test", "record")
+	before := testutil.ToFloat64(counter)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx)
+	start := time.Now()
+	err = h.ServeHTTP(httptest.NewRecorder(), req, emptyNextHandler())
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("query ran for %v after the client disconnected", elapsed)
+	}
+	var herr caddyhttp.HandlerError
+	if !errors.As(err, &herr) || herr.StatusCode != statusClientClosedRequest {
+		t.Errorf("err = %v, want status %d", err, statusClientClosedRequest)
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("canceled requests = %v, want 1", got)
+	}
+}
@@ -0,0 +1,175 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+	"go.uber.org/zap"
+)
+
+// Page sizes of the changes feed.
+const (
+	changesPageSize    = 100
+	changesMaxPageSize = 1000
+)
+
+// changesPollInterval is how often a long-polling changes request checks
+// the database for new rows.
+const changesPollInterval = time.Second
+
+// Change is an entry in the changes feed.
+type Change struct {
+	ID      string `json:"id"`
+	Updated string `json:"updated"`
+	Deleted bool   `json:"deleted"`
+}
+
+// ChangesResponse is the body of a changes feed response. Next is the
+// watermark to pass as since in the following request; More reports that
+// further changes are already waiting.
+type ChangesResponse struct {
+	Changes []Change `json:"changes"`
+	Next    string   `json:"next"`
+	More    bool     `json:"more"`
+}
+
+// serveChanges serves {base_path}/{changes_path}?since=<watermark>: the IDs
+// whose updated_column is later than since, oldest first. With wait=<duration>
+// (or seconds) and nothing new yet, the request is held until a change shows
+// up or the wait, capped at changes_max_wait, runs out.
+func (h *HTMLFromDuckDB) serveChanges(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	since := query.Get("since")
+
+	limit := changesPageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid limit: %q", v))
+		}
+		limit = min(n, changesMaxPageSize)
+	}
+
+	var wait time.Duration
+	if v := query.Get("wait"); v != "" {
+		var err error
+		wait, err = time.ParseDuration(v)
+		if err != nil {
+			n, nerr := strconv.Atoi(v)
+			if nerr != nil || n < 0 {
+				return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid wait: %q", v))
+			}
+			wait = time.Duration(n) * time.Second
+		}
+		wait = min(wait, h.changesMaxWait)
+	}
+	deadline := time.Now().Add(wait)
+
+	var resp ChangesResponse
+	for {
+		var err error
+		resp, err = h.listChanges(r.Context(), since, limit)
+		var derr *duckdb.Error
+		if errors.As(err, &derr) && derr.Type == duckdb.ErrorTypeConversion {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid since: %v", err))
+		}
+		if err != nil {
+			if r.Context().Err() != nil {
+				return nil
+			}
+			h.logFailure(r.Context(), "changes query failed", zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		remaining := time.Until(deadline)
+		if len(resp.Changes) > 0 || remaining <= 0 {
+			break
+		}
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-time.After(min(remaining, changesPollInterval)):
+		}
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	return err
+}
+
+// listChanges returns up to limit changes after since (all when empty).
+// Rows sharing the last timestamp are not split across pages, since the
+// next request only asks for later ones.
+func (h *HTMLFromDuckDB) listChanges(ctx context.Context, since string, limit int) (ChangesResponse, error) {
+	updated := sanitizeIdentifier(h.UpdatedColumn)
+	deleted := "false"
+	if cond := h.goneCondition(); cond != "" {
+		deleted = cond
+	}
+	query := fmt.Sprintf("SELECT CAST(%s AS VARCHAR), CAST(%s AS VARCHAR), %s FROM %s WHERE %s IS NOT NULL",
+		sanitizeIdentifier(h.IDColumn), updated, deleted, sanitizeIdentifier(h.Table), updated)
+	var args []any
+	if since != "" {
+		query += fmt.Sprintf(" AND %s > ?", updated)
+		args = append(args, since)
+	}
+	if h.WhereClause != "" {
+		query += fmt.Sprintf(" AND (%s)", h.WhereClause)
+	}
+	query += fmt.Sprintf(" ORDER BY %s, %s LIMIT %d", updated, sanitizeIdentifier(h.IDColumn), limit+1)
+
+	ctx, cancel := h.queryContext(ctx)
+	defer cancel()
+
+	resp := ChangesResponse{Changes: []Change{}, Next: since}
+	start := time.Now()
+	rows, err := h.requestDatabase(ctx, "changes").QueryContext(ctx, tagQuery(ctx, query), args...)
+	if err != nil {
+		h.observeQuery(ctx, "changes", query, time.Since(start))
+		return resp, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.ID, &c.Updated, &c.Deleted); err != nil {
+			return resp, err
+		}
+		resp.Changes = append(resp.Changes, c)
+	}
+	h.observeQuery(ctx, "changes", query, time.Since(start))
+	if err := rows.Err(); err != nil {
+		return resp, err
+	}
+
+	if len(resp.Changes) > limit {
+		resp.More = true
+		first := resp.Changes[limit].Updated
+		n := limit
+		for n > 0 && resp.Changes[n-1].Updated == first {
+			n--
+		}
+		if n == 0 {
+			// A single timestamp has more rows than fit in a page; better
+			// to skip some than to never move on.
+			n = limit
+		}
+		resp.Changes = resp.Changes[:n]
+	}
+	if n := len(resp.Changes); n > 0 {
+		resp.Next = resp.Changes[n-1].Updated
+	}
+	return resp, nil
+}
@@ -0,0 +1,123 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Changes(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, updated_at TIMESTAMP, deleted BOOLEAN);
+		INSERT INTO html VALUES
+			('a', '<p>a</p>', TIMESTAMP '2025-01-01 10:00:00', false),
+			('b', '<p>b</p>', TIMESTAMP '2025-01-02 10:00:00', false),
+			('c', '<p>c</p>', TIMESTAMP '2025-01-02 10:00:00', true),
+			('d', '<p>d</p>', TIMESTAMP '2025-01-03 10:00:00', false),
+			('e', '<p>e</p>', NULL, false)
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:             db,
+		Table:          "html",
+		HTMLColumn:     "html",
+		IDColumn:       "id",
+		UpdatedColumn:  "updated_at",
+		DeletedColumn:  "deleted",
+		ChangesPath:    "_changes",
+		changesMaxWait: 5 * time.Second,
+		logger:         zap.NewNop(),
+	}
+	get := func(t *testing.T, query string) (ChangesResponse, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_changes"+query, nil), emptyNextHandler())
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			return ChangesResponse{}, herr.StatusCode
+		} else if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		var resp ChangesResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+		}
+		return resp, rec.Code
+	}
+	ids := func(resp ChangesResponse) string {
+		var s string
+		for _, c := range resp.Changes {
+			s += c.ID
+			if c.Deleted {
+				s += "-"
+			}
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		ids   string
+		next  string
+		more  bool
+	}{
+		{"all", "", "abc-d", "2025-01-03 10:00:00", false},
+		{"since", "?since=2025-01-01+10:00:00", "bc-d", "2025-01-03 10:00:00", false},
+		{"page", "?limit=1", "a", "2025-01-01 10:00:00", true},
+		{"ties kept together", "?limit=2", "a", "2025-01-01 10:00:00", true},
+		{"up to date", "?since=2025-01-03+10:00:00", "", "2025-01-03 10:00:00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, status := get(t, tt.query)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d", status)
+			}
+			if ids(resp) != tt.ids || resp.Next != tt.next || resp.More != tt.more {
+				t.Errorf("got %s next=%q more=%v, want %s next=%q more=%v",
+					ids(resp), resp.Next, resp.More, tt.ids, tt.next, tt.more)
+			}
+		})
+	}
+
+	for _, query := range []string{"?since=yesterday", "?limit=0", "?wait=soon"} {
+		if _, status := get(t, query); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, status)
+		}
+	}
+
+	t.Run("long poll", func(t *testing.T) {
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			db.Exec(`INSERT INTO html VALUES ('f', '<p>f</p>', TIMESTAMP '2025-01-04 10:00:00', false)`)
+		}()
+		start := time.Now()
+		resp, _ := get(t, "?since=2025-01-03+10:00:00&wait=3")
+		if ids(resp) != "f" {
+			t.Errorf("got %q after %v", ids(resp), time.Since(start))
+		}
+
+		start = time.Now()
+		resp, _ = get(t, "?since=2025-01-04+10:00:00&wait=100ms")
+		if len(resp.Changes) != 0 || time.Since(start) < 100*time.Millisecond {
+			t.Errorf("expected empty response after waiting, got %q after %v", ids(resp), time.Since(start))
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// provisionCharset looks up the charset record content is stored in. UTF-8
+// needs no decoder.
+func (h *HTMLFromDuckDB) provisionCharset() error {
+	if h.Charset == "" {
+		return nil
+	}
+	enc, err := htmlindex.Get(h.Charset)
+	if err != nil {
+		return fmt.Errorf("invalid charset: %q", h.Charset)
+	}
+	if name, _ := htmlindex.Name(enc); name != "utf-8" {
+		h.charset = enc
+	}
+	return nil
+}
+
+// decodeContent converts record content from charset to UTF-8, the charset
+// responses are served in. With validate_utf8 (or after decoding), invalid
+// UTF-8 is replaced with U+FFFD and logged instead of being sent as is.
+func (h *HTMLFromDuckDB) decodeContent(ctx context.Context, id, content string) string {
+	if h.charset != nil {
+		decoded, err := h.charset.NewDecoder().String(content)
+		if err == nil {
+			return decoded
+		}
+		h.log(ctx).Warn("failed to decode content", zap.String("id", id), zap.String("charset", h.Charset), zap.Error(err))
+	}
+	if (h.ValidateUTF8 || h.charset != nil) && !utf8.ValidString(content) {
+		h.log(ctx).Warn("invalid UTF-8 in content", zap.String("id", id))
+		return strings.ToValidUTF8(content, "�")
+	}
+	return content
+}
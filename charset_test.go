@@ -0,0 +1,97 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Charset(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	// VARCHAR is always UTF-8 in DuckDB, so legacy bytes live in a BLOB
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html BLOB);
+		INSERT INTO html VALUES ('w1', '<p>\xE9t\xE9</p>'::BLOB), ('w2', '<p>caf\xC3\xA9</p>'::BLOB);
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		charset  string
+		validate bool
+		id       string
+		want     string
+	}{
+		{"unchanged by default", "", false, "w1", "<p>\xe9t\xe9</p>"},
+		{"latin-1 decoded", "iso-8859-1", false, "w1", "<p>été</p>"},
+		{"windows-1252 decoded", "windows-1252", false, "w1", "<p>été</p>"},
+		{"utf-8 charset", "utf-8", false, "w2", "<p>café</p>"},
+		{"invalid utf-8 replaced", "", true, "w1", "<p>�t�</p>"},
+		{"valid utf-8 kept", "", true, "w2", "<p>café</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTMLFromDuckDB{
+				db:           db,
+				Table:        "html",
+				IDColumn:     "id",
+				HTMLColumn:   "html",
+				Charset:      tt.charset,
+				ValidateUTF8: tt.validate,
+				logger:       zap.NewNop(),
+			}
+			if err := h.provisionCharset(); err != nil {
+				t.Fatalf("provisionCharset: %v", err)
+			}
+			rec := httptest.NewRecorder()
+			if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+tt.id, nil), emptyNextHandler()); err != nil {
+				t.Fatalf("ServeHTTP: %v", err)
+			}
+			if rec.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.want)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
+
+func TestProvisionCharset(t *testing.T) {
+	for charset, decodes := range map[string]bool{"": false, "utf-8": false, "UTF8": false, "latin1": true, "ISO-8859-15": true} {
+		h := &HTMLFromDuckDB{Charset: charset}
+		if err := h.provisionCharset(); err != nil {
+			t.Errorf("provisionCharset(%q): %v", charset, err)
+		}
+		if (h.charset != nil) != decodes {
+			t.Errorf("provisionCharset(%q) decoder = %v", charset, h.charset)
+		}
+	}
+	if err := (&HTMLFromDuckDB{Charset: "klingon"}).provisionCharset(); err == nil {
+		t.Error("expected error for unknown charset")
+	}
+}
+
+func TestParseCharset(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		charset windows-1252
+		validate_utf8 true
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.Charset != "windows-1252" || !h.ValidateUTF8 {
+		t.Errorf("Charset = %q, ValidateUTF8 = %v", h.Charset, h.ValidateUTF8)
+	}
+}
@@ -0,0 +1,187 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// Chart dimensions, in SVG user units. The chart scales to the width it is
+// displayed at.
+const (
+	chartWidth  = 640
+	chartHeight = 320
+	chartLeft   = 56
+	chartRight  = 16
+	chartTop    = 16
+	chartBottom = 40
+	// chartMaxLabels is the most x axis labels drawn; with more points,
+	// only every so many is labelled.
+	chartMaxLabels = 24
+)
+
+// chartKinds are the charts format=svg draws, selected with ?chart=.
+var chartKinds = []string{"bar", "line"}
+
+// chartPoint is one row of a chart: its label and value. A NULL value
+// isn't drawn.
+type chartPoint struct {
+	label string
+	value sql.NullFloat64
+}
+
+// serveTableChart serves the table macro's result as an SVG chart of kind
+// bar or line: the first column labels the x axis and the second, cast to
+// DOUBLE, is the value. Points are capped by table_max_rows.
+func (h *HTMLFromDuckDB) serveTableChart(ctx context.Context, w http.ResponseWriter, r *http.Request, kind, query string) error {
+	if kind == "" {
+		kind = "bar"
+	}
+	if !slices.Contains(chartKinds, kind) {
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("unsupported chart %q", kind))
+	}
+	query = fmt.Sprintf("SELECT #1::VARCHAR, TRY_CAST(#2 AS DOUBLE) FROM (%s)", query)
+
+	start := time.Now()
+	rows, err := h.queryRetry(ctx, "table", h.queries(ctx, "table"), query)
+	if err != nil {
+		h.logFailure(ctx, "table macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+	var points []chartPoint
+	omitted := 0
+	for rows.Next() {
+		if h.TableMaxRows > 0 && len(points) >= h.TableMaxRows {
+			omitted++
+			continue
+		}
+		var label sql.NullString
+		var p chartPoint
+		if err = rows.Scan(&label, &p.value); err != nil {
+			break
+		}
+		p.label = label.String
+		points = append(points, p)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	h.observeQuery(ctx, "table", query, time.Since(start))
+	if err != nil {
+		h.logFailure(ctx, "table macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if omitted > 0 {
+		w.Header().Set("X-Truncated", strconv.Itoa(omitted))
+		h.log(ctx).Warn("table output truncated",
+			zap.String("macro", h.TableMacro),
+			zap.Int("max_rows", h.TableMaxRows),
+			zap.Int("omitted_rows", omitted))
+	}
+
+	var buf bytes.Buffer
+	writeChart(&buf, h.TableMacro, kind, points)
+	w.Header().Set("Cache-Control", h.tableCacheControl())
+	if notModified(w, r, generateETag(buf.String())) {
+		return nil
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// writeChart draws points as an SVG bar or line chart titled title. The
+// value axis always includes zero. Elements carry classes (bar, line,
+// point, axis, label) so that pages embedding the SVG inline can restyle
+// them.
+func writeChart(buf *bytes.Buffer, title, kind string, points []chartPoint) {
+	lo, hi := 0.0, 0.0
+	for _, p := range points {
+		if p.value.Valid {
+			lo, hi = math.Min(lo, p.value.Float64), math.Max(hi, p.value.Float64)
+		}
+	}
+	if lo == hi {
+		hi = lo + 1
+	}
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	y := func(v float64) float64 { return chartTop + (hi-v)/(hi-lo)*plotH }
+	band := plotW / float64(max(len(points), 1))
+	x := func(i int) float64 { return chartLeft + (float64(i)+0.5)*band }
+
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" role="img" aria-label="%s" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight, html.EscapeString(title))
+	fmt.Fprintf(buf, "\n<title>%s</title>\n", html.EscapeString(title))
+
+	// Value axis, labelled with its extremes and zero. lo <= 0 <= hi.
+	fmt.Fprintf(buf, `<g class="axis" stroke="#888"><line x1="%d" y1="%d" x2="%d" y2="%d"/><line x1="%d" y1="%.1f" x2="%d" y2="%.1f"/></g>`+"\n",
+		chartLeft, chartTop, chartLeft, chartHeight-chartBottom,
+		chartLeft, y(0), chartWidth-chartRight, y(0))
+	buf.WriteString(`<g class="label" fill="#444" text-anchor="end">`)
+	ticks := []float64{hi, lo}
+	if lo < 0 && hi > 0 {
+		ticks = append(ticks, 0)
+	}
+	for _, v := range ticks {
+		fmt.Fprintf(buf, `<text x="%d" y="%.1f" dy="0.35em">%s</text>`, chartLeft-6, y(v), strconv.FormatFloat(v, 'g', 4, 64))
+	}
+	buf.WriteString("</g>\n")
+
+	switch kind {
+	case "bar":
+		buf.WriteString(`<g class="bar" fill="steelblue">`)
+		for i, p := range points {
+			if !p.value.Valid {
+				continue
+			}
+			top, bottom := y(math.Max(p.value.Float64, 0)), y(math.Min(p.value.Float64, 0))
+			fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f"><title>%s: %s</title></rect>`,
+				x(i)-band*0.4, top, band*0.8, bottom-top,
+				html.EscapeString(p.label), strconv.FormatFloat(p.value.Float64, 'g', -1, 64))
+		}
+		buf.WriteString("</g>\n")
+	case "line":
+		// NULL values break the line.
+		var path bytes.Buffer
+		pen := "M"
+		for i, p := range points {
+			if !p.value.Valid {
+				pen = "M"
+				continue
+			}
+			fmt.Fprintf(&path, "%s%.1f %.1f ", pen, x(i), y(p.value.Float64))
+			pen = "L"
+		}
+		fmt.Fprintf(buf, `<path class="line" fill="none" stroke="steelblue" stroke-width="2" d="%s"/>`+"\n", bytes.TrimSpace(path.Bytes()))
+		buf.WriteString(`<g class="point" fill="steelblue">`)
+		for i, p := range points {
+			if p.value.Valid {
+				fmt.Fprintf(buf, `<circle cx="%.1f" cy="%.1f" r="3"><title>%s: %s</title></circle>`,
+					x(i), y(p.value.Float64),
+					html.EscapeString(p.label), strconv.FormatFloat(p.value.Float64, 'g', -1, 64))
+			}
+		}
+		buf.WriteString("</g>\n")
+	}
+
+	step := max((len(points)+chartMaxLabels-1)/chartMaxLabels, 1)
+	buf.WriteString(`<g class="label" fill="#444" text-anchor="middle">`)
+	for i := 0; i < len(points); i += step {
+		fmt.Fprintf(buf, `<text x="%.1f" y="%d">%s</text>`, x(i), chartHeight-chartBottom+16, html.EscapeString(points[i].label))
+	}
+	buf.WriteString("</g>\n</svg>\n")
+}
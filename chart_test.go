@@ -0,0 +1,96 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_TableChart(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE OR REPLACE MACRO sales(chart := 'ignored', base_path := '') AS TABLE
+		SELECT * FROM (VALUES ('Q1 <est>', 12.5::DECIMAL(5,2)), ('Q2', NULL), ('Q3', -4), ('Q4', 20)) t(quarter, total)
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+	handler := &HTMLFromDuckDB{
+		Table:        "html",
+		TableMacro:   "sales",
+		TablePath:    "_chart",
+		TableMaxRows: 3,
+		db:           db,
+		logger:       zap.NewNop(),
+	}
+	get := func(t *testing.T, query string) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_chart?"+query, nil), emptyNextHandler())
+		return rec, err
+	}
+
+	for _, kind := range []string{"bar", "line"} {
+		t.Run(kind, func(t *testing.T) {
+			rec, err := get(t, "format=svg&chart="+kind)
+			if err != nil {
+				t.Fatalf("ServeHTTP error: %v", err)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if rec.Header().Get("X-Truncated") != "1" {
+				t.Errorf("X-Truncated = %q", rec.Header().Get("X-Truncated"))
+			}
+			body := rec.Body.String()
+			dec := xml.NewDecoder(strings.NewReader(body))
+			for {
+				if _, err := dec.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("invalid SVG: %v\n%s", err, body)
+				}
+			}
+			if !strings.Contains(body, "Q1 &lt;est&gt;: 12.5") || strings.Contains(body, "Q4") {
+				t.Errorf("points missing or not capped:\n%s", body)
+			}
+			marks := map[string]string{"bar": "<rect", "line": "<circle"}[kind]
+			if n := strings.Count(body, marks); n != 2 {
+				t.Errorf("%d %s marks, want 2 (NULL skipped)", n, kind)
+			}
+			if kind == "line" && !strings.Contains(body, `d="M`) {
+				t.Errorf("no line path:\n%s", body)
+			}
+		})
+	}
+
+	rec, _ := get(t, "format=svg")
+	if !strings.Contains(rec.Body.String(), "<rect") {
+		t.Errorf("default chart is not a bar chart")
+	}
+	if _, err := get(t, "format=svg&chart=pie"); err == nil {
+		t.Error("expected error for unknown chart")
+	} else if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %v", err)
+	}
+}
+
+func TestWriteChart_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	writeChart(&buf, "empty", "line", nil)
+	if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+		t.Errorf("invalid SVG: %v\n%s", err, buf.String())
+	}
+}
@@ -0,0 +1,170 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings for the compress option.
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// provisionCompress checks the compress encodings and, if there are any,
+// declares that responses vary by Accept-Encoding.
+func (h *HTMLFromDuckDB) provisionCompress() error {
+	for _, encoding := range h.Compress {
+		if encoding != encodingBrotli && encoding != encodingGzip {
+			return fmt.Errorf("unknown compress encoding %q, must be br or gzip", encoding)
+		}
+	}
+	if len(h.Compress) > 0 {
+		h.negotiate("Accept-Encoding")
+	}
+	return nil
+}
+
+// negotiateEncoding picks the first compress encoding the request accepts
+// and remembers it for the response: notModified() and writeBody() use it
+// for the ETag and the body. It returns "" when the response goes out
+// uncompressed.
+func (h *HTMLFromDuckDB) negotiateEncoding(r *http.Request) string {
+	if len(h.Compress) == 0 {
+		return ""
+	}
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if name != "" {
+			accepted[name] = q > 0
+		}
+	}
+	for _, encoding := range h.Compress {
+		ok, listed := accepted[encoding]
+		if !listed {
+			ok = accepted["*"]
+		}
+		if ok {
+			if info := requestInfoFrom(r.Context()); info != nil {
+				info.encoding = encoding
+			}
+			return encoding
+		}
+	}
+	return ""
+}
+
+// encodingOf returns the encoding negotiateEncoding picked for r.
+func encodingOf(r *http.Request) string {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.encoding
+	}
+	return ""
+}
+
+// encodedETag gives a strong ETag a suffix for the content coding, the
+// same way Caddy's encode handler does, since each encoding is a different
+// representation.
+func encodedETag(etag, encoding string) string {
+	if encoding == "" || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// compressBody compresses body with encoding.
+func compressBody(encoding, body string) ([]byte, error) {
+	var buf bytes.Buffer
+	var zw interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case encodingBrotli:
+		zw = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	case encodingGzip:
+		zw = gzip.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+	if _, err := zw.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodedBodies holds the compressed copies of a cached body, made the first
+// time each encoding is asked for. It is shared by the copies of the cache
+// entry it belongs to.
+type encodedBodies struct {
+	mu     sync.Mutex
+	bodies map[string][]byte
+}
+
+func newEncodedBodies() *encodedBodies {
+	return &encodedBodies{bodies: make(map[string][]byte)}
+}
+
+// get returns body compressed with encoding. Compression runs without the
+// lock, so concurrent requests may both compress a body; the first one
+// stored is kept.
+func (e *encodedBodies) get(encoding, body string) ([]byte, error) {
+	e.mu.Lock()
+	b, ok := e.bodies[encoding]
+	e.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+	b, err := compressBody(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if stored, ok := e.bodies[encoding]; ok {
+		return stored, nil
+	}
+	e.bodies[encoding] = b
+	return b, nil
+}
+
+// writeBody writes a 200 response with body, compressed with the encoding
+// negotiateEncoding picked. cached, if not nil, holds compressed copies of
+// body to reuse. Content-Type is left to the caller.
+func (h *HTMLFromDuckDB) writeBody(w http.ResponseWriter, r *http.Request, body string, cached *encodedBodies) error {
+	out := []byte(body)
+	if encoding := encodingOf(r); encoding != "" {
+		var err error
+		if cached != nil {
+			out, err = cached.get(encoding, body)
+		} else {
+			out, err = compressBody(encoding, body)
+		}
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(out)
+	return err
+}
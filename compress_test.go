@@ -0,0 +1,246 @@
+package caddyhtmlduckdb
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Compress(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('w1', repeat('<p>record</p>', 100));
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE
+			SELECT repeat('<li>item</li>', 100) AS html;
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:           db,
+		Table:        "html",
+		IDColumn:     "id",
+		HTMLColumn:   "html",
+		IndexEnabled: true,
+		IndexMacro:   "render_index",
+		Compress:     []string{"br", "gzip"},
+		indexCache:   newLRUCache[indexPage](indexCacheSize, time.Hour),
+		logger:       zap.NewNop(),
+	}
+	if err := h.provisionCompress(); err != nil {
+		t.Fatal(err)
+	}
+	get := func(t *testing.T, path, acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		return rec
+	}
+	decode := func(t *testing.T, rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var r io.Reader = rec.Body
+		switch rec.Header().Get("Content-Encoding") {
+		case "br":
+			r = brotli.NewReader(rec.Body)
+		case "gzip":
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("gzip: %v", err)
+			}
+			r = zr
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return string(body)
+	}
+
+	want := strings.Repeat("<p>record</p>", 100)
+	plain := get(t, "/w1", "", "")
+	etag := plain.Header().Get("ETag")
+	if plain.Header().Get("Content-Encoding") != "" || plain.Body.String() != want {
+		t.Fatalf("uncompressed response: encoding %q", plain.Header().Get("Content-Encoding"))
+	}
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"gzip, deflate, br", "br"},
+		{"gzip", "gzip"},
+		{"br;q=0, gzip;q=0.5", "gzip"},
+		{"*", "br"},
+		{"deflate", ""},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			rec := get(t, "/w1", tt.acceptEncoding, "")
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+				t.Errorf("Content-Length = %s, body is %d bytes", rec.Header().Get("Content-Length"), rec.Body.Len())
+			}
+			if got := decode(t, rec); got != want {
+				t.Errorf("body = %q", got)
+			}
+			if got, want := rec.Header().Get("ETag"), encodedETag(etag, tt.encoding); got != want {
+				t.Errorf("ETag = %s, want %s", got, want)
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q", rec.Header().Get("Vary"))
+			}
+		})
+	}
+
+	t.Run("conditional request per encoding", func(t *testing.T) {
+		br := get(t, "/w1", "br", "")
+		if rec := get(t, "/w1", "br", br.Header().Get("ETag")); rec.Code != http.StatusNotModified {
+			t.Errorf("status = %d, want 304", rec.Code)
+		}
+		// A client switching encodings must not get a 304 for the other body
+		if rec := get(t, "/w1", "gzip", br.Header().Get("ETag")); rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200", rec.Code)
+		}
+		// Behind Caddy's encode, If-None-Match arrives without the suffix
+		if rec := get(t, "/w1", "br", etag); rec.Code != http.StatusNotModified {
+			t.Errorf("status = %d, want 304", rec.Code)
+		}
+	})
+
+	t.Run("index cache keeps compressed pages", func(t *testing.T) {
+		rec := get(t, "/", "br", "")
+		if got := decode(t, rec); got != strings.Repeat("<li>item</li>", 100) {
+			t.Fatalf("body = %q", got)
+		}
+		page, ok := h.indexCache.get("\x001")
+		if !ok || page.bodies == nil {
+			t.Fatal("index page not cached")
+		}
+		compressed := page.bodies.bodies["br"]
+		if compressed == nil {
+			t.Fatal("compressed page not cached")
+		}
+		if rec := get(t, "/", "br", ""); rec.Body.String() != string(compressed) {
+			t.Error("cached hit should reuse the compressed page")
+		}
+	})
+}
+
+func TestServeHTTP_CompressESI(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('page', '<main><esi:include src="/nav"/></main>'), ('nav', '<nav>menu</nav>');
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	h := &HTMLFromDuckDB{
+		db:              db,
+		Table:           "html",
+		IDColumn:        "id",
+		HTMLColumn:      "html",
+		ESI:             true,
+		IncludeMaxDepth: 5,
+		Compress:        []string{"gzip"},
+		logger:          zap.NewNop(),
+	}
+	r := httptest.NewRequest(http.MethodGet, "/page", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	body, _ := io.ReadAll(zr)
+	// The fragment is included uncompressed, and only the page compressed
+	if string(body) != "<main><nav>menu</nav></main>" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestEncodedBodies_Concurrent(t *testing.T) {
+	e := newEncodedBodies()
+	body := strings.Repeat("<p>compress me</p>", 1000)
+	got := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range got {
+		wg.Go(func() {
+			b, err := e.get("gzip", body)
+			if err != nil {
+				t.Error(err)
+			}
+			got[i] = b
+		})
+	}
+	wg.Wait()
+	// Every caller gets the copy that was stored
+	stored, _ := e.get("gzip", body)
+	for i, b := range got {
+		if &b[0] != &stored[0] {
+			t.Errorf("get %d returned a copy that wasn't kept", i)
+		}
+	}
+}
+
+func TestProvision_CompressErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &HTMLFromDuckDB{Table: "html", Compress: []string{"br", "zstd"}}
+	if err := h.Provision(ctx); err == nil {
+		h.Cleanup()
+		t.Error("expected Provision to fail for zstd")
+	}
+}
+
+func TestParseCompress(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		compress br gzip
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if len(h.Compress) != 2 || h.Compress[0] != "br" || h.Compress[1] != "gzip" {
+		t.Errorf("Compress = %v", h.Compress)
+	}
+}
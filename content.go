@@ -0,0 +1,111 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// immutableCacheControl is sent with content-addressed responses: a hash
+// URL always names the same bytes, so caches may keep them for a year
+// without revalidating.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// contentHash matches the hex SHA-256 digest in a content_path URL.
+var contentHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// contentURLStatement returns the statement that defines content_url() on
+// a connection. Macros call content_url(html) to link to a row's content
+// under content_path; it is a temporary macro, so it works on read-only
+// databases.
+func contentURLStatement(prefix string) string {
+	return fmt.Sprintf("CREATE OR REPLACE TEMP MACRO content_url(content) AS '%s' || sha256(content)",
+		escapeSQLString(prefix))
+}
+
+// contentURLPrefix returns the path content_url() puts before the hash, or
+// "" without content_path.
+func (h *HTMLFromDuckDB) contentURLPrefix() string {
+	if h.ContentPath == "" {
+		return ""
+	}
+	return h.endpointPath(h.ContentPath) + "/"
+}
+
+// contentQuery returns the query that finds the content with a given hash:
+// by content_hash_column if set, else by hashing html_column in every row.
+func (h *HTMLFromDuckDB) contentQuery() string {
+	column := sanitizeIdentifier(h.HTMLColumn)
+	hash := "sha256(" + column + ")"
+	if h.ContentHashColumn != "" {
+		hash = sanitizeIdentifier(h.ContentHashColumn)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+		column,
+		sanitizeIdentifier(h.Table),
+		hash)
+	if h.WhereClause != "" {
+		query += fmt.Sprintf(" AND (%s)", h.WhereClause)
+	}
+	if cond := h.goneCondition(); cond != "" {
+		query += " AND NOT " + cond
+	}
+	return query + " LIMIT 1"
+}
+
+// serveContent serves {base_path}/{content_path}/{hash}: the html_column of
+// the row whose content has that SHA-256 hash, exactly as stored (without
+// includes, ESI or meta tags), with a Cache-Control header marking it
+// immutable.
+func (h *HTMLFromDuckDB) serveContent(w http.ResponseWriter, r *http.Request, hash string) error {
+	if !contentHash.MatchString(hash) {
+		return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("invalid content hash: %q", hash))
+	}
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	query := h.contentQuery()
+	var content sql.NullString
+	start := time.Now()
+	err := h.queries(ctx, "content").QueryRowContext(ctx, tagQuery(ctx, query), hash).Scan(&content)
+	h.observeQuery(ctx, "content", query, time.Since(start))
+	if err == sql.ErrNoRows || (err == nil && !content.Valid) {
+		return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("content %s not found", hash))
+	}
+	if err != nil {
+		h.logFailure(r.Context(), "content query failed", zap.String("hash", hash), zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	// A stale content_hash_column must not get other bytes cached forever
+	// under this URL.
+	sum := sha256.Sum256([]byte(content.String))
+	if hex.EncodeToString(sum[:]) != hash {
+		h.log(r.Context()).Warn("content hash mismatch",
+			zap.String("hash", hash),
+			zap.String("column", h.ContentHashColumn))
+		return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("content %s not found", hash))
+	}
+	html := h.decodeContent(ctx, hash, content.String)
+
+	w.Header().Set("Cache-Control", immutableCacheControl)
+	h.negotiateEncoding(r)
+	if notModified(w, r, `"`+hash+`"`) {
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return h.writeBody(w, r, html, nil)
+}
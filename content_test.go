@@ -0,0 +1,173 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestServeHTTP_Content(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, hash VARCHAR, published BOOLEAN);
+		INSERT INTO html VALUES
+			('a', '<nav>menu</nav>', sha256('<nav>menu</nav>'), true),
+			('b', '<p>draft</p>', sha256('<p>draft</p>'), false),
+			('c', '<p>edited</p>', sha256('<p>original</p>'), true);
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	newHandler := func(hashColumn string) *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{
+			db:                db,
+			Table:             "html",
+			IDColumn:          "id",
+			HTMLColumn:        "html",
+			WhereClause:       "published",
+			ContentPath:       "_c",
+			ContentHashColumn: hashColumn,
+			logger:            zap.NewNop(),
+		}
+	}
+	get := func(t *testing.T, h *HTMLFromDuckDB, path, ifNoneMatch string) (*httptest.ResponseRecorder, int) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, r, emptyNextHandler())
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			return rec, herr.StatusCode
+		} else if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec, rec.Code
+	}
+
+	menu := sha256Hex("<nav>menu</nav>")
+	for _, hashColumn := range []string{"", "hash"} {
+		t.Run("hash column "+hashColumn, func(t *testing.T) {
+			h := newHandler(hashColumn)
+			rec, status := get(t, h, "/_c/"+menu, "")
+			if status != http.StatusOK || rec.Body.String() != "<nav>menu</nav>" {
+				t.Fatalf("status = %d, body = %q", status, rec.Body.String())
+			}
+			if got := rec.Header().Get("Cache-Control"); got != immutableCacheControl {
+				t.Errorf("Cache-Control = %q", got)
+			}
+			if got := rec.Header().Get("ETag"); got != `"`+menu+`"` {
+				t.Errorf("ETag = %s", got)
+			}
+			if _, status := get(t, h, "/_c/"+menu, `"`+menu+`"`); status != http.StatusNotModified {
+				t.Errorf("conditional status = %d, want 304", status)
+			}
+		})
+	}
+
+	h := newHandler("hash")
+	for name, path := range map[string]string{
+		"unknown hash":         "/_c/" + sha256Hex("<p>nothing</p>"),
+		"outside where_clause": "/_c/" + sha256Hex("<p>draft</p>"),
+		"stale hash column":    "/_c/" + sha256Hex("<p>original</p>"),
+		"not a hash":           "/_c/menu",
+		"uppercase hash":       "/_c/" + strings.ToUpper(menu),
+		"missing hash":         "/_c/",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, status := get(t, h, path, ""); status != http.StatusNotFound {
+				t.Errorf("status = %d, want 404", status)
+			}
+		})
+	}
+}
+
+func TestProvision_ContentURLMacro(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "works.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Macros using content_url() are created with a stand-in, which the
+	// handler's definition replaces on its connections.
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('nav', '<nav>menu</nav>');
+		CREATE TEMP MACRO content_url(content) AS '/elsewhere/' || sha256(content);
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE
+			SELECT '<a href="' || content_url(html) || '">nav</a>' AS html FROM html;
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	h := &HTMLFromDuckDB{
+		DatabasePath: path,
+		Table:        "html",
+		BasePath:     "/works",
+		IndexEnabled: true,
+		ContentPath:  "_c",
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/works/", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+	url := "/works/_c/" + sha256Hex("<nav>menu</nav>")
+	if want := `<a href="` + url + `">nav</a>`; rec.Body.String() != want {
+		t.Fatalf("index = %q, want %q", rec.Body.String(), want)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+	if rec.Body.String() != "<nav>menu</nav>" {
+		t.Errorf("content = %q", rec.Body.String())
+	}
+}
+
+func TestParseContentPath(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		content_path _c
+		content_hash_column html_sha256
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.ContentPath != "_c" || h.ContentHashColumn != "html_sha256" {
+		t.Errorf("ContentPath = %q, ContentHashColumn = %q", h.ContentPath, h.ContentHashColumn)
+	}
+}
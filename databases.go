@@ -0,0 +1,125 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// selectedDatabaseKey is the context key of the pool a request selected
+// with database_selector.
+type selectedDatabaseKey struct{}
+
+// provisionDatabases opens a read-only pool for each of the databases a
+// request can select, shared like the handler's own pool, with the same
+// init SQL, datasets and settings as cfg and settings.
+func (h *HTMLFromDuckDB) provisionDatabases(ctx context.Context, cfg poolConfig, settings poolSettings) error {
+	if len(h.Databases) == 0 && h.DatabaseSelector == "" {
+		return nil
+	}
+	if len(h.Databases) == 0 || h.DatabaseSelector == "" {
+		return fmt.Errorf("databases and database_selector must be set together")
+	}
+	if h.indexCache != nil || h.notFound != nil || h.esiCache != nil || h.tileCache != nil {
+		return fmt.Errorf("database_selector can't be combined with the index, negative, ESI or tile cache")
+	}
+	if name, ok := strings.CutPrefix(h.DatabaseSelector, "{http.request.header."); ok && strings.HasSuffix(name, "}") {
+		h.selectorHeader = strings.TrimSuffix(name, "}")
+	}
+
+	h.databases = make(map[string]*dbPool, len(h.Databases))
+	for name, path := range h.Databases {
+		if name == "" || path == "" {
+			h.releaseDatabases()
+			return fmt.Errorf("databases: a name and a path are required")
+		}
+		pool, err := h.acquireReadOnlyPool(ctx, cfg, settings, path)
+		if err != nil {
+			h.releaseDatabases()
+			return fmt.Errorf("failed to open database %s: %v", name, err)
+		}
+		h.databases[name] = pool
+	}
+	return nil
+}
+
+// acquireReadOnlyPool acquires a shared pool on the database file at path,
+// opened read-only but otherwise like the handler's own pool cfg.
+func (h *HTMLFromDuckDB) acquireReadOnlyPool(ctx context.Context, cfg poolConfig, settings poolSettings, path string) (*dbPool, error) {
+	cfg.connStr, cfg.attach, cfg.loadFrom = path+"?access_mode=READ_ONLY", "", ""
+	pool, _, err := h.acquirePoolWaiting(ctx, cfg, settings)
+	return pool, err
+}
+
+// releaseDatabases releases the pools of the selectable databases.
+func (h *HTMLFromDuckDB) releaseDatabases() {
+	for name, pool := range h.databases {
+		if err := releasePool(pool); err != nil {
+			h.logger.Warn("failed to release database", zap.String("database", name), zap.Error(err))
+		}
+	}
+	h.databases = nil
+}
+
+// selectDatabase resolves database_selector for r and, if it names one of
+// the databases, returns r with that database selected for its queries. An
+// empty value keeps the handler's own database; names outside databases
+// are rejected with 400.
+func (h *HTMLFromDuckDB) selectDatabase(r *http.Request) (*http.Request, error) {
+	if h.databases == nil {
+		return r, nil
+	}
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		repl = caddy.NewReplacer()
+	}
+	name := repl.ReplaceAll(h.DatabaseSelector, "")
+	if name == "" {
+		return r, nil
+	}
+	pool, ok := h.databases[name]
+	if !ok {
+		return r, caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("unknown database %q", name))
+	}
+	return r.WithContext(context.WithValue(r.Context(), selectedDatabaseKey{}, pool)), nil
+}
+
+// requestDatabase returns the pool the endpoint's queries for a request
+// should use: that of the database the request selected, or databaseFor.
+func (h *HTMLFromDuckDB) requestDatabase(ctx context.Context, endpoint string) *sql.DB {
+	pool, ok := ctx.Value(selectedDatabaseKey{}).(*dbPool)
+	if !ok {
+		return h.databaseFor(endpoint)
+	}
+	if h.AnalyticsPoolSize > 0 && analyticsEndpoints[endpoint] {
+		return pool.analytics
+	}
+	return pool.db
+}
+
+// parseDatabases parses a databases block:
+//
+//	databases {
+//	    <name> <path>
+//	}
+func parseDatabases(d *caddyfile.Dispenser) (map[string]string, error) {
+	databases := make(map[string]string)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		name := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		databases[name] = d.Val()
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+	}
+	return databases, nil
+}
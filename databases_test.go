@@ -0,0 +1,92 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestDatabaseSelector(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &HTMLFromDuckDB{
+		DatabasePath:     writeRecordsDB(t, map[string]string{"a": "<p>production</p>"}),
+		Table:            "html",
+		Databases:        map[string]string{"staging": writeRecordsDB(t, map[string]string{"a": "<p>staging</p>"})},
+		DatabaseSelector: "{http.request.header.X-Dataset}",
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	get := func(dataset string) (*httptest.ResponseRecorder, error) {
+		repl := caddy.NewReplacer()
+		repl.Set("http.request.header.X-Dataset", dataset)
+		r := httptest.NewRequest(http.MethodGet, "/a", nil)
+		r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, r, emptyNextHandler())
+		return rec, err
+	}
+
+	for dataset, want := range map[string]string{"": "<p>production</p>", "staging": "<p>staging</p>"} {
+		rec, err := get(dataset)
+		if err != nil {
+			t.Fatalf("%q: ServeHTTP error: %v", dataset, err)
+		}
+		if rec.Body.String() != want {
+			t.Errorf("%q: body = %q, want %q", dataset, rec.Body.String(), want)
+		}
+		if rec.Header().Get("Vary") != "X-Dataset" {
+			t.Errorf("%q: Vary = %q", dataset, rec.Header().Get("Vary"))
+		}
+	}
+
+	_, err := get("production")
+	if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown database: expected 400, got %v", err)
+	}
+}
+
+func TestProvision_DatabaseSelectorErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"no selector":    {Table: "html", Databases: map[string]string{"staging": "staging.duckdb"}},
+		"no databases":   {Table: "html", DatabaseSelector: "{http.request.header.X-Dataset}"},
+		"negative cache": {Table: "html", Databases: map[string]string{"staging": "staging.duckdb"}, DatabaseSelector: "x", NegativeCacheTTL: "1m"},
+		"missing file":   {Table: "html", Databases: map[string]string{"staging": filepath.Join(t.TempDir(), "none.duckdb")}, DatabaseSelector: "x"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseDatabases(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		databases {
+			staging /srv/staging/works.duckdb
+			next    /srv/next/works.duckdb
+		}
+		database_selector {http.request.header.X-Dataset}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if len(h.Databases) != 2 || h.Databases["staging"] != "/srv/staging/works.duckdb" || h.DatabaseSelector != "{http.request.header.X-Dataset}" {
+		t.Errorf("Databases = %v, DatabaseSelector = %q", h.Databases, h.DatabaseSelector)
+	}
+}
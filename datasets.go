@@ -0,0 +1,427 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Dataset is a view over external Parquet, CSV or JSON files, or an Iceberg
+// or Delta Lake table, so the content table (or anything a macro reads) can
+// be written by a pipeline rather than be a table in a DuckDB database.
+type Dataset struct {
+	// Name is the name of the view.
+	Name string `json:"name"`
+
+	// Path is a file, a glob such as /data/pages/*.parquet, a local
+	// directory (read recursively) or a URL DuckDB can read (https://,
+	// s3://, using the secrets set up by init_sql_file). For Iceberg and
+	// Delta Lake it is the table's location, or for a table in an Iceberg
+	// catalog its <namespace>.<table>.
+	Path string `json:"path"`
+
+	// Format is "parquet", "csv", "json", "iceberg" or "delta".
+	// Default: taken from the file extension
+	Format string `json:"format,omitempty"`
+
+	// Snapshot pins an Iceberg table to a snapshot ID. Without it, Iceberg
+	// and Delta Lake tables are pinned to the snapshot that is current when
+	// the pool opens, and follow the table every dataset_refresh_interval.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Catalog is the endpoint of the Iceberg REST catalog the table is in.
+	// Optional.
+	Catalog string `json:"catalog,omitempty"`
+
+	// Warehouse is the catalog's warehouse. Requires Catalog.
+	Warehouse string `json:"warehouse,omitempty"`
+}
+
+// datasetReaders maps file dataset formats to the DuckDB functions reading
+// them.
+var datasetReaders = map[string]string{
+	"parquet": "read_parquet",
+	"csv":     "read_csv",
+	"json":    "read_json",
+}
+
+// lakeFormat describes how a table format with snapshots is read.
+type lakeFormat struct {
+	// setup returns the statements run on every connection before the view
+	// is created, such as loading the extension. Optional.
+	setup func(ds Dataset) []string
+	// latest returns a query for the ID of the table's current snapshot, or
+	// "" if it can't be pinned automatically.
+	latest func(ds Dataset) string
+	// attach returns a statement run before the view is created, after the
+	// snapshot is resolved, or "". Optional.
+	attach func(ds Dataset) string
+	// scan returns the query the view selects, reading the table at
+	// snapshot, or at the current snapshot if snapshot is "".
+	scan func(ds Dataset, snapshot string) string
+}
+
+// lakeFormats are the table formats besides plain files.
+var lakeFormats = map[string]lakeFormat{
+	"iceberg": {
+		setup: func(ds Dataset) []string {
+			stmts := []string{"INSTALL iceberg", "LOAD iceberg"}
+			if ds.Catalog != "" {
+				stmts = append(stmts, fmt.Sprintf("ATTACH IF NOT EXISTS '%s' AS %s (TYPE iceberg, ENDPOINT '%s')",
+					escapeSQLString(ds.Warehouse), ds.Name+"_catalog", escapeSQLString(ds.Catalog)))
+			}
+			return stmts
+		},
+		latest: func(ds Dataset) string {
+			if ds.Catalog != "" {
+				return ""
+			}
+			return fmt.Sprintf("SELECT snapshot_id::VARCHAR FROM iceberg_snapshots('%s') ORDER BY sequence_number DESC LIMIT 1",
+				escapeSQLString(ds.Path))
+		},
+		scan: func(ds Dataset, snapshot string) string {
+			if ds.Catalog != "" {
+				query := fmt.Sprintf("SELECT * FROM %s.%s", ds.Name+"_catalog", sanitizeQualifiedIdentifier(ds.Path))
+				if snapshot != "" {
+					query += " AT (VERSION => " + snapshot + ")"
+				}
+				return query
+			}
+			if snapshot == "" {
+				return fmt.Sprintf("SELECT * FROM iceberg_scan('%s')", escapeSQLString(ds.Path))
+			}
+			return fmt.Sprintf("SELECT * FROM iceberg_scan('%s', snapshot_from_id => %s)", escapeSQLString(ds.Path), snapshot)
+		},
+	},
+	// Delta Lake tables are attached with PIN_SNAPSHOT, which pins them for
+	// the whole pool, since attached databases are shared by its
+	// connections. The latest version is only read from the log, before
+	// attaching, to notice changes.
+	"delta": {
+		setup: func(ds Dataset) []string {
+			return []string{"INSTALL delta", "LOAD delta"}
+		},
+		latest: func(ds Dataset) string {
+			return fmt.Sprintf(`SELECT max(regexp_extract(file, '(\d+)\.json$', 1)::BIGINT)::VARCHAR FROM glob('%s')`,
+				escapeSQLString(strings.TrimSuffix(ds.Path, "/")+"/_delta_log/*.json"))
+		},
+		attach: func(ds Dataset) string {
+			return fmt.Sprintf("ATTACH IF NOT EXISTS '%s' AS %s (TYPE delta, PIN_SNAPSHOT)", escapeSQLString(ds.Path), ds.Name+"_delta")
+		},
+		scan: func(ds Dataset, snapshot string) string {
+			return "SELECT * FROM " + ds.Name + "_delta"
+		},
+	},
+}
+
+// datasetFormat infers a dataset's format from its path's extension,
+// ignoring a compression suffix.
+func datasetFormat(path string) string {
+	path = strings.ToLower(path)
+	for _, suffix := range []string{".gz", ".zst"} {
+		path = strings.TrimSuffix(path, suffix)
+	}
+	switch filepath.Ext(path) {
+	case ".parquet":
+		return "parquet"
+	case ".csv", ".tsv":
+		return "csv"
+	case ".json", ".jsonl", ".ndjson":
+		return "json"
+	}
+	return ""
+}
+
+// checkDatasets validates the datasets and fills in their formats.
+func checkDatasets(datasets []Dataset) error {
+	seen := make(map[string]bool)
+	for i := range datasets {
+		ds := &datasets[i]
+		if ds.Name == "" || sanitizeIdentifier(ds.Name) != ds.Name {
+			return fmt.Errorf("invalid dataset name %q", ds.Name)
+		}
+		if seen[ds.Name] {
+			return fmt.Errorf("duplicate dataset %q", ds.Name)
+		}
+		seen[ds.Name] = true
+		if ds.Path == "" {
+			return fmt.Errorf("dataset %s: path is required", ds.Name)
+		}
+		if ds.Format == "" {
+			ds.Format = datasetFormat(ds.Path)
+		}
+		_, lake := lakeFormats[ds.Format]
+		if _, ok := datasetReaders[ds.Format]; !ok && !lake {
+			return fmt.Errorf("dataset %s: unknown format of %q; set parquet, csv, json, iceberg or delta", ds.Name, ds.Path)
+		}
+		switch {
+		case (ds.Snapshot != "" || ds.Catalog != "") && ds.Format != "iceberg":
+			return fmt.Errorf("dataset %s: snapshot and catalog are only supported for iceberg", ds.Name)
+		case ds.Snapshot != "" && strings.Trim(ds.Snapshot, "0123456789") != "":
+			return fmt.Errorf("dataset %s: invalid snapshot %q", ds.Name, ds.Snapshot)
+		case ds.Warehouse != "" && ds.Catalog == "":
+			return fmt.Errorf("dataset %s: warehouse requires catalog", ds.Name)
+		}
+	}
+	return nil
+}
+
+// pinsSnapshots reports whether any dataset is pinned to the snapshot
+// current when the pool opens, and so can be refreshed.
+func pinsSnapshots(datasets []Dataset) bool {
+	for _, ds := range datasets {
+		if lf, ok := lakeFormats[ds.Format]; ok && ds.Snapshot == "" && lf.latest(ds) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeDatasets encodes checked datasets for poolConfig, which has to stay
+// comparable.
+func encodeDatasets(datasets []Dataset) string {
+	if len(datasets) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(datasets)
+	return string(data)
+}
+
+// datasetViews creates a pool's dataset views on each of its connections.
+// The views are temporary, since the database may be read-only, so every
+// connection needs its own. Snapshots are resolved on the first connection
+// and shared by the rest, so the whole pool reads the same version of each
+// table.
+type datasetViews struct {
+	datasets []Dataset
+
+	mu    sync.Mutex
+	pins  map[string]string
+	stmts []string
+}
+
+// newDatasetViews decodes a poolConfig's datasets.
+func newDatasetViews(encoded string) (*datasetViews, error) {
+	var datasets []Dataset
+	if err := json.Unmarshal([]byte(encoded), &datasets); err != nil {
+		return nil, err
+	}
+	return &datasetViews{datasets: datasets}, nil
+}
+
+// create creates the views on a new connection.
+func (v *datasetViews) create(ctx context.Context, execer driver.ExecerContext) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, ds := range v.datasets {
+		if lf, ok := lakeFormats[ds.Format]; ok && lf.setup != nil {
+			for _, stmt := range lf.setup(ds) {
+				if _, err := execer.ExecContext(ctx, stmt, nil); err != nil {
+					return fmt.Errorf("dataset %s: %v", ds.Name, err)
+				}
+			}
+		}
+	}
+	if v.stmts == nil {
+		pins := make(map[string]string)
+		var stmts []string
+		for _, ds := range v.datasets {
+			dsStmts, err := v.statements(ctx, execer, ds, pins)
+			if err != nil {
+				return fmt.Errorf("dataset %s: %v", ds.Name, err)
+			}
+			stmts = append(stmts, dsStmts...)
+		}
+		v.pins, v.stmts = pins, stmts
+	}
+	for _, stmt := range v.stmts {
+		if _, err := execer.ExecContext(ctx, stmt, nil); err != nil {
+			return fmt.Errorf("dataset view failed: %v\nStatement: %s", err, truncateForLog(stmt, 200))
+		}
+	}
+	return nil
+}
+
+// statements returns the statements creating ds's view, resolving and
+// recording its snapshot if it is pinned when the pool opens.
+func (v *datasetViews) statements(ctx context.Context, execer driver.ExecerContext, ds Dataset, pins map[string]string) ([]string, error) {
+	var stmts []string
+	var query string
+	if lf, ok := lakeFormats[ds.Format]; ok {
+		snapshot := ds.Snapshot
+		if latest := lf.latest(ds); snapshot == "" && latest != "" {
+			var err error
+			snapshot, err = queryString(ctx, execer, latest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read current snapshot: %v", err)
+			}
+		}
+		if snapshot != "" {
+			pins[ds.Name] = snapshot
+		}
+		if lf.attach != nil {
+			stmts = append(stmts, lf.attach(ds))
+		}
+		query = lf.scan(ds, snapshot)
+	} else {
+		path := ds.Path
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			path = filepath.Join(path, "**", "*."+ds.Format)
+		}
+		query = fmt.Sprintf("SELECT * FROM %s('%s', union_by_name = true)", datasetReaders[ds.Format], escapeSQLString(path))
+	}
+	return append(stmts, fmt.Sprintf("CREATE OR REPLACE TEMP VIEW %s AS %s", ds.Name, query)), nil
+}
+
+// queryString runs a query returning a single string on a connection being
+// initialized.
+func queryString(ctx context.Context, execer driver.ExecerContext, query string) (string, error) {
+	queryer, ok := execer.(driver.QueryerContext)
+	if !ok {
+		return "", fmt.Errorf("connection can't run queries")
+	}
+	rows, err := queryer.QueryContext(ctx, query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, len(rows.Columns()))
+	if err := rows.Next(dest); err == io.EOF {
+		return "", fmt.Errorf("table has no snapshots")
+	} else if err != nil {
+		return "", err
+	}
+	s, ok := dest[0].(string)
+	if !ok {
+		return "", fmt.Errorf("table has no snapshots")
+	}
+	return s, nil
+}
+
+// snapshots returns the snapshots the pool's datasets are pinned to.
+func (v *datasetViews) snapshots() map[string]string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.pins
+}
+
+// provisionDatasetRefresh opens a pool of the handler's own and every
+// dataset_refresh_interval opens another, swapping it in when a dataset's
+// current snapshot changed. The swap uses the same machinery as read
+// replicas.
+func (h *HTMLFromDuckDB) provisionDatasetRefresh(ctx context.Context, cfg poolConfig, settings poolSettings, interval time.Duration) error {
+	rep := &replica{interval: interval, pool: cfg, settings: settings}
+	pool, err := openPool(cfg)
+	if err != nil {
+		return err
+	}
+	pool.apply(settings)
+	rep.current.Store(pool)
+	h.replica = rep
+
+	refreshCtx, stop := context.WithCancel(ctx)
+	rep.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-refreshCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := h.refreshDatasets(refreshCtx); err != nil && refreshCtx.Err() == nil {
+				h.logger.Warn("refreshing datasets failed", zap.Error(err))
+			}
+		}
+	}()
+	return nil
+}
+
+// refreshDatasets opens a pool pinned to the datasets' current snapshots and
+// swaps it in if they differ from the ones in use. It reports whether it
+// did.
+func (h *HTMLFromDuckDB) refreshDatasets(ctx context.Context) (bool, error) {
+	rep := h.replica
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	pool, err := openPool(rep.pool)
+	if err != nil {
+		return false, err
+	}
+	old := rep.current.Load()
+	if maps.Equal(pool.views.snapshots(), old.views.snapshots()) {
+		pool.Destruct()
+		return false, nil
+	}
+	pool.apply(rep.settings)
+	rep.current.Store(pool)
+	time.AfterFunc(replicaCloseDelay, func() { old.Destruct() })
+	h.logger.Info("datasets refreshed",
+		zap.Any("snapshots", pool.views.snapshots()),
+		zap.Any("previous", old.views.snapshots()))
+	h.flushCaches()
+	h.notify(eventDatabaseSwap, map[string]any{"datasets": pool.views.snapshots()})
+	h.audit(ctx, auditDatabaseSwap, "dataset refresh", nil, map[string]any{"datasets": pool.views.snapshots()})
+	return true, nil
+}
+
+// parseDatasets parses a datasets block:
+//
+//	datasets {
+//	    <name> <path> [<format>] [{
+//	        snapshot <id>
+//	        catalog <endpoint>
+//	        warehouse <name>
+//	    }]
+//	}
+func parseDatasets(d *caddyfile.Dispenser) ([]Dataset, error) {
+	var datasets []Dataset
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		ds := Dataset{Name: d.Val()}
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		ds.Path = d.Val()
+		if d.NextArg() {
+			ds.Format = strings.ToLower(d.Val())
+		}
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "snapshot":
+				if d.NextArg() {
+					ds.Snapshot = d.Val()
+				}
+				// No error if empty - allows {$SNAPSHOT:} with empty default
+			case "catalog":
+				if !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				ds.Catalog = d.Val()
+			case "warehouse":
+				if !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				ds.Warehouse = d.Val()
+			default:
+				return nil, d.Errf("unrecognized dataset subdirective: %s", d.Val())
+			}
+		}
+		datasets = append(datasets, ds)
+	}
+	return datasets, nil
+}
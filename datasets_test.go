@@ -0,0 +1,223 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestDatasets(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	// A pipeline's output: Parquet files in nested directories and a CSV.
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "pages", "2026"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		fmt.Sprintf("COPY (SELECT 'a' AS id, '<p>a</p>' AS html) TO '%s'", filepath.Join(dir, "pages", "a.parquet")),
+		fmt.Sprintf("COPY (SELECT 'b' AS id, '<p>b</p>' AS html) TO '%s'", filepath.Join(dir, "pages", "2026", "b.parquet")),
+		fmt.Sprintf("COPY (SELECT 'a' AS id, 'Ann' AS author) TO '%s' (HEADER)", filepath.Join(dir, "authors.csv")),
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	h := &HTMLFromDuckDB{
+		Table: "pages",
+		Datasets: []Dataset{
+			{Name: "pages", Path: filepath.Join(dir, "pages"), Format: "parquet"},
+			{Name: "authors", Path: filepath.Join(dir, "*.csv")},
+		},
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	for _, id := range []string{"a", "b"} {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, nil), emptyNextHandler()); err != nil {
+			t.Fatalf("/%s: %v", id, err)
+		}
+		if want := "<p>" + id + "</p>"; rec.Body.String() != want {
+			t.Errorf("/%s = %q, want %q", id, rec.Body.String(), want)
+		}
+	}
+	var author string
+	if err := h.database().QueryRow("SELECT author FROM authors WHERE id = 'a'").Scan(&author); err != nil || author != "Ann" {
+		t.Errorf("authors: %q, %v", author, err)
+	}
+}
+
+func TestDatasetRefresh(t *testing.T) {
+	replicaCloseDelay = 0
+	defer func() { replicaCloseDelay = time.Minute }()
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	// A stand-in for a table format with snapshots: every Parquet file in
+	// dir is a snapshot, the last one by name is current.
+	dir := t.TempDir()
+	lakeFormats["test"] = lakeFormat{
+		latest: func(ds Dataset) string {
+			return fmt.Sprintf("SELECT max(file) FROM glob('%s/*.parquet')", ds.Path)
+		},
+		scan: func(ds Dataset, snapshot string) string {
+			return fmt.Sprintf("SELECT * FROM read_parquet('%s')", snapshot)
+		},
+	}
+	defer delete(lakeFormats, "test")
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	writeSnapshot := func(name, ids string) {
+		t.Helper()
+		stmt := fmt.Sprintf("COPY (SELECT id, '<p>' || id || '</p>' AS html FROM unnest(%s) AS t(id)) TO '%s'", ids, filepath.Join(dir, name))
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSnapshot("1.parquet", "['a']")
+
+	h := &HTMLFromDuckDB{
+		Table:                  "pages",
+		Datasets:               []Dataset{{Name: "pages", Path: dir, Format: "test"}},
+		DatasetRefreshInterval: "1h",
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler()); err != nil {
+			return http.StatusNotFound
+		}
+		return rec.Code
+	}
+
+	if code := get("/a"); code != http.StatusOK {
+		t.Errorf("/a = %d", code)
+	}
+	writeSnapshot("2.parquet", "['a', 'b']")
+	if code := get("/b"); code == http.StatusOK {
+		t.Error("new snapshot served before refreshing")
+	}
+	if swapped, err := h.refreshDatasets(ctx); err != nil || !swapped {
+		t.Fatalf("refreshDatasets = %v, %v", swapped, err)
+	}
+	if code := get("/b"); code != http.StatusOK {
+		t.Errorf("/b after refresh = %d", code)
+	}
+	if got := h.currentPool().views.snapshots()["pages"]; got != filepath.Join(dir, "2.parquet") {
+		t.Errorf("pinned snapshot = %q", got)
+	}
+	if swapped, err := h.refreshDatasets(ctx); err != nil || swapped {
+		t.Errorf("unchanged snapshot refreshed: %v, %v", swapped, err)
+	}
+}
+
+func TestDatasetStatements(t *testing.T) {
+	views := &datasetViews{}
+	var got []string
+	for _, ds := range []Dataset{
+		{Name: "events", Path: "s3://bucket/events/*.ndjson.gz", Format: "json"},
+		{Name: "sales", Path: "https://example.com/it's.csv", Format: "csv"},
+		{Name: "orders", Path: "s3://lake/orders", Format: "iceberg", Snapshot: "42"},
+		{Name: "items", Path: "shop.items", Format: "iceberg", Catalog: "https://catalog", Warehouse: "wh"},
+	} {
+		stmts, err := views.statements(context.Background(), nil, ds, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, stmts...)
+	}
+	want := []string{
+		"CREATE OR REPLACE TEMP VIEW events AS SELECT * FROM read_json('s3://bucket/events/*.ndjson.gz', union_by_name = true)",
+		"CREATE OR REPLACE TEMP VIEW sales AS SELECT * FROM read_csv('https://example.com/it''s.csv', union_by_name = true)",
+		"CREATE OR REPLACE TEMP VIEW orders AS SELECT * FROM iceberg_scan('s3://lake/orders', snapshot_from_id => 42)",
+		"CREATE OR REPLACE TEMP VIEW items AS SELECT * FROM items_catalog.shop.items",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	delta := Dataset{Name: "posts", Path: "s3://lake/posts/", Format: "delta"}
+	if got := lakeFormats["delta"].latest(delta); got != `SELECT max(regexp_extract(file, '(\d+)\.json$', 1)::BIGINT)::VARCHAR FROM glob('s3://lake/posts/_delta_log/*.json')` {
+		t.Errorf("delta latest = %s", got)
+	}
+}
+
+func TestCheckDatasets(t *testing.T) {
+	datasets := []Dataset{{Name: "p", Path: "/data/p.csv.gz"}, {Name: "q", Path: "s3://lake/q", Format: "delta"}}
+	if err := checkDatasets(datasets); err != nil || datasets[0].Format != "csv" {
+		t.Errorf("checkDatasets = %v, format = %q", err, datasets[0].Format)
+	}
+	if !pinsSnapshots(datasets) {
+		t.Error("delta dataset not pinned")
+	}
+
+	for name, datasets := range map[string][]Dataset{
+		"bad name":           {{Name: "my-pages", Path: "p.parquet"}},
+		"duplicate":          {{Name: "p", Path: "p.parquet"}, {Name: "p", Path: "q.parquet"}},
+		"no path":            {{Name: "p"}},
+		"unknown format":     {{Name: "p", Path: "p.xlsx"}},
+		"bad format":         {{Name: "p", Path: "p.parquet", Format: "avro"}},
+		"snapshot of a file": {{Name: "p", Path: "p.parquet", Snapshot: "1"}},
+		"bad snapshot":       {{Name: "p", Path: "s3://lake/p", Format: "iceberg", Snapshot: "1; DROP"}},
+		"no catalog":         {{Name: "p", Path: "s.p", Format: "iceberg", Warehouse: "wh"}},
+	} {
+		if err := checkDatasets(datasets); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseDatasets(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		datasets {
+			pages /data/pages
+			events s3://bucket/events/*.json.gz JSON
+			orders s3://lake/orders iceberg {
+				snapshot 42
+			}
+			items shop.items iceberg {
+				catalog https://catalog
+				warehouse wh
+			}
+		}
+		dataset_refresh_interval 5m
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	want := []Dataset{
+		{Name: "pages", Path: "/data/pages"},
+		{Name: "events", Path: "s3://bucket/events/*.json.gz", Format: "json"},
+		{Name: "orders", Path: "s3://lake/orders", Format: "iceberg", Snapshot: "42"},
+		{Name: "items", Path: "shop.items", Format: "iceberg", Catalog: "https://catalog", Warehouse: "wh"},
+	}
+	if fmt.Sprint(h.Datasets) != fmt.Sprint(want) || h.DatasetRefreshInterval != "5m" {
+		t.Errorf("Datasets = %v, want %v; DatasetRefreshInterval = %q", h.Datasets, want, h.DatasetRefreshInterval)
+	}
+}
@@ -0,0 +1,190 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(Defaults{})
+	httpcaddyfile.RegisterGlobalOption("html_from_duckdb", parseGlobalDefaults)
+}
+
+// Defaults is the html_from_duckdb app, which holds handler options
+// declared once, in the Caddyfile's global options block, for every
+// html_from_duckdb handler in the config.
+type Defaults struct {
+	// Handler holds the default options, in the handler's JSON form. A
+	// handler takes each option it leaves unset from here: one its JSON
+	// has no key for and its overrides don't name. Blocks such as backup
+	// are taken whole, not merged. The name option isn't inherited.
+	Handler json.RawMessage `json:"handler,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Defaults) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "html_from_duckdb",
+		New: func() caddy.Module { return new(Defaults) },
+	}
+}
+
+// Provision checks that the defaults are handler options.
+func (a *Defaults) Provision(ctx caddy.Context) error {
+	if _, err := a.handler(); err != nil {
+		return fmt.Errorf("html_from_duckdb defaults: %w", err)
+	}
+	return nil
+}
+
+// Start does nothing; the handlers do the work.
+func (a *Defaults) Start() error { return nil }
+
+// Stop does nothing.
+func (a *Defaults) Stop() error { return nil }
+
+// handler decodes a fresh copy of the defaults, so handlers don't share
+// slices, maps or blocks with each other.
+func (a *Defaults) handler() (*HTMLFromDuckDB, error) {
+	h := new(HTMLFromDuckDB)
+	if len(a.Handler) == 0 {
+		return h, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(a.Handler))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// parseGlobalDefaults parses the html_from_duckdb global option, a block
+// of handler subdirectives:
+//
+//	{
+//	    html_from_duckdb {
+//	        connection_pool_size 20
+//	        query_timeout 10s
+//	    }
+//	}
+func parseGlobalDefaults(d *caddyfile.Dispenser, existing any) (any, error) {
+	if existing != nil {
+		return nil, d.Err("html_from_duckdb global option given more than once")
+	}
+	var h HTMLFromDuckDB
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		return nil, err
+	}
+	if h.Name != "" {
+		return nil, d.Err("name must be unique per handler and can't be a global default")
+	}
+	return httpcaddyfile.App{
+		Name:  "html_from_duckdb",
+		Value: caddyconfig.JSON(Defaults{Handler: caddyconfig.JSON(h, nil)}, nil),
+	}, nil
+}
+
+// inheritDefaults fills the options h leaves unset from the html_from_duckdb
+// app, if the config has one, and records them in h.inherited.
+func (h *HTMLFromDuckDB) inheritDefaults(ctx caddy.Context) error {
+	app, err := ctx.AppIfConfigured("html_from_duckdb")
+	if errors.Is(err, caddy.ErrNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defaults, err := app.(*Defaults).handler()
+	if err != nil {
+		return err
+	}
+	h.inherit(defaults)
+	return nil
+}
+
+// inherit copies the options h leaves unset from defaults.
+func (h *HTMLFromDuckDB) inherit(defaults *HTMLFromDuckDB) {
+	dst, src := reflect.ValueOf(h).Elem(), reflect.ValueOf(defaults).Elem()
+	for i := range dst.NumField() {
+		field := dst.Type().Field(i)
+		if !field.IsExported() || field.Name == "Name" || field.Name == "Overrides" || !dst.Field(i).IsZero() || src.Field(i).IsZero() {
+			continue
+		}
+		option := jsonOption(field)
+		if h.configured[option] {
+			continue
+		}
+		dst.Field(i).Set(src.Field(i))
+		if h.inherited == nil {
+			h.inherited = make(map[string]bool)
+		}
+		h.inherited[option] = true
+	}
+}
+
+// UnmarshalJSON decodes the handler and records the options its JSON has
+// keys for, along with its overrides, so that inherit leaves them alone
+// even when they are false, 0 or "".
+func (h *HTMLFromDuckDB) UnmarshalJSON(data []byte) error {
+	type handler HTMLFromDuckDB // without this method
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode((*handler)(h)); err != nil {
+		return err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	h.configured = make(map[string]bool, len(keys)+len(h.Overrides))
+	for option := range keys {
+		h.configured[option] = true
+	}
+	for _, option := range h.Overrides {
+		h.configured[option] = true
+	}
+	return nil
+}
+
+// addOverrides adds those of the Caddyfile subdirectives in set that leave
+// their option false, 0 or "" to h.Overrides, since the handler's JSON
+// omits them. Options that a later subdirective set after all are dropped.
+func (h *HTMLFromDuckDB) addOverrides(set []string) {
+	zero := make(map[string]bool)
+	v := reflect.ValueOf(h).Elem()
+	for i := range v.NumField() {
+		if field := v.Type().Field(i); field.IsExported() && v.Field(i).IsZero() {
+			zero[jsonOption(field)] = true
+		}
+	}
+	var overrides []string
+	for _, option := range append(h.Overrides, set...) {
+		if zero[option] && !slices.Contains(overrides, option) {
+			overrides = append(overrides, option)
+		}
+	}
+	h.Overrides = overrides
+}
+
+// jsonOption returns the JSON name of a handler option, which is also its
+// Caddyfile subdirective.
+func jsonOption(field reflect.StructField) string {
+	option, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return option
+}
+
+// Interface guards
+var (
+	_ caddy.App         = (*Defaults)(nil)
+	_ caddy.Provisioner = (*Defaults)(nil)
+)
@@ -0,0 +1,173 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	_ "github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func TestInherit(t *testing.T) {
+	h := &HTMLFromDuckDB{Name: "site", ConnectionPoolSize: 2, Vary: []string{"Accept"}}
+	h.inherit(&HTMLFromDuckDB{
+		Name:               "global",
+		ConnectionPoolSize: 20,
+		QueryTimeout:       "10s",
+		Vary:               []string{"Cookie"},
+		IndexCacheTTL:      "1m",
+		LoadShedding:       &LoadShedding{LowPriorityLimit: 3},
+	})
+	if h.Name != "site" || h.ConnectionPoolSize != 2 || h.Vary[0] != "Accept" {
+		t.Errorf("site options overridden: %+v", h)
+	}
+	if h.QueryTimeout != "10s" || h.IndexCacheTTL != "1m" || h.LoadShedding == nil || h.LoadShedding.LowPriorityLimit != 3 {
+		t.Errorf("defaults not inherited: %+v", h)
+	}
+	if !h.inherited["query_timeout"] || h.inherited["connection_pool_size"] {
+		t.Errorf("inherited = %v", h.inherited)
+	}
+}
+
+func TestInherit_Overrides(t *testing.T) {
+	defaults := &HTMLFromDuckDB{}
+	if err := defaults.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`html_from_duckdb {
+		index_enabled true
+		negative_cache_ttl 1m
+		index_version_query "SELECT 1"
+		query_timeout 10s
+		conn_max_idle_time 5m
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A Caddyfile site turns options off; its JSON form has to say so.
+	site := &HTMLFromDuckDB{}
+	if err := site.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`html_from_duckdb {
+		preset blog
+		index_enabled false
+		index_version_query ""
+		conn_max_idle_time
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(site.Overrides, []string{"index_enabled", "index_version_query"}) {
+		t.Errorf("overrides = %v", site.Overrides)
+	}
+	var h HTMLFromDuckDB
+	if err := json.Unmarshal(caddyconfig.JSON(site, nil), &h); err != nil {
+		t.Fatal(err)
+	}
+	h.inherit(defaults)
+	if h.IndexEnabled || h.IndexVersionQuery != "" {
+		t.Errorf("site overrides replaced: index_enabled = %v, index_version_query = %q", h.IndexEnabled, h.IndexVersionQuery)
+	}
+	if h.QueryTimeout != "10s" || h.ConnMaxIdleTime != "5m" {
+		t.Errorf("defaults not inherited: query_timeout = %q, conn_max_idle_time = %q", h.QueryTimeout, h.ConnMaxIdleTime)
+	}
+
+	// In JSON, a key is enough.
+	h = HTMLFromDuckDB{}
+	if err := json.Unmarshal([]byte(`{"table": "html", "index_enabled": false, "negative_cache_ttl": ""}`), &h); err != nil {
+		t.Fatal(err)
+	}
+	h.inherit(defaults)
+	if h.IndexEnabled || h.NegativeCacheTTL != "" || h.IndexVersionQuery != "SELECT 1" {
+		t.Errorf("index_enabled = %v, negative_cache_ttl = %q, index_version_query = %q", h.IndexEnabled, h.NegativeCacheTTL, h.IndexVersionQuery)
+	}
+	if err := json.Unmarshal([]byte(`{"no_such_option": 1}`), &h); err == nil {
+		t.Error("expected error for unknown option")
+	}
+
+	// A site with session_variables can turn off the caches it inherits.
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	h = HTMLFromDuckDB{}
+	if err := json.Unmarshal([]byte(`{"table": "html", "session_variables": {"user": "{http.auth.user.id}"}, "negative_cache_ttl": "", "index_version_query": ""}`), &h); err != nil {
+		t.Fatal(err)
+	}
+	h.inherit(defaults)
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	h.Cleanup()
+}
+
+func TestGlobalDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	adapt := func(caddyfile string) ([]byte, error) {
+		cfg, _, err := caddyconfig.GetAdapter("caddyfile").Adapt([]byte(caddyfile), nil)
+		return cfg, err
+	}
+	// The site needs the table, database and a harmless index_cache_ttl
+	// from the global block; Validate would reject the TTL if set locally.
+	cfg, err := adapt(`{
+		html_from_duckdb {
+			database_path ` + path + `
+			table html
+			index_cache_ttl 1m
+		}
+	}
+	:0 {
+		html_from_duckdb
+	}`)
+	if err != nil {
+		t.Fatalf("Adapt: %v", err)
+	}
+	if !strings.Contains(string(cfg), `"html_from_duckdb":{"handler":{`) {
+		t.Errorf("config = %s", cfg)
+	}
+	var config struct {
+		Apps struct {
+			Defaults Defaults `json:"html_from_duckdb"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal(cfg, &config); err != nil {
+		t.Fatal(err)
+	}
+	defaults, err := config.Apps.Defaults.handler()
+	if err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	h := &HTMLFromDuckDB{}
+	h.inherit(defaults)
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	if err := h.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	for caddyfile, want := range map[string]string{
+		"{\n\thtml_from_duckdb {\n\t\tname shared\n\t}\n}\n:0 {\n}":     "can't be a global default",
+		"{\n\thtml_from_duckdb {\n\t\tread_only maybe\n\t}\n}\n:0 {\n}": `invalid read_only: "maybe"`,
+	} {
+		if _, err := adapt(caddyfile); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+
+	app := &Defaults{Handler: json.RawMessage(`{"no_such_option": 1}`)}
+	if err := app.Provision(caddy.Context{}); err == nil {
+		t.Error("expected error for unknown option")
+	}
+}
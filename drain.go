@@ -0,0 +1,126 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// drainCancelWait is how long Cleanup waits for requests to return after
+// canceling them, before closing the pool regardless.
+const drainCancelWait = 5 * time.Second
+
+// drainState tracks a handler's in-flight requests, so Cleanup can let them
+// finish before the pool they use is closed.
+type drainState struct {
+	wg     sync.WaitGroup
+	active atomic.Int64
+
+	// ctx is canceled to abort requests still running after the grace
+	// period.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	draining bool
+}
+
+// newDrainState returns the drain state for a newly provisioned handler.
+func newDrainState() *drainState {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &drainState{ctx: ctx, cancel: cancel}
+}
+
+// beginRequest counts r as in flight and returns it with a context that is
+// canceled if the handler is shut down before it finishes. The returned
+// function must be called when the request is done. Once draining has
+// started no new requests are accepted.
+func (h *HTMLFromDuckDB) beginRequest(r *http.Request) (*http.Request, func(), error) {
+	d := h.drain
+	if d == nil {
+		return r, func() {}, nil
+	}
+	d.mu.Lock()
+	if d.draining {
+		d.mu.Unlock()
+		return r, nil, caddyhttp.Error(http.StatusServiceUnavailable, errShuttingDown)
+	}
+	d.wg.Add(1)
+	d.mu.Unlock()
+	d.active.Add(1)
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	stop := context.AfterFunc(d.ctx, func() { cancel(errShuttingDown) })
+	return r.WithContext(ctx), func() {
+		stop()
+		cancel(nil)
+		d.active.Add(-1)
+		d.wg.Done()
+	}, nil
+}
+
+// drainRequests stops accepting requests and waits up to
+// shutdown_grace_period for those in flight, then cancels the rest and
+// waits briefly for them to return.
+func (h *HTMLFromDuckDB) drainRequests() {
+	d := h.drain
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	if d.draining {
+		d.mu.Unlock()
+		return
+	}
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	if n := d.active.Load(); n > 0 {
+		h.logger.Info("draining in-flight requests",
+			zap.Int64("requests", n),
+			zap.Duration("grace_period", h.drainGrace))
+	}
+
+	grace := time.NewTimer(h.drainGrace)
+	defer grace.Stop()
+	select {
+	case <-done:
+		d.cancel()
+		return
+	case <-grace.C:
+	}
+
+	h.logger.Warn("canceling requests still in flight after grace period",
+		zap.Int64("requests", d.active.Load()))
+	d.cancel()
+	select {
+	case <-done:
+	case <-time.After(drainCancelWait):
+		h.logger.Error("closing database with requests still in flight",
+			zap.Int64("requests", d.active.Load()))
+	}
+}
+
+// closesPool reports whether releasing the handler's database will close
+// a pool, rather than leave it to another handler (such as the one that
+// replaced this one in a config reload).
+func (h *HTMLFromDuckDB) closesPool() bool {
+	if h.replica != nil {
+		return true
+	}
+	if h.pool == nil {
+		return false
+	}
+	refs, ok := pools.References(h.pool.key)
+	return !ok || refs <= 1
+}
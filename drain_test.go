@@ -0,0 +1,123 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestDrainRequests(t *testing.T) {
+	newHandler := func(grace time.Duration) *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{drain: newDrainState(), drainGrace: grace, logger: zap.NewNop()}
+	}
+
+	t.Run("waits for requests", func(t *testing.T) {
+		h := newHandler(time.Second)
+		r, done, err := h.beginRequest(httptest.NewRequest(http.MethodGet, "/a", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ctxErr error
+		time.AfterFunc(50*time.Millisecond, func() {
+			ctxErr = r.Context().Err()
+			done()
+		})
+		start := time.Now()
+		h.drainRequests()
+		if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > 900*time.Millisecond {
+			t.Errorf("drained after %v", elapsed)
+		}
+		if ctxErr != nil {
+			t.Errorf("request canceled within the grace period: %v", ctxErr)
+		}
+	})
+
+	t.Run("cancels stragglers", func(t *testing.T) {
+		h := newHandler(20 * time.Millisecond)
+		r, done, err := h.beginRequest(httptest.NewRequest(http.MethodGet, "/a", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			<-r.Context().Done()
+			done()
+		}()
+		h.drainRequests()
+		if !errors.Is(r.Context().Err(), context.Canceled) {
+			t.Errorf("ctx.Err() = %v", r.Context().Err())
+		}
+	})
+
+	t.Run("refuses new requests", func(t *testing.T) {
+		h := newHandler(time.Second)
+		h.drainRequests()
+		_, _, err := h.beginRequest(httptest.NewRequest(http.MethodGet, "/a", nil))
+		var herr caddyhttp.HandlerError
+		if !errors.As(err, &herr) || herr.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestCleanup_SharedPoolSkipsDrain(t *testing.T) {
+	readOnly := false
+	// Each handler is from a config of its own, as on a reload.
+	newHandler := func() *HTMLFromDuckDB {
+		ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+		t.Cleanup(cancel)
+		h := &HTMLFromDuckDB{Table: "html", ReadOnly: &readOnly, ShutdownGracePeriod: "1h"}
+		if err := h.Provision(ctx); err != nil {
+			t.Fatalf("Provision: %v", err)
+		}
+		return h
+	}
+	old, current := newHandler(), newHandler()
+	defer current.Cleanup()
+
+	if old.closesPool() {
+		t.Fatal("pool is shared with the new handler")
+	}
+	// An in-flight request on the old handler must not hold up the reload
+	r, done, err := old.beginRequest(httptest.NewRequest(http.MethodGet, "/a", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	finished := make(chan struct{})
+	go func() {
+		old.Cleanup()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cleanup waited for a request on a shared pool")
+	}
+	if r.Context().Err() != nil {
+		t.Error("request on a shared pool should not be canceled")
+	}
+	if !current.closesPool() {
+		t.Error("last handler should close the pool")
+	}
+}
+
+func TestParseShutdownGracePeriod(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		shutdown_grace_period 30s
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.ShutdownGracePeriod != "30s" {
+		t.Errorf("ShutdownGracePeriod = %q", h.ShutdownGracePeriod)
+	}
+}
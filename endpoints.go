@@ -0,0 +1,121 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// matchableEndpoints are the internal endpoints whose routing can be
+// replaced with an EndpointMatcher.
+var matchableEndpoints = []string{"health", "openapi", "table", "geojson", "tile", "api", "query", "export", "changes", "search"}
+
+// EndpointMatcher routes requests to an internal endpoint with a Caddy
+// matcher set instead of the built-in path check.
+type EndpointMatcher struct {
+	// Endpoint is one of health, openapi, table, geojson, tile, api, query, export, changes or search.
+	Endpoint string `json:"endpoint"`
+
+	// MatcherSetRaw is the matcher set, in the same form as a route's
+	// match. All matchers must match.
+	MatcherSetRaw caddy.ModuleMap `json:"match,omitempty" caddy:"namespace=http.matchers"`
+}
+
+// provisionEndpointMatchers loads the configured endpoint matchers.
+func (h *HTMLFromDuckDB) provisionEndpointMatchers(ctx caddy.Context) error {
+	if len(h.EndpointMatchers) == 0 {
+		return nil
+	}
+	h.endpointMatch = make(map[string]caddyhttp.MatcherSet, len(h.EndpointMatchers))
+	for i := range h.EndpointMatchers {
+		em := &h.EndpointMatchers[i]
+		if !slices.Contains(matchableEndpoints, em.Endpoint) {
+			return fmt.Errorf("unknown endpoint %q, must be one of %s",
+				em.Endpoint, strings.Join(matchableEndpoints, ", "))
+		}
+		// Loaded one by one rather than with ctx.LoadModule, which doesn't
+		// recognize a ModuleMap when json.RawMessage is an alias of
+		// jsontext.Value (Go 1.27).
+		var set caddyhttp.MatcherSet
+		for name, raw := range em.MatcherSetRaw {
+			mod, err := ctx.LoadModuleByID("http.matchers."+name, raw)
+			if err != nil {
+				return fmt.Errorf("loading %s endpoint matcher %s: %v", em.Endpoint, name, err)
+			}
+			matcher, ok := mod.(caddyhttp.RequestMatcher)
+			if !ok {
+				return fmt.Errorf("%s endpoint: module %s is not a request matcher", em.Endpoint, name)
+			}
+			set = append(set, matcher)
+		}
+		h.endpointMatch[em.Endpoint] = set
+	}
+	return nil
+}
+
+// atEndpoint reports whether r is for an internal endpoint: by its
+// configured matcher if there is one, else by its path name under
+// base_path. Endpoints with subpaths also match below their path.
+func (h *HTMLFromDuckDB) atEndpoint(r *http.Request, endpoint, name string, subpaths bool) bool {
+	if set, ok := h.endpointMatch[endpoint]; ok {
+		return set.Match(r)
+	}
+	rest, ok := h.endpointRest(r, name)
+	return ok && (subpaths || rest == "")
+}
+
+// endpointRest reports whether the request path is at or below the endpoint
+// path name and returns the rest of the path. The path is the one left by
+// earlier rewrites; behind handle_path or uri strip_prefix, where base_path
+// was already removed, the endpoint is matched without it.
+func (h *HTMLFromDuckDB) endpointRest(r *http.Request, name string) (string, bool) {
+	bases := []string{h.BasePath}
+	if h.basePathStripped(r) {
+		bases = append(bases, "")
+	}
+	for _, base := range bases {
+		rest, found := strings.CutPrefix(r.URL.Path, base+"/"+name)
+		if found && (rest == "" || rest[0] == '/') {
+			return rest, true
+		}
+	}
+	return "", false
+}
+
+// basePathStripped reports whether base_path is set and the request path
+// was rewritten to one outside it, as handle_path does.
+func (h *HTMLFromDuckDB) basePathStripped(r *http.Request) bool {
+	if h.BasePath == "" || strings.HasPrefix(r.URL.Path, h.BasePath+"/") {
+		return false
+	}
+	orig, ok := r.Context().Value(caddyhttp.OriginalRequestCtxKey).(http.Request)
+	return ok && orig.URL != nil && orig.URL.Path != r.URL.Path
+}
+
+// parseEndpointMatcher parses an endpoint directive:
+//
+//	endpoint health {
+//	    path /healthz
+//	    remote_ip private_ranges
+//	}
+func parseEndpointMatcher(d *caddyfile.Dispenser) (EndpointMatcher, error) {
+	var em EndpointMatcher
+	if !d.NextArg() {
+		return em, d.ArgErr()
+	}
+	em.Endpoint = d.Val()
+	set, err := caddyhttp.ParseCaddyfileNestedMatcherSet(d)
+	if err != nil {
+		return em, err
+	}
+	if len(set) == 0 {
+		return em, d.Errf("endpoint %s needs at least one matcher", em.Endpoint)
+	}
+	em.MatcherSetRaw = set
+	return em, nil
+}
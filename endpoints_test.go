@@ -0,0 +1,158 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// caddyRequest returns a request carrying the context values Caddy's server
+// sets up: the replacer and the original request before any rewrites.
+func caddyRequest(method, path, origPath string) *http.Request {
+	r := httptest.NewRequest(method, path, nil)
+	orig := httptest.NewRequest(method, origPath, nil)
+	ctx := context.WithValue(r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+	ctx = context.WithValue(ctx, caddyhttp.OriginalRequestCtxKey, *orig)
+	return r.WithContext(ctx)
+}
+
+func TestServeHTTP_StrippedBasePath(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('_health', '<p>a record</p>'), ('a', '<p>a</p>')`); err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:            db,
+		Table:         "html",
+		HTMLColumn:    "html",
+		IDColumn:      "id",
+		BasePath:      "/docs",
+		HealthEnabled: true,
+		HealthPath:    "_health",
+		APIPath:       "api",
+		APIPageSize:   10,
+		logger:        zap.NewNop(),
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		origPath    string
+		contentType string
+	}{
+		{"full path", "/docs/_health", "/docs/_health", "application/json"},
+		{"behind handle_path", "/_health", "/docs/_health", "application/json"},
+		{"not rewritten", "/_health", "/_health", "text/html; charset=utf-8"},
+		{"api behind handle_path", "/api/a", "/docs/api/a", "application/vnd.api+json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if err := h.ServeHTTP(rec, caddyRequest(http.MethodGet, tt.path, tt.origPath), emptyNextHandler()); err != nil {
+				t.Fatalf("ServeHTTP error: %v", err)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q (body %q)", got, tt.contentType, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestProvision_EndpointMatchers(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	readOnly := false
+
+	h := &HTMLFromDuckDB{
+		Table:         "html",
+		ReadOnly:      &readOnly,
+		HealthEnabled: true,
+		SearchEnabled: true,
+		EndpointMatchers: []EndpointMatcher{
+			{Endpoint: "health", MatcherSetRaw: caddy.ModuleMap{"path": json.RawMessage(`["/healthz"]`)}},
+			{Endpoint: "search", MatcherSetRaw: caddy.ModuleMap{
+				"path":   json.RawMessage(`["/find"]`),
+				"method": json.RawMessage(`["GET"]`),
+			}},
+		},
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	tests := []struct {
+		method   string
+		path     string
+		endpoint string
+	}{
+		{http.MethodGet, "/healthz", "health"},
+		{http.MethodGet, "/_health", ""},
+		{http.MethodGet, "/find?q=x", "search"},
+		{http.MethodPost, "/find?q=x", ""},
+		{http.MethodGet, "/page?q=x", ""},
+	}
+	for _, tt := range tests {
+		r := caddyRequest(tt.method, tt.path, tt.path)
+		for _, endpoint := range []string{"health", "search"} {
+			if got := h.endpointMatch[endpoint].Match(r); got != (endpoint == tt.endpoint) {
+				t.Errorf("%s %s: %s matcher = %v", tt.method, tt.path, endpoint, got)
+			}
+		}
+	}
+
+	h2 := &HTMLFromDuckDB{
+		Table:    "html",
+		ReadOnly: &readOnly,
+		EndpointMatchers: []EndpointMatcher{
+			{Endpoint: "records", MatcherSetRaw: caddy.ModuleMap{"path": json.RawMessage(`["/r"]`)}},
+		},
+	}
+	if err := h2.Provision(ctx); err == nil {
+		h2.Cleanup()
+		t.Error("expected error for unknown endpoint")
+	}
+}
+
+func TestParseEndpointMatcher(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		table html
+		endpoint health {
+			path /healthz
+			remote_ip 10.0.0.0/8
+		}
+	}`)
+	var h HTMLFromDuckDB
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if len(h.EndpointMatchers) != 1 {
+		t.Fatalf("expected one endpoint matcher, got %+v", h.EndpointMatchers)
+	}
+	em := h.EndpointMatchers[0]
+	if em.Endpoint != "health" || em.MatcherSetRaw["path"] == nil || em.MatcherSetRaw["remote_ip"] == nil {
+		t.Errorf("unexpected endpoint matcher %s %v", em.Endpoint, em.MatcherSetRaw)
+	}
+
+	d = caddyfile.NewTestDispenser(`html_from_duckdb {
+		endpoint health
+	}`)
+	if err := h.UnmarshalCaddyfile(d); err == nil {
+		t.Error("expected error for endpoint without matchers")
+	}
+}
@@ -0,0 +1,164 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// The supported subset of ESI: include (with alt and onerror="continue"),
+// remove, and <!--esi ...--> comments, whose content is kept.
+var (
+	esiInclude   = regexp.MustCompile(`<esi:include\s([^>]*?)/?>(?:\s*</esi:include>)?`)
+	esiRemove    = regexp.MustCompile(`(?s)<esi:remove>.*?</esi:remove>`)
+	esiComment   = regexp.MustCompile(`(?s)<!--esi(.*?)-->`)
+	esiAttribute = regexp.MustCompile(`([a-z]+)\s*=\s*"([^"]*)"`)
+)
+
+type esiDepthKey struct{}
+
+// processESI resolves the ESI tags in html, served for r. Includes are
+// answered by this handler as GET subrequests for src (relative to r's
+// URL), and may contain ESI themselves up to include_max_depth levels.
+func (h *HTMLFromDuckDB) processESI(r *http.Request, html string) string {
+	if !h.ESI || !strings.Contains(html, "<esi:") && !strings.Contains(html, "<!--esi") {
+		return html
+	}
+	html = esiComment.ReplaceAllString(html, "$1")
+	html = esiRemove.ReplaceAllString(html, "")
+
+	var b strings.Builder
+	last := 0
+	for _, m := range esiInclude.FindAllStringSubmatchIndex(html, -1) {
+		b.WriteString(html[last:m[0]])
+		b.WriteString(h.esiInclude(r, html[m[2]:m[3]]))
+		last = m[1]
+	}
+	b.WriteString(html[last:])
+	return b.String()
+}
+
+// esiInclude renders one <esi:include> tag with the given attributes: src,
+// then alt if src fails. When both fail the tag is dropped if onerror is
+// "continue", and replaced by an HTML comment otherwise.
+func (h *HTMLFromDuckDB) esiInclude(r *http.Request, attributes string) string {
+	attrs := make(map[string]string)
+	for _, m := range esiAttribute.FindAllStringSubmatch(attributes, -1) {
+		attrs[m[1]] = m[2]
+	}
+	src := attrs["src"]
+
+	for _, target := range []string{src, attrs["alt"]} {
+		if target == "" {
+			continue
+		}
+		html, err := h.esiFetch(r, target)
+		if err == nil {
+			return html
+		}
+		h.log(r.Context()).Warn("esi include failed",
+			zap.String("src", target),
+			zap.String("page", r.URL.Path),
+			zap.Error(err))
+	}
+	if attrs["onerror"] == "continue" {
+		return ""
+	}
+	return fmt.Sprintf("<!-- esi:include %q failed -->", strings.ReplaceAll(src, "--", ""))
+}
+
+// esiFetch returns the body of this handler's response to a GET for src,
+// from the ESI cache if enabled. Only 200 responses are used.
+func (h *HTMLFromDuckDB) esiFetch(r *http.Request, src string) (string, error) {
+	u, err := r.URL.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", fmt.Errorf("src must be on this site")
+	}
+	key := u.RequestURI()
+	if h.esiCache != nil {
+		if entry, ok := h.esiCache.get(key); ok {
+			requestInfoFrom(r.Context()).addTags(entry.tags...)
+			return entry.html, nil
+		}
+	}
+
+	depth, _ := r.Context().Value(esiDepthKey{}).(int)
+	if depth >= h.IncludeMaxDepth {
+		return "", fmt.Errorf("nested too deep")
+	}
+
+	// The subrequest gets its own requestInfo, so it doesn't overwrite the
+	// page's ID; its query time still counts towards the page, and its
+	// queries carry the page's request ID.
+	info := &requestInfo{requestID: requestIDFrom(r.Context())}
+	ctx := context.WithValue(r.Context(), esiDepthKey{}, depth+1)
+	ctx = context.WithValue(ctx, requestInfoKey{}, info)
+	sub := r.Clone(ctx)
+	sub.Method = http.MethodGet
+	sub.URL = &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}
+	sub.RequestURI = key
+	sub.Body = http.NoBody
+	sub.ContentLength = 0
+	// Fragments are cached and shared between viewers, so they're fetched
+	// without the viewer's credentials.
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "Range", "Accept-Encoding", "Authorization", "Cookie"} {
+		sub.Header.Del(name)
+	}
+
+	rec := &esiResponse{header: make(http.Header)}
+	err = h.serveHTTP(rec, sub)
+	requestInfoFrom(r.Context()).addQueryTime(info.queryTime)
+	if err != nil {
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			return "", fmt.Errorf("status %d: %v", herr.StatusCode, herr.Err)
+		}
+		return "", err
+	}
+	if rec.status != http.StatusOK {
+		return "", fmt.Errorf("status %d", rec.status)
+	}
+
+	// The page depends on the fragment, so it carries the fragment's tags
+	html := rec.body.String()
+	requestInfoFrom(r.Context()).addTags(info.tags...)
+	if h.esiCache != nil {
+		h.esiCache.add(key, esiEntry{html: html, tags: info.tags})
+	}
+	return html, nil
+}
+
+// esiResponse buffers the response to an ESI subrequest.
+type esiResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *esiResponse) Header() http.Header { return rec.header }
+
+func (rec *esiResponse) WriteHeader(status int) {
+	// Informational responses such as early hints precede the real status
+	if status < 200 {
+		return
+	}
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *esiResponse) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
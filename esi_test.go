@@ -0,0 +1,115 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_ESI(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES
+			('page', '<body><esi:include src="/site/_fragment/news"/><main>page</main></body>'),
+			('news', '<aside>news 1</aside>'),
+			('fallback', '<p><esi:include src="missing" alt="/site/_fragment/news" /></p>'),
+			('broken', '<p><esi:include src="missing"/><esi:include src="gone" onerror="continue"/></p>'),
+			('markup', '<p><esi:remove><a href="/news">news</a></esi:remove><!--esi <b>live</b> --></p>'),
+			('self', '<p><esi:include src="/site/self"/></p>'),
+			('external', '<p><esi:include src="https://example.com/x" onerror="continue"/></p>'),
+			('report', '<p><esi:include src="/site/_query?sql=SELECT+42+AS+answer"/></p>'),
+			('record', '<p><esi:include src="/site/news"/></p>');
+		CREATE MACRO page_assets(id := '') AS TABLE SELECT '/app.js' AS url, 'script' AS "as";
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:              db,
+		Table:           "html",
+		HTMLColumn:      "html",
+		IDColumn:        "id",
+		BasePath:        "/site",
+		FragmentPath:    "_fragment",
+		QueryPath:       "_query",
+		AuthTokens:      []string{"secret"},
+		ESI:             true,
+		IncludeMaxDepth: 2,
+		esiCache:        newLRUCache[esiEntry](esiCacheSize, time.Hour),
+		logger:          zap.NewNop(),
+	}
+	get := func(path string, header ...string) string {
+		t.Helper()
+		rec := &hintsRecorder{ResponseRecorder: httptest.NewRecorder()}
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return rec.Body.String()
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/site/page", `<body><aside>news 1</aside><main>page</main></body>`},
+		{"/site/fallback", `<p><aside>news 1</aside></p>`},
+		{"/site/broken", `<p><!-- esi:include "missing" failed --></p>`},
+		{"/site/markup", `<p> <b>live</b> </p>`},
+		{"/site/self", `<p><p><p><!-- esi:include "/site/self" failed --></p></p></p>`},
+		{"/site/external", `<p></p>`},
+	}
+	for _, tt := range tests {
+		if got := get(tt.path); got != tt.want {
+			t.Errorf("GET %s = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		if _, err := db.Exec(`UPDATE html SET html = '<aside>news 2</aside>' WHERE id = 'news'`); err != nil {
+			t.Fatal(err)
+		}
+		if got := get("/site/page"); got != `<body><aside>news 1</aside><main>page</main></body>` {
+			t.Errorf("expected cached fragment, got %q", got)
+		}
+		h.flushCaches()
+		if got := get("/site/page"); got != `<body><aside>news 2</aside><main>page</main></body>` {
+			t.Errorf("expected fresh fragment after flush, got %q", got)
+		}
+	})
+	t.Run("credentials", func(t *testing.T) {
+		// Fragments are shared through the cache, so the viewer's
+		// credentials must not unlock protected endpoints for them
+		want := `<p><!-- esi:include "/site/_query?sql=SELECT+42+AS+answer" failed --></p>`
+		if got := get("/site/report", "Authorization", "Bearer secret"); got != want {
+			t.Errorf("authorized GET = %q, want %q", got, want)
+		}
+		if got := get("/site/report"); got != want {
+			t.Errorf("anonymous GET = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("early hints", func(t *testing.T) {
+		h.PreloadMacro = "page_assets"
+		h.EarlyHints = true
+		defer func() { h.PreloadMacro, h.EarlyHints = "", false }()
+		h.flushCaches()
+		if got := get("/site/record"); got != `<p><aside>news 2</aside></p>` {
+			t.Errorf("GET /site/record = %q", got)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package caddyhtmlduckdb
+
+import (
+	"reflect"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// expandPlaceholders replaces global placeholders such as
+// {env.DATABASE_PATH} in every string option, including those of nested
+// blocks, so JSON configs can take any option from the environment as
+// Caddyfiles do with {$DATABASE_PATH}. Placeholders Caddy doesn't know at
+// startup, such as the request placeholders of database_selector, and
+// braces in SQL are left as they are.
+func (h *HTMLFromDuckDB) expandPlaceholders() {
+	expandStrings(reflect.ValueOf(h).Elem(), caddy.NewReplacer())
+}
+
+// expandStrings expands the placeholders in the strings in v.
+func expandStrings(v reflect.Value, repl *caddy.Replacer) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(repl.ReplaceKnown(v.String(), ""))
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			expandStrings(v.Elem(), repl)
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				expandStrings(v.Field(i), repl)
+			}
+		}
+	case reflect.Slice:
+		// Byte slices are module configs (json.RawMessage), which their
+		// modules expand if they want to.
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		for i := range v.Len() {
+			expandStrings(v.Index(i), repl)
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(repl.ReplaceKnown(iter.Value().String(), "")).Convert(v.Type().Elem()))
+		}
+	}
+}
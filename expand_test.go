@@ -0,0 +1,66 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	t.Setenv("HTML_DUCKDB_TEST_TABLE", "pages")
+	t.Setenv("HTML_DUCKDB_TEST_LANG", "sv")
+	t.Setenv("HTML_DUCKDB_TEST_TTL", "2m")
+
+	h := &HTMLFromDuckDB{
+		Table:            "{env.HTML_DUCKDB_TEST_TABLE}",
+		WhereClause:      "lang = '{env.HTML_DUCKDB_TEST_LANG}' AND meta = {'k': 1}",
+		DatabaseSelector: "{http.request.host}",
+		NegativeCacheTTL: "{env.HTML_DUCKDB_TEST_TTL}",
+		Vary:             []string{"X-{env.HTML_DUCKDB_TEST_LANG}"},
+		FilterParams:     []FilterParam{{Param: "lang", Column: "{env.HTML_DUCKDB_TEST_LANG}_lang"}},
+		LoadShedding:     &LoadShedding{LowPriorityLimit: 1},
+	}
+	h.expandPlaceholders()
+
+	for _, tc := range []struct{ name, got, want string }{
+		{"table", h.Table, "pages"},
+		{"where_clause", h.WhereClause, "lang = 'sv' AND meta = {'k': 1}"},
+		{"database_selector", h.DatabaseSelector, "{http.request.host}"},
+		{"negative_cache_ttl", h.NegativeCacheTTL, "2m"},
+		{"vary", h.Vary[0], "X-sv"},
+		{"filter_params", h.FilterParams[0].Column, "sv_lang"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestProvision_ExpandsPlaceholders(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE pages (id VARCHAR, html VARCHAR)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	t.Setenv("HTML_DUCKDB_TEST_DIR", dir)
+
+	h := &HTMLFromDuckDB{DatabasePath: "{env.HTML_DUCKDB_TEST_DIR}/content.duckdb", Table: "pages"}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	if h.DatabasePath != path {
+		t.Errorf("DatabasePath = %q, want %q", h.DatabasePath, path)
+	}
+}
@@ -0,0 +1,259 @@
+package caddyhtmlduckdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// experimentCookieMaxAge is how long an experiment cookie keeps a client in
+// its variant.
+const experimentCookieMaxAge = 30 * 24 * 60 * 60
+
+// experimentHeader is the response header naming the variants a page was
+// rendered with, as <experiment>=<variant>.
+const experimentHeader = "X-Experiment"
+
+// Experiment splits record or index page requests between variants that
+// render with different macros. A client is assigned a variant by a hash of
+// its IP address and the salt, so it sees the same one on every request,
+// and with a cookie the assignment sticks when the address changes.
+type Experiment struct {
+	// Name identifies the experiment in the response header and the
+	// {duckdb.experiment.<name>} placeholder.
+	Name string `json:"name"`
+
+	// Salt is mixed into the hash, so that experiments split clients
+	// independently of each other. Changing it reshuffles the clients
+	// without a cookie.
+	Salt string `json:"salt,omitempty"`
+
+	// Cookie is the name of a cookie that remembers the variant. Optional.
+	Cookie string `json:"cookie,omitempty"`
+
+	// Variants are the alternatives, at least two.
+	Variants []ExperimentVariant `json:"variants"`
+}
+
+// ExperimentVariant is one alternative of an experiment.
+type ExperimentVariant struct {
+	// Name identifies the variant.
+	Name string `json:"name"`
+
+	// Weight is the variant's share of clients, relative to the others.
+	// Default: 1
+	Weight int `json:"weight,omitempty"`
+
+	// RecordMacro renders record pages in this variant. Empty keeps the
+	// handler's record_macro (or table).
+	RecordMacro string `json:"record_macro,omitempty"`
+
+	// IndexMacro renders index pages in this variant. Empty keeps the
+	// handler's index_macro.
+	IndexMacro string `json:"index_macro,omitempty"`
+}
+
+// macro returns the variant's macro for pages of kind "record" or "index".
+func (v ExperimentVariant) macro(kind string) string {
+	if kind == "record" {
+		return v.RecordMacro
+	}
+	return v.IndexMacro
+}
+
+// varies reports whether any variant of e has a macro for pages of kind.
+func (e *Experiment) varies(kind string) bool {
+	return slices.ContainsFunc(e.Variants, func(v ExperimentVariant) bool { return v.macro(kind) != "" })
+}
+
+// checkExperiments validates the experiments and fills in default weights.
+// Each page kind may only be varied by one experiment, so a page's macro
+// is never contested.
+func checkExperiments(experiments []Experiment) error {
+	seen := make(map[string]bool)
+	varied := make(map[string]string)
+	for i := range experiments {
+		e := &experiments[i]
+		if e.Name == "" || sanitizeIdentifier(e.Name) != e.Name {
+			return fmt.Errorf("invalid experiment name %q", e.Name)
+		}
+		if seen[e.Name] {
+			return fmt.Errorf("duplicate experiment %q", e.Name)
+		}
+		seen[e.Name] = true
+		if len(e.Variants) < 2 {
+			return fmt.Errorf("experiment %s needs at least two variants", e.Name)
+		}
+		total := 0
+		names := make(map[string]bool)
+		for j := range e.Variants {
+			v := &e.Variants[j]
+			if v.Name == "" || names[v.Name] {
+				return fmt.Errorf("experiment %s: invalid or duplicate variant %q", e.Name, v.Name)
+			}
+			names[v.Name] = true
+			if v.Weight == 0 {
+				v.Weight = 1
+			}
+			if v.Weight < 0 {
+				return fmt.Errorf("experiment %s: variant %s has a negative weight", e.Name, v.Name)
+			}
+			total += v.Weight
+		}
+		if total == 0 {
+			return fmt.Errorf("experiment %s: all variants have weight 0", e.Name)
+		}
+		if !e.varies("record") && !e.varies("index") {
+			return fmt.Errorf("experiment %s has no variant with a record_macro or index_macro", e.Name)
+		}
+		for _, kind := range []string{"record", "index"} {
+			if !e.varies(kind) {
+				continue
+			}
+			if other, ok := varied[kind]; ok {
+				return fmt.Errorf("experiments %s and %s both vary the %s macro", other, e.Name, kind)
+			}
+			varied[kind] = e.Name
+		}
+	}
+	return nil
+}
+
+// assign returns the variant r is in: the one its cookie names, or else
+// the one its client address hashes to, which the cookie then remembers.
+func (e *Experiment) assign(w http.ResponseWriter, r *http.Request) ExperimentVariant {
+	if e.Cookie != "" {
+		addVary(w, "Cookie")
+		if c, err := r.Cookie(e.Cookie); err == nil {
+			if i := slices.IndexFunc(e.Variants, func(v ExperimentVariant) bool { return v.Name == c.Value }); i >= 0 {
+				return e.Variants[i]
+			}
+		}
+	}
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	sum := sha256.Sum256([]byte(e.Salt + "\x00" + e.Name + "\x00" + clientAddr(r)))
+	bucket := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	variant := e.Variants[len(e.Variants)-1]
+	for _, v := range e.Variants {
+		if bucket < v.Weight {
+			variant = v
+			break
+		}
+		bucket -= v.Weight
+	}
+	if e.Cookie != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     e.Cookie,
+			Value:    variant.Name,
+			Path:     "/",
+			MaxAge:   experimentCookieMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return variant
+}
+
+// experimentMacro returns the macro that renders r, a page of kind "record"
+// or "index" otherwise rendered with macro. The experiment varying kind,
+// if any, assigns r a variant, which is announced in the X-Experiment
+// header and the {duckdb.experiment.<name>} placeholder.
+func (h *HTMLFromDuckDB) experimentMacro(w http.ResponseWriter, r *http.Request, kind, macro string) string {
+	for i := range h.Experiments {
+		e := &h.Experiments[i]
+		if !e.varies(kind) {
+			continue
+		}
+		v := e.assign(w, r)
+		w.Header().Add(experimentHeader, e.Name+"="+v.Name)
+		if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+			repl.Set("duckdb.experiment."+e.Name, v.Name)
+		}
+		if m := v.macro(kind); m != "" {
+			return m
+		}
+		return macro
+	}
+	return macro
+}
+
+// parseExperiments parses an experiments block:
+//
+//	experiments {
+//	    <name> {
+//	        salt <salt>
+//	        cookie <cookie name>
+//	        variant <name> [<weight>] [{
+//	            record_macro <macro>
+//	            index_macro <macro>
+//	        }]
+//	    }
+//	}
+func parseExperiments(d *caddyfile.Dispenser) ([]Experiment, error) {
+	var experiments []Experiment
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		e := Experiment{Name: d.Val()}
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "salt":
+				if d.NextArg() {
+					e.Salt = d.Val()
+				}
+				// No error if empty - allows {$EXPERIMENT_SALT:} with empty default
+
+			case "cookie":
+				if !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				e.Cookie = d.Val()
+
+			case "variant":
+				if !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				v := ExperimentVariant{Name: d.Val()}
+				if d.NextArg() {
+					if err := checkIntArg(d, "variant weight", &v.Weight); err != nil {
+						return nil, err
+					}
+				}
+				if d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				for vn := d.Nesting(); d.NextBlock(vn); {
+					switch d.Val() {
+					case "record_macro":
+						if !d.NextArg() {
+							return nil, d.ArgErr()
+						}
+						v.RecordMacro = d.Val()
+					case "index_macro":
+						if !d.NextArg() {
+							return nil, d.ArgErr()
+						}
+						v.IndexMacro = d.Val()
+					default:
+						return nil, d.Errf("unrecognized variant subdirective: %s", d.Val())
+					}
+				}
+				e.Variants = append(e.Variants, v)
+
+			default:
+				return nil, d.Errf("unrecognized experiment subdirective: %s", d.Val())
+			}
+		}
+		experiments = append(experiments, e)
+	}
+	return experiments, nil
+}
@@ -0,0 +1,165 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestExperiments(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html AS SELECT 'a' AS id, '<p>table</p>' AS html;
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE SELECT '<p>index</p>' AS html;
+		CREATE MACRO render_record_b(id) AS TABLE SELECT '<p>b</p>' AS html;
+		CREATE MACRO render_index_b(page := 1, base_path := '') AS TABLE SELECT '<p>index b</p>' AS html;
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	h := &HTMLFromDuckDB{
+		DatabasePath: path,
+		Table:        "html",
+		IndexEnabled: true,
+		Experiments: []Experiment{{
+			Name:   "layout",
+			Cookie: "layout",
+			Variants: []ExperimentVariant{
+				{Name: "a"},
+				{Name: "b", RecordMacro: "render_record_b", IndexMacro: "render_index_b"},
+			},
+		}},
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	get := func(path, variant string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if variant != "" {
+			r.AddCookie(&http.Cookie{Name: "layout", Value: variant})
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("%s %s: ServeHTTP error: %v", path, variant, err)
+		}
+		return rec
+	}
+
+	for _, tc := range []struct{ path, variant, want string }{
+		{"/a", "a", "<p>table</p>"},
+		{"/a", "b", "<p>b</p>"},
+		{"/", "a", "<p>index</p>"},
+		{"/", "b", "<p>index b</p>"},
+	} {
+		rec := get(tc.path, tc.variant)
+		if rec.Body.String() != tc.want {
+			t.Errorf("%s %s: body = %q, want %q", tc.path, tc.variant, rec.Body.String(), tc.want)
+		}
+		if got := rec.Header().Get(experimentHeader); got != "layout="+tc.variant {
+			t.Errorf("%s %s: %s = %q", tc.path, tc.variant, experimentHeader, got)
+		}
+		if len(rec.Result().Cookies()) != 0 {
+			t.Errorf("%s %s: cookie set again", tc.path, tc.variant)
+		}
+	}
+
+	// Without a cookie the client is assigned by address, and the cookie
+	// remembers it.
+	first := get("/a", "")
+	cookies := first.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "layout" {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	if got := first.Header().Get(experimentHeader); got != "layout="+cookies[0].Value {
+		t.Errorf("%s = %q, cookie = %q", experimentHeader, got, cookies[0].Value)
+	}
+	if got := get("/a", "").Header().Get(experimentHeader); got != first.Header().Get(experimentHeader) {
+		t.Errorf("assignment changed: %q, then %q", first.Header().Get(experimentHeader), got)
+	}
+	if first.Header().Get("Vary") != "Cookie" {
+		t.Errorf("Vary = %q", first.Header().Get("Vary"))
+	}
+}
+
+func TestExperimentAssign_Weights(t *testing.T) {
+	e := &Experiment{Name: "layout", Variants: []ExperimentVariant{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}}}
+	counts := map[string]int{}
+	for i := range 400 {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+		counts[e.assign(httptest.NewRecorder(), r).Name]++
+	}
+	if counts["a"] < 240 || counts["b"] < 60 {
+		t.Errorf("counts = %v, want about 300 and 100", counts)
+	}
+}
+
+func TestCheckExperiments(t *testing.T) {
+	variants := func(macros ...string) []ExperimentVariant {
+		var vs []ExperimentVariant
+		for i, m := range macros {
+			vs = append(vs, ExperimentVariant{Name: string(rune('a' + i)), RecordMacro: m})
+		}
+		return vs
+	}
+	for name, experiments := range map[string][]Experiment{
+		"invalid name":      {{Name: "a-b", Variants: variants("", "m")}},
+		"one variant":       {{Name: "x", Variants: variants("m")}},
+		"no macros":         {{Name: "x", Variants: variants("", "")}},
+		"negative weight":   {{Name: "x", Variants: []ExperimentVariant{{Name: "a", Weight: -1}, {Name: "b", RecordMacro: "m"}}}},
+		"duplicate variant": {{Name: "x", Variants: []ExperimentVariant{{Name: "a"}, {Name: "a", RecordMacro: "m"}}}},
+		"same kind":         {{Name: "x", Variants: variants("", "m")}, {Name: "y", Variants: variants("", "n")}},
+	} {
+		if err := checkExperiments(experiments); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseExperiments(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		experiments {
+			layout {
+				salt 2026
+				cookie layout
+				variant control
+				variant cards 3 {
+					record_macro render_record_cards
+					index_macro render_index_cards
+				}
+			}
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if len(h.Experiments) != 1 {
+		t.Fatalf("Experiments = %v", h.Experiments)
+	}
+	e := h.Experiments[0]
+	if e.Name != "layout" || e.Salt != "2026" || e.Cookie != "layout" || len(e.Variants) != 2 {
+		t.Fatalf("Experiment = %+v", e)
+	}
+	if v := e.Variants[1]; v.Name != "cards" || v.Weight != 3 || v.RecordMacro != "render_record_cards" || v.IndexMacro != "render_index_cards" {
+		t.Errorf("Variant = %+v", v)
+	}
+}
@@ -0,0 +1,105 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// serveExplain serves {base_path}/{explain_path} to authorized clients. It
+// runs the query a request would run under EXPLAIN ANALYZE and returns
+// DuckDB's profile:
+//
+//	?id=<id>                 the record query
+//	?page=<n>                the index macro, with &prefix=<prefix> for
+//	                         hierarchical indexes
+//	?<search_param>=<term>   the search macro
+//
+// With format=json the profile is DuckDB's JSON output, else the rendered
+// plan as text. The query really runs, so the timings are those of a
+// request.
+func (h *HTMLFromDuckDB) serveExplain(w http.ResponseWriter, r *http.Request) error {
+	if !h.authorized(r, scopeExplain) {
+		unauthorized(w)
+		return nil
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	params := r.URL.Query()
+	var endpoint, query string
+	var args []any
+	switch {
+	case params.Has("id"):
+		endpoint = "record"
+		query, args = h.recordQuery(h.recordColumns(), h.RecordMacro, params.Get("id"), r)
+	case params.Has("page"):
+		if !h.IndexEnabled {
+			return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("index is not enabled"))
+		}
+		page, err := strconv.Atoi(params.Get("page"))
+		if err != nil || page < 1 {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid page: %q", params.Get("page")))
+		}
+		endpoint = "index"
+		query = h.indexQuery(h.IndexMacro, page, h.BasePath, params.Get("prefix"))
+	case params.Has(h.SearchParam):
+		if !h.SearchEnabled {
+			return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("search is not enabled"))
+		}
+		endpoint = "search"
+		query = h.searchQuery(strings.TrimSpace(params.Get(h.SearchParam)), h.BasePath)
+	default:
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("one of id, page or %s is required", h.SearchParam))
+	}
+
+	explain, contentType := "EXPLAIN ANALYZE ", "text/plain; charset=utf-8"
+	switch params.Get("format") {
+	case "", "text":
+	case "json":
+		explain, contentType = "EXPLAIN (ANALYZE, FORMAT json) ", "application/json"
+	default:
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("unsupported format: %q", params.Get("format")))
+	}
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	var key, plan string
+	err := h.queries(ctx, "explain").QueryRowContext(ctx, tagQuery(ctx, explain+query), args...).Scan(&key, &plan)
+	elapsed := time.Since(start)
+	if err != nil && clientGone(ctx) {
+		h.log(ctx).Debug("explain canceled, client disconnected", zap.String("endpoint", endpoint))
+		return nil
+	}
+	if err != nil {
+		// The caller is debugging, so DuckDB's message is the useful part
+		h.log(ctx).Warn("explain failed", zap.String("endpoint", endpoint), zap.Error(err))
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			http.Error(w, "query timed out", http.StatusServiceUnavailable)
+			return nil
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	h.log(ctx).Info("served explain",
+		zap.String("endpoint", endpoint),
+		zap.String("sql", truncateForLog(query, 200)),
+		zap.Duration("duration", elapsed))
+
+	w.Header().Set("Content-Type", contentType)
+	_, err = w.Write([]byte(plan))
+	return err
+}
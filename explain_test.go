@@ -0,0 +1,146 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Explain(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('w1', '<p>one</p>');
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE
+			SELECT string_agg(id, ',') AS html FROM html;
+		CREATE MACRO render_broken(id) AS TABLE SELECT error('extension not loaded') AS html;
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	newHandler := func() *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{
+			db:           db,
+			Table:        "html",
+			IDColumn:     "id",
+			HTMLColumn:   "html",
+			IndexEnabled: true,
+			IndexMacro:   "render_index",
+			SearchParam:  "q",
+			ExplainPath:  "_debug/explain",
+			AuthTokens:   []string{"secret"},
+			logger:       zap.NewNop(),
+		}
+	}
+	get := func(t *testing.T, h *HTMLFromDuckDB, query, token string) (*httptest.ResponseRecorder, error) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/_debug/explain?"+query, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		return rec, h.ServeHTTP(rec, r, emptyNextHandler())
+	}
+
+	t.Run("requires token", func(t *testing.T) {
+		rec, err := get(t, newHandler(), "id=w1", "")
+		if err != nil || rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, err = %v", rec.Code, err)
+		}
+	})
+
+	t.Run("record", func(t *testing.T) {
+		rec, err := get(t, newHandler(), "id=w1", "secret")
+		if err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Query Profiling Information") {
+			t.Errorf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+		}
+	})
+
+	t.Run("index as json", func(t *testing.T) {
+		rec, err := get(t, newHandler(), "page=1&format=json", "secret")
+		if err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		var profile map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &profile); err != nil {
+			t.Fatalf("invalid JSON profile: %v\n%s", err, rec.Body.String())
+		}
+		if _, ok := profile["latency"]; !ok || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("profile = %v", profile)
+		}
+	})
+
+	t.Run("failing macro", func(t *testing.T) {
+		h := newHandler()
+		h.RecordMacro = "render_broken"
+		rec, err := get(t, h, "id=w1", "secret")
+		if err != nil || rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "extension not loaded") {
+			t.Errorf("status = %d, err = %v, body = %s", rec.Code, err, rec.Body.String())
+		}
+	})
+
+	for name, tt := range map[string]struct {
+		query  string
+		status int
+	}{
+		"no parameters":      {"", http.StatusBadRequest},
+		"invalid page":       {"page=0", http.StatusBadRequest},
+		"search not enabled": {"q=one", http.StatusNotFound},
+		"unknown format":     {"id=w1&format=xml", http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := get(t, newHandler(), tt.query, "secret")
+			var herr caddyhttp.HandlerError
+			if !errors.As(err, &herr) || herr.StatusCode != tt.status {
+				t.Errorf("err = %v, want status %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestProvision_ExplainRequiresTokens(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &HTMLFromDuckDB{Table: "html", ExplainPath: "_debug/explain"}
+	if err := h.Provision(ctx); err == nil {
+		h.Cleanup()
+		t.Fatal("expected Provision to fail")
+	} else if !strings.Contains(err.Error(), "explain_path requires auth_tokens") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestParseExplainPath(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		explain_path _debug/explain
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.ExplainPath != "_debug/explain" {
+		t.Errorf("ExplainPath = %q", h.ExplainPath)
+	}
+}
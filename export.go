@@ -0,0 +1,262 @@
+package caddyhtmlduckdb
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// Export formats.
+const (
+	exportDuckDB  = "duckdb"
+	exportArchive = "export"
+	exportParquet = "parquet"
+)
+
+// exportSeq numbers the databases attached for exports, so concurrent
+// handlers sharing a pool never pick the same alias.
+var exportSeq atomic.Int64
+
+// serveExport serves {base_path}/{export_path} to authorized clients:
+//
+//	?format=duckdb          a copy of the database as a DuckDB file (default)
+//	?format=export          a zip of EXPORT DATABASE output (schema.sql,
+//	                        load.sql and one Parquet file per table)
+//	?format=parquet&table=  the named tables as Parquet, zipped when more
+//	                        than one
+//
+// Each snapshot is taken by a single statement, so it is consistent. One
+// export runs at a time; others get 503.
+func (h *HTMLFromDuckDB) serveExport(w http.ResponseWriter, r *http.Request) error {
+	if !h.authorized(r, scopeExport) {
+		unauthorized(w)
+		return nil
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportDuckDB
+	}
+	tables := r.URL.Query()["table"]
+	switch format {
+	case exportDuckDB, exportArchive:
+		if len(tables) > 0 {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("table is only supported with format=parquet"))
+		}
+	case exportParquet:
+		if len(tables) == 0 {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("format=parquet requires at least one table"))
+		}
+	default:
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("unsupported export format: %q", format))
+	}
+
+	select {
+	case h.exportBusy <- struct{}{}:
+		defer func() { <-h.exportBusy }()
+	default:
+		w.Header().Set("Retry-After", "30")
+		return caddyhttp.Error(http.StatusServiceUnavailable, fmt.Errorf("another export is running"))
+	}
+
+	dir, err := os.MkdirTemp(h.TempDirectory, "html_from_duckdb-export-*")
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Exports take as long as they take; only the client going away stops
+	// them, not query_timeout.
+	ctx := r.Context()
+	name := h.exportName()
+	start := time.Now()
+	switch format {
+	case exportDuckDB:
+		path := filepath.Join(dir, name+".duckdb")
+		if err := h.exportDatabase(ctx, path); err != nil {
+			return h.exportFailed(ctx, format, err)
+		}
+		err = h.serveExportFile(w, path, "application/octet-stream")
+
+	case exportArchive:
+		stmt := fmt.Sprintf("EXPORT DATABASE '%s' (FORMAT parquet)", escapeSQLString(dir))
+		if _, err := h.databaseFor("export").ExecContext(ctx, tagQuery(ctx, stmt)); err != nil {
+			return h.exportFailed(ctx, format, err)
+		}
+		err = serveExportZip(w, dir, name+".zip")
+
+	case exportParquet:
+		known, err := h.exportableTables(ctx)
+		if err != nil {
+			return h.exportFailed(ctx, format, err)
+		}
+		var files []string
+		for _, table := range tables {
+			if !slices.Contains(known, table) {
+				return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("no table named %q", table))
+			}
+			path := filepath.Join(dir, sanitizeIdentifier(table)+".parquet")
+			stmt := fmt.Sprintf("COPY %s TO '%s' (FORMAT parquet)", sanitizeIdentifier(table), escapeSQLString(path))
+			if _, err := h.databaseFor("export").ExecContext(ctx, tagQuery(ctx, stmt)); err != nil {
+				return h.exportFailed(ctx, format, err)
+			}
+			files = append(files, path)
+		}
+		if len(files) == 1 {
+			err = h.serveExportFile(w, files[0], "application/vnd.apache.parquet")
+		} else {
+			err = serveExportZip(w, dir, name+"-tables.zip")
+		}
+	}
+	if err != nil {
+		h.logFailure(r.Context(), "failed to write export", zap.String("format", format), zap.Error(err))
+		return err
+	}
+
+	h.log(r.Context()).Info("served database export",
+		zap.String("format", format),
+		zap.Strings("tables", tables),
+		zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// exportFailed logs a failed export statement and returns a 500.
+func (h *HTMLFromDuckDB) exportFailed(ctx context.Context, format string, err error) error {
+	h.logFailure(ctx, "export failed", zap.String("format", format), zap.Error(err))
+	return caddyhttp.Error(http.StatusInternalServerError, err)
+}
+
+// exportName is the base name of export downloads: the database file name
+// without its extension, or "memory" for in-memory databases.
+func (h *HTMLFromDuckDB) exportName() string {
+	name := strings.TrimSuffix(filepath.Base(h.DatabasePath), filepath.Ext(h.DatabasePath))
+	if h.DatabasePath == "" || name == "" || name == "." || name == ":memory:" {
+		return "memory"
+	}
+	return name
+}
+
+// exportDatabase copies the handler's database into a new DuckDB file at
+// path, by attaching the file and running COPY FROM DATABASE.
+func (h *HTMLFromDuckDB) exportDatabase(ctx context.Context, path string) error {
+	conn, err := h.databaseFor("export").Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var current string
+	if err := conn.QueryRowContext(ctx, tagQuery(ctx, "SELECT current_database()")).Scan(&current); err != nil {
+		return err
+	}
+	alias := fmt.Sprintf("html_from_duckdb_export_%d", exportSeq.Add(1))
+	attach := fmt.Sprintf("ATTACH '%s' AS %s (READ_WRITE)", escapeSQLString(path), alias)
+	if _, err := conn.ExecContext(ctx, tagQuery(ctx, attach)); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, tagQuery(ctx, fmt.Sprintf(`COPY FROM DATABASE "%s" TO %s`, strings.ReplaceAll(current, `"`, `""`), alias)))
+	// Detach even if the copy failed or the client left, so the alias and
+	// the file handle don't outlive the request.
+	if _, detachErr := conn.ExecContext(context.Background(), "DETACH "+alias); err == nil {
+		err = detachErr
+	}
+	return err
+}
+
+// exportableTables lists the base tables of the handler's database.
+func (h *HTMLFromDuckDB) exportableTables(ctx context.Context) ([]string, error) {
+	rows, err := h.databaseFor("export").QueryContext(ctx,
+		"SELECT table_name FROM duckdb_tables() WHERE database_name = current_database() AND NOT temporary")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// serveExportFile streams the file at path as an attachment, with its
+// SHA-256 in a Repr-Digest header (RFC 9530) so clients can verify the
+// download.
+func (h *HTMLFromDuckDB) serveExportFile(w http.ResponseWriter, path, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(path)))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(hash.Sum(nil))+":")
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// serveExportZip streams the files in dir as a zip attachment.
+func serveExportZip(w http.ResponseWriter, dir, filename string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := addZipFile(zw, filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// addZipFile copies the file at path into zw under its base name.
+func addZipFile(zw *zip.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dst, err := zw.Create(filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, f)
+	return err
+}
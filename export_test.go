@@ -0,0 +1,166 @@
+package caddyhtmlduckdb
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Export(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "works.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('a', '<p>a</p>'), ('b', '<p>b</p>');
+		CREATE TABLE people (id VARCHAR, name VARCHAR);
+		INSERT INTO people VALUES ('p1', 'Ada')
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:            db,
+		DatabasePath:  path,
+		Table:         "html",
+		HTMLColumn:    "html",
+		IDColumn:      "id",
+		ExportPath:    "_export",
+		AuthTokens:    []string{"secret"},
+		TempDirectory: dir,
+		exportBusy:    make(chan struct{}, 1),
+		logger:        zap.NewNop(),
+	}
+	get := func(t *testing.T, query, token string) (*httptest.ResponseRecorder, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/_export"+query, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		err := h.ServeHTTP(rec, r, emptyNextHandler())
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			return rec, herr.StatusCode
+		} else if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec, rec.Code
+	}
+	zipNames := func(t *testing.T, body []byte) []string {
+		t.Helper()
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("invalid zip: %v", err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	t.Run("database", func(t *testing.T) {
+		rec, status := get(t, "", "secret")
+		if status != http.StatusOK {
+			t.Fatalf("status = %d: %s", status, rec.Body.String())
+		}
+		if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="works.duckdb"` {
+			t.Errorf("Content-Disposition = %q", cd)
+		}
+		sum := sha256.Sum256(rec.Body.Bytes())
+		if want := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"; rec.Header().Get("Repr-Digest") != want {
+			t.Errorf("Repr-Digest = %q, want %q", rec.Header().Get("Repr-Digest"), want)
+		}
+
+		copyPath := filepath.Join(t.TempDir(), "copy.duckdb")
+		if err := os.WriteFile(copyPath, rec.Body.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		snap, err := sql.Open("duckdb", copyPath+"?access_mode=READ_ONLY")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snap.Close()
+		var n int
+		if err := snap.QueryRow("SELECT count(*) FROM html").Scan(&n); err != nil || n != 2 {
+			t.Errorf("snapshot has %d rows, %v", n, err)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		rec, status := get(t, "?format=export", "secret")
+		if status != http.StatusOK {
+			t.Fatalf("status = %d", status)
+		}
+		names := zipNames(t, rec.Body.Bytes())
+		for _, want := range []string{"load.sql", "schema.sql", "html.parquet", "people.parquet"} {
+			if !slices.Contains(names, want) {
+				t.Errorf("zip %v lacks %s", names, want)
+			}
+		}
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		rec, status := get(t, "?format=parquet&table=people", "secret")
+		if status != http.StatusOK || rec.Header().Get("Content-Type") != "application/vnd.apache.parquet" {
+			t.Fatalf("status = %d, Content-Type = %q", status, rec.Header().Get("Content-Type"))
+		}
+		if !bytes.HasPrefix(rec.Body.Bytes(), []byte("PAR1")) {
+			t.Error("not a Parquet file")
+		}
+
+		rec, _ = get(t, "?format=parquet&table=people&table=html", "secret")
+		if names := zipNames(t, rec.Body.Bytes()); !slices.Equal(names, []string{"html.parquet", "people.parquet"}) {
+			t.Errorf("zip contains %v", names)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		query  string
+		token  string
+		status int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"wrong token", "", "nope", http.StatusUnauthorized},
+		{"bad format", "?format=csv", "secret", http.StatusBadRequest},
+		{"parquet without table", "?format=parquet", "secret", http.StatusBadRequest},
+		{"unknown table", "?format=parquet&table=missing", "secret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, status := get(t, tt.query, tt.token); status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	t.Run("busy", func(t *testing.T) {
+		h.exportBusy <- struct{}{}
+		defer func() { <-h.exportBusy }()
+		rec, status := get(t, "", "secret")
+		if status != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("status = %d, Retry-After = %q", status, rec.Header().Get("Retry-After"))
+		}
+	})
+}
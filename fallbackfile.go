@@ -0,0 +1,59 @@
+package caddyhtmlduckdb
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+
+	"go.uber.org/zap"
+)
+
+// checkFallbackFileRoot checks that fallback_file_root is a directory.
+func (h *HTMLFromDuckDB) checkFallbackFileRoot() error {
+	if h.FallbackFileRoot == "" {
+		return nil
+	}
+	info, err := os.Stat(h.FallbackFileRoot)
+	if err != nil {
+		return fmt.Errorf("invalid fallback_file_root: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("invalid fallback_file_root: %s is not a directory", h.FallbackFileRoot)
+	}
+	return nil
+}
+
+// serveFallbackFile serves {fallback_file_root}/{id}.html for a record the
+// database lacks, and reports whether there was such a file. IDs with
+// slashes name files in subdirectories, but can't leave the root, not
+// even through symlinks.
+func (h *HTMLFromDuckDB) serveFallbackFile(w http.ResponseWriter, r *http.Request, id string) bool {
+	name := path.Clean(id) + ".html"
+	file, err := os.OpenInRoot(h.FallbackFileRoot, name)
+	if err != nil {
+		// Missing files are the usual case, and paths escaping the root
+		// come from clients; a file that can't be read is worth a warning.
+		if errors.Is(err, fs.ErrPermission) {
+			h.log(r.Context()).Warn("fallback file unavailable", zap.String("id", id), zap.Error(err))
+		}
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	requestInfoFrom(r.Context()).setEndpoint("fallback_file")
+	h.log(r.Context()).Debug("serving fallback file", zap.String("id", id), zap.String("file", name))
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+	// ServeContent handles conditional and range requests, and sets the
+	// Content-Type from the extension.
+	http.ServeContent(w, r, name, info.ModTime(), file)
+	return true
+}
@@ -0,0 +1,106 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestServeHTTP_FallbackFile(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('1', '<p>From the database</p>');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	root := filepath.Join(dir, "site")
+	for name, content := range map[string]string{
+		"1.html":           "<p>Old file</p>",
+		"2.html":           "<p>Two</p>",
+		"guide/intro.html": "<p>Intro</p>",
+		"dir.html/x":       "",
+	} {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755)
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret.html"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &HTMLFromDuckDB{
+		DatabasePath:     path,
+		Table:            "html",
+		FallbackFileRoot: root,
+		CacheControl:     "public, max-age=60",
+		NegativeCacheTTL: "1m",
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	for target, want := range map[string]string{
+		"/works/1":       "<p>From the database</p>",
+		"/works/2":       "<p>Two</p>",
+		"/works/2?again": "<p>Two</p>", // from the negative cache
+	} {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil), emptyNextHandler()); err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		if rec.Body.String() != want {
+			t.Errorf("%s: body = %q, want %q", target, rec.Body.String(), want)
+		}
+		if target != "/works/1" && (rec.Header().Get("Content-Type") != "text/html; charset=utf-8" || rec.Header().Get("Cache-Control") != "public, max-age=60") {
+			t.Errorf("%s: headers = %v", target, rec.Header())
+		}
+	}
+
+	for _, target := range []string{"/works/3", "/works/dir"} {
+		err := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil), emptyNextHandler())
+		if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %v", target, err)
+		}
+	}
+
+	// IDs with slashes, here from id_param, may name files in
+	// subdirectories but not outside the root.
+	h.IDParam = "id"
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/works?id=guide/intro", nil), emptyNextHandler()); err != nil || rec.Body.String() != "<p>Intro</p>" {
+		t.Errorf("guide/intro: body = %q, err = %v", rec.Body.String(), err)
+	}
+	for _, id := range []string{"../secret", "guide/../../secret", "/etc/passwd"} {
+		err := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/works?id="+id, nil), emptyNextHandler())
+		if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %v", id, err)
+		}
+	}
+
+	h2 := &HTMLFromDuckDB{DatabasePath: path, Table: "html", FallbackFileRoot: filepath.Join(dir, "missing")}
+	if err := h2.Provision(ctx); err == nil {
+		h2.Cleanup()
+		t.Error("expected error for missing fallback_file_root")
+	}
+}
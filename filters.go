@@ -0,0 +1,108 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// FilterParam binds a query parameter to a column that a record lookup
+// must match besides the ID column, e.g. ?lang=en to a language column.
+type FilterParam struct {
+	// Param is the query parameter.
+	Param string `json:"param"`
+
+	// Column is the column the parameter's value is compared with.
+	// Default: Param
+	Column string `json:"column,omitempty"`
+
+	// Type is the DuckDB type the value is cast to, such as INTEGER or
+	// DATE. Values that don't cast match no record.
+	// Default: VARCHAR
+	Type string `json:"type,omitempty"`
+}
+
+// checkFilterParams validates the filter params and fills in defaults.
+func (h *HTMLFromDuckDB) checkFilterParams() error {
+	if len(h.FilterParams) == 0 {
+		return nil
+	}
+	if h.RecordMacro != "" {
+		return fmt.Errorf("filter_params can't be combined with record_macro; pass the parameters to the macro instead")
+	}
+	seen := make(map[string]bool)
+	for i := range h.FilterParams {
+		f := &h.FilterParams[i]
+		if f.Param == "" || seen[f.Param] || f.Param == h.IDParam {
+			return fmt.Errorf("filter_params: invalid or duplicate parameter %q", f.Param)
+		}
+		seen[f.Param] = true
+		if f.Column == "" {
+			f.Column = f.Param
+		}
+		if sanitizeIdentifier(f.Column) != f.Column {
+			return fmt.Errorf("filter_params: invalid column %q", f.Column)
+		}
+		if f.Type == "" {
+			f.Type = "VARCHAR"
+		}
+		f.Type = strings.ToUpper(f.Type)
+		if sanitizeIdentifier(f.Type) != f.Type {
+			return fmt.Errorf("filter_params: invalid type %q", f.Type)
+		}
+	}
+	return nil
+}
+
+// filterConditions returns the conditions the filter params present in
+// params add to a record lookup, and their bound values. Parameters that
+// are absent don't filter.
+func (h *HTMLFromDuckDB) filterConditions(params url.Values) (string, []any) {
+	var conditions strings.Builder
+	var args []any
+	for _, f := range h.FilterParams {
+		if !params.Has(f.Param) {
+			continue
+		}
+		fmt.Fprintf(&conditions, " AND %s = TRY_CAST(? AS %s)", f.Column, f.Type)
+		args = append(args, params.Get(f.Param))
+	}
+	return conditions.String(), args
+}
+
+// filterKey returns the values of the filter params present in params,
+// which together with the ID identify a record lookup.
+func (h *HTMLFromDuckDB) filterKey(params url.Values) string {
+	var key strings.Builder
+	for _, f := range h.FilterParams {
+		if params.Has(f.Param) {
+			key.WriteString("\x00" + f.Param + "=" + params.Get(f.Param))
+		}
+	}
+	return key.String()
+}
+
+// parseFilterParams parses a filter_params block:
+//
+//	filter_params {
+//	    <param> [<column> [<type>]]
+//	}
+func parseFilterParams(d *caddyfile.Dispenser) ([]FilterParam, error) {
+	var filters []FilterParam
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		f := FilterParam{Param: d.Val()}
+		if d.NextArg() {
+			f.Column = d.Val()
+		}
+		if d.NextArg() {
+			f.Type = d.Val()
+		}
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
@@ -0,0 +1,105 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_FilterParams(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE pages (id VARCHAR, language VARCHAR, version INTEGER, html VARCHAR);
+		INSERT INTO pages VALUES
+			('about', 'en', 1, '<p>About v1</p>'),
+			('about', 'en', 2, '<p>About v2</p>'),
+			('about', 'sv', 1, '<p>Om v1</p>');
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	h := &HTMLFromDuckDB{
+		Table:    "pages",
+		IDColumn: "id",
+		FilterParams: []FilterParam{
+			{Param: "lang", Column: "language"},
+			{Param: "version", Type: "integer"},
+		},
+		HTMLColumn: "html",
+		db:         db,
+		logger:     zap.NewNop(),
+	}
+	if err := h.checkFilterParams(); err != nil {
+		t.Fatalf("checkFilterParams: %v", err)
+	}
+	if h.FilterParams[0].Type != "VARCHAR" || h.FilterParams[1].Column != "version" || h.FilterParams[1].Type != "INTEGER" {
+		t.Errorf("FilterParams = %+v", h.FilterParams)
+	}
+
+	for target, want := range map[string]string{
+		"/about?lang=en&version=2": "<p>About v2</p>",
+		"/about?lang=sv":           "<p>Om v1</p>",
+		"/about?version=1&lang=en": "<p>About v1</p>",
+	} {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil), emptyNextHandler()); err != nil {
+			t.Fatalf("%s: ServeHTTP error: %v", target, err)
+		}
+		if rec.Body.String() != want {
+			t.Errorf("%s: body = %q, want %q", target, rec.Body.String(), want)
+		}
+	}
+	for _, target := range []string{"/about?lang=de", "/about?lang=en&version=two"} {
+		err := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil), emptyNextHandler())
+		if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %v", target, err)
+		}
+	}
+}
+
+func TestCheckFilterParams_Errors(t *testing.T) {
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"record macro": {RecordMacro: "render_page", FilterParams: []FilterParam{{Param: "lang"}}},
+		"duplicate":    {FilterParams: []FilterParam{{Param: "lang"}, {Param: "lang", Column: "language"}}},
+		"id param":     {IDParam: "id", FilterParams: []FilterParam{{Param: "id"}}},
+		"column":       {FilterParams: []FilterParam{{Param: "lang", Column: "lang; DROP TABLE x"}}},
+		"type":         {FilterParams: []FilterParam{{Param: "lang", Type: "VARCHAR) OR (1=1"}}},
+	} {
+		if err := h.checkFilterParams(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseFilterParams(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		filter_params {
+			lang language
+			version version INTEGER
+			edition
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	want := []FilterParam{{Param: "lang", Column: "language"}, {Param: "version", Column: "version", Type: "INTEGER"}, {Param: "edition"}}
+	if len(h.FilterParams) != len(want) {
+		t.Fatalf("FilterParams = %+v", h.FilterParams)
+	}
+	for i := range want {
+		if h.FilterParams[i] != want[i] {
+			t.Errorf("FilterParams[%d] = %+v, want %+v", i, h.FilterParams[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// maxFooterRows caps the footer, which is meant for a few summary rows.
+const maxFooterRows = 10
+
+// scanTableFooter runs the table_footer_macro with the table macro's
+// arguments and adds its rows to box as footer rows.
+func (h *HTMLFromDuckDB) scanTableFooter(ctx context.Context, box *duckbox, args string) error {
+	query := fmt.Sprintf("SELECT * FROM %s(%s)", sanitizeIdentifier(h.TableFooterMacro), args)
+	start := time.Now()
+	rows, err := h.queryRetry(ctx, "table", h.queries(ctx, "table"), query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	err = box.scanFooter(rows)
+	h.observeQuery(ctx, "table", query, time.Since(start))
+	return err
+}
+
+// scanFooter reads up to maxFooterRows rows as footer rows. Footer columns
+// are matched to the table's by name, so a footer only needs the columns it
+// summarizes: the other cells are left blank, and footer columns the table
+// doesn't have (e.g. left out with the columns parameter) are ignored.
+func (b *duckbox) scanFooter(rows *sql.Rows) error {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	source := make([]int, len(b.names))
+	for i, name := range b.names {
+		source[i] = slices.IndexFunc(cols, func(col *sql.ColumnType) bool { return col.Name() == name })
+	}
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	format := b.cellFormat()
+	for b.footers < maxFooterRows && rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for col, j := range source {
+			start := len(b.text)
+			if j >= 0 {
+				b.text = b.appendCell(format, col, values[j], cols[j].DatabaseTypeName())
+			}
+			b.endCell(format, col, start)
+		}
+		b.footers++
+	}
+	return rows.Err()
+}
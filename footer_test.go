@@ -0,0 +1,87 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_TableFooter(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE sales AS SELECT * FROM (VALUES ('Ada', 2024, 120), ('Bo', 2024, 80), ('Cy', 2024, 1000)) t(seller, year, total);
+		CREATE MACRO report(yr := 2024, base_path := '') AS TABLE
+			SELECT seller, total FROM sales WHERE year = yr ORDER BY total;
+		CREATE MACRO report_footer(yr := 2024, base_path := '') AS TABLE
+			SELECT 'Total' AS seller, sum(total) AS total, 'ignored' AS extra FROM sales WHERE year = yr
+			UNION ALL
+			SELECT 'Average', avg(total)::INTEGER, NULL FROM sales WHERE year = yr;
+	`)
+	if err != nil {
+		t.Fatalf("failed to create macros: %v", err)
+	}
+	handler := &HTMLFromDuckDB{
+		Table:            "html",
+		TableMacro:       "report",
+		TableFooterMacro: "report_footer",
+		TablePath:        "_report",
+		TableMaxRows:     2,
+		db:               db,
+		logger:           zap.NewNop(),
+	}
+	get := func(query string) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_report?"+query, nil), emptyNextHandler())
+		return rec, err
+	}
+
+	rec, err := get("yr=2024")
+	if err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	want := "<pre class=\"duckbox\">\n" +
+		" seller   total \n" +
+		"────────────────\n" +
+		"                \n" +
+		" Bo          80 \n" +
+		" Ada        120 \n" +
+		" … 1 more row   \n" +
+		"────────────────\n" +
+		" Total     1200 \n" +
+		" Average    400 \n" +
+		"</pre>"
+	if rec.Body.String() != want {
+		t.Errorf("body mismatch\ngot:\n%s\nwant:\n%s", rec.Body.String(), want)
+	}
+
+	// The footer follows the columns parameter, and other formats leave
+	// it out.
+	rec, err = get("columns=total")
+	if err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), "─────\n  1200 \n") {
+		t.Errorf("projected footer missing:\n%s", rec.Body.String())
+	}
+	rec, err = get("format=csv")
+	if err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	if strings.Contains(rec.Body.String(), "Total") {
+		t.Errorf("CSV has the footer:\n%s", rec.Body.String())
+	}
+
+	handler.TableFooterMacro = "missing_footer"
+	if _, err := get(""); err == nil {
+		t.Error("expected error for missing footer macro")
+	}
+}
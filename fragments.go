@@ -0,0 +1,97 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// includeDirective matches a server-side include of another record:
+// <!--#include id="nav"-->
+var includeDirective = regexp.MustCompile(`<!--#include\s+id="([^"]*)"\s*-->`)
+
+// resolveIncludes replaces the include directives in html with the included
+// records, which may include others in turn. stack holds the IDs being
+// rendered, outermost first, to stop cycles and limit the depth. Includes
+// that can't be resolved are replaced by an HTML comment saying why.
+func (h *HTMLFromDuckDB) resolveIncludes(ctx context.Context, html string, stack []string) string {
+	if !h.Includes || !strings.Contains(html, "<!--#include") {
+		return html
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range includeDirective.FindAllStringSubmatchIndex(html, -1) {
+		b.WriteString(html[last:m[0]])
+		b.WriteString(h.include(ctx, html[m[2]:m[3]], stack))
+		last = m[1]
+	}
+	b.WriteString(html[last:])
+	return b.String()
+}
+
+// include renders record id for an include directive inside stack.
+func (h *HTMLFromDuckDB) include(ctx context.Context, id string, stack []string) string {
+	fail := func(reason string) string {
+		h.log(ctx).Warn("include failed",
+			zap.String("id", id),
+			zap.Strings("stack", stack),
+			zap.String("reason", reason))
+		return fmt.Sprintf("<!-- include %q %s -->", strings.ReplaceAll(id, "--", ""), reason)
+	}
+	if slices.Contains(stack, id) {
+		return fail("would loop")
+	}
+	if len(stack) > h.IncludeMaxDepth {
+		return fail("is nested too deep")
+	}
+	html, err := h.renderRecord(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return fail("not found")
+	}
+	if err != nil {
+		h.logFailure(ctx, "include query failed", zap.String("id", id), zap.Error(err))
+		return fail("failed")
+	}
+	return h.resolveIncludes(ctx, html, append(slices.Clip(stack), id))
+}
+
+// serveFragment serves {fragment_path}/{id}: the record's HTML on its own,
+// with includes and ESI resolved but without meta tags or response headers, for
+// embedding in other pages.
+func (h *HTMLFromDuckDB) serveFragment(w http.ResponseWriter, r *http.Request, id string) error {
+	if id == "" {
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("missing ID parameter"))
+	}
+	requestInfoFrom(r.Context()).setID(id)
+
+	html, err := h.RenderRecord(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("fragment %q not found", id))
+	}
+	if err != nil {
+		h.logFailure(r.Context(), "fragment query failed", zap.String("id", id), zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	html = h.processESI(r, html)
+
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+	if notModified(w, r, generateETag(html)) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(html)))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte(html))
+	return err
+}
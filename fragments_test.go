@@ -0,0 +1,103 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Includes(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES
+			('page', '<body><!--#include id="nav"--><main>page</main><!--#include id="footer" --></body>'),
+			('nav', '<nav><!--#include id="logo"--></nav>'),
+			('logo', '<img src="logo.svg">'),
+			('footer', '<footer>(c)</footer>'),
+			('broken', '<p><!--#include id="missing"--></p>'),
+			('loop', '<p><!--#include id="loop2"--></p>'),
+			('loop2', '<p><!--#include id="loop"--></p>')
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		db:              db,
+		Table:           "html",
+		HTMLColumn:      "html",
+		IDColumn:        "id",
+		FragmentPath:    "_fragment",
+		Includes:        true,
+		IncludeMaxDepth: 5,
+		logger:          zap.NewNop(),
+	}
+	serve := func(t *testing.T, h *HTMLFromDuckDB, path string) (*httptest.ResponseRecorder, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler())
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			return rec, herr.StatusCode
+		} else if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec, rec.Code
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{"nested includes", "/page", http.StatusOK,
+			`<body><nav><img src="logo.svg"></nav><main>page</main><footer>(c)</footer></body>`},
+		{"missing include", "/broken", http.StatusOK, `<p><!-- include "missing" not found --></p>`},
+		{"cycle", "/loop", http.StatusOK, `<p><p><!-- include "loop" would loop --></p></p>`},
+		{"fragment", "/_fragment/nav", http.StatusOK, `<nav><img src="logo.svg"></nav>`},
+		{"missing fragment", "/_fragment/missing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, status := serve(t, handler, tt.path)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+
+	t.Run("depth limit", func(t *testing.T) {
+		h := *handler
+		h.IncludeMaxDepth = 1
+		rec, _ := serve(t, &h, "/page")
+		if !strings.Contains(rec.Body.String(), `<nav><!-- include "logo" is nested too deep --></nav>`) {
+			t.Errorf("expected depth limit, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		h := *handler
+		h.Includes = false
+		html, err := h.RenderRecord(context.Background(), "nav")
+		if err != nil || html != `<nav><!--#include id="logo"--></nav>` {
+			t.Errorf("got %q, %v", html, err)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package caddyhtmlduckdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// bboxParams are the macro parameters a bbox query parameter is passed
+// as, in the order of the bbox's coordinates.
+var bboxParams = []string{"min_x", "min_y", "max_x", "max_y"}
+
+// parseBBox parses a bbox query parameter: min x, min y, max x and max y,
+// separated by commas, as in the GeoJSON bbox member.
+func parseBBox(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != len(bboxParams) {
+		return nil, fmt.Errorf("invalid bbox %q: want min_x,min_y,max_x,max_y", s)
+	}
+	bbox := make([]float64, len(parts))
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bbox %q: %v", s, err)
+		}
+		bbox[i] = f
+	}
+	if bbox[0] > bbox[2] || bbox[1] > bbox[3] {
+		return nil, fmt.Errorf("invalid bbox %q: min exceeds max", s)
+	}
+	return bbox, nil
+}
+
+// geoJSONMacroCall returns the call of the GeoJSON macro for the request's
+// query parameters. A bbox is passed as min_x, min_y, max_x and max_y; other
+// parameters are passed by name, as for the table macro.
+func (h *HTMLFromDuckDB) geoJSONMacroCall(r *http.Request) (string, error) {
+	var args []string
+	for key, values := range r.URL.Query() {
+		if key == "bbox" || len(values) == 0 {
+			continue
+		}
+		name := sanitizeIdentifier(key)
+		if name == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(values[0]); err == nil {
+			args = append(args, fmt.Sprintf("%s := %s", name, values[0]))
+		} else {
+			args = append(args, fmt.Sprintf("%s := '%s'", name, escapeSQLString(values[0])))
+		}
+	}
+	if s := r.URL.Query().Get("bbox"); s != "" {
+		bbox, err := parseBBox(s)
+		if err != nil {
+			return "", err
+		}
+		for i, name := range bboxParams {
+			args = append(args, fmt.Sprintf("%s := %s", name, strconv.FormatFloat(bbox[i], 'g', -1, 64)))
+		}
+	}
+	return fmt.Sprintf("SELECT * FROM %s(%s)", sanitizeIdentifier(h.GeoJSONMacro), strings.Join(args, ", ")), nil
+}
+
+// geoJSONQuery wraps the macro call so that the geometry column, of type
+// columnType, holds GeoJSON text. GEOMETRY and WKB columns are converted
+// with the spatial extension; text columns are taken to hold GeoJSON
+// already.
+func geoJSONQuery(call, column, columnType string) string {
+	col := sanitizeIdentifier(column)
+	switch {
+	case strings.HasPrefix(columnType, "GEOMETRY"):
+		return fmt.Sprintf("SELECT * REPLACE (ST_AsGeoJSON(%s)::VARCHAR AS %s) FROM (%s)", col, col, call)
+	case columnType == "BLOB" || columnType == "WKB_BLOB":
+		return fmt.Sprintf("SELECT * REPLACE (ST_AsGeoJSON(ST_GeomFromWKB(%s))::VARCHAR AS %s) FROM (%s)", col, col, call)
+	default:
+		return call
+	}
+}
+
+// featuresQuery looks up the type of the geometry column in the result of
+// call and returns the query selecting it as GeoJSON.
+func (h *HTMLFromDuckDB) featuresQuery(ctx context.Context, db querier, call string) (string, error) {
+	var columnType string
+	err := db.QueryRowContext(ctx, tagQuery(ctx, fmt.Sprintf("SELECT column_type FROM (DESCRIBE %s) WHERE column_name = ?", call)),
+		h.GeoJSONGeometryColumn).Scan(&columnType)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no geometry column %q", h.GeoJSONGeometryColumn)
+	}
+	if err != nil {
+		return "", err
+	}
+	return geoJSONQuery(call, h.GeoJSONGeometryColumn, columnType), nil
+}
+
+// serveGeoJSON serves the GeoJSON macro's rows as a GeoJSON
+// FeatureCollection: each row is a feature whose geometry is the geometry
+// column, whose id is the id column if there is one, and whose properties
+// are the other columns.
+func (h *HTMLFromDuckDB) serveGeoJSON(w http.ResponseWriter, r *http.Request) error {
+	call, err := h.geoJSONMacroCall(r)
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	db := h.queries(ctx, "geojson")
+	query, err := h.featuresQuery(ctx, db, call)
+	if err != nil {
+		h.logFailure(ctx, "geojson macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	h.log(ctx).Debug("executing geojson macro", zap.String("query", query))
+	start := time.Now()
+	rows, err := h.queryRetry(ctx, "geojson", db, query)
+	if err != nil {
+		h.logFailure(ctx, "geojson macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	sw := newStreamWriter(w, h.StreamBuffer)
+	omitted, err := writeFeatures(sw, rows, h.GeoJSONGeometryColumn, h.ValueEncoding, h.TableMaxRows)
+	h.observeQuery(ctx, "geojson", query, time.Since(start))
+	if err != nil {
+		if sw.started() {
+			if clientGone(ctx) {
+				return nil
+			}
+			h.log(ctx).Error("geojson stream failed", zap.Error(err))
+			sw.abort()
+		}
+		w.Header().Del("Content-Type")
+		h.logFailure(ctx, "geojson macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if omitted > 0 {
+		h.log(ctx).Warn("geojson output truncated",
+			zap.String("macro", h.GeoJSONMacro),
+			zap.Int("max_rows", h.TableMaxRows),
+			zap.Int("omitted_rows", omitted))
+	}
+	return sw.finish(omitted)
+}
+
+// writeFeatures writes rows as a GeoJSON FeatureCollection, with the
+// geometry in column geometry as GeoJSON text (or NULL). At most maxRows
+// features are written (0 means no limit); the number of rows left out is
+// returned.
+func writeFeatures(w io.Writer, rows *sql.Rows, geometry string, ve *ValueEncoding, maxRows int) (int, error) {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	geomIdx, idIdx := -1, -1
+	for i, col := range cols {
+		switch col.Name() {
+		case geometry:
+			geomIdx = i
+		case "id":
+			idIdx = i
+		}
+	}
+	if geomIdx < 0 {
+		return 0, fmt.Errorf("no geometry column %q", geometry)
+	}
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"type":"FeatureCollection","features":[`)
+	n, omitted := 0, 0
+	for rows.Next() {
+		if maxRows > 0 && n >= maxRows {
+			omitted++
+			continue
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		geom, err := geometryJSON(values[geomIdx])
+		if err != nil {
+			return 0, fmt.Errorf("row %d: %v", n+1, err)
+		}
+		props := make(map[string]any, len(cols))
+		for i, v := range values {
+			if i != geomIdx && i != idIdx {
+				props[cols[i].Name()] = ve.jsonValue(v, cols[i].DatabaseTypeName())
+			}
+		}
+		feature := struct {
+			Type       string          `json:"type"`
+			ID         any             `json:"id,omitempty"`
+			Geometry   json.RawMessage `json:"geometry"`
+			Properties map[string]any  `json:"properties"`
+		}{Type: "Feature", Geometry: geom, Properties: props}
+		if idIdx >= 0 {
+			feature.ID = ve.jsonValue(values[idIdx], cols[idIdx].DatabaseTypeName())
+		}
+		enc, err := json.Marshal(feature)
+		if err != nil {
+			return 0, fmt.Errorf("row %d: %v", n+1, err)
+		}
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString("\n")
+		bw.Write(enc)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	bw.WriteString("\n]}\n")
+	return omitted, bw.Flush()
+}
+
+// geometryJSON returns a geometry column's value as a GeoJSON geometry:
+// text holding GeoJSON, a JSON value DuckDB decoded, or null.
+func geometryJSON(v any) (json.RawMessage, error) {
+	switch x := v.(type) {
+	case nil:
+		return json.RawMessage("null"), nil
+	case string:
+		if !json.Valid([]byte(x)) {
+			return nil, fmt.Errorf("geometry is not GeoJSON: %s", truncateForLog(x, 50))
+		}
+		return json.RawMessage(x), nil
+	case []byte:
+		if !json.Valid(x) {
+			return nil, fmt.Errorf("geometry is not GeoJSON")
+		}
+		return json.RawMessage(x), nil
+	default:
+		return json.Marshal(defaultValueEncoding.jsonValue(v, ""))
+	}
+}
+
+// checkSpatial warns when the spatial extension couldn't be loaded, so that
+// GEOMETRY columns can't be converted nor vector tiles built.
+func (h *HTMLFromDuckDB) checkSpatial(ctx context.Context) {
+	var loaded bool
+	err := h.database().QueryRowContext(ctx, "SELECT loaded FROM duckdb_extensions() WHERE extension_name = 'spatial'").Scan(&loaded)
+	if err != nil || !loaded {
+		h.logger.Warn("spatial extension not loaded; geometry columns must hold GeoJSON text and tiles can't be built with ST_AsMVT",
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,131 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestGeoJSONEndpoint(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	initFile := writeInitSQL(t, `CREATE OR REPLACE MACRO places(min_x := -180, min_y := -90, max_x := 180, max_y := 90, category := NULL) AS TABLE
+		SELECT id, name, kind,
+			CASE WHEN lon IS NOT NULL THEN json_object('type', 'Point', 'coordinates', [lon, lat])::VARCHAR END AS geom
+		FROM (VALUES (1, 'Uppsala', 'city', 17.64, 59.86), (2, 'Visby', 'town', 18.29, 57.64), (3, 'Nowhere', 'city', NULL, NULL)) t(id, name, kind, lon, lat)
+		WHERE (lon IS NULL OR (lon BETWEEN min_x AND max_x AND lat BETWEEN min_y AND max_y))
+			AND (category IS NULL OR kind = category)
+		ORDER BY id`)
+	h := &HTMLFromDuckDB{
+		InitSQLFile:  initFile,
+		Table:        "html",
+		GeoJSONMacro: "places",
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	type featureCollection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			ID       any    `json:"id"`
+			Geometry *struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	get := func(t *testing.T, query string) (featureCollection, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_geojson"+query, nil), emptyNextHandler())
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			return featureCollection{}, herr.StatusCode
+		} else if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/geo+json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var fc featureCollection
+		if err := json.Unmarshal(rec.Body.Bytes(), &fc); err != nil {
+			t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+		}
+		return fc, rec.Code
+	}
+
+	fc, status := get(t, "")
+	if status != http.StatusOK || fc.Type != "FeatureCollection" || len(fc.Features) != 3 {
+		t.Fatalf("all: status = %d, collection = %+v", status, fc)
+	}
+	f := fc.Features[0]
+	if f.Type != "Feature" || f.ID != float64(1) || f.Geometry == nil || f.Geometry.Type != "Point" ||
+		len(f.Geometry.Coordinates) != 2 || f.Geometry.Coordinates[0] != 17.64 {
+		t.Errorf("feature = %+v, geometry = %+v", f, f.Geometry)
+	}
+	if f.Properties["name"] != "Uppsala" || f.Properties["kind"] != "city" || len(f.Properties) != 2 {
+		t.Errorf("properties = %v", f.Properties)
+	}
+	if fc.Features[2].Geometry != nil {
+		t.Errorf("NULL geometry = %+v", fc.Features[2].Geometry)
+	}
+
+	// Only Uppsala lies in the box; Nowhere has no location to filter on.
+	fc, _ = get(t, "?bbox=17,59,18,60")
+	if len(fc.Features) != 2 || fc.Features[0].Properties["name"] != "Uppsala" {
+		t.Errorf("bbox: %+v", fc.Features)
+	}
+	fc, _ = get(t, "?bbox=17,57,19,60&category=town")
+	if len(fc.Features) != 1 || fc.Features[0].Properties["name"] != "Visby" {
+		t.Errorf("bbox and category: %+v", fc.Features)
+	}
+	for _, bbox := range []string{"1,2,3", "a,b,c,d", "10,0,0,10"} {
+		if _, status := get(t, "?bbox="+bbox); status != http.StatusBadRequest {
+			t.Errorf("bbox=%s: status = %d", bbox, status)
+		}
+	}
+}
+
+func TestGeoJSONQuery(t *testing.T) {
+	call := "SELECT * FROM places()"
+	for _, tt := range []struct {
+		columnType, want string
+	}{
+		{"GEOMETRY", "SELECT * REPLACE (ST_AsGeoJSON(geom)::VARCHAR AS geom) FROM (SELECT * FROM places())"},
+		{"GEOMETRY('OGC:CRS84')", "SELECT * REPLACE (ST_AsGeoJSON(geom)::VARCHAR AS geom) FROM (SELECT * FROM places())"},
+		{"BLOB", "SELECT * REPLACE (ST_AsGeoJSON(ST_GeomFromWKB(geom))::VARCHAR AS geom) FROM (SELECT * FROM places())"},
+		{"VARCHAR", call},
+		{"JSON", call},
+	} {
+		if got := geoJSONQuery(call, "geom", tt.columnType); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.columnType, got, tt.want)
+		}
+	}
+}
+
+func TestParseGeoJSON(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		geojson_macro places
+		geojson_path map/places
+		geojson_geometry_column shape
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.GeoJSONMacro != "places" || h.GeoJSONPath != "map/places" || h.GeoJSONGeometryColumn != "shape" {
+		t.Errorf("GeoJSONMacro = %q, GeoJSONPath = %q, GeoJSONGeometryColumn = %q", h.GeoJSONMacro, h.GeoJSONPath, h.GeoJSONGeometryColumn)
+	}
+}
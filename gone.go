@@ -0,0 +1,88 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// goneCondition returns the SQL condition matching soft-deleted rows, built
+// from deleted_column and gone_where_clause, or an empty string. NULL
+// results count as not deleted.
+func (h *HTMLFromDuckDB) goneCondition() string {
+	var conds []string
+	if h.DeletedColumn != "" {
+		// Works for flags (deleted = true) as well as timestamps (deleted_at
+		// IS NOT NULL).
+		col := sanitizeIdentifier(h.DeletedColumn)
+		conds = append(conds, fmt.Sprintf("(%s IS NOT NULL AND CAST(%s AS VARCHAR) <> 'false')", col, col))
+	}
+	if h.GoneWhereClause != "" {
+		conds = append(conds, "("+h.GoneWhereClause+")")
+	}
+	if len(conds) == 0 {
+		return ""
+	}
+	return "COALESCE(" + strings.Join(conds, " OR ") + ", false)"
+}
+
+// isGone reports whether id belongs to a soft-deleted row.
+func (h *HTMLFromDuckDB) isGone(ctx context.Context, id string) (bool, error) {
+	cond := h.goneCondition()
+	if cond == "" {
+		return false, nil
+	}
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ? AND %s",
+		sanitizeIdentifier(h.Table),
+		sanitizeIdentifier(h.IDColumn),
+		cond)
+	if h.WhereClause != "" {
+		query += fmt.Sprintf(" AND (%s)", h.WhereClause)
+	}
+	query += " LIMIT 1"
+
+	var one int
+	start := time.Now()
+	err := h.queries(ctx, "record").QueryRowContext(ctx, tagQuery(ctx, query), id).Scan(&one)
+	h.observeQuery(ctx, "gone", query, time.Since(start))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// serveGone answers a request for a soft-deleted record with 410 Gone and,
+// if gone_macro is set, its tombstone page.
+func (h *HTMLFromDuckDB) serveGone(w http.ResponseWriter, r *http.Request, id string) error {
+	requestInfoFrom(r.Context()).setEndpoint("gone")
+	if h.GoneMacro != "" {
+		ctx, cancel := h.queryContext(r.Context())
+		defer cancel()
+
+		query := fmt.Sprintf("SELECT html FROM %s(id := '%s', path := '%s')",
+			sanitizeIdentifier(h.GoneMacro),
+			escapeSQLString(id),
+			escapeSQLString(r.URL.Path))
+
+		var html string
+		start := time.Now()
+		err := h.queries(ctx, "record").QueryRowContext(ctx, tagQuery(ctx, query)).Scan(&html)
+		h.observeQuery(ctx, "gone", query, time.Since(start))
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Length", strconv.Itoa(len(html)))
+			w.WriteHeader(http.StatusGone)
+			_, err = w.Write([]byte(html))
+			return err
+		}
+		h.log(r.Context()).Warn("gone macro failed", zap.String("id", id), zap.Error(err))
+	}
+	return caddyhttp.Error(http.StatusGone, fmt.Errorf("content gone"))
+}
@@ -0,0 +1,114 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Gone(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, deleted_at TIMESTAMP, status VARCHAR);
+		INSERT INTO html VALUES
+			('live', '<p>live</p>', NULL, NULL),
+			('deleted', '<p>deleted</p>', TIMESTAMP '2025-01-01', NULL),
+			('withdrawn', '<p>withdrawn</p>', NULL, 'withdrawn');
+		CREATE MACRO render_tombstone(id, path) AS TABLE
+		SELECT '<p>' || id || ' was removed</p>' AS html
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		db:              db,
+		Table:           "html",
+		HTMLColumn:      "html",
+		IDColumn:        "id",
+		DeletedColumn:   "deleted_at",
+		GoneWhereClause: "status = 'withdrawn'",
+		APIPath:         "api",
+		APIColumns:      []string{"html"},
+		APIPageSize:     10,
+		logger:          zap.NewNop(),
+	}
+	serve := func(t *testing.T, h *HTMLFromDuckDB, path string) (*httptest.ResponseRecorder, error) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler())
+		return rec, err
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/live", http.StatusOK},
+		{"/deleted", http.StatusGone},
+		{"/withdrawn", http.StatusGone},
+		{"/missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec, err := serve(t, handler, tt.path)
+			status := rec.Code
+			var herr caddyhttp.HandlerError
+			if errors.As(err, &herr) {
+				status = herr.StatusCode
+			} else if err != nil {
+				t.Fatalf("ServeHTTP error: %v", err)
+			}
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	t.Run("tombstone macro", func(t *testing.T) {
+		h := *handler
+		h.GoneMacro = "render_tombstone"
+		rec, err := serve(t, &h, "/deleted")
+		if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		if rec.Code != http.StatusGone || rec.Body.String() != "<p>deleted was removed</p>" {
+			t.Errorf("got %d %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("api", func(t *testing.T) {
+		rec, err := serve(t, handler, "/api/withdrawn")
+		if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		if rec.Code != http.StatusGone {
+			t.Errorf("status = %d, want 410", rec.Code)
+		}
+
+		rec, err = serve(t, handler, "/api")
+		if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		var doc struct {
+			Data []apiResource `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(doc.Data) != 1 || doc.Data[0].ID != "live" {
+			t.Errorf("collection should only list live records, got %+v", doc.Data)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// graphQLSchemaQuery is the query name that describes the named queries
+// instead of running one.
+const graphQLSchemaQuery = "__schema"
+
+// graphQLRequest is a graphql_path request body: the name of a named query
+// and its parameters, as in a GraphQL request's query and variables.
+type graphQLRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+// graphQLError is an entry of a graphql_path response's errors.
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+// serveGraphQL runs the named query a POSTed JSON document names and
+// returns its rows as {"data": {"<name>": [...]}}, or the named queries
+// for the query "__schema". Failures are returned as {"errors": [...]}.
+func (h *HTMLFromDuckDB) serveGraphQL(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeGraphQLError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return nil
+	}
+	var req graphQLRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQuerySQLSize))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		writeGraphQLError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return nil
+	}
+	if req.Query == graphQLSchemaQuery {
+		return writeGraphQLData(w, req.Query, map[string]any{"queries": h.namedQueryList()})
+	}
+	q, ok := h.namedQueries[req.Query]
+	if !ok {
+		writeGraphQLError(w, http.StatusBadRequest, fmt.Sprintf("unknown query %q", req.Query))
+		return nil
+	}
+	params, err := graphQLParams(req.Variables)
+	if err != nil {
+		writeGraphQLError(w, http.StatusBadRequest, err.Error())
+		return nil
+	}
+	args, err := q.args(params)
+	if err != nil {
+		writeGraphQLError(w, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	conn, release, err := h.requestConn(ctx, "graphql")
+	if err != nil {
+		writeGraphQLError(w, http.StatusServiceUnavailable, err.Error())
+		return nil
+	}
+	defer release()
+
+	// The rows are encoded into the data object, so the result is
+	// buffered, bounded by query_max_bytes, rather than streamed.
+	start := time.Now()
+	var result bytes.Buffer
+	omitted, err := func() (int, error) {
+		rows, err := conn.QueryContext(ctx, tagQuery(ctx, q.sql), args...)
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		return writeJSONRows(&limitWriter{w: &result, limit: h.QueryMaxBytes}, rows, h.ValueEncoding, h.QueryMaxRows)
+	}()
+	switch {
+	case err == nil:
+	case clientGone(ctx):
+		return nil
+	case errors.Is(err, errResultTooLarge):
+		writeGraphQLError(w, http.StatusUnprocessableEntity, fmt.Sprintf("result exceeds %d bytes", h.QueryMaxBytes))
+		return nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		writeGraphQLError(w, http.StatusServiceUnavailable, "query timed out")
+		return nil
+	default:
+		h.log(ctx).Warn("named query failed", zap.String("query", q.name), zap.Error(err))
+		writeGraphQLError(w, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	h.observeQuery(ctx, "graphql", q.sql, time.Since(start))
+	h.log(ctx).Info("served named query",
+		zap.String("query", q.name),
+		zap.Duration("duration", time.Since(start)),
+		zap.Int("omitted_rows", omitted))
+	if omitted > 0 {
+		w.Header().Set("X-Truncated", strconv.Itoa(omitted))
+	}
+	return writeGraphQLData(w, q.name, json.RawMessage(result.Bytes()))
+}
+
+// graphQLParams converts GraphQL variables to the query parameters of a
+// named query. Null variables are left out, so defaults apply.
+func graphQLParams(variables map[string]any) (map[string][]string, error) {
+	params := make(map[string][]string, len(variables))
+	for name, v := range variables {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			params[name] = []string{v}
+		case json.Number:
+			params[name] = []string{v.String()}
+		case bool:
+			params[name] = []string{strconv.FormatBool(v)}
+		default:
+			return nil, fmt.Errorf("variable %s must be a string, number or boolean", name)
+		}
+	}
+	return params, nil
+}
+
+// writeGraphQLData writes a successful graphql_path response.
+func writeGraphQLData(w http.ResponseWriter, name string, data any) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{name: data}})
+}
+
+// writeGraphQLError writes a failed graphql_path response.
+func writeGraphQLError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string][]graphQLError{"errors": {{Message: message}}})
+}
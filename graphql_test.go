@@ -0,0 +1,96 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_GraphQL(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		CREATE TABLE works (title VARCHAR, pub_year INTEGER, lang VARCHAR);
+		INSERT INTO works VALUES ('B', 1999, 'en'), ('A', 1999, 'en'), ('C', 1999, 'sv'), ('D', 2001, 'en');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	queriesFile := filepath.Join(dir, "queries.sql")
+	if err := os.WriteFile(queriesFile, []byte(testQueriesFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &HTMLFromDuckDB{DatabasePath: path, Table: "html", QueriesFile: queriesFile, GraphQLPath: "graphql"}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	for _, tc := range []struct {
+		method, body string
+		status       int
+		want         string
+	}{
+		{http.MethodPost, `{"query": "works_by_year", "variables": {"year": 1999}}`, http.StatusOK,
+			`{"data":{"works_by_year":[{"title":"A"},{"title":"B"}]}}` + "\n"},
+		{http.MethodPost, `{"query": "works_by_year", "variables": {"year": "1999", "lang": null}}`, http.StatusOK, `{"title":"B"}`},
+		{http.MethodPost, `{"query": "work_count"}`, http.StatusOK, `{"data":{"work_count":[{"n":4}]}}`},
+		{http.MethodPost, `{"query": "__schema"}`, http.StatusOK, `{"data":{"__schema":{"queries":[{"name":"work_count"`},
+		{http.MethodPost, `{"query": "works_by_year"}`, http.StatusBadRequest, `{"errors":[{"message":"missing parameter year"}]}`},
+		{http.MethodPost, `{"query": "works_by_year", "variables": {"year": [1999]}}`, http.StatusBadRequest, `must be a string, number or boolean`},
+		{http.MethodPost, `{"query": "nope"}`, http.StatusBadRequest, `unknown query`},
+		{http.MethodPost, `not json`, http.StatusBadRequest, `invalid request`},
+		{http.MethodGet, ``, http.StatusMethodNotAllowed, `method not allowed`},
+	} {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, "/graphql", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("%s: ServeHTTP error: %v", tc.body, err)
+		}
+		if rec.Code != tc.status || !strings.Contains(rec.Body.String(), tc.want) {
+			t.Errorf("%s: status %d, body %q; want %d, %q", tc.body, rec.Code, rec.Body.String(), tc.status, tc.want)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type = %q", tc.body, ct)
+		}
+	}
+}
+
+func TestGraphQLRequiresQueriesFile(t *testing.T) {
+	h := &HTMLFromDuckDB{GraphQLPath: "graphql"}
+	if err := h.provisionNamedQueries(context.Background()); err == nil {
+		t.Error("expected error for graphql_path without queries_file")
+	}
+}
+
+func TestParseGraphQLPath(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		queries_file queries.sql
+		graphql_path graphql
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.GraphQLPath != "graphql" {
+		t.Errorf("GraphQLPath = %q", h.GraphQLPath)
+	}
+}
@@ -0,0 +1,208 @@
+package caddyhtmlduckdb
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/clipperhouse/displaywidth"
+)
+
+// gridParams are the table endpoint's sorting and paging parameters, taken
+// by the module rather than passed to the macro when table_grid is on.
+var gridParams = []string{"sort", "dir", "limit", "offset"}
+
+// isGridParam reports whether key is a grid parameter handled by the
+// module.
+func (h *HTMLFromDuckDB) isGridParam(key string) bool {
+	return h.TableGrid && slices.Contains(gridParams, key)
+}
+
+// tablePage is the page of a table result asked for with limit and offset.
+type tablePage struct {
+	limit  int // 0 for no limit
+	offset int
+}
+
+// tablePage parses the limit and offset parameters. A limit above
+// table_max_rows is lowered to it.
+func (h *HTMLFromDuckDB) tablePage(params url.Values) (tablePage, error) {
+	var page tablePage
+	if !h.TableGrid {
+		return page, nil
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &page.limit}, {"offset", &page.offset}} {
+		if v := params.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return page, caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid %s %q", p.name, v))
+			}
+			*p.dst = n
+		}
+	}
+	if h.TableMaxRows > 0 && page.limit > h.TableMaxRows {
+		page.limit = h.TableMaxRows
+	}
+	return page, nil
+}
+
+// wrap applies the page to query, fetching extra rows beyond the limit, so
+// the HTML table can tell whether there is a next page.
+func (p tablePage) wrap(query string, extra int) string {
+	if p.limit > 0 {
+		query = fmt.Sprintf("SELECT * FROM (%s) LIMIT %d", query, p.limit+extra)
+		if p.offset > 0 {
+			query += fmt.Sprintf(" OFFSET %d", p.offset)
+		}
+	} else if p.offset > 0 {
+		query = fmt.Sprintf("SELECT * FROM (%s) OFFSET %d", query, p.offset)
+	}
+	return query
+}
+
+// tableGrid holds the links of an HTML table rendered with table_grid:
+// column headers that sort by their column, and previous and next page
+// links.
+type tableGrid struct {
+	headers []string // sort link of each column, "" if it can't be sorted
+	sortCol int      // column the table is sorted by, or -1
+	desc    bool
+
+	paged      bool
+	prev, next string // "" if there's no such page
+	first      int    // 1-based number of the first row shown
+}
+
+// gridAsc and gridDesc mark the column the table is sorted by, and
+// gridPrev and gridNext label the page links.
+const (
+	gridAsc  = " ▲"
+	gridDesc = " ▼"
+	gridPrev = "‹ prev"
+	gridNext = "next ›"
+)
+
+// tableGrid builds the links for box. Links keep the request's other
+// parameters, and a header link sorts by its column, ascending unless the
+// table is already sorted by it that way. Sorting starts from the first
+// page again.
+func (h *HTMLFromDuckDB) tableGrid(r *http.Request, box *duckbox, page tablePage, hasNext bool) *tableGrid {
+	params := r.URL.Query()
+	link := func(set map[string]string) string {
+		q := url.Values{}
+		for k, v := range params {
+			q[k] = v
+		}
+		for k, v := range set {
+			if v == "" {
+				q.Del(k)
+			} else {
+				q.Set(k, v)
+			}
+		}
+		return r.URL.Path + "?" + q.Encode()
+	}
+
+	g := &tableGrid{sortCol: -1, desc: strings.EqualFold(params.Get("dir"), "desc")}
+	sort := params.Get("sort")
+	for col, name := range box.names {
+		href := ""
+		if !box.hidden[col] && (len(h.TableSortColumns) == 0 || slices.Contains(h.TableSortColumns, name)) {
+			dir := "asc"
+			if name == sort {
+				g.sortCol = col
+				if !g.desc {
+					dir = "desc"
+				}
+			}
+			href = link(map[string]string{"sort": name, "dir": dir, "offset": ""})
+		}
+		g.headers = append(g.headers, href)
+	}
+	if g.sortCol >= 0 {
+		arrow := displaywidth.String(box.names[g.sortCol] + gridAsc)
+		box.widths[g.sortCol] = max(box.widths[g.sortCol], arrow)
+	}
+
+	if page.limit > 0 {
+		g.paged = true
+		g.first = page.offset + 1
+		if page.offset > 0 {
+			prev := ""
+			if offset := page.offset - page.limit; offset > 0 {
+				prev = strconv.Itoa(offset)
+			}
+			g.prev = link(map[string]string{"offset": prev})
+		}
+		if hasNext {
+			g.next = link(map[string]string{"offset": strconv.Itoa(page.offset + page.limit)})
+		}
+	}
+	return g
+}
+
+// writeHeader writes the header of column col and returns its display
+// width. In an HTML grid it is a link that sorts by the column, marked with
+// an arrow when the table is sorted by it.
+func (b *duckbox) writeHeader(w *bufio.Writer, col int, html bool) int {
+	name := b.names[col]
+	if !html || b.grid == nil || b.grid.headers[col] == "" {
+		w.WriteString(name)
+		return displaywidth.String(name)
+	}
+	label := name
+	if col == b.grid.sortCol {
+		if b.grid.desc {
+			label += gridDesc
+		} else {
+			label += gridAsc
+		}
+	}
+	writeGridLink(w, b.grid.headers[col], label)
+	return displaywidth.String(label)
+}
+
+// writeGridLink writes an anchor that works as a plain link, and with htmx
+// replaces the table in place.
+func writeGridLink(w *bufio.Writer, href, label string) {
+	w.WriteString(`<a href="`)
+	writeAttr(w, href)
+	w.WriteString(`" hx-get="`)
+	writeAttr(w, href)
+	w.WriteString(`" hx-target="closest pre" hx-swap="outerHTML">`)
+	w.WriteString(label)
+	w.WriteString(`</a>`)
+}
+
+// writePager writes the "‹ prev  rows 21–40  next ›" line of a paged HTML
+// grid, padded to the table width.
+func (b *duckbox) writePager(w *bufio.Writer, total int) {
+	width := 1
+	w.WriteByte(' ')
+	if b.grid.prev != "" {
+		writeGridLink(w, b.grid.prev, gridPrev)
+		w.WriteString("  ")
+		width += displaywidth.String(gridPrev) + 2
+	}
+	label := "no rows"
+	if b.rows > 0 {
+		label = fmt.Sprintf("rows %d–%d", b.grid.first, b.grid.first+b.rows-1)
+	}
+	w.WriteString(label)
+	width += displaywidth.String(label)
+	if b.grid.next != "" {
+		w.WriteString("  ")
+		writeGridLink(w, b.grid.next, gridNext)
+		width += 2 + displaywidth.String(gridNext)
+	}
+	writeRepeated(w, spaces, 1, total-width)
+	w.WriteByte('\n')
+}
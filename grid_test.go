@@ -0,0 +1,111 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_TableGrid(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE MACRO people(team := 'a', base_path := '') AS TABLE
+		SELECT name, age FROM (VALUES ('Ann', 31, 'a'), ('Bob', 25, 'a'), ('Cid', 47, 'a'), ('Dee', 38, 'a'), ('Eve', 52, 'b')) t(name, age, team)
+		WHERE t.team = team
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+	handler := &HTMLFromDuckDB{
+		Table:            "html",
+		TableMacro:       "people",
+		TablePath:        "_people",
+		TableMaxRows:     3,
+		TableGrid:        true,
+		TableSortColumns: []string{"age"},
+		db:               db,
+		logger:           zap.NewNop(),
+	}
+	get := func(query string) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_people?"+query, nil), emptyNextHandler())
+		return rec, err
+	}
+
+	rec, err := get("team=a&sort=age&dir=desc&limit=2")
+	if err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	link := func(q string) string {
+		return `<a href="/_people?` + q + `" hx-get="/_people?` + q + `" hx-target="closest pre" hx-swap="outerHTML">`
+	}
+	want := "<pre class=\"duckbox\">\n" +
+		" name  " + link("dir=asc&amp;limit=2&amp;sort=age&amp;team=a") + "age ▼</a> \n" +
+		"─────────────\n" +
+		"             \n" +
+		" Cid      47 \n" +
+		" Dee      38 \n" +
+		" rows 1–2  " + link("dir=desc&amp;limit=2&amp;offset=2&amp;sort=age&amp;team=a") + "next ›</a>\n" +
+		"</pre>"
+	if rec.Body.String() != want {
+		t.Errorf("body mismatch\ngot:\n%s\nwant:\n%s", rec.Body.String(), want)
+	}
+	if rec.Header().Get("X-Truncated") != "" {
+		t.Errorf("a page is not truncated: X-Truncated = %q", rec.Header().Get("X-Truncated"))
+	}
+
+	// dir is case-insensitive, as in the SQL the macro's rows are sorted by
+	rec, _ = get("team=a&sort=age&dir=DESC&limit=2")
+	if !strings.Contains(rec.Body.String(), "age ▼</a>") || !strings.Contains(rec.Body.String(), link("dir=asc&amp;limit=2&amp;sort=age&amp;team=a")) {
+		t.Errorf("dir=DESC:\n%s", rec.Body.String())
+	}
+
+	rec, err = get("team=a&sort=age&dir=desc&limit=2&offset=2")
+	if err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, " Ann      31 \n Bob      25 \n") || strings.Contains(body, "offset=0") ||
+		!strings.Contains(body, "‹ prev</a>  rows 3–4\n") || strings.Contains(body, "next ›") {
+		t.Errorf("second page:\n%s", body)
+	}
+
+	// The data formats are paged in SQL, without the extra row.
+	rec, err = get("team=a&sort=age&limit=2&offset=1&format=csv")
+	if err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	if rec.Body.String() != "name,age\nAnn,31\nDee,38\n" {
+		t.Errorf("CSV page = %q", rec.Body.String())
+	}
+
+	// A limit above table_max_rows is lowered to it.
+	rec, _ = get("team=a&limit=100")
+	if !strings.Contains(rec.Body.String(), "rows 1–3  ") {
+		t.Errorf("limit not capped:\n%s", rec.Body.String())
+	}
+
+	for _, bad := range []string{"sort=name", "sort=age&dir=up", "limit=-1", "offset=x"} {
+		if _, err := get(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		} else if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %v", bad, err)
+		}
+	}
+
+	// Without table_grid, the parameters go to the macro.
+	handler.TableGrid = false
+	if _, err := get("limit=2"); err == nil {
+		t.Error("expected the macro to reject limit")
+	}
+}
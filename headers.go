@@ -0,0 +1,295 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// defaultHeadersAllow lists the response headers a headers column may set
+// when no headers_allow list is configured.
+var defaultHeadersAllow = []string{
+	"Link",
+	"X-Robots-Tag",
+	"Content-Security-Policy",
+	"Content-Language",
+	"Referrer-Policy",
+	"Permissions-Policy",
+}
+
+// parseRowHeaders converts a headers column value into response headers.
+// The value must be a JSON object whose values are strings or arrays of
+// strings; it may arrive as a string or []byte (VARCHAR/JSON columns) or as
+// an already decoded map (STRUCT/MAP columns).
+func parseRowHeaders(value any) (http.Header, error) {
+	var obj map[string]any
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		if err := json.Unmarshal([]byte(v), &obj); err != nil {
+			return nil, fmt.Errorf("headers column is not a JSON object: %v", err)
+		}
+	case []byte:
+		if len(v) == 0 {
+			return nil, nil
+		}
+		if err := json.Unmarshal(v, &obj); err != nil {
+			return nil, fmt.Errorf("headers column is not a JSON object: %v", err)
+		}
+	case map[string]any:
+		obj = v
+	default:
+		return nil, fmt.Errorf("unsupported headers column type %T", value)
+	}
+
+	headers := make(http.Header, len(obj))
+	for name, raw := range obj {
+		switch val := raw.(type) {
+		case string:
+			headers.Add(name, val)
+		case []any:
+			for _, item := range val {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("header %s: array values must be strings", name)
+				}
+				headers.Add(name, s)
+			}
+		default:
+			return nil, fmt.Errorf("header %s: value must be a string or array of strings", name)
+		}
+	}
+	return headers, nil
+}
+
+// applyRowHeaders copies the allowed headers from a headers column value into
+// the response. Invalid values are logged and ignored rather than failing
+// the request, since the page itself is still servable.
+func (h *HTMLFromDuckDB) applyRowHeaders(ctx context.Context, w http.ResponseWriter, value any) {
+	headers, err := parseRowHeaders(value)
+	if err != nil {
+		h.log(ctx).Warn("ignoring invalid headers column", zap.Error(err))
+		return
+	}
+
+	allow := h.HeadersAllow
+	if len(allow) == 0 {
+		allow = defaultHeadersAllow
+	}
+	for _, name := range allow {
+		values := headers.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		w.Header().Del(name)
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+}
+
+// parsePreloadList converts a preload column value into a list of assets.
+// Accepts a DuckDB LIST, a JSON array, or a string of assets separated by
+// commas, whitespace or newlines.
+func parsePreloadList(value any) ([]string, error) {
+	return parseList(value, "preload")
+}
+
+// parseList converts the value of a list-valued column (preload, tags) into
+// its items, as described for parsePreloadList. column names the column in
+// errors.
+func parseList(value any, column string) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s list items must be strings, got %T", column, item)
+			}
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		return items, nil
+	case []byte:
+		return parseList(string(v), column)
+	case string:
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "[") {
+			var list []string
+			if err := json.Unmarshal([]byte(v), &list); err != nil {
+				return nil, fmt.Errorf("%s column is not a JSON array of strings: %v", column, err)
+			}
+			return parseList(toAnySlice(list), column)
+		}
+		return strings.FieldsFunc(v, isListSeparator), nil
+	default:
+		return nil, fmt.Errorf("unsupported %s column type %T", column, value)
+	}
+}
+
+// isListSeparator reports whether r separates items in a list column.
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\n' || r == '\t' || r == '\r'
+}
+
+// toAnySlice converts a string slice to an any slice.
+func toAnySlice(s []string) []any {
+	out := make([]any, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
+
+// preloadLink returns a Link header value preloading asset. Entries that
+// already look like a Link value ("<url>; rel=...") are used verbatim;
+// otherwise the "as" destination is derived from the file extension unless
+// given explicitly.
+func preloadLink(asset, as string) string {
+	if strings.HasPrefix(asset, "<") {
+		return asset
+	}
+	if as == "" {
+		as = preloadAs(asset)
+	}
+	link := "<" + asset + ">; rel=preload"
+	if as != "" {
+		link += "; as=" + as
+	}
+	// Fonts are always fetched in CORS mode, so the preload must match.
+	if as == "font" {
+		link += "; crossorigin"
+	}
+	return link
+}
+
+// preloadAs guesses the preload destination from an asset URL's extension.
+func preloadAs(asset string) string {
+	if i := strings.IndexAny(asset, "?#"); i >= 0 {
+		asset = asset[:i]
+	}
+	switch strings.ToLower(path.Ext(asset)) {
+	case ".css":
+		return "style"
+	case ".js", ".mjs":
+		return "script"
+	case ".woff", ".woff2", ".ttf", ".otf":
+		return "font"
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg", ".ico":
+		return "image"
+	case ".json":
+		return "fetch"
+	default:
+		return ""
+	}
+}
+
+// queryPreloadMacro calls the preload macro for id and returns Link header
+// values. The macro returns one row per asset: the URL in the first column
+// and, optionally, the "as" destination in the second.
+func (h *HTMLFromDuckDB) queryPreloadMacro(ctx context.Context, id string) ([]string, error) {
+	query := fmt.Sprintf("SELECT * FROM %s(id := '%s')",
+		sanitizeIdentifier(h.PreloadMacro),
+		escapeSQLString(id))
+
+	rows, err := h.queries(ctx, "record").QueryContext(ctx, tagQuery(ctx, query))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var links []string
+	for rows.Next() {
+		var asset, as sql.NullString
+		dest := []any{&asset}
+		if len(cols) > 1 {
+			dest = append(dest, &as)
+		}
+		for i := len(dest); i < len(cols); i++ {
+			dest = append(dest, new(any))
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if asset.String != "" {
+			links = append(links, preloadLink(asset.String, as.String))
+		}
+	}
+	return links, rows.Err()
+}
+
+// addPreloadLinks adds Link preload headers for assets to the response.
+func addPreloadLinks(w http.ResponseWriter, links []string) {
+	for _, link := range links {
+		w.Header().Add("Link", link)
+	}
+}
+
+// negotiate records that responses depend on the named request header.
+// Features that negotiate on request headers (encoding, language, variants)
+// call it during Provision, so every response carries a correct Vary header.
+func (h *HTMLFromDuckDB) negotiate(name string) {
+	name = http.CanonicalHeaderKey(name)
+	for _, n := range h.negotiated {
+		if n == name {
+			return
+		}
+	}
+	h.negotiated = append(h.negotiated, name)
+}
+
+// varyHeaders returns the request headers to list in the Vary header.
+// A configured Vary list replaces the negotiated one; "none" disables it.
+func (h *HTMLFromDuckDB) varyHeaders() []string {
+	if len(h.Vary) > 0 {
+		if len(h.Vary) == 1 && strings.EqualFold(h.Vary[0], "none") {
+			return nil
+		}
+		return h.Vary
+	}
+	return h.negotiated
+}
+
+// addVary merges header names into the response's Vary header, keeping
+// values set by earlier middleware and skipping names already present.
+func addVary(w http.ResponseWriter, names ...string) {
+	if len(names) == 0 {
+		return
+	}
+	present := make(map[string]bool)
+	for _, value := range w.Header().Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			present[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	if present["*"] {
+		return
+	}
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" || present[name] {
+			continue
+		}
+		present[name] = true
+		w.Header().Add("Vary", name)
+	}
+}
@@ -0,0 +1,404 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestParseRowHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		want    http.Header
+		wantErr bool
+	}{
+		{
+			name:  "NULL",
+			value: nil,
+			want:  nil,
+		},
+		{
+			name:  "string values",
+			value: `{"X-Robots-Tag": "noindex"}`,
+			want:  http.Header{"X-Robots-Tag": {"noindex"}},
+		},
+		{
+			name:  "array values",
+			value: []byte(`{"link": ["</a.css>; rel=preload", "</b.js>; rel=preload"]}`),
+			want:  http.Header{"Link": {"</a.css>; rel=preload", "</b.js>; rel=preload"}},
+		},
+		{
+			name:  "decoded map",
+			value: map[string]any{"Content-Language": "sv"},
+			want:  http.Header{"Content-Language": {"sv"}},
+		},
+		{
+			name:    "not an object",
+			value:   `["noindex"]`,
+			wantErr: true,
+		},
+		{
+			name:    "numeric value",
+			value:   `{"X-Count": 3}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRowHeaders(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRowHeaders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseRowHeaders() = %v, want %v", got, tt.want)
+			}
+			for name, values := range tt.want {
+				if g := got.Values(name); len(g) != len(values) || g[0] != values[0] {
+					t.Errorf("header %s = %v, want %v", name, g, values)
+				}
+			}
+		})
+	}
+}
+
+func TestServeHTTP_HeadersColumn(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR, headers JSON)`)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO html VALUES
+		('with-headers', '<html>A</html>', '{"X-Robots-Tag": "noindex", "Set-Cookie": "evil=1", "Cache-Control": "no-store"}'),
+		('no-headers', '<html>B</html>', NULL),
+		('bad-headers', '<html>C</html>', '"oops"')`)
+	if err != nil {
+		t.Fatalf("failed to insert test data: %v", err)
+	}
+
+	newHandler := func(allow []string) *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{
+			Table:         "html",
+			HTMLColumn:    "html",
+			IDColumn:      "id",
+			HeadersColumn: "headers",
+			HeadersAllow:  allow,
+			CacheControl:  "public, max-age=60",
+			db:            db,
+			logger:        zap.NewNop(),
+		}
+	}
+
+	serve := func(t *testing.T, h *HTMLFromDuckDB, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("applies default allowlist", func(t *testing.T) {
+		rec := serve(t, newHandler(nil), "/page/with-headers")
+		if got := rec.Header().Get("X-Robots-Tag"); got != "noindex" {
+			t.Errorf("X-Robots-Tag = %q, want %q", got, "noindex")
+		}
+		if got := rec.Header().Get("Set-Cookie"); got != "" {
+			t.Errorf("Set-Cookie should not pass the allowlist, got %q", got)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=60" {
+			t.Errorf("Cache-Control = %q, want configured value", got)
+		}
+	})
+
+	t.Run("configured allowlist can override defaults", func(t *testing.T) {
+		rec := serve(t, newHandler([]string{"cache-control"}), "/page/with-headers")
+		if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("Cache-Control = %q, want %q", got, "no-store")
+		}
+		if got := rec.Header().Get("X-Robots-Tag"); got != "" {
+			t.Errorf("X-Robots-Tag should not pass the allowlist, got %q", got)
+		}
+	})
+
+	t.Run("NULL and invalid values are ignored", func(t *testing.T) {
+		for _, path := range []string{"/page/no-headers", "/page/bad-headers"} {
+			rec := serve(t, newHandler(nil), path)
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: status = %d, want %d", path, rec.Code, http.StatusOK)
+			}
+		}
+	})
+}
+
+func TestPreloadLink(t *testing.T) {
+	tests := []struct {
+		asset string
+		as    string
+		want  string
+	}{
+		{"/css/site.css", "", "</css/site.css>; rel=preload; as=style"},
+		{"/js/app.js?v=2", "", "</js/app.js?v=2>; rel=preload; as=script"},
+		{"/fonts/inter.woff2", "", "</fonts/inter.woff2>; rel=preload; as=font; crossorigin"},
+		{"/img/hero.webp", "", "</img/hero.webp>; rel=preload; as=image"},
+		{"/data/blob", "fetch", "</data/blob>; rel=preload; as=fetch"},
+		{"/unknown", "", "</unknown>; rel=preload"},
+		{"</x.css>; rel=preload; as=style; media=print", "", "</x.css>; rel=preload; as=style; media=print"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.asset, func(t *testing.T) {
+			if got := preloadLink(tt.asset, tt.as); got != tt.want {
+				t.Errorf("preloadLink(%q, %q) = %q, want %q", tt.asset, tt.as, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePreloadList(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  []string
+	}{
+		{"NULL", nil, nil},
+		{"DuckDB list", []any{"/a.css", "/b.js"}, []string{"/a.css", "/b.js"}},
+		{"JSON array", `["/a.css", "/b.js"]`, []string{"/a.css", "/b.js"}},
+		{"separated string", "/a.css, /b.js\n/c.woff2", []string{"/a.css", "/b.js", "/c.woff2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePreloadList(tt.value)
+			if err != nil {
+				t.Fatalf("parsePreloadList() error: %v", err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("parsePreloadList() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// hintsRecorder records informational responses separately, since
+// httptest.ResponseRecorder treats the first WriteHeader as final.
+type hintsRecorder struct {
+	*httptest.ResponseRecorder
+	hints []http.Header
+}
+
+func (r *hintsRecorder) WriteHeader(code int) {
+	if code >= 100 && code < 200 {
+		r.hints = append(r.hints, r.Header().Clone())
+		return
+	}
+	r.ResponseRecorder.WriteHeader(code)
+}
+
+func TestServeHTTP_Preload(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR, assets VARCHAR[])`)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO html VALUES ('p1', '<html>P1</html>', ['/site.css', '/hero.webp'])`)
+	if err != nil {
+		t.Fatalf("failed to insert test data: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE OR REPLACE MACRO page_assets(id := '') AS TABLE
+		SELECT '/app.js' AS url, 'script' AS "as"
+	`)
+	if err != nil {
+		t.Fatalf("failed to create preload macro: %v", err)
+	}
+
+	t.Run("emits Link headers from preload column", func(t *testing.T) {
+		handler := &HTMLFromDuckDB{
+			Table:         "html",
+			HTMLColumn:    "html",
+			IDColumn:      "id",
+			PreloadColumn: "assets",
+			db:            db,
+			logger:        zap.NewNop(),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/page/p1", nil)
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		links := rec.Header().Values("Link")
+		want := []string{"</site.css>; rel=preload; as=style", "</hero.webp>; rel=preload; as=image"}
+		if strings.Join(links, "|") != strings.Join(want, "|") {
+			t.Errorf("Link = %q, want %q", links, want)
+		}
+	})
+
+	t.Run("sends early hints from preload macro", func(t *testing.T) {
+		handler := &HTMLFromDuckDB{
+			Table:        "html",
+			HTMLColumn:   "html",
+			IDColumn:     "id",
+			PreloadMacro: "page_assets",
+			EarlyHints:   true,
+			db:           db,
+			logger:       zap.NewNop(),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/page/p1", nil)
+		rec := &hintsRecorder{ResponseRecorder: httptest.NewRecorder()}
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		want := "</app.js>; rel=preload; as=script"
+		if len(rec.hints) != 1 || rec.hints[0].Get("Link") != want {
+			t.Errorf("early hints = %v, want one with Link %q", rec.hints, want)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Values("Link"); len(got) != 1 || got[0] != want {
+			t.Errorf("final Link = %q, want [%q]", got, want)
+		}
+	})
+
+	t.Run("no Link headers for missing record", func(t *testing.T) {
+		handler := &HTMLFromDuckDB{
+			Table:        "html",
+			HTMLColumn:   "html",
+			IDColumn:     "id",
+			PreloadMacro: "page_assets",
+			EarlyHints:   true,
+			db:           db,
+			logger:       zap.NewNop(),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/page/missing", nil)
+		rec := &hintsRecorder{ResponseRecorder: httptest.NewRecorder()}
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err == nil {
+			t.Fatal("expected not found error")
+		}
+		if got := rec.Header().Get("Link"); got != "" {
+			t.Errorf("Link should not be set on error, got %q", got)
+		}
+		if len(rec.hints) != 0 {
+			t.Errorf("early hints sent for missing record: %v", rec.hints)
+		}
+	})
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		add      []string
+		want     []string
+	}{
+		{"nothing to add", nil, nil, nil},
+		{"adds canonical names", nil, []string{"accept-encoding", "HX-Request"}, []string{"Accept-Encoding", "Hx-Request"}},
+		{"skips duplicates", []string{"Accept-Encoding, Cookie"}, []string{"cookie", "Accept-Language"}, []string{"Accept-Encoding, Cookie", "Accept-Language"}},
+		{"wildcard wins", []string{"*"}, []string{"Cookie"}, []string{"*"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			for _, v := range tt.existing {
+				rec.Header().Add("Vary", v)
+			}
+			addVary(rec, tt.add...)
+			if got := rec.Header().Values("Vary"); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Vary = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_Vary(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('p1', '<html>P1</html>')`)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	serve := func(t *testing.T, h *HTMLFromDuckDB, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/page/p1", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec
+	}
+
+	newHandler := func(vary []string) *HTMLFromDuckDB {
+		h := &HTMLFromDuckDB{
+			Table:      "html",
+			HTMLColumn: "html",
+			IDColumn:   "id",
+			Vary:       vary,
+			db:         db,
+			logger:     zap.NewNop(),
+		}
+		h.negotiate("accept-encoding")
+		h.negotiate("Accept-Encoding")
+		return h
+	}
+
+	t.Run("lists negotiated headers once", func(t *testing.T) {
+		rec := serve(t, newHandler(nil), "")
+		if got := rec.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding" {
+			t.Errorf("Vary = %q, want [Accept-Encoding]", got)
+		}
+	})
+
+	t.Run("included on 304 responses", func(t *testing.T) {
+		rec := serve(t, newHandler(nil), generateETag("<html>P1</html>"))
+		if rec.Code != http.StatusNotModified {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotModified)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Vary = %q, want Accept-Encoding", got)
+		}
+	})
+
+	t.Run("override replaces negotiated headers", func(t *testing.T) {
+		rec := serve(t, newHandler([]string{"Cookie"}), "")
+		if got := rec.Header().Values("Vary"); len(got) != 1 || got[0] != "Cookie" {
+			t.Errorf("Vary = %q, want [Cookie]", got)
+		}
+	})
+
+	t.Run("none disables Vary", func(t *testing.T) {
+		rec := serve(t, newHandler([]string{"none"}), "")
+		if got := rec.Header().Get("Vary"); got != "" {
+			t.Errorf("Vary = %q, want none", got)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hierarchyPath returns the part of a request path below base_path, without
+// the leading slash: "guide/intro" for /docs/guide/intro, and "guide/" for
+// /docs/guide/.
+func (h *HTMLFromDuckDB) hierarchyPath(p string) string {
+	if h.BasePath != "" && (p == h.BasePath || strings.HasPrefix(p, h.BasePath+"/")) {
+		p = p[len(h.BasePath):]
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+// indexPrefix returns the prefix of the index requested by r with
+// hierarchy_enabled: the hierarchy path up to its last slash.
+func (h *HTMLFromDuckDB) indexPrefix(r *http.Request) string {
+	if !h.HierarchyEnabled {
+		return ""
+	}
+	p := h.hierarchyPath(r.URL.Path)
+	return p[:strings.LastIndex(p, "/")+1]
+}
+
+// breadcrumbs returns a DuckDB list literal with a {name, path} struct for
+// each level of prefix, outermost first, so "guide/setup/" gives guide and
+// setup, linking to {base_path}/guide/ and {base_path}/guide/setup/.
+func breadcrumbs(basePath, prefix string) string {
+	var items []string
+	path := basePath + "/"
+	for _, name := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		path += name + "/"
+		items = append(items, fmt.Sprintf("{'name': '%s', 'path': '%s'}",
+			escapeSQLString(name),
+			escapeSQLString(path)))
+	}
+	if len(items) == 0 {
+		// An untyped [] would leave macros unable to read the fields
+		return "[]::STRUCT(name VARCHAR, path VARCHAR)[]"
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
@@ -0,0 +1,92 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_Hierarchy(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES
+			('intro', '<p>Intro</p>'),
+			('guide/intro', '<p>Guide intro</p>'),
+			('guide/setup/install', '<p>Install</p>');
+		CREATE MACRO render_index(page := 1, base_path := '', prefix := '', breadcrumbs := []) AS TABLE
+		SELECT concat(
+			(SELECT coalesce(string_agg(b.name || '=' || b.path, ' > '), '') FROM (SELECT unnest(breadcrumbs) AS b)),
+			' | ',
+			(SELECT string_agg(id, ',' ORDER BY id) FROM html WHERE starts_with(id, prefix) AND id <> prefix)
+		) AS html;
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	h := &HTMLFromDuckDB{
+		DatabasePath:     path,
+		Table:            "html",
+		BasePath:         "/docs",
+		IndexEnabled:     true,
+		IndexCacheTTL:    "1m",
+		HierarchyEnabled: true,
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	for target, want := range map[string]string{
+		"/docs/intro":               "<p>Intro</p>",
+		"/docs/guide/intro":         "<p>Guide intro</p>",
+		"/docs/guide/setup/install": "<p>Install</p>",
+		"/docs/":                    " | guide/intro,guide/setup/install,intro",
+		"/docs":                     " | guide/intro,guide/setup/install,intro",
+		"/docs/guide/":              "guide=/docs/guide/ | guide/intro,guide/setup/install",
+		"/docs/guide/setup/":        "guide=/docs/guide/ > setup=/docs/guide/setup/ | guide/setup/install",
+		"/docs/guide/?page=2":       "guide=/docs/guide/ | guide/intro,guide/setup/install",
+		"/docs/guide/setup/?again":  "guide=/docs/guide/ > setup=/docs/guide/setup/ | guide/setup/install", // from the index cache
+	} {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil), emptyNextHandler()); err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		if rec.Body.String() != want {
+			t.Errorf("%s: body = %q, want %q", target, rec.Body.String(), want)
+		}
+	}
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"":              "[]::STRUCT(name VARCHAR, path VARCHAR)[]",
+		"guide/":        "[{'name': 'guide', 'path': '/docs/guide/'}]",
+		"it's/setup/":   "[{'name': 'it''s', 'path': '/docs/it''s/'}, {'name': 'setup', 'path': '/docs/it''s/setup/'}]",
+		"guide//setup/": "[{'name': 'guide', 'path': '/docs/guide/'}, {'name': 'setup', 'path': '/docs/guide/setup/'}]",
+	} {
+		if got := breadcrumbs("/docs", prefix); got != want {
+			t.Errorf("breadcrumbs(%q) = %s, want %s", prefix, got, want)
+		}
+	}
+
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(caddyfile.NewTestDispenser("html_from_duckdb {\n\thierarchy_enabled true\n}")); err != nil || !h.HierarchyEnabled {
+		t.Errorf("hierarchy_enabled = %v, err = %v", h.HierarchyEnabled, err)
+	}
+}
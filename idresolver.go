@@ -0,0 +1,59 @@
+package caddyhtmlduckdb
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// IDResolver maps a request to the ID of the record it is for, e.g. by
+// decoding a hashid or base62 path segment or looking the URL up in another
+// service. Resolvers are Caddy modules in the duckdb.id_resolvers
+// namespace, registered and configured like ResponseTransformer modules,
+// and one is selected with id_resolver.
+type IDResolver interface {
+	// ResolveID returns the record ID for r. raw is the ID the handler
+	// would otherwise use: the id_param value or the last path segment,
+	// empty for paths ending in "/". An empty ID serves the index page
+	// if it's enabled and is a 400 otherwise. An error is a 404, unless it
+	// is a caddyhttp.HandlerError, which is returned as it is.
+	ResolveID(r *http.Request, raw string) (string, error)
+}
+
+// idResolverNamespace is the Caddy module namespace of ID resolvers.
+const idResolverNamespace = "duckdb.id_resolvers"
+
+// provisionIDResolver loads the configured ID resolver.
+func (h *HTMLFromDuckDB) provisionIDResolver(ctx caddy.Context) error {
+	if len(h.IDResolverRaw) == 0 {
+		return nil
+	}
+	mod, name, err := loadInlineModule(ctx, idResolverNamespace, "resolver", h.IDResolverRaw)
+	if err != nil {
+		return fmt.Errorf("id_resolver: %v", err)
+	}
+	resolver, ok := mod.(IDResolver)
+	if !ok {
+		return fmt.Errorf("module %s is not an IDResolver", name)
+	}
+	h.idResolver = resolver
+	return nil
+}
+
+// resolveID resolves the ID extracted from r with the configured resolver.
+func (h *HTMLFromDuckDB) resolveID(r *http.Request, raw string) (string, error) {
+	id, err := h.idResolver.ResolveID(r, raw)
+	if err == nil {
+		return id, nil
+	}
+	var herr caddyhttp.HandlerError
+	if errors.As(err, &herr) {
+		return "", err
+	}
+	h.log(r.Context()).Debug("unresolved ID", zap.String("id", raw), zap.Error(err))
+	return "", caddyhttp.Error(http.StatusNotFound, fmt.Errorf("resolving ID %q: %v", raw, err))
+}
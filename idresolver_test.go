@@ -0,0 +1,131 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(testPrefixResolver{})
+}
+
+// testPrefixResolver strips Prefix from the ID and fails without it.
+type testPrefixResolver struct {
+	Prefix string `json:"prefix,omitempty"`
+}
+
+func (testPrefixResolver) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "duckdb.id_resolvers.test_prefix",
+		New: func() caddy.Module { return new(testPrefixResolver) },
+	}
+}
+
+func (p *testPrefixResolver) ResolveID(r *http.Request, raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	if raw == "forbidden" {
+		return "", caddyhttp.Error(http.StatusForbidden, fmt.Errorf("forbidden"))
+	}
+	id, ok := strings.CutPrefix(raw, p.Prefix)
+	if !ok {
+		return "", fmt.Errorf("missing prefix %s", p.Prefix)
+	}
+	return id, nil
+}
+
+func (p *testPrefixResolver) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next()
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	p.Prefix = d.Val()
+	return nil
+}
+
+func TestServeHTTP_IDResolver(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('1', '<p>One</p>');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	h := &HTMLFromDuckDB{
+		DatabasePath:  path,
+		Table:         "html",
+		IDResolverRaw: json.RawMessage(`{"resolver": "test_prefix", "prefix": "w-"}`),
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/works/w-1", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	if rec.Body.String() != "<p>One</p>" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+	for target, status := range map[string]int{
+		"/works/1":         http.StatusNotFound,
+		"/works/forbidden": http.StatusForbidden,
+		"/works/":          http.StatusBadRequest,
+	} {
+		err := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil), emptyNextHandler())
+		if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != status {
+			t.Errorf("%s: expected %d, got %v", target, status, err)
+		}
+	}
+}
+
+func TestProvisionIDResolver(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, raw := range []string{
+		`{"prefix": "w-"}`,
+		`{"resolver": "no_such_resolver"}`,
+		`{"resolver": "test_suffix"}`,
+	} {
+		h := &HTMLFromDuckDB{IDResolverRaw: json.RawMessage(raw)}
+		if err := h.provisionIDResolver(ctx); err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
+
+func TestParseIDResolver(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		id_resolver test_prefix w-
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if got := string(h.IDResolverRaw); got != `{"prefix":"w-","resolver":"test_prefix"}` {
+		t.Errorf("IDResolverRaw = %s", got)
+	}
+}
@@ -0,0 +1,145 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// infoTimeout bounds the catalog queries behind database info.
+const infoTimeout = 2 * time.Second
+
+// DatabaseInfo describes the DuckDB build and database file a handler uses,
+// so that version skew between nodes shows up in monitoring.
+type DatabaseInfo struct {
+	DuckDBVersion string          `json:"duckdb_version"`
+	Extensions    []ExtensionInfo `json:"extensions"`
+	// File details are omitted for in-memory databases.
+	FileSizeBytes *int64     `json:"file_size_bytes,omitempty"`
+	WALSizeBytes  *int64     `json:"wal_size_bytes,omitempty"`
+	FileModified  *time.Time `json:"file_modified,omitempty"`
+}
+
+// ExtensionInfo is a loaded DuckDB extension.
+type ExtensionInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// databaseInfo collects the DuckDB version, the loaded extensions and the
+// size and modification time of the database file and its WAL. The
+// modification time is the later of the two, since writes land in the WAL
+// until a checkpoint.
+func (h *HTMLFromDuckDB) databaseInfo(ctx context.Context) (*DatabaseInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, infoTimeout)
+	defer cancel()
+
+	info := &DatabaseInfo{Extensions: []ExtensionInfo{}}
+	if err := h.database().QueryRowContext(ctx, tagQuery(ctx, "SELECT version()")).Scan(&info.DuckDBVersion); err != nil {
+		return nil, err
+	}
+	rows, err := h.database().QueryContext(ctx,
+		"SELECT extension_name, coalesce(extension_version, '') FROM duckdb_extensions() WHERE loaded ORDER BY extension_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ext ExtensionInfo
+		if err := rows.Scan(&ext.Name, &ext.Version); err != nil {
+			return nil, err
+		}
+		info.Extensions = append(info.Extensions, ext)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if h.DatabasePath == "" || h.DatabasePath == ":memory:" {
+		return info, nil
+	}
+	if stat, err := os.Stat(h.DatabasePath); err == nil {
+		size, modified := stat.Size(), stat.ModTime()
+		info.FileSizeBytes, info.FileModified = &size, &modified
+		if wal, err := os.Stat(h.DatabasePath + ".wal"); err == nil {
+			walSize := wal.Size()
+			info.WALSizeBytes = &walSize
+			if wal.ModTime().After(modified) {
+				walModified := wal.ModTime()
+				info.FileModified = &walModified
+			}
+		}
+	}
+	return info, nil
+}
+
+// Metrics describing the handlers' databases, served by Caddy's metrics
+// endpoint.
+var (
+	infoDesc = prometheus.NewDesc("caddy_html_duckdb_info",
+		"DuckDB version used by a handler (always 1).",
+		[]string{"instance", "duckdb_version"}, nil)
+	extensionDesc = prometheus.NewDesc("caddy_html_duckdb_extension_info",
+		"DuckDB extension loaded in a handler's database (always 1).",
+		[]string{"instance", "extension", "version"}, nil)
+	fileSizeDesc = prometheus.NewDesc("caddy_html_duckdb_database_size_bytes",
+		"Size of a handler's database file, plus its WAL.",
+		[]string{"instance"}, nil)
+	fileModifiedDesc = prometheus.NewDesc("caddy_html_duckdb_database_modified_timestamp_seconds",
+		"Last modification time of a handler's database file or its WAL.",
+		[]string{"instance"}, nil)
+)
+
+// registerMetrics registers the package's metrics with Caddy's registry
+// when the first handler is provisioned.
+var registerMetrics sync.Once
+
+// infoCollector reports database info for the live handlers when Prometheus
+// scrapes, so it needs no per-handler registration across reloads.
+type infoCollector struct{}
+
+// Describe implements prometheus.Collector.
+func (infoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- infoDesc
+	ch <- extensionDesc
+	ch <- fileSizeDesc
+	ch <- fileModifiedDesc
+	describePoolMetrics(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (infoCollector) Collect(ch chan<- prometheus.Metric) {
+	// During a reload the old and new handler share a name; report the
+	// newer one, which is registered last.
+	latest := make(map[string]*HTMLFromDuckDB)
+	var names []string
+	for _, h := range liveInstances() {
+		name := h.instanceName()
+		if _, ok := latest[name]; !ok {
+			names = append(names, name)
+		}
+		latest[name] = h
+	}
+	for _, name := range names {
+		latest[name].collectPoolMetrics(ch, name)
+		info, err := latest[name].databaseInfo(context.Background())
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(infoDesc, prometheus.GaugeValue, 1, name, info.DuckDBVersion)
+		for _, ext := range info.Extensions {
+			ch <- prometheus.MustNewConstMetric(extensionDesc, prometheus.GaugeValue, 1, name, ext.Name, ext.Version)
+		}
+		if info.FileSizeBytes != nil {
+			size := *info.FileSizeBytes
+			if info.WALSizeBytes != nil {
+				size += *info.WALSizeBytes
+			}
+			ch <- prometheus.MustNewConstMetric(fileSizeDesc, prometheus.GaugeValue, float64(size), name)
+			ch <- prometheus.MustNewConstMetric(fileModifiedDesc, prometheus.GaugeValue, float64(info.FileModified.Unix()), name)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func openInfoTestDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "info.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR); CHECKPOINT`); err != nil {
+		t.Fatal(err)
+	}
+	return db, path
+}
+
+func TestDatabaseInfo(t *testing.T) {
+	db, path := openInfoTestDB(t)
+	h := &HTMLFromDuckDB{db: db, DatabasePath: path}
+	info, err := h.databaseInfo(t.Context())
+	if err != nil {
+		t.Fatalf("databaseInfo: %v", err)
+	}
+	if !strings.HasPrefix(info.DuckDBVersion, "v") {
+		t.Errorf("DuckDBVersion = %q", info.DuckDBVersion)
+	}
+	if info.FileSizeBytes == nil || *info.FileSizeBytes == 0 || info.FileModified == nil {
+		t.Errorf("file details missing: %+v", info)
+	}
+
+	h = &HTMLFromDuckDB{db: db, DatabasePath: ":memory:"}
+	if info, err = h.databaseInfo(t.Context()); err != nil || info.FileSizeBytes != nil || info.FileModified != nil {
+		t.Errorf("in-memory info = %+v, err = %v", info, err)
+	}
+}
+
+func TestServeHTTP_HealthInfo(t *testing.T) {
+	db, path := openInfoTestDB(t)
+	get := func(detailed bool) HealthResponse {
+		h := &HTMLFromDuckDB{
+			db:             db,
+			DatabasePath:   path,
+			Table:          "html",
+			HealthEnabled:  true,
+			HealthPath:     "_health",
+			HealthDetailed: detailed,
+			logger:         zap.NewNop(),
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_health", nil), emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		var resp HealthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return resp
+	}
+	if resp := get(true); resp.Info == nil || resp.Info.DuckDBVersion == "" || resp.Info.FileSizeBytes == nil {
+		t.Errorf("detailed info = %+v", resp.Info)
+	}
+	if resp := get(false); resp.Info != nil {
+		t.Errorf("info without detailed = %+v", resp.Info)
+	}
+}
+
+func TestInfoCollector(t *testing.T) {
+	db, path := openInfoTestDB(t)
+	h := &HTMLFromDuckDB{db: db, DatabasePath: path, Table: "html", Name: "info-test"}
+	registerInstance(h)
+	defer unregisterInstance(h)
+	// A handler left over from a reload is reported only once
+	stale := &HTMLFromDuckDB{db: db, DatabasePath: path, Table: "html", Name: "info-test"}
+	registerInstance(stale)
+	defer unregisterInstance(stale)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(infoCollector{})
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	found := map[string]bool{}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "instance" && l.GetValue() == "info-test" {
+					found[mf.GetName()] = true
+				}
+			}
+		}
+	}
+	for _, name := range []string{
+		"caddy_html_duckdb_info",
+		"caddy_html_duckdb_database_size_bytes",
+		"caddy_html_duckdb_database_modified_timestamp_seconds",
+	} {
+		if !found[name] {
+			t.Errorf("missing %s", name)
+		}
+	}
+}
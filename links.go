@@ -0,0 +1,87 @@
+package caddyhtmlduckdb
+
+import (
+	"bufio"
+	"bytes"
+	"slices"
+	"strings"
+)
+
+// hrefSuffix marks a column holding the link targets of another column: in
+// HTML tables, the cells of column X link to the values of column X__href,
+// which isn't shown itself.
+const hrefSuffix = "__href"
+
+// pairLinks finds the X__href columns that have an X column to link.
+func (b *duckbox) pairLinks() {
+	for range b.names {
+		b.links = append(b.links, -1)
+		b.hidden = append(b.hidden, false)
+	}
+	for i, name := range b.names {
+		target, ok := strings.CutSuffix(name, hrefSuffix)
+		if !ok {
+			continue
+		}
+		if col := slices.Index(b.names, target); col >= 0 {
+			b.links[col] = i
+			b.hidden[i] = true
+		}
+	}
+}
+
+// safeHref reports whether a link target may be used as an href: a path or
+// URL without a scheme, or an http, https or mailto URL. Empty (NULL)
+// targets and other schemes, such as javascript:, leave the cell unlinked.
+func safeHref(href []byte) bool {
+	if len(href) == 0 {
+		return false
+	}
+	i := bytes.IndexAny(href, ":/?#")
+	if i < 0 || href[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(string(href[:i])) {
+	case "http", "https", "mailto":
+		return true
+	default:
+		return false
+	}
+}
+
+// writeLinkCell writes a padded cell like writeCell, with the text wrapped
+// in an anchor to href. The padding stays outside the anchor.
+func (b *duckbox) writeLinkCell(w *bufio.Writer, col int, text []byte, width int, href []byte) {
+	pad := b.widths[col] - width
+	w.WriteByte(' ')
+	if b.right[col] {
+		writeRepeated(w, spaces, 1, pad)
+	}
+	w.WriteString(`<a href="`)
+	writeAttr(w, href)
+	w.WriteString(`">`)
+	w.Write(text)
+	w.WriteString(`</a>`)
+	if !b.right[col] {
+		writeRepeated(w, spaces, 1, pad)
+	}
+	w.WriteByte(' ')
+}
+
+// writeAttr writes s escaped for a double-quoted HTML attribute value.
+func writeAttr[T string | []byte](w *bufio.Writer, s T) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '&':
+			w.WriteString("&amp;")
+		case '"':
+			w.WriteString("&#34;")
+		case '<':
+			w.WriteString("&lt;")
+		case '>':
+			w.WriteString("&gt;")
+		default:
+			w.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_TableLinks(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE MACRO listing(base_path := '') AS TABLE
+		SELECT * FROM (VALUES
+			('Dune', base_path || '/w1?a=1&b="2"', 3),
+			('Emma', 'javascript:alert(1)', 12),
+			('Ulysses', NULL, 7)
+		) t(title, title__href, n)
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+	handler := &HTMLFromDuckDB{
+		Table:      "html",
+		TableMacro: "listing",
+		TablePath:  "_list",
+		BasePath:   "/works",
+		db:         db,
+		logger:     zap.NewNop(),
+	}
+	get := func(query string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/works/_list?"+query, nil), emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec.Body.String()
+	}
+
+	want := "<pre class=\"duckbox\">\n" +
+		" title    n  \n" +
+		"─────────────\n" +
+		"             \n" +
+		` <a href="/works/w1?a=1&amp;b=&#34;2&#34;">Dune</a>      3 ` + "\n" +
+		" Emma     12 \n" +
+		" Ulysses   7 \n" +
+		"</pre>"
+	if got := get(""); got != want {
+		t.Errorf("body mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Link targets are ordinary columns in the data formats.
+	if got := get("format=csv"); !strings.HasPrefix(got, "title,title__href,n\n") {
+		t.Errorf("CSV lost the link column:\n%s", got)
+	}
+}
+
+func TestDuckbox_LinksInText(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT 'x' AS a, '/x' AS a__href, '/orphan' AS b__href`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	box := getDuckbox()
+	defer putDuckbox(box)
+	box.format = &TableFormat{MaxCellWidth: 2}
+	if err := box.scan(rows, -1); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	var out strings.Builder
+	if err := box.renderTextTo(&out); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	// A link column without its column to link stays visible, and link
+	// targets are never cut to max_cell_width.
+	want := " a  b__href \n" +
+		"────────────\n" +
+		"            \n" +
+		" x  /…      \n"
+	if out.String() != want {
+		t.Errorf("text mismatch\ngot:\n%q\nwant:\n%q", out.String(), want)
+	}
+	if href, _ := box.cell(0, 1); string(href) != "/x" {
+		t.Errorf("link target = %q", href)
+	}
+}
+
+func TestSafeHref(t *testing.T) {
+	for href, want := range map[string]bool{
+		"":                     false,
+		"/works/1":             true,
+		"w1?x=a:b":             true,
+		"https://example.org":  true,
+		"MAILTO:a@example.org": true,
+		"javascript:alert(1)":  false,
+		"data:text/html,x":     false,
+	} {
+		if got := safeHref([]byte(href)); got != want {
+			t.Errorf("safeHref(%q) = %v, want %v", href, got, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lockErrorMessage is part of DuckDB's error when another process holds a
+// conflicting lock on the database file, typically a writer.
+const lockErrorMessage = "could not set lock on file"
+
+// lockBackoffMax caps the delay between attempts to open a locked database.
+const lockBackoffMax = 2 * time.Second
+
+// isLockError reports whether err is DuckDB failing to lock the database
+// file because another process holds it.
+func isLockError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), lockErrorMessage)
+}
+
+// lockState records whether the handler's database is currently locked by
+// another process: set by the first lock error, cleared by the next
+// successful query.
+type lockState struct {
+	held atomic.Pointer[lockEvent]
+}
+
+// lockEvent describes a lock conflict that hasn't cleared yet.
+type lockEvent struct {
+	since time.Time
+	err   string
+}
+
+// noteLock updates the lock state with a query's outcome. Other errors
+// leave it unchanged, since they say nothing about the lock.
+func (h *HTMLFromDuckDB) noteLock(err error) {
+	if h.lock == nil {
+		return
+	}
+	switch {
+	case err == nil:
+		if h.lock.held.Load() != nil {
+			h.lock.held.Store(nil)
+			h.logger.Info("database lock conflict cleared")
+		}
+	case isLockError(err):
+		if h.lock.held.CompareAndSwap(nil, &lockEvent{since: time.Now(), err: err.Error()}) {
+			h.logger.Warn("database locked by another process", zap.Error(err))
+		}
+	}
+}
+
+// checkLock reports a lock conflict that hasn't cleared as a failed health
+// check.
+func (h *HTMLFromDuckDB) checkLock() *CheckResult {
+	event := h.lock.held.Load()
+	if event == nil {
+		return &CheckResult{Status: "ok"}
+	}
+	return &CheckResult{
+		Status: "error",
+		Code:   "database_locked",
+		Error:  fmt.Sprintf("locked by another process since %s: %s", event.since.UTC().Format(time.RFC3339), event.err),
+	}
+}
+
+// lockAware reports whether the handler waits for and reports lock
+// conflicts.
+func (h *HTMLFromDuckDB) lockAware() bool {
+	return h.lockWait > 0 || h.AttachReadOnly
+}
+
+// acquirePoolWaiting is acquirePool, retried with backoff for up to
+// lock_wait while another process holds the database file's lock. With
+// attach_read_only, opening the pool doesn't fail on a lock in the first
+// place (see openPool).
+func (h *HTMLFromDuckDB) acquirePoolWaiting(ctx context.Context, cfg poolConfig, settings poolSettings) (*dbPool, bool, error) {
+	deadline := time.Now().Add(h.lockWait)
+	delay := 100 * time.Millisecond
+	for {
+		pool, loaded, err := acquirePool(cfg, settings)
+		if !isLockError(err) {
+			return pool, loaded, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, false, err
+		}
+		wait := min(delay, remaining)
+		h.logger.Warn("database locked by another process, waiting",
+			zap.String("database", h.DatabasePath),
+			zap.Duration("retry_in", wait),
+			zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, false, errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, lockBackoffMax)
+	}
+}
+
+// attachStatements returns the statements that attach the database file at
+// path read-only to an in-memory primary and make it the default, under the
+// name DuckDB would give it when opened directly.
+func attachStatements(path string) []string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return []string{
+		fmt.Sprintf("ATTACH IF NOT EXISTS '%s' AS \"%s\" (READ_ONLY)", escapeSQLString(path), strings.ReplaceAll(name, `"`, `""`)),
+		fmt.Sprintf("USE \"%s\"", strings.ReplaceAll(name, `"`, `""`)),
+	}
+}
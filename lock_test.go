@@ -0,0 +1,181 @@
+package caddyhtmlduckdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// TestLockHolderProcess is not a real test: holdLock runs it in a child
+// process that keeps a database open for writing until its stdin closes.
+func TestLockHolderProcess(t *testing.T) {
+	path := os.Getenv("HTML_DUCKDB_LOCK_HOLDER")
+	if path == "" {
+		t.Skip("only run as a helper process")
+	}
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CHECKPOINT"); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout.WriteString("locked\n")
+	io.Copy(io.Discard, os.Stdin)
+}
+
+// holdLock locks the database at path from another process, as an ETL
+// writer would, and returns a function releasing the lock.
+func holdLock(t *testing.T, path string) func() {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHolderProcess$")
+	cmd.Env = append(os.Environ(), "HTML_DUCKDB_LOCK_HOLDER="+path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(stdout).ReadString('\n')
+	if line != "locked\n" {
+		cmd.Process.Kill()
+		t.Fatalf("lock holder failed: %q", line)
+	}
+	var released bool
+	release := func() {
+		if !released {
+			released = true
+			stdin.Close()
+			cmd.Wait()
+		}
+	}
+	t.Cleanup(release)
+	return release
+}
+
+func TestLockConflicts(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "works.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE html AS SELECT 'a' AS id, '<p>a</p>' AS html"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	release := holdLock(t, path)
+
+	t.Run("lock_wait gives up", func(t *testing.T) {
+		h := &HTMLFromDuckDB{DatabasePath: path, Table: "html", LockWait: "300ms"}
+		start := time.Now()
+		err := h.Provision(ctx)
+		if err == nil {
+			h.Cleanup()
+			t.Fatal("expected Provision to fail while the file is locked")
+		}
+		if !isLockError(err) || time.Since(start) < 200*time.Millisecond {
+			t.Errorf("err = %v after %v", err, time.Since(start))
+		}
+	})
+
+	t.Run("attach_read_only", func(t *testing.T) {
+		h := &HTMLFromDuckDB{
+			DatabasePath:   path,
+			Table:          "html",
+			AttachReadOnly: true,
+			HealthEnabled:  true,
+			HealthPath:     "_health",
+		}
+		if err := h.Provision(ctx); err != nil {
+			t.Fatalf("Provision: %v", err)
+		}
+		defer h.Cleanup()
+		get := func(path string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler())
+			if err != nil {
+				rec.Code = http.StatusInternalServerError
+			}
+			return rec
+		}
+
+		rec := get("/_health")
+		if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "database_locked") {
+			t.Errorf("locked health: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		if rec := get("/a"); rec.Code == http.StatusOK {
+			t.Error("record served from a database that couldn't be attached")
+		}
+
+		release()
+		if rec := get("/a"); rec.Code != http.StatusOK || rec.Body.String() != "<p>a</p>" {
+			t.Errorf("after release: status = %d, body = %q", rec.Code, rec.Body.String())
+		}
+		if rec := get("/_health"); rec.Code != http.StatusOK {
+			t.Errorf("health after release: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestProvision_AttachReadOnlyErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readWrite := false
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"no database_path": {Table: "html", AttachReadOnly: true},
+		"read-write":       {Table: "html", DatabasePath: "x.duckdb", AttachReadOnly: true, ReadOnly: &readWrite},
+		"bad lock_wait":    {Table: "html", LockWait: "forever"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestAttachStatements(t *testing.T) {
+	got := strings.Join(attachStatements("/srv/it's.duckdb"), "; ")
+	want := `ATTACH IF NOT EXISTS '/srv/it''s.duckdb' AS "it's" (READ_ONLY); USE "it's"`
+	if got != want {
+		t.Errorf("attachStatements = %s, want %s", got, want)
+	}
+}
+
+func TestParseLockOptions(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		lock_wait 30s
+		attach_read_only true
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.LockWait != "30s" || !h.AttachReadOnly {
+		t.Errorf("LockWait = %q, AttachReadOnly = %v", h.LockWait, h.AttachReadOnly)
+	}
+}
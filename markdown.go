@@ -0,0 +1,65 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// defaultMarkdownExtensions is used when no markdown_extensions are set.
+var defaultMarkdownExtensions = []string{"gfm"}
+
+// markdownExtensions maps the names accepted by markdown_extensions to
+// goldmark extensions.
+var markdownExtensions = map[string]goldmark.Extender{
+	"gfm":             extension.GFM,
+	"table":           extension.Table,
+	"strikethrough":   extension.Strikethrough,
+	"linkify":         extension.Linkify,
+	"tasklist":        extension.TaskList,
+	"footnote":        extension.Footnote,
+	"definition_list": extension.DefinitionList,
+	"typographer":     extension.Typographer,
+}
+
+// newMarkdown builds the Markdown converter. Unless unsafe is set, raw HTML
+// in the source is omitted and links with dangerous schemes (javascript:,
+// vbscript:, ...) are dropped, so stored Markdown can't inject scripts.
+func newMarkdown(extensions []string, unsafe bool) (goldmark.Markdown, error) {
+	if len(extensions) == 0 {
+		extensions = defaultMarkdownExtensions
+	}
+
+	var exts []goldmark.Extender
+	for _, name := range extensions {
+		ext, ok := markdownExtensions[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown markdown extension %q", name)
+		}
+		exts = append(exts, ext)
+	}
+
+	var rendererOpts []goldmark.Option
+	if unsafe {
+		rendererOpts = append(rendererOpts, goldmark.WithRendererOptions(html.WithUnsafe()))
+	}
+
+	return goldmark.New(append(rendererOpts,
+		goldmark.WithExtensions(exts...),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	)...), nil
+}
+
+// renderMarkdown converts Markdown source to HTML.
+func (h *HTMLFromDuckDB) renderMarkdown(src string) (string, error) {
+	var buf bytes.Buffer
+	if err := h.markdown.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
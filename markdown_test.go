@@ -0,0 +1,158 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestNewMarkdown(t *testing.T) {
+	t.Run("rejects unknown extensions", func(t *testing.T) {
+		if _, err := newMarkdown([]string{"gfm", "emoji"}, false); err == nil {
+			t.Error("expected error for unknown extension")
+		}
+	})
+
+	tests := []struct {
+		name       string
+		extensions []string
+		unsafe     bool
+		src        string
+		contains   []string
+		excludes   []string
+	}{
+		{
+			name:     "gfm tables by default",
+			src:      "| a | b |\n|---|---|\n| 1 | 2 |\n",
+			contains: []string{"<table>", "<td>1</td>"},
+		},
+		{
+			name:     "headings get ids",
+			src:      "# Hello World\n",
+			contains: []string{`<h1 id="hello-world">Hello World</h1>`},
+		},
+		{
+			name:     "raw HTML omitted",
+			src:      "<script>alert(1)</script>\n\ntext <b>bold</b>\n",
+			contains: []string{"<!-- raw HTML omitted -->", "text"},
+			excludes: []string{"<script>", "<b>"},
+		},
+		{
+			name:     "dangerous links dropped",
+			src:      "[click](javascript:alert(1))\n",
+			excludes: []string{"javascript:"},
+		},
+		{
+			name:     "unsafe passes raw HTML through",
+			unsafe:   true,
+			src:      "text <b>bold</b>\n",
+			contains: []string{"<b>bold</b>"},
+		},
+		{
+			name:       "only configured extensions",
+			extensions: []string{"strikethrough"},
+			src:        "~~gone~~\n\n| a |\n|---|\n| 1 |\n",
+			contains:   []string{"<del>gone</del>"},
+			excludes:   []string{"<table>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := newMarkdown(tt.extensions, tt.unsafe)
+			if err != nil {
+				t.Fatalf("newMarkdown: %v", err)
+			}
+			h := &HTMLFromDuckDB{markdown: md}
+			got, err := h.renderMarkdown(tt.src)
+			if err != nil {
+				t.Fatalf("renderMarkdown: %v", err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("output should contain %q, got %q", want, got)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("output should not contain %q, got %q", unwanted, got)
+				}
+			}
+		})
+	}
+}
+
+func TestServeHTTP_Markdown(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, body_md VARCHAR);
+		INSERT INTO html VALUES ('1', '# From HTML column', '# From *Markdown*');
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up table: %v", err)
+	}
+
+	md, err := newMarkdown(nil, false)
+	if err != nil {
+		t.Fatalf("newMarkdown: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		handler *HTMLFromDuckDB
+		want    string
+	}{
+		{
+			name: "markdown_column",
+			handler: &HTMLFromDuckDB{
+				Table:          "html",
+				HTMLColumn:     "html",
+				IDColumn:       "id",
+				MarkdownColumn: "body_md",
+			},
+			want: `<h1 id="from-markdown">From <em>Markdown</em></h1>`,
+		},
+		{
+			name: "render_markdown",
+			handler: &HTMLFromDuckDB{
+				Table:          "html",
+				HTMLColumn:     "html",
+				IDColumn:       "id",
+				RenderMarkdown: true,
+			},
+			want: `<h1 id="from-html-column">From HTML column</h1>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.handler.db = db
+			tt.handler.markdown = md
+			tt.handler.logger = zap.NewNop()
+
+			req := httptest.NewRequest(http.MethodGet, "/1", nil)
+			rec := httptest.NewRecorder()
+			if err := tt.handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+				t.Fatalf("ServeHTTP error: %v", err)
+			}
+
+			body := rec.Body.String()
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.want)
+			}
+			if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(len(body)) {
+				t.Errorf("Content-Length = %s, want %d", cl, len(body))
+			}
+		})
+	}
+}
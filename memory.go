@@ -0,0 +1,158 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// loadAlias is the name the database file is attached under while it is
+// copied into memory.
+const loadAlias = "html_from_duckdb_load"
+
+// loadStatements returns the statements that copy the database file at path
+// into an in-memory database, named as DuckDB names the file when opening
+// it directly so that qualified names keep working.
+func loadStatements(path string) (setup, load []string) {
+	name := strings.ReplaceAll(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), `"`, `""`)
+	setup = []string{
+		fmt.Sprintf(`ATTACH IF NOT EXISTS ':memory:' AS "%s"`, name),
+		fmt.Sprintf(`USE "%s"`, name),
+	}
+	load = []string{
+		fmt.Sprintf("ATTACH '%s' AS %s (READ_ONLY)", escapeSQLString(path), loadAlias),
+		fmt.Sprintf(`COPY FROM DATABASE %s TO "%s"`, loadAlias, name),
+		"DETACH " + loadAlias,
+	}
+	return setup, load
+}
+
+// fileStamp identifies a version of the database file at path by the size
+// and modification time of the file and its WAL, following symlinks.
+func fileStamp(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	stamp := fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+	if wal, err := os.Stat(path + ".wal"); err == nil {
+		stamp += fmt.Sprintf("+%d-%d", wal.Size(), wal.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
+
+// provisionMemory loads the database file into memory and, unless
+// memory_reload_interval is 0, starts checking the file for changes, loading
+// each new version into a fresh in-memory database and swapping it in. The
+// swap uses the same machinery as read replicas.
+func (h *HTMLFromDuckDB) provisionMemory(ctx context.Context, cfg poolConfig, settings poolSettings) error {
+	if h.DatabasePath == "" {
+		return fmt.Errorf("load_into_memory requires database_path")
+	}
+	if !*h.ReadOnly {
+		return fmt.Errorf("load_into_memory requires read_only")
+	}
+	if h.Sync != nil || h.AttachReadOnly {
+		return fmt.Errorf("load_into_memory can't be combined with sync or attach_read_only")
+	}
+	if h.MemoryReloadInterval == "" {
+		h.MemoryReloadInterval = "30s"
+	}
+	interval, err := time.ParseDuration(h.MemoryReloadInterval)
+	if err != nil || interval < 0 {
+		return fmt.Errorf("invalid memory_reload_interval: %q", h.MemoryReloadInterval)
+	}
+
+	cfg.connStr = ""
+	cfg.loadFrom = h.DatabasePath
+	// Stamp the file before copying it, so a change made during the copy
+	// is picked up by the next check.
+	stamp, err := fileStamp(h.DatabasePath)
+	if err != nil {
+		return err
+	}
+	rep := &replica{interval: interval, pool: cfg, settings: settings, sum: stamp}
+	h.replica = rep
+	pool, err := h.openMemoryCopy()
+	if err != nil {
+		h.replica = nil
+		return err
+	}
+	rep.current.Store(pool)
+	if interval == 0 {
+		return nil
+	}
+
+	reloadCtx, stop := context.WithCancel(ctx)
+	rep.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-reloadCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := h.reloadMemory(reloadCtx); err != nil && reloadCtx.Err() == nil {
+				h.logger.Warn("reloading database into memory failed",
+					zap.String("database", h.DatabasePath),
+					zap.Error(err))
+			}
+		}
+	}()
+	return nil
+}
+
+// openMemoryCopy opens a pool on a new in-memory copy of the database file
+// and checks that it has the handler's table.
+func (h *HTMLFromDuckDB) openMemoryCopy() (*dbPool, error) {
+	start := time.Now()
+	pool, err := openPool(h.replica.pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database into memory: %v", err)
+	}
+	var n int64
+	if err := pool.db.QueryRow("SELECT count(*) FROM " + sanitizeIdentifier(h.Table)).Scan(&n); err != nil {
+		pool.Destruct()
+		return nil, err
+	}
+	pool.apply(h.replica.settings)
+	h.logger.Info("database loaded into memory",
+		zap.String("database", h.DatabasePath),
+		zap.Int64("rows", n),
+		zap.Duration("duration", time.Since(start)))
+	return pool, nil
+}
+
+// reloadMemory loads the database file into memory again if it changed
+// since the copy in use was loaded, and swaps the new copy in. It reports
+// whether it did.
+func (h *HTMLFromDuckDB) reloadMemory(ctx context.Context) (bool, error) {
+	rep := h.replica
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	stamp, err := fileStamp(h.DatabasePath)
+	if err != nil {
+		return false, err
+	}
+	if stamp == rep.sum {
+		return false, nil
+	}
+	pool, err := h.openMemoryCopy()
+	if err != nil {
+		return false, err
+	}
+	old := rep.current.Swap(pool)
+	rep.sum = stamp
+	time.AfterFunc(replicaCloseDelay, func() { old.Destruct() })
+	h.flushCaches()
+	h.notify(eventDatabaseSwap, map[string]any{"database": h.DatabasePath, "in_memory": true})
+	h.audit(ctx, auditDatabaseSwap, "memory reload", nil, map[string]any{"database": h.DatabasePath})
+	return true, nil
+}
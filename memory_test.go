@@ -0,0 +1,111 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestLoadIntoMemory(t *testing.T) {
+	replicaCloseDelay = 0
+	defer func() { replicaCloseDelay = time.Minute }()
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "works.duckdb")
+	write := func(stmt string) {
+		t.Helper()
+		db, err := sql.Open("duckdb", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`CREATE TABLE html AS SELECT 'a' AS id, '<p>a</p>' AS html;
+		CREATE MACRO greeting() AS 'hello'`)
+
+	h := &HTMLFromDuckDB{
+		DatabasePath:         path,
+		Table:                "html",
+		LoadIntoMemory:       true,
+		MemoryReloadInterval: "1h",
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler()); err != nil {
+			rec.Code = http.StatusNotFound
+		}
+		return rec
+	}
+
+	if rec := get("/a"); rec.Code != http.StatusOK || rec.Body.String() != "<p>a</p>" {
+		t.Errorf("record: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	var name, greeting string
+	if err := h.database().QueryRow("SELECT current_database(), greeting()").Scan(&name, &greeting); err != nil || name != "works" || greeting != "hello" {
+		t.Errorf("copy: database = %q, greeting() = %q, err = %v", name, greeting, err)
+	}
+
+	// The file isn't held open, so a writer can change it.
+	write("INSERT INTO html VALUES ('b', '<p>b</p>')")
+	if rec := get("/b"); rec.Code == http.StatusOK {
+		t.Error("change served before reloading")
+	}
+	if swapped, err := h.reloadMemory(ctx); err != nil || !swapped {
+		t.Fatalf("reloadMemory = %v, %v", swapped, err)
+	}
+	if rec := get("/b"); rec.Code != http.StatusOK || rec.Body.String() != "<p>b</p>" {
+		t.Errorf("after reload: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if swapped, err := h.reloadMemory(ctx); err != nil || swapped {
+		t.Errorf("unchanged file reloaded: %v, %v", swapped, err)
+	}
+}
+
+func TestProvision_LoadIntoMemoryErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readWrite := false
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"no database_path": {Table: "html", LoadIntoMemory: true},
+		"read-write":       {Table: "html", DatabasePath: "x.duckdb", LoadIntoMemory: true, ReadOnly: &readWrite},
+		"missing file":     {Table: "html", DatabasePath: filepath.Join(t.TempDir(), "x.duckdb"), LoadIntoMemory: true},
+		"bad interval":     {Table: "html", DatabasePath: "x.duckdb", LoadIntoMemory: true, MemoryReloadInterval: "often"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestParseLoadIntoMemory(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		load_into_memory true
+		memory_reload_interval 10s
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if !h.LoadIntoMemory || h.MemoryReloadInterval != "10s" {
+		t.Errorf("LoadIntoMemory = %v, MemoryReloadInterval = %q", h.LoadIntoMemory, h.MemoryReloadInterval)
+	}
+}
@@ -0,0 +1,141 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// parseMetaColumns parses meta_columns arguments of the form "key" or
+// "key=column" into a map from meta key to column name.
+func parseMetaColumns(args []string) (map[string]string, error) {
+	cols := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, col, found := strings.Cut(arg, "=")
+		if !found {
+			col = key
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		col = strings.TrimSpace(col)
+		if key == "" || col == "" {
+			return nil, fmt.Errorf("invalid meta column %q", arg)
+		}
+		cols[key] = col
+	}
+	return cols, nil
+}
+
+// metaKeys returns the configured meta keys in a stable order, so the query
+// and the injected tags don't change between requests.
+func (h *HTMLFromDuckDB) metaKeys() []string {
+	keys := make([]string, 0, len(h.MetaColumns))
+	for key := range h.MetaColumns {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metaTags builds the head tags for a row's metadata. Tags already present in
+// doc are skipped, so stored pages that carry their own meta tags win.
+// Relative image and url values are made absolute against the request, as
+// social preview crawlers require.
+func metaTags(doc string, keys []string, values map[string]string, r *http.Request) string {
+	lower := strings.ToLower(doc)
+	has := func(attr, name string) bool {
+		return strings.Contains(lower, attr+`="`+name+`"`)
+	}
+
+	var b strings.Builder
+	meta := func(attr, name, content string) {
+		if has(attr, name) {
+			return
+		}
+		fmt.Fprintf(&b, `<meta %s="%s" content="%s">`, attr, name, html.EscapeString(content))
+		b.WriteByte('\n')
+	}
+
+	for _, key := range keys {
+		value := strings.TrimSpace(values[key])
+		if value == "" {
+			continue
+		}
+		switch key {
+		case "title":
+			if !strings.Contains(lower, "<title") {
+				fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(value))
+			}
+			meta("property", "og:title", value)
+			meta("name", "twitter:title", value)
+		case "description":
+			meta("name", "description", value)
+			meta("property", "og:description", value)
+			meta("name", "twitter:description", value)
+		case "image":
+			value = absoluteURL(r, value)
+			meta("property", "og:image", value)
+			meta("name", "twitter:image", value)
+			meta("name", "twitter:card", "summary_large_image")
+		case "url":
+			value = absoluteURL(r, value)
+			if !strings.Contains(lower, `rel="canonical"`) {
+				fmt.Fprintf(&b, "<link rel=\"canonical\" href=\"%s\">\n", html.EscapeString(value))
+			}
+			meta("property", "og:url", value)
+		default:
+			meta("property", "og:"+key, value)
+		}
+	}
+	return b.String()
+}
+
+// absoluteURL resolves a root-relative URL against the request's host.
+func absoluteURL(r *http.Request, u string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") {
+		return u
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + u
+}
+
+// injectHead inserts tags at the end of the document's <head>. Documents
+// without a head get one after the <html> tag; fragments get the tags
+// prepended (after any doctype), which browsers place in the head when
+// parsing.
+func injectHead(doc, tags string) string {
+	if tags == "" {
+		return doc
+	}
+	if i := indexFold(doc, "</head>"); i >= 0 {
+		return doc[:i] + tags + doc[i:]
+	}
+	if i := indexFold(doc, "<html"); i >= 0 {
+		if end := strings.IndexByte(doc[i:], '>'); end >= 0 {
+			pos := i + end + 1
+			return doc[:pos] + "\n<head>\n" + tags + "</head>" + doc[pos:]
+		}
+	}
+	if indexFold(doc, "<!doctype") == 0 {
+		if end := strings.IndexByte(doc, '>'); end >= 0 {
+			return doc[:end+1] + "\n" + tags + doc[end+1:]
+		}
+	}
+	return tags + doc
+}
+
+// indexFold returns the index of the first ASCII case-insensitive match of
+// substr in s, or -1. Unlike searching strings.ToLower(s), the index is
+// always valid for s.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
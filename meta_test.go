@@ -0,0 +1,140 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestParseMetaColumns(t *testing.T) {
+	got, err := parseMetaColumns([]string{"title", "Description=summary", "image=cover_url"})
+	if err != nil {
+		t.Fatalf("parseMetaColumns: %v", err)
+	}
+	want := map[string]string{"title": "title", "description": "summary", "image": "cover_url"}
+	for key, col := range want {
+		if got[key] != col {
+			t.Errorf("meta column %s = %q, want %q", key, got[key], col)
+		}
+	}
+
+	if _, err := parseMetaColumns([]string{"image="}); err == nil {
+		t.Error("expected error for empty column name")
+	}
+}
+
+func TestInjectHead(t *testing.T) {
+	tags := `<meta property="og:title" content="T">` + "\n"
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			name: "before closing head",
+			doc:  "<html><HEAD><title>x</title></HEAD><body></body></html>",
+			want: "<html><HEAD><title>x</title>" + tags + "</HEAD><body></body></html>",
+		},
+		{
+			name: "adds head after html tag",
+			doc:  `<html lang="en"><body>hi</body></html>`,
+			want: `<html lang="en">` + "\n<head>\n" + tags + "</head><body>hi</body></html>",
+		},
+		{
+			name: "after doctype",
+			doc:  "<!DOCTYPE html><p>hi</p>",
+			want: "<!DOCTYPE html>\n" + tags + "<p>hi</p>",
+		},
+		{
+			name: "prepended to fragment",
+			doc:  "<p>hi</p>",
+			want: tags + "<p>hi</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := injectHead(tt.doc, tags); got != tt.want {
+				t.Errorf("injectHead() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_MetaColumns(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, title VARCHAR, summary VARCHAR, cover VARCHAR);
+		INSERT INTO html VALUES
+			('1', '<html><head></head><body>One</body></html>', 'Tom & Jerry', 'A "classic"', '/img/1.png'),
+			('2', '<html><head><title>Own</title><meta property="og:title" content="Own"></head></html>', 'Ignored', NULL, NULL);
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up table: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		Table:      "html",
+		HTMLColumn: "html",
+		IDColumn:   "id",
+		MetaColumns: map[string]string{
+			"title":       "title",
+			"description": "summary",
+			"image":       "cover",
+		},
+		db:     db,
+		logger: zap.NewNop(),
+	}
+
+	t.Run("injects escaped tags", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/1", nil)
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+
+		body := rec.Body.String()
+		for _, want := range []string{
+			"<title>Tom &amp; Jerry</title>",
+			`<meta property="og:title" content="Tom &amp; Jerry">`,
+			`<meta name="description" content="A &#34;classic&#34;">`,
+			`<meta property="og:image" content="http://example.com/img/1.png">`,
+			`<meta name="twitter:card" content="summary_large_image">`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("body should contain %s, got %q", want, body)
+			}
+		}
+		if !strings.HasSuffix(body, "</head><body>One</body></html>") {
+			t.Errorf("tags should be injected into head, got %q", body)
+		}
+	})
+
+	t.Run("keeps tags already in the page", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/2", nil)
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+
+		body := rec.Body.String()
+		if strings.Contains(body, "Ignored</title>") || strings.Count(body, `property="og:title"`) != 1 {
+			t.Errorf("existing title tags should not be repeated, got %q", body)
+		}
+		if !strings.Contains(body, `<meta name="twitter:title" content="Ignored">`) {
+			t.Errorf("missing tags should still be added, got %q", body)
+		}
+		if strings.Contains(body, "og:image") {
+			t.Errorf("NULL values should not produce tags, got %q", body)
+		}
+	})
+}
@@ -0,0 +1,356 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(MissLogger{})
+	httpcaddyfile.RegisterHandlerDirective("duckdb_miss_log", parseMissLogger)
+	httpcaddyfile.RegisterDirectiveOrder("duckdb_miss_log", httpcaddyfile.Before, "file_server")
+}
+
+// Sources of logged misses.
+const (
+	missRecord = "record" // a record this handler doesn't have
+	missRoute  = "route"  // a 404 from the handlers after duckdb_miss_log
+)
+
+// missBatchSize is the most misses written with one INSERT.
+const missBatchSize = 100
+
+// MissLog stores the paths of requests that ended in 404 in a DuckDB table,
+// so they can be mined with SQL for broken links and missing redirects:
+//
+//	time TIMESTAMPTZ, instance VARCHAR, source VARCHAR, host VARCHAR,
+//	path VARCHAR, query VARCHAR, referer VARCHAR, user_agent VARCHAR
+//
+// Misses are queued and written in batches in the background, so they never
+// slow down a response; when the queue is full they are dropped.
+type MissLog struct {
+	// Table is the table misses are written to. It is created if it
+	// doesn't exist.
+	Table string `json:"table"`
+
+	// QueueSize is how many misses can wait to be written before new ones
+	// are dropped.
+	// Default: 1000
+	QueueSize int `json:"queue_size,omitempty"`
+
+	// FlushInterval is how often queued misses are written.
+	// Default: "5s"
+	FlushInterval string `json:"flush_interval,omitempty"`
+}
+
+// miss is one logged request.
+type miss struct {
+	time      time.Time
+	source    string
+	host      string
+	path      string
+	query     string
+	referer   string
+	userAgent string
+}
+
+// missWriter writes queued misses to the miss_log table until stopped.
+type missWriter struct {
+	config   *MissLog
+	interval time.Duration
+	queue    chan miss
+	dropped  atomic.Int64
+	stop     context.CancelFunc
+	done     chan struct{}
+}
+
+// provisionMissLog validates the miss_log block, creates the table and
+// starts the writer.
+func (h *HTMLFromDuckDB) provisionMissLog(ctx context.Context) error {
+	c := h.MissLog
+	if c == nil {
+		return nil
+	}
+	if c.Table == "" {
+		return fmt.Errorf("miss_log requires a table")
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 1000
+	}
+	if c.QueueSize < 0 {
+		return fmt.Errorf("miss_log queue_size must be positive")
+	}
+	if c.FlushInterval == "" {
+		c.FlushInterval = "5s"
+	}
+	interval, err := time.ParseDuration(c.FlushInterval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid miss_log flush_interval: %q", c.FlushInterval)
+	}
+
+	createCtx, cancel := context.WithTimeout(ctx, auditTimeout)
+	defer cancel()
+	_, err = h.database().ExecContext(createCtx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		time TIMESTAMPTZ NOT NULL,
+		instance VARCHAR NOT NULL,
+		source VARCHAR NOT NULL,
+		host VARCHAR,
+		path VARCHAR NOT NULL,
+		query VARCHAR,
+		referer VARCHAR,
+		user_agent VARCHAR
+	)`, sanitizeQualifiedIdentifier(c.Table)))
+	if err != nil {
+		return fmt.Errorf("failed to create miss_log table: %v", err)
+	}
+
+	mw := &missWriter{
+		config:   c,
+		interval: interval,
+		queue:    make(chan miss, c.QueueSize),
+		done:     make(chan struct{}),
+	}
+	runCtx, stop := context.WithCancel(ctx)
+	mw.stop = stop
+	h.missLog = mw
+	go h.runMissLog(runCtx, mw)
+	return nil
+}
+
+// logMiss queues a miss of r for the miss_log table. It never blocks: when
+// the queue is full the miss is dropped.
+func (h *HTMLFromDuckDB) logMiss(r *http.Request, source string) {
+	mw := h.missLog
+	if mw == nil {
+		return
+	}
+	m := miss{
+		time:      time.Now(),
+		source:    source,
+		host:      r.Host,
+		path:      r.URL.Path,
+		query:     r.URL.RawQuery,
+		referer:   r.Referer(),
+		userAgent: r.UserAgent(),
+	}
+	select {
+	case mw.queue <- m:
+	default:
+		mw.dropped.Add(1)
+	}
+}
+
+// runMissLog writes queued misses every flush_interval, or as soon as a
+// batch is full. When stopped, it writes what is still queued.
+func (h *HTMLFromDuckDB) runMissLog(ctx context.Context, mw *missWriter) {
+	defer close(mw.done)
+	ticker := time.NewTicker(mw.interval)
+	defer ticker.Stop()
+	var batch []miss
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case m := <-mw.queue:
+					batch = append(batch, m)
+				default:
+					h.writeMisses(batch)
+					return
+				}
+			}
+		case m := <-mw.queue:
+			if batch = append(batch, m); len(batch) < missBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		h.writeMisses(batch)
+		batch = batch[:0]
+	}
+}
+
+// writeMisses inserts misses into the miss_log table in batches. Failures
+// are logged; the misses are not retried.
+func (h *HTMLFromDuckDB) writeMisses(misses []miss) {
+	mw := h.missLog
+	if n := mw.dropped.Swap(0); n > 0 {
+		h.logger.Warn("miss_log queue full, dropped misses", zap.Int64("dropped", n))
+	}
+	table := sanitizeQualifiedIdentifier(mw.config.Table)
+	instance := h.instanceName()
+	for len(misses) > 0 {
+		batch := misses[:min(len(misses), missBatchSize)]
+		misses = misses[len(batch):]
+
+		args := make([]any, 0, len(batch)*8)
+		for _, m := range batch {
+			args = append(args, m.time, instance, m.source, m.host, m.path,
+				nullIfEmpty(m.query), nullIfEmpty(m.referer), nullIfEmpty(m.userAgent))
+		}
+		query := fmt.Sprintf("INSERT INTO %s VALUES %s", table,
+			strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?, ?), ", len(batch)), ", "))
+		ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+		_, err := h.database().ExecContext(ctx, query, args...)
+		cancel()
+		if err != nil {
+			h.logger.Error("miss_log write failed", zap.Int("misses", len(batch)), zap.Error(err))
+		}
+	}
+}
+
+// stopMissLog stops the writer after it has written the queued misses.
+func (h *HTMLFromDuckDB) stopMissLog() {
+	h.missLog.stop()
+	<-h.missLog.done
+}
+
+// nullIfEmpty returns nil for an empty string, to be stored as NULL.
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// parseMissLog parses a miss_log block:
+//
+//	miss_log {
+//	    table <table>
+//	    queue_size <n>
+//	    flush_interval <duration>
+//	}
+//
+// or just miss_log <table>.
+func parseMissLog(d *caddyfile.Dispenser) (*MissLog, error) {
+	m := &MissLog{}
+	if d.NextArg() {
+		m.Table = d.Val()
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "table":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			m.Table = d.Val()
+
+		case "queue_size":
+			if err := parseIntArg(d, &m.QueueSize); err != nil {
+				return nil, err
+			}
+
+		case "flush_interval":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			m.FlushInterval = d.Val()
+
+		default:
+			return nil, d.Errf("unrecognized miss_log subdirective: %s", d.Val())
+		}
+	}
+	return m, nil
+}
+
+// MissLogger logs the requests that the handlers after it answer with 404
+// in the miss_log table of an html_from_duckdb handler, so that misses of
+// file_server or other handlers can be analyzed with the handler's own:
+//
+//	duckdb_miss_log works
+//	file_server
+//
+// The handler is looked up by instance name on every request, so it may
+// be defined anywhere in the config; without it, or without its miss_log,
+// misses are not logged.
+type MissLogger struct {
+	// Instance is the name of the html_from_duckdb handler (its name
+	// option, or table[@base_path]) whose miss_log is used.
+	Instance string `json:"instance"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (MissLogger) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.duckdb_miss_log",
+		New: func() caddy.Module { return new(MissLogger) },
+	}
+}
+
+// ServeHTTP passes the request on and logs it if the response is a 404,
+// whether written by the next handler or returned as an error.
+func (m *MissLogger) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	sw := &statusWriter{ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w}}
+	err := next.ServeHTTP(sw, r)
+	var herr caddyhttp.HandlerError
+	if sw.status == http.StatusNotFound || (sw.status == 0 && errors.As(err, &herr) && herr.StatusCode == http.StatusNotFound) {
+		if h := lookupInstance(m.Instance); h != nil {
+			h.logMiss(r, missRoute)
+		}
+	}
+	return err
+}
+
+// statusWriter remembers the status code written to it.
+type statusWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	status int
+}
+
+// WriteHeader records the first final status code.
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriterWrapper.WriteHeader(status)
+}
+
+// Write records an implicit 200.
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriterWrapper.Write(p)
+}
+
+// UnmarshalCaddyfile sets up the module from Caddyfile tokens:
+//
+//	duckdb_miss_log <instance>
+func (m *MissLogger) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	m.Instance = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+// parseMissLogger unmarshals Caddyfile tokens into a MissLogger.
+func parseMissLogger(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m MissLogger
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	return &m, err
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*MissLogger)(nil)
+	_ caddyfile.Unmarshaler       = (*MissLogger)(nil)
+)
@@ -0,0 +1,132 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestMissLog(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('w1', '<p>One</p>')`); err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	h := &HTMLFromDuckDB{
+		db:         db,
+		Name:       "works",
+		Table:      "html",
+		HTMLColumn: "html",
+		IDColumn:   "id",
+		MissLog:    &MissLog{Table: "misses", FlushInterval: "1h"},
+		logger:     zap.NewNop(),
+	}
+	if err := h.provisionMissLog(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	registerInstance(h)
+	defer unregisterInstance(h)
+
+	// A missing record, and a found one.
+	for _, path := range []string{"/w9", "/w1"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Referer", "https://example.org/links")
+		h.ServeHTTP(httptest.NewRecorder(), req, emptyNextHandler())
+	}
+
+	// 404s after duckdb_miss_log, returned as errors or written.
+	logger := &MissLogger{Instance: "works"}
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.URL.Path {
+		case "/files/missing.pdf":
+			return caddyhttp.Error(http.StatusNotFound, nil)
+		case "/files/gone.pdf":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+		return nil
+	})
+	for _, path := range []string{"/files/missing.pdf?v=2", "/files/gone.pdf", "/files/ok.pdf"} {
+		logger.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil), next)
+	}
+	(&MissLogger{Instance: "nope"}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil), next)
+
+	// Stopping writes what is queued.
+	h.stopMissLog()
+	rows, err := db.Query(`SELECT instance, source, path, coalesce(query, ''), coalesce(referer, '') FROM misses ORDER BY time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var instance, source, path, query, referer string
+		if err := rows.Scan(&instance, &source, &path, &query, &referer); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Join([]string{instance, source, path, query, referer}, " "))
+	}
+	want := []string{
+		"works record /w9  https://example.org/links",
+		"works route /files/missing.pdf v=2 ",
+		"works route /files/gone.pdf  ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("misses:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	h.MissLog = &MissLog{Table: "misses", FlushInterval: "soon"}
+	if err := h.provisionMissLog(context.Background()); err == nil {
+		t.Error("expected error for invalid flush_interval")
+	}
+}
+
+func TestMissLog_DropsWhenFull(t *testing.T) {
+	h := &HTMLFromDuckDB{missLog: &missWriter{queue: make(chan miss, 1)}}
+	req := httptest.NewRequest(http.MethodGet, "/a", nil)
+	for range 3 {
+		h.logMiss(req, missRecord)
+	}
+	if len(h.missLog.queue) != 1 || h.missLog.dropped.Load() != 2 {
+		t.Errorf("queued %d, dropped %d", len(h.missLog.queue), h.missLog.dropped.Load())
+	}
+}
+
+func TestParseMissLog(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		miss_log {
+			table analytics.misses
+			queue_size 50
+			flush_interval 10s
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if m := h.MissLog; m == nil || m.Table != "analytics.misses" || m.QueueSize != 50 || m.FlushInterval != "10s" {
+		t.Errorf("MissLog = %+v", h.MissLog)
+	}
+
+	d = caddyfile.NewTestDispenser(`duckdb_miss_log works`)
+	var m MissLogger
+	if err := m.UnmarshalCaddyfile(d); err != nil || m.Instance != "works" {
+		t.Errorf("MissLogger = %+v, %v", m, err)
+	}
+	if err := new(MissLogger).UnmarshalCaddyfile(caddyfile.NewTestDispenser(`duckdb_miss_log`)); err == nil {
+		t.Error("expected error without an instance")
+	}
+}
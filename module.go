@@ -6,12 +6,16 @@ import (
 	"context"
 	"crypto/md5"
 	"database/sql"
-	"database/sql/driver"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -20,11 +24,11 @@ import (
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
-	duckdb "github.com/duckdb/duckdb-go/v2"
-	"github.com/olekukonko/tablewriter"
-	"github.com/olekukonko/tablewriter/renderer"
-	"github.com/olekukonko/tablewriter/tw"
+	"github.com/dustin/go-humanize"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuin/goldmark"
 	"go.uber.org/zap"
+	"golang.org/x/text/encoding"
 )
 
 func init() {
@@ -46,10 +50,26 @@ func parseHTMLFromDuckDB(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, e
 
 // HTMLFromDuckDB is a Caddy HTTP handler that serves HTML content from a DuckDB table.
 type HTMLFromDuckDB struct {
+	// Name identifies this handler in admin API routes, metrics and logs, so
+	// several html_from_duckdb blocks can be told apart.
+	// Default: the table name, followed by "@" and BasePath if set
+	Name string `json:"name,omitempty"`
+
+	// Overrides names the options set to false, 0 or "" on purpose, such
+	// as index_enabled false, so that they aren't taken from the global
+	// defaults. The Caddyfile adapter fills it in; a JSON config can name
+	// the options instead, since options it has keys for aren't inherited.
+	Overrides []string `json:"overrides,omitempty"`
+
 	// DatabasePath is the path to the DuckDB database file.
 	// Use ":memory:" for in-memory database.
 	DatabasePath string `json:"database_path,omitempty"`
 
+	// Database names a database of the duckdb app to use instead of
+	// DatabasePath. The app owns its pool, so its path, read_only, init
+	// SQL, resource limits and pool settings are set there, not here.
+	Database string `json:"database,omitempty"`
+
 	// Table is the name of the table containing HTML content.
 	Table string `json:"table"`
 
@@ -66,31 +86,274 @@ type HTMLFromDuckDB struct {
 	// Default: extracts from path (e.g., /page/123 -> 123)
 	IDParam string `json:"id_param,omitempty"`
 
+	// IDResolverRaw is an IDResolver module (duckdb.id_resolvers
+	// namespace) that maps requests outside record routes to record IDs,
+	// given the ID taken from IDParam or the path.
+	IDResolverRaw json.RawMessage `json:"id_resolver,omitempty" caddy:"namespace=duckdb.id_resolvers inline_key=resolver"`
+
 	// WhereClause allows additional SQL WHERE conditions.
 	// The ID condition is always added automatically.
 	// Example: "status = 'published' AND deleted_at IS NULL"
 	WhereClause string `json:"where_clause,omitempty"`
 
+	// FilterParams bind query parameters to further columns a record must
+	// match, with typed, parameterized values, e.g. ?lang=en&version=2 to
+	// the language and version columns. Parameters a request leaves out
+	// don't filter. Only for lookups in Table, not with RecordMacro.
+	FilterParams []FilterParam `json:"filter_params,omitempty"`
+
 	// NotFoundRedirect is an optional URL to redirect to when content is not found.
 	// If not set, returns 404 status.
 	NotFoundRedirect string `json:"not_found_redirect,omitempty"`
 
+	// NotFoundMacro is the name of a DuckDB table macro that renders the page
+	// for a missing record, e.g. with "did you mean" suggestions. It is called
+	// with (id, path) and should return a single html column; the result is
+	// served with status 404. If the macro fails or returns no row, the
+	// handler falls back to NotFoundRedirect or a plain 404.
+	NotFoundMacro string `json:"not_found_macro,omitempty"`
+
+	// FallbackFileRoot is a directory tried for records the database
+	// lacks, before the not found handling: a request for ID foo/bar is
+	// served {fallback_file_root}/foo/bar.html if it exists. This lets a
+	// file-based site move into the database a section at a time.
+	FallbackFileRoot string `json:"fallback_file_root,omitempty"`
+
+	// DeletedColumn marks soft-deleted rows: a row is deleted when the column
+	// is true, or for non-boolean columns such as deleted_at, not NULL.
+	// Deleted records are answered with 410 Gone instead of being served.
+	DeletedColumn string `json:"deleted_column,omitempty"`
+
+	// GoneWhereClause is an SQL condition marking rows as gone, e.g.
+	// "status = 'withdrawn'". It can be combined with DeletedColumn.
+	GoneWhereClause string `json:"gone_where_clause,omitempty"`
+
+	// GoneMacro is the name of a DuckDB table macro that renders a tombstone
+	// page for deleted records. It is called with (id, path) and should
+	// return a single html column, served with status 410.
+	GoneMacro string `json:"gone_macro,omitempty"`
+
 	// CacheControl sets the Cache-Control header for successful responses.
 	// Example: "public, max-age=3600"
 	CacheControl string `json:"cache_control,omitempty"`
 
+	// HeadersColumn is the name of an optional column holding a JSON object of
+	// extra response headers for the row, e.g. {"X-Robots-Tag": "noindex"}.
+	// Values may be strings or arrays of strings.
+	HeadersColumn string `json:"headers_column,omitempty"`
+
+	// HeadersAllow lists the header names the headers column may set.
+	// Default: Link, X-Robots-Tag, Content-Security-Policy, Content-Language,
+	// Referrer-Policy, Permissions-Policy
+	HeadersAllow []string `json:"headers_allow,omitempty"`
+
+	// PreloadColumn is the name of an optional column listing critical assets
+	// for the row (a LIST, a JSON array, or a comma/whitespace separated string).
+	// Each asset is sent as a "Link: <url>; rel=preload" header.
+	PreloadColumn string `json:"preload_column,omitempty"`
+
+	// PreloadMacro is the name of an optional DuckDB table macro that returns
+	// the critical assets for a record. It is called with an id parameter and
+	// returns one row per asset: the URL and, optionally, the "as" destination.
+	PreloadMacro string `json:"preload_macro,omitempty"`
+
+	// EarlyHints sends the assets from PreloadMacro in a 103 Early Hints
+	// response before the record is rendered, so browsers can start fetching
+	// them while the database works.
+	// Default: false
+	EarlyHints bool `json:"early_hints,omitempty"`
+
+	// TagsColumn is the name of an optional column with the cache tags of a
+	// record (a LIST, a JSON array, or a comma/whitespace separated string).
+	// Index and search macros must return it too. Tags are sent in
+	// TagsHeaders, for purges by tag at a CDN and in the handler's caches.
+	TagsColumn string `json:"tags_column,omitempty"`
+
+	// TagsHeaders are the response headers the cache tags are sent in.
+	// Default: Surrogate-Key and Cache-Tag
+	TagsHeaders []string `json:"tags_headers,omitempty"`
+
+	// Vary overrides the request headers listed in the Vary response header.
+	// By default the handler lists the headers used by the negotiation
+	// features that are enabled. Use "none" to send no Vary header.
+	Vary []string `json:"vary,omitempty"`
+
+	// MarkdownColumn is the name of a column holding Markdown source. When set,
+	// it is selected instead of HTMLColumn and converted to HTML on each request.
+	MarkdownColumn string `json:"markdown_column,omitempty"`
+
+	// RenderMarkdown treats the content of HTMLColumn (or the record macro's
+	// output) as Markdown and converts it to HTML.
+	// Default: false
+	RenderMarkdown bool `json:"render_markdown,omitempty"`
+
+	// MarkdownExtensions lists the Markdown extensions to enable: gfm, table,
+	// strikethrough, linkify, tasklist, footnote, definition_list, typographer.
+	// Default: gfm
+	MarkdownExtensions []string `json:"markdown_extensions,omitempty"`
+
+	// MarkdownUnsafe passes raw HTML and links with dangerous URL schemes in
+	// Markdown through unchanged. Only enable it for trusted content.
+	// Default: false (raw HTML is omitted)
+	MarkdownUnsafe bool `json:"markdown_unsafe,omitempty"`
+
+	// MetaColumns maps meta keys to columns whose values are injected into the
+	// page's <head> as Open Graph and Twitter tags, so social previews work
+	// even when stored pages lack them. Keys title, description, image and url
+	// also produce <title>, <meta name="description"> and a canonical link;
+	// other keys become og:<key>. Tags already in the page are not repeated.
+	// Example: {"title": "title", "image": "cover_url"}
+	MetaColumns map[string]string `json:"meta_columns,omitempty"`
+
 	// ReadOnly opens the database in read-only mode.
 	// Default: true
 	ReadOnly *bool `json:"read_only,omitempty"`
 
+	// LockWait is how long Provision keeps trying to open a database file
+	// that another process (such as an ETL writer) has locked, with backoff,
+	// before giving up. It also adds a database_lock health check.
+	// Default: 0 (fail right away)
+	LockWait string `json:"lock_wait,omitempty"`
+
+	// AttachReadOnly opens an in-memory database and ATTACHes DatabasePath
+	// to it READ_ONLY on every connection, instead of opening the file
+	// itself. A file locked by a writer then fails queries until the lock
+	// is released, rather than the handler's startup. Requires ReadOnly.
+	AttachReadOnly bool `json:"attach_read_only,omitempty"`
+
+	// LoadIntoMemory copies the database file into an in-memory database
+	// at startup and serves from the copy, trading RAM for latency. The file
+	// is only open while it is copied. Requires ReadOnly.
+	LoadIntoMemory bool `json:"load_into_memory,omitempty"`
+
+	// MemoryReloadInterval is how often the file is checked for changes
+	// with LoadIntoMemory; a changed file is loaded into a fresh copy that
+	// is swapped in atomically. "0" disables reloading.
+	// Default: 30s
+	MemoryReloadInterval string `json:"memory_reload_interval,omitempty"`
+
+	// Datasets are views over external Parquet, CSV or JSON files created
+	// on every connection, so Table can name a dataset instead of a table
+	// in the database. Without DatabasePath the views are all there is.
+	Datasets []Dataset `json:"datasets,omitempty"`
+
+	// DatasetRefreshInterval is how often Iceberg and Delta Lake datasets
+	// pinned to the snapshot current at startup check for a newer one; a
+	// pool pinned to the new snapshots is then swapped in atomically. Empty
+	// or "0" keeps the snapshots until the config is reloaded.
+	DatasetRefreshInterval string `json:"dataset_refresh_interval,omitempty"`
+
+	// Databases are other database files, by name, that a request can
+	// select with DatabaseSelector, e.g. a staging build of the content to
+	// preview through the production site. They are opened read-only, with
+	// the same init SQL file and datasets as the handler's own database.
+	Databases map[string]string `json:"databases,omitempty"`
+
+	// DatabaseSelector is resolved for each request, and names the entry
+	// of Databases its queries use, e.g. "{http.request.header.X-Dataset}".
+	// Empty values use the handler's own database, and names not in
+	// Databases are rejected with 400. It can't be combined with the index,
+	// negative, ESI or tile cache.
+	DatabaseSelector string `json:"database_selector,omitempty"`
+
+	// ShadowDatabasePath is a candidate database, e.g. a new content build,
+	// that a sample of record lookups is repeated on in the background. The
+	// results are compared with what was served and counted in the
+	// caddy_html_duckdb_shadow_reads_total metric; responses are never
+	// affected. Opened read-only.
+	ShadowDatabasePath string `json:"shadow_database_path,omitempty"`
+
+	// ShadowSampleRate is the fraction of record lookups repeated on the
+	// shadow database, between 0 and 1.
+	// Default: 0.1
+	ShadowSampleRate float64 `json:"shadow_sample_rate,omitempty"`
+
+	// CanaryDatabasePath is a new database build that canary_percent of
+	// clients read from instead of the handler's database, for comparing
+	// the two in the caddy_html_duckdb_database_* metrics before a full
+	// cutover. Opened read-only. It can't be combined with the index,
+	// negative, ESI or tile cache.
+	CanaryDatabasePath string `json:"canary_database_path,omitempty"`
+
+	// CanaryPercent is the percentage of clients routed to the canary
+	// database, between 0 and 100. 0 routes no clients while keeping the
+	// canary open.
+	// Default: 10
+	CanaryPercent *float64 `json:"canary_percent,omitempty"`
+
+	// Experiments split record and index pages between variants rendered
+	// with alternative macros. Clients stay in their variant, which is
+	// named in the X-Experiment response header and the
+	// {duckdb.experiment.<name>} placeholder. Record routes are not
+	// affected.
+	Experiments []Experiment `json:"experiments,omitempty"`
+
 	// ConnectionPoolSize sets the maximum number of open connections.
 	// Default: 10
 	ConnectionPoolSize int `json:"connection_pool_size,omitempty"`
 
+	// MaxIdleConns sets the maximum number of idle connections kept in the pool.
+	// Default: half of ConnectionPoolSize
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+
+	// ConnMaxLifetime sets how long a connection may be reused before it is
+	// closed and replaced. Use "0" to keep connections forever.
+	// Default: 1h
+	ConnMaxLifetime string `json:"conn_max_lifetime,omitempty"`
+
+	// ConnMaxIdleTime sets how long a connection may sit idle before it is closed.
+	// Default: no limit
+	ConnMaxIdleTime string `json:"conn_max_idle_time,omitempty"`
+
+	// AnalyticsPoolSize partitions the pool: table, search, query, export
+	// and explain queries get a sub-pool of this many connections, so they
+	// can't take the connections record lookups need.
+	// Default: 0 (one shared pool)
+	AnalyticsPoolSize int `json:"analytics_pool_size,omitempty"`
+
+	// RecordPoolSize sets the connections left for record, index and other
+	// lookups when the pool is partitioned with AnalyticsPoolSize.
+	// Default: ConnectionPoolSize
+	RecordPoolSize int `json:"record_pool_size,omitempty"`
+
+	// MemoryLimit caps the memory DuckDB may use, e.g. "1GB" or "75%".
+	// Applied with SET memory_limit on every pool connection.
+	// Default: DuckDB's own default (80% of system memory)
+	MemoryLimit string `json:"memory_limit,omitempty"`
+
+	// Threads sets the number of threads DuckDB uses for query execution.
+	// Default: DuckDB's own default (number of CPU cores)
+	Threads int `json:"threads,omitempty"`
+
+	// TempDirectory is the directory DuckDB spills to when a query exceeds
+	// the memory limit.
+	// Default: DuckDB's own default ("<database_path>.tmp")
+	TempDirectory string `json:"temp_directory,omitempty"`
+
+	// MaxTempDirectorySize caps the disk space used in TempDirectory, e.g. "10GB".
+	// Default: DuckDB's own default (90% of available disk space)
+	MaxTempDirectorySize string `json:"max_temp_directory_size,omitempty"`
+
 	// QueryTimeout sets the maximum time for query execution.
 	// Default: 5s
 	QueryTimeout string `json:"query_timeout,omitempty"`
 
+	// RetryAttempts is how many times record, index, search, table and API
+	// queries are retried after a transient DuckDB error, such as the
+	// database file being locked during a swap. 0 disables retries.
+	// Default: 2
+	RetryAttempts *int `json:"retry_attempts,omitempty"`
+
+	// RetryBackoff is the delay before the first retry, doubled for each
+	// further retry and jittered.
+	// Default: 50ms
+	RetryBackoff string `json:"retry_backoff,omitempty"`
+
+	// SlowQueryThreshold is the duration from which queries are logged as slow
+	// and kept in the slow query log shown by the admin API ("0" disables).
+	// Default: 1s
+	SlowQueryThreshold string `json:"slow_query_threshold,omitempty"`
+
 	// IndexEnabled enables serving an index page when no ID is provided.
 	// The index is rendered by calling a DuckDB table macro.
 	// Default: false
@@ -101,6 +364,43 @@ type HTMLFromDuckDB struct {
 	// Default: "render_index"
 	IndexMacro string `json:"index_macro,omitempty"`
 
+	// IndexCacheTTL keeps rendered index pages in memory for this long, so
+	// large boards aren't rebuilt on every hit.
+	// Default: no caching
+	IndexCacheTTL string `json:"index_cache_ttl,omitempty"`
+
+	// HierarchyEnabled treats the whole path after BasePath as the record
+	// ID, so /docs/guide/intro serves the record "guide/intro". Paths with
+	// a trailing slash serve the index of that prefix: the index macro is
+	// called with prefix ("guide/", or "" at the root) and breadcrumbs, a
+	// list of {name, path} structs for the ancestors of the prefix.
+	// Default: false
+	HierarchyEnabled bool `json:"hierarchy_enabled,omitempty"`
+
+	// IndexVersionQuery is a cheap query returning a single value that changes
+	// whenever the index does, e.g. "SELECT max(updated_at) FROM html". It is
+	// run on each index request; the ETag is derived from its result, and
+	// cached index pages are rebuilt when it changes.
+	IndexVersionQuery string `json:"index_version_query,omitempty"`
+
+	// InvalidateQuery is polled every InvalidateInterval for a watermark,
+	// e.g. "SELECT max(updated_at) FROM html". When its result changes, the
+	// response caches are flushed and index ETags change with it, so caching
+	// stays safe with databases that are updated in place. Setting it also
+	// enables the index page cache.
+	InvalidateQuery string `json:"invalidate_query,omitempty"`
+
+	// InvalidateInterval is how often InvalidateQuery is run.
+	// Default: 10s
+	InvalidateInterval string `json:"invalidate_interval,omitempty"`
+
+	// NegativeCacheTTL remembers IDs that were not found for this long, so
+	// repeated requests for them are answered without querying DuckDB. The
+	// cache holds the most recent 10000 IDs and is flushed together with the
+	// response caches.
+	// Default: no negative caching
+	NegativeCacheTTL string `json:"negative_cache_ttl,omitempty"`
+
 	// SearchEnabled enables a search endpoint using a DuckDB table macro.
 	// Default: false
 	SearchEnabled bool `json:"search_enabled,omitempty"`
@@ -131,6 +431,97 @@ type HTMLFromDuckDB struct {
 	// The macro should accept an id parameter and return a single html column.
 	RecordMacro string `json:"record_macro,omitempty"`
 
+	// RecordQuery is a SELECT statement that looks up a record, for joins,
+	// UNIONs of content sources and other lookups neither Table nor a
+	// macro fits. Named placeholders are bound as parameters: :id, :host
+	// (without port), :path, and any other :name from the query parameter
+	// of that name (NULL if absent). It must return the record columns,
+	// e.g. html, and can't be combined with RecordMacro or FilterParams.
+	RecordQuery string `json:"record_query,omitempty"`
+
+	// FragmentPath serves records on their own at {base_path}/{fragment_path}/{id},
+	// with includes resolved but no meta tags or per-row headers, for
+	// embedding in other pages. E.g. "_fragment".
+	FragmentPath string `json:"fragment_path,omitempty"`
+
+	// ContentPath serves rows addressed by the SHA-256 hash of their
+	// html_column at {base_path}/{content_path}/{hash}, marked immutable for
+	// caches. Macros link to them with content_url(html). E.g. "_c".
+	ContentPath string `json:"content_path,omitempty"`
+
+	// ContentHashColumn holds the hex SHA-256 hash of html_column, so
+	// content_path lookups need not hash every row.
+	ContentHashColumn string `json:"content_hash_column,omitempty"`
+
+	// Includes resolves <!--#include id="..."--> directives in records by
+	// inserting the referenced records, recursively.
+	// Default: false
+	Includes bool `json:"includes,omitempty"`
+
+	// IncludeMaxDepth limits how deeply includes may nest.
+	// Default: 5
+	IncludeMaxDepth int `json:"include_max_depth,omitempty"`
+
+	// ESI resolves <esi:include src="..."/> tags (plus <esi:remove> and
+	// <!--esi ...-->) in record and index pages by requesting src from this
+	// handler, so mostly static pages can embed changing fragments. Nested
+	// ESI is limited by include_max_depth.
+	// Default: false
+	ESI bool `json:"esi,omitempty"`
+
+	// ESICacheTTL caches the responses of ESI includes for this long, keyed
+	// by src. Cached fragments are also dropped when invalidate_query fires.
+	// Default: "" (no caching)
+	ESICacheTTL string `json:"esi_cache_ttl,omitempty"`
+
+	// RecordRoutes render records under specific path prefixes with their own
+	// macros, ID extraction and Cache-Control, checked in order before the
+	// default record lookup.
+	RecordRoutes []RecordRoute `json:"record_routes,omitempty"`
+
+	// RevisionsPath enables a revision history endpoint at
+	// {record path}/{revisions_path}, listing a record's stored versions and
+	// serving one with ?version=N. E.g. "_revisions".
+	RevisionsPath string `json:"revisions_path,omitempty"`
+
+	// RevisionsTable is the table holding revisions, one row per (id, version).
+	// Default: Table
+	RevisionsTable string `json:"revisions_table,omitempty"`
+
+	// RevisionsMacro is the name of a DuckDB table macro returning the
+	// revisions of a record, called with (id). Used instead of RevisionsTable;
+	// it must return the version, content and (optional) updated columns.
+	RevisionsMacro string `json:"revisions_macro,omitempty"`
+
+	// VersionColumn is the revision number column.
+	// Default: "version"
+	VersionColumn string `json:"version_column,omitempty"`
+
+	// DiffPath is the endpoint next to RevisionsPath that renders an HTML diff
+	// of two revisions, {record path}/{diff_path}?from=N&to=M.
+	// Default: "_diff"
+	DiffPath string `json:"diff_path,omitempty"`
+
+	// UpdatedColumn is an optional timestamp column shown in the revision list,
+	// e.g. "updated_at". It is also the watermark of the changes feed.
+	UpdatedColumn string `json:"updated_column,omitempty"`
+
+	// ChangesPath enables a changes feed at {base_path}/{changes_path}
+	// listing the IDs whose updated_column is later than ?since=, with
+	// optional long polling. Requires updated_column. E.g. "_changes".
+	ChangesPath string `json:"changes_path,omitempty"`
+
+	// ChangesMaxWait caps how long a changes request may wait for a change.
+	// Default: "30s"
+	ChangesMaxWait string `json:"changes_max_wait,omitempty"`
+
+	// ShutdownGracePeriod is how long Cleanup waits for in-flight requests
+	// before closing the database pool; requests still running then are
+	// canceled. Only applies when the pool is actually closed, not when a
+	// reload hands it to the new config.
+	// Default: "10s"
+	ShutdownGracePeriod string `json:"shutdown_grace_period,omitempty"`
+
 	// TableMacro is the name of a DuckDB table macro for rendering tabular data.
 	// The macro returns multiple columns which are formatted as an ASCII table.
 	// URL query parameters are passed to the macro by name.
@@ -140,6 +531,259 @@ type HTMLFromDuckDB struct {
 	// Default: "_table"
 	TablePath string `json:"table_path,omitempty"`
 
+	// TableMaxRows caps the number of rows rendered by the table endpoint.
+	// Further rows are counted but not formatted, and a "… N more rows"
+	// footer and an X-Truncated header report how many were left out.
+	// Use -1 for no limit.
+	// Default: 10000
+	TableMaxRows int `json:"table_max_rows,omitempty"`
+
+	// TableSortColumns limits the columns the table endpoint's order_by
+	// parameter may sort by, e.g. to those a wide macro result is cheap to
+	// sort on. Columns are always checked against the macro's result.
+	// Default: all columns
+	TableSortColumns []string `json:"table_sort_columns,omitempty"`
+
+	// TableFooterMacro is the name of a DuckDB table macro, taking the same
+	// parameters as TableMacro, whose rows (totals, averages) are rendered
+	// below a rule at the foot of the table endpoint's ASCII table. Its
+	// columns are matched to the table's by name. Other formats leave it
+	// out.
+	TableFooterMacro string `json:"table_footer_macro,omitempty"`
+
+	// TableGrid makes the table endpoint take sort, dir, limit and offset
+	// parameters itself, instead of passing them to the macro, and render
+	// its HTML table with sort links in the column headers and previous
+	// and next page links, as a data grid that needs no JavaScript (and
+	// swaps in place with htmx).
+	// Default: false
+	TableGrid bool `json:"table_grid,omitempty"`
+
+	// TableOrder makes the table endpoint's output deterministic, so the
+	// same data always renders the same bytes and ETag. The plan of each
+	// table query is checked for a sort; without one, "require" fails the
+	// request with 500 and "auto" sorts the rows by all columns.
+	// Default: rows in the order the macro returns them
+	TableOrder string `json:"table_order,omitempty"`
+
+	// TableETag sends ETags with the table endpoint's JSON, CSV, NDJSON,
+	// XLSX and Parquet responses, and answers matching If-None-Match
+	// requests with 304, like its HTML and SVG responses. Only bodies
+	// that fit in stream_buffer get one; streamed bodies and Arrow
+	// streams don't.
+	// Default: false
+	TableETag bool `json:"table_etag,omitempty"`
+
+	// TableCacheControl is the Cache-Control header of the table
+	// endpoint's responses, e.g. "public, max-age=300" for reports that
+	// rarely change.
+	// Default: "no-cache"
+	TableCacheControl string `json:"table_cache_control,omitempty"`
+
+	// TableFormat sets how NULLs, numbers, timestamps, booleans and long
+	// values are written in ASCII tables, those of the table endpoint and of
+	// the query endpoint's text and HTML formats.
+	// Default: NULL as an empty cell, values as DuckDB shows them
+	TableFormat *TableFormat `json:"table_format,omitempty"`
+
+	// ValueEncoding sets how DECIMALs, large integers, BLOBs and INTERVALs
+	// are written in JSON and CSV output.
+	// Default: DECIMALs as numbers at their scale, integers beyond ±2^53 as
+	// strings, BLOBs in base64 and INTERVALs as ISO 8601 durations
+	ValueEncoding *ValueEncoding `json:"value_encoding,omitempty"`
+
+	// GeoJSONMacro is the name of a DuckDB table macro whose rows are served
+	// as a GeoJSON FeatureCollection at GeoJSONPath, for map frontends. URL
+	// query parameters are passed to the macro by name, and a bbox
+	// parameter as min_x, min_y, max_x and max_y. The spatial extension is
+	// loaded to convert GEOMETRY columns. Rows are capped by TableMaxRows.
+	GeoJSONMacro string `json:"geojson_macro,omitempty"`
+
+	// GeoJSONPath is the endpoint path for the GeoJSON macro, relative to
+	// BasePath.
+	// Default: "_geojson"
+	GeoJSONPath string `json:"geojson_path,omitempty"`
+
+	// GeoJSONGeometryColumn is the GeoJSON macro's geometry column: a
+	// GEOMETRY, WKB or GeoJSON text column. The other columns become the
+	// features' properties, except id, which becomes their id.
+	// Default: "geom"
+	GeoJSONGeometryColumn string `json:"geojson_geometry_column,omitempty"`
+
+	// TileMacro is the name of a DuckDB table macro serving map tiles at
+	// {tile_path}/{z}/{x}/{y}.mvt and .geojson. It is called with z, x and
+	// y. An .mvt tile is the first column of its first row, a BLOB such as
+	// ST_AsMVT returns; a .geojson tile is its rows, like GeoJSONMacro's,
+	// with the geometry in GeoJSONGeometryColumn.
+	TileMacro string `json:"tile_macro,omitempty"`
+
+	// TilePath is the endpoint path for tiles, relative to BasePath.
+	// Default: "_tiles"
+	TilePath string `json:"tile_path,omitempty"`
+
+	// TileCacheTTL caches tiles for this long, keyed by z/x/y and format.
+	// Cached tiles are also dropped when invalidate_query fires.
+	// Default: "" (no caching)
+	TileCacheTTL string `json:"tile_cache_ttl,omitempty"`
+
+	// APIPath enables a JSON:API style endpoint for records, relative to
+	// BasePath. GET {api_path}/{id} returns one row and GET {api_path}?page=N
+	// a page of rows ordered by IDColumn.
+	// Default: disabled
+	APIPath string `json:"api_path,omitempty"`
+
+	// APIColumns limits the columns returned by the API endpoint.
+	// Default: all columns
+	APIColumns []string `json:"api_columns,omitempty"`
+
+	// APIPageSize is the number of rows per API collection page.
+	// Default: 50
+	APIPageSize int `json:"api_page_size,omitempty"`
+
+	// QueryPath enables an endpoint, relative to BasePath, that runs read-only
+	// SQL from authorized clients (see AuthTokens) and returns the result as
+	// JSON, CSV or an ASCII table depending on the Accept header or format
+	// query parameter. Only single SELECT statements are accepted.
+	// Default: disabled
+	QueryPath string `json:"query_path,omitempty"`
+
+	// QueriesFile is a SQL file of named, parameterized SELECT statements,
+	// each served at {base_path}/{queries_path}/{name} to all clients, with
+	// its parameters taken from the query string. Annotation comments name
+	// each query and declare its parameters' types and defaults and the
+	// formats it's served in; see readQueriesFile.
+	// Default: disabled
+	QueriesFile string `json:"queries_file,omitempty"`
+
+	// QueriesPath is the path, relative to BasePath, of the named queries
+	// in QueriesFile.
+	// Default: "_q"
+	QueriesPath string `json:"queries_path,omitempty"`
+
+	// GraphQLPath enables an endpoint, relative to BasePath, that runs the
+	// named queries in QueriesFile from POSTed JSON documents naming the
+	// query and its variables, {"query": "<name>", "variables": {...}},
+	// and returns the rows as {"data": {"<name>": [...]}}. The query
+	// "__schema" lists the named queries. It's not a GraphQL parser, only
+	// GraphQL's request and response shape. E.g. "graphql".
+	// Default: disabled
+	GraphQLPath string `json:"graphql_path,omitempty"`
+
+	// ExportPath enables an endpoint, relative to BasePath, that lets
+	// authorized clients (see AuthTokens) download a consistent snapshot of
+	// the database: as a DuckDB file, as EXPORT DATABASE output, or selected
+	// tables as Parquet. E.g. "_export".
+	// Default: disabled
+	ExportPath string `json:"export_path,omitempty"`
+
+	// ExplainPath enables an endpoint, relative to BasePath, that lets
+	// authorized clients (see AuthTokens) run the record, index or search
+	// query under EXPLAIN ANALYZE and read DuckDB's profile, to diagnose
+	// slow macros in production. E.g. "_debug/explain".
+	// Default: disabled
+	ExplainPath string `json:"explain_path,omitempty"`
+
+	// StrictRows checks that record, index and search queries return a
+	// single row, since only the first is served. "warn" logs a warning with
+	// the row count; "error" also fails the request with 500.
+	// Default: disabled
+	StrictRows string `json:"strict_rows,omitempty"`
+
+	// PinSnapshot runs all of a request's queries in one transaction on one
+	// connection, so a page composed of several queries sees one state of
+	// the database even if it changes or is swapped mid-request.
+	// Default: false
+	PinSnapshot bool `json:"pin_snapshot,omitempty"`
+
+	// SessionVariables are DuckDB variables set for each request's queries,
+	// by name, so macros can filter rows with getvariable(). Values can hold
+	// placeholders such as {http.auth.user.id}; a value that is empty for
+	// a request sets NULL.
+	SessionVariables map[string]string `json:"session_variables,omitempty"`
+
+	// NullHTML decides what a record whose content column (html_column,
+	// markdown_column or the record macro's html) is NULL gets: "error"
+	// (500), "not_found" (handled like a missing record), "empty" (an empty
+	// page), "column" (the NullHTMLFallback column of the same row) or
+	// "macro" (the html of the NullHTMLFallback macro, called with id).
+	// Default: "error"
+	NullHTML string `json:"null_html,omitempty"`
+
+	// NullHTMLFallback is the column or macro for NullHTML "column" and
+	// "macro". A NULL or missing fallback is handled like a missing record.
+	NullHTMLFallback string `json:"null_html_fallback,omitempty"`
+
+	// Charset is the character set of record content (html_column,
+	// markdown_column and revisions), e.g. "iso-8859-1" or "windows-1252"
+	// for content copied from legacy systems into a BLOB column. Content is
+	// converted to UTF-8, which responses are always served in.
+	// Default: "utf-8"
+	Charset string `json:"charset,omitempty"`
+
+	// ValidateUTF8 checks that record content is valid UTF-8, replacing
+	// invalid bytes with U+FFFD and logging a warning.
+	// Default: false
+	ValidateUTF8 bool `json:"validate_utf8,omitempty"`
+
+	// WarnResponseSize logs a warning for every response body larger than
+	// this many bytes, to find bloated pages in the database.
+	// Default: 0 (disabled)
+	WarnResponseSize int64 `json:"warn_response_size,omitempty"`
+
+	// Compress lists the content codings (br, gzip) to compress record,
+	// index and search pages with, in order of preference. Compressed index
+	// pages are kept in the index cache, so hot pages are compressed once.
+	// Default: none (responses are sent uncompressed)
+	Compress []string `json:"compress,omitempty"`
+
+	// QueryMaxRows caps the number of rows returned by the query endpoint.
+	// Use -1 for no limit.
+	// Default: 10000
+	QueryMaxRows int `json:"query_max_rows,omitempty"`
+
+	// QueryMaxBytes caps the size of a query endpoint response in bytes.
+	// Larger results are rejected with 422. Use -1 for no limit.
+	// Default: 10MB
+	QueryMaxBytes int64 `json:"query_max_bytes,omitempty"`
+
+	// TemplateQueries lets Caddy templates run read-only SQL on this
+	// handler's pool with the duckdbQueryRow and duckdbQueryRows functions
+	// of the duckdb template extension. Queries get query_timeout, and
+	// duckdbQueryRows returns at most query_max_rows rows.
+	// Default: false
+	TemplateQueries bool `json:"template_queries,omitempty"`
+
+	// StreamBuffer is how many bytes of a query or table endpoint result
+	// are buffered. Larger results are streamed to the client as rows are
+	// scanned, so memory use stays flat however many rows a query returns;
+	// an error after streaming has begun aborts the response. Use -1 to
+	// buffer every result.
+	// Default: 1MB
+	StreamBuffer int64 `json:"stream_buffer,omitempty"`
+
+	// NDJSONFlushRows is how many rows of a format=ndjson table result are
+	// written between flushes to the client. NDJSON results are streamed
+	// from the first flush regardless of StreamBuffer.
+	// Default: 100
+	NDJSONFlushRows int `json:"ndjson_flush_rows,omitempty"`
+
+	// AuthTokens lists the bearer tokens accepted by protected endpoints such
+	// as the query endpoint. Clients send "Authorization: Bearer <token>".
+	AuthTokens []string `json:"auth_tokens,omitempty"`
+
+	// APIKeysTable is a DuckDB table of API keys accepted by the query,
+	// export, explain and health endpoints alongside AuthTokens, with the
+	// columns key_hash (hex SHA-256 of the bearer token), scopes (the
+	// endpoints the key may call, or "*") and expires_at (NULL for never).
+	// Lookups are cached for a minute.
+	APIKeysTable string `json:"api_keys_table,omitempty"`
+
+	// AuditTable is a DuckDB table that administrative actions (cache
+	// purges, database swaps and init SQL re-runs) are appended to, with the
+	// time, actor and outcome. It is created if it doesn't exist, so it
+	// needs a writable database. Actions are logged either way.
+	AuditTable string `json:"audit_table,omitempty"`
+
 	// HealthEnabled enables a health check endpoint.
 	// Default: false
 	HealthEnabled bool `json:"health_enabled,omitempty"`
@@ -148,13 +792,137 @@ type HTMLFromDuckDB struct {
 	// Default: "_health"
 	HealthPath string `json:"health_path,omitempty"`
 
-	// HealthDetailed includes connection pool stats and latencies in the response.
+	// HealthDetailed includes connection pool stats and latencies in the response,
+	// and runs each configured macro with canary parameters.
 	// Default: false
 	HealthDetailed bool `json:"health_detailed,omitempty"`
 
-	db      *sql.DB
-	timeout time.Duration
-	logger  *zap.Logger
+	// HealthAuth restricts the health endpoint to clients that send one of
+	// the AuthTokens or an APIKeysTable key with the "health" scope, or
+	// connect from a HealthAllow range; others get 401.
+	// Default: false
+	HealthAuth bool `json:"health_auth,omitempty"`
+
+	// HealthAllow lists client IPs or CIDR ranges, such as the load
+	// balancer's or the cluster's, trusted by the health endpoint without a
+	// token.
+	HealthAllow []string `json:"health_allow,omitempty"`
+
+	// HealthRedactErrors replaces check names, error details and pool stats
+	// with stable error codes for untrusted callers.
+	// Default: false
+	HealthRedactErrors bool `json:"health_redact_errors,omitempty"`
+
+	// HealthCanaryID is the id detailed health checks pass to macros that
+	// take one (record, preload and not found macros) when running them.
+	// Default: "health-canary"
+	HealthCanaryID string `json:"health_canary_id,omitempty"`
+
+	// HealthCanaryTerm is the term detailed health checks search for.
+	// Default: "health"
+	HealthCanaryTerm string `json:"health_canary_term,omitempty"`
+
+	// SelfTestID is a record that Provision requests through the handler
+	// before it goes live; provisioning fails unless it is served with
+	// status 200. Optional.
+	SelfTestID string `json:"self_test_id,omitempty"`
+
+	// SelfTestExpect is text the self-test response must contain. Optional.
+	SelfTestExpect string `json:"self_test_expect,omitempty"`
+
+	// OpenAPIEnabled serves an OpenAPI 3 description of the endpoints this
+	// handler exposes.
+	// Default: false
+	OpenAPIEnabled bool `json:"openapi_enabled,omitempty"`
+
+	// OpenAPIPath is the path for the OpenAPI document, relative to BasePath.
+	// Default: "_openapi.json"
+	OpenAPIPath string `json:"openapi_path,omitempty"`
+
+	// EndpointMatchers route requests to internal endpoints (health, openapi,
+	// table, api, query, export, changes, search) with Caddy matcher sets
+	// instead of their paths under BasePath.
+	EndpointMatchers []EndpointMatcher `json:"endpoint_matchers,omitempty"`
+
+	// TransformersRaw are ResponseTransformer modules (duckdb.transformers
+	// namespace) that post-process record, index and search pages and
+	// JSON API documents, in order, before they are sent.
+	TransformersRaw []json.RawMessage `json:"transformers,omitempty" caddy:"namespace=duckdb.transformers inline_key=transformer"`
+
+	// Sync keeps the database a read replica of a remote primary, replacing
+	// the local copy at DatabasePath when the source changes.
+	Sync *Sync `json:"sync,omitempty"`
+
+	// Quota limits the requests each client may make to the JSON API, query
+	// and table endpoints.
+	Quota *Quota `json:"quota,omitempty"`
+
+	// Backup stores snapshots of the database in another location on a
+	// schedule and on shutdown.
+	Backup *Backup `json:"backup,omitempty"`
+
+	// Webhooks posts JSON notifications about errors, health changes, cache
+	// flushes and database swaps to external URLs.
+	Webhooks *Webhooks `json:"webhooks,omitempty"`
+
+	// MissLog stores the paths of requests answered with 404 (missing
+	// records, and with the duckdb_miss_log directive those of other
+	// handlers such as file_server) in a DuckDB table.
+	MissLog *MissLog `json:"miss_log,omitempty"`
+
+	// LoadShedding rejects requests with 503 when too many are in flight,
+	// search, table and other query endpoints first.
+	LoadShedding *LoadShedding `json:"load_shedding,omitempty"`
+
+	db             *sql.DB
+	pool           *dbPool
+	replica        *replica
+	backups        *backups
+	timeout        time.Duration
+	retryAttempts  int
+	retryBackoff   time.Duration
+	negotiated     []string
+	markdown       goldmark.Markdown
+	charset        encoding.Encoding
+	served         *servedSizes
+	quota          *quotaState
+	apiKeys        *lruCache[keyGrant]
+	apiKeyMisses   *lruCache[struct{}]
+	slowAfter      time.Duration
+	slowLog        *slowQueryLog
+	changesMaxWait time.Duration
+	drainGrace     time.Duration
+	drain          *drainState
+	lockWait       time.Duration
+	lock           *lockState
+	shedRetry      time.Duration
+	healthAllow    []netip.Prefix
+	exportBusy     chan struct{}
+	indexCache     *lruCache[indexPage]
+	notFound       *lruCache[struct{}]
+	esiCache       *lruCache[esiEntry]
+	tileCache      *lruCache[tileEntry]
+	watermark      *watermark
+	stopPoll       context.CancelFunc
+	endpointMatch  map[string]caddyhttp.MatcherSet
+	transformers   []ResponseTransformer
+	idResolver     IDResolver
+	shared         *SharedDatabase
+	inherited      map[string]bool
+	configured     map[string]bool
+	webhooks       *webhookSender
+	missLog        *missWriter
+	databases      map[string]*dbPool
+	selectorHeader string
+	shadow         *shadow
+	canary         *dbPool
+	canaryPercent  float64
+	logger         *zap.Logger
+	config         <-chan struct{} // Done channel of the handler's config, identifying it
+
+	boundRecordQuery  string   // record_query with positional placeholders
+	recordQueryParams []string // names of its placeholders, in order
+	namedQueries      map[string]*namedQuery
 }
 
 // CaddyModule returns the Caddy module information.
@@ -167,7 +935,16 @@ func (HTMLFromDuckDB) CaddyModule() caddy.ModuleInfo {
 
 // Provision sets up the handler.
 func (h *HTMLFromDuckDB) Provision(ctx caddy.Context) error {
-	h.logger = ctx.Logger(h)
+	if err := h.inheritDefaults(ctx); err != nil {
+		return fmt.Errorf("html_from_duckdb defaults: %w", err)
+	}
+	h.expandPlaceholders()
+	h.logger = ctx.Logger(h).With(zap.String("instance", h.instanceName()))
+	if h.Database != "" {
+		if err := h.checkSharedOptions(); err != nil {
+			return err
+		}
+	}
 
 	// Set defaults
 	if h.HTMLColumn == "" {
@@ -183,12 +960,42 @@ func (h *HTMLFromDuckDB) Provision(ctx caddy.Context) error {
 	if h.ConnectionPoolSize == 0 {
 		h.ConnectionPoolSize = 10
 	}
+	if h.MaxIdleConns == 0 {
+		h.MaxIdleConns = h.ConnectionPoolSize / 2
+	}
+	if h.AnalyticsPoolSize > 0 && h.RecordPoolSize == 0 {
+		h.RecordPoolSize = h.ConnectionPoolSize
+	}
+	if h.ConnMaxLifetime == "" {
+		h.ConnMaxLifetime = "1h"
+	}
 	if h.QueryTimeout == "" {
 		h.QueryTimeout = "5s"
 	}
+	if h.RetryBackoff == "" {
+		h.RetryBackoff = "50ms"
+	}
+	if h.SlowQueryThreshold == "" {
+		h.SlowQueryThreshold = "1s"
+	}
 	if h.IndexMacro == "" {
 		h.IndexMacro = "render_index"
 	}
+	if err := checkExperiments(h.Experiments); err != nil {
+		return err
+	}
+	if h.IncludeMaxDepth == 0 {
+		h.IncludeMaxDepth = 5
+	}
+	if h.VersionColumn == "" {
+		h.VersionColumn = "version"
+	}
+	if h.DiffPath == "" {
+		h.DiffPath = "_diff"
+	}
+	if h.InvalidateInterval == "" {
+		h.InvalidateInterval = "10s"
+	}
 	if h.SearchMacro == "" {
 		h.SearchMacro = "render_search"
 	}
@@ -198,9 +1005,51 @@ func (h *HTMLFromDuckDB) Provision(ctx caddy.Context) error {
 	if h.TablePath == "" {
 		h.TablePath = "_table"
 	}
+	if h.TableMaxRows == 0 {
+		h.TableMaxRows = 10000
+	}
+	if h.GeoJSONPath == "" {
+		h.GeoJSONPath = "_geojson"
+	}
+	if h.GeoJSONGeometryColumn == "" {
+		h.GeoJSONGeometryColumn = "geom"
+	}
+	if h.TilePath == "" {
+		h.TilePath = "_tiles"
+	}
+	if h.APIPageSize == 0 {
+		h.APIPageSize = 50
+	}
+	if h.QueryMaxRows == 0 {
+		h.QueryMaxRows = 10000
+	}
+	if h.QueryMaxBytes == 0 {
+		h.QueryMaxBytes = 10 << 20
+	}
+	if h.StreamBuffer == 0 {
+		h.StreamBuffer = defaultStreamBuffer
+	}
+	if h.NDJSONFlushRows == 0 {
+		h.NDJSONFlushRows = 100
+	}
 	if h.HealthPath == "" {
 		h.HealthPath = "_health"
 	}
+	if h.HealthCanaryID == "" {
+		h.HealthCanaryID = "health-canary"
+	}
+	if h.HealthCanaryTerm == "" {
+		h.HealthCanaryTerm = "health"
+	}
+	if h.OpenAPIPath == "" {
+		h.OpenAPIPath = "_openapi.json"
+	}
+	if h.ChangesMaxWait == "" {
+		h.ChangesMaxWait = "30s"
+	}
+	if h.ShutdownGracePeriod == "" {
+		h.ShutdownGracePeriod = "10s"
+	}
 
 	// Parse timeout
 	var err error
@@ -208,11 +1057,215 @@ func (h *HTMLFromDuckDB) Provision(ctx caddy.Context) error {
 	if err != nil {
 		return fmt.Errorf("invalid query_timeout: %v", err)
 	}
+	h.retryAttempts = 2
+	if h.RetryAttempts != nil {
+		if *h.RetryAttempts < 0 {
+			return fmt.Errorf("invalid retry_attempts: %d", *h.RetryAttempts)
+		}
+		h.retryAttempts = *h.RetryAttempts
+	}
+	h.retryBackoff, err = time.ParseDuration(h.RetryBackoff)
+	if err != nil || h.retryBackoff < 0 {
+		return fmt.Errorf("invalid retry_backoff: %q", h.RetryBackoff)
+	}
+	h.slowAfter, err = time.ParseDuration(h.SlowQueryThreshold)
+	if err != nil {
+		return fmt.Errorf("invalid slow_query_threshold: %v", err)
+	}
+	h.slowLog = newSlowQueryLog(slowQueryLogSize)
+	if h.ChangesPath != "" && h.UpdatedColumn == "" {
+		return fmt.Errorf("changes_path requires updated_column")
+	}
+	h.changesMaxWait, err = time.ParseDuration(h.ChangesMaxWait)
+	if err != nil {
+		return fmt.Errorf("invalid changes_max_wait: %v", err)
+	}
+	h.drainGrace, err = time.ParseDuration(h.ShutdownGracePeriod)
+	if err != nil || h.drainGrace < 0 {
+		return fmt.Errorf("invalid shutdown_grace_period: %q", h.ShutdownGracePeriod)
+	}
+	h.drain = newDrainState()
+
+	var indexCacheTTL time.Duration
+	if h.IndexCacheTTL != "" {
+		indexCacheTTL, err = time.ParseDuration(h.IndexCacheTTL)
+		if err != nil {
+			return fmt.Errorf("invalid index_cache_ttl: %v", err)
+		}
+	}
+	if indexCacheTTL > 0 || h.IndexVersionQuery != "" || h.InvalidateQuery != "" {
+		h.indexCache = newLRUCache[indexPage](indexCacheSize, indexCacheTTL)
+	}
+	if h.NegativeCacheTTL != "" {
+		ttl, err := time.ParseDuration(h.NegativeCacheTTL)
+		if err != nil {
+			return fmt.Errorf("invalid negative_cache_ttl: %v", err)
+		}
+		if ttl > 0 {
+			h.notFound = newLRUCache[struct{}](negativeCacheSize, ttl)
+		}
+	}
+	if h.ESICacheTTL != "" {
+		ttl, err := time.ParseDuration(h.ESICacheTTL)
+		if err != nil {
+			return fmt.Errorf("invalid esi_cache_ttl: %v", err)
+		}
+		if ttl > 0 {
+			h.esiCache = newLRUCache[esiEntry](esiCacheSize, ttl)
+		}
+	}
+	if h.TileCacheTTL != "" {
+		ttl, err := time.ParseDuration(h.TileCacheTTL)
+		if err != nil {
+			return fmt.Errorf("invalid tile_cache_ttl: %v", err)
+		}
+		if ttl > 0 {
+			h.tileCache = newLRUCache[tileEntry](tileCacheSize, ttl)
+		}
+	}
+	if err := h.checkSessionVariables(); err != nil {
+		return err
+	}
+	if err := h.checkFallbackFileRoot(); err != nil {
+		return err
+	}
+
+	connMaxLifetime, err := time.ParseDuration(h.ConnMaxLifetime)
+	if err != nil {
+		return fmt.Errorf("invalid conn_max_lifetime: %v", err)
+	}
+	var connMaxIdleTime time.Duration
+	if h.ConnMaxIdleTime != "" {
+		connMaxIdleTime, err = time.ParseDuration(h.ConnMaxIdleTime)
+		if err != nil {
+			return fmt.Errorf("invalid conn_max_idle_time: %v", err)
+		}
+	}
 
 	// Validate required fields
 	if h.Table == "" {
 		return fmt.Errorf("table name is required")
 	}
+	if h.Threads < 0 {
+		return fmt.Errorf("invalid threads: %d", h.Threads)
+	}
+	if h.APIPageSize < 0 {
+		return fmt.Errorf("invalid api_page_size: %d", h.APIPageSize)
+	}
+	if h.AnalyticsPoolSize < 0 {
+		return fmt.Errorf("invalid analytics_pool_size: %d", h.AnalyticsPoolSize)
+	}
+	if h.RecordPoolSize < 0 {
+		return fmt.Errorf("invalid record_pool_size: %d", h.RecordPoolSize)
+	}
+	if h.RecordPoolSize > 0 && h.AnalyticsPoolSize == 0 {
+		return fmt.Errorf("record_pool_size requires analytics_pool_size")
+	}
+	if h.QueryPath != "" && !h.hasAuth() {
+		return fmt.Errorf("query_path requires auth_tokens or api_keys_table")
+	}
+	if h.ExportPath != "" && !h.hasAuth() {
+		return fmt.Errorf("export_path requires auth_tokens or api_keys_table")
+	}
+	if h.ExplainPath != "" && !h.hasAuth() {
+		return fmt.Errorf("explain_path requires auth_tokens or api_keys_table")
+	}
+	switch h.StrictRows {
+	case "", strictRowsWarn, strictRowsError:
+	default:
+		return fmt.Errorf("invalid strict_rows: %q (must be warn or error)", h.StrictRows)
+	}
+	if err := h.checkFilterParams(); err != nil {
+		return err
+	}
+	switch h.TableOrder {
+	case "", tableOrderRequire, tableOrderAuto:
+	default:
+		return fmt.Errorf("invalid table_order: %q (must be require or auto)", h.TableOrder)
+	}
+	if err := h.validateNullHTML(); err != nil {
+		return err
+	}
+	if err := h.provisionCharset(); err != nil {
+		return err
+	}
+	if err := h.provisionCompress(); err != nil {
+		return err
+	}
+	if h.APIKeysTable != "" {
+		h.apiKeys = newLRUCache[keyGrant](apiKeyCacheSize, apiKeyCacheTTL)
+		h.apiKeyMisses = newLRUCache[struct{}](apiKeyMissCacheSize, apiKeyCacheTTL)
+	}
+	if err := h.provisionQuota(); err != nil {
+		return err
+	}
+	if err := h.provisionLoadShedding(); err != nil {
+		return err
+	}
+	if h.TableFormat != nil {
+		if err := h.TableFormat.provision(); err != nil {
+			return err
+		}
+	}
+	if h.ValueEncoding != nil {
+		if err := h.ValueEncoding.provision(); err != nil {
+			return err
+		}
+	}
+	h.served = newServedSizes()
+	h.healthAllow, err = parseIPRanges(h.HealthAllow)
+	if err != nil {
+		return fmt.Errorf("invalid health_allow: %v", err)
+	}
+	if h.HealthAuth && !h.hasAuth() && len(h.healthAllow) == 0 {
+		return fmt.Errorf("health_auth requires auth_tokens, api_keys_table or health_allow")
+	}
+	h.exportBusy = make(chan struct{}, 1)
+	if err := h.provisionRecordRoutes(); err != nil {
+		return err
+	}
+	if err := h.provisionEndpointMatchers(ctx); err != nil {
+		return err
+	}
+	if err := h.provisionTransformers(ctx); err != nil {
+		return err
+	}
+	if err := h.provisionIDResolver(ctx); err != nil {
+		return err
+	}
+	if err := h.provisionWebhooks(ctx); err != nil {
+		return err
+	}
+
+	if h.MarkdownColumn != "" || h.RenderMarkdown {
+		h.markdown, err = newMarkdown(h.MarkdownExtensions, h.MarkdownUnsafe)
+		if err != nil {
+			return fmt.Errorf("invalid markdown_extensions: %v", err)
+		}
+	}
+
+	if h.LockWait != "" {
+		h.lockWait, err = time.ParseDuration(h.LockWait)
+		if err != nil || h.lockWait < 0 {
+			return fmt.Errorf("invalid lock_wait: %q", h.LockWait)
+		}
+	}
+	if h.AttachReadOnly {
+		switch {
+		case h.DatabasePath == "":
+			return fmt.Errorf("attach_read_only requires database_path")
+		case !*h.ReadOnly:
+			return fmt.Errorf("attach_read_only requires read_only")
+		case h.Sync != nil:
+			return fmt.Errorf("attach_read_only can't be combined with sync")
+		}
+	}
+	h.lock = &lockState{}
+	if h.Database != "" {
+		if h.shared, err = h.sharedDatabase(ctx); err != nil {
+			return err
+		}
+	}
 
 	// Build connection string
 	connStr := h.DatabasePath
@@ -222,68 +1275,236 @@ func (h *HTMLFromDuckDB) Provision(ctx caddy.Context) error {
 
 	// Add connection parameters
 	params := []string{}
-	if *h.ReadOnly {
+	// DuckDB can't open an in-memory database read-only; without a file
+	// there is nothing to protect anyway.
+	if *h.ReadOnly && h.DatabasePath != "" {
 		params = append(params, "access_mode=READ_ONLY")
 	}
 	if len(params) > 0 {
 		connStr += "?" + strings.Join(params, "&")
 	}
 
-	// Build a connector that re-runs init SQL on every new pool connection.
-	// This ensures session-scoped settings (e.g. SET search_path) are applied
-	// even after database/sql recycles connections due to SetConnMaxLifetime.
-	initFile := h.InitSQLFile
-	connector, err := duckdb.NewConnector(connStr, func(execer driver.ExecerContext) error {
-		if initFile == "" {
-			return nil
+	attach := ""
+	if h.AttachReadOnly {
+		connStr, attach = "", h.DatabasePath
+	}
+
+	if err := checkDatasets(h.Datasets); err != nil {
+		return err
+	}
+	var datasetRefresh time.Duration
+	if h.DatasetRefreshInterval != "" {
+		datasetRefresh, err = time.ParseDuration(h.DatasetRefreshInterval)
+		if err != nil || datasetRefresh < 0 {
+			return fmt.Errorf("invalid dataset_refresh_interval: %q", h.DatasetRefreshInterval)
 		}
-		stmts, readErr := readInitSQLFile(initFile)
-		if readErr != nil {
-			return readErr
+		if datasetRefresh > 0 && (h.Sync != nil || h.LoadIntoMemory) {
+			return fmt.Errorf("dataset_refresh_interval can't be combined with sync or load_into_memory")
 		}
-		ctx := context.Background()
-		for _, stmt := range stmts {
-			stmt = strings.TrimSpace(stmt)
-			if stmt == "" {
-				continue
-			}
-			if _, execErr := execer.ExecContext(ctx, stmt, nil); execErr != nil {
-				return fmt.Errorf("init SQL failed: %v\nStatement: %s", execErr, truncateForLog(stmt, 200))
-			}
+	}
+
+	h.config = ctx.Done()
+	if err := h.checkInstanceName(); err != nil {
+		return err
+	}
+
+	cfg := poolConfig{
+		attach:      attach,
+		datasets:    encodeDatasets(h.Datasets),
+		spatial:     h.GeoJSONMacro != "" || h.TileMacro != "",
+		connStr:     connStr,
+		initSQLFile: h.InitSQLFile,
+		initSQLHash: hashInitSQLFile(h.InitSQLFile),
+		contentURL:  h.contentURLPrefix(),
+		limits: resourceLimits{
+			memoryLimit:          h.MemoryLimit,
+			threads:              h.Threads,
+			tempDirectory:        h.TempDirectory,
+			maxTempDirectorySize: h.MaxTempDirectorySize,
+		},
+	}
+	settings := poolSettings{
+		maxOpen:          h.ConnectionPoolSize,
+		maxIdle:          h.MaxIdleConns,
+		maxLifetime:      connMaxLifetime,
+		maxIdleTime:      connMaxIdleTime,
+		analyticsMaxOpen: h.AnalyticsPoolSize,
+	}
+	if h.AnalyticsPoolSize > 0 {
+		settings.maxOpen = h.RecordPoolSize
+	}
+	if h.shared != nil {
+		// The same key and settings as the app's, so this is its pool.
+		connStr, cfg, settings = h.Database, h.shared.cfg, h.shared.settings
+	}
+	reused := false
+	if h.LoadIntoMemory {
+		// Like a replica, the handler has in-memory copies of its own.
+		if err := h.provisionMemory(ctx, cfg, settings); err != nil {
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create database connector: %v", err)
+	} else if datasetRefresh > 0 && pinsSnapshots(h.Datasets) {
+		// Refreshing swaps in pools pinned to new snapshots, which can't
+		// be shared with other handlers.
+		if err := h.provisionDatasetRefresh(ctx, cfg, settings, datasetRefresh); err != nil {
+			return err
+		}
+	} else if h.Sync != nil {
+		// A replica opens a pool of its own for every synced copy, so it
+		// can swap them without affecting other handlers.
+		if err := h.provisionSync(ctx, cfg, settings); err != nil {
+			return err
+		}
+	} else {
+		// Reuse the open pool when another handler (or the previous config,
+		// during a reload) uses the same database configuration.
+		pool, loaded, err := h.acquirePoolWaiting(ctx, cfg, settings)
+		if err != nil {
+			return err
+		}
+		h.pool = pool
+		h.db = pool.db
+		reused = loaded
+		h.noteLock(pool.db.PingContext(ctx))
+	}
+	if err := h.provisionDatabases(ctx, cfg, settings); err != nil {
+		h.closeDatabase()
+		return err
+	}
+	if err := h.provisionShadow(ctx, cfg, settings); err != nil {
+		h.releaseDatabases()
+		h.closeDatabase()
+		return err
+	}
+	if err := h.provisionCanary(ctx, cfg, settings); err != nil {
+		h.releaseShadow()
+		h.releaseDatabases()
+		h.closeDatabase()
+		return err
 	}
-	h.db = sql.OpenDB(connector)
 
-	// Configure connection pool
-	h.db.SetMaxOpenConns(h.ConnectionPoolSize)
-	h.db.SetMaxIdleConns(h.ConnectionPoolSize / 2)
-	h.db.SetConnMaxLifetime(time.Hour)
+	if h.GeoJSONMacro != "" || h.TileMacro != "" {
+		h.checkSpatial(ctx)
+	}
 
-	// Test connection (also triggers first connInitFn run)
-	if err := h.db.Ping(); err != nil {
-		h.db.Close()
-		return fmt.Errorf("failed to ping database: %v", err)
+	if h.InvalidateQuery != "" {
+		interval, err := time.ParseDuration(h.InvalidateInterval)
+		if err != nil || interval <= 0 {
+			h.abortProvision()
+			return fmt.Errorf("invalid invalidate_interval: %q", h.InvalidateInterval)
+		}
+		if err := h.startWatermarkPoll(ctx, interval); err != nil {
+			h.abortProvision()
+			return fmt.Errorf("invalid invalidate_query: %v", err)
+		}
+	}
+	if h.Backup != nil {
+		if err := h.provisionBackup(ctx); err != nil {
+			h.abortProvision()
+			return err
+		}
+	}
+	if err := h.provisionRecordQuery(ctx); err != nil {
+		h.abortProvision()
+		return err
+	}
+	if err := h.provisionNamedQueries(ctx); err != nil {
+		h.abortProvision()
+		return err
+	}
+	if h.SelfTestID != "" {
+		if err := h.selfTest(ctx); err != nil {
+			h.abortProvision()
+			return err
+		}
+	}
+	if err := h.provisionAudit(ctx); err != nil {
+		h.abortProvision()
+		return err
+	}
+	if err := h.provisionMissLog(ctx); err != nil {
+		h.abortProvision()
+		return err
 	}
 
 	h.logger.Info("HTML from DuckDB handler provisioned",
 		zap.String("database", connStr),
 		zap.String("table", h.Table),
 		zap.Bool("read_only", *h.ReadOnly),
+		zap.Bool("shared_pool", reused),
+		zap.String("memory_limit", h.MemoryLimit),
+		zap.Int("threads", h.Threads),
 		zap.Bool("index_enabled", h.IndexEnabled),
 		zap.Bool("search_enabled", h.SearchEnabled),
 		zap.Bool("health_enabled", h.HealthEnabled))
 
+	// A reload that points the handler at a different database (or reopens
+	// it with other options) is a swap worth telling webhooks about.
+	if prev := lookupInstance(h.instanceName()); prev != nil && prev.pool != h.pool {
+		h.notify(eventDatabaseSwap, map[string]any{"database": h.DatabasePath})
+		h.audit(ctx, auditDatabaseSwap, "config reload", nil, map[string]any{"database": h.DatabasePath})
+		if prev.pool != nil && h.pool != nil && prev.pool.key.initSQLHash != h.pool.key.initSQLHash {
+			h.audit(ctx, auditInitSQL, "config reload", nil, map[string]any{"init_sql_file": h.InitSQLFile})
+		}
+	}
+	registerInstance(h)
+	registerMetrics.Do(func() {
+		prometheus.MustRegister(infoCollector{}, responseSizes, canceledRequests, shedRequests, queryRetries, shadowReads,
+			databaseRequests, databaseErrors)
+	})
+
 	return nil
 }
 
-// Cleanup closes the database connection.
+// Cleanup releases the handler's reference to the shared database pool and
+// removes it from the admin API. The pool is closed once no other handler
+// instance uses it, after in-flight requests have drained and the shutdown
+// backup if one is configured.
 func (h *HTMLFromDuckDB) Cleanup() error {
-	if h.db != nil {
-		return h.db.Close()
+	unregisterInstance(h)
+	if h.closesPool() {
+		h.drainRequests()
+	}
+	if h.stopPoll != nil {
+		h.stopPoll()
+	}
+	if h.backups != nil {
+		h.stopBackups()
+	}
+	if h.missLog != nil {
+		h.stopMissLog()
+	}
+	h.releaseDatabases()
+	h.drainShadow()
+	h.releaseShadow()
+	h.releaseCanary()
+	return h.closeDatabase()
+}
+
+// abortProvision stops what a failed Provision had started and closes the
+// database.
+func (h *HTMLFromDuckDB) abortProvision() {
+	if h.stopPoll != nil {
+		h.stopPoll()
+	}
+	if h.backups != nil && h.backups.stop != nil {
+		h.backups.stop()
+		<-h.backups.done
+	}
+	h.releaseDatabases()
+	h.releaseShadow()
+	h.releaseCanary()
+	h.closeDatabase()
+}
+
+// closeDatabase stops a replica's sync and closes its pool, or releases the
+// handler's reference to the shared pool.
+func (h *HTMLFromDuckDB) closeDatabase() error {
+	if h.replica != nil {
+		return h.closeReplica()
+	}
+	if h.pool != nil {
+		return releasePool(h.pool)
 	}
 	return nil
 }
@@ -417,88 +1638,292 @@ func truncateForLog(s string, maxLen int) string {
 	return s[:maxLen] + "..."
 }
 
-// ServeHTTP serves HTML content from DuckDB.
+// ServeHTTP serves HTML content from DuckDB and sets the {duckdb.*}
+// placeholders for the rest of the route.
 func (h *HTMLFromDuckDB) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	r, done, err := h.beginRequest(r)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if !h.admitAny(w, r) {
+		return nil
+	}
+	if h.selectorHeader != "" {
+		addVary(w, h.selectorHeader)
+	}
+	if r, err = h.selectDatabase(r); err != nil {
+		return err
+	}
+	r, database := h.routeCanary(r)
+	r, unpin := h.pinSnapshot(r)
+	defer unpin()
+	start := time.Now()
+	err = withPlaceholders(w, r, h.serveAndMeasure)
+	h.observeDatabase(database, start, err)
+	var herr caddyhttp.HandlerError
+	if errors.As(err, &herr) && herr.StatusCode >= 500 {
+		h.notify(eventError, map[string]any{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"status": herr.StatusCode,
+			"error":  herr.Err.Error(),
+		})
+	}
+	return err
+}
+
+func (h *HTMLFromDuckDB) serveHTTP(w http.ResponseWriter, r *http.Request) error {
+	// Declare the request headers the response depends on up front, so
+	// every response (including 304s and errors) carries them.
+	addVary(w, h.varyHeaders()...)
+
 	// Check for health endpoint first
-	if h.HealthEnabled {
-		healthPath := "/" + h.HealthPath
-		if h.BasePath != "" {
-			healthPath = h.BasePath + "/" + h.HealthPath
-		}
-		if r.URL.Path == healthPath {
-			return h.serveHealth(w, r)
-		}
+	if h.HealthEnabled && h.atEndpoint(r, "health", h.HealthPath, false) {
+		requestInfoFrom(r.Context()).setEndpoint("health")
+		return h.serveHealth(w, r)
+	}
+
+	// Check for OpenAPI description
+	if h.OpenAPIEnabled && h.atEndpoint(r, "openapi", h.OpenAPIPath, false) {
+		requestInfoFrom(r.Context()).setEndpoint("openapi")
+		return h.serveOpenAPI(w, r)
 	}
 
 	// Check for table endpoint
-	if h.TableMacro != "" {
-		tablePath := "/" + h.TablePath
-		if h.BasePath != "" {
-			tablePath = h.BasePath + "/" + h.TablePath
+	if h.TableMacro != "" && h.atEndpoint(r, "table", h.TablePath, true) {
+		requestInfoFrom(r.Context()).setEndpoint("table")
+		if !h.admitLowPriority(w, r) {
+			return nil
 		}
-		if strings.HasPrefix(r.URL.Path, tablePath) {
-			return h.serveTable(w, r)
+		if !h.checkQuota(w, r) {
+			return nil
 		}
+		return h.serveTable(w, r)
 	}
 
-	// Check for search query first
-	searchQuery := r.URL.Query().Get(h.SearchParam)
-	if searchQuery != "" && h.SearchEnabled {
-		return h.serveSearch(w, r, searchQuery)
+	// Check for GeoJSON endpoint
+	if h.GeoJSONMacro != "" && h.atEndpoint(r, "geojson", h.GeoJSONPath, false) {
+		requestInfoFrom(r.Context()).setEndpoint("geojson")
+		if !h.admitLowPriority(w, r) {
+			return nil
+		}
+		if !h.checkQuota(w, r) {
+			return nil
+		}
+		return h.serveGeoJSON(w, r)
 	}
 
-	// Extract ID from URL
-	var id string
-	if h.IDParam != "" {
-		// Get from query parameter
-		id = r.URL.Query().Get(h.IDParam)
-	} else {
-		// Get from path (last segment)
-		// If path ends with /, treat as index request (no ID)
-		if !strings.HasSuffix(r.URL.Path, "/") {
-			parts := strings.Split(r.URL.Path, "/")
-			if len(parts) > 0 {
-				id = parts[len(parts)-1]
-			}
+	// Check for tile endpoint
+	if h.TileMacro != "" && h.atEndpoint(r, "tile", h.TilePath, true) {
+		requestInfoFrom(r.Context()).setEndpoint("tile")
+		if !h.admitLowPriority(w, r) {
+			return nil
+		}
+		if !h.checkQuota(w, r) {
+			return nil
 		}
+		return h.serveTile(w, r)
 	}
 
-	// If no ID and index is enabled, serve index page
-	if id == "" && h.IndexEnabled {
-		page := r.URL.Query().Get("page")
-		return h.serveIndex(w, r, page)
+	// Check for API endpoint
+	if h.APIPath != "" && h.atEndpoint(r, "api", h.APIPath, true) {
+		requestInfoFrom(r.Context()).setEndpoint("api")
+		if !h.admitLowPriority(w, r) {
+			return nil
+		}
+		if !h.checkQuota(w, r) {
+			return nil
+		}
+		return h.serveAPI(w, r)
 	}
 
-	if id == "" {
-		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("missing ID parameter"))
+	// Check for query endpoint
+	if h.QueryPath != "" && h.atEndpoint(r, "query", h.QueryPath, false) {
+		requestInfoFrom(r.Context()).setEndpoint("query")
+		if !h.admitLowPriority(w, r) {
+			return nil
+		}
+		if !h.checkQuota(w, r) {
+			return nil
+		}
+		return h.serveQuery(w, r)
 	}
 
-	// Build query
-	var query string
-	var useParams bool
+	// Check for GraphQL-style named query endpoint
+	if h.GraphQLPath != "" && h.atEndpoint(r, "graphql", h.GraphQLPath, false) {
+		requestInfoFrom(r.Context()).setEndpoint("graphql")
+		if !h.admitLowPriority(w, r) {
+			return nil
+		}
+		if !h.checkQuota(w, r) {
+			return nil
+		}
+		return h.serveGraphQL(w, r)
+	}
 
-	if h.RecordMacro != "" {
-		// Use table macro: SELECT html FROM macro_name(id := 'escaped_value')
-		// DuckDB table macros don't support parameterized queries
-		query = fmt.Sprintf("SELECT %s FROM %s(id := '%s')",
-			sanitizeIdentifier(h.HTMLColumn),
-			sanitizeIdentifier(h.RecordMacro),
-			escapeSQLString(id))
-		useParams = false
+	// Check for named queries
+	if h.QueriesFile != "" {
+		if rest, ok := h.endpointRest(r, h.QueriesPath); ok {
+			requestInfoFrom(r.Context()).setEndpoint("queries")
+			if !h.admitLowPriority(w, r) {
+				return nil
+			}
+			if !h.checkQuota(w, r) {
+				return nil
+			}
+			return h.serveNamedQuery(w, r, strings.Trim(rest, "/"))
+		}
+	}
+
+	// Check for export endpoint
+	if h.ExportPath != "" && h.atEndpoint(r, "export", h.ExportPath, false) {
+		requestInfoFrom(r.Context()).setEndpoint("export")
+		if !h.admitLowPriority(w, r) {
+			return nil
+		}
+		return h.serveExport(w, r)
+	}
+
+	// Check for explain endpoint
+	if h.ExplainPath != "" && h.atEndpoint(r, "explain", h.ExplainPath, false) {
+		requestInfoFrom(r.Context()).setEndpoint("explain")
+		if !h.admitLowPriority(w, r) {
+			return nil
+		}
+		return h.serveExplain(w, r)
+	}
+
+	// Check for changes feed
+	if h.ChangesPath != "" && h.atEndpoint(r, "changes", h.ChangesPath, false) {
+		requestInfoFrom(r.Context()).setEndpoint("changes")
+		if !h.admitLowPriority(w, r) {
+			return nil
+		}
+		return h.serveChanges(w, r)
+	}
+
+	// Check for content-addressed endpoint
+	if h.ContentPath != "" {
+		if rest, ok := h.endpointRest(r, h.ContentPath); ok {
+			requestInfoFrom(r.Context()).setEndpoint("content")
+			return h.serveContent(w, r, strings.Trim(rest, "/"))
+		}
+	}
+
+	// Check for fragment endpoint
+	if h.FragmentPath != "" {
+		if rest, ok := h.endpointRest(r, h.FragmentPath); ok {
+			requestInfoFrom(r.Context()).setEndpoint("fragment")
+			return h.serveFragment(w, r, strings.Trim(rest, "/"))
+		}
+	}
+
+	// Check for search query first; a search matcher replaces the check
+	// for the search parameter
+	searchQuery := r.URL.Query().Get(h.SearchParam)
+	if h.SearchEnabled {
+		if set, ok := h.endpointMatch["search"]; ok {
+			if set.Match(r) {
+				return h.serveSearch(w, r, searchQuery)
+			}
+		} else if searchQuery != "" {
+			return h.serveSearch(w, r, searchQuery)
+		}
+	}
+
+	// Check for revision history and diff endpoints
+	if h.RevisionsPath != "" {
+		if id := h.revisionsID(r, h.RevisionsPath); id != "" {
+			requestInfoFrom(r.Context()).setEndpoint("revisions")
+			return h.serveRevisions(w, r, id)
+		}
+		if id := h.revisionsID(r, h.DiffPath); id != "" {
+			requestInfoFrom(r.Context()).setEndpoint("diff")
+			return h.serveDiff(w, r, id)
+		}
+	}
+
+	// Record routes bring their own macro, ID extraction and caching
+	recordMacro, idParam, cacheControl := h.RecordMacro, h.IDParam, h.CacheControl
+	route := h.recordRoute(r.URL.Path)
+	if route != nil {
+		recordMacro, idParam = route.Macro, route.IDParam
+		if route.CacheControl != "" {
+			cacheControl = route.CacheControl
+		}
+	}
+
+	// Extract ID from URL
+	var id string
+	if idParam != "" {
+		// Get from query parameter
+		id = r.URL.Query().Get(idParam)
+	} else if route != nil {
+		// Get from path (everything after the route prefix)
+		id = strings.TrimPrefix(r.URL.Path, route.Prefix)
 	} else {
-		// Traditional table query with parameterized ID
-		query = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
-			sanitizeIdentifier(h.HTMLColumn),
-			sanitizeIdentifier(h.Table),
-			sanitizeIdentifier(h.IDColumn))
-		useParams = true
+		// Get from path (last segment, or everything below base_path
+		// with hierarchy_enabled)
+		// If path ends with /, treat as index request (no ID)
+		if h.HierarchyEnabled {
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				id = h.hierarchyPath(r.URL.Path)
+			}
+		} else if !strings.HasSuffix(r.URL.Path, "/") {
+			parts := strings.Split(r.URL.Path, "/")
+			if len(parts) > 0 {
+				id = parts[len(parts)-1]
+			}
+		}
+	}
+	if h.idResolver != nil && route == nil {
+		var err error
+		if id, err = h.resolveID(r, id); err != nil {
+			return err
+		}
+	}
+
+	// If no ID and index is enabled, serve index page
+	if id == "" && h.IndexEnabled && route == nil {
+		page := r.URL.Query().Get("page")
+		return h.serveIndex(w, r, page)
+	}
 
-		if h.WhereClause != "" {
-			query += fmt.Sprintf(" AND (%s)", h.WhereClause)
+	if id == "" {
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("missing ID parameter"))
+	}
+	requestInfoFrom(r.Context()).setID(id)
+	if route == nil {
+		recordMacro = h.experimentMacro(w, r, "record", recordMacro)
+	}
+
+	// Routes and experiment variants may share IDs, so misses are
+	// remembered per route and macro
+	notFoundKey := id
+	if route != nil {
+		notFoundKey = route.Prefix + "\x00" + id
+	} else if recordMacro != h.RecordMacro {
+		notFoundKey = "\x00" + recordMacro + "\x00" + id
+	} else if len(h.FilterParams) > 0 {
+		notFoundKey = id + h.filterKey(r.URL.Query())
+	} else if h.boundRecordQuery != "" {
+		notFoundKey = h.recordQueryKey(id, r)
+	}
+	if h.notFound != nil {
+		_, ok := h.notFound.get(notFoundKey)
+		requestInfoFrom(r.Context()).setCache(ok)
+		if ok {
+			h.log(r.Context()).Debug("content not found (cached)", zap.String("id", id))
+			return h.serveNotFound(w, r, id)
 		}
 	}
 
-	h.logger.Debug("executing query",
+	// Build query
+	metaKeys := h.metaKeys()
+	query, args := h.recordQuery(h.recordColumns(), recordMacro, id, r)
+
+	h.log(r.Context()).Debug("executing query",
 		zap.String("query", query),
 		zap.String("id", id))
 
@@ -511,91 +1936,271 @@ func (h *HTMLFromDuckDB) ServeHTTP(w http.ResponseWriter, r *http.Request, next
 	}
 
 	var html string
+	var content, fallback sql.NullString
+	var rowHeaders, rowPreload, rowTags any
+	dest := []any{&content}
+	if h.HeadersColumn != "" {
+		dest = append(dest, &rowHeaders)
+	}
+	if h.PreloadColumn != "" {
+		dest = append(dest, &rowPreload)
+	}
+	if h.TagsColumn != "" {
+		dest = append(dest, &rowTags)
+	}
+	metaValues := make([]sql.NullString, len(metaKeys))
+	for i := range metaValues {
+		dest = append(dest, &metaValues[i])
+	}
+	if h.NullHTML == nullHTMLColumn {
+		dest = append(dest, &fallback)
+	}
+
 	var err error
-	if useParams {
-		err = h.db.QueryRowContext(ctx, query, id).Scan(&html)
-	} else {
-		err = h.db.QueryRowContext(ctx, query).Scan(&html)
+	start := time.Now()
+	err = h.queryRow(ctx, "record", query, args, dest...)
+	h.observeQuery(ctx, "record", query, time.Since(start))
+	if err == nil || err == sql.ErrNoRows {
+		h.shadowRecord(ctx, recordMacro, id, r, err == nil, content)
+	}
+	if err == nil {
+		if content.Valid {
+			html = h.decodeContent(ctx, id, content.String)
+		} else {
+			html, err = h.nullHTML(ctx, id, fallback)
+		}
 	}
 	if err != nil {
 		if err == sql.ErrNoRows {
-			h.logger.Debug("content not found", zap.String("id", id))
-			if h.NotFoundRedirect != "" {
-				http.Redirect(w, r, h.NotFoundRedirect, http.StatusFound)
-				return nil
+			gone, goneErr := h.isGone(ctx, id)
+			if goneErr != nil {
+				h.log(r.Context()).Warn("gone check failed", zap.String("id", id), zap.Error(goneErr))
+			}
+			if gone {
+				h.log(r.Context()).Debug("content gone", zap.String("id", id))
+				return h.serveGone(w, r, id)
+			}
+			h.log(r.Context()).Debug("content not found", zap.String("id", id))
+			if h.notFound != nil {
+				h.notFound.add(notFoundKey, struct{}{})
 			}
-			return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("content not found"))
+			return h.serveNotFound(w, r, id)
 		}
-		h.logger.Error("query failed", zap.Error(err))
+		h.logFailure(r.Context(), "query failed", zap.Error(err))
 		return caddyhttp.Error(http.StatusInternalServerError, err)
 	}
+	if h.TagsColumn != "" {
+		h.rowTags(ctx, "record", rowTags)
+	}
 
-	// Generate ETag from content hash
-	hash := md5.Sum([]byte(html))
-	etag := `"` + hex.EncodeToString(hash[:]) + `"`
+	// Now that the record is known to exist, its preload assets can go out
+	// as early hints while it is rendered.
+	if h.PreloadMacro != "" {
+		links, err := h.queryPreloadMacro(ctx, id)
+		if err != nil {
+			h.log(r.Context()).Warn("preload macro failed", zap.String("id", id), zap.Error(err))
+		}
+		addPreloadLinks(w, links)
+		if h.EarlyHints && len(links) > 0 {
+			w.WriteHeader(http.StatusEarlyHints)
+		}
+	}
 
-	// Check If-None-Match header for conditional requests (RFC 7232)
-	if match := r.Header.Get("If-None-Match"); match != "" {
-		if match == "*" {
-			w.WriteHeader(http.StatusNotModified)
-			return nil
+	if h.markdown != nil {
+		html, err = h.renderMarkdown(html)
+		if err != nil {
+			h.log(r.Context()).Error("markdown rendering failed", zap.String("id", id), zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
 		}
-		// Handle multiple ETags: "etag1", "etag2", "etag3"
-		for _, m := range strings.Split(match, ",") {
-			if strings.TrimSpace(m) == etag {
-				w.WriteHeader(http.StatusNotModified)
-				return nil
-			}
+	}
+
+	html = h.resolveIncludes(ctx, html, []string{id})
+	html = h.processESI(r, html)
+
+	if len(metaKeys) > 0 {
+		values := make(map[string]string, len(metaKeys))
+		for i, key := range metaKeys {
+			values[key] = metaValues[i].String
 		}
+		html = injectHead(html, metaTags(html, metaKeys, values, r))
+	}
+	html, err = h.transformHTML(r, html)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	// Conditional request handling (RFC 7232)
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	h.setTagHeaders(w, r)
+	h.negotiateEncoding(r)
+	if notModified(w, r, generateETag(html)) {
+		return nil
 	}
 
 	// Set headers
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("Content-Length", strconv.Itoa(len(html)))
-	w.Header().Set("ETag", etag)
-	if h.CacheControl != "" {
-		w.Header().Set("Cache-Control", h.CacheControl)
+	if h.HeadersColumn != "" {
+		h.applyRowHeaders(ctx, w, rowHeaders)
+	}
+	if h.PreloadColumn != "" {
+		assets, err := parsePreloadList(rowPreload)
+		if err != nil {
+			h.log(r.Context()).Warn("ignoring invalid preload column", zap.String("id", id), zap.Error(err))
+		}
+		for _, asset := range assets {
+			w.Header().Add("Link", preloadLink(asset, ""))
+		}
 	}
 
 	// Write HTML
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(html)); err != nil {
-		h.logger.Error("failed to write response", zap.Error(err))
+	if err := h.writeBody(w, r, html, nil); err != nil {
+		h.logFailure(r.Context(), "failed to write response", zap.Error(err))
 		return err
 	}
 
-	h.logger.Debug("served HTML content",
+	h.log(r.Context()).Debug("served HTML content",
 		zap.String("id", id),
 		zap.Int("size", len(html)))
 
 	return nil
 }
 
+// recordColumns returns the columns a record lookup selects: the content
+// column, then the headers, preload, tags, meta and null_html fallback columns
+// when configured.
+func (h *HTMLFromDuckDB) recordColumns() string {
+	contentColumn := h.HTMLColumn
+	if h.MarkdownColumn != "" {
+		contentColumn = h.MarkdownColumn
+	}
+	columns := sanitizeIdentifier(contentColumn)
+	if h.HeadersColumn != "" {
+		columns += ", " + sanitizeIdentifier(h.HeadersColumn)
+	}
+	if h.PreloadColumn != "" {
+		columns += ", " + sanitizeIdentifier(h.PreloadColumn)
+	}
+	if h.TagsColumn != "" {
+		columns += ", " + sanitizeIdentifier(h.TagsColumn)
+	}
+	for _, key := range h.metaKeys() {
+		columns += ", " + sanitizeIdentifier(h.MetaColumns[key])
+	}
+	if h.NullHTML == nullHTMLColumn {
+		columns += ", " + sanitizeIdentifier(h.NullHTMLFallback)
+	}
+	return columns
+}
+
+// recordQuery builds the query looking up record id with the given select
+// list, from recordMacro if set, record_query or the table, with the
+// placeholders of record_query and the filter_params bound from r, which
+// may be nil.
+func (h *HTMLFromDuckDB) recordQuery(columns, recordMacro, id string, r *http.Request) (string, []any) {
+	if recordMacro != "" {
+		// Use table macro: SELECT html FROM macro_name(id := 'escaped_value')
+		// DuckDB table macros don't support parameterized queries
+		return fmt.Sprintf("SELECT %s FROM %s(id := '%s')",
+			columns,
+			sanitizeIdentifier(recordMacro),
+			escapeSQLString(id)), nil
+	}
+	if h.boundRecordQuery != "" {
+		return h.wrapRecordQuery(columns, h.boundRecordQuery), h.recordQueryArgs(id, r)
+	}
+
+	// Traditional table query with parameterized ID
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+		columns,
+		sanitizeIdentifier(h.Table),
+		sanitizeIdentifier(h.IDColumn))
+	args := []any{id}
+	if len(h.FilterParams) > 0 && r != nil {
+		conditions, values := h.filterConditions(r.URL.Query())
+		query += conditions
+		args = append(args, values...)
+	}
+	if h.WhereClause != "" {
+		query += fmt.Sprintf(" AND (%s)", h.WhereClause)
+	}
+	if cond := h.goneCondition(); cond != "" {
+		query += " AND NOT " + cond
+	}
+	return query, args
+}
+
+// serveNotFound answers a request for a record that doesn't exist with its
+// fallback file, the not_found_macro page, a redirect to not_found_redirect,
+// or a plain 404.
+func (h *HTMLFromDuckDB) serveNotFound(w http.ResponseWriter, r *http.Request, id string) error {
+	if h.FallbackFileRoot != "" && h.serveFallbackFile(w, r, id) {
+		return nil
+	}
+	requestInfoFrom(r.Context()).setEndpoint("not_found")
+	h.logMiss(r, missRecord)
+	if h.NotFoundMacro != "" {
+		html, err := h.renderNotFound(r.Context(), id, r.URL.Path)
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Length", strconv.Itoa(len(html)))
+			w.WriteHeader(http.StatusNotFound)
+			_, err = w.Write([]byte(html))
+			return err
+		}
+		h.log(r.Context()).Warn("not found macro failed", zap.String("id", id), zap.Error(err))
+	}
+	if h.NotFoundRedirect != "" {
+		http.Redirect(w, r, h.NotFoundRedirect, http.StatusFound)
+		return nil
+	}
+	return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("content not found"))
+}
+
+// renderNotFound calls the not found macro for a missing id and path.
+func (h *HTMLFromDuckDB) renderNotFound(ctx context.Context, id, path string) (string, error) {
+	ctx, cancel := h.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf("SELECT html FROM %s(id := '%s', path := '%s')",
+		sanitizeIdentifier(h.NotFoundMacro),
+		escapeSQLString(id),
+		escapeSQLString(path))
+
+	var html string
+	start := time.Now()
+	err := h.queries(ctx, "record").QueryRowContext(ctx, tagQuery(ctx, query)).Scan(&html)
+	h.observeQuery(ctx, "not_found", query, time.Since(start))
+	return html, err
+}
+
 // serveIndex serves a paginated index page by calling the index macro.
 func (h *HTMLFromDuckDB) serveIndex(w http.ResponseWriter, r *http.Request, page string) error {
+	requestInfoFrom(r.Context()).setEndpoint("index")
+	h.negotiateEncoding(r)
+
 	pageNum := 1
 	if p, err := strconv.Atoi(page); err == nil && p > 0 {
 		pageNum = p
 	}
 
-	// Derive base path from request if not configured
+	// Derive base path from request if not configured. Hierarchical
+	// indexes are all below base_path, so there it is the site root.
 	basePath := h.BasePath
-	if basePath == "" {
+	if basePath == "" && !h.HierarchyEnabled {
 		basePath = strings.TrimSuffix(r.URL.Path, "/")
 	}
+	prefix := h.indexPrefix(r)
 
-	// Call the DuckDB macro
-	// Note: DuckDB table macros don't support ? parameter placeholders,
-	// so we use string interpolation with proper escaping
-	query := fmt.Sprintf("SELECT html FROM %s(page := %d, base_path := '%s')",
-		sanitizeIdentifier(h.IndexMacro),
-		pageNum,
-		escapeSQLString(basePath))
+	indexMacro := h.experimentMacro(w, r, "index", h.IndexMacro)
+	query := h.indexQuery(indexMacro, pageNum, basePath, prefix)
 
-	h.logger.Debug("executing index macro",
-		zap.String("macro", h.IndexMacro),
+	h.log(r.Context()).Debug("executing index macro",
+		zap.String("macro", indexMacro),
 		zap.Int("page", pageNum),
-		zap.String("base_path", basePath))
+		zap.String("base_path", basePath),
+		zap.String("prefix", prefix))
 
 	ctx := r.Context()
 	if h.timeout > 0 {
@@ -604,34 +2209,165 @@ func (h *HTMLFromDuckDB) serveIndex(w http.ResponseWriter, r *http.Request, page
 		defer cancel()
 	}
 
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+
+	// With a version query (probed here) or a polled invalidate_query, the
+	// ETag follows the watermark, so clients with an unchanged index get a
+	// 304 without the macro running at all. If the probe fails, the page is
+	// rendered and the cache bypassed.
+	cacheKey := basePath + "\x00" + strconv.Itoa(pageNum)
+	if prefix != "" {
+		cacheKey += "\x00" + prefix
+	}
+	if indexMacro != h.IndexMacro {
+		cacheKey += "\x00" + indexMacro
+	}
+	useCache := h.indexCache != nil
+	var version, etag string
+	versioned := false
+	if h.IndexVersionQuery != "" {
+		v, err := h.queryWatermark(ctx, "index_version", h.IndexVersionQuery)
+		if err != nil {
+			h.log(r.Context()).Warn("index version query failed", zap.Error(err))
+			useCache = false
+		} else {
+			version, versioned = v, true
+		}
+	} else if h.watermark != nil {
+		version, versioned = h.watermark.load(), true
+	}
+	if versioned && !h.ESI {
+		etag = generateETag(version + "\x00" + cacheKey)
+		if notModified(w, r, etag) {
+			return nil
+		}
+	}
+
 	var html string
-	err := h.db.QueryRowContext(ctx, query).Scan(&html)
-	if err != nil {
-		h.logger.Error("index macro failed", zap.Error(err))
-		return caddyhttp.Error(http.StatusInternalServerError, err)
+	var cached indexPage
+	var hit bool
+	if useCache {
+		cached, hit = h.indexCache.get(cacheKey)
+		hit = hit && cached.version == version
+		requestInfoFrom(r.Context()).setCache(hit)
+	}
+	// Compressed copies live with the cache entry, so each encoding of a
+	// page is compressed once.
+	var bodies *encodedBodies
+	if hit {
+		html = cached.html
+		etag = cached.etag
+		bodies = cached.bodies
+		requestInfoFrom(r.Context()).addTags(cached.tags...)
+	} else {
+		var rowTags any
+		dest := []any{&html}
+		if h.TagsColumn != "" {
+			dest = append(dest, &rowTags)
+		}
+		start := time.Now()
+		err := h.queryRow(ctx, "index", query, nil, dest...)
+		h.observeQuery(ctx, "index", query, time.Since(start))
+		if err != nil {
+			h.logFailure(r.Context(), "index macro failed", zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		var tags []string
+		if h.TagsColumn != "" {
+			tags = h.rowTags(ctx, "index", rowTags)
+		}
+		if etag == "" {
+			etag = generateETag(html)
+		}
+		if useCache {
+			bodies = newEncodedBodies()
+			h.indexCache.add(cacheKey, indexPage{html: html, etag: etag, version: version, tags: tags, bodies: bodies})
+		}
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("Content-Length", strconv.Itoa(len(html)))
-	if h.CacheControl != "" {
-		w.Header().Set("Cache-Control", h.CacheControl)
+	// The watermark says nothing about ESI fragments, so with ESI the ETag
+	// follows the assembled page.
+	if h.ESI {
+		html = h.processESI(r, html)
+		etag = generateETag(html)
+		bodies = nil
+	}
+	// Likewise with transformers, which may depend on the request.
+	if len(h.transformers) > 0 {
+		transformed, err := h.transformHTML(r, html)
+		if err != nil {
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		html = transformed
+		etag = generateETag(html)
+		bodies = nil
 	}
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(html)); err != nil {
-		h.logger.Error("failed to write response", zap.Error(err))
+	h.setTagHeaders(w, r)
+	if notModified(w, r, etag) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.writeBody(w, r, html, bodies); err != nil {
+		h.logFailure(r.Context(), "failed to write response", zap.Error(err))
 		return err
 	}
 
-	h.logger.Debug("served index page",
+	h.log(r.Context()).Debug("served index page",
 		zap.Int("page", pageNum),
 		zap.Int("size", len(html)))
 
 	return nil
 }
 
+// indexQuery returns the query that renders an index page with macro.
+// With hierarchy_enabled, the macro also gets the prefix and breadcrumbs.
+func (h *HTMLFromDuckDB) indexQuery(macro string, page int, basePath, prefix string) string {
+	// Note: DuckDB table macros don't support ? parameter placeholders,
+	// so we use string interpolation with proper escaping
+	var hierarchy string
+	if h.HierarchyEnabled {
+		hierarchy = fmt.Sprintf(", prefix := '%s', breadcrumbs := %s",
+			escapeSQLString(prefix),
+			breadcrumbs(basePath, prefix))
+	}
+	return fmt.Sprintf("SELECT %s FROM %s(page := %d, base_path := '%s'%s)",
+		h.macroColumns(),
+		sanitizeIdentifier(macro),
+		page,
+		escapeSQLString(basePath),
+		hierarchy)
+}
+
+// macroColumns returns the columns selected from the index and search
+// macros: html, and the tags column when configured.
+func (h *HTMLFromDuckDB) macroColumns() string {
+	if h.TagsColumn != "" {
+		return "html, " + sanitizeIdentifier(h.TagsColumn)
+	}
+	return "html"
+}
+
+// searchQuery returns the query that renders search results.
+func (h *HTMLFromDuckDB) searchQuery(term, basePath string) string {
+	return fmt.Sprintf("SELECT %s FROM %s(term := '%s', base_path := '%s')",
+		h.macroColumns(),
+		sanitizeIdentifier(h.SearchMacro),
+		escapeSQLString(term),
+		escapeSQLString(basePath))
+}
+
 // serveSearch serves search results by calling the search macro.
 func (h *HTMLFromDuckDB) serveSearch(w http.ResponseWriter, r *http.Request, searchTerm string) error {
+	requestInfoFrom(r.Context()).setEndpoint("search")
+	if !h.admitLowPriority(w, r) {
+		return nil
+	}
+	h.negotiateEncoding(r)
+
 	// Sanitize search query
 	searchTerm = strings.TrimSpace(searchTerm)
 	if len(searchTerm) > 200 {
@@ -646,15 +2382,9 @@ func (h *HTMLFromDuckDB) serveSearch(w http.ResponseWriter, r *http.Request, sea
 		basePath = strings.TrimSuffix(basePath, "/search")
 	}
 
-	// Call the DuckDB macro
-	// Note: DuckDB table macros don't support ? parameter placeholders,
-	// so we use string interpolation with proper escaping
-	query := fmt.Sprintf("SELECT html FROM %s(term := '%s', base_path := '%s')",
-		sanitizeIdentifier(h.SearchMacro),
-		escapeSQLString(searchTerm),
-		escapeSQLString(basePath))
+	query := h.searchQuery(searchTerm, basePath)
 
-	h.logger.Debug("executing search macro",
+	h.log(r.Context()).Debug("executing search macro",
 		zap.String("macro", h.SearchMacro),
 		zap.String("term", searchTerm),
 		zap.String("base_path", basePath))
@@ -667,38 +2397,56 @@ func (h *HTMLFromDuckDB) serveSearch(w http.ResponseWriter, r *http.Request, sea
 	}
 
 	var html string
-	err := h.db.QueryRowContext(ctx, query).Scan(&html)
+	var rowTags any
+	dest := []any{&html}
+	if h.TagsColumn != "" {
+		dest = append(dest, &rowTags)
+	}
+	start := time.Now()
+	err := h.queryRow(ctx, "search", query, nil, dest...)
+	h.observeQuery(ctx, "search", query, time.Since(start))
+	if err != nil {
+		h.logFailure(r.Context(), "search macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if h.TagsColumn != "" {
+		h.rowTags(ctx, "search", rowTags)
+	}
+	html, err = h.transformHTML(r, html)
 	if err != nil {
-		h.logger.Error("search macro failed", zap.Error(err))
 		return caddyhttp.Error(http.StatusInternalServerError, err)
 	}
 
-	// HTMX partial - no caching
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("Content-Length", strconv.Itoa(len(html)))
+	// HTMX partial - always revalidate, but let unchanged results be a 304
 	w.Header().Set("Cache-Control", "no-cache")
+	h.setTagHeaders(w, r)
+	if notModified(w, r, generateETag(html)) {
+		return nil
+	}
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(html)); err != nil {
-		h.logger.Error("failed to write response", zap.Error(err))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.writeBody(w, r, html, nil); err != nil {
+		h.logFailure(r.Context(), "failed to write response", zap.Error(err))
 		return err
 	}
 
-	h.logger.Debug("served search results",
+	h.log(r.Context()).Debug("served search results",
 		zap.String("query", query),
 		zap.Int("size", len(html)))
 
 	return nil
 }
 
-// serveTable serves tabular data from a DuckDB macro, formatted as an ASCII table.
-func (h *HTMLFromDuckDB) serveTable(w http.ResponseWriter, r *http.Request) error {
-	// Extract query params
-	params := r.URL.Query()
-
-	// Build macro call with all params
+// tableMacroArgs returns the table macro's arguments for a request: every
+// query parameter except the reserved format, columns and order_by (and
+// chart for SVG charts, and the grid parameters with table_grid), plus
+// base_path.
+func (h *HTMLFromDuckDB) tableMacroArgs(r *http.Request, params url.Values) string {
 	var paramParts []string
 	for key, values := range params {
+		if key == "format" || slices.Contains(projectionParams, key) || h.isGridParam(key) || (key == "chart" && params.Get("format") == "svg") {
+			continue
+		}
 		if len(values) > 0 {
 			// Sanitize parameter name
 			sanitizedKey := sanitizeIdentifier(key)
@@ -725,11 +2473,18 @@ func (h *HTMLFromDuckDB) serveTable(w http.ResponseWriter, r *http.Request) erro
 		paramParts = append(paramParts, fmt.Sprintf("base_path := '%s'", escapeSQLString(basePath)))
 	}
 
-	query := fmt.Sprintf("SELECT * FROM %s(%s)",
-		sanitizeIdentifier(h.TableMacro),
-		strings.Join(paramParts, ", "))
+	return strings.Join(paramParts, ", ")
+}
+
+// serveTable serves tabular data from a DuckDB macro, formatted as an ASCII table.
+func (h *HTMLFromDuckDB) serveTable(w http.ResponseWriter, r *http.Request) error {
+	// Extract query params
+	params := r.URL.Query()
+	args := h.tableMacroArgs(r, params)
+
+	query := fmt.Sprintf("SELECT * FROM %s(%s)", sanitizeIdentifier(h.TableMacro), args)
 
-	h.logger.Debug("executing table macro",
+	h.log(r.Context()).Debug("executing table macro",
 		zap.String("macro", h.TableMacro),
 		zap.String("query", query))
 
@@ -741,142 +2496,119 @@ func (h *HTMLFromDuckDB) serveTable(w http.ResponseWriter, r *http.Request) erro
 		defer cancel()
 	}
 
-	rows, err := h.db.QueryContext(ctx, query)
+	query, err := h.projectTable(ctx, query, params)
+	if err != nil {
+		return err
+	}
+	if query, err = h.orderTable(ctx, query); err != nil {
+		return err
+	}
+	page, err := h.tablePage(params)
+	if err != nil {
+		return err
+	}
+
+	extra := 0
+	if format := params.Get("format"); format == "" || format == "html" {
+		// One more row than the page tells whether there's a next page
+		extra = 1
+	}
+	query = page.wrap(query, extra)
+
+	switch format := params.Get("format"); format {
+	case "", "html":
+	case formatJSON, formatCSV, formatNDJSON:
+		return h.serveTableRows(ctx, w, r, format, query)
+	case "arrow":
+		return h.serveTableArrow(ctx, w, query)
+	case "parquet":
+		return h.serveTableParquet(ctx, w, r, query)
+	case "svg":
+		return h.serveTableChart(ctx, w, r, params.Get("chart"), query)
+	case "xlsx":
+		return h.serveTableXLSX(ctx, w, r, query)
+	default:
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("unsupported table format %q", format))
+	}
+
+	start := time.Now()
+	rows, err := h.queryRetry(ctx, "table", h.queries(ctx, "table"), query)
 	if err != nil {
-		h.logger.Error("table macro failed", zap.Error(err))
+		h.logFailure(r.Context(), "table macro failed", zap.Error(err))
 		return caddyhttp.Error(http.StatusInternalServerError, err)
 	}
 	defer rows.Close()
 
-	// Format with tablewriter
-	html, err := h.formatTable(rows)
+	box := getDuckbox()
+	defer putDuckbox(box)
+	box.format = h.TableFormat
+	maxRows := h.TableMaxRows
+	if page.limit > 0 {
+		maxRows = page.limit
+	}
+	err = box.scan(rows, maxRows)
+	h.observeQuery(ctx, "table", query, time.Since(start))
+	if err != nil {
+		h.log(r.Context()).Error("table formatting failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if h.TableGrid {
+		hasNext := false
+		if page.limit > 0 {
+			// The extra row isn't left out, it's on the next page
+			hasNext, box.truncated = box.truncated > 0, 0
+		}
+		box.grid = h.tableGrid(r, box, page, hasNext)
+	}
+	if h.TableFooterMacro != "" {
+		if err := h.scanTableFooter(ctx, box, args); err != nil {
+			h.logFailure(r.Context(), "table footer macro failed", zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+	}
+	if box.truncated > 0 {
+		w.Header().Set("X-Truncated", strconv.Itoa(box.truncated))
+		h.log(r.Context()).Warn("table output truncated",
+			zap.String("macro", h.TableMacro),
+			zap.Int("max_rows", h.TableMaxRows),
+			zap.Int("omitted_rows", box.truncated))
+	}
+
+	// Render once into a hash for the ETag and length, then again straight
+	// into the response, so the table is never held in memory as a whole.
+	etag, size, err := box.etag()
 	if err != nil {
-		h.logger.Error("table formatting failed", zap.Error(err))
 		return caddyhttp.Error(http.StatusInternalServerError, err)
 	}
 
+	w.Header().Set("Cache-Control", h.tableCacheControl())
+	if notModified(w, r, etag) {
+		return nil
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("Content-Length", strconv.Itoa(len(html)))
-	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 
 	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(html)); err != nil {
-		h.logger.Error("failed to write response", zap.Error(err))
+	if err := box.renderTo(w); err != nil {
+		h.logFailure(r.Context(), "failed to write response", zap.Error(err))
 		return err
 	}
 
-	h.logger.Debug("served table",
+	h.log(r.Context()).Debug("served table",
 		zap.String("macro", h.TableMacro),
-		zap.Int("size", len(html)))
+		zap.Int64("size", size))
 
 	return nil
 }
 
-// formatTable formats SQL rows as an ASCII table wrapped in HTML pre tags.
-func (h *HTMLFromDuckDB) formatTable(rows *sql.Rows) (string, error) {
-	cols, err := rows.ColumnTypes()
-	if err != nil {
-		return "", err
-	}
-
-	colNames := make([]string, len(cols))
-	alignments := make([]tw.Align, len(cols))
-	for i, col := range cols {
-		colNames[i] = col.Name()
-		// Right-align numeric types
-		switch col.DatabaseTypeName() {
-		case "INTEGER", "BIGINT", "DOUBLE", "FLOAT", "DECIMAL", "HUGEINT", "SMALLINT", "TINYINT", "UBIGINT", "UINTEGER", "USMALLINT", "UTINYINT":
-			alignments[i] = tw.AlignRight
-		default:
-			alignments[i] = tw.AlignLeft
-		}
-	}
-
-	var buf strings.Builder
-	buf.WriteString(`<pre class="duckbox">`)
-	buf.WriteString("\n")
-
-	// Create table with borderless renderer
-	table := tablewriter.NewTable(&buf,
-		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
-			Borders: tw.BorderNone,
-			Settings: tw.Settings{
-				Separators: tw.Separators{
-					BetweenRows:    tw.Off,
-					BetweenColumns: tw.Off, // no inner separators
-				},
-				Lines: tw.Lines{
-					ShowHeaderLine: tw.On, // blank line after header
-					ShowFooterLine: tw.Off,
-				},
-			},
-		})),
-		tablewriter.WithConfig(tablewriter.Config{
-			Header: tw.CellConfig{
-				Alignment: tw.CellAlignment{
-					Global: tw.AlignLeft,
-				},
-				Formatting: tw.CellFormatting{
-					AutoFormat: tw.Off,
-				},
-			},
-			Row: tw.CellConfig{
-				Alignment: tw.CellAlignment{
-					PerColumn: alignments,
-				},
-			},
-		}),
-	)
-
-	// Convert string slice to any slice for Header
-	headerAny := make([]any, len(colNames))
-	for i, v := range colNames {
-		headerAny[i] = v
-	}
-	table.Header(headerAny...)
-
-	// Add blank line between header and data rows
-	emptyRow := make([]string, len(cols))
-	table.Append(emptyRow)
-
-	// Scan rows
-	values := make([]interface{}, len(cols))
-	valuePtrs := make([]interface{}, len(cols))
-	for i := range values {
-		valuePtrs[i] = &values[i]
-	}
-
-	for rows.Next() {
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return "", err
-		}
-
-		row := make([]string, len(cols))
-		for i, v := range values {
-			if v == nil {
-				row[i] = ""
-			} else {
-				row[i] = fmt.Sprintf("%v", v)
-			}
-		}
-		table.Append(row)
-	}
-
-	if err := rows.Err(); err != nil {
-		return "", err
-	}
-
-	table.Render()
-	buf.WriteString(`</pre>`)
-
-	return buf.String(), nil
-}
-
 // HealthResponse represents the JSON structure of a health check response.
 type HealthResponse struct {
-	Status string                  `json:"status"`
-	Checks map[string]*CheckResult `json:"checks"`
-	Pool   *PoolStats              `json:"pool,omitempty"`
+	Status string                   `json:"status"`
+	Checks map[string]*CheckResult  `json:"checks"`
+	Pool   *PoolStats               `json:"pool,omitempty"`
+	Info   *DatabaseInfo            `json:"info,omitempty"`
+	Served map[string]EndpointSizes `json:"served,omitempty"`
 }
 
 // CheckResult represents the result of a single health check.
@@ -884,18 +2616,66 @@ type CheckResult struct {
 	Status    string `json:"status"`
 	Name      string `json:"name,omitempty"`
 	LatencyMs int64  `json:"latency_ms,omitempty"`
-	Error     string `json:"error,omitempty"`
+	// Code identifies the kind of failure with a stable value, such as
+	// "macro_not_found"; Error has the details.
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	// Set for macros in detailed mode, from the canary query.
+	Rows            *int64 `json:"rows,omitempty"`
+	CanaryLatencyMs *int64 `json:"canary_latency_ms,omitempty"`
+}
+
+// redacted returns a copy of the response without check names, error
+// details, pool stats, database info or response sizes, which reveal the
+// schema and internals to untrusted callers. Statuses and error codes stay, so monitoring still works.
+func (resp HealthResponse) redacted() HealthResponse {
+	out := HealthResponse{Status: resp.Status, Checks: make(map[string]*CheckResult, len(resp.Checks))}
+	for name, check := range resp.Checks {
+		// Route prefixes are configuration too; keep the worst route result.
+		if strings.HasPrefix(name, "record_route ") {
+			name = "record_route"
+			if prev := out.Checks[name]; prev != nil && prev.Status != "ok" {
+				continue
+			}
+		}
+		out.Checks[name] = &CheckResult{Status: check.Status, LatencyMs: check.LatencyMs, Code: check.Code}
+	}
+	return out
 }
 
 // PoolStats represents database connection pool statistics.
 type PoolStats struct {
-	OpenConnections int `json:"open_connections"`
-	InUse           int `json:"in_use"`
-	Idle            int `json:"idle"`
+	OpenConnections   int           `json:"open_connections"`
+	InUse             int           `json:"in_use"`
+	Idle              int           `json:"idle"`
+	WaitCount         int64         `json:"wait_count"`
+	WaitDurationMs    int64         `json:"wait_duration_ms"`
+	MaxIdleClosed     int64         `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64         `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64         `json:"max_lifetime_closed"`
+	Settings          *PoolSettings `json:"settings,omitempty"`
+	// Analytics has the stats of the analytics sub-pool, if the pool is
+	// partitioned with analytics_pool_size.
+	Analytics *PoolStats `json:"analytics,omitempty"`
+}
+
+// PoolSettings represents the configured limits of a connection pool.
+type PoolSettings struct {
+	MaxOpenConns    int    `json:"max_open_conns"`
+	MaxIdleConns    int    `json:"max_idle_conns"`
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+	ConnMaxIdleTime string `json:"conn_max_idle_time"`
 }
 
 // serveHealth serves the health check endpoint.
 func (h *HTMLFromDuckDB) serveHealth(w http.ResponseWriter, r *http.Request) error {
+	trusted := h.authorized(r, scopeHealth) || h.clientAllowed(r, h.healthAllow)
+	if h.HealthAuth && !trusted {
+		unauthorized(w)
+		return nil
+	}
+
 	response := HealthResponse{
 		Status: "healthy",
 		Checks: make(map[string]*CheckResult),
@@ -910,6 +2690,25 @@ func (h *HTMLFromDuckDB) serveHealth(w http.ResponseWriter, r *http.Request) err
 		allHealthy = false
 	}
 
+	// Report a writer holding the database file's lock
+	if h.lockAware() && h.lock != nil {
+		lockCheck := h.checkLock()
+		response.Checks["database_lock"] = lockCheck
+		if lockCheck.Status != "ok" {
+			allHealthy = false
+		}
+	}
+
+	// Report the duckdb app's latest background probe of the database
+	if h.shared != nil {
+		if probe := h.shared.lastProbe(); probe != nil {
+			response.Checks["database_probe"] = probe
+			if probe.Status != "ok" {
+				allHealthy = false
+			}
+		}
+	}
+
 	// Check table accessibility
 	tableCheck := h.checkTable(r.Context())
 	response.Checks["table"] = tableCheck
@@ -944,6 +2743,33 @@ func (h *HTMLFromDuckDB) serveHealth(w http.ResponseWriter, r *http.Request) err
 		}
 	}
 
+	// Check record route macros
+	for _, route := range h.RecordRoutes {
+		routeCheck := h.checkMacro(r.Context(), route.Macro, "record_route")
+		response.Checks["record_route "+route.Prefix] = routeCheck
+		if routeCheck.Status != "ok" {
+			allHealthy = false
+		}
+	}
+
+	// Check preload macro if configured
+	if h.PreloadMacro != "" {
+		preloadCheck := h.checkMacro(r.Context(), h.PreloadMacro, "preload_macro")
+		response.Checks["preload_macro"] = preloadCheck
+		if preloadCheck.Status != "ok" {
+			allHealthy = false
+		}
+	}
+
+	// Check not found macro if configured
+	if h.NotFoundMacro != "" {
+		notFoundCheck := h.checkMacro(r.Context(), h.NotFoundMacro, "not_found_macro")
+		response.Checks["not_found_macro"] = notFoundCheck
+		if notFoundCheck.Status != "ok" {
+			allHealthy = false
+		}
+	}
+
 	// Check table macro if configured
 	if h.TableMacro != "" {
 		tableCheck := h.checkMacro(r.Context(), h.TableMacro, "table_macro")
@@ -953,19 +2779,32 @@ func (h *HTMLFromDuckDB) serveHealth(w http.ResponseWriter, r *http.Request) err
 		}
 	}
 
-	// Add pool stats if detailed mode is enabled
+	// Check table footer macro if configured
+	if h.TableFooterMacro != "" {
+		footerCheck := h.checkMacro(r.Context(), h.TableFooterMacro, "table_footer_macro")
+		response.Checks["table_footer_macro"] = footerCheck
+		if footerCheck.Status != "ok" {
+			allHealthy = false
+		}
+	}
+
+	// Add pool stats, versions and response sizes if detailed mode is enabled
 	if h.HealthDetailed {
-		stats := h.db.Stats()
-		response.Pool = &PoolStats{
-			OpenConnections: stats.OpenConnections,
-			InUse:           stats.InUse,
-			Idle:            stats.Idle,
+		response.Pool = h.poolStats()
+		if info, err := h.databaseInfo(r.Context()); err == nil {
+			response.Info = info
+		} else {
+			h.log(r.Context()).Warn("failed to collect database info", zap.Error(err))
+		}
+		if h.served != nil {
+			response.Served = h.served.snapshot()
 		}
 	}
 
 	if !allHealthy {
 		response.Status = "unhealthy"
 	}
+	h.notifyHealth(response.Status, response.Checks)
 
 	// Determine HTTP status code
 	statusCode := http.StatusOK
@@ -973,10 +2812,14 @@ func (h *HTMLFromDuckDB) serveHealth(w http.ResponseWriter, r *http.Request) err
 		statusCode = http.StatusServiceUnavailable
 	}
 
+	if h.HealthRedactErrors && !trusted {
+		response = response.redacted()
+	}
+
 	// Marshal response
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		h.logger.Error("failed to marshal health response", zap.Error(err))
+		h.log(r.Context()).Error("failed to marshal health response", zap.Error(err))
 		return caddyhttp.Error(http.StatusInternalServerError, err)
 	}
 
@@ -986,17 +2829,55 @@ func (h *HTMLFromDuckDB) serveHealth(w http.ResponseWriter, r *http.Request) err
 	w.WriteHeader(statusCode)
 
 	if _, err := w.Write(jsonResponse); err != nil {
-		h.logger.Error("failed to write health response", zap.Error(err))
+		h.log(r.Context()).Error("failed to write health response", zap.Error(err))
 		return err
 	}
 
-	h.logger.Debug("served health check",
+	h.log(r.Context()).Debug("served health check",
 		zap.String("status", response.Status),
 		zap.Int("status_code", statusCode))
 
 	return nil
 }
 
+// poolStats returns the connection pool statistics and settings.
+func (h *HTMLFromDuckDB) poolStats() *PoolStats {
+	parts := h.poolPartitions()
+	ps := newPoolStats(parts[partitionRecord].Stats())
+	if pool := h.currentPool(); pool != nil {
+		settings := pool.currentSettings()
+		ps.Settings = &PoolSettings{
+			MaxOpenConns:    settings.maxOpen,
+			MaxIdleConns:    settings.maxIdle,
+			ConnMaxLifetime: settings.maxLifetime.String(),
+			ConnMaxIdleTime: settings.maxIdleTime.String(),
+		}
+	}
+	if analytics, ok := parts[partitionAnalytics]; ok {
+		ps.Analytics = newPoolStats(analytics.Stats())
+		if ps.Settings != nil {
+			settings := *ps.Settings
+			settings.MaxOpenConns = analytics.Stats().MaxOpenConnections
+			ps.Analytics.Settings = &settings
+		}
+	}
+	return ps
+}
+
+// newPoolStats converts database/sql pool statistics.
+func newPoolStats(stats sql.DBStats) *PoolStats {
+	return &PoolStats{
+		OpenConnections:   stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitDurationMs:    stats.WaitDuration.Milliseconds(),
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+	}
+}
+
 // checkDatabase verifies database connectivity with a ping.
 func (h *HTMLFromDuckDB) checkDatabase(ctx context.Context) *CheckResult {
 	start := time.Now()
@@ -1007,13 +2888,15 @@ func (h *HTMLFromDuckDB) checkDatabase(ctx context.Context) *CheckResult {
 		defer cancel()
 	}
 
-	err := h.db.PingContext(ctx)
+	err := h.database().PingContext(ctx)
 	latency := time.Since(start).Milliseconds()
+	h.noteLock(err)
 
 	if err != nil {
 		return &CheckResult{
 			Status:    "error",
 			LatencyMs: latency,
+			Code:      "database_unavailable",
 			Error:     err.Error(),
 		}
 	}
@@ -1035,7 +2918,7 @@ func (h *HTMLFromDuckDB) checkTable(ctx context.Context) *CheckResult {
 	}
 
 	query := fmt.Sprintf("SELECT 1 FROM %s LIMIT 1", sanitizeIdentifier(h.Table))
-	_, err := h.db.ExecContext(ctx, query)
+	_, err := h.database().ExecContext(ctx, tagQuery(ctx, query))
 	latency := time.Since(start).Milliseconds()
 
 	if err != nil {
@@ -1043,6 +2926,7 @@ func (h *HTMLFromDuckDB) checkTable(ctx context.Context) *CheckResult {
 			Status:    "error",
 			Name:      h.Table,
 			LatencyMs: latency,
+			Code:      "table_unavailable",
 			Error:     err.Error(),
 		}
 	}
@@ -1055,6 +2939,8 @@ func (h *HTMLFromDuckDB) checkTable(ctx context.Context) *CheckResult {
 }
 
 // checkMacro verifies a DuckDB macro exists by querying duckdb_functions().
+// In detailed mode it also runs the macro with canary parameters, since a
+// macro can exist and still fail, e.g. when an extension it uses is missing.
 func (h *HTMLFromDuckDB) checkMacro(ctx context.Context, macroName, checkName string) *CheckResult {
 	start := time.Now()
 
@@ -1067,7 +2953,7 @@ func (h *HTMLFromDuckDB) checkMacro(ctx context.Context, macroName, checkName st
 	// Query DuckDB's function catalog to check if macro exists
 	query := "SELECT 1 FROM duckdb_functions() WHERE function_name = ? AND function_type = 'table_macro' LIMIT 1"
 	var exists int
-	err := h.db.QueryRowContext(ctx, query, macroName).Scan(&exists)
+	err := h.database().QueryRowContext(ctx, tagQuery(ctx, query), macroName).Scan(&exists)
 	latency := time.Since(start).Milliseconds()
 
 	if err == sql.ErrNoRows {
@@ -1075,6 +2961,7 @@ func (h *HTMLFromDuckDB) checkMacro(ctx context.Context, macroName, checkName st
 			Status:    "error",
 			Name:      macroName,
 			LatencyMs: latency,
+			Code:      "macro_not_found",
 			Error:     "macro not found",
 		}
 	}
@@ -1083,28 +2970,82 @@ func (h *HTMLFromDuckDB) checkMacro(ctx context.Context, macroName, checkName st
 			Status:    "error",
 			Name:      macroName,
 			LatencyMs: latency,
+			Code:      "macro_check_failed",
 			Error:     err.Error(),
 		}
 	}
+	if !h.HealthDetailed {
+		return &CheckResult{
+			Status:    "ok",
+			Name:      macroName,
+			LatencyMs: latency,
+		}
+	}
+
+	canaryStart := time.Now()
+	rows, err := h.runCanary(ctx, h.canaryQuery(checkName, macroName))
+	canaryLatency := time.Since(canaryStart).Milliseconds()
+	latency = time.Since(start).Milliseconds()
+	if err != nil {
+		return &CheckResult{
+			Status:          "error",
+			Name:            macroName,
+			LatencyMs:       latency,
+			CanaryLatencyMs: &canaryLatency,
+			Code:            "canary_failed",
+			Error:           fmt.Sprintf("canary query failed: %v", err),
+		}
+	}
 
 	return &CheckResult{
-		Status:    "ok",
-		Name:      macroName,
-		LatencyMs: latency,
+		Status:          "ok",
+		Name:            macroName,
+		LatencyMs:       latency,
+		Rows:            &rows,
+		CanaryLatencyMs: &canaryLatency,
 	}
 }
 
 // UnmarshalCaddyfile implements caddyfile.Unmarshaler.
 func (h *HTMLFromDuckDB) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	var set []string
 	for d.Next() {
+		configured := false
 		for d.NextBlock(0) {
+			if d.Val() == "preset" {
+				if configured {
+					return d.Err("preset must come before other subdirectives, which override it")
+				}
+				if err := h.applyPreset(d); err != nil {
+					return err
+				}
+				continue
+			}
+			configured = true
+			if d.CountRemainingArgs() > 0 {
+				// Without an argument, as with an empty {$ENV:}, the
+				// option is left to the global defaults.
+				set = append(set, d.Val())
+			}
 			switch d.Val() {
+			case "name":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.Name = d.Val()
+
 			case "database_path":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				h.DatabasePath = d.Val()
 
+			case "database":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.Database = d.Val()
+
 			case "table":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -1135,45 +3076,311 @@ func (h *HTMLFromDuckDB) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				}
 				h.WhereClause = d.Val()
 
+			case "filter_params":
+				filters, err := parseFilterParams(d)
+				if err != nil {
+					return err
+				}
+				h.FilterParams = append(h.FilterParams, filters...)
+
 			case "not_found_redirect":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				h.NotFoundRedirect = d.Val()
 
+			case "fallback_file_root":
+				if d.NextArg() {
+					h.FallbackFileRoot = d.Val()
+				}
+				// No error if empty - allows {$FALLBACK_FILE_ROOT:} with empty default
+
+			case "not_found_macro":
+				if d.NextArg() {
+					h.NotFoundMacro = d.Val()
+				}
+				// No error if empty - allows {$NOT_FOUND_MACRO:} with empty default
+
+			case "deleted_column":
+				if d.NextArg() {
+					h.DeletedColumn = d.Val()
+				}
+				// No error if empty - allows {$DELETED_COLUMN:} with empty default
+
+			case "gone_where_clause":
+				if d.NextArg() {
+					h.GoneWhereClause = d.Val()
+				}
+				// No error if empty - allows {$GONE_WHERE_CLAUSE:} with empty default
+
+			case "gone_macro":
+				if d.NextArg() {
+					h.GoneMacro = d.Val()
+				}
+				// No error if empty - allows {$GONE_MACRO:} with empty default
+
+			case "negative_cache_ttl":
+				if err := parseOptionalDurationArg(d, &h.NegativeCacheTTL); err != nil {
+					return err
+				}
+				// No error if empty - allows {$NEGATIVE_CACHE_TTL:} with empty default
+
 			case "cache_control":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				h.CacheControl = d.Val()
 
+			case "headers_column":
+				if d.NextArg() {
+					h.HeadersColumn = d.Val()
+				}
+				// No error if empty - allows {$HEADERS_COLUMN:} with empty default
+
+			case "headers_allow":
+				h.HeadersAllow = append(h.HeadersAllow, d.RemainingArgs()...)
+
+			case "preload_column":
+				if d.NextArg() {
+					h.PreloadColumn = d.Val()
+				}
+				// No error if empty - allows {$PRELOAD_COLUMN:} with empty default
+
+			case "tags_column":
+				if d.NextArg() {
+					h.TagsColumn = d.Val()
+				}
+				// No error if empty - allows {$TAGS_COLUMN:} with empty default
+
+			case "tags_headers":
+				h.TagsHeaders = append(h.TagsHeaders, d.RemainingArgs()...)
+
+			case "preload_macro":
+				if d.NextArg() {
+					h.PreloadMacro = d.Val()
+				}
+				// No error if empty - allows {$PRELOAD_MACRO:} with empty default
+
+			case "early_hints":
+				if err := parseBoolArg(d, &h.EarlyHints); err != nil {
+					return err
+				}
+
+			case "markdown_column":
+				if d.NextArg() {
+					h.MarkdownColumn = d.Val()
+				}
+				// No error if empty - allows {$MARKDOWN_COLUMN:} with empty default
+
+			case "render_markdown":
+				if err := parseBoolArg(d, &h.RenderMarkdown); err != nil {
+					return err
+				}
+
+			case "markdown_extensions":
+				h.MarkdownExtensions = append(h.MarkdownExtensions, d.RemainingArgs()...)
+
+			case "meta_columns":
+				cols, err := parseMetaColumns(d.RemainingArgs())
+				if err != nil {
+					return d.Errf("invalid meta_columns: %v", err)
+				}
+				if h.MetaColumns == nil {
+					h.MetaColumns = make(map[string]string)
+				}
+				for key, col := range cols {
+					h.MetaColumns[key] = col
+				}
+
+			case "markdown_unsafe":
+				if err := parseBoolArg(d, &h.MarkdownUnsafe); err != nil {
+					return err
+				}
+
+			case "vary":
+				h.Vary = append(h.Vary, d.RemainingArgs()...)
+
 			case "read_only":
-				if !d.NextArg() {
-					return d.ArgErr()
+				var readOnly bool
+				if err := parseBoolArg(d, &readOnly); err != nil {
+					return err
 				}
-				readOnly := d.Val() == "true"
 				h.ReadOnly = &readOnly
 
-			case "connection_pool_size":
+			case "lock_wait":
+				if err := parseOptionalDurationArg(d, &h.LockWait); err != nil {
+					return err
+				}
+				// No error if empty - allows {$LOCK_WAIT:} with empty default
+
+			case "attach_read_only":
+				if err := parseBoolArg(d, &h.AttachReadOnly); err != nil {
+					return err
+				}
+
+			case "load_into_memory":
+				if err := parseBoolArg(d, &h.LoadIntoMemory); err != nil {
+					return err
+				}
+
+			case "memory_reload_interval":
+				if err := parseOptionalDurationArg(d, &h.MemoryReloadInterval); err != nil {
+					return err
+				}
+				// No error if empty - allows {$MEMORY_RELOAD_INTERVAL:} with empty default
+
+			case "datasets":
+				datasets, err := parseDatasets(d)
+				if err != nil {
+					return err
+				}
+				h.Datasets = append(h.Datasets, datasets...)
+
+			case "dataset_refresh_interval":
+				if err := parseOptionalDurationArg(d, &h.DatasetRefreshInterval); err != nil {
+					return err
+				}
+				// No error if empty - allows {$DATASET_REFRESH_INTERVAL:} with empty default
+
+			case "databases":
+				databases, err := parseDatabases(d)
+				if err != nil {
+					return err
+				}
+				if h.Databases == nil {
+					h.Databases = make(map[string]string)
+				}
+				maps.Copy(h.Databases, databases)
+
+			case "database_selector":
+				if d.NextArg() {
+					h.DatabaseSelector = d.Val()
+				}
+				// No error if empty - allows {$DATABASE_SELECTOR:} with empty default
+
+			case "shadow_database_path":
+				if d.NextArg() {
+					h.ShadowDatabasePath = d.Val()
+				}
+				// No error if empty - allows {$SHADOW_DATABASE_PATH:} with empty default
+
+			case "shadow_sample_rate":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
-				var err error
-				if _, err = fmt.Sscanf(d.Val(), "%d", &h.ConnectionPoolSize); err != nil {
-					return d.Errf("invalid connection_pool_size: %v", err)
+				rate, err := strconv.ParseFloat(d.Val(), 64)
+				if err != nil {
+					return d.Errf("invalid shadow_sample_rate: %v", err)
 				}
+				h.ShadowSampleRate = rate
 
-			case "query_timeout":
+			case "canary_database_path":
+				if d.NextArg() {
+					h.CanaryDatabasePath = d.Val()
+				}
+				// No error if empty - allows {$CANARY_DATABASE_PATH:} with empty default
+
+			case "canary_percent":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
-				h.QueryTimeout = d.Val()
+				percent, err := strconv.ParseFloat(d.Val(), 64)
+				if err != nil {
+					return d.Errf("invalid canary_percent: %v", err)
+				}
+				h.CanaryPercent = &percent
+
+			case "experiments":
+				experiments, err := parseExperiments(d)
+				if err != nil {
+					return err
+				}
+				h.Experiments = append(h.Experiments, experiments...)
+
+			case "connection_pool_size":
+				if err := parseIntArg(d, &h.ConnectionPoolSize); err != nil {
+					return err
+				}
+
+			case "max_idle_conns":
+				if err := parseIntArg(d, &h.MaxIdleConns); err != nil {
+					return err
+				}
+
+			case "record_pool_size":
+				if err := parseIntArg(d, &h.RecordPoolSize); err != nil {
+					return err
+				}
+
+			case "analytics_pool_size":
+				if err := parseIntArg(d, &h.AnalyticsPoolSize); err != nil {
+					return err
+				}
+
+			case "conn_max_lifetime":
+				if err := parseOptionalDurationArg(d, &h.ConnMaxLifetime); err != nil {
+					return err
+				}
+				// No error if empty - allows {$CONN_MAX_LIFETIME:} with empty default
+
+			case "conn_max_idle_time":
+				if err := parseOptionalDurationArg(d, &h.ConnMaxIdleTime); err != nil {
+					return err
+				}
+				// No error if empty - allows {$CONN_MAX_IDLE_TIME:} with empty default
+
+			case "memory_limit":
+				if d.NextArg() {
+					h.MemoryLimit = d.Val()
+				}
+				// No error if empty - allows {$MEMORY_LIMIT:} with empty default
+
+			case "threads":
+				if err := parseOptionalIntArg(d, &h.Threads); err != nil {
+					return err
+				}
+				// No error if empty - allows {$THREADS:} with empty default
+
+			case "temp_directory":
+				if d.NextArg() {
+					h.TempDirectory = d.Val()
+				}
+				// No error if empty - allows {$TEMP_DIRECTORY:} with empty default
+
+			case "max_temp_directory_size":
+				if d.NextArg() {
+					h.MaxTempDirectorySize = d.Val()
+				}
+				// No error if empty - allows {$MAX_TEMP_DIRECTORY_SIZE:} with empty default
+
+			case "query_timeout":
+				if err := parseDurationArg(d, &h.QueryTimeout); err != nil {
+					return err
+				}
+
+			case "retry_attempts":
+				var attempts int
+				if err := parseIntArg(d, &attempts); err != nil {
+					return err
+				}
+				h.RetryAttempts = &attempts
+
+			case "retry_backoff":
+				if err := parseOptionalDurationArg(d, &h.RetryBackoff); err != nil {
+					return err
+				}
+				// No error if empty - allows {$RETRY_BACKOFF:} with empty default
+
+			case "slow_query_threshold":
+				if err := parseOptionalDurationArg(d, &h.SlowQueryThreshold); err != nil {
+					return err
+				}
+				// No error if empty - allows {$SLOW_QUERY_THRESHOLD:} with empty default
 
 			case "index_enabled":
-				if !d.NextArg() {
-					return d.ArgErr()
+				if err := parseBoolArg(d, &h.IndexEnabled); err != nil {
+					return err
 				}
-				h.IndexEnabled = d.Val() == "true"
 
 			case "index_macro":
 				if !d.NextArg() {
@@ -1181,11 +3388,39 @@ func (h *HTMLFromDuckDB) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				}
 				h.IndexMacro = d.Val()
 
+			case "index_cache_ttl":
+				if err := parseOptionalDurationArg(d, &h.IndexCacheTTL); err != nil {
+					return err
+				}
+				// No error if empty - allows {$INDEX_CACHE_TTL:} with empty default
+
+			case "hierarchy_enabled":
+				if err := parseBoolArg(d, &h.HierarchyEnabled); err != nil {
+					return err
+				}
+
+			case "index_version_query":
+				if d.NextArg() {
+					h.IndexVersionQuery = d.Val()
+				}
+				// No error if empty - allows {$INDEX_VERSION_QUERY:} with empty default
+
+			case "invalidate_query":
+				if d.NextArg() {
+					h.InvalidateQuery = d.Val()
+				}
+				// No error if empty - allows {$INVALIDATE_QUERY:} with empty default
+
+			case "invalidate_interval":
+				if err := parseOptionalDurationArg(d, &h.InvalidateInterval); err != nil {
+					return err
+				}
+				// No error if empty - allows {$INVALIDATE_INTERVAL:} with empty default
+
 			case "search_enabled":
-				if !d.NextArg() {
-					return d.ArgErr()
+				if err := parseBoolArg(d, &h.SearchEnabled); err != nil {
+					return err
 				}
-				h.SearchEnabled = d.Val() == "true"
 
 			case "search_macro":
 				if !d.NextArg() {
@@ -1203,6 +3438,9 @@ func (h *HTMLFromDuckDB) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
+				if v := d.Val(); v != "" && (!strings.HasPrefix(v, "/") || strings.HasSuffix(v, "/")) {
+					return d.Errf("invalid base_path: %q must start with / and not end with one", v)
+				}
 				h.BasePath = d.Val()
 
 			case "init_sql_file":
@@ -1217,6 +3455,173 @@ func (h *HTMLFromDuckDB) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				}
 				// No error if empty - allows {$RECORD_MACRO:} with empty default
 
+			case "record_query":
+				if d.NextArg() {
+					h.RecordQuery = d.Val()
+				}
+				// No error if empty - allows {$RECORD_QUERY:} with empty default
+
+			case "id_resolver":
+				raw, err := parseInlineModule(d, idResolverNamespace, "resolver")
+				if err != nil {
+					return err
+				}
+				h.IDResolverRaw = raw
+
+			case "transform":
+				raw, err := parseInlineModule(d, transformerNamespace, "transformer")
+				if err != nil {
+					return err
+				}
+				h.TransformersRaw = append(h.TransformersRaw, raw)
+
+			case "endpoint":
+				em, err := parseEndpointMatcher(d)
+				if err != nil {
+					return err
+				}
+				h.EndpointMatchers = append(h.EndpointMatchers, em)
+
+			case "fragment_path":
+				if d.NextArg() {
+					h.FragmentPath = d.Val()
+				}
+				// No error if empty - allows {$FRAGMENT_PATH:} with empty default
+
+			case "content_path":
+				if d.NextArg() {
+					h.ContentPath = d.Val()
+				}
+				// No error if empty - allows {$CONTENT_PATH:} with empty default
+
+			case "content_hash_column":
+				if d.NextArg() {
+					h.ContentHashColumn = d.Val()
+				}
+				// No error if empty - allows {$CONTENT_HASH_COLUMN:} with empty default
+
+			case "includes":
+				if err := parseBoolArg(d, &h.Includes); err != nil {
+					return err
+				}
+
+			case "include_max_depth":
+				if err := parseIntArg(d, &h.IncludeMaxDepth); err != nil {
+					return err
+				}
+
+			case "esi":
+				if err := parseBoolArg(d, &h.ESI); err != nil {
+					return err
+				}
+
+			case "esi_cache_ttl":
+				if err := parseOptionalDurationArg(d, &h.ESICacheTTL); err != nil {
+					return err
+				}
+				// No error if empty - allows {$ESI_CACHE_TTL:} with empty default
+
+			case "sync":
+				sync, err := parseSync(d)
+				if err != nil {
+					return err
+				}
+				h.Sync = sync
+
+			case "quota":
+				quota, err := parseQuota(d)
+				if err != nil {
+					return err
+				}
+				h.Quota = quota
+
+			case "backup":
+				backup, err := parseBackup(d)
+				if err != nil {
+					return err
+				}
+				h.Backup = backup
+
+			case "load_shedding":
+				ls, err := parseLoadShedding(d)
+				if err != nil {
+					return err
+				}
+				h.LoadShedding = ls
+
+			case "webhooks":
+				webhooks, err := parseWebhooks(d)
+				if err != nil {
+					return err
+				}
+				h.Webhooks = webhooks
+
+			case "miss_log":
+				missLog, err := parseMissLog(d)
+				if err != nil {
+					return err
+				}
+				h.MissLog = missLog
+
+			case "record_route":
+				route, err := parseRecordRoute(d)
+				if err != nil {
+					return err
+				}
+				h.RecordRoutes = append(h.RecordRoutes, route)
+
+			case "revisions_path":
+				if d.NextArg() {
+					h.RevisionsPath = d.Val()
+				}
+				// No error if empty - allows {$REVISIONS_PATH:} with empty default
+
+			case "revisions_table":
+				if d.NextArg() {
+					h.RevisionsTable = d.Val()
+				}
+				// No error if empty - allows {$REVISIONS_TABLE:} with empty default
+
+			case "revisions_macro":
+				if d.NextArg() {
+					h.RevisionsMacro = d.Val()
+				}
+				// No error if empty - allows {$REVISIONS_MACRO:} with empty default
+
+			case "version_column":
+				if d.NextArg() {
+					h.VersionColumn = d.Val()
+				}
+				// No error if empty - allows {$VERSION_COLUMN:} with empty default
+
+			case "diff_path":
+				if d.NextArg() {
+					h.DiffPath = d.Val()
+				}
+				// No error if empty - allows {$DIFF_PATH:} with empty default
+
+			case "updated_column":
+				if d.NextArg() {
+					h.UpdatedColumn = d.Val()
+				}
+				// No error if empty - allows {$UPDATED_COLUMN:} with empty default
+
+			case "changes_path":
+				if d.NextArg() {
+					h.ChangesPath = d.Val()
+				}
+				// No error if empty - allows {$CHANGES_PATH:} with empty default
+
+			case "changes_max_wait":
+				if err := parseDurationArg(d, &h.ChangesMaxWait); err != nil {
+					return err
+				}
+
+			case "shutdown_grace_period":
+				if err := parseDurationArg(d, &h.ShutdownGracePeriod); err != nil {
+					return err
+				}
+
 			case "table_macro":
 				if d.NextArg() {
 					h.TableMacro = d.Val()
@@ -1229,11 +3634,300 @@ func (h *HTMLFromDuckDB) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				}
 				// No error if empty - allows {$TABLE_PATH:} with empty default
 
-			case "health_enabled":
+			case "geojson_macro":
+				if d.NextArg() {
+					h.GeoJSONMacro = d.Val()
+				}
+				// No error if empty - allows {$GEOJSON_MACRO:} with empty default
+
+			case "geojson_path":
+				if d.NextArg() {
+					h.GeoJSONPath = d.Val()
+				}
+				// No error if empty - allows {$GEOJSON_PATH:} with empty default
+
+			case "geojson_geometry_column":
+				if d.NextArg() {
+					h.GeoJSONGeometryColumn = d.Val()
+				}
+				// No error if empty - allows {$GEOJSON_GEOMETRY_COLUMN:} with empty default
+
+			case "tile_macro":
+				if d.NextArg() {
+					h.TileMacro = d.Val()
+				}
+				// No error if empty - allows {$TILE_MACRO:} with empty default
+
+			case "tile_path":
+				if d.NextArg() {
+					h.TilePath = d.Val()
+				}
+				// No error if empty - allows {$TILE_PATH:} with empty default
+
+			case "tile_cache_ttl":
+				if err := parseOptionalDurationArg(d, &h.TileCacheTTL); err != nil {
+					return err
+				}
+				// No error if empty - allows {$TILE_CACHE_TTL:} with empty default
+
+			case "table_max_rows":
+				if err := parseIntArg(d, &h.TableMaxRows); err != nil {
+					return err
+				}
+
+			case "api_path":
+				if d.NextArg() {
+					h.APIPath = d.Val()
+				}
+				// No error if empty - allows {$API_PATH:} with empty default
+
+			case "table_grid":
+				if err := parseBoolArg(d, &h.TableGrid); err != nil {
+					return err
+				}
+
+			case "table_order":
+				if d.NextArg() {
+					h.TableOrder = d.Val()
+				}
+				// No error if empty - allows {$TABLE_ORDER:} with empty default
+
+			case "table_etag":
+				if err := parseBoolArg(d, &h.TableETag); err != nil {
+					return err
+				}
+
+			case "table_cache_control":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
-				h.HealthEnabled = d.Val() == "true"
+				h.TableCacheControl = d.Val()
+
+			case "table_footer_macro":
+				if d.NextArg() {
+					h.TableFooterMacro = d.Val()
+				}
+				// No error if empty - allows {$TABLE_FOOTER_MACRO:} with empty default
+
+			case "table_sort_columns":
+				h.TableSortColumns = append(h.TableSortColumns, d.RemainingArgs()...)
+
+			case "table_format":
+				format, err := parseTableFormat(d)
+				if err != nil {
+					return err
+				}
+				h.TableFormat = format
+
+			case "value_encoding":
+				encoding, err := parseValueEncoding(d)
+				if err != nil {
+					return err
+				}
+				h.ValueEncoding = encoding
+
+			case "api_columns":
+				h.APIColumns = append(h.APIColumns, d.RemainingArgs()...)
+
+			case "api_page_size":
+				if err := parseIntArg(d, &h.APIPageSize); err != nil {
+					return err
+				}
+
+			case "query_path":
+				if d.NextArg() {
+					h.QueryPath = d.Val()
+				}
+				// No error if empty - allows {$QUERY_PATH:} with empty default
+
+			case "queries_file":
+				if d.NextArg() {
+					h.QueriesFile = d.Val()
+				}
+				// No error if empty - allows {$QUERIES_FILE:} with empty default
+
+			case "queries_path":
+				if d.NextArg() {
+					h.QueriesPath = d.Val()
+				}
+				// No error if empty - allows {$QUERIES_PATH:} with empty default
+
+			case "graphql_path":
+				if d.NextArg() {
+					h.GraphQLPath = d.Val()
+				}
+				// No error if empty - allows {$GRAPHQL_PATH:} with empty default
+
+			case "export_path":
+				if d.NextArg() {
+					h.ExportPath = d.Val()
+				}
+				// No error if empty - allows {$EXPORT_PATH:} with empty default
+
+			case "explain_path":
+				if d.NextArg() {
+					h.ExplainPath = d.Val()
+				}
+				// No error if empty - allows {$EXPLAIN_PATH:} with empty default
+
+			case "strict_rows":
+				if d.NextArg() {
+					h.StrictRows = d.Val()
+				}
+				// No error if empty - allows {$STRICT_ROWS:} with empty default
+
+			case "pin_snapshot":
+				if err := parseBoolArg(d, &h.PinSnapshot); err != nil {
+					return err
+				}
+
+			case "session_variables":
+				vars, err := parseSessionVariables(d)
+				if err != nil {
+					return err
+				}
+				if h.SessionVariables == nil {
+					h.SessionVariables = make(map[string]string)
+				}
+				maps.Copy(h.SessionVariables, vars)
+
+			case "null_html":
+				if d.NextArg() {
+					h.NullHTML = d.Val()
+					if d.NextArg() {
+						h.NullHTMLFallback = d.Val()
+					}
+				}
+				// No error if empty - allows {$NULL_HTML:} with empty default
+
+			case "charset":
+				if d.NextArg() {
+					h.Charset = d.Val()
+				}
+				// No error if empty - allows {$CHARSET:} with empty default
+
+			case "validate_utf8":
+				if err := parseBoolArg(d, &h.ValidateUTF8); err != nil {
+					return err
+				}
+
+			case "warn_response_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(d.Val())
+				if err != nil {
+					return d.Errf("invalid warn_response_size: %v", err)
+				}
+				h.WarnResponseSize = int64(size)
+
+			case "compress":
+				h.Compress = append(h.Compress, d.RemainingArgs()...)
+
+			case "query_max_rows":
+				if err := parseIntArg(d, &h.QueryMaxRows); err != nil {
+					return err
+				}
+
+			case "query_max_bytes":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				if d.Val() == "-1" {
+					h.QueryMaxBytes = -1
+					break
+				}
+				size, err := humanize.ParseBytes(d.Val())
+				if err != nil {
+					return d.Errf("invalid query_max_bytes: %v", err)
+				}
+				h.QueryMaxBytes = int64(size)
+
+			case "template_queries":
+				if err := parseBoolArg(d, &h.TemplateQueries); err != nil {
+					return err
+				}
+
+			case "stream_buffer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				if d.Val() == "-1" {
+					h.StreamBuffer = -1
+					break
+				}
+				size, err := humanize.ParseBytes(d.Val())
+				if err != nil {
+					return d.Errf("invalid stream_buffer: %v", err)
+				}
+				h.StreamBuffer = int64(size)
+
+			case "ndjson_flush_rows":
+				if err := parseIntArg(d, &h.NDJSONFlushRows); err != nil {
+					return err
+				}
+
+			case "auth_tokens":
+				for _, token := range d.RemainingArgs() {
+					// Skip empty values from {$AUTH_TOKEN:} placeholders
+					if token != "" {
+						h.AuthTokens = append(h.AuthTokens, token)
+					}
+				}
+
+			case "audit_table":
+				if d.NextArg() {
+					h.AuditTable = d.Val()
+				}
+				// No error if empty - allows {$AUDIT_TABLE:} with empty default
+
+			case "api_keys_table":
+				if d.NextArg() {
+					h.APIKeysTable = d.Val()
+				}
+				// No error if empty - allows {$API_KEYS_TABLE:} with empty default
+
+			case "health_auth":
+				if err := parseBoolArg(d, &h.HealthAuth); err != nil {
+					return err
+				}
+
+			case "health_allow":
+				h.HealthAllow = append(h.HealthAllow, d.RemainingArgs()...)
+
+			case "health_redact_errors":
+				if err := parseBoolArg(d, &h.HealthRedactErrors); err != nil {
+					return err
+				}
+
+			case "health_canary_id":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.HealthCanaryID = d.Val()
+
+			case "health_canary_term":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.HealthCanaryTerm = d.Val()
+
+			case "self_test_id":
+				if d.NextArg() {
+					h.SelfTestID = d.Val()
+				}
+				// No error if empty - allows {$SELF_TEST_ID:} with empty default
+
+			case "self_test_expect":
+				if d.NextArg() {
+					h.SelfTestExpect = d.Val()
+				}
+				// No error if empty - allows {$SELF_TEST_EXPECT:} with empty default
+
+			case "health_enabled":
+				if err := parseBoolArg(d, &h.HealthEnabled); err != nil {
+					return err
+				}
 
 			case "health_path":
 				if d.NextArg() {
@@ -1242,19 +3936,75 @@ func (h *HTMLFromDuckDB) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				// No error if empty - allows {$HEALTH_PATH:} with empty default
 
 			case "health_detailed":
-				if !d.NextArg() {
-					return d.ArgErr()
+				if err := parseBoolArg(d, &h.HealthDetailed); err != nil {
+					return err
 				}
-				h.HealthDetailed = d.Val() == "true"
+
+			case "openapi_enabled":
+				if err := parseBoolArg(d, &h.OpenAPIEnabled); err != nil {
+					return err
+				}
+
+			case "openapi_path":
+				if d.NextArg() {
+					h.OpenAPIPath = d.Val()
+				}
+				// No error if empty - allows {$OPENAPI_PATH:} with empty default
 
 			default:
 				return d.Errf("unrecognized subdirective: %s", d.Val())
 			}
 		}
 	}
+	h.addOverrides(set)
 	return nil
 }
 
+// generateETag returns a strong ETag derived from the MD5 hash of content.
+func generateETag(content string) string {
+	hash := md5.Sum([]byte(content))
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+// Handles the "*" wildcard and lists of ETags: "etag1", "etag2", "etag3".
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, m := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimSpace(m) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag header and, if the request's If-None-Match
+// matches it, writes a 304 Not Modified response. It reports whether the
+// 304 was written, in which case the caller must not write a body. A strong
+// ETag gets the suffix of the request's negotiated encoding, if any; the
+// plain ETag still matches, since Caddy's encode handler strips the same
+// suffix from If-None-Match.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	encoded := encodedETag(etag, encodingOf(r))
+	w.Header().Set("ETag", encoded)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); etagMatches(ifNoneMatch, encoded) || etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// endpointPath returns the request path of a handler endpoint such as
+// health_path or table_path, relative to BasePath.
+func (h *HTMLFromDuckDB) endpointPath(name string) string {
+	return h.BasePath + "/" + name
+}
+
 // sanitizeIdentifier prevents SQL injection in table/column names.
 // It only allows alphanumeric characters and underscores.
 func sanitizeIdentifier(s string) string {
@@ -1267,6 +4017,16 @@ func sanitizeIdentifier(s string) string {
 	return result.String()
 }
 
+// sanitizeQualifiedIdentifier sanitizes each part of a dotted name such as
+// "audit.events", for tables in an attached database or another schema.
+func sanitizeQualifiedIdentifier(s string) string {
+	parts := strings.Split(s, ".")
+	for i, part := range parts {
+		parts[i] = sanitizeIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
 // escapeSQLString escapes single quotes in a string for safe SQL interpolation.
 // This is needed because DuckDB table macros don't support parameterized queries.
 func escapeSQLString(s string) string {
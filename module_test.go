@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -39,8 +40,7 @@ func TestGenerateETag(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hash := md5.Sum([]byte(tt.content))
-			etag := `"` + hex.EncodeToString(hash[:]) + `"`
+			etag := generateETag(tt.content)
 			if etag != tt.want {
 				t.Errorf("generateETag() = %v, want %v", etag, tt.want)
 			}
@@ -114,23 +114,6 @@ func TestIfNoneMatchParsing(t *testing.T) {
 	}
 }
 
-// etagMatches checks if the If-None-Match header matches the given ETag.
-// This mirrors the logic in ServeHTTP for testing purposes.
-func etagMatches(ifNoneMatch, etag string) bool {
-	if ifNoneMatch == "" {
-		return false
-	}
-	if ifNoneMatch == "*" {
-		return true
-	}
-	for _, m := range strings.Split(ifNoneMatch, ",") {
-		if strings.TrimSpace(m) == etag {
-			return true
-		}
-	}
-	return false
-}
-
 func md5Hash(s string) string {
 	hash := md5.Sum([]byte(s))
 	return hex.EncodeToString(hash[:])
@@ -179,6 +162,20 @@ func TestSanitizeIdentifier(t *testing.T) {
 	}
 }
 
+func TestSanitizeQualifiedIdentifier(t *testing.T) {
+	tests := map[string]string{
+		"events":                 "events",
+		"audit.events":           "audit.events",
+		"audit.main.events":      "audit.main.events",
+		"audit.events; DROP x--": "audit.eventsDROPx",
+	}
+	for input, want := range tests {
+		if got := sanitizeQualifiedIdentifier(input); got != want {
+			t.Errorf("sanitizeQualifiedIdentifier(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
 func TestServeHTTP_ETag(t *testing.T) {
 	// Create in-memory DuckDB database with test data
 	db, err := sql.Open("duckdb", ":memory:")
@@ -307,6 +304,26 @@ func emptyNextHandler() caddyhttp.Handler {
 	})
 }
 
+// writeRecordsDB creates a DuckDB file with an html table holding records.
+func writeRecordsDB(t *testing.T, records map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR)`); err != nil {
+		t.Fatal(err)
+	}
+	for id, html := range records {
+		if _, err := db.Exec(`INSERT INTO html VALUES (?, ?)`, id, html); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
 func TestServeHTTP_IndexRouting(t *testing.T) {
 	db, err := sql.Open("duckdb", ":memory:")
 	if err != nil {
@@ -1085,6 +1102,60 @@ func TestServeHTTP_TableMacro(t *testing.T) {
 		}
 	})
 
+	t.Run("truncates output at table_max_rows", func(t *testing.T) {
+		limited := &HTMLFromDuckDB{
+			Table:        "html",
+			TableMacro:   "render_chart",
+			TablePath:    "_chart",
+			TableMaxRows: 3,
+			db:           db,
+			logger:       zap.NewNop(),
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/_chart?max_items=10", nil)
+		rec := httptest.NewRecorder()
+
+		if err := limited.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+
+		body := rec.Body.String()
+		if !strings.Contains(body, "Item 3") || strings.Contains(body, "Item 4") {
+			t.Errorf("body should contain exactly 3 rows, got %q", body)
+		}
+		if !strings.Contains(body, "… 7 more rows") {
+			t.Errorf("body should contain truncation footer, got %q", body)
+		}
+		if got := rec.Header().Get("X-Truncated"); got != "7" {
+			t.Errorf("X-Truncated = %q, want %q", got, "7")
+		}
+	})
+
+	t.Run("no truncation indicator under the limit", func(t *testing.T) {
+		limited := &HTMLFromDuckDB{
+			Table:        "html",
+			TableMacro:   "render_chart",
+			TablePath:    "_chart",
+			TableMaxRows: 3,
+			db:           db,
+			logger:       zap.NewNop(),
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/_chart?max_items=3", nil)
+		rec := httptest.NewRecorder()
+
+		if err := limited.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+
+		if strings.Contains(rec.Body.String(), "more row") {
+			t.Errorf("body should not contain truncation footer, got %q", rec.Body.String())
+		}
+		if got := rec.Header().Get("X-Truncated"); got != "" {
+			t.Errorf("X-Truncated = %q, want empty", got)
+		}
+	})
+
 	t.Run("respects base_path for table endpoint", func(t *testing.T) {
 		handlerWithBase := &HTMLFromDuckDB{
 			Table:      "html",
@@ -1270,3 +1341,129 @@ func TestServeHTTP_TableMacro_Health(t *testing.T) {
 		}
 	})
 }
+
+func TestServeHTTP_ConditionalListEndpoints(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR)`)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE OR REPLACE MACRO render_index(page := 1, base_path := '') AS TABLE
+		SELECT '<html>Index Page ' || page || '</html>' AS html;
+		CREATE OR REPLACE MACRO render_search(term := '', base_path := '') AS TABLE
+		SELECT '<ul>Results for: ' || term || '</ul>' AS html;
+		CREATE OR REPLACE MACRO render_chart(base_path := '') AS TABLE
+		SELECT 'a' AS name, 1 AS value;
+	`)
+	if err != nil {
+		t.Fatalf("failed to create macros: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		Table:         "html",
+		HTMLColumn:    "html",
+		IDColumn:      "id",
+		IndexEnabled:  true,
+		IndexMacro:    "render_index",
+		SearchEnabled: true,
+		SearchMacro:   "render_search",
+		SearchParam:   "q",
+		TableMacro:    "render_chart",
+		TablePath:     "_chart",
+		CacheControl:  "public, max-age=60",
+		db:            db,
+		logger:        zap.NewNop(),
+	}
+
+	for _, path := range []string{"/works/", "/works/?q=test", "/_chart"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			rec := httptest.NewRecorder()
+			if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+				t.Fatalf("ServeHTTP error: %v", err)
+			}
+			etag := rec.Header().Get("ETag")
+			if etag == "" {
+				t.Fatal("ETag header missing")
+			}
+			if etag != generateETag(rec.Body.String()) {
+				t.Errorf("ETag = %q, want hash of body", etag)
+			}
+
+			req2 := httptest.NewRequest(http.MethodGet, path, nil)
+			req2.Header.Set("If-None-Match", etag)
+			rec2 := httptest.NewRecorder()
+			if err := handler.ServeHTTP(rec2, req2, emptyNextHandler()); err != nil {
+				t.Fatalf("ServeHTTP error: %v", err)
+			}
+			if rec2.Code != http.StatusNotModified {
+				t.Errorf("status = %d, want %d", rec2.Code, http.StatusNotModified)
+			}
+			if rec2.Body.Len() != 0 {
+				t.Errorf("body should be empty for 304, got %d bytes", rec2.Body.Len())
+			}
+		})
+	}
+}
+
+func TestServeHTTP_NotFoundMacro(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('duckdb', '<p>DuckDB</p>'), ('caddy', '<p>Caddy</p>');
+		CREATE MACRO render_not_found(id, path) AS TABLE
+		SELECT '<p>No ' || path || '. Did you mean ' || arg_max(html.id, jaro_winkler_similarity(html.id, id)) || '?</p>' AS html
+		FROM html
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		db:               db,
+		Table:            "html",
+		HTMLColumn:       "html",
+		IDColumn:         "id",
+		NotFoundMacro:    "render_not_found",
+		NotFoundRedirect: "/missing",
+		logger:           zap.NewNop(),
+	}
+
+	t.Run("renders suggestions with 404", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/works/duckbd", nil)
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rec.Code)
+		}
+		if want := "<p>No /works/duckbd. Did you mean duckdb?</p>"; rec.Body.String() != want {
+			t.Errorf("body = %q, want %q", rec.Body.String(), want)
+		}
+	})
+
+	t.Run("falls back when the macro fails", func(t *testing.T) {
+		h := *handler
+		h.NotFoundMacro = "missing_macro"
+		req := httptest.NewRequest(http.MethodGet, "/works/nope", nil)
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/missing" {
+			t.Errorf("expected redirect fallback, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package caddyhtmlduckdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// namedQuery is a SELECT from queries_file, served at queries_path/<name>.
+type namedQuery struct {
+	name    string
+	sql     string       // with positional placeholders
+	params  []queryParam // declared parameters
+	order   []string     // parameter of each placeholder
+	formats []string     // allowed formats, or nil for all
+}
+
+// queryParam is a declared parameter of a named query.
+type queryParam struct {
+	name       string
+	typ        string
+	def        string
+	hasDefault bool
+}
+
+// namedQueryInfo describes a named query in the queries_path listing.
+type namedQueryInfo struct {
+	Name    string            `json:"name"`
+	Params  map[string]string `json:"params"`
+	Formats []string          `json:"formats,omitempty"`
+}
+
+// provisionNamedQueries reads queries_file and checks that every query is
+// a single SELECT.
+func (h *HTMLFromDuckDB) provisionNamedQueries(ctx context.Context) error {
+	if h.QueriesFile == "" {
+		if h.GraphQLPath != "" {
+			return fmt.Errorf("graphql_path requires queries_file")
+		}
+		return nil
+	}
+	if h.QueriesPath == "" {
+		h.QueriesPath = "_q"
+	}
+	queries, err := readQueriesFile(h.QueriesFile)
+	if err != nil {
+		return fmt.Errorf("queries_file: %v", err)
+	}
+	conn, err := h.database().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	h.namedQueries = make(map[string]*namedQuery, len(queries))
+	for _, q := range queries {
+		if err := validateReadOnlyQuery(ctx, conn, q.sql); err != nil {
+			return fmt.Errorf("queries_file: query %s: %v", q.name, err)
+		}
+		h.namedQueries[q.name] = q
+	}
+	return nil
+}
+
+// readQueriesFile parses a queries file: SELECT statements, each preceded
+// by annotation comments naming it and declaring its parameters and
+// formats:
+//
+//	-- name: works_by_year
+//	-- param: year INTEGER
+//	-- param: lang VARCHAR = en
+//	-- formats: json, csv
+//	SELECT title FROM works WHERE pub_year = :year AND lang = :lang;
+func readQueriesFile(path string) ([]*namedQuery, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var queries []*namedQuery
+	var current *namedQuery
+	var body strings.Builder
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		err := current.compile(strings.TrimRight(strings.TrimSpace(body.String()), ";"))
+		body.Reset()
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		annotation, value, isAnnotation := strings.Cut(strings.TrimSpace(strings.TrimPrefix(trimmed, "--")), ":")
+		isAnnotation = isAnnotation && strings.HasPrefix(trimmed, "--")
+		value = strings.TrimSpace(value)
+		switch {
+		case isAnnotation && annotation == "name":
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			if value == "" || sanitizeIdentifier(value) != value {
+				return nil, fmt.Errorf("line %d: invalid query name %q", n, value)
+			}
+			if slices.ContainsFunc(queries, func(q *namedQuery) bool { return q.name == value }) {
+				return nil, fmt.Errorf("line %d: duplicate query %s", n, value)
+			}
+			current = &namedQuery{name: value}
+			queries = append(queries, current)
+		case current == nil:
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return nil, fmt.Errorf("line %d: SQL before the first -- name: annotation", n)
+			}
+		case isAnnotation && annotation == "param":
+			p, err := parseQueryParam(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			current.params = append(current.params, p)
+		case isAnnotation && annotation == "formats":
+			for _, format := range strings.Split(value, ",") {
+				format = strings.ToLower(strings.TrimSpace(format))
+				if !slices.Contains([]string{formatJSON, formatCSV, formatText, formatHTML}, format) {
+					return nil, fmt.Errorf("line %d: unsupported format %q", n, format)
+				}
+				current.formats = append(current.formats, format)
+			}
+		default:
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no queries")
+	}
+	return queries, nil
+}
+
+// parseQueryParam parses a param annotation: <name> <type> [= <default>].
+func parseQueryParam(value string) (queryParam, error) {
+	decl, def, hasDefault := strings.Cut(value, "=")
+	fields := strings.Fields(decl)
+	if len(fields) != 2 {
+		return queryParam{}, fmt.Errorf("invalid param %q, want <name> <type> [= <default>]", value)
+	}
+	p := queryParam{name: fields[0], typ: strings.ToUpper(fields[1]), def: strings.TrimSpace(def), hasDefault: hasDefault}
+	if sanitizeIdentifier(p.name) != p.name || sanitizeIdentifier(p.typ) != p.typ {
+		return queryParam{}, fmt.Errorf("invalid param %q", value)
+	}
+	if hasDefault {
+		if err := checkParamValue(p.typ, p.def); err != nil {
+			return queryParam{}, fmt.Errorf("invalid default for %s: %v", p.name, err)
+		}
+	}
+	return p, nil
+}
+
+// compile sets the query's SQL, with each placeholder cast to the type of
+// its declared parameter.
+func (q *namedQuery) compile(body string) error {
+	if body == "" {
+		return fmt.Errorf("query %s has no SQL", q.name)
+	}
+	var undeclared string
+	q.sql, q.order = replaceNamedParams(body, func(name string) string {
+		p, ok := q.param(name)
+		if !ok {
+			undeclared = name
+			return "?"
+		}
+		return "CAST(? AS " + p.typ + ")"
+	})
+	if undeclared != "" {
+		return fmt.Errorf("query %s: undeclared parameter :%s", q.name, undeclared)
+	}
+	return nil
+}
+
+// param returns the declared parameter name.
+func (q *namedQuery) param(name string) (queryParam, bool) {
+	i := slices.IndexFunc(q.params, func(p queryParam) bool { return p.name == name })
+	if i < 0 {
+		return queryParam{}, false
+	}
+	return q.params[i], true
+}
+
+// args returns the placeholder values for a request with params, or an
+// error naming a parameter that is missing or doesn't parse as its type.
+func (q *namedQuery) args(params map[string][]string) ([]any, error) {
+	args := make([]any, len(q.order))
+	for i, name := range q.order {
+		p, _ := q.param(name)
+		values, ok := params[name]
+		switch {
+		case ok && len(values) > 0:
+			if err := checkParamValue(p.typ, values[0]); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", name, err)
+			}
+			args[i] = values[0]
+		case p.hasDefault:
+			args[i] = p.def
+		default:
+			return nil, fmt.Errorf("missing parameter %s", name)
+		}
+	}
+	return args, nil
+}
+
+// checkParamValue checks that v parses as a value of the DuckDB type typ.
+// Types without a check here are left to DuckDB's cast.
+func checkParamValue(typ, v string) error {
+	var err error
+	switch typ {
+	case "TINYINT", "SMALLINT", "INTEGER", "INT", "BIGINT":
+		_, err = strconv.ParseInt(v, 10, 64)
+	case "DOUBLE", "FLOAT", "REAL":
+		_, err = strconv.ParseFloat(v, 64)
+	case "BOOLEAN", "BOOL":
+		_, err = strconv.ParseBool(v)
+	case "DATE":
+		_, err = time.Parse(time.DateOnly, v)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", v, typ)
+	}
+	return nil
+}
+
+// serveNamedQuery runs the named query at queries_path/<name> with the
+// request's query parameters, and lists the queries at queries_path.
+func (h *HTMLFromDuckDB) serveNamedQuery(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	addVary(w, "Accept")
+	w.Header().Set("Cache-Control", "no-cache")
+	if name == "" {
+		return h.serveNamedQueryList(w)
+	}
+	q, ok := h.namedQueries[name]
+	if !ok {
+		return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("unknown query %q", name))
+	}
+	format, ok := negotiateFormat(r)
+	if !ok || (q.formats != nil && !slices.Contains(q.formats, format)) {
+		http.Error(w, "unsupported format", http.StatusNotAcceptable)
+		return nil
+	}
+	args, err := q.args(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	conn, release, err := h.requestConn(ctx, "queries")
+	if err != nil {
+		return caddyhttp.Error(http.StatusServiceUnavailable, err)
+	}
+	defer release()
+
+	start := time.Now()
+	rows, err := conn.QueryContext(ctx, tagQuery(ctx, q.sql), args...)
+	if err != nil {
+		return h.queryFailed(ctx, w, q.sql, err)
+	}
+	defer rows.Close()
+	return h.writeQueryResult(ctx, w, rows, format, q.sql, start)
+}
+
+// serveNamedQueryList lists the named queries with their parameters.
+func (h *HTMLFromDuckDB) serveNamedQueryList(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(h.namedQueryList())
+}
+
+// namedQueryList describes the named queries, sorted by name.
+func (h *HTMLFromDuckDB) namedQueryList() []namedQueryInfo {
+	list := make([]namedQueryInfo, 0, len(h.namedQueries))
+	for _, q := range h.namedQueries {
+		info := namedQueryInfo{Name: q.name, Params: make(map[string]string, len(q.params)), Formats: q.formats}
+		for _, p := range q.params {
+			info.Params[p.name] = p.typ
+		}
+		list = append(list, info)
+	}
+	slices.SortFunc(list, func(a, b namedQueryInfo) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
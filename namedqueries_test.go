@@ -0,0 +1,191 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+const testQueriesFile = `-- Reports for the editors.
+
+-- name: works_by_year
+-- param: year INTEGER
+-- param: lang VARCHAR = en
+-- formats: json, csv
+SELECT title FROM works
+WHERE pub_year = :year AND lang = :lang
+ORDER BY title;
+
+-- name: work_count
+SELECT count(*) AS n FROM works;
+`
+
+func TestReadQueriesFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "queries.sql")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	queries, err := readQueriesFile(write(testQueriesFile))
+	if err != nil {
+		t.Fatalf("readQueriesFile: %v", err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("got %d queries, want 2", len(queries))
+	}
+	q := queries[0]
+	want := "SELECT title FROM works\nWHERE pub_year = CAST(? AS INTEGER) AND lang = CAST(? AS VARCHAR)\nORDER BY title"
+	if q.name != "works_by_year" || q.sql != want || len(q.params) != 2 || len(q.formats) != 2 {
+		t.Errorf("query = %+v", q)
+	}
+	if queries[1].name != "work_count" || queries[1].sql != "SELECT count(*) AS n FROM works" || queries[1].formats != nil {
+		t.Errorf("query = %+v", queries[1])
+	}
+
+	for name, content := range map[string]string{
+		"no queries":      "-- just a comment\n",
+		"sql before name": "SELECT 1;\n-- name: a\nSELECT 2;\n",
+		"duplicate":       "-- name: a\nSELECT 1;\n-- name: a\nSELECT 2;\n",
+		"invalid name":    "-- name: a-b\nSELECT 1;\n",
+		"no sql":          "-- name: a\n-- name: b\nSELECT 1;\n",
+		"undeclared":      "-- name: a\nSELECT :x;\n",
+		"bad param":       "-- name: a\n-- param: x\nSELECT :x;\n",
+		"bad default":     "-- name: a\n-- param: x INTEGER = abc\nSELECT :x;\n",
+		"bad format":      "-- name: a\n-- formats: xml\nSELECT 1;\n",
+	} {
+		if _, err := readQueriesFile(write(content)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestNamedQueryArgs(t *testing.T) {
+	q := &namedQuery{
+		params: []queryParam{{name: "year", typ: "INTEGER"}, {name: "lang", typ: "VARCHAR", def: "en", hasDefault: true}},
+		order:  []string{"year", "lang", "year"},
+	}
+	args, err := q.args(map[string][]string{"year": {"1999"}})
+	if err != nil || len(args) != 3 || args[0] != "1999" || args[1] != "en" || args[2] != "1999" {
+		t.Errorf("args = %v, %v", args, err)
+	}
+	if _, err := q.args(nil); err == nil || !strings.Contains(err.Error(), "missing parameter year") {
+		t.Errorf("missing year: err = %v", err)
+	}
+	if _, err := q.args(map[string][]string{"year": {"last"}}); err == nil {
+		t.Error("year=last: expected error")
+	}
+}
+
+func TestServeHTTP_NamedQueries(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		CREATE TABLE works (title VARCHAR, pub_year INTEGER, lang VARCHAR);
+		INSERT INTO works VALUES ('B', 1999, 'en'), ('A', 1999, 'en'), ('C', 1999, 'sv'), ('D', 2001, 'en');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	queriesFile := filepath.Join(dir, "queries.sql")
+	if err := os.WriteFile(queriesFile, []byte(testQueriesFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &HTMLFromDuckDB{DatabasePath: path, Table: "html", QueriesFile: queriesFile}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	for _, tc := range []struct {
+		method, target string
+		status         int
+		body           string
+	}{
+		{http.MethodGet, "/_q/works_by_year?year=1999&format=csv", http.StatusOK, "title\nA\nB\n"},
+		{http.MethodGet, "/_q/works_by_year?year=1999&lang=sv&format=json", http.StatusOK, `{"title":"C"}`},
+		{http.MethodGet, "/_q/work_count?format=csv", http.StatusOK, "n\n4\n"},
+		{http.MethodGet, "/_q/", http.StatusOK, `"name":"work_count"`},
+		{http.MethodGet, "/_q/works_by_year?format=csv", http.StatusBadRequest, "missing parameter year"},
+		{http.MethodGet, "/_q/works_by_year?year=x&format=csv", http.StatusBadRequest, "not a valid INTEGER"},
+		{http.MethodGet, "/_q/works_by_year?year=1999&format=html", http.StatusNotAcceptable, ""},
+		{http.MethodPost, "/_q/work_count", http.StatusMethodNotAllowed, ""},
+	} {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil), emptyNextHandler()); err != nil {
+			t.Fatalf("%s: ServeHTTP error: %v", tc.target, err)
+		}
+		body := strings.ReplaceAll(rec.Body.String(), "\r\n", "\n")
+		if rec.Code != tc.status || !strings.Contains(body, tc.body) {
+			t.Errorf("%s %s: status %d, body %q; want %d, %q", tc.method, tc.target, rec.Code, body, tc.status, tc.body)
+		}
+	}
+	err = h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/_q/unknown", nil), emptyNextHandler())
+	if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown query: expected 404, got %v", err)
+	}
+}
+
+func TestServeHTTP_NamedQueriesInvalid(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	queriesFile := filepath.Join(dir, "queries.sql")
+	if err := os.WriteFile(queriesFile, []byte("-- name: drop\nDROP TABLE html;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := &HTMLFromDuckDB{DatabasePath: path, Table: "html", QueriesFile: queriesFile}
+	err = h.Provision(ctx)
+	if err == nil {
+		h.Cleanup()
+		t.Fatal("Provision: expected error for a non-SELECT query")
+	}
+	if !strings.Contains(err.Error(), "query drop") {
+		t.Errorf("Provision error = %v", err)
+	}
+}
+
+func TestParseQueriesFile(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		queries_file /etc/caddy/queries.sql
+		queries_path reports
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.QueriesFile != "/etc/caddy/queries.sql" || h.QueriesPath != "reports" {
+		t.Errorf("QueriesFile = %q, QueriesPath = %q", h.QueriesFile, h.QueriesPath)
+	}
+}
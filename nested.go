@@ -0,0 +1,182 @@
+package caddyhtmlduckdb
+
+import (
+	"slices"
+	"strings"
+
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// The driver scans LIST and ARRAY values as []any, STRUCTs as
+// map[string]any, MAPs as duckdb.OrderedMap and UNIONs as duckdb.Union, and
+// the elements lose their DuckDB types on the way: a UUID in a list is just
+// 16 bytes, and a struct's fields come back unordered. The helpers below
+// recover the element types from the column's type name, e.g.
+// STRUCT("id" UUID, "tags" VARCHAR[]), so nested values are converted like
+// top-level ones.
+
+// listElemType returns the element type of a LIST or ARRAY type name
+// (INTEGER for INTEGER[] or INTEGER[3]), or "" if typeName isn't one.
+func listElemType(typeName string) string {
+	if !strings.HasSuffix(typeName, "]") {
+		return ""
+	}
+	if i := strings.LastIndexByte(typeName, '['); i > 0 {
+		return typeName[:i]
+	}
+	return ""
+}
+
+// structFields returns the field names and types of a STRUCT or UNION type
+// name, in declaration order.
+func structFields(typeName string) (names, types []string) {
+	args, ok := typeArgs(typeName, "STRUCT")
+	if !ok {
+		if args, ok = typeArgs(typeName, "UNION"); !ok {
+			return nil, nil
+		}
+	}
+	for _, arg := range args {
+		name, typ := splitField(arg)
+		names = append(names, name)
+		types = append(types, typ)
+	}
+	return names, types
+}
+
+// mapTypes returns the key and value types of a MAP type name.
+func mapTypes(typeName string) (key, value string) {
+	if args, ok := typeArgs(typeName, "MAP"); ok && len(args) == 2 {
+		return args[0], args[1]
+	}
+	return "", ""
+}
+
+// fieldType returns the type of the field or union member called name.
+func fieldType(typeName, name string) string {
+	names, types := structFields(typeName)
+	if i := slices.Index(names, name); i >= 0 {
+		return types[i]
+	}
+	return ""
+}
+
+// typeArgs splits the parenthesized arguments of a type name such as
+// MAP(VARCHAR, INTEGER[]) at its top-level commas.
+func typeArgs(typeName, kind string) ([]string, bool) {
+	inner, ok := strings.CutPrefix(typeName, kind+"(")
+	if !ok || !strings.HasSuffix(inner, ")") {
+		return nil, false
+	}
+	inner = inner[:len(inner)-1]
+
+	var args []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(inner[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(inner[start:])), true
+}
+
+// splitField splits a struct field declaration, "name" TYPE or name TYPE,
+// into its unquoted name and its type.
+func splitField(field string) (name, typ string) {
+	if strings.HasPrefix(field, `"`) {
+		for i := 1; i < len(field); i++ {
+			if field[i] != '"' {
+				continue
+			}
+			if i+1 < len(field) && field[i+1] == '"' {
+				i++
+				continue
+			}
+			return strings.ReplaceAll(field[1:i], `""`, `"`), strings.TrimSpace(field[i+1:])
+		}
+	}
+	name, typ, _ = strings.Cut(field, " ")
+	return name, strings.TrimSpace(typ)
+}
+
+// appendNested appends a LIST, STRUCT, MAP or UNION value the way DuckDB
+// shows it: [1, 2, NULL], {'name': value}, {key=value}, and a union as its
+// member's value. Elements are formatted like cells, but without thousands
+// separators, which would be confused with the element separators.
+func (f *TableFormat) appendNested(dst []byte, v any, typeName string) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(dst, "NULL"...)
+	case []any:
+		elem := listElemType(typeName)
+		dst = append(dst, '[')
+		for i, e := range x {
+			if i > 0 {
+				dst = append(dst, ", "...)
+			}
+			dst = f.appendNested(dst, e, elem)
+		}
+		return append(dst, ']')
+	case map[string]any:
+		names, types := structFields(typeName)
+		if len(names) != len(x) {
+			names, types = sortedKeys(x), nil
+		}
+		dst = append(dst, '{')
+		for i, name := range names {
+			if i > 0 {
+				dst = append(dst, ", "...)
+			}
+			dst = append(dst, '\'')
+			dst = append(dst, name...)
+			dst = append(dst, "': "...)
+			var typ string
+			if types != nil {
+				typ = types[i]
+			}
+			dst = f.appendNested(dst, x[name], typ)
+		}
+		return append(dst, '}')
+	case duckdb.OrderedMap:
+		keyType, valueType := mapTypes(typeName)
+		keys, values := x.Keys(), x.Values()
+		dst = append(dst, '{')
+		for i := range keys {
+			if i > 0 {
+				dst = append(dst, ", "...)
+			}
+			dst = f.appendNested(dst, keys[i], keyType)
+			dst = append(dst, '=')
+			dst = f.appendNested(dst, values[i], valueType)
+		}
+		return append(dst, '}')
+	case duckdb.Union:
+		return f.appendNested(dst, x.Value, fieldType(typeName, x.Tag))
+	default:
+		if f.ThousandsSeparator == "" {
+			return f.appendCell(dst, v, typeName)
+		}
+		inner := *f
+		inner.ThousandsSeparator = ""
+		return inner.appendCell(dst, v, typeName)
+	}
+}
+
+// sortedKeys returns the keys of a struct scanned without its type.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// nestedQuery selects one row of nested values whose elements need their
+// DuckDB type to be shown correctly.
+const nestedQuery = `SELECT
+	['a', 'b c', NULL] AS list,
+	{'x': 1, 'y': 'hi', 'z': [1.5::DECIMAL(4,2)]} AS struct,
+	MAP {'k': 1, 'j': 2} AS map,
+	['550e8400-e29b-41d4-a716-446655440000'::UUID] AS uuids,
+	union_value(num := 2)::UNION(num INTEGER, s VARCHAR) AS u,
+	[[1, 2], [3]] AS matrix,
+	{'a b': NULL, 'at': DATE '2024-01-01'} AS quoted,
+	[1000000] AS big`
+
+func TestTypeNames(t *testing.T) {
+	if got := listElemType("STRUCT(a INTEGER[])[3]"); got != "STRUCT(a INTEGER[])" {
+		t.Errorf("listElemType = %q", got)
+	}
+	if got := listElemType("VARCHAR"); got != "" {
+		t.Errorf("listElemType(VARCHAR) = %q", got)
+	}
+	names, types := structFields(`STRUCT("x" INTEGER, "a, ""b""" MAP(VARCHAR, DECIMAL(4,2)), plain UUID[])`)
+	if !slices.Equal(names, []string{"x", `a, "b"`, "plain"}) || !slices.Equal(types, []string{"INTEGER", "MAP(VARCHAR, DECIMAL(4,2))", "UUID[]"}) {
+		t.Errorf("structFields = %q %q", names, types)
+	}
+	if k, v := mapTypes("MAP(VARCHAR, STRUCT(a INTEGER, b INTEGER))"); k != "VARCHAR" || v != "STRUCT(a INTEGER, b INTEGER)" {
+		t.Errorf("mapTypes = %q, %q", k, v)
+	}
+	if got := fieldType(`UNION("num" INTEGER, "s" VARCHAR)`, "s"); got != "VARCHAR" {
+		t.Errorf("fieldType = %q", got)
+	}
+}
+
+func TestNested_Table(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	want := []string{
+		"[a, b c, NULL]",
+		"{'x': 1, 'y': hi, 'z': [1.50]}",
+		"{k=1, j=2}",
+		"[550e8400-e29b-41d4-a716-446655440000]",
+		"2",
+		"[[1, 2], [3]]",
+		"{'a b': NULL, 'at': 2024-01-01}",
+		"[1000000]",
+	}
+	got := scanCells(t, db, &TableFormat{ThousandsSeparator: ","}, nestedQuery)
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("nested cells\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestNested_JSON(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(nestedQuery)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	var buf bytes.Buffer
+	if _, err := writeJSONRows(&buf, rows, nil, -1); err != nil {
+		t.Fatalf("writeJSONRows: %v", err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d rows", len(got))
+	}
+	row, _ := json.Marshal(got[0])
+	want := `{"big":[1000000],"list":["a","b c",null],"map":{"j":2,"k":1},"matrix":[[1,2],[3]],` +
+		`"quoted":{"a b":null,"at":"2024-01-01T00:00:00Z"},"struct":{"x":1,"y":"hi","z":[1.5]},` +
+		`"u":2,"uuids":["550e8400-e29b-41d4-a716-446655440000"]}`
+	if string(row) != want {
+		t.Errorf("JSON row\ngot:  %s\nwant: %s", row, want)
+	}
+
+	rows, err = db.Query(`SELECT [1, 2] AS l, {'u': '550e8400-e29b-41d4-a716-446655440000'::UUID} AS s`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	buf.Reset()
+	if _, err := writeCSVRows(&buf, rows, nil, -1); err != nil {
+		t.Fatalf("writeCSVRows: %v", err)
+	}
+	if want := "l,s\n\"[1,2]\",\"{\"\"u\"\":\"\"550e8400-e29b-41d4-a716-446655440000\"\"}\"\n"; buf.String() != want {
+		t.Errorf("CSV\ngot:  %q\nwant: %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,65 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Modes for null_html.
+const (
+	nullHTMLError    = "error"
+	nullHTMLNotFound = "not_found"
+	nullHTMLEmpty    = "empty"
+	nullHTMLColumn   = "column"
+	nullHTMLMacro    = "macro"
+)
+
+// validateNullHTML checks the null_html mode and its fallback.
+func (h *HTMLFromDuckDB) validateNullHTML() error {
+	switch h.NullHTML {
+	case "", nullHTMLError, nullHTMLNotFound, nullHTMLEmpty:
+		if h.NullHTMLFallback != "" {
+			return fmt.Errorf("null_html %s takes no fallback", h.NullHTML)
+		}
+	case nullHTMLColumn, nullHTMLMacro:
+		if h.NullHTMLFallback == "" {
+			return fmt.Errorf("null_html %s requires a column or macro name", h.NullHTML)
+		}
+	default:
+		return fmt.Errorf("invalid null_html: %q (must be error, not_found, empty, column or macro)", h.NullHTML)
+	}
+	return nil
+}
+
+// nullHTML returns what to serve for a record whose content column is NULL.
+// fallback is the null_html column of the same row, if selected. An
+// sql.ErrNoRows error means the record is to be treated as not found.
+func (h *HTMLFromDuckDB) nullHTML(ctx context.Context, id string, fallback sql.NullString) (string, error) {
+	switch h.NullHTML {
+	case nullHTMLNotFound:
+		return "", sql.ErrNoRows
+	case nullHTMLEmpty:
+		return "", nil
+	case nullHTMLColumn:
+		if !fallback.Valid {
+			return "", sql.ErrNoRows
+		}
+		return h.decodeContent(ctx, id, fallback.String), nil
+	case nullHTMLMacro:
+		query := fmt.Sprintf("SELECT html FROM %s(id := '%s')",
+			sanitizeIdentifier(h.NullHTMLFallback),
+			escapeSQLString(id))
+		var html sql.NullString
+		start := time.Now()
+		err := h.queries(ctx, "record").QueryRowContext(ctx, tagQuery(ctx, query)).Scan(&html)
+		h.observeQuery(ctx, "null_html", query, time.Since(start))
+		if err == nil && !html.Valid {
+			err = sql.ErrNoRows
+		}
+		return html.String, err
+	default:
+		return "", fmt.Errorf("content of record %q is NULL", id)
+	}
+}
@@ -0,0 +1,113 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_NullHTML(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, summary VARCHAR);
+		INSERT INTO html VALUES
+			('w1', '<p>one</p>', NULL),
+			('w2', NULL, '<p>summary of two</p>'),
+			('w3', NULL, NULL);
+		CREATE MACRO render_placeholder(id) AS TABLE
+			SELECT '<p>' || id || ' is being rendered</p>' AS html WHERE id <> 'w3';
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		mode     string
+		fallback string
+		id       string
+		status   int
+		body     string
+	}{
+		{"non-null unaffected", nullHTMLNotFound, "", "w1", http.StatusOK, "<p>one</p>"},
+		{"error by default", "", "", "w2", http.StatusInternalServerError, ""},
+		{"error", nullHTMLError, "", "w2", http.StatusInternalServerError, ""},
+		{"not found", nullHTMLNotFound, "", "w2", http.StatusNotFound, ""},
+		{"empty", nullHTMLEmpty, "", "w2", http.StatusOK, ""},
+		{"column", nullHTMLColumn, "summary", "w2", http.StatusOK, "<p>summary of two</p>"},
+		{"null column", nullHTMLColumn, "summary", "w3", http.StatusNotFound, ""},
+		{"macro", nullHTMLMacro, "render_placeholder", "w2", http.StatusOK, "<p>w2 is being rendered</p>"},
+		{"macro without row", nullHTMLMacro, "render_placeholder", "w3", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTMLFromDuckDB{
+				db:               db,
+				Table:            "html",
+				IDColumn:         "id",
+				HTMLColumn:       "html",
+				NullHTML:         tt.mode,
+				NullHTMLFallback: tt.fallback,
+				logger:           zap.NewNop(),
+			}
+			rec := httptest.NewRecorder()
+			err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+tt.id, nil), emptyNextHandler())
+			status := rec.Code
+			var herr caddyhttp.HandlerError
+			if errors.As(err, &herr) {
+				status = herr.StatusCode
+			} else if err != nil {
+				t.Fatalf("ServeHTTP: %v", err)
+			}
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d (err = %v)", status, tt.status, err)
+			}
+			if status == http.StatusOK && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestProvision_InvalidNullHTML(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"unknown mode":        {Table: "html", NullHTML: "skip"},
+		"column without name": {Table: "html", NullHTML: nullHTMLColumn},
+		"fallback for empty":  {Table: "html", NullHTML: nullHTMLEmpty, NullHTMLFallback: "summary"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestParseNullHTML(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		null_html column summary_html
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.NullHTML != nullHTMLColumn || h.NullHTMLFallback != "summary_html" {
+		t.Errorf("NullHTML = %q, NullHTMLFallback = %q", h.NullHTML, h.NullHTMLFallback)
+	}
+}
@@ -0,0 +1,585 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// openAPIDocument is the subset of an OpenAPI 3 document the handler
+// generates: one path item per endpoint, keyed by lower-case method.
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components *openAPIComponents                      `json:"components,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	OperationID string                     `json:"operationId"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type    string   `json:"type,omitempty"`
+	Format  string   `json:"format,omitempty"`
+	Enum    []string `json:"enum,omitempty"`
+	Minimum *int     `json:"minimum,omitempty"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+// addOperation adds op to the document. Several features share a path and
+// method (index, search and id_param lookups all answer GET {base_path}/),
+// so operations landing on the same one are merged into a single operation
+// with the union of their parameters and responses.
+func (doc *openAPIDocument) addOperation(path, method string, op *openAPIOperation) {
+	item := doc.Paths[path]
+	if item == nil {
+		item = make(map[string]*openAPIOperation)
+		doc.Paths[path] = item
+	}
+	existing := item[method]
+	if existing == nil {
+		item[method] = op
+		return
+	}
+
+	existing.Summary += "; " + op.Summary
+	for _, p := range op.Parameters {
+		dup := false
+		for _, q := range existing.Parameters {
+			if q.Name == p.Name && q.In == p.In {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			existing.Parameters = append(existing.Parameters, p)
+		}
+	}
+	for status, resp := range op.Responses {
+		if prev, ok := existing.Responses[status]; ok {
+			for mediaType, content := range resp.Content {
+				if prev.Content == nil {
+					prev.Content = make(map[string]openAPIMediaType)
+				}
+				prev.Content[mediaType] = content
+			}
+			existing.Responses[status] = prev
+			continue
+		}
+		existing.Responses[status] = resp
+	}
+}
+
+// responses builds a response map from status/description pairs.
+func responses(pairs ...string) map[string]openAPIResponse {
+	m := make(map[string]openAPIResponse, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		m[pairs[i]] = openAPIResponse{Description: pairs[i+1]}
+	}
+	return m
+}
+
+// withContent sets the media types of a response in m.
+func withContent(m map[string]openAPIResponse, status string, schema openAPISchema, mediaTypes ...string) map[string]openAPIResponse {
+	resp := m[status]
+	resp.Content = make(map[string]openAPIMediaType, len(mediaTypes))
+	for _, mt := range mediaTypes {
+		resp.Content[mt] = openAPIMediaType{Schema: schema}
+	}
+	m[status] = resp
+	return m
+}
+
+var (
+	stringSchema = openAPISchema{Type: "string"}
+	objectSchema = openAPISchema{Type: "object"}
+	binarySchema = openAPISchema{Type: "string", Format: "binary"}
+	firstPage    = 1
+)
+
+// openAPI describes the endpoints enabled in this handler's configuration.
+// Table macro parameters are read from DuckDB's function catalog, so the
+// document follows the macro definitions in the database.
+func (h *HTMLFromDuckDB) openAPI(ctx context.Context) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:   h.Table,
+			Version: "1.0.0",
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+	}
+	base := h.BasePath + "/"
+
+	if h.IDParam != "" {
+		doc.addOperation(base, "get", &openAPIOperation{
+			Summary:     "Get a record",
+			OperationID: "getRecord",
+			Parameters: []openAPIParameter{{
+				Name: h.IDParam, In: "query", Required: !h.IndexEnabled && !h.SearchEnabled,
+				Description: "Record ID", Schema: stringSchema,
+			}},
+			Responses: withContent(responses("200", "Record page", "304", "Not modified", "404", "Record not found"),
+				"200", stringSchema, "text/html"),
+		})
+	} else {
+		doc.addOperation(base+"{id}", "get", &openAPIOperation{
+			Summary:     "Get a record",
+			OperationID: "getRecord",
+			Parameters: []openAPIParameter{{
+				Name: "id", In: "path", Required: true,
+				Description: "Record ID", Schema: stringSchema,
+			}},
+			Responses: withContent(responses("200", "Record page", "304", "Not modified", "404", "Record not found"),
+				"200", stringSchema, "text/html"),
+		})
+	}
+
+	for i, route := range h.RecordRoutes {
+		param := openAPIParameter{Name: "id", In: "path", Required: true, Description: "Record ID", Schema: stringSchema}
+		path := route.Prefix + "{id}"
+		if route.IDParam != "" {
+			param.Name, param.In = route.IDParam, "query"
+			path = route.Prefix
+		}
+		doc.addOperation(path, "get", &openAPIOperation{
+			Summary:     "Get a record rendered by " + route.Macro,
+			OperationID: "getRecordRoute" + strconv.Itoa(i+1),
+			Parameters:  []openAPIParameter{param},
+			Responses: withContent(responses("200", "Record page", "304", "Not modified", "404", "Record not found"),
+				"200", stringSchema, "text/html"),
+		})
+	}
+
+	if h.goneCondition() != "" {
+		for _, item := range doc.Paths {
+			if op := item["get"]; op != nil {
+				op.Responses["410"] = openAPIResponse{Description: "Record deleted"}
+				if h.GoneMacro != "" {
+					withContent(op.Responses, "410", stringSchema, "text/html")
+				}
+			}
+		}
+	}
+	if h.NotFoundMacro != "" {
+		for _, item := range doc.Paths {
+			if op := item["get"]; op != nil {
+				withContent(op.Responses, "404", stringSchema, "text/html")
+			}
+		}
+	}
+
+	if h.RevisionsPath != "" {
+		idParam := openAPIParameter{Name: "id", In: "path", Required: true, Description: "Record ID", Schema: stringSchema}
+		path := func(endpoint string) string { return base + "{id}/" + endpoint }
+		if h.IDParam != "" {
+			idParam.Name, idParam.In = h.IDParam, "query"
+			path = h.endpointPath
+		}
+		versionParam := func(name, desc string, required bool) openAPIParameter {
+			return openAPIParameter{Name: name, In: "query", Required: required, Description: desc,
+				Schema: openAPISchema{Type: "integer"}}
+		}
+		doc.addOperation(path(h.RevisionsPath), "get", &openAPIOperation{
+			Summary:     "List or get record revisions",
+			OperationID: "getRevisions",
+			Parameters: []openAPIParameter{idParam,
+				versionParam("version", "Revision to return instead of the list", false)},
+			Responses: withContent(responses("200", "Revision list or revision page", "304", "Not modified",
+				"400", "Invalid version", "404", "No such record or version"),
+				"200", stringSchema, "text/html"),
+		})
+		doc.addOperation(path(h.DiffPath), "get", &openAPIOperation{
+			Summary:     "Diff two record revisions",
+			OperationID: "getRevisionDiff",
+			Parameters: []openAPIParameter{idParam,
+				versionParam("from", "Old revision", true),
+				versionParam("to", "New revision", true)},
+			Responses: withContent(responses("200", "HTML diff", "304", "Not modified",
+				"400", "Invalid version", "404", "No such record or version", "422", "Revisions too large to diff"),
+				"200", stringSchema, "text/html"),
+		})
+	}
+
+	if h.FragmentPath != "" {
+		doc.addOperation(h.endpointPath(h.FragmentPath)+"/{id}", "get", &openAPIOperation{
+			Summary:     "Get a record as a bare HTML fragment",
+			OperationID: "getFragment",
+			Parameters: []openAPIParameter{{
+				Name: "id", In: "path", Required: true,
+				Description: "Record ID", Schema: stringSchema,
+			}},
+			Responses: withContent(responses("200", "Fragment", "304", "Not modified", "404", "Fragment not found"),
+				"200", stringSchema, "text/html"),
+		})
+	}
+
+	if h.ContentPath != "" {
+		doc.addOperation(h.endpointPath(h.ContentPath)+"/{hash}", "get", &openAPIOperation{
+			Summary:     "Get content by its SHA-256 hash",
+			OperationID: "getContent",
+			Parameters: []openAPIParameter{{
+				Name: "hash", In: "path", Required: true,
+				Description: "Hex SHA-256 hash of the content", Schema: stringSchema,
+			}},
+			Responses: withContent(responses("200", "Content", "304", "Not modified", "404", "No content with this hash"),
+				"200", stringSchema, "text/html"),
+		})
+	}
+
+	if h.ChangesPath != "" {
+		doc.addOperation(h.endpointPath(h.ChangesPath), "get", &openAPIOperation{
+			Summary:     "List records changed since a watermark",
+			OperationID: "getChanges",
+			Parameters: []openAPIParameter{
+				{Name: "since", In: "query", Description: "Watermark (next) from the previous response", Schema: stringSchema},
+				{Name: "limit", In: "query", Description: "Maximum number of changes",
+					Schema: openAPISchema{Type: "integer", Minimum: &firstPage}},
+				{Name: "wait", In: "query", Description: "How long to wait for a change, e.g. 30s", Schema: stringSchema},
+			},
+			Responses: withContent(responses("200", "Changes", "400", "Invalid parameter"),
+				"200", objectSchema, "application/json"),
+		})
+	}
+
+	if h.IndexEnabled {
+		doc.addOperation(base, "get", &openAPIOperation{
+			Summary:     "List records",
+			OperationID: "getIndex",
+			Parameters: []openAPIParameter{{
+				Name: "page", In: "query", Description: "Page number",
+				Schema: openAPISchema{Type: "integer", Minimum: &firstPage},
+			}},
+			Responses: withContent(responses("200", "Index page", "304", "Not modified"),
+				"200", stringSchema, "text/html"),
+		})
+	}
+
+	if h.SearchEnabled {
+		doc.addOperation(base, "get", &openAPIOperation{
+			Summary:     "Search records",
+			OperationID: "search",
+			Parameters: []openAPIParameter{{
+				Name: h.SearchParam, In: "query", Description: "Search term", Schema: stringSchema,
+			}},
+			Responses: withContent(responses("200", "Search results", "304", "Not modified"),
+				"200", stringSchema, "text/html"),
+		})
+	}
+
+	if h.TableMacro != "" {
+		params, err := h.macroParameters(ctx, h.TableMacro)
+		if err != nil {
+			h.log(ctx).Warn("failed to look up table macro parameters",
+				zap.String("macro", h.TableMacro),
+				zap.Error(err))
+		}
+		var opParams []openAPIParameter
+		for _, name := range params {
+			if name == "base_path" || h.isGridParam(name) {
+				continue
+			}
+			opParams = append(opParams, openAPIParameter{Name: name, In: "query", Schema: stringSchema})
+		}
+		if h.TableGrid {
+			opParams = append(opParams, openAPIParameter{
+				Name: "sort", In: "query", Description: "Column to sort by, before order_by", Schema: stringSchema,
+			}, openAPIParameter{
+				Name: "dir", In: "query", Description: "Direction to sort by sort in",
+				Schema: openAPISchema{Type: "string", Enum: []string{"asc", "desc"}},
+			}, openAPIParameter{
+				Name: "limit", In: "query", Description: "Rows per page, at most table_max_rows",
+				Schema: openAPISchema{Type: "integer", Minimum: new(int)},
+			}, openAPIParameter{
+				Name: "offset", In: "query", Description: "Rows to skip",
+				Schema: openAPISchema{Type: "integer", Minimum: new(int)},
+			})
+		}
+		opParams = append(opParams, openAPIParameter{
+			Name: "format", In: "query", Description: "Output format",
+			Schema: openAPISchema{Type: "string", Enum: []string{"html", formatJSON, formatCSV, formatNDJSON, "arrow", "parquet", "svg", "xlsx"}},
+		}, openAPIParameter{
+			Name: "columns", In: "query", Description: "Comma-separated columns to return, in order", Schema: stringSchema,
+		}, openAPIParameter{
+			Name: "order_by", In: "query", Description: "Comma-separated columns to sort by, prefixed with - for descending", Schema: stringSchema,
+		}, openAPIParameter{
+			Name: "chart", In: "query", Description: "Chart drawn with format=svg",
+			Schema: openAPISchema{Type: "string", Enum: chartKinds},
+		})
+		resp := responses("200", "Macro result", "304", "Not modified", "400", "Unsupported format or unknown column",
+			"406", "Format not available in this build")
+		resp = withContent(resp, "200", stringSchema, "text/html")
+		resp["200"].Content[formatContentTypes[formatJSON]] = openAPIMediaType{Schema: openAPISchema{Type: "array"}}
+		resp["200"].Content[formatContentTypes[formatCSV]] = openAPIMediaType{Schema: stringSchema}
+		resp["200"].Content[formatContentTypes[formatNDJSON]] = openAPIMediaType{Schema: stringSchema}
+		resp["200"].Content["application/vnd.apache.arrow.stream"] = openAPIMediaType{Schema: binarySchema}
+		resp["200"].Content["application/vnd.apache.parquet"] = openAPIMediaType{Schema: binarySchema}
+		resp["200"].Content["image/svg+xml"] = openAPIMediaType{Schema: stringSchema}
+		resp["200"].Content[xlsxContentType] = openAPIMediaType{Schema: binarySchema}
+		doc.addOperation(h.endpointPath(h.TablePath), "get", &openAPIOperation{
+			Summary:     "Render the " + h.TableMacro + " table macro",
+			OperationID: "getTable",
+			Parameters:  opParams,
+			Responses:   resp,
+		})
+	}
+
+	if h.GeoJSONMacro != "" {
+		params, err := h.macroParameters(ctx, h.GeoJSONMacro)
+		if err != nil {
+			h.log(ctx).Warn("failed to look up geojson macro parameters",
+				zap.String("macro", h.GeoJSONMacro),
+				zap.Error(err))
+		}
+		opParams := []openAPIParameter{{
+			Name: "bbox", In: "query", Description: "Bounding box: min_x,min_y,max_x,max_y", Schema: stringSchema,
+		}}
+		for _, name := range params {
+			if !slices.Contains(bboxParams, name) {
+				opParams = append(opParams, openAPIParameter{Name: name, In: "query", Schema: stringSchema})
+			}
+		}
+		doc.addOperation(h.endpointPath(h.GeoJSONPath), "get", &openAPIOperation{
+			Summary:     "Get the " + h.GeoJSONMacro + " macro as GeoJSON",
+			OperationID: "getGeoJSON",
+			Parameters:  opParams,
+			Responses: withContent(responses("200", "FeatureCollection", "400", "Invalid bbox"),
+				"200", objectSchema, "application/geo+json"),
+		})
+	}
+
+	if h.TileMacro != "" {
+		coord := func(name string) openAPIParameter {
+			return openAPIParameter{Name: name, In: "path", Required: true, Schema: openAPISchema{Type: "integer", Minimum: new(int)}}
+		}
+		resp := responses("200", "Tile", "204", "Empty tile", "304", "Not modified", "400", "Invalid tile")
+		resp = withContent(resp, "200", binarySchema, tileFormats["mvt"])
+		resp["200"].Content[tileFormats["geojson"]] = openAPIMediaType{Schema: objectSchema}
+		doc.addOperation(h.endpointPath(h.TilePath)+"/{z}/{x}/{y}.{format}", "get", &openAPIOperation{
+			Summary:     "Get a tile of the " + h.TileMacro + " macro",
+			OperationID: "getTile",
+			Parameters: []openAPIParameter{coord("z"), coord("x"), coord("y"), {
+				Name: "format", In: "path", Required: true,
+				Schema: openAPISchema{Type: "string", Enum: []string{"mvt", "geojson"}},
+			}},
+			Responses: resp,
+		})
+	}
+
+	if h.APIPath != "" {
+		api := h.endpointPath(h.APIPath)
+		doc.addOperation(api, "get", &openAPIOperation{
+			Summary:     "List records as JSON:API",
+			OperationID: "listResources",
+			Parameters: []openAPIParameter{{
+				Name: "page", In: "query", Description: "Page number",
+				Schema: openAPISchema{Type: "integer", Minimum: &firstPage},
+			}},
+			Responses: withContent(responses("200", "Page of records", "304", "Not modified",
+				"400", "Invalid page", "404", "Page out of range"),
+				"200", objectSchema, apiMediaType),
+		})
+		doc.addOperation(api+"/{id}", "get", &openAPIOperation{
+			Summary:     "Get a record as JSON:API",
+			OperationID: "getResource",
+			Parameters: []openAPIParameter{{
+				Name: "id", In: "path", Required: true, Description: "Record ID", Schema: stringSchema,
+			}},
+			Responses: withContent(responses("200", "Record", "304", "Not modified", "404", "Record not found"),
+				"200", objectSchema, apiMediaType),
+		})
+	}
+
+	if h.QueryPath != "" {
+		query := h.endpointPath(h.QueryPath)
+		security := []map[string][]string{{"bearerAuth": {}}}
+		formatParam := openAPIParameter{
+			Name: "format", In: "query", Description: "Result format (overrides Accept)",
+			Schema: openAPISchema{Type: "string", Enum: []string{formatJSON, formatCSV, formatText, formatHTML}},
+		}
+		queryResponses := func() map[string]openAPIResponse {
+			resp := responses("200", "Query result", "400", "Invalid or failed query", "401", "Missing or invalid token",
+				"406", "Unsupported format", "422", "Result exceeds query_max_bytes", "503", "Query timed out")
+			resp = withContent(resp, "200", stringSchema, "text/csv", "text/plain", "text/html")
+			resp["200"].Content["application/json"] = openAPIMediaType{Schema: openAPISchema{Type: "array"}}
+			return resp
+		}
+		doc.addOperation(query, "get", &openAPIOperation{
+			Summary:     "Run a read-only SQL query",
+			OperationID: "query",
+			Parameters: []openAPIParameter{
+				{Name: "sql", In: "query", Required: true, Description: "A single SELECT statement", Schema: stringSchema},
+				formatParam,
+			},
+			Responses: queryResponses(),
+			Security:  security,
+		})
+		doc.addOperation(query, "post", &openAPIOperation{
+			Summary:     "Run a read-only SQL query",
+			OperationID: "queryPost",
+			Parameters:  []openAPIParameter{formatParam},
+			RequestBody: &openAPIRequestBody{
+				Required: true,
+				Content: map[string]openAPIMediaType{
+					"text/plain":                        {Schema: stringSchema},
+					"application/x-www-form-urlencoded": {Schema: objectSchema},
+				},
+			},
+			Responses: queryResponses(),
+			Security:  security,
+		})
+	}
+
+	if h.ExportPath != "" {
+		resp := responses("200", "Database snapshot", "400", "Invalid format or table", "401", "Missing or invalid token",
+			"404", "Table not found", "503", "Another export is running")
+		resp = withContent(resp, "200", binarySchema, "application/octet-stream", "application/zip",
+			"application/vnd.apache.parquet")
+		doc.addOperation(h.endpointPath(h.ExportPath), "get", &openAPIOperation{
+			Summary:     "Download a database snapshot",
+			OperationID: "export",
+			Parameters: []openAPIParameter{
+				{Name: "format", In: "query", Description: "Snapshot format",
+					Schema: openAPISchema{Type: "string", Enum: []string{exportDuckDB, exportArchive, exportParquet}}},
+				{Name: "table", In: "query", Description: "Table to export with format=parquet (repeatable)", Schema: stringSchema},
+			},
+			Responses: resp,
+			Security:  []map[string][]string{{"bearerAuth": {}}},
+		})
+	}
+
+	if h.ExplainPath != "" {
+		resp := responses("200", "Query profile", "400", "Invalid parameters", "401", "Missing or invalid token",
+			"404", "Index or search not enabled", "500", "Query failed", "503", "Query timed out")
+		resp = withContent(resp, "200", stringSchema, "text/plain", "application/json")
+		doc.addOperation(h.endpointPath(h.ExplainPath), "get", &openAPIOperation{
+			Summary:     "Profile the query behind a record, index page or search",
+			OperationID: "explain",
+			Parameters: []openAPIParameter{
+				{Name: "id", In: "query", Description: "Record ID", Schema: stringSchema},
+				{Name: "page", In: "query", Description: "Index page", Schema: openAPISchema{Type: "integer", Minimum: &firstPage}},
+				{Name: h.SearchParam, In: "query", Description: "Search term", Schema: stringSchema},
+				{Name: "format", In: "query", Description: "Profile format",
+					Schema: openAPISchema{Type: "string", Enum: []string{"text", "json"}}},
+			},
+			Responses: resp,
+			Security:  []map[string][]string{{"bearerAuth": {}}},
+		})
+	}
+
+	if h.QueryPath != "" || h.ExportPath != "" || h.ExplainPath != "" || h.HealthAuth {
+		doc.Components = &openAPIComponents{
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer"},
+			},
+		}
+	}
+
+	if h.HealthEnabled {
+		op := &openAPIOperation{
+			Summary:     "Health check",
+			OperationID: "getHealth",
+			Responses: withContent(responses("200", "Healthy", "503", "Unhealthy"),
+				"200", objectSchema, "application/json"),
+		}
+		if h.HealthAuth {
+			// Allowlisted clients need no token, which OpenAPI can't express.
+			op.Responses = withContent(responses("200", "Healthy", "401", "Missing or invalid bearer token", "503", "Unhealthy"),
+				"200", objectSchema, "application/json")
+			op.Security = []map[string][]string{{"bearerAuth": {}}}
+		}
+		doc.addOperation(h.endpointPath(h.HealthPath), "get", op)
+	}
+
+	return doc
+}
+
+// macroParameters returns the parameter names of a table macro, in
+// declaration order.
+func (h *HTMLFromDuckDB) macroParameters(ctx context.Context, macro string) ([]string, error) {
+	query := "SELECT parameters FROM duckdb_functions() WHERE function_name = ? AND function_type = 'table_macro' LIMIT 1"
+	var params any
+	if err := h.database().QueryRowContext(ctx, tagQuery(ctx, query), macro).Scan(&params); err != nil {
+		return nil, err
+	}
+	list, _ := params.([]any)
+	names := make([]string, 0, len(list))
+	for _, p := range list {
+		if s, ok := p.(string); ok {
+			names = append(names, s)
+		}
+	}
+	return names, nil
+}
+
+// serveOpenAPI serves the OpenAPI description of the handler's endpoints.
+func (h *HTMLFromDuckDB) serveOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	body, err := json.MarshalIndent(h.openAPI(ctx), "", "  ")
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	body = append(body, '\n')
+
+	if notModified(w, r, generateETag(string(body))) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	return err
+}
@@ -0,0 +1,166 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_OpenAPI(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`
+		CREATE TABLE works (id VARCHAR, html VARCHAR);
+		CREATE MACRO stats(year, kind := 'all', base_path := '') AS TABLE SELECT year, kind;
+	`); err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	serve := func(t *testing.T, handler *HTMLFromDuckDB, header http.Header) (*httptest.ResponseRecorder, *openAPIDocument) {
+		t.Helper()
+		req := httptest.NewRequest("GET", handler.BasePath+"/_openapi.json", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		if rec.Code != http.StatusOK {
+			return rec, nil
+		}
+		var doc openAPIDocument
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, rec.Body.String())
+		}
+		return rec, &doc
+	}
+
+	paramNames := func(op *openAPIOperation) map[string]string {
+		names := make(map[string]string)
+		for _, p := range op.Parameters {
+			names[p.Name] = p.In
+		}
+		return names
+	}
+
+	t.Run("describes configured endpoints", func(t *testing.T) {
+		handler := &HTMLFromDuckDB{
+			db:             db,
+			Table:          "works",
+			HTMLColumn:     "html",
+			IDColumn:       "id",
+			BasePath:       "/works",
+			IndexEnabled:   true,
+			SearchEnabled:  true,
+			SearchParam:    "q",
+			TableMacro:     "stats",
+			TablePath:      "_table",
+			APIPath:        "api",
+			QueryPath:      "_query",
+			AuthTokens:     []string{"secret"},
+			HealthEnabled:  true,
+			HealthPath:     "_health",
+			OpenAPIEnabled: true,
+			OpenAPIPath:    "_openapi.json",
+			logger:         zap.NewNop(),
+		}
+		rec, doc := serve(t, handler, nil)
+		if doc == nil {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if doc.OpenAPI != "3.0.3" || doc.Info.Title != "works" {
+			t.Errorf("unexpected header: %+v %+v", doc.OpenAPI, doc.Info)
+		}
+
+		for _, path := range []string{"/works/", "/works/{id}", "/works/_table", "/works/api", "/works/api/{id}", "/works/_query", "/works/_health"} {
+			if doc.Paths[path]["get"] == nil {
+				t.Errorf("missing GET %s", path)
+			}
+		}
+
+		root := paramNames(doc.Paths["/works/"]["get"])
+		if root["page"] != "query" || root["q"] != "query" {
+			t.Errorf("index and search parameters not merged: %v", root)
+		}
+
+		table := paramNames(doc.Paths["/works/_table"]["get"])
+		if table["year"] != "query" || table["kind"] != "query" || table["format"] != "query" {
+			t.Errorf("table parameters = %v", table)
+		}
+		if _, ok := table["base_path"]; ok {
+			t.Error("base_path should not be described")
+		}
+
+		query := doc.Paths["/works/_query"]
+		if query["post"] == nil || len(query["get"].Security) == 0 {
+			t.Errorf("query endpoint should describe POST and bearer security")
+		}
+		if doc.Components == nil || doc.Components.SecuritySchemes["bearerAuth"].Scheme != "bearer" {
+			t.Errorf("missing bearer security scheme")
+		}
+
+		etag := rec.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("missing ETag")
+		}
+		rec, _ = serve(t, handler, http.Header{"If-None-Match": {etag}})
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("expected 304, got %d", rec.Code)
+		}
+	})
+
+	t.Run("omits disabled endpoints", func(t *testing.T) {
+		handler := &HTMLFromDuckDB{
+			db:             db,
+			Table:          "works",
+			HTMLColumn:     "html",
+			IDColumn:       "id",
+			IDParam:        "id",
+			OpenAPIEnabled: true,
+			OpenAPIPath:    "_openapi.json",
+			logger:         zap.NewNop(),
+		}
+		_, doc := serve(t, handler, nil)
+		if doc == nil {
+			t.Fatal("expected a document")
+		}
+		if len(doc.Paths) != 1 {
+			t.Errorf("expected only the record endpoint, got %v", doc.Paths)
+		}
+		op := doc.Paths["/"]["get"]
+		if op == nil || paramNames(op)["id"] != "query" || !op.Parameters[0].Required {
+			t.Errorf("record lookup via id_param not described: %+v", op)
+		}
+		if doc.Components != nil {
+			t.Error("no security schemes expected without query_path")
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		handler := &HTMLFromDuckDB{
+			db:         db,
+			Table:      "works",
+			HTMLColumn: "html",
+			IDColumn:   "id",
+			logger:     zap.NewNop(),
+		}
+		// Without openapi_enabled the path is treated as a record ID
+		req := httptest.NewRequest("GET", "/_openapi.json", nil)
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err == nil {
+			t.Errorf("expected record lookup to fail, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Pool partitions, as reported in metrics.
+const (
+	partitionRecord    = "record"
+	partitionAnalytics = "analytics"
+)
+
+// analyticsEndpoints are the endpoints whose queries run on the analytics
+// partition when analytics_pool_size is set. They can scan whole tables,
+// while the other endpoints look up a few rows.
+var analyticsEndpoints = map[string]bool{
+	"table":    true,
+	"tile":     true,
+	"search":   true,
+	"query":    true,
+	"queries":  true,
+	"graphql":  true,
+	"export":   true,
+	"explain":  true,
+	"geojson":  true,
+	"template": true,
+}
+
+// databaseFor returns the pool the endpoint's queries should use: the
+// analytics sub-pool for heavy endpoints if the pool is partitioned,
+// otherwise the same as database().
+func (h *HTMLFromDuckDB) databaseFor(endpoint string) *sql.DB {
+	if h.AnalyticsPoolSize > 0 && analyticsEndpoints[endpoint] {
+		if pool := h.currentPool(); pool != nil {
+			return pool.analytics
+		}
+	}
+	return h.database()
+}
+
+// poolPartitions returns the handler's pools by partition name. An
+// unpartitioned pool is reported as a single record partition.
+func (h *HTMLFromDuckDB) poolPartitions() map[string]*sql.DB {
+	parts := map[string]*sql.DB{partitionRecord: h.database()}
+	if db := h.databaseFor("table"); db != parts[partitionRecord] {
+		parts[partitionAnalytics] = db
+	}
+	return parts
+}
+
+// Saturation metrics for each pool partition. A partition whose in-use
+// connections sit at its maximum, with a growing wait count, is too small
+// for its load.
+var (
+	poolConnectionsDesc = prometheus.NewDesc("caddy_html_duckdb_pool_connections",
+		"Connections in a pool partition, by state (in_use or idle).",
+		[]string{"instance", "partition", "state"}, nil)
+	poolMaxOpenDesc = prometheus.NewDesc("caddy_html_duckdb_pool_max_open_connections",
+		"Maximum open connections of a pool partition.",
+		[]string{"instance", "partition"}, nil)
+	poolWaitCountDesc = prometheus.NewDesc("caddy_html_duckdb_pool_wait_total",
+		"Queries that waited for a free connection in a pool partition.",
+		[]string{"instance", "partition"}, nil)
+	poolWaitSecondsDesc = prometheus.NewDesc("caddy_html_duckdb_pool_wait_seconds_total",
+		"Time spent waiting for a free connection in a pool partition.",
+		[]string{"instance", "partition"}, nil)
+)
+
+// describePoolMetrics sends the pool metric descriptors to ch.
+func describePoolMetrics(ch chan<- *prometheus.Desc) {
+	ch <- poolConnectionsDesc
+	ch <- poolMaxOpenDesc
+	ch <- poolWaitCountDesc
+	ch <- poolWaitSecondsDesc
+}
+
+// collectPoolMetrics sends the current stats of h's pool partitions to ch.
+func (h *HTMLFromDuckDB) collectPoolMetrics(ch chan<- prometheus.Metric, name string) {
+	for partition, db := range h.poolPartitions() {
+		stats := db.Stats()
+		ch <- prometheus.MustNewConstMetric(poolConnectionsDesc, prometheus.GaugeValue, float64(stats.InUse), name, partition, "in_use")
+		ch <- prometheus.MustNewConstMetric(poolConnectionsDesc, prometheus.GaugeValue, float64(stats.Idle), name, partition, "idle")
+		ch <- prometheus.MustNewConstMetric(poolMaxOpenDesc, prometheus.GaugeValue, float64(stats.MaxOpenConnections), name, partition)
+		ch <- prometheus.MustNewConstMetric(poolWaitCountDesc, prometheus.CounterValue, float64(stats.WaitCount), name, partition)
+		ch <- prometheus.MustNewConstMetric(poolWaitSecondsDesc, prometheus.CounterValue, stats.WaitDuration.Seconds(), name, partition)
+	}
+}
@@ -0,0 +1,120 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPoolPartitions(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readOnly := false
+	h := &HTMLFromDuckDB{
+		DatabasePath:      filepath.Join(t.TempDir(), "partitions.duckdb"),
+		Table:             "html",
+		ReadOnly:          &readOnly,
+		InitSQLFile:       writeInitSQL(t, "CREATE TABLE IF NOT EXISTS html AS SELECT 'a' AS id, '<p>a</p>' AS html"),
+		AnalyticsPoolSize: 2,
+		RecordPoolSize:    3,
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	record, analytics := h.databaseFor("record"), h.databaseFor("table")
+	if record == analytics || h.databaseFor("search") != analytics || h.databaseFor("index") != record {
+		t.Fatal("endpoints are not partitioned")
+	}
+	if got := record.Stats().MaxOpenConnections; got != 3 {
+		t.Errorf("record pool size = %d, want 3", got)
+	}
+	if got := analytics.Stats().MaxOpenConnections; got != 2 {
+		t.Errorf("analytics pool size = %d, want 2", got)
+	}
+
+	// Saturate the analytics partition; record lookups still get a
+	// connection, further analytics queries wait.
+	for range 2 {
+		conn, err := analytics.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	short, cancelShort := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancelShort()
+	var html string
+	if err := record.QueryRowContext(short, "SELECT html FROM html WHERE id = 'a'").Scan(&html); err != nil || html != "<p>a</p>" {
+		t.Errorf("record lookup = %q, %v", html, err)
+	}
+	if _, err := analytics.Conn(short); err == nil {
+		t.Error("expected the saturated analytics partition to block")
+	}
+
+	stats := h.poolStats()
+	if stats.Analytics == nil || stats.Analytics.InUse != 2 || stats.Analytics.Settings.MaxOpenConns != 2 || stats.Settings.MaxOpenConns != 3 {
+		t.Errorf("pool stats = %+v, analytics = %+v", stats, stats.Analytics)
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	h.collectPoolMetrics(ch, "partition-test")
+	close(ch)
+	var poolMetrics int
+	for m := range ch {
+		if strings.Contains(m.Desc().String(), "caddy_html_duckdb_pool_") {
+			poolMetrics++
+		}
+	}
+	if poolMetrics != 10 {
+		t.Errorf("got %d pool metrics, want 5 per partition", poolMetrics)
+	}
+}
+
+func TestPoolPartitions_Unpartitioned(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readOnly := false
+	h := &HTMLFromDuckDB{Table: "html", ReadOnly: &readOnly}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	if h.databaseFor("table") != h.database() || len(h.poolPartitions()) != 1 || h.poolStats().Analytics != nil {
+		t.Error("expected a single shared pool")
+	}
+}
+
+func TestProvision_RecordPoolSizeAlone(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &HTMLFromDuckDB{Table: "html", RecordPoolSize: 4}
+	if err := h.Provision(ctx); err == nil {
+		h.Cleanup()
+		t.Error("expected record_pool_size without analytics_pool_size to fail")
+	}
+}
+
+func TestParsePoolPartitions(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		record_pool_size 8
+		analytics_pool_size 2
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.RecordPoolSize != 8 || h.AnalyticsPoolSize != 2 {
+		t.Errorf("RecordPoolSize = %d, AnalyticsPoolSize = %d", h.RecordPoolSize, h.AnalyticsPoolSize)
+	}
+}
@@ -0,0 +1,154 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// requestInfo collects what the handler learned about a request, published
+// as placeholders for the rest of the route:
+//
+//	{duckdb.id}        record ID looked up, if any
+//	{duckdb.status}    response status
+//	{duckdb.query_ms}  time spent in DuckDB queries, in milliseconds
+//	{duckdb.cache}     "hit" or "miss" when a response cache was consulted
+type requestInfo struct {
+	id        string
+	queryTime time.Duration
+	cache     string
+
+	// requestID correlates the request's queries and log lines; logger is
+	// the handler's logger with it attached, created on first use.
+	requestID string
+	logger    *zap.Logger
+
+	// endpoint names what served the request and bytes counts the body
+	// written, for response size metrics.
+	endpoint string
+	bytes    int64
+
+	// encoding is the content coding negotiated for the response body.
+	encoding string
+
+	// tags are the response's cache tags, including those of ESI
+	// fragments.
+	tags []string
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom returns the requestInfo of the request ctx belongs to, or
+// nil outside ServeHTTP. Its methods are safe to call on nil.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+func (info *requestInfo) setID(id string) {
+	if info != nil {
+		info.id = id
+	}
+}
+
+func (info *requestInfo) setEndpoint(endpoint string) {
+	if info != nil {
+		info.endpoint = endpoint
+	}
+}
+
+func (info *requestInfo) setCache(hit bool) {
+	if info == nil {
+		return
+	}
+	if hit {
+		info.cache = "hit"
+	} else {
+		info.cache = "miss"
+	}
+}
+
+func (info *requestInfo) addQueryTime(elapsed time.Duration) {
+	if info != nil {
+		info.queryTime += elapsed
+	}
+}
+
+// setPlaceholders publishes info and status in the request's replacer.
+func (info *requestInfo) setPlaceholders(r *http.Request, status int) {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return
+	}
+	repl.Set("duckdb.id", info.id)
+	repl.Set("duckdb.status", strconv.Itoa(status))
+	repl.Set("duckdb.query_ms", strconv.FormatFloat(float64(info.queryTime.Microseconds())/1000, 'f', 3, 64))
+	repl.Set("duckdb.cache", info.cache)
+	repl.Set("duckdb.tags", strings.Join(info.tags, " "))
+}
+
+// placeholderWriter sets the placeholders just before the response status
+// goes out, so response header manipulation (header with defer) sees them.
+type placeholderWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	r           *http.Request
+	info        *requestInfo
+	wroteHeader bool
+}
+
+func (pw *placeholderWriter) WriteHeader(status int) {
+	// 1xx responses such as Early Hints are followed by the real one
+	if status >= 200 && !pw.wroteHeader {
+		pw.wroteHeader = true
+		pw.info.setPlaceholders(pw.r, status)
+	}
+	pw.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (pw *placeholderWriter) Write(b []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	n, err := pw.ResponseWriterWrapper.Write(b)
+	pw.info.bytes += int64(n)
+	return n, err
+}
+
+func (pw *placeholderWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	n, err := pw.ResponseWriterWrapper.ReadFrom(src)
+	pw.info.bytes += n
+	return n, err
+}
+
+// withPlaceholders wraps serve so the request's placeholders are set, also
+// when it fails and the response is left to Caddy's error handling.
+func withPlaceholders(w http.ResponseWriter, r *http.Request, serve func(http.ResponseWriter, *http.Request) error) error {
+	info := &requestInfo{requestID: incomingRequestID(r)}
+	r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+	pw := &placeholderWriter{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		r:                     r,
+		info:                  info,
+	}
+	err := serve(pw, r)
+	if err != nil && !pw.wroteHeader {
+		status := http.StatusInternalServerError
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) && herr.StatusCode != 0 {
+			status = herr.StatusCode
+		}
+		info.setPlaceholders(r, status)
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// headerSnoop records the {duckdb.status} placeholder as seen when the
+// status is written, like a deferred header directive would.
+type headerSnoop struct {
+	*httptest.ResponseRecorder
+	repl   *caddy.Replacer
+	status string
+}
+
+func (hs *headerSnoop) WriteHeader(code int) {
+	hs.status, _ = hs.repl.GetString("duckdb.status")
+	hs.ResponseRecorder.WriteHeader(code)
+}
+
+func TestServeHTTP_Placeholders(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('a', '<p>a</p>');
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE
+		SELECT 'index ' || page AS html`); err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:           db,
+		Table:        "html",
+		HTMLColumn:   "html",
+		IDColumn:     "id",
+		IndexEnabled: true,
+		IndexMacro:   "render_index",
+		indexCache:   newLRUCache[indexPage](indexCacheSize, time.Hour),
+		notFound:     newLRUCache[struct{}](negativeCacheSize, time.Hour),
+		logger:       zap.NewNop(),
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		id     string
+		status string
+		cache  string
+	}{
+		{"record", "/a", "a", "200", "miss"},
+		{"missing", "/b", "b", "404", "miss"},
+		{"missing cached", "/b", "b", "404", "hit"},
+		{"index", "/", "", "200", "miss"},
+		{"index cached", "/", "", "200", "hit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := caddyRequest(http.MethodGet, tt.path, tt.path)
+			repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+			w := &headerSnoop{ResponseRecorder: httptest.NewRecorder(), repl: repl}
+			h.ServeHTTP(w, r, emptyNextHandler())
+
+			for name, want := range map[string]string{
+				"duckdb.id":     tt.id,
+				"duckdb.status": tt.status,
+				"duckdb.cache":  tt.cache,
+			} {
+				if got, _ := repl.GetString(name); got != want {
+					t.Errorf("{%s} = %q, want %q", name, got, want)
+				}
+			}
+			if tt.status == "200" && w.status != "200" {
+				t.Errorf("{duckdb.status} not set before WriteHeader, got %q", w.status)
+			}
+			ms, _ := repl.GetString("duckdb.query_ms")
+			if v, err := strconv.ParseFloat(ms, 64); err != nil || v < 0 {
+				t.Errorf("{duckdb.query_ms} = %q", ms)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// pools holds the database pools shared by all handler instances in the
+// process. Handlers configured with the same database share a single *sql.DB;
+// the pool is closed when its last user is cleaned up.
+//
+// Because Caddy provisions a new config before cleaning up the old one, a
+// reload that leaves the database configuration unchanged hands the open
+// pool to the new handler instead of closing and reopening it.
+var pools = caddy.NewUsagePool()
+
+// poolConfig holds everything that determines which database a pool is
+// connected to and how its connections are initialized. It is used as the
+// key in the pools registry, so two handlers only share a pool when all
+// fields are equal.
+type poolConfig struct {
+	connStr     string
+	initSQLFile string
+	// initSQLHash is the hash of the init SQL file's content, so that editing
+	// the file and reloading the config opens a fresh pool.
+	initSQLHash string
+	limits      resourceLimits
+	// contentURL is the prefix content_url() gives hashes, if content_path
+	// is set.
+	contentURL string
+	// attach is the database file attached read-only to an in-memory
+	// primary by every connection, with attach_read_only.
+	attach string
+	// loadFrom is the database file copied into the in-memory database
+	// when it is opened, with load_into_memory.
+	loadFrom string
+	// datasets holds the JSON-encoded dataset views to create.
+	datasets string
+	// spatial loads the spatial extension, installing it if needed.
+	spatial bool
+	// settings is the pool tuning. database/sql applies it to the whole
+	// pool, so handlers that want different tuning get pools of their own.
+	settings poolSettings
+}
+
+// resourceLimits holds DuckDB settings that cap the database's footprint.
+// Empty or zero values leave DuckDB's defaults in place.
+type resourceLimits struct {
+	memoryLimit          string
+	threads              int
+	tempDirectory        string
+	maxTempDirectorySize string
+}
+
+// statements returns the SET statements that apply the limits.
+func (l resourceLimits) statements() []string {
+	var stmts []string
+	if l.memoryLimit != "" {
+		stmts = append(stmts, fmt.Sprintf("SET memory_limit = '%s'", escapeSQLString(l.memoryLimit)))
+	}
+	if l.threads > 0 {
+		stmts = append(stmts, fmt.Sprintf("SET threads = %d", l.threads))
+	}
+	if l.tempDirectory != "" {
+		stmts = append(stmts, fmt.Sprintf("SET temp_directory = '%s'", escapeSQLString(l.tempDirectory)))
+	}
+	if l.maxTempDirectorySize != "" {
+		stmts = append(stmts, fmt.Sprintf("SET max_temp_directory_size = '%s'", escapeSQLString(l.maxTempDirectorySize)))
+	}
+	return stmts
+}
+
+// poolSettings holds the connection pool tuning, applied when a pool is
+// opened.
+type poolSettings struct {
+	maxOpen     int
+	maxIdle     int
+	maxLifetime time.Duration
+	maxIdleTime time.Duration
+	// analyticsMaxOpen caps the analytics sub-pool; 0 leaves the pool
+	// unpartitioned.
+	analyticsMaxOpen int
+}
+
+// dbPool is a shared database pool stored in the pools registry.
+type dbPool struct {
+	key poolConfig
+	db  *sql.DB
+	// analytics is a second pool on the same database, so heavy queries
+	// wait for its connections instead of taking all of db's. It only
+	// opens connections when analytics_pool_size is set.
+	analytics *sql.DB
+	// views creates the dataset views and knows the snapshots they are
+	// pinned to; nil without datasets.
+	views *datasetViews
+
+	mu       sync.Mutex
+	settings poolSettings
+}
+
+// Destruct closes the pool once no handler uses it anymore.
+func (p *dbPool) Destruct() error {
+	p.analytics.Close()
+	return p.db.Close()
+}
+
+// apply sets the pool tuning.
+func (p *dbPool) apply(s poolSettings) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settings = s
+	p.db.SetMaxOpenConns(s.maxOpen)
+	p.db.SetMaxIdleConns(s.maxIdle)
+	p.db.SetConnMaxLifetime(s.maxLifetime)
+	p.db.SetConnMaxIdleTime(s.maxIdleTime)
+
+	analyticsMax := s.analyticsMaxOpen
+	if analyticsMax == 0 {
+		analyticsMax = s.maxOpen
+	}
+	p.analytics.SetMaxOpenConns(analyticsMax)
+	p.analytics.SetMaxIdleConns(s.maxIdle)
+	p.analytics.SetConnMaxLifetime(s.maxLifetime)
+	p.analytics.SetConnMaxIdleTime(s.maxIdleTime)
+}
+
+// currentSettings returns the pool tuning currently in effect.
+func (p *dbPool) currentSettings() poolSettings {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.settings
+}
+
+// acquirePool returns the shared pool for cfg with settings, opening it if
+// needed. The settings are part of the key, so a handler never changes the
+// tuning of a pool other handlers use. The returned bool reports whether an
+// existing pool was reused. Every successful call must be paired with
+// releasePool.
+func acquirePool(cfg poolConfig, settings poolSettings) (*dbPool, bool, error) {
+	cfg.settings = settings
+	val, loaded, err := pools.LoadOrNew(cfg, func() (caddy.Destructor, error) {
+		pool, err := openPool(cfg)
+		if err != nil {
+			return nil, err
+		}
+		pool.apply(settings)
+		return pool, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return val.(*dbPool), loaded, nil
+}
+
+// releasePool drops one reference to the pool, closing it when unused.
+func releasePool(p *dbPool) error {
+	_, err := pools.Delete(p.key)
+	return err
+}
+
+// hashInitSQLFile returns a hash of the init SQL file's content, or an empty
+// string if there is no file or it cannot be read. Read errors are reported
+// when the pool is opened.
+func hashInitSQLFile(path string) string {
+	if path == "" {
+		return ""
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// openPool opens a new database pool for cfg and verifies connectivity.
+func openPool(cfg poolConfig) (*dbPool, error) {
+	// Build a connector that re-runs resource limits and init SQL on every new
+	// pool connection. This ensures session-scoped settings (e.g. SET search_path)
+	// are applied even after database/sql recycles connections due to
+	// SetConnMaxLifetime.
+	initFile := cfg.initSQLFile
+	limitStmts := cfg.limits.statements()
+	contentURL := cfg.contentURL
+	var views *datasetViews
+	if cfg.datasets != "" {
+		var err error
+		if views, err = newDatasetViews(cfg.datasets); err != nil {
+			return nil, fmt.Errorf("invalid datasets: %v", err)
+		}
+	}
+	var attachStmts, loadStmts []string
+	if cfg.attach != "" {
+		attachStmts = attachStatements(cfg.attach)
+	}
+	if cfg.loadFrom != "" {
+		attachStmts, loadStmts = loadStatements(cfg.loadFrom)
+	}
+	var loadOnce sync.Mutex
+	loaded := false
+	var spatialOnce sync.Once
+	connector, err := duckdb.NewConnector(cfg.connStr, func(execer driver.ExecerContext) error {
+		ctx := context.Background()
+		// A no-op once attached; until then every new connection tries
+		// again, so a file locked by a writer is picked up once it is free.
+		for _, stmt := range attachStmts {
+			if _, execErr := execer.ExecContext(ctx, stmt, nil); execErr != nil {
+				return fmt.Errorf("attach failed: %v", execErr)
+			}
+		}
+		if len(loadStmts) > 0 {
+			loadOnce.Lock()
+			defer loadOnce.Unlock()
+			if !loaded {
+				for _, stmt := range loadStmts {
+					if _, execErr := execer.ExecContext(ctx, stmt, nil); execErr != nil {
+						return fmt.Errorf("load failed: %v", execErr)
+					}
+				}
+				loaded = true
+			}
+		}
+		for _, stmt := range limitStmts {
+			if _, execErr := execer.ExecContext(ctx, stmt, nil); execErr != nil {
+				return fmt.Errorf("resource limit failed: %v\nStatement: %s", execErr, stmt)
+			}
+		}
+		if cfg.spatial {
+			// Extensions are loaded for the whole database, so once per
+			// pool. Without it GEOMETRY columns can't be converted, but
+			// GeoJSON text still can, so failures aren't fatal.
+			spatialOnce.Do(func() {
+				if _, execErr := execer.ExecContext(ctx, "LOAD spatial", nil); execErr != nil {
+					execer.ExecContext(ctx, "INSTALL spatial", nil)
+					execer.ExecContext(ctx, "LOAD spatial", nil)
+				}
+			})
+		}
+		if contentURL != "" {
+			if _, execErr := execer.ExecContext(ctx, contentURLStatement(contentURL), nil); execErr != nil {
+				return fmt.Errorf("failed to define content_url(): %v", execErr)
+			}
+		}
+		if initFile != "" {
+			stmts, readErr := readInitSQLFile(initFile)
+			if readErr != nil {
+				return readErr
+			}
+			for _, stmt := range stmts {
+				stmt = strings.TrimSpace(stmt)
+				if stmt == "" {
+					continue
+				}
+				if _, execErr := execer.ExecContext(ctx, stmt, nil); execErr != nil {
+					return fmt.Errorf("init SQL failed: %v\nStatement: %s", execErr, truncateForLog(stmt, 200))
+				}
+			}
+		}
+		// Dataset views come last, so they can read remote files with
+		// secrets and extensions set up by the init SQL.
+		if views != nil {
+			return views.create(ctx, execer)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+
+	// Test connection (also triggers first connInitFn run). An attached
+	// file that is locked doesn't make the pool unusable: later
+	// connections attach it once the lock is released.
+	if err := db.Ping(); err != nil && !(cfg.attach != "" && isLockError(err)) {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	return &dbPool{key: cfg, db: db, analytics: sql.OpenDB(sharedConnector{connector}), views: views}, nil
+}
+
+// sharedConnector lets a second *sql.DB use a connector without closing it:
+// it hides the connector's Close, leaving that to the pool that owns it.
+type sharedConnector struct {
+	driver.Connector
+}
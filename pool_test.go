@@ -0,0 +1,295 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestProvision_SharedPool(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readOnly := false
+	dbPath := filepath.Join(t.TempDir(), "shared.db")
+
+	newHandler := func(poolSize int) *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{
+			DatabasePath:       dbPath,
+			Table:              "html",
+			ReadOnly:           &readOnly,
+			ConnectionPoolSize: poolSize,
+		}
+	}
+
+	first := newHandler(4)
+	if err := first.Provision(ctx); err != nil {
+		t.Fatalf("Provision first: %v", err)
+	}
+	second := newHandler(4)
+	second.Name = "second"
+	if err := second.Provision(ctx); err != nil {
+		t.Fatalf("Provision second: %v", err)
+	}
+
+	t.Run("handlers with equal config share one pool", func(t *testing.T) {
+		if first.db != second.db {
+			t.Error("expected both handlers to share the same *sql.DB")
+		}
+		if refs, ok := pools.References(first.pool.key); !ok || refs != 2 {
+			t.Errorf("references = %d (exists %v), want 2", refs, ok)
+		}
+	})
+
+	t.Run("handlers with different tuning get pools of their own", func(t *testing.T) {
+		other := newHandler(8)
+		other.Name = "other"
+		if err := other.Provision(ctx); err != nil {
+			t.Fatalf("Provision other: %v", err)
+		}
+		defer other.Cleanup()
+		if other.db == first.db {
+			t.Error("expected a separate pool for different tuning")
+		}
+		if got := first.pool.currentSettings().maxOpen; got != 4 {
+			t.Errorf("first pool maxOpen = %d, want 4", got)
+		}
+		if got := other.pool.currentSettings().maxOpen; got != 8 {
+			t.Errorf("other pool maxOpen = %d, want 8", got)
+		}
+	})
+
+	t.Run("cleanup keeps pool open while still referenced", func(t *testing.T) {
+		if err := first.Cleanup(); err != nil {
+			t.Fatalf("Cleanup first: %v", err)
+		}
+		if err := second.db.Ping(); err != nil {
+			t.Errorf("pool should stay open for remaining handler: %v", err)
+		}
+		if err := second.Cleanup(); err != nil {
+			t.Fatalf("Cleanup second: %v", err)
+		}
+		if _, ok := pools.References(second.pool.key); ok {
+			t.Error("pool should be removed after last cleanup")
+		}
+		if err := second.db.Ping(); err == nil {
+			t.Error("pool should be closed after last cleanup")
+		}
+	})
+}
+
+func TestResourceLimitsStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits resourceLimits
+		want   []string
+	}{
+		{
+			name:   "no limits",
+			limits: resourceLimits{},
+			want:   nil,
+		},
+		{
+			name: "all limits",
+			limits: resourceLimits{
+				memoryLimit:          "1GB",
+				threads:              2,
+				tempDirectory:        "/tmp/duck's",
+				maxTempDirectorySize: "10GB",
+			},
+			want: []string{
+				"SET memory_limit = '1GB'",
+				"SET threads = 2",
+				"SET temp_directory = '/tmp/duck''s'",
+				"SET max_temp_directory_size = '10GB'",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.limits.statements()
+			if strings.Join(got, ";") != strings.Join(tt.want, ";") {
+				t.Errorf("statements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvision_ResourceLimits(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readOnly := false
+	handler := &HTMLFromDuckDB{
+		Table:              "html",
+		ReadOnly:           &readOnly,
+		MemoryLimit:        "256MB",
+		Threads:            1,
+		ConnectionPoolSize: 2,
+	}
+	if err := handler.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer handler.Cleanup()
+
+	// Exhaust the pool so a second connection has to be opened, which must
+	// also have the limits applied.
+	conns := make([]*sql.Conn, 2)
+	for i := range conns {
+		conn, err := handler.db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("Conn: %v", err)
+		}
+		defer conn.Close()
+		conns[i] = conn
+	}
+
+	for i, conn := range conns {
+		var threads string
+		if err := conn.QueryRowContext(context.Background(), "SELECT current_setting('threads')").Scan(&threads); err != nil {
+			t.Fatalf("query threads: %v", err)
+		}
+		if threads != "1" {
+			t.Errorf("connection %d: threads = %q, want %q", i, threads, "1")
+		}
+	}
+}
+
+func TestProvision_ConnectionTuning(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readOnly := false
+	handler := &HTMLFromDuckDB{
+		Table:              "html",
+		ReadOnly:           &readOnly,
+		ConnectionPoolSize: 6,
+		MaxIdleConns:       1,
+		ConnMaxLifetime:    "10m",
+		ConnMaxIdleTime:    "30s",
+		HealthEnabled:      true,
+		HealthDetailed:     true,
+	}
+	if err := handler.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer handler.Cleanup()
+
+	if got := handler.db.Stats().MaxOpenConnections; got != 6 {
+		t.Errorf("MaxOpenConnections = %d, want 6", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/_health", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req, emptyNextHandler())
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`"max_open_conns":6`,
+		`"max_idle_conns":1`,
+		`"conn_max_lifetime":"10m0s"`,
+		`"conn_max_idle_time":"30s"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("health response should contain %s, got %q", want, body)
+		}
+	}
+
+	t.Run("rejects invalid durations", func(t *testing.T) {
+		bad := &HTMLFromDuckDB{Table: "html", ConnMaxIdleTime: "soon"}
+		if err := bad.Provision(ctx); err == nil {
+			t.Error("expected error for invalid conn_max_idle_time")
+		}
+	})
+}
+
+func TestProvision_ReloadReusesPool(t *testing.T) {
+	// Each handler is from a config of its own, as on a reload.
+	newConfig := func() caddy.Context {
+		ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "reload.db")
+	initPath := filepath.Join(dir, "init.sql")
+	if err := os.WriteFile(initPath, []byte("SET threads = 1;"), 0o644); err != nil {
+		t.Fatalf("write init SQL: %v", err)
+	}
+
+	readOnly := false
+	newHandler := func(poolSize int) *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{
+			DatabasePath:       dbPath,
+			Table:              "html",
+			ReadOnly:           &readOnly,
+			InitSQLFile:        initPath,
+			ConnectionPoolSize: poolSize,
+		}
+	}
+
+	// Simulate a reload: the new config is provisioned before the old one
+	// is cleaned up.
+	old := newHandler(4)
+	if err := old.Provision(newConfig()); err != nil {
+		t.Fatalf("Provision old: %v", err)
+	}
+	reloaded := newHandler(8)
+	if err := reloaded.Provision(newConfig()); err != nil {
+		t.Fatalf("Provision reloaded: %v", err)
+	}
+	if err := old.Cleanup(); err != nil {
+		t.Fatalf("Cleanup old: %v", err)
+	}
+	defer reloaded.Cleanup()
+
+	t.Run("changed pool tuning opens a new pool", func(t *testing.T) {
+		if reloaded.db == old.db {
+			t.Error("expected a new pool after the pool tuning changed")
+		}
+		if err := reloaded.db.Ping(); err != nil {
+			t.Errorf("new pool should be open: %v", err)
+		}
+		if err := old.db.Ping(); err == nil {
+			t.Error("old pool should be closed after cleanup")
+		}
+		if got := reloaded.db.Stats().MaxOpenConnections; got != 8 {
+			t.Errorf("MaxOpenConnections = %d, want 8 from the new config", got)
+		}
+	})
+
+	t.Run("pool survives reload with unchanged config", func(t *testing.T) {
+		again := newHandler(8)
+		if err := again.Provision(newConfig()); err != nil {
+			t.Fatalf("Provision again: %v", err)
+		}
+		defer again.Cleanup()
+		if again.db != reloaded.db {
+			t.Error("expected the reloaded handler to reuse the existing pool")
+		}
+	})
+
+	t.Run("changed init SQL opens a new pool", func(t *testing.T) {
+		if err := os.WriteFile(initPath, []byte("SET threads = 2;"), 0o644); err != nil {
+			t.Fatalf("write init SQL: %v", err)
+		}
+		changed := newHandler(8)
+		if err := changed.Provision(newConfig()); err != nil {
+			t.Fatalf("Provision changed: %v", err)
+		}
+		defer changed.Cleanup()
+
+		if changed.db == reloaded.db {
+			t.Error("expected a new pool after the init SQL file changed")
+		}
+	})
+}
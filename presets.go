@@ -0,0 +1,68 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// presets are Caddyfile subdirectives for common setups, by name. A preset
+// is expanded where it appears, so subdirectives after it override it.
+var presets = map[string]string{
+	// blog serves posts with an index and search, cached briefly so new
+	// posts show up soon.
+	"blog": `
+		index_enabled true
+		index_cache_ttl 5m
+		search_enabled true
+		cache_control "public, max-age=300"
+		negative_cache_ttl 1m
+	`,
+	// docs serves documentation pages that change with releases, with an
+	// index, search and shared fragments.
+	"docs": `
+		index_enabled true
+		index_cache_ttl 1h
+		search_enabled true
+		includes true
+		cache_control "public, max-age=3600"
+		negative_cache_ttl 5m
+	`,
+	// dataset_api serves the table as JSON for other programs, described
+	// by an OpenAPI document and watched by a health check.
+	"dataset_api": `
+		api_path _api
+		openapi_enabled true
+		health_enabled true
+		cache_control "public, max-age=60"
+		negative_cache_ttl 1m
+	`,
+}
+
+// applyPreset parses the subdirectives of the preset named by the next
+// argument into h.
+func (h *HTMLFromDuckDB) applyPreset(d *caddyfile.Dispenser) error {
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	name := d.Val()
+	body, ok := presets[name]
+	if !ok {
+		names := make([]string, 0, len(presets))
+		for n := range presets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return d.Errf("unknown preset %q (known: %s)", name, strings.Join(names, ", "))
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	tokens, err := caddyfile.Tokenize([]byte("html_from_duckdb {"+body+"}"), "preset "+name)
+	if err != nil {
+		return fmt.Errorf("preset %s: %v", name, err)
+	}
+	return h.UnmarshalCaddyfile(caddyfile.NewDispenser(tokens))
+}
@@ -0,0 +1,75 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestPresets(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		CREATE MACRO render_search(q := '') AS TABLE SELECT html FROM html WHERE html ILIKE '%' || q || '%';
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Every preset must give a working configuration.
+	for name := range presets {
+		d := caddyfile.NewTestDispenser("html_from_duckdb {\n\tpreset " + name + "\n\tdatabase_path " + path + "\n\ttable html\n}")
+		h := &HTMLFromDuckDB{}
+		if err := h.UnmarshalCaddyfile(d); err != nil {
+			t.Errorf("%s: UnmarshalCaddyfile: %v", name, err)
+			continue
+		}
+		if err := h.Provision(ctx); err != nil {
+			t.Errorf("%s: Provision: %v", name, err)
+			continue
+		}
+		if err := h.Validate(); err != nil {
+			t.Errorf("%s: Validate: %v", name, err)
+		}
+		h.Cleanup()
+	}
+}
+
+func TestPreset_Override(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		preset blog
+		table html
+		search_enabled false
+		cache_control "no-cache"
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if !h.IndexEnabled || h.IndexCacheTTL != "5m" || h.SearchEnabled || h.CacheControl != "no-cache" || h.Table != "html" {
+		t.Errorf("parsed %+v", h)
+	}
+
+	for input, want := range map[string]string{
+		"html_from_duckdb {\n\ttable html\n\tpreset blog\n}": "preset must come before other subdirectives, which override it, at Testfile:3",
+		"html_from_duckdb {\n\tpreset wiki\n}":               `unknown preset "wiki" (known: blog, dataset_api, docs), at Testfile:2`,
+		"html_from_duckdb {\n\tpreset\n}":                    "wrong argument count",
+	} {
+		err := (&HTMLFromDuckDB{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser(input))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// projectionParams are the table endpoint's query parameters that select
+// and order the macro's columns. Like format, they aren't passed to the
+// macro.
+var projectionParams = []string{"columns", "order_by"}
+
+// quoteIdentifier quotes a column name that was checked against a result
+// schema, so names that sanitizeIdentifier would mangle still work.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// projectTable applies the columns and order_by parameters to the table
+// macro call: columns=a,b,c keeps those columns in that order, and
+// order_by=a,-b (or "b desc") sorts by them. Both are checked against the
+// macro's result columns, and order_by against table_sort_columns if set,
+// so that they only ever name existing columns. With table_grid, sort=a and
+// dir=asc|desc sort by a before the order_by columns.
+func (h *HTMLFromDuckDB) projectTable(ctx context.Context, call string, params url.Values) (string, error) {
+	columns, orderBy := params.Get("columns"), params.Get("order_by")
+	var orderKeys []string
+	if sort := params.Get("sort"); h.TableGrid && sort != "" {
+		dir := params.Get("dir")
+		if dir != "" && !strings.EqualFold(dir, "asc") && !strings.EqualFold(dir, "desc") {
+			return "", caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid dir %q", dir))
+		}
+		orderKeys = append(orderKeys, strings.TrimSpace(sort+" "+dir))
+	}
+	if orderBy != "" {
+		orderKeys = append(orderKeys, strings.Split(orderBy, ",")...)
+	}
+	if columns == "" && len(orderKeys) == 0 {
+		return call, nil
+	}
+
+	rows, err := h.queries(ctx, "table").QueryContext(ctx, tagQuery(ctx, "SELECT column_name FROM (DESCRIBE "+call+")"))
+	if err != nil {
+		return "", caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+	var schema []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		schema = append(schema, name)
+	}
+	if err := rows.Err(); err != nil {
+		return "", caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	selectList := "*"
+	if columns != "" {
+		var cols []string
+		for _, name := range strings.Split(columns, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(schema, name) {
+				return "", caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("unknown column %q", name))
+			}
+			cols = append(cols, quoteIdentifier(name))
+		}
+		selectList = strings.Join(cols, ", ")
+	}
+	query := fmt.Sprintf("SELECT %s FROM (%s)", selectList, call)
+
+	if len(orderKeys) > 0 {
+		var keys []string
+		for _, key := range orderKeys {
+			name, dir, err := parseOrderKey(key)
+			if err != nil {
+				return "", caddyhttp.Error(http.StatusBadRequest, err)
+			}
+			if !slices.Contains(schema, name) {
+				return "", caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("unknown column %q", name))
+			}
+			if len(h.TableSortColumns) > 0 && !slices.Contains(h.TableSortColumns, name) {
+				return "", caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("can't order by %q", name))
+			}
+			keys = append(keys, quoteIdentifier(name)+" "+dir)
+		}
+		query += " ORDER BY " + strings.Join(keys, ", ")
+	}
+	return query, nil
+}
+
+// parseOrderKey parses one order_by key: a column name, optionally
+// prefixed with - or followed by asc or desc.
+func parseOrderKey(key string) (name, dir string, err error) {
+	key = strings.TrimSpace(key)
+	if name, ok := strings.CutPrefix(key, "-"); ok {
+		key, dir = name, "DESC"
+	} else if i := strings.LastIndexByte(key, ' '); i > 0 && (strings.EqualFold(key[i+1:], "asc") || strings.EqualFold(key[i+1:], "desc")) {
+		key, dir = key[:i], strings.ToUpper(key[i+1:])
+	} else {
+		dir = "ASC"
+	}
+	if name = strings.TrimSpace(key); name == "" {
+		return "", "", fmt.Errorf("invalid order_by key %q", key)
+	}
+	return name, dir, nil
+}
@@ -0,0 +1,95 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_TableProjection(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE MACRO wide(base_path := '') AS TABLE
+		SELECT * FROM (VALUES (1, 'b', 20, 'x'), (2, 'a', 10, 'y'), (3, 'c', 10, 'z')) t(id, name, "total value", note)
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+	h := &HTMLFromDuckDB{
+		Table:            "html",
+		TableMacro:       "wide",
+		TablePath:        "_wide",
+		TableSortColumns: []string{"name", "total value"},
+		db:               db,
+		logger:           zap.NewNop(),
+	}
+
+	for _, tt := range []struct {
+		query, want string
+	}{
+		{"format=csv&columns=name,id", "name,id\nb,1\na,2\nc,3\n"},
+		{"format=csv&order_by=name", "id,name,total value,note\n2,a,10,y\n1,b,20,x\n3,c,10,z\n"},
+		{"format=csv&columns=id&order_by=total+value,-name", "id\n3\n2\n1\n"},
+		{"format=csv&columns=note&order_by=name+DESC", "note\nz\nx\ny\n"},
+	} {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_wide?"+tt.query, nil), emptyNextHandler()); err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if rec.Body.String() != tt.want {
+			t.Errorf("%s: body = %q, want %q", tt.query, rec.Body.String(), tt.want)
+		}
+	}
+
+	for _, query := range []string{
+		"columns=secret",
+		"columns=id,name%20DROP",
+		"order_by=,name",
+		"order_by=secret",
+		"order_by=note",
+		"order_by=name+sideways",
+	} {
+		err := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/_wide?"+query, nil), emptyNextHandler())
+		if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %v", query, err)
+		}
+	}
+}
+
+func TestParseOrderKey(t *testing.T) {
+	for key, want := range map[string][2]string{
+		"name":         {"name", "ASC"},
+		" -name":       {"name", "DESC"},
+		"name desc":    {"name", "DESC"},
+		"name Asc":     {"name", "ASC"},
+		`-total value`: {"total value", "DESC"},
+	} {
+		name, dir, err := parseOrderKey(key)
+		if err != nil || name != want[0] || dir != want[1] {
+			t.Errorf("parseOrderKey(%q) = %q, %q, %v", key, name, dir, err)
+		}
+	}
+}
+
+func TestParseTableSortColumns(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		table_sort_columns year title
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if len(h.TableSortColumns) != 2 || h.TableSortColumns[1] != "title" {
+		t.Errorf("TableSortColumns = %v", h.TableSortColumns)
+	}
+}
@@ -0,0 +1,579 @@
+package caddyhtmlduckdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+	"go.uber.org/zap"
+)
+
+// maxQuerySQLSize caps the size of a submitted SQL statement.
+const maxQuerySQLSize = 64 << 10
+
+// errResultTooLarge is returned when a query result exceeds query_max_bytes.
+var errResultTooLarge = errors.New("result exceeds query_max_bytes")
+
+// Result formats for the query endpoint.
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatText = "text"
+	formatHTML = "html"
+	// formatNDJSON is only offered by the table endpoint.
+	formatNDJSON = "ndjson"
+)
+
+// formatContentTypes maps result formats to response content types.
+var formatContentTypes = map[string]string{
+	formatJSON: "application/json",
+	formatCSV:  "text/csv; charset=utf-8",
+	formatText: "text/plain; charset=utf-8",
+	formatHTML: "text/html; charset=utf-8",
+
+	formatNDJSON: "application/x-ndjson",
+}
+
+// negotiateFormat picks the result format from the format query parameter
+// or, failing that, the first supported media type in the Accept header.
+// JSON is the default.
+func negotiateFormat(r *http.Request) (string, bool) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		switch f = strings.ToLower(f); f {
+		case formatJSON, formatCSV, formatHTML:
+			return f, true
+		case formatText, "ascii", "txt":
+			return formatText, true
+		default:
+			return "", false
+		}
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json", "*/*", "application/*":
+			return formatJSON, true
+		case "text/csv":
+			return formatCSV, true
+		case "text/plain":
+			return formatText, true
+		case "text/html":
+			return formatHTML, true
+		}
+	}
+	return formatJSON, true
+}
+
+// limitWriter passes writes through until limit bytes have been written,
+// then fails with errResultTooLarge.
+type limitWriter struct {
+	w     io.Writer
+	limit int64
+	n     int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.limit > 0 && l.n+int64(len(p)) > l.limit {
+		return 0, errResultTooLarge
+	}
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
+
+// serveQuery runs a read-only SQL statement submitted by an authorized
+// client and returns the result as JSON, CSV or an ASCII table. The SQL is
+// taken from the sql query parameter (GET) or the request body (POST, raw or
+// as a sql form field).
+func (h *HTMLFromDuckDB) serveQuery(w http.ResponseWriter, r *http.Request) error {
+	if !h.authorized(r, scopeQuery) {
+		unauthorized(w)
+		return nil
+	}
+
+	addVary(w, "Accept")
+	w.Header().Set("Cache-Control", "no-store")
+
+	var query string
+	switch r.Method {
+	case http.MethodGet:
+		query = r.URL.Query().Get("sql")
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxQuerySQLSize)
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" {
+			query = r.PostFormValue("sql")
+		} else {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "failed to read SQL: "+err.Error(), http.StatusBadRequest)
+				return nil
+			}
+			query = string(body)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		http.Error(w, "missing sql", http.StatusBadRequest)
+		return nil
+	}
+	if len(query) > maxQuerySQLSize {
+		http.Error(w, "sql too long", http.StatusRequestEntityTooLarge)
+		return nil
+	}
+
+	format, ok := negotiateFormat(r)
+	if !ok {
+		http.Error(w, "unsupported format", http.StatusNotAcceptable)
+		return nil
+	}
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	conn, release, err := h.requestConn(ctx, "query")
+	if err != nil {
+		return caddyhttp.Error(http.StatusServiceUnavailable, err)
+	}
+	defer release()
+
+	if err := validateReadOnlyQuery(ctx, conn, query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	start := time.Now()
+	rows, err := conn.QueryContext(ctx, tagQuery(ctx, query))
+	if err != nil {
+		return h.queryFailed(ctx, w, query, err)
+	}
+	defer rows.Close()
+	return h.writeQueryResult(ctx, w, rows, format, query, start)
+}
+
+// writeQueryResult writes the rows of query, started at start, in format:
+// JSON, CSV or an ASCII table, capped by query_max_rows and
+// query_max_bytes.
+func (h *HTMLFromDuckDB) writeQueryResult(ctx context.Context, w http.ResponseWriter, rows *sql.Rows, format, query string, start time.Time) error {
+	// Buffer the encoded result up to stream_buffer, so a small oversized or
+	// failing result turns into a clean error instead of a cut-off body;
+	// larger results are streamed.
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	sw := newStreamWriter(w, h.StreamBuffer)
+	out := &limitWriter{w: sw, limit: h.QueryMaxBytes}
+	var omitted int
+	var err error
+	switch format {
+	case formatJSON:
+		omitted, err = writeJSONRows(out, rows, h.ValueEncoding, h.QueryMaxRows)
+	case formatCSV:
+		omitted, err = writeCSVRows(out, rows, h.ValueEncoding, h.QueryMaxRows)
+	default:
+		box := getDuckbox()
+		defer putDuckbox(box)
+		box.format = h.TableFormat
+		if err = box.scan(rows, h.QueryMaxRows); err == nil {
+			omitted = box.truncated
+			if format == formatHTML {
+				err = box.renderTo(out)
+			} else {
+				err = box.renderTextTo(out)
+			}
+		}
+	}
+	if err != nil && sw.started() {
+		// Too late for an error status
+		if clientGone(ctx) {
+			return nil
+		}
+		h.log(ctx).Warn("query stream failed",
+			zap.String("sql", truncateForLog(query, 200)),
+			zap.Int64("bytes", out.n),
+			zap.Error(err))
+		sw.abort()
+	}
+	if errors.Is(err, errResultTooLarge) {
+		http.Error(w, fmt.Sprintf("result exceeds %d bytes; add a LIMIT or select fewer columns", h.QueryMaxBytes),
+			http.StatusUnprocessableEntity)
+		return nil
+	}
+	if err != nil {
+		return h.queryFailed(ctx, w, query, err)
+	}
+
+	h.observeQuery(ctx, "query", query, time.Since(start))
+	h.log(ctx).Info("served query",
+		zap.String("sql", truncateForLog(query, 200)),
+		zap.String("format", format),
+		zap.Duration("duration", time.Since(start)),
+		zap.Int("omitted_rows", omitted),
+		zap.Bool("streamed", sw.started()))
+	return sw.finish(omitted)
+}
+
+// queryFailed reports a failed query: timeouts as 503, everything else
+// (typically a mistake in the submitted SQL) as 400 with DuckDB's message.
+// Queries canceled by a client disconnecting get no response.
+func (h *HTMLFromDuckDB) queryFailed(ctx context.Context, w http.ResponseWriter, query string, err error) error {
+	if clientGone(ctx) {
+		h.log(ctx).Debug("query canceled, client disconnected", zap.String("sql", truncateForLog(query, 200)))
+		return nil
+	}
+	h.log(ctx).Warn("query failed",
+		zap.String("sql", truncateForLog(query, 200)),
+		zap.Error(err))
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		http.Error(w, "query timed out", http.StatusServiceUnavailable)
+		return nil
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+	return nil
+}
+
+// validateReadOnlyQuery checks that query is exactly one SELECT statement
+// (including WITH ... SELECT and DuckDB's FROM-first syntax) that reads
+// only the database. DuckDB parses and prepares the statement without
+// running it, so the check can't be fooled by comments, quoting or unusual
+// syntax. The query endpoint, named queries, record_query and template
+// queries all rely on it to keep clients and content away from files and
+// URLs, see checkExternalAccess.
+func validateReadOnlyQuery(ctx context.Context, conn *sql.Conn, query string) error {
+	if len(parseSQLStatements(query)) > 1 {
+		return fmt.Errorf("only a single statement is allowed")
+	}
+	// Before preparing: binding read_csv and friends already reads the
+	// file, or fetches the URL.
+	if err := checkExternalAccess(ctx, conn, query); err != nil {
+		return err
+	}
+	return conn.Raw(func(driverConn any) error {
+		dc, ok := driverConn.(*duckdb.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		// Conn.Prepare, unlike PrepareContext, refuses multiple statements
+		// instead of executing all but the last.
+		stmt, err := dc.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		stmtType, err := stmt.(*duckdb.Stmt).StatementType()
+		if err != nil {
+			return err
+		}
+		if stmtType != duckdb.STATEMENT_TYPE_SELECT {
+			return fmt.Errorf("only SELECT statements are allowed")
+		}
+		return nil
+	})
+}
+
+// safeTableFunctions are the built-in table functions that neither read
+// files or URLs nor reveal the server's configuration.
+var safeTableFunctions = map[string]bool{
+	"range":           true,
+	"generate_series": true,
+	"unnest":          true,
+	"json_each":       true,
+	"json_tree":       true,
+}
+
+// unsafeFunctions are scalar functions revealing the server's environment
+// and settings, which may hold credentials.
+var unsafeFunctions = map[string]bool{
+	"getenv":          true,
+	"current_setting": true,
+}
+
+// checkExternalAccess walks the parse tree of query, as serialized by
+// DuckDB, and refuses anything that reaches outside the database: table
+// functions other than safeTableFunctions and the database's own table
+// macros (read_csv, read_text, glob, query, ...), tables named like files
+// or URLs, which DuckDB would read with a replacement scan, and
+// unsafeFunctions. Statements DuckDB can't serialize are refused too.
+func checkExternalAccess(ctx context.Context, conn *sql.Conn, query string) error {
+	var serialized string
+	if err := conn.QueryRowContext(ctx, "SELECT json_serialize_sql(?::VARCHAR)::VARCHAR", query).Scan(&serialized); err != nil {
+		return err
+	}
+	var tree struct {
+		Error        bool   `json:"error"`
+		ErrorType    string `json:"error_type"`
+		ErrorMessage string `json:"error_message"`
+		Statements   any    `json:"statements"`
+	}
+	if err := json.Unmarshal([]byte(serialized), &tree); err != nil {
+		return fmt.Errorf("failed to inspect query: %v", err)
+	}
+	if tree.Error {
+		if tree.ErrorType == "parser" {
+			return errors.New(tree.ErrorMessage)
+		}
+		return fmt.Errorf("only SELECT statements are allowed")
+	}
+
+	var macros map[string]bool
+	var check func(node any) error
+	check = func(node any) error {
+		switch node := node.(type) {
+		case []any:
+			for _, child := range node {
+				if err := check(child); err != nil {
+					return err
+				}
+			}
+		case map[string]any:
+			switch node["type"] {
+			case "TABLE_FUNCTION":
+				fn, _ := node["function"].(map[string]any)
+				name, _ := fn["function_name"].(string)
+				name = strings.ToLower(name)
+				if !safeTableFunctions[name] {
+					if macros == nil {
+						var err error
+						if macros, err = tableMacros(ctx, conn); err != nil {
+							return err
+						}
+					}
+					if !macros[name] {
+						return fmt.Errorf("table function %s is not allowed", name)
+					}
+				}
+			case "BASE_TABLE":
+				if name, _ := node["table_name"].(string); strings.ContainsAny(name, "./\\:") {
+					return fmt.Errorf("reading files is not allowed: %q", name)
+				}
+			}
+			if node["class"] == "FUNCTION" {
+				if name, _ := node["function_name"].(string); unsafeFunctions[strings.ToLower(name)] {
+					return fmt.Errorf("function %s is not allowed", strings.ToLower(name))
+				}
+			}
+			for _, child := range node {
+				if err := check(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return check(tree.Statements)
+}
+
+// tableMacros returns the names of the table macros defined in the
+// database, as opposed to those built into DuckDB and its extensions.
+func tableMacros(ctx context.Context, conn *sql.Conn) (map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT DISTINCT lower(function_name) FROM duckdb_functions() WHERE function_type = 'table_macro' AND NOT internal")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	macros := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		macros[name] = true
+	}
+	return macros, rows.Err()
+}
+
+// writeJSONRows writes rows as a JSON array of objects with keys in column
+// order, with values encoded as ve says. After maxRows rows (if positive)
+// the rest are only counted; the number of omitted rows is returned.
+func writeJSONRows(w io.Writer, rows *sql.Rows, ve *ValueEncoding, maxRows int) (int, error) {
+	enc, err := newJSONRowEncoder(rows, ve)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+	n, omitted := 0, 0
+	for rows.Next() {
+		if maxRows > 0 && n >= maxRows {
+			omitted++
+			continue
+		}
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteByte('\n')
+		if err := enc.encode(bw, rows); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	bw.WriteString("\n]\n")
+	return omitted, bw.Flush()
+}
+
+// writeNDJSONRows writes rows as newline-delimited JSON, one object per
+// line, calling flush after every flushRows rows so that consumers get
+// them as they are scanned. Truncation works as in writeJSONRows.
+func writeNDJSONRows(w io.Writer, rows *sql.Rows, ve *ValueEncoding, maxRows, flushRows int, flush func() error) (int, error) {
+	enc, err := newJSONRowEncoder(rows, ve)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	n, omitted := 0, 0
+	for rows.Next() {
+		if maxRows > 0 && n >= maxRows {
+			omitted++
+			continue
+		}
+		if err := enc.encode(bw, rows); err != nil {
+			return 0, err
+		}
+		bw.WriteByte('\n')
+		n++
+		if flushRows > 0 && n%flushRows == 0 {
+			if err := bw.Flush(); err != nil {
+				return 0, err
+			}
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return omitted, bw.Flush()
+}
+
+// jsonRowEncoder writes rows as JSON objects keyed by column name.
+type jsonRowEncoder struct {
+	cols     []*sql.ColumnType
+	keys     [][]byte
+	values   []any
+	ptrs     []any
+	encoding *ValueEncoding
+}
+
+func newJSONRowEncoder(rows *sql.Rows, ve *ValueEncoding) (*jsonRowEncoder, error) {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	e := &jsonRowEncoder{
+		cols:   cols,
+		keys:   make([][]byte, len(cols)),
+		values: make([]any, len(cols)),
+		ptrs:   make([]any, len(cols)),
+
+		encoding: ve,
+	}
+	for i, col := range cols {
+		name, _ := json.Marshal(col.Name())
+		e.keys[i] = append(name, ':')
+		e.ptrs[i] = &e.values[i]
+	}
+	return e, nil
+}
+
+// encode scans the current row and writes it to bw.
+func (e *jsonRowEncoder) encode(bw *bufio.Writer, rows *sql.Rows) error {
+	if err := rows.Scan(e.ptrs...); err != nil {
+		return err
+	}
+	bw.WriteByte('{')
+	for i, v := range e.values {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.Write(e.keys[i])
+		enc, err := json.Marshal(e.encoding.jsonValue(v, e.cols[i].DatabaseTypeName()))
+		if err != nil {
+			return fmt.Errorf("column %s: %v", e.cols[i].Name(), err)
+		}
+		bw.Write(enc)
+	}
+	bw.WriteByte('}')
+	return nil
+}
+
+// writeCSVRows writes rows as CSV with a header line, with fields encoded
+// as ve says (see ValueEncoding.appendCSV). Truncation works as in
+// writeJSONRows.
+func writeCSVRows(w io.Writer, rows *sql.Rows, ve *ValueEncoding, maxRows int) (int, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(cols); err != nil {
+		return 0, err
+	}
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	record := make([]string, len(cols))
+	var scratch []byte
+
+	n, omitted := 0, 0
+	for rows.Next() {
+		if maxRows > 0 && n >= maxRows {
+			omitted++
+			continue
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		for i, v := range values {
+			scratch, err = ve.appendCSV(scratch[:0], v, types[i].DatabaseTypeName())
+			if err != nil {
+				return 0, fmt.Errorf("column %s: %v", cols[i], err)
+			}
+			record[i] = string(scratch)
+		}
+		if err := cw.Write(record); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	cw.Flush()
+	return omitted, cw.Error()
+}
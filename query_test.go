@@ -0,0 +1,187 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Query(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, score DOUBLE);
+		INSERT INTO html SELECT 'r' || i, '<p>' || i || '</p>', i / 2 FROM range(1, 6) t(i);
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up table: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		Table:      "html",
+		HTMLColumn: "html",
+		IDColumn:   "id",
+		QueryPath:  "_query",
+		AuthTokens: []string{"secret"},
+		db:         db,
+		logger:     zap.NewNop(),
+	}
+
+	run := func(t *testing.T, h *HTMLFromDuckDB, sqlText, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/_query?sql="+url.QueryEscape(sqlText), nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("requires a valid token", func(t *testing.T) {
+		for _, auth := range []string{"", "Bearer wrong", "Basic secret"} {
+			req := httptest.NewRequest(http.MethodGet, "/_query?sql=SELECT+1", nil)
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			rec := httptest.NewRecorder()
+			if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+				t.Fatalf("ServeHTTP error: %v", err)
+			}
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Authorization %q: status = %d, want %d", auth, rec.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("JSON by default", func(t *testing.T) {
+		rec := run(t, handler, "SELECT id, score FROM html ORDER BY id LIMIT 2", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var got []map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+		}
+		if len(got) != 2 || got[0]["id"] != "r1" || got[0]["score"] != 0.5 {
+			t.Errorf("unexpected rows: %v", got)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("Cache-Control = %q, want no-store", cc)
+		}
+	})
+
+	t.Run("CSV and ASCII via Accept", func(t *testing.T) {
+		rec := run(t, handler, "WITH x AS (SELECT id FROM html ORDER BY id LIMIT 2) SELECT * FROM x", "text/csv")
+		if body := rec.Body.String(); body != "id\nr1\nr2\n" {
+			t.Errorf("CSV body = %q", body)
+		}
+		rec = run(t, handler, "FROM html SELECT id LIMIT 1", "text/plain")
+		if body := rec.Body.String(); strings.Contains(body, "<pre") || !strings.Contains(body, " id ") {
+			t.Errorf("ASCII body = %q", body)
+		}
+	})
+
+	t.Run("rejects non-SELECT and multiple statements", func(t *testing.T) {
+		for _, q := range []string{
+			"DELETE FROM html",
+			"CREATE TABLE x AS SELECT 1",
+			"SELECT 1; DROP TABLE html",
+			"COPY html TO 'out.csv'",
+			"ATTACH 'other.db'",
+		} {
+			rec := run(t, handler, q, "")
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%q: status = %d, want %d", q, rec.Code, http.StatusBadRequest)
+			}
+		}
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM html").Scan(&n); err != nil || n != 5 {
+			t.Errorf("table should be untouched, count = %d (%v)", n, err)
+		}
+	})
+
+	t.Run("rejects reading files and URLs", func(t *testing.T) {
+		if _, err := db.Exec(`CREATE MACRO recent(n := 2) AS TABLE SELECT id FROM html ORDER BY id LIMIT n`); err != nil {
+			t.Fatal(err)
+		}
+		for q, want := range map[string]string{
+			"SELECT * FROM read_text('/etc/passwd')":                            "table function read_text is not allowed",
+			"SELECT * FROM glob('/root/**')":                                    "table function glob is not allowed",
+			"SELECT * FROM read_csv('/etc/passwd')":                             "table function read_csv is not allowed",
+			"SELECT id FROM html WHERE id IN (FROM READ_CSV_AUTO('x.csv'))":     "table function read_csv_auto is not allowed",
+			"WITH x AS (FROM read_parquet('http://169.254.169.254/x')) FROM x":  "table function read_parquet is not allowed",
+			"SELECT * FROM query('SELECT * FROM read_text(''/etc/passwd'')')":   "table function query is not allowed",
+			"SELECT * FROM '/etc/passwd.csv'":                                   "reading files is not allowed",
+			`SELECT * FROM "data.parquet"`:                                      "reading files is not allowed",
+			"SELECT getenv('HOME')":                                             "function getenv is not allowed",
+			"SELECT 1 UNION ALL SELECT current_setting('s3_secret_access_key')": "function current_setting is not allowed",
+		} {
+			rec := run(t, handler, q, "")
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("%q: status = %d, body %q, want %q", q, rec.Code, rec.Body.String(), want)
+			}
+		}
+		// The database's own table macros and harmless table functions
+		// are fine.
+		rec := run(t, handler, "SELECT r.id FROM recent() r, range(1) ORDER BY 1", "text/csv")
+		if rec.Code != http.StatusOK || rec.Body.String() != "id\nr1\nr2\n" {
+			t.Errorf("status = %d, body %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("row and byte limits", func(t *testing.T) {
+		limited := *handler
+		limited.QueryMaxRows = 2
+		rec := run(t, &limited, "SELECT id FROM html", "text/csv")
+		if got := rec.Header().Get("X-Truncated"); got != "3" {
+			t.Errorf("X-Truncated = %q, want 3", got)
+		}
+		if strings.Count(rec.Body.String(), "\n") != 3 {
+			t.Errorf("expected header and 2 rows, got %q", rec.Body.String())
+		}
+
+		limited.QueryMaxBytes = 10
+		rec = run(t, &limited, "SELECT html FROM html", "")
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("SQL errors are reported", func(t *testing.T) {
+		rec := run(t, handler, "SELECT nope FROM html", "")
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "nope") {
+			t.Errorf("status = %d, body %q", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestProvision_QueryRequiresTokens(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readOnly := false
+	h := &HTMLFromDuckDB{Table: "html", ReadOnly: &readOnly, QueryPath: "_query"}
+	if err := h.Provision(ctx); err == nil {
+		h.Cleanup()
+		t.Error("expected error for query_path without auth_tokens")
+	}
+}
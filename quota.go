@@ -0,0 +1,197 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Quota limits how many requests a client may make to the JSON API, query
+// and table endpoints. Clients sending a bearer key from api_keys_table are
+// counted per key, others per IP address.
+type Quota struct {
+	// PerMinute is the number of requests a client may make per minute.
+	// 0 means no per-minute limit.
+	PerMinute int `json:"per_minute,omitempty"`
+
+	// PerDay is the number of requests a client may make per day (UTC).
+	// 0 means no daily limit. Optional per_minute and per_day columns in
+	// api_keys_table override both limits for a key (NULL keeps them).
+	PerDay int `json:"per_day,omitempty"`
+}
+
+// quotaLimits are the limits that apply to a client.
+type quotaLimits struct {
+	perMinute, perDay int
+}
+
+// quotaUsage counts a client's requests in the current minute and day.
+type quotaUsage struct {
+	minute, day           time.Time
+	minuteCount, dayCount int
+}
+
+// quotaState holds a handler's request counts.
+type quotaState struct {
+	mu      sync.Mutex
+	clients map[string]*quotaUsage
+	swept   time.Time
+}
+
+// quotaStatus describes the tightest window a request was counted in, for
+// the RateLimit headers.
+type quotaStatus struct {
+	limit, remaining int
+	reset            time.Duration
+}
+
+// provisionQuota checks the quota configuration and sets up its state.
+func (h *HTMLFromDuckDB) provisionQuota() error {
+	q := h.Quota
+	if q == nil {
+		return nil
+	}
+	if q.PerMinute < 0 || q.PerDay < 0 {
+		return fmt.Errorf("quota limits must not be negative")
+	}
+	if q.PerMinute == 0 && q.PerDay == 0 && h.APIKeysTable == "" {
+		return fmt.Errorf("quota requires per_minute, per_day or api_keys_table")
+	}
+	h.quota = &quotaState{clients: make(map[string]*quotaUsage)}
+	return nil
+}
+
+// checkQuota counts the request against the client's quota and sets the
+// RateLimit headers. If the request must not be served it writes the
+// response (401 for an unknown or expired API key, 429 when the quota is
+// used up) and returns false.
+func (h *HTMLFromDuckDB) checkQuota(w http.ResponseWriter, r *http.Request) bool {
+	if h.quota == nil {
+		return true
+	}
+	client := "ip:" + clientAddr(r)
+	limits := quotaLimits{perMinute: h.Quota.PerMinute, perDay: h.Quota.PerDay}
+	if token := bearerToken(r); token != "" && h.APIKeysTable != "" && !h.isAuthToken(token) {
+		hash, grant, err := h.keyGrant(r.Context(), token)
+		if err != nil {
+			// Fail open: a broken keys table shouldn't take the API down
+			h.logFailure(r.Context(), "api key lookup failed", zap.Error(err))
+		} else if !grant.usable(time.Now()) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return false
+		} else {
+			client = "key:" + hash
+			if grant.perMinute.Valid {
+				limits.perMinute = int(grant.perMinute.Int64)
+			}
+			if grant.perDay.Valid {
+				limits.perDay = int(grant.perDay.Int64)
+			}
+		}
+	}
+
+	status, ok := h.quota.take(client, limits, time.Now())
+	if status.limit > 0 {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(status.limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(status.remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(status.reset.Seconds())))
+	}
+	if !ok {
+		h.log(r.Context()).Debug("quota exceeded", zap.String("path", r.URL.Path))
+		w.Header().Set("Retry-After", strconv.Itoa(int(status.reset.Seconds())))
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// take counts a request by client at now if it is within limits, and
+// reports whether it was. Refused requests aren't counted.
+func (q *quotaState) take(client string, limits quotaLimits, now time.Time) (quotaStatus, bool) {
+	minute, day := now.Truncate(time.Minute), now.UTC().Truncate(24*time.Hour)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweep(minute, day)
+	u := q.clients[client]
+	if u == nil {
+		u = &quotaUsage{}
+		q.clients[client] = u
+	}
+	if !u.minute.Equal(minute) {
+		u.minute, u.minuteCount = minute, 0
+	}
+	if !u.day.Equal(day) {
+		u.day, u.dayCount = day, 0
+	}
+
+	ok := (limits.perMinute == 0 || u.minuteCount < limits.perMinute) &&
+		(limits.perDay == 0 || u.dayCount < limits.perDay)
+	if ok {
+		u.minuteCount++
+		u.dayCount++
+	}
+
+	// Report the window with the fewest requests left
+	var status quotaStatus
+	report := func(limit, count int, reset time.Time) {
+		if limit == 0 {
+			return
+		}
+		remaining := max(limit-count, 0)
+		if status.limit == 0 || remaining < status.remaining {
+			status = quotaStatus{limit: limit, remaining: remaining, reset: reset.Sub(now)}
+		}
+	}
+	report(limits.perMinute, u.minuteCount, minute.Add(time.Minute))
+	report(limits.perDay, u.dayCount, day.Add(24*time.Hour))
+	return status, ok
+}
+
+// sweep forgets clients that made no requests this minute or today, once a
+// minute. The caller holds q.mu.
+func (q *quotaState) sweep(minute, day time.Time) {
+	if !minute.After(q.swept) {
+		return
+	}
+	q.swept = minute
+	for client, u := range q.clients {
+		if !u.minute.Equal(minute) && !u.day.Equal(day) {
+			delete(q.clients, client)
+		}
+	}
+}
+
+// parseQuota parses a quota block:
+//
+//	quota {
+//	    per_minute <n>
+//	    per_day <n>
+//	}
+func parseQuota(d *caddyfile.Dispenser) (*Quota, error) {
+	q := &Quota{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "per_minute", "per_day":
+			name := d.Val()
+			var n int
+			if err := parseIntArg(d, &n); err != nil {
+				return nil, err
+			}
+			if name == "per_minute" {
+				q.PerMinute = n
+			} else {
+				q.PerDay = n
+			}
+
+		default:
+			return nil, d.Errf("unrecognized quota subdirective: %s", d.Val())
+		}
+	}
+	return q, nil
+}
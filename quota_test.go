@@ -0,0 +1,203 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestQuotaTake(t *testing.T) {
+	q := &quotaState{clients: make(map[string]*quotaUsage)}
+	limits := quotaLimits{perMinute: 2, perDay: 3}
+	now := time.Date(2026, 5, 1, 12, 0, 10, 0, time.UTC)
+
+	for i, want := range []bool{true, true, false} {
+		if _, ok := q.take("ip:1", limits, now); ok != want {
+			t.Errorf("request %d: ok = %v, want %v", i+1, ok, want)
+		}
+	}
+	status, _ := q.take("ip:1", limits, now)
+	if status != (quotaStatus{limit: 2, remaining: 0, reset: 50 * time.Second}) {
+		t.Errorf("status = %+v", status)
+	}
+	if _, ok := q.take("ip:2", limits, now); !ok {
+		t.Error("other clients have their own quota")
+	}
+
+	// The next minute allows one more request before the daily limit
+	now = now.Add(time.Minute)
+	status, ok := q.take("ip:1", limits, now)
+	if !ok || status.limit != 3 || status.remaining != 0 {
+		t.Errorf("next minute: ok = %v, status = %+v", ok, status)
+	}
+	if _, ok := q.take("ip:1", limits, now); ok {
+		t.Error("daily limit should apply")
+	}
+	if _, ok := q.take("ip:1", limits, now.Add(24*time.Hour)); !ok {
+		t.Error("daily limit should reset the next day")
+	}
+
+	// Idle clients are forgotten
+	q.take("ip:3", limits, now.Add(48*time.Hour))
+	if len(q.clients) != 1 {
+		t.Errorf("clients = %d, want 1", len(q.clients))
+	}
+}
+
+func TestServeHTTP_Quota(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('a', '<p>A</p>');
+		CREATE TABLE api_keys (key_hash VARCHAR, scopes VARCHAR[], expires_at TIMESTAMP, per_minute INTEGER, per_day INTEGER);
+		INSERT INTO api_keys VALUES
+			(sha256('partner'), [], NULL, 3, NULL),
+			(sha256('default'), [], NULL, NULL, NULL),
+			(sha256('former'), [], TIMESTAMP '2000-01-01', 100, NULL);
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:           db,
+		Table:        "html",
+		HTMLColumn:   "html",
+		IDColumn:     "id",
+		APIPath:      "api",
+		APIKeysTable: "api_keys",
+		AuthTokens:   []string{"static"},
+		Quota:        &Quota{PerMinute: 1},
+		apiKeys:      newLRUCache[keyGrant](apiKeyCacheSize, apiKeyCacheTTL),
+		logger:       zap.NewNop(),
+	}
+	if err := h.provisionQuota(); err != nil {
+		t.Fatal(err)
+	}
+	get := func(t *testing.T, path, remoteAddr, key string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = remoteAddr
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("per IP", func(t *testing.T) {
+		rec := get(t, "/api/a", "203.0.113.1:5000", "")
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "1" || rec.Header().Get("RateLimit-Remaining") != "0" {
+			t.Fatalf("status = %d, headers = %v", rec.Code, rec.Header())
+		}
+		rec = get(t, "/api/a", "203.0.113.1:5000", "")
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("status = %d, headers = %v", rec.Code, rec.Header())
+		}
+		if rec := get(t, "/api/a", "203.0.113.2:5000", ""); rec.Code != http.StatusOK {
+			t.Errorf("other IP: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("per key", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if rec := get(t, "/api/a", "203.0.113.1:5000", "partner"); rec.Code != http.StatusOK {
+				t.Fatalf("request %d: status = %d", i+1, rec.Code)
+			}
+		}
+		if rec := get(t, "/api/a", "203.0.113.3:5000", "partner"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want 429 across IPs", rec.Code)
+		}
+		if rec := get(t, "/api/a", "203.0.113.1:5000", "default"); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "1" {
+			t.Errorf("NULL limits: status = %d, headers = %v", rec.Code, rec.Header())
+		}
+		for _, key := range []string{"stolen", "former"} {
+			if rec := get(t, "/api/a", "203.0.113.4:5000", key); rec.Code != http.StatusUnauthorized {
+				t.Errorf("key %s: status = %d", key, rec.Code)
+			}
+		}
+		// auth_tokens aren't keys, so their clients are counted by IP
+		if rec := get(t, "/api/a", "203.0.113.5:5000", "static"); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "1" {
+			t.Errorf("auth token: status = %d, headers = %v", rec.Code, rec.Header())
+		}
+		if rec := get(t, "/api/a", "203.0.113.5:5000", "static"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("auth token: status = %d, want 429", rec.Code)
+		}
+	})
+
+	t.Run("limit columns are optional", func(t *testing.T) {
+		if _, err := db.Exec(`
+			CREATE TABLE plain_keys (key_hash VARCHAR, scopes VARCHAR[], expires_at TIMESTAMP);
+			INSERT INTO plain_keys VALUES (sha256('plain'), ['query'], NULL);
+		`); err != nil {
+			t.Fatal(err)
+		}
+		h.APIKeysTable = "plain_keys"
+		defer func() { h.APIKeysTable = "api_keys" }()
+		rec := get(t, "/api/a", "203.0.113.6:5000", "plain")
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "1" {
+			t.Errorf("status = %d, headers = %v", rec.Code, rec.Header())
+		}
+		// The same key, from another address, has used up its quota
+		if rec := get(t, "/api/a", "203.0.113.7:5000", "plain"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want 429", rec.Code)
+		}
+	})
+
+	t.Run("pages are not limited", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if rec := get(t, "/a", "203.0.113.1:5000", ""); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+		}
+	})
+}
+
+func TestProvision_QuotaErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for name, q := range map[string]*Quota{
+		"no limits":      {},
+		"negative limit": {PerMinute: -1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := &HTMLFromDuckDB{Table: "html", Quota: q}
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestParseQuota(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		quota {
+			per_minute 60
+			per_day 10000
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	want := Quota{PerMinute: 60, PerDay: 10000}
+	if h.Quota == nil || *h.Quota != want {
+		t.Errorf("Quota = %+v", h.Quota)
+	}
+}
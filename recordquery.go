@@ -0,0 +1,146 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// provisionRecordQuery rewrites record_query's named placeholders to
+// positional ones and checks that it is a single SELECT returning the
+// record columns.
+func (h *HTMLFromDuckDB) provisionRecordQuery(ctx context.Context) error {
+	if h.RecordQuery == "" {
+		return nil
+	}
+	if h.RecordMacro != "" {
+		return fmt.Errorf("record_query can't be combined with record_macro")
+	}
+	if len(h.FilterParams) > 0 {
+		return fmt.Errorf("record_query can't be combined with filter_params; use named placeholders instead")
+	}
+	query, names := bindNamedParams(h.RecordQuery)
+	if !slices.Contains(names, "id") {
+		return fmt.Errorf("record_query must use the :id placeholder")
+	}
+	conn, err := h.database().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := validateReadOnlyQuery(ctx, conn, h.wrapRecordQuery(h.recordColumns(), query)); err != nil {
+		return fmt.Errorf("invalid record_query: %v", err)
+	}
+	h.boundRecordQuery, h.recordQueryParams = query, names
+	return nil
+}
+
+// wrapRecordQuery selects columns from the rewritten record_query.
+func (h *HTMLFromDuckDB) wrapRecordQuery(columns, query string) string {
+	return fmt.Sprintf("SELECT %s FROM (%s) AS record_query", columns, query)
+}
+
+// recordQueryArgs returns the values of record_query's placeholders for a
+// lookup of id in r: the ID, the request's host (without port) and path,
+// and otherwise the query parameter of the same name. Values r doesn't
+// have are NULL.
+func (h *HTMLFromDuckDB) recordQueryArgs(id string, r *http.Request) []any {
+	args := make([]any, len(h.recordQueryParams))
+	for i, name := range h.recordQueryParams {
+		switch {
+		case name == "id":
+			args[i] = id
+		case r == nil:
+		case name == "host":
+			host := r.Host
+			if hostname, _, err := net.SplitHostPort(host); err == nil {
+				host = hostname
+			}
+			args[i] = host
+		case name == "path":
+			args[i] = r.URL.Path
+		default:
+			if params := r.URL.Query(); params.Has(name) {
+				args[i] = params.Get(name)
+			}
+		}
+	}
+	return args
+}
+
+// recordQueryKey returns the values record_query's placeholders take for a
+// lookup of id in r, which identify the lookup for the negative cache.
+func (h *HTMLFromDuckDB) recordQueryKey(id string, r *http.Request) string {
+	var key strings.Builder
+	for _, arg := range h.recordQueryArgs(id, r) {
+		fmt.Fprintf(&key, "%v\x00", arg)
+	}
+	return key.String()
+}
+
+// bindNamedParams replaces the :name placeholders in query with positional
+// ? placeholders and returns the names in order.
+func bindNamedParams(query string) (string, []string) {
+	return replaceNamedParams(query, func(string) string { return "?" })
+}
+
+// replaceNamedParams replaces the :name placeholders in query with what
+// bind returns for them and returns the names in order. Colons in string
+// literals, quoted identifiers and comments, casts (::), named arguments
+// (:=) and colons following a name or closing bracket, as in slices
+// (list[a:b]), are left as they are.
+func replaceNamedParams(query string, bind func(name string) string) (string, []string) {
+	var out strings.Builder
+	var names []string
+	isName := func(c byte) bool {
+		return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			// Doubled quotes escape themselves, so scanning to the next
+			// quote and continuing keeps us in step.
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				out.WriteString(query[i:])
+				return out.String(), names
+			}
+			out.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			out.WriteString(query[i : i+end+1])
+			i += end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				out.WriteString(query[i:])
+				return out.String(), names
+			}
+			out.WriteString(query[i : i+end+4])
+			i += end + 3
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			out.WriteString("::")
+			i++
+		case c == ':' && (i == 0 || (!isName(query[i-1]) && query[i-1] != ']' && query[i-1] != ')')) &&
+			i+1 < len(query) && isName(query[i+1]) && !(query[i+1] >= '0' && query[i+1] <= '9'):
+			j := i + 1
+			for j < len(query) && isName(query[j]) {
+				j++
+			}
+			names = append(names, query[i+1:j])
+			out.WriteString(bind(query[i+1 : j]))
+			i = j - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), names
+}
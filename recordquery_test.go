@@ -0,0 +1,123 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestBindNamedParams(t *testing.T) {
+	for _, tc := range []struct {
+		query, want string
+		names       []string
+	}{
+		{"SELECT html FROM pages WHERE id = :id", "SELECT html FROM pages WHERE id = ?", []string{"id"}},
+		{"SELECT html FROM pages WHERE id=:id AND (site = :host OR :lang IS NULL)", "SELECT html FROM pages WHERE id=? AND (site = ? OR ? IS NULL)", []string{"id", "host", "lang"}},
+		{"SELECT ':id' AS a, \":host\" FROM t WHERE x = :id -- :lang\n", "SELECT ':id' AS a, \":host\" FROM t WHERE x = ? -- :lang\n", []string{"id"}},
+		{"SELECT v::VARCHAR, l[a:b], m(x := 1) /* :x */ FROM t WHERE id = :id", "SELECT v::VARCHAR, l[a:b], m(x := 1) /* :x */ FROM t WHERE id = ?", []string{"id"}},
+		{"SELECT 'it''s :id' WHERE id = :id", "SELECT 'it''s :id' WHERE id = ?", []string{"id"}},
+	} {
+		got, names := bindNamedParams(tc.query)
+		if got != tc.want || !slices.Equal(names, tc.names) {
+			t.Errorf("bindNamedParams(%q) = %q, %v; want %q, %v", tc.query, got, names, tc.want, tc.names)
+		}
+	}
+}
+
+func TestRecordQuery(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE pages (id VARCHAR, site VARCHAR, lang VARCHAR, html VARCHAR);
+		INSERT INTO pages VALUES ('about', 'example.org', 'en', '<p>About</p>'), ('about', 'example.org', 'sv', '<p>Om</p>');
+		CREATE TABLE news (slug VARCHAR, body VARCHAR);
+		INSERT INTO news VALUES ('launch', '<p>Launched</p>');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	h := &HTMLFromDuckDB{
+		DatabasePath:     path,
+		Table:            "pages",
+		NegativeCacheTTL: "1m",
+		RecordQuery: `SELECT html FROM pages WHERE id = :id AND site = :host AND lang = coalesce(:lang, 'en')
+			UNION ALL
+			SELECT body AS html FROM news WHERE slug = :id`,
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	for target, want := range map[string]string{
+		"http://example.org:8080/about":    "<p>About</p>",
+		"http://example.org/about?lang=sv": "<p>Om</p>",
+		"http://example.org/launch":        "<p>Launched</p>",
+	} {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil), emptyNextHandler()); err != nil {
+			t.Fatalf("%s: ServeHTTP error: %v", target, err)
+		}
+		if rec.Body.String() != want {
+			t.Errorf("%s: body = %q, want %q", target, rec.Body.String(), want)
+		}
+	}
+	err = h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/about", nil), emptyNextHandler())
+	if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusNotFound {
+		t.Errorf("other host: expected 404, got %v", err)
+	}
+	// Misses are cached per host and parameters.
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.org/about", nil), emptyNextHandler()); err != nil || rec.Body.String() != "<p>About</p>" {
+		t.Errorf("after a miss on another host: %q, %v", rec.Body.String(), err)
+	}
+}
+
+func TestProvision_RecordQueryErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for name, query := range map[string]string{
+		"no id":      "SELECT 'x' AS html",
+		"not select": "DELETE FROM pages WHERE id = :id",
+		"two":        "SELECT 'x' AS html WHERE :id = 'a'; SELECT 'y' AS html",
+		"no html":    "SELECT 'x' AS other WHERE :id = 'a'",
+		"syntax":     "SELEC html FROM pages WHERE id = :id",
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := &HTMLFromDuckDB{Table: "pages", RecordQuery: query}
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseRecordQuery(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		record_query "SELECT html FROM pages WHERE id = :id"
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.RecordQuery != "SELECT html FROM pages WHERE id = :id" {
+		t.Errorf("RecordQuery = %q", h.RecordQuery)
+	}
+}
@@ -0,0 +1,151 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/templates"
+)
+
+func init() {
+	caddy.RegisterModule(TemplateFunctions{})
+}
+
+// ErrNotFound is returned by Render and RenderRecord when there is no live
+// record with the requested ID.
+var ErrNotFound = errors.New("record not found")
+
+// Render returns the HTML of record id from the html_from_duckdb handler
+// named instance (its name option, or table[@base_path]), so other Caddy
+// modules can compose pages from DuckDB fragments in-process. During a
+// config reload the newest handler with that name is used.
+func Render(ctx context.Context, instance, id string) (string, error) {
+	h := lookupInstance(instance)
+	if h == nil {
+		return "", fmt.Errorf("no html_from_duckdb instance named %q", instance)
+	}
+	return h.RenderRecord(ctx, id)
+}
+
+// lookupInstance returns the newest provisioned handler named name, or nil.
+func lookupInstance(name string) *HTMLFromDuckDB {
+	list := liveInstances()
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].instanceName() == name {
+			return list[i]
+		}
+	}
+	return nil
+}
+
+// RenderRecord returns the HTML the handler serves for record id, from
+// record_macro or the table with Markdown rendered and includes resolved,
+// but without meta tag injection or response headers. Soft-deleted records
+// count as missing.
+func (h *HTMLFromDuckDB) RenderRecord(ctx context.Context, id string) (string, error) {
+	html, err := h.renderRecord(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return h.resolveIncludes(ctx, html, []string{id}), nil
+}
+
+// renderRecord is RenderRecord without include processing.
+func (h *HTMLFromDuckDB) renderRecord(ctx context.Context, id string) (string, error) {
+	contentColumn := h.HTMLColumn
+	if h.MarkdownColumn != "" {
+		contentColumn = h.MarkdownColumn
+	}
+	query, args := h.recordQuery(sanitizeIdentifier(contentColumn), h.RecordMacro, id, nil)
+
+	ctx, cancel := h.queryContext(ctx)
+	defer cancel()
+
+	var html string
+	start := time.Now()
+	err := h.queries(ctx, "record").QueryRowContext(ctx, tagQuery(ctx, query), args...).Scan(&html)
+	h.observeQuery(ctx, "render", query, time.Since(start))
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	html = h.decodeContent(ctx, id, html)
+	if h.markdown != nil {
+		return h.renderMarkdown(html)
+	}
+	return html, nil
+}
+
+// TemplateFunctions adds the duckdbRender, duckdbQueryRow and
+// duckdbQueryRows functions to the templates handler:
+//
+//	templates {
+//	    extensions {
+//	        duckdb
+//	    }
+//	}
+//
+// after which {{duckdbRender "works" "w123"}} includes record w123 of the
+// handler named works. Missing records render as an empty string; other
+// errors fail the template.
+//
+// {{duckdbQueryRows "works" "SELECT id, title FROM works WHERE year = ?" 2024}}
+// returns the rows of a read-only query on that handler's pool, as maps from
+// column name to value, and duckdbQueryRow the first row or nil. The
+// handler must enable template_queries.
+type TemplateFunctions struct{}
+
+// CaddyModule returns the Caddy module information.
+func (TemplateFunctions) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.templates.functions.duckdb",
+		New: func() caddy.Module { return new(TemplateFunctions) },
+	}
+}
+
+// CustomTemplateFunctions returns the template functions.
+func (TemplateFunctions) CustomTemplateFunctions() template.FuncMap {
+	return template.FuncMap{
+		"duckdbRender": func(instance, id string) (string, error) {
+			html, err := Render(context.Background(), instance, id)
+			if errors.Is(err, ErrNotFound) {
+				return "", nil
+			}
+			return html, err
+		},
+		"duckdbQueryRow": func(instance, query string, args ...any) (map[string]any, error) {
+			rows, err := templateQuery(instance, query, args, 1)
+			if err != nil || len(rows) == 0 {
+				return nil, err
+			}
+			return rows[0], nil
+		},
+		"duckdbQueryRows": func(instance, query string, args ...any) ([]map[string]any, error) {
+			return templateQuery(instance, query, args, 0)
+		},
+	}
+}
+
+// UnmarshalCaddyfile sets up the module from Caddyfile tokens. It takes no
+// options.
+func (f *TemplateFunctions) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume module name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ templates.CustomFunctions = (*TemplateFunctions)(nil)
+	_ caddyfile.Unmarshaler     = (*TemplateFunctions)(nil)
+)
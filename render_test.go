@@ -0,0 +1,73 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+
+	"go.uber.org/zap"
+)
+
+func TestRender(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR, deleted BOOLEAN);
+		INSERT INTO html VALUES ('nav', '<nav>menu</nav>', false), ('old', '<p>old</p>', true)`); err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:            db,
+		Name:          "fragments",
+		Table:         "html",
+		HTMLColumn:    "html",
+		IDColumn:      "id",
+		DeletedColumn: "deleted",
+		logger:        zap.NewNop(),
+	}
+	registerInstance(h)
+	defer unregisterInstance(h)
+
+	ctx := context.Background()
+	if html, err := Render(ctx, "fragments", "nav"); err != nil || html != "<nav>menu</nav>" {
+		t.Errorf("Render(nav) = %q, %v", html, err)
+	}
+	for _, id := range []string{"missing", "old"} {
+		if _, err := Render(ctx, "fragments", id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Render(%s) error = %v, want ErrNotFound", id, err)
+		}
+	}
+	if _, err := Render(ctx, "nope", "nav"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected unknown instance error, got %v", err)
+	}
+
+	t.Run("template function", func(t *testing.T) {
+		tpl, err := template.New("page").
+			Funcs(TemplateFunctions{}.CustomTemplateFunctions()).
+			Parse(`<body>{{duckdbRender "fragments" "nav"}}{{duckdbRender "fragments" "missing"}}</body>`)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		var b strings.Builder
+		if err := tpl.Execute(&b, nil); err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+		if b.String() != "<body><nav>menu</nav></body>" {
+			t.Errorf("got %q", b.String())
+		}
+
+		tpl = template.Must(template.New("page").
+			Funcs(TemplateFunctions{}.CustomTemplateFunctions()).
+			Parse(`{{duckdbRender "nope" "nav"}}`))
+		if err := tpl.Execute(&b, nil); err == nil {
+			t.Error("expected error for unknown instance")
+		}
+	})
+}
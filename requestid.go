@@ -0,0 +1,77 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// maxRequestIDLength caps the length of an X-Request-Id header we accept.
+const maxRequestIDLength = 128
+
+// incomingRequestID returns the ID that correlates a request's queries and
+// log lines: the X-Request-Id header when it is set and safe to embed in a
+// SQL comment, otherwise Caddy's {http.request.uuid}, which access logs can
+// include too.
+func incomingRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); validRequestID(id) {
+		return id
+	}
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		if id, ok := repl.GetString("http.request.uuid"); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// validRequestID reports whether id is non-empty, short and made only of
+// characters that can't end a SQL comment or break a log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '=', c == '+':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFrom returns the request ID of the request ctx belongs to, or ""
+// outside ServeHTTP.
+func requestIDFrom(ctx context.Context) string {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.requestID
+	}
+	return ""
+}
+
+// tagQuery prefixes query with a comment carrying the request ID, so a
+// query seen in DuckDB's profiling output or the slow query log can be
+// traced back to the HTTP request that ran it.
+func tagQuery(ctx context.Context, query string) string {
+	if id := requestIDFrom(ctx); id != "" {
+		return "/* request_id=" + id + " */ " + query
+	}
+	return query
+}
+
+// log returns the handler's logger, with the request ID added as a field
+// when ctx belongs to a request.
+func (h *HTMLFromDuckDB) log(ctx context.Context) *zap.Logger {
+	info := requestInfoFrom(ctx)
+	if info == nil || info.requestID == "" {
+		return h.logger
+	}
+	if info.logger == nil {
+		info.logger = h.logger.With(zap.String("request_id", info.requestID))
+	}
+	return info.logger
+}
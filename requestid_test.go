@@ -0,0 +1,113 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"abc-123":                              true,
+		"0f8fad5b-d9cb-469f-a165-70867728950e": true,
+		"svc.a:b=c+d_e":                        true,
+		"":                                     false,
+		"a */ DROP TABLE html; /*":             false,
+		"line\nbreak":                          false,
+		strings.Repeat("a", maxRequestIDLength+1): false,
+	} {
+		if got := validRequestID(id); got != want {
+			t.Errorf("validRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestServeHTTP_RequestID(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('w1', '<p>one</p>');
+		CREATE MACRO render_query(id) AS TABLE SELECT current_query() AS html;
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	serve := func(t *testing.T, header string, uuid string) (*httptest.ResponseRecorder, *HTMLFromDuckDB, *observer.ObservedLogs) {
+		t.Helper()
+		core, logs := observer.New(zapcore.DebugLevel)
+		h := &HTMLFromDuckDB{
+			db:          db,
+			Table:       "html",
+			HTMLColumn:  "html",
+			RecordMacro: "render_query",
+			logger:      zap.New(core),
+			slowAfter:   time.Nanosecond,
+			slowLog:     newSlowQueryLog(slowQueryLogSize),
+		}
+		r := httptest.NewRequest(http.MethodGet, "/w1", nil)
+		if header != "" {
+			r.Header.Set("X-Request-Id", header)
+		}
+		if uuid != "" {
+			repl := caddy.NewReplacer()
+			repl.Set("http.request.uuid", uuid)
+			r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		return rec, h, logs
+	}
+
+	t.Run("header", func(t *testing.T) {
+		rec, h, logs := serve(t, "abc-123", "")
+		if !strings.HasPrefix(rec.Body.String(), "/* request_id=abc-123 */ SELECT") {
+			t.Errorf("query = %q", rec.Body.String())
+		}
+		if logs.Len() == 0 {
+			t.Fatal("expected log entries")
+		}
+		for _, entry := range logs.All() {
+			if entry.ContextMap()["request_id"] != "abc-123" {
+				t.Errorf("log %q has no request_id: %v", entry.Message, entry.ContextMap())
+			}
+		}
+		if slow := h.slowLog.snapshot(); len(slow) == 0 || slow[0].RequestID != "abc-123" {
+			t.Errorf("slow queries = %+v", slow)
+		}
+	})
+
+	t.Run("invalid header falls back to uuid", func(t *testing.T) {
+		rec, _, _ := serve(t, "x */ SELECT 1; /*", "0f8fad5b-d9cb-469f-a165-70867728950e")
+		if !strings.HasPrefix(rec.Body.String(), "/* request_id=0f8fad5b-d9cb-469f-a165-70867728950e */ SELECT") {
+			t.Errorf("query = %q", rec.Body.String())
+		}
+	})
+
+	t.Run("no id", func(t *testing.T) {
+		rec, _, logs := serve(t, "", "")
+		if !strings.HasPrefix(rec.Body.String(), "SELECT") {
+			t.Errorf("query = %q", rec.Body.String())
+		}
+		for _, entry := range logs.All() {
+			if _, ok := entry.ContextMap()["request_id"]; ok {
+				t.Errorf("log %q has a request_id", entry.Message)
+			}
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// transientMessages identify DuckDB errors worth retrying: another process
+// (such as a replica swap or a backup) briefly holding the database file's
+// lock, and system calls interrupted by a signal.
+var transientMessages = []string{
+	lockErrorMessage,
+	"interrupted system call",
+	"resource temporarily unavailable",
+}
+
+// queryRetries counts queries retried after a transient failure.
+var queryRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "caddy_html_duckdb_query_retries_total",
+	Help: "Queries retried after a transient DuckDB error, by endpoint.",
+}, []string{"instance", "endpoint"})
+
+// transientError reports whether err is a failure that may go away if the
+// query is run again. Errors in the query itself, missing records and
+// timeouts or cancellations are permanent.
+func transientError(err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryTransient runs fn and, while it fails with a transient error, runs
+// it again up to retry_attempts times. The delay starts at retry_backoff and
+// doubles for each retry, with jitter so that requests that failed together
+// don't retry together. It gives up early when ctx is done.
+func (h *HTMLFromDuckDB) retryTransient(ctx context.Context, endpoint string, fn func() error) error {
+	delay := h.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		h.noteLock(err)
+		if attempt > h.retryAttempts || !transientError(err) || ctx.Err() != nil {
+			return err
+		}
+		wait := delay/2 + rand.N(delay/2+1)
+		queryRetries.WithLabelValues(h.instanceName(), endpoint).Inc()
+		h.log(ctx).Debug("retrying after transient error",
+			zap.String("endpoint", endpoint),
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait),
+			zap.Error(err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// queryRetry is QueryContext on db with transient failures retried. Only
+// opening the result is retried; errors while reading rows are not.
+func (h *HTMLFromDuckDB) queryRetry(ctx context.Context, endpoint string, db querier, query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := h.retryTransient(ctx, endpoint, func() error {
+		var err error
+		rows, err = db.QueryContext(ctx, tagQuery(ctx, query), args...)
+		return err
+	})
+	return rows, err
+}
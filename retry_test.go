@@ -0,0 +1,88 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+	"go.uber.org/zap"
+)
+
+func TestTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"file lock", &duckdb.Error{Type: duckdb.ErrorTypeIO, Msg: `IO Error: Could not set lock on file "/srv/works.duckdb": Conflicting lock is held`}, true},
+		{"interrupted system call", fmt.Errorf("read: %w", &os.SyscallError{Syscall: "read", Err: syscall.EINTR}), true},
+		{"interrupted system call message", errors.New("IO Error: Could not read from file: Interrupted system call"), true},
+		{"syntax error", &duckdb.Error{Type: duckdb.ErrorTypeParser, Msg: "Parser Error: syntax error at or near \"SELCT\""}, false},
+		{"missing table", &duckdb.Error{Type: duckdb.ErrorTypeCatalog, Msg: "Catalog Error: Table with name html does not exist!"}, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"timeout", context.DeadlineExceeded, false},
+		{"canceled", fmt.Errorf("query: %w", context.Canceled), false},
+	}
+	for _, tt := range tests {
+		if got := transientError(tt.err); got != tt.want {
+			t.Errorf("%s: transientError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	h := &HTMLFromDuckDB{retryAttempts: 2, retryBackoff: 1, logger: zap.NewNop()}
+	locked := errors.New("IO Error: Could not set lock on file")
+	run := func(ctx context.Context, failures int, failure error) (int, error) {
+		calls := 0
+		err := h.retryTransient(ctx, "record", func() error {
+			calls++
+			if calls <= failures {
+				return failure
+			}
+			return nil
+		})
+		return calls, err
+	}
+
+	if calls, err := run(context.Background(), 2, locked); err != nil || calls != 3 {
+		t.Errorf("recovering failure: calls = %d, err = %v", calls, err)
+	}
+	if calls, err := run(context.Background(), 5, locked); err != locked || calls != 3 {
+		t.Errorf("lasting failure: calls = %d, err = %v", calls, err)
+	}
+	if calls, err := run(context.Background(), 5, sql.ErrNoRows); err != sql.ErrNoRows || calls != 1 {
+		t.Errorf("permanent failure: calls = %d, err = %v", calls, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if calls, _ := run(ctx, 5, locked); calls != 1 {
+		t.Errorf("canceled request: calls = %d", calls)
+	}
+
+	h.retryAttempts = 0
+	if calls, _ := run(context.Background(), 5, locked); calls != 1 {
+		t.Errorf("retries disabled: calls = %d", calls)
+	}
+}
+
+func TestParseRetry(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		retry_attempts 0
+		retry_backoff 100ms
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.RetryAttempts == nil || *h.RetryAttempts != 0 || h.RetryBackoff != "100ms" {
+		t.Errorf("RetryAttempts = %v, RetryBackoff = %q", h.RetryAttempts, h.RetryBackoff)
+	}
+}
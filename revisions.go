@@ -0,0 +1,274 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aryann/difflib"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// revision is one entry in a record's revision list.
+type revision struct {
+	version int64
+	updated sql.NullString
+}
+
+// revisionsID returns the record ID for a request to {id}/{suffix}, or an
+// empty string if the path doesn't end in suffix. With id_param the ID is
+// taken from the query string instead.
+func (h *HTMLFromDuckDB) revisionsID(r *http.Request, suffix string) string {
+	prefix, found := strings.CutSuffix(r.URL.Path, "/"+suffix)
+	if suffix == "" || !found {
+		return ""
+	}
+	if h.IDParam != "" {
+		return r.URL.Query().Get(h.IDParam)
+	}
+	return prefix[strings.LastIndexByte(prefix, '/')+1:]
+}
+
+// revisionsSource returns the FROM target for revision queries and whether
+// it is a macro. Macros are called with the ID and filtered afterwards,
+// tables are filtered on the ID column.
+func (h *HTMLFromDuckDB) revisionsSource(id string) (string, bool) {
+	if h.RevisionsMacro != "" {
+		return fmt.Sprintf("%s(id := '%s')", sanitizeIdentifier(h.RevisionsMacro), escapeSQLString(id)), true
+	}
+	table := h.RevisionsTable
+	if table == "" {
+		table = h.Table
+	}
+	return sanitizeIdentifier(table), false
+}
+
+// serveRevisions serves {id}/{revisions_path}: the list of stored versions
+// of a record, or with ?version=N that version's content.
+func (h *HTMLFromDuckDB) serveRevisions(w http.ResponseWriter, r *http.Request, id string) error {
+	requestInfoFrom(r.Context()).setID(id)
+	ctx, cancel := h.queryContext(r.Context())
+	defer cancel()
+
+	var body string
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid version %q", v))
+		}
+		content, err := h.revisionContent(ctx, id, version)
+		if err == sql.ErrNoRows {
+			return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("no version %d of %q", version, id))
+		}
+		if err != nil {
+			h.logFailure(r.Context(), "revision query failed", zap.String("id", id), zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		body = content
+	} else {
+		revs, err := h.listRevisions(ctx, id)
+		if err != nil {
+			h.logFailure(r.Context(), "revisions query failed", zap.String("id", id), zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		if len(revs) == 0 {
+			return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("no revisions of %q", id))
+		}
+		body = h.renderRevisionList(id, revs)
+	}
+
+	if notModified(w, r, generateETag(body)) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(body))
+	return err
+}
+
+// listRevisions returns the versions of a record, newest first.
+func (h *HTMLFromDuckDB) listRevisions(ctx context.Context, id string) ([]revision, error) {
+	source, isMacro := h.revisionsSource(id)
+	updated := "NULL"
+	if h.UpdatedColumn != "" {
+		updated = "CAST(" + sanitizeIdentifier(h.UpdatedColumn) + " AS VARCHAR)"
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s", sanitizeIdentifier(h.VersionColumn), updated, source)
+	var args []any
+	if !isMacro {
+		query += fmt.Sprintf(" WHERE %s = ?", sanitizeIdentifier(h.IDColumn))
+		args = append(args, id)
+	}
+	query += fmt.Sprintf(" ORDER BY %s DESC", sanitizeIdentifier(h.VersionColumn))
+
+	start := time.Now()
+	defer func() { h.observeQuery(ctx, "revisions", query, time.Since(start)) }()
+
+	rows, err := h.queries(ctx, "revisions").QueryContext(ctx, tagQuery(ctx, query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revs []revision
+	for rows.Next() {
+		var rev revision
+		if err := rows.Scan(&rev.version, &rev.updated); err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, rows.Err()
+}
+
+// revisionContent returns the HTML of one version of a record, rendering
+// Markdown when the handler does. It returns sql.ErrNoRows if there is no
+// such version.
+func (h *HTMLFromDuckDB) revisionContent(ctx context.Context, id string, version int64) (string, error) {
+	contentColumn := h.HTMLColumn
+	if h.MarkdownColumn != "" {
+		contentColumn = h.MarkdownColumn
+	}
+	source, isMacro := h.revisionsSource(id)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+		sanitizeIdentifier(contentColumn),
+		source,
+		sanitizeIdentifier(h.VersionColumn))
+	args := []any{version}
+	if !isMacro {
+		query += fmt.Sprintf(" AND %s = ?", sanitizeIdentifier(h.IDColumn))
+		args = append(args, id)
+	}
+	query += " LIMIT 1"
+
+	var content string
+	start := time.Now()
+	err := h.queries(ctx, "revisions").QueryRowContext(ctx, tagQuery(ctx, query), args...).Scan(&content)
+	h.observeQuery(ctx, "revisions", query, time.Since(start))
+	if err != nil {
+		return "", err
+	}
+	content = h.decodeContent(ctx, id, content)
+	if h.markdown != nil {
+		return h.renderMarkdown(content)
+	}
+	return content, nil
+}
+
+// revisionLink returns a link relative to the revisions endpoint to
+// endpoint (empty for the revisions endpoint itself) with the given query,
+// carrying the ID along when it comes from id_param.
+func (h *HTMLFromDuckDB) revisionLink(id, endpoint, query string) string {
+	if h.IDParam != "" {
+		param := url.QueryEscape(h.IDParam) + "=" + url.QueryEscape(id)
+		if query == "" {
+			query = param
+		} else {
+			query = param + "&" + query
+		}
+	}
+	if query == "" {
+		return endpoint
+	}
+	return endpoint + "?" + query
+}
+
+// renderRevisionList renders the revision list page with a link to each
+// version and to its diff against the previous one.
+func (h *HTMLFromDuckDB) renderRevisionList(id string, revs []revision) string {
+	var b strings.Builder
+	title := "Revisions of " + html.EscapeString(id)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n<h1>%s</h1>\n<ol class=\"revisions\" reversed>\n", title, title)
+	for i, rev := range revs {
+		link := h.revisionLink(id, "", fmt.Sprintf("version=%d", rev.version))
+		fmt.Fprintf(&b, "<li><a href=\"%s\">Version %d</a>", html.EscapeString(link), rev.version)
+		if rev.updated.Valid {
+			fmt.Fprintf(&b, " <time>%s</time>", html.EscapeString(rev.updated.String))
+		}
+		if h.DiffPath != "" && i+1 < len(revs) {
+			link = h.revisionLink(id, h.DiffPath, fmt.Sprintf("from=%d&to=%d", revs[i+1].version, rev.version))
+			fmt.Fprintf(&b, " <a class=\"diff\" href=\"%s\">changes</a>", html.EscapeString(link))
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ol>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// maxDiffLines caps the size of revisions compared by the diff endpoint; the
+// diff needs memory proportional to the product of both line counts.
+const maxDiffLines = 5000
+
+// serveDiff serves {id}/{diff_path}?from=N&to=M: a line-by-line HTML diff of
+// two revisions of a record.
+func (h *HTMLFromDuckDB) serveDiff(w http.ResponseWriter, r *http.Request, id string) error {
+	requestInfoFrom(r.Context()).setID(id)
+	var versions [2]int64
+	for i, name := range []string{"from", "to"} {
+		v := r.URL.Query().Get(name)
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid %s version %q", name, v))
+		}
+		versions[i] = n
+	}
+
+	ctx, cancel := h.queryContext(r.Context())
+	defer cancel()
+
+	var lines [2][]string
+	for i, version := range versions {
+		content, err := h.revisionContent(ctx, id, version)
+		if err == sql.ErrNoRows {
+			return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("no version %d of %q", version, id))
+		}
+		if err != nil {
+			h.logFailure(r.Context(), "revision query failed", zap.String("id", id), zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		lines[i] = strings.Split(html.EscapeString(content), "\n")
+		if len(lines[i]) > maxDiffLines {
+			return caddyhttp.Error(http.StatusUnprocessableEntity,
+				fmt.Errorf("version %d of %q has more than %d lines", version, id, maxDiffLines))
+		}
+	}
+
+	body := h.renderDiff(id, versions[0], versions[1], difflib.HTMLDiff(lines[0], lines[1]))
+	if notModified(w, r, generateETag(body)) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(body))
+	return err
+}
+
+// renderDiff wraps the table rows produced by difflib in a page.
+func (h *HTMLFromDuckDB) renderDiff(id string, from, to int64, rows string) string {
+	var b strings.Builder
+	title := fmt.Sprintf("Changes to %s from version %d to %d", html.EscapeString(id), from, to)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", title)
+	b.WriteString("<style>\n" +
+		".diff { border-collapse: collapse; font-family: monospace; }\n" +
+		".diff pre { margin: 0; white-space: pre-wrap; }\n" +
+		".diff .line-num { color: #888; text-align: right; padding: 0 .5em; }\n" +
+		".diff .deleted { background: #fdd; }\n" +
+		".diff .added { background: #dfd; }\n" +
+		"</style>\n</head>\n<body>\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n<p><a href=\"%s\">All revisions</a></p>\n<table class=\"diff\">\n",
+		title, html.EscapeString(h.revisionLink(id, h.RevisionsPath, "")))
+	b.WriteString(rows)
+	b.WriteString("</table>\n</body>\n</html>\n")
+	return b.String()
+}
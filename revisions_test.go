@@ -0,0 +1,153 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_Revisions(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('doc', '<p>v3</p>');
+		CREATE TABLE html_revisions (id VARCHAR, version INTEGER, html VARCHAR, updated_at TIMESTAMP);
+		INSERT INTO html_revisions VALUES
+			('doc', 1, '<p>v1</p>', TIMESTAMP '2025-01-01 10:00:00'),
+			('doc', 2, '<p>v2</p>', TIMESTAMP '2025-02-01 10:00:00'),
+			('doc', 3, '<p>v3</p>', TIMESTAMP '2025-03-01 10:00:00'),
+			('other', 1, '<p>other</p>', TIMESTAMP '2025-01-01 10:00:00');
+		CREATE MACRO doc_revisions(id) AS TABLE
+		SELECT version, html FROM html_revisions WHERE html_revisions.id = id
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		db:             db,
+		Table:          "html",
+		HTMLColumn:     "html",
+		IDColumn:       "id",
+		RevisionsPath:  "_revisions",
+		DiffPath:       "_diff",
+		RevisionsTable: "html_revisions",
+		VersionColumn:  "version",
+		UpdatedColumn:  "updated_at",
+		logger:         zap.NewNop(),
+	}
+	serve := func(t *testing.T, h *HTMLFromDuckDB, path string) (*httptest.ResponseRecorder, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler())
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			return rec, herr.StatusCode
+		} else if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec, rec.Code
+	}
+
+	t.Run("list", func(t *testing.T) {
+		rec, status := serve(t, handler, "/doc/_revisions")
+		if status != http.StatusOK {
+			t.Fatalf("status = %d, want 200", status)
+		}
+		body := rec.Body.String()
+		v3 := strings.Index(body, `<a href="?version=3">`)
+		v1 := strings.Index(body, `<a href="?version=1">`)
+		if v3 < 0 || v1 < 0 || v3 > v1 {
+			t.Errorf("expected versions newest first, got %q", body)
+		}
+		if !strings.Contains(body, "<time>2025-02-01 10:00:00</time>") {
+			t.Errorf("expected updated_at in list, got %q", body)
+		}
+		if !strings.Contains(body, `<a class="diff" href="_diff?from=2&amp;to=3">`) {
+			t.Errorf("expected diff link, got %q", body)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{"version", "/doc/_revisions?version=2", http.StatusOK, "<p>v2</p>"},
+		{"missing version", "/doc/_revisions?version=9", http.StatusNotFound, ""},
+		{"invalid version", "/doc/_revisions?version=x", http.StatusBadRequest, ""},
+		{"missing record", "/nope/_revisions", http.StatusNotFound, ""},
+		{"current record", "/doc", http.StatusOK, "<p>v3</p>"},
+		{"diff missing version", "/doc/_diff?from=1&to=9", http.StatusNotFound, ""},
+		{"diff invalid version", "/doc/_diff?from=1", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, status := serve(t, handler, tt.path)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+
+	t.Run("diff", func(t *testing.T) {
+		rec, status := serve(t, handler, "/doc/_diff?from=1&to=3")
+		if status != http.StatusOK {
+			t.Fatalf("status = %d, want 200", status)
+		}
+		body := rec.Body.String()
+		for _, want := range []string{
+			`<td class="deleted"><pre>&lt;p&gt;v1&lt;/p&gt;</pre>`,
+			`<td class="added"><pre>&lt;p&gt;v3&lt;/p&gt;</pre>`,
+			`<a href="_revisions">All revisions</a>`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected %q in diff, got %q", want, body)
+			}
+		}
+	})
+
+	t.Run("id param links", func(t *testing.T) {
+		h := *handler
+		h.IDParam = "id"
+		rec, status := serve(t, &h, "/_revisions?id=doc")
+		if status != http.StatusOK {
+			t.Fatalf("status = %d, want 200", status)
+		}
+		for _, want := range []string{`href="?id=doc&amp;version=3"`, `href="_diff?id=doc&amp;from=2&amp;to=3"`} {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("expected %q in list, got %q", want, rec.Body.String())
+			}
+		}
+	})
+
+	t.Run("macro", func(t *testing.T) {
+		h := *handler
+		h.RevisionsMacro = "doc_revisions"
+		h.UpdatedColumn = ""
+
+		rec, status := serve(t, &h, "/doc/_revisions?version=1")
+		if status != http.StatusOK || rec.Body.String() != "<p>v1</p>" {
+			t.Errorf("got %d %q", status, rec.Body.String())
+		}
+		rec, status = serve(t, &h, "/doc/_revisions")
+		if status != http.StatusOK || strings.Count(rec.Body.String(), "<li>") != 3 {
+			t.Errorf("got %d %q", status, rec.Body.String())
+		}
+	})
+}
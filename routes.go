@@ -0,0 +1,103 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// RecordRoute renders the records under a path prefix with their own macro,
+// so one handler (and one connection pool) can serve several content types.
+type RecordRoute struct {
+	// Prefix is the request path prefix, e.g. "/works/". A trailing "*" is
+	// dropped, so "/works/*" works too.
+	Prefix string `json:"prefix"`
+
+	// Macro is the DuckDB table macro rendering records under Prefix, called
+	// like record_macro with (id).
+	Macro string `json:"macro"`
+
+	// IDParam takes the ID from this query parameter. By default the ID is the
+	// rest of the path after Prefix.
+	IDParam string `json:"id_param,omitempty"`
+
+	// CacheControl overrides cache_control for this route.
+	CacheControl string `json:"cache_control,omitempty"`
+}
+
+// provisionRecordRoutes normalizes and validates the configured routes.
+func (h *HTMLFromDuckDB) provisionRecordRoutes() error {
+	for i := range h.RecordRoutes {
+		route := &h.RecordRoutes[i]
+		route.Prefix = strings.TrimSuffix(route.Prefix, "*")
+		if route.Prefix == "" {
+			return fmt.Errorf("record route %d: prefix is required", i)
+		}
+		if route.Macro == "" {
+			return fmt.Errorf("record route %s: macro is required", route.Prefix)
+		}
+	}
+	return nil
+}
+
+// recordRoute returns the first record route whose prefix matches path, or
+// nil.
+func (h *HTMLFromDuckDB) recordRoute(path string) *RecordRoute {
+	for i := range h.RecordRoutes {
+		if strings.HasPrefix(path, h.RecordRoutes[i].Prefix) {
+			return &h.RecordRoutes[i]
+		}
+	}
+	return nil
+}
+
+// parseRecordRoute parses a record_route directive, either on one line:
+//
+//	record_route /works/* render_work
+//
+// or as a block:
+//
+//	record_route /people/* {
+//	    macro render_person
+//	    id_param pid
+//	    cache_control "public, max-age=60"
+//	}
+func parseRecordRoute(d *caddyfile.Dispenser) (RecordRoute, error) {
+	var route RecordRoute
+	if !d.NextArg() {
+		return route, d.ArgErr()
+	}
+	route.Prefix = d.Val()
+	if d.NextArg() {
+		route.Macro = d.Val()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "macro":
+			if !d.NextArg() {
+				return route, d.ArgErr()
+			}
+			route.Macro = d.Val()
+
+		case "id_param":
+			if !d.NextArg() {
+				return route, d.ArgErr()
+			}
+			route.IDParam = d.Val()
+
+		case "cache_control":
+			if !d.NextArg() {
+				return route, d.ArgErr()
+			}
+			route.CacheControl = d.Val()
+
+		default:
+			return route, d.Errf("unrecognized record_route subdirective: %s", d.Val())
+		}
+	}
+	if route.Macro == "" {
+		return route, d.Err("record_route needs a macro")
+	}
+	return route, nil
+}
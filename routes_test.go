@@ -0,0 +1,139 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestParseRecordRoute(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		table html
+		record_route /works/* render_work
+		record_route /people/* {
+			macro render_person
+			id_param pid
+			cache_control "public, max-age=60"
+		}
+	}`)
+	var h HTMLFromDuckDB
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	want := []RecordRoute{
+		{Prefix: "/works/*", Macro: "render_work"},
+		{Prefix: "/people/*", Macro: "render_person", IDParam: "pid", CacheControl: "public, max-age=60"},
+	}
+	if !reflect.DeepEqual(h.RecordRoutes, want) {
+		t.Errorf("RecordRoutes = %+v, want %+v", h.RecordRoutes, want)
+	}
+
+	for _, input := range []string{
+		`html_from_duckdb {
+			record_route /works/*
+		}`,
+		`html_from_duckdb {
+			record_route /works/* {
+				template render_work
+			}
+		}`,
+	} {
+		var h HTMLFromDuckDB
+		if err := h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestServeHTTP_RecordRoutes(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('1', '<p>page 1</p>');
+		CREATE TABLE works (id VARCHAR, title VARCHAR);
+		INSERT INTO works VALUES ('1', 'Hamlet');
+		CREATE TABLE people (id VARCHAR, name VARCHAR);
+		INSERT INTO people VALUES ('1', 'Shakespeare');
+		CREATE MACRO render_work(id) AS TABLE
+		SELECT '<h1>' || title || '</h1>' AS html FROM works WHERE works.id = id;
+		CREATE MACRO render_person(id) AS TABLE
+		SELECT '<h1>' || name || '</h1>' AS html FROM people WHERE people.id = id
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		db:           db,
+		Table:        "html",
+		HTMLColumn:   "html",
+		IDColumn:     "id",
+		CacheControl: "no-cache",
+		RecordRoutes: []RecordRoute{
+			{Prefix: "/works/*", Macro: "render_work"},
+			{Prefix: "/people/", Macro: "render_person", IDParam: "pid", CacheControl: "public, max-age=60"},
+		},
+		notFound: newLRUCache[struct{}](negativeCacheSize, time.Hour),
+		logger:   zap.NewNop(),
+	}
+	if err := handler.provisionRecordRoutes(); err != nil {
+		t.Fatalf("provisionRecordRoutes: %v", err)
+	}
+
+	tests := []struct {
+		path         string
+		status       int
+		body         string
+		cacheControl string
+	}{
+		{"/works/1", http.StatusOK, "<h1>Hamlet</h1>", "no-cache"},
+		{"/people/?pid=1", http.StatusOK, "<h1>Shakespeare</h1>", "public, max-age=60"},
+		{"/pages/1", http.StatusOK, "<p>page 1</p>", "no-cache"},
+		{"/works/2", http.StatusNotFound, "", ""},
+		{"/works/", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil), emptyNextHandler())
+			status := rec.Code
+			var herr caddyhttp.HandlerError
+			if errors.As(err, &herr) {
+				status = herr.StatusCode
+			} else if err != nil {
+				t.Fatalf("ServeHTTP error: %v", err)
+			}
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+		})
+	}
+
+	t.Run("misses are cached per route", func(t *testing.T) {
+		if _, ok := handler.notFound.get("/works/\x002"); !ok {
+			t.Error("expected /works/ miss to be cached")
+		}
+		if _, ok := handler.notFound.get("2"); ok {
+			t.Error("route miss should not be cached for the default lookup")
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package caddyhtmlduckdb
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+// handlerSchema is the JSON Schema of the handler's JSON config, generated
+// from the doc comments of HTMLFromDuckDB and the types it uses. Update it
+// with `go test -run TestSchema -update` after changing an option.
+//
+//go:embed schema.json
+var handlerSchema []byte
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "duckdb-schema",
+		Usage: "[--output <file>]",
+		Short: "Prints the JSON Schema of the html_from_duckdb handler config",
+		Long: `
+Prints the JSON Schema (draft 2020-12) of the html_from_duckdb handler's
+JSON config, with the type, description and default of every option, for
+editors and config validation pipelines. The schema covers one handler
+object, as found in a route's "handle" list.`,
+		CobraFunc: func(cmd *cobra.Command) {
+			cmd.Flags().StringP("output", "o", "", "Write the schema to this file instead of stdout")
+			cmd.RunE = caddycmd.WrapCommandFuncForCobra(cmdSchema)
+		},
+	})
+}
+
+// cmdSchema writes the handler schema to stdout or the --output file.
+func cmdSchema(fl caddycmd.Flags) (int, error) {
+	if output := fl.String("output"); output != "" {
+		if err := os.WriteFile(output, handlerSchema, 0o644); err != nil {
+			return 1, fmt.Errorf("writing schema: %v", err)
+		}
+		return 0, nil
+	}
+	if _, err := os.Stdout.Write(handlerSchema); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
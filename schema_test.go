@@ -0,0 +1,265 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateSchema = flag.Bool("update", false, "regenerate schema.json")
+
+func TestSchema(t *testing.T) {
+	got, err := generateSchema(".")
+	if err != nil {
+		t.Fatalf("generateSchema: %v", err)
+	}
+	if *updateSchema {
+		if err := os.WriteFile("schema.json", got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if !bytes.Equal(got, handlerSchema) {
+		t.Error("schema.json is out of date; run go test -run TestSchema -update")
+	}
+
+	var schema struct {
+		Properties map[string]struct {
+			Type        any    `json:"type"`
+			Ref         string `json:"$ref"`
+			Description string `json:"description"`
+			Default     any    `json:"default"`
+		} `json:"properties"`
+		Defs map[string]json.RawMessage `json:"$defs"`
+	}
+	if err := json.Unmarshal(handlerSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	typ := reflect.TypeOf(HTMLFromDuckDB{})
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("option %s missing from the schema", name)
+		}
+	}
+	for name, want := range map[string]any{"html_column": "html", "connection_pool_size": 10.0, "read_only": true} {
+		if got := schema.Properties[name].Default; got != want {
+			t.Errorf("%s default = %v, want %v", name, got, want)
+		}
+	}
+	if p := schema.Properties["table"]; p.Type != "string" || !strings.Contains(p.Description, "Table") {
+		t.Errorf("table = %+v", p)
+	}
+	if _, ok := schema.Defs["FilterParam"]; !ok || schema.Properties["backup"].Ref != "#/$defs/Backup" {
+		t.Error("nested blocks missing from $defs")
+	}
+}
+
+// generateSchema builds the JSON Schema of HTMLFromDuckDB from the Go
+// sources in dir, taking descriptions and defaults from doc comments.
+func generateSchema(dir string) ([]byte, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	g := &schemaGenerator{types: make(map[string]*ast.TypeSpec), docs: make(map[string]string), defs: make(map[string]any)}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				g.types[ts.Name.Name] = ts
+				if ts.Doc != nil {
+					g.docs[ts.Name.Name] = ts.Doc.Text()
+				} else if gen.Doc != nil {
+					g.docs[ts.Name.Name] = gen.Doc.Text()
+				}
+			}
+		}
+	}
+
+	schema := g.structSchema(g.types["HTMLFromDuckDB"].Type.(*ast.StructType))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "html_from_duckdb handler"
+	schema["description"] = strings.TrimSpace(g.docs["HTMLFromDuckDB"])
+	schema["properties"].(map[string]any)["handler"] = map[string]any{"const": "html_from_duckdb"}
+	schema["required"] = []string{"handler"}
+	schema["$defs"] = g.defs
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(schema); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// schemaGenerator maps the package's types to JSON Schema.
+type schemaGenerator struct {
+	types map[string]*ast.TypeSpec
+	docs  map[string]string
+	defs  map[string]any
+}
+
+// structSchema returns the schema of an object with the struct's JSON
+// fields.
+func (g *schemaGenerator) structSchema(st *ast.StructType) map[string]any {
+	props := make(map[string]any)
+	for _, field := range st.Fields.List {
+		tag := reflect.StructTag("")
+		if field.Tag != nil {
+			tag = reflect.StructTag(strings.Trim(field.Tag.Value, "`"))
+		}
+		for _, ident := range field.Names {
+			name, _, _ := strings.Cut(tag.Get("json"), ",")
+			if !ident.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = ident.Name
+			}
+			prop := g.typeSchema(field.Type, tag.Get("caddy"))
+			if field.Doc != nil {
+				doc := field.Doc.Text()
+				prop["description"] = strings.TrimSpace(doc)
+				if def, ok := parseDefault(doc, prop["type"]); ok {
+					prop["default"] = def
+				}
+			}
+			props[name] = prop
+		}
+	}
+	return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+}
+
+// typeSchema returns the schema of a field type. caddyTag is the field's
+// caddy struct tag, which marks module configs.
+func (g *schemaGenerator) typeSchema(expr ast.Expr, caddyTag string) map[string]any {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return g.typeSchema(t.X, caddyTag)
+	case *ast.ArrayType:
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elt, caddyTag)}
+	case *ast.MapType:
+		return map[string]any{"type": "object", "additionalProperties": g.typeSchema(t.Value, caddyTag)}
+	case *ast.SelectorExpr:
+		if t.Sel.Name == "RawMessage" && caddyTag != "" {
+			return moduleSchema(caddyTag)
+		}
+		return map[string]any{}
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return map[string]any{"type": "string"}
+		case "bool":
+			return map[string]any{"type": "boolean"}
+		case "int", "int32", "int64", "uint", "uint32", "uint64":
+			return map[string]any{"type": "integer"}
+		case "float32", "float64":
+			return map[string]any{"type": "number"}
+		}
+		ts, ok := g.types[t.Name]
+		if !ok {
+			return map[string]any{}
+		}
+		st, ok := ts.Type.(*ast.StructType)
+		if !ok {
+			return g.typeSchema(ts.Type, caddyTag)
+		}
+		if _, done := g.defs[t.Name]; !done {
+			g.defs[t.Name] = nil // guards recursive types
+			def := g.structSchema(st)
+			if doc := g.docs[t.Name]; doc != "" {
+				def["description"] = strings.TrimSpace(doc)
+			}
+			g.defs[t.Name] = def
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name}
+	}
+	return map[string]any{}
+}
+
+// moduleSchema returns the schema of a module config with the caddy tag
+// caddyTag, such as "namespace=duckdb.transformers inline_key=transformer".
+func moduleSchema(caddyTag string) map[string]any {
+	var namespace, inlineKey string
+	for _, part := range strings.Fields(caddyTag) {
+		if v, ok := strings.CutPrefix(part, "namespace="); ok {
+			namespace = v
+		}
+		if v, ok := strings.CutPrefix(part, "inline_key="); ok {
+			inlineKey = v
+		}
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			inlineKey: map[string]any{"type": "string", "description": "Name of a module in the " + namespace + " namespace"},
+		},
+		"required": []string{inlineKey},
+	}
+}
+
+// parseDefault returns the value of a "Default:" line in doc for a field
+// of JSON type typ, if it is a value such as "html", 10, true or 30s
+// rather than prose. A note in parentheses after the value is ignored.
+func parseDefault(doc string, typ any) (any, bool) {
+	for _, line := range strings.Split(doc, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "Default: ")
+		if !ok {
+			continue
+		}
+		if v, _, ok := strings.Cut(value, " ("); ok {
+			value = v
+		}
+		switch typ {
+		case "boolean":
+			if value == "true" || value == "false" {
+				return value == "true", true
+			}
+		case "integer":
+			if n, err := strconv.Atoi(value); err == nil {
+				return n, true
+			}
+		case "number":
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				return n, true
+			}
+		case "string":
+			if s, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+				return s, true
+			}
+			if _, err := time.ParseDuration(value); err == nil {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
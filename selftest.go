@@ -0,0 +1,53 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// selfTest requests self_test_id through the handler, exactly as a client
+// would, and fails unless it is served with status 200 and contains
+// self_test_expect. It catches broken macros, extensions or includes that
+// the catalog checks at startup don't see.
+func (h *HTMLFromDuckDB) selfTest(ctx context.Context) error {
+	target := h.BasePath + "/" + url.PathEscape(h.SelfTestID)
+	if h.IDParam != "" {
+		target = h.BasePath + "/?" + url.Values{h.IDParam: {h.SelfTestID}}.Encode()
+	}
+	ctx = context.WithValue(ctx, requestInfoKey{}, &requestInfo{})
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("self-test: %v", err)
+	}
+
+	start := time.Now()
+	rec := &esiResponse{header: make(http.Header)}
+	if err := h.serveHTTP(rec, r); err != nil {
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			return fmt.Errorf("self-test of %q failed: status %d: %v", h.SelfTestID, herr.StatusCode, herr.Err)
+		}
+		return fmt.Errorf("self-test of %q failed: %v", h.SelfTestID, err)
+	}
+	if rec.status != http.StatusOK {
+		return fmt.Errorf("self-test of %q failed: status %d", h.SelfTestID, rec.status)
+	}
+	if h.SelfTestExpect != "" && !strings.Contains(rec.body.String(), h.SelfTestExpect) {
+		return fmt.Errorf("self-test of %q failed: response does not contain %q: %s",
+			h.SelfTestID, h.SelfTestExpect, truncateForLog(rec.body.String(), 200))
+	}
+
+	h.logger.Info("self-test passed",
+		zap.String("id", h.SelfTestID),
+		zap.Int("size", rec.body.Len()),
+		zap.Duration("duration", time.Since(start)))
+	return nil
+}
@@ -0,0 +1,73 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestProvision_SelfTest(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	initSQL := writeInitSQL(t, `
+		CREATE TABLE IF NOT EXISTS html AS SELECT 'home' AS id, '<h1>Welcome</h1>' AS html;
+		CREATE OR REPLACE MACRO render_broken(id) AS TABLE SELECT error('extension not loaded') AS html;
+	`)
+	readOnly := false
+	tests := []struct {
+		name    string
+		h       *HTMLFromDuckDB
+		wantErr string
+	}{
+		{"passes", &HTMLFromDuckDB{SelfTestID: "home", SelfTestExpect: "Welcome"}, ""},
+		{"passes with id_param", &HTMLFromDuckDB{SelfTestID: "home", IDParam: "id", BasePath: "/site"}, ""},
+		{"missing record", &HTMLFromDuckDB{SelfTestID: "nope"}, "status 404"},
+		{"unexpected output", &HTMLFromDuckDB{SelfTestID: "home", SelfTestExpect: "Goodbye"}, `does not contain "Goodbye"`},
+		{"broken macro", &HTMLFromDuckDB{SelfTestID: "home", RecordMacro: "render_broken"}, "status 500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.h.Table = "html"
+			tt.h.InitSQLFile = initSQL
+			tt.h.ReadOnly = &readOnly
+			err := tt.h.Provision(ctx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Provision: %v", err)
+				}
+				tt.h.Cleanup()
+				return
+			}
+			if err == nil {
+				tt.h.Cleanup()
+				t.Fatal("expected Provision to fail")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+			if tt.h.pool != nil {
+				if _, ok := pools.References(tt.h.pool.key); ok {
+					t.Error("pool should be released after a failed self-test")
+				}
+			}
+		})
+	}
+}
+
+func TestParseSelfTest(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		self_test_id home
+		self_test_expect "<h1>Welcome"
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.SelfTestID != "home" || h.SelfTestExpect != "<h1>Welcome" {
+		t.Errorf("SelfTestID = %q, SelfTestExpect = %q", h.SelfTestID, h.SelfTestExpect)
+	}
+}
@@ -0,0 +1,146 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// sessionVariable is a session variable's value for one request. A nil
+// value sets the variable to NULL.
+type sessionVariable struct {
+	name  string
+	value any
+}
+
+// checkSessionVariables validates session_variables. Variables hold a
+// user's identity, so caches that would share one user's rows with others
+// can't be used with them.
+func (h *HTMLFromDuckDB) checkSessionVariables() error {
+	if len(h.SessionVariables) == 0 {
+		return nil
+	}
+	for name := range h.SessionVariables {
+		if name == "" || sanitizeIdentifier(name) != name {
+			return fmt.Errorf("invalid session variable name %q", name)
+		}
+	}
+	if h.indexCache != nil || h.notFound != nil || h.esiCache != nil || h.tileCache != nil {
+		return fmt.Errorf("session_variables can't be combined with the index, negative, ESI or tile cache")
+	}
+	return nil
+}
+
+// sessionValues resolves the placeholders in session_variables for r.
+// Variables whose value is empty, such as the user of an anonymous
+// request, are NULL.
+func (h *HTMLFromDuckDB) sessionValues(r *http.Request) []sessionVariable {
+	if len(h.SessionVariables) == 0 {
+		return nil
+	}
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		repl = caddy.NewReplacer()
+	}
+	vars := make([]sessionVariable, 0, len(h.SessionVariables))
+	for name, value := range h.SessionVariables {
+		v := sessionVariable{name: name}
+		if s := repl.ReplaceAll(value, ""); s != "" {
+			v.value = s
+		}
+		vars = append(vars, v)
+	}
+	slices.SortFunc(vars, func(a, b sessionVariable) int { return strings.Compare(a.name, b.name) })
+	return vars
+}
+
+// sessionConn takes a connection from db and sets vars on it.
+func (h *HTMLFromDuckDB) sessionConn(ctx context.Context, db *sql.DB, vars []sessionVariable) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vars {
+		if _, err := conn.ExecContext(ctx, "SET VARIABLE "+v.name+" = ?", v.value); err != nil {
+			h.releaseConn(conn, vars)
+			return nil, fmt.Errorf("failed to set session variable %s: %v", v.name, err)
+		}
+	}
+	return conn, nil
+}
+
+// releaseConn resets vars on conn and returns it to the pool. A connection
+// whose variables can't be reset is discarded, so no other request sees
+// them.
+func (h *HTMLFromDuckDB) releaseConn(conn *sql.Conn, vars []sessionVariable) {
+	for _, v := range vars {
+		// The request's context may be done by now.
+		if _, err := conn.ExecContext(context.Background(), "RESET VARIABLE "+v.name); err != nil {
+			h.logger.Warn("resetting session variables failed, discarding connection", zap.Error(err))
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+			break
+		}
+	}
+	conn.Close()
+}
+
+// requestConn takes a connection from the endpoint's pool for a query that
+// needs one of its own, with the request's session variables set. The
+// returned function returns it to the pool.
+func (h *HTMLFromDuckDB) requestConn(ctx context.Context, endpoint string) (*sql.Conn, func(), error) {
+	var vars []sessionVariable
+	if pin, ok := ctx.Value(pinnedSnapshotKey{}).(*snapshotPin); ok {
+		vars = pin.vars
+	}
+	conn, err := h.sessionConn(ctx, h.requestDatabase(ctx, endpoint), vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, func() { h.releaseConn(conn, vars) }, nil
+}
+
+// failingDB returns a pool whose every query fails with err.
+func failingDB(err error) *sql.DB {
+	return sql.OpenDB(failingConnector{err})
+}
+
+// failingConnector is a driver.Connector that can't connect.
+type failingConnector struct {
+	err error
+}
+
+func (c failingConnector) Connect(context.Context) (driver.Conn, error) { return nil, c.err }
+func (c failingConnector) Driver() driver.Driver                        { return failingDriver(c) }
+
+// failingDriver is failingConnector's driver.
+type failingDriver failingConnector
+
+func (d failingDriver) Open(string) (driver.Conn, error) { return nil, d.err }
+
+// parseSessionVariables parses a session_variables block:
+//
+//	session_variables {
+//	    <name> <value>
+//	}
+func parseSessionVariables(d *caddyfile.Dispenser) (map[string]string, error) {
+	vars := make(map[string]string)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		name := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		vars[name] = d.Val()
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,103 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestSessionVariables(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	initFile := writeInitSQL(t, `CREATE TABLE IF NOT EXISTS pages AS
+		SELECT * FROM (VALUES ('a', '<p>a</p>', 'ann'), ('b', '<p>b</p>', 'bob')) t(id, html, owner);
+	CREATE OR REPLACE VIEW html AS SELECT * FROM pages WHERE owner = getvariable('current_user');
+	CREATE OR REPLACE MACRO owned(base_path := '') AS TABLE SELECT id, owner FROM html`)
+	h := &HTMLFromDuckDB{
+		InitSQLFile:      initFile,
+		Table:            "html",
+		TableMacro:       "owned",
+		TablePath:        "_table",
+		SessionVariables: map[string]string{"current_user": "{http.auth.user.id}"},
+		// One connection, so every request reuses the previous one's.
+		ConnectionPoolSize: 1,
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	get := func(path, user string) *httptest.ResponseRecorder {
+		repl := caddy.NewReplacer()
+		if user != "" {
+			repl.Set("http.auth.user.id", user)
+		}
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			rec.Code = http.StatusNotFound
+		}
+		return rec
+	}
+
+	for _, tt := range []struct {
+		path, user string
+		status     int
+	}{
+		{"/a", "ann", http.StatusOK},
+		{"/b", "ann", http.StatusNotFound},
+		{"/b", "bob", http.StatusOK},
+		{"/b", "", http.StatusNotFound},
+	} {
+		if rec := get(tt.path, tt.user); rec.Code != tt.status {
+			t.Errorf("%s as %q: status = %d, want %d", tt.path, tt.user, rec.Code, tt.status)
+		}
+	}
+	if rec := get("/_table?format=csv", "bob"); rec.Body.String() != "id,owner\nb,bob\n" {
+		t.Errorf("table as bob = %q", rec.Body.String())
+	}
+
+	// Variables don't outlive the request on the connection.
+	var user any
+	if err := h.database().QueryRow("SELECT getvariable('current_user')").Scan(&user); err != nil || user != nil {
+		t.Errorf("current_user after requests = %v, %v", user, err)
+	}
+}
+
+func TestProvision_SessionVariablesErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"bad name":       {Table: "html", SessionVariables: map[string]string{"current-user": "x"}},
+		"negative cache": {Table: "html", SessionVariables: map[string]string{"u": "x"}, NegativeCacheTTL: "1m"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestParseSessionVariables(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		session_variables {
+			current_user {http.auth.user.id}
+			tenant {http.request.header.X-Tenant}
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.SessionVariables["current_user"] != "{http.auth.user.id}" || h.SessionVariables["tenant"] != "{http.request.header.X-Tenant}" {
+		t.Errorf("SessionVariables = %v", h.SessionVariables)
+	}
+}
@@ -0,0 +1,150 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// shadowConcurrency caps the shadow reads running at once; sampled requests
+// beyond it are skipped rather than queued.
+const shadowConcurrency = 4
+
+// Shadow read results.
+const (
+	shadowMatch   = "match"   // same content, or missing from both
+	shadowChanged = "changed" // found in both, with different content
+	shadowMissing = "missing" // served, but not in the shadow database
+	shadowAdded   = "added"   // not found, but in the shadow database
+	shadowError   = "error"   // the shadow query failed
+	shadowSkipped = "skipped" // too many shadow reads running
+)
+
+// shadowReads counts the record lookups compared against the shadow
+// database, by result.
+var shadowReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "caddy_html_duckdb_shadow_reads_total",
+	Help: "Sampled record lookups repeated on shadow_database_path, by result (match, changed, missing, added, error, skipped).",
+}, []string{"instance", "result"})
+
+// shadow is the candidate database that record lookups are mirrored to.
+type shadow struct {
+	pool *dbPool
+	busy chan struct{}
+}
+
+// provisionShadow opens shadow_database_path read-only, sharing the pool
+// like the handler's own.
+func (h *HTMLFromDuckDB) provisionShadow(ctx context.Context, cfg poolConfig, settings poolSettings) error {
+	if h.ShadowDatabasePath == "" {
+		return nil
+	}
+	if h.ShadowSampleRate == 0 {
+		h.ShadowSampleRate = 0.1
+	}
+	if h.ShadowSampleRate < 0 || h.ShadowSampleRate > 1 {
+		return fmt.Errorf("shadow_sample_rate must be between 0 and 1")
+	}
+	if len(h.SessionVariables) > 0 {
+		return fmt.Errorf("shadow_database_path can't be combined with session_variables")
+	}
+	pool, err := h.acquireReadOnlyPool(ctx, cfg, settings, h.ShadowDatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to open shadow_database_path: %v", err)
+	}
+	h.shadow = &shadow{pool: pool, busy: make(chan struct{}, shadowConcurrency)}
+	return nil
+}
+
+// releaseShadow releases the shadow database's pool.
+func (h *HTMLFromDuckDB) releaseShadow() {
+	if h.shadow == nil {
+		return
+	}
+	if err := releasePool(h.shadow.pool); err != nil {
+		h.logger.Warn("failed to release shadow database", zap.Error(err))
+	}
+	h.shadow = nil
+}
+
+// shadowRecord repeats a sample of record lookups on the shadow database in
+// the background and counts how the result compares to what was served:
+// r is the request, for filter_params, found is whether the record was
+// found, and content its raw content. It never delays or changes the
+// response.
+func (h *HTMLFromDuckDB) shadowRecord(ctx context.Context, recordMacro, id string, r *http.Request, found bool, content sql.NullString) {
+	s := h.shadow
+	if s == nil || rand.Float64() >= h.ShadowSampleRate {
+		return
+	}
+	if _, selected := ctx.Value(selectedDatabaseKey{}).(*dbPool); selected {
+		return
+	}
+	select {
+	case s.busy <- struct{}{}:
+	default:
+		shadowReads.WithLabelValues(h.instanceName(), shadowSkipped).Inc()
+		return
+	}
+
+	contentColumn := h.HTMLColumn
+	if h.MarkdownColumn != "" {
+		contentColumn = h.MarkdownColumn
+	}
+	query, args := h.recordQuery(sanitizeIdentifier(contentColumn), recordMacro, id, r)
+	logger := h.log(ctx)
+	go func() {
+		defer func() { <-s.busy }()
+		ctx, cancel := h.queryContext(context.Background())
+		defer cancel()
+
+		var shadowContent sql.NullString
+		err := s.pool.db.QueryRowContext(ctx, query, args...).Scan(&shadowContent)
+		result := shadowMatch
+		switch {
+		case err == sql.ErrNoRows && found:
+			result = shadowMissing
+		case err == sql.ErrNoRows:
+		case err != nil:
+			result = shadowError
+		case !found:
+			result = shadowAdded
+		case shadowContent != content:
+			result = shadowChanged
+		}
+		shadowReads.WithLabelValues(h.instanceName(), result).Inc()
+		if result != shadowMatch {
+			fields := []zap.Field{zap.String("id", id), zap.String("result", result)}
+			if err != nil && err != sql.ErrNoRows {
+				fields = append(fields, zap.Error(err))
+			}
+			logger.Debug("shadow read diverged", fields...)
+		}
+	}()
+}
+
+// shadowTimeout bounds how long Cleanup waits for shadow reads to finish.
+const shadowTimeout = 5 * time.Second
+
+// drainShadow waits for running shadow reads, so their pool isn't closed
+// under them.
+func (h *HTMLFromDuckDB) drainShadow() {
+	s := h.shadow
+	if s == nil {
+		return
+	}
+	deadline := time.After(shadowTimeout)
+	for range shadowConcurrency {
+		select {
+		case s.busy <- struct{}{}:
+		case <-deadline:
+			return
+		}
+	}
+}
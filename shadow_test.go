@@ -0,0 +1,86 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestShadowReads(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &HTMLFromDuckDB{
+		Name:         "shadow-test",
+		DatabasePath: writeRecordsDB(t, map[string]string{"same": "<p>1</p>", "edited": "<p>old</p>", "dropped": "<p>3</p>"}),
+		ShadowDatabasePath: writeRecordsDB(t, map[string]string{
+			"same": "<p>1</p>", "edited": "<p>new</p>", "new": "<p>4</p>",
+		}),
+		ShadowSampleRate: 1,
+		Table:            "html",
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	before := map[string]float64{}
+	results := []string{shadowMatch, shadowChanged, shadowMissing, shadowAdded}
+	for _, result := range results {
+		before[result] = testutil.ToFloat64(shadowReads.WithLabelValues("shadow-test", result))
+	}
+	for _, id := range []string{"same", "edited", "dropped", "new", "neither"} {
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, nil), emptyNextHandler())
+		// Responses come from the primary database only.
+		if id == "new" && err == nil {
+			t.Errorf("new: served %q from the shadow database", rec.Body.String())
+		}
+		h.drainShadow()
+		h.shadow.busy = make(chan struct{}, shadowConcurrency)
+	}
+	for result, want := range map[string]float64{shadowMatch: 2, shadowChanged: 1, shadowMissing: 1, shadowAdded: 1} {
+		if got := testutil.ToFloat64(shadowReads.WithLabelValues("shadow-test", result)) - before[result]; got != want {
+			t.Errorf("%s = %v, want %v", result, got, want)
+		}
+	}
+}
+
+func TestProvision_ShadowErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := writeRecordsDB(t, nil)
+	for name, h := range map[string]*HTMLFromDuckDB{
+		"rate":              {Table: "html", ShadowDatabasePath: path, ShadowSampleRate: 2},
+		"session variables": {Table: "html", ShadowDatabasePath: path, SessionVariables: map[string]string{"u": "x"}},
+		"missing file":      {Table: "html", ShadowDatabasePath: filepath.Join(t.TempDir(), "none.duckdb")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseShadow(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		shadow_database_path /srv/next/works.duckdb
+		shadow_sample_rate 0.25
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.ShadowDatabasePath != "/srv/next/works.duckdb" || h.ShadowSampleRate != 0.25 {
+		t.Errorf("ShadowDatabasePath = %q, ShadowSampleRate = %v", h.ShadowDatabasePath, h.ShadowSampleRate)
+	}
+}
@@ -0,0 +1,437 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(DuckDB{})
+	httpcaddyfile.RegisterGlobalOption("duckdb", parseDuckDBApp)
+}
+
+// DuckDB is the duckdb app. It owns databases declared once, by name, that
+// handlers use with their database option, and probes their health in the
+// background. Its pools live in the same registry as the handlers', so a
+// reload that keeps a database's configuration keeps its open pool.
+type DuckDB struct {
+	// Databases are the shared databases, by name.
+	Databases map[string]*SharedDatabase `json:"databases,omitempty"`
+
+	logger *zap.Logger
+	stop   context.CancelFunc
+	done   *sync.WaitGroup
+}
+
+// SharedDatabase is a database of the duckdb app, with the options that
+// decide how its pool is opened and tuned.
+type SharedDatabase struct {
+	// Path is the DuckDB database file. Empty opens an in-memory database.
+	Path string `json:"path,omitempty"`
+
+	// ReadOnly opens the database in read-only mode.
+	// Default: true
+	ReadOnly *bool `json:"read_only,omitempty"`
+
+	// InitSQLFile is run on every new connection, as with the handler's
+	// init_sql_file.
+	InitSQLFile string `json:"init_sql_file,omitempty"`
+
+	// MemoryLimit, Threads, TempDirectory and MaxTempDirectorySize cap
+	// the database's footprint, as the handler options of the same names.
+	MemoryLimit          string `json:"memory_limit,omitempty"`
+	Threads              int    `json:"threads,omitempty"`
+	TempDirectory        string `json:"temp_directory,omitempty"`
+	MaxTempDirectorySize string `json:"max_temp_directory_size,omitempty"`
+
+	// Spatial loads the spatial extension, which handlers with a
+	// geojson_macro or tile_macro need.
+	Spatial bool `json:"spatial,omitempty"`
+
+	// PoolSize is the maximum number of open connections.
+	// Default: 10
+	PoolSize int `json:"pool_size,omitempty"`
+
+	// MaxIdleConns is the maximum number of idle connections.
+	// Default: PoolSize / 2
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+
+	// ConnMaxLifetime and ConnMaxIdleTime limit how long connections are
+	// kept, as the handler options of the same names.
+	// Default: "1h" and no idle limit
+	ConnMaxLifetime string `json:"conn_max_lifetime,omitempty"`
+	ConnMaxIdleTime string `json:"conn_max_idle_time,omitempty"`
+
+	// ProbeInterval is how often the database is queried in the
+	// background, with the result reported by the health checks of the
+	// handlers using it. "0" disables probing.
+	// Default: "30s"
+	ProbeInterval string `json:"probe_interval,omitempty"`
+
+	name     string
+	cfg      poolConfig
+	settings poolSettings
+	pool     *dbPool
+	interval time.Duration
+
+	mu    sync.Mutex
+	probe *CheckResult
+}
+
+// CaddyModule returns the Caddy module information.
+func (DuckDB) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "duckdb",
+		New: func() caddy.Module { return new(DuckDB) },
+	}
+}
+
+// Provision opens, or takes over from the previous config, the pools of
+// the databases.
+func (a *DuckDB) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger()
+	for name, db := range a.Databases {
+		if db == nil {
+			db = new(SharedDatabase)
+			a.Databases[name] = db
+		}
+		db.name = name
+		if err := db.provision(); err != nil {
+			a.Cleanup()
+			return fmt.Errorf("database %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// provision fills in the defaults and acquires the database's pool.
+func (db *SharedDatabase) provision() error {
+	if db.ReadOnly == nil {
+		readOnly := true
+		db.ReadOnly = &readOnly
+	}
+	if db.PoolSize == 0 {
+		db.PoolSize = 10
+	}
+	if db.MaxIdleConns == 0 {
+		db.MaxIdleConns = db.PoolSize / 2
+	}
+	if db.ConnMaxLifetime == "" {
+		db.ConnMaxLifetime = "1h"
+	}
+	if db.ProbeInterval == "" {
+		db.ProbeInterval = "30s"
+	}
+
+	maxLifetime, err := time.ParseDuration(db.ConnMaxLifetime)
+	if err != nil {
+		return fmt.Errorf("invalid conn_max_lifetime: %v", err)
+	}
+	var maxIdleTime time.Duration
+	if db.ConnMaxIdleTime != "" {
+		if maxIdleTime, err = time.ParseDuration(db.ConnMaxIdleTime); err != nil {
+			return fmt.Errorf("invalid conn_max_idle_time: %v", err)
+		}
+	}
+	db.interval, err = time.ParseDuration(db.ProbeInterval)
+	if err != nil || db.interval < 0 {
+		return fmt.Errorf("invalid probe_interval: %q", db.ProbeInterval)
+	}
+
+	connStr := db.Path
+	if connStr == "" {
+		connStr = ":memory:"
+	} else if *db.ReadOnly {
+		connStr += "?access_mode=READ_ONLY"
+	}
+	db.cfg = poolConfig{
+		connStr:     connStr,
+		initSQLFile: db.InitSQLFile,
+		initSQLHash: hashInitSQLFile(db.InitSQLFile),
+		spatial:     db.Spatial,
+		limits: resourceLimits{
+			memoryLimit:          db.MemoryLimit,
+			threads:              db.Threads,
+			tempDirectory:        db.TempDirectory,
+			maxTempDirectorySize: db.MaxTempDirectorySize,
+		},
+	}
+	db.settings = poolSettings{
+		maxOpen:     db.PoolSize,
+		maxIdle:     db.MaxIdleConns,
+		maxLifetime: maxLifetime,
+		maxIdleTime: maxIdleTime,
+	}
+	db.pool, _, err = acquirePool(db.cfg, db.settings)
+	return err
+}
+
+// Start probes the databases in the background, once right away and then
+// every probe_interval. A slow database doesn't hold up loading the config;
+// until its first probe completes, handlers report no probe result.
+func (a *DuckDB) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.stop, a.done = cancel, new(sync.WaitGroup)
+	for _, db := range a.Databases {
+		if db.interval == 0 {
+			continue
+		}
+		a.done.Add(1)
+		go func() {
+			defer a.done.Done()
+			a.probe(ctx, db)
+			a.runProbe(ctx, db)
+		}()
+	}
+	return nil
+}
+
+// Stop stops the probes. The pools stay open until Cleanup, so a reload
+// hands them to the new config.
+func (a *DuckDB) Stop() error {
+	if a.stop != nil {
+		a.stop()
+		a.done.Wait()
+	}
+	return nil
+}
+
+// Cleanup releases the app's references to the pools, closing those no
+// handler or newer config uses.
+func (a *DuckDB) Cleanup() error {
+	for name, db := range a.Databases {
+		if db == nil || db.pool == nil {
+			continue
+		}
+		if err := releasePool(db.pool); err != nil {
+			a.logger.Warn("failed to release database", zap.String("database", name), zap.Error(err))
+		}
+		db.pool = nil
+	}
+	return nil
+}
+
+// runProbe probes db every probe_interval until ctx is done.
+func (a *DuckDB) runProbe(ctx context.Context, db *SharedDatabase) {
+	ticker := time.NewTicker(db.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.probe(ctx, db)
+		}
+	}
+}
+
+// probe probes db, logging when it becomes unavailable and when it
+// recovers.
+func (a *DuckDB) probe(ctx context.Context, db *SharedDatabase) {
+	prev := db.lastProbe()
+	result := db.runProbeOnce(ctx)
+	switch {
+	case ctx.Err() != nil:
+	case result.Status != "ok" && (prev == nil || prev.Status == "ok"):
+		a.logger.Warn("database probe failed", zap.String("database", db.name), zap.String("error", result.Error))
+	case result.Status == "ok" && prev != nil && prev.Status != "ok":
+		a.logger.Info("database probe recovered", zap.String("database", db.name))
+	}
+}
+
+// runProbeOnce runs a trivial query on the database and records the result.
+func (db *SharedDatabase) runProbeOnce(ctx context.Context) *CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	var one int
+	err := db.pool.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+	result := &CheckResult{Status: "ok", Name: db.name, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status, result.Code, result.Error = "error", "probe_failed", err.Error()
+	}
+	db.mu.Lock()
+	db.probe = result
+	db.mu.Unlock()
+	return result
+}
+
+// lastProbe returns the result of the latest probe, or nil before the
+// first one.
+func (db *SharedDatabase) lastProbe() *CheckResult {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.probe
+}
+
+// checkSharedOptions returns an error if the handler sets an option that
+// belongs to its shared database. It runs before the handler's defaults
+// are filled in, so it sees only what was configured. Pool tuning from the
+// global defaults is exempt, as the database's tuning replaces it anyway.
+func (h *HTMLFromDuckDB) checkSharedOptions() error {
+	for _, c := range []struct {
+		set    bool
+		option string
+	}{
+		{h.DatabasePath != "", "database_path"},
+		{h.AttachReadOnly, "attach_read_only"},
+		{h.LoadIntoMemory, "load_into_memory"},
+		{h.Sync != nil, "sync"},
+		{len(h.Datasets) > 0, "datasets"},
+		{h.InitSQLFile != "", "init_sql_file"},
+		{h.MemoryLimit != "", "memory_limit"},
+		{h.Threads != 0, "threads"},
+		{h.TempDirectory != "", "temp_directory"},
+		{h.MaxTempDirectorySize != "", "max_temp_directory_size"},
+		{h.ContentPath != "", "content_path"},
+		{h.AnalyticsPoolSize > 0, "analytics_pool_size"},
+		{h.RecordPoolSize > 0 && !h.inherited["record_pool_size"], "record_pool_size"},
+		{h.ConnectionPoolSize != 0 && !h.inherited["connection_pool_size"], "connection_pool_size"},
+		{h.MaxIdleConns != 0 && !h.inherited["max_idle_conns"], "max_idle_conns"},
+		{h.ConnMaxLifetime != "" && !h.inherited["conn_max_lifetime"], "conn_max_lifetime"},
+		{h.ConnMaxIdleTime != "" && !h.inherited["conn_max_idle_time"], "conn_max_idle_time"},
+	} {
+		if c.set {
+			return fmt.Errorf("%s can't be combined with database; set it on the database in the duckdb app", c.option)
+		}
+	}
+	return nil
+}
+
+// sharedDatabase looks up the handler's database in the duckdb app and
+// takes read_only from it.
+func (h *HTMLFromDuckDB) sharedDatabase(ctx caddy.Context) (*SharedDatabase, error) {
+	app, err := ctx.AppIfConfigured("duckdb")
+	if errors.Is(err, caddy.ErrNotConfigured) {
+		return nil, fmt.Errorf("database %q: no duckdb app is configured", h.Database)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db, ok := app.(*DuckDB).Databases[h.Database]
+	if !ok {
+		return nil, fmt.Errorf("database %q is not defined in the duckdb app", h.Database)
+	}
+	if (h.GeoJSONMacro != "" || h.TileMacro != "") && !db.Spatial {
+		return nil, fmt.Errorf("database %q needs spatial for geojson_macro and tile_macro", h.Database)
+	}
+	h.ReadOnly = db.ReadOnly
+	return db, nil
+}
+
+// parseDuckDBApp parses the duckdb global option:
+//
+//	{
+//	    duckdb {
+//	        database <name> [<path>] {
+//	            path <path>
+//	            read_only <true|false>
+//	            init_sql_file <path>
+//	            memory_limit <size>
+//	            threads <n>
+//	            temp_directory <path>
+//	            max_temp_directory_size <size>
+//	            spatial <true|false>
+//	            pool_size <n>
+//	            max_idle_conns <n>
+//	            conn_max_lifetime <duration>
+//	            conn_max_idle_time <duration>
+//	            probe_interval <duration>
+//	        }
+//	    }
+//	}
+func parseDuckDBApp(d *caddyfile.Dispenser, existing any) (any, error) {
+	if existing != nil {
+		return nil, d.Err("duckdb global option given more than once")
+	}
+	app := DuckDB{Databases: make(map[string]*SharedDatabase)}
+	d.Next() // consume option name
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if d.Val() != "database" {
+			return nil, d.Errf("unrecognized duckdb subdirective: %s", d.Val())
+		}
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		name := d.Val()
+		if _, ok := app.Databases[name]; ok {
+			return nil, d.Errf("database %s defined more than once", name)
+		}
+		db, err := parseSharedDatabase(d)
+		if err != nil {
+			return nil, err
+		}
+		app.Databases[name] = db
+	}
+	return httpcaddyfile.App{Name: "duckdb", Value: caddyconfig.JSON(app, nil)}, nil
+}
+
+// parseSharedDatabase parses the optional path argument and block of a
+// database in the duckdb global option.
+func parseSharedDatabase(d *caddyfile.Dispenser) (*SharedDatabase, error) {
+	db := new(SharedDatabase)
+	if d.NextArg() {
+		db.Path = d.Val()
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		var err error
+		switch d.Val() {
+		case "path":
+			err = parseStringArg(d, &db.Path)
+		case "read_only":
+			var readOnly bool
+			err = parseBoolArg(d, &readOnly)
+			db.ReadOnly = &readOnly
+		case "init_sql_file":
+			err = parseStringArg(d, &db.InitSQLFile)
+		case "memory_limit":
+			err = parseStringArg(d, &db.MemoryLimit)
+		case "threads":
+			err = parseIntArg(d, &db.Threads)
+		case "temp_directory":
+			err = parseStringArg(d, &db.TempDirectory)
+		case "max_temp_directory_size":
+			err = parseStringArg(d, &db.MaxTempDirectorySize)
+		case "spatial":
+			err = parseBoolArg(d, &db.Spatial)
+		case "pool_size":
+			err = parseIntArg(d, &db.PoolSize)
+		case "max_idle_conns":
+			err = parseIntArg(d, &db.MaxIdleConns)
+		case "conn_max_lifetime":
+			err = parseDurationArg(d, &db.ConnMaxLifetime)
+		case "conn_max_idle_time":
+			err = parseDurationArg(d, &db.ConnMaxIdleTime)
+		case "probe_interval":
+			err = parseDurationArg(d, &db.ProbeInterval)
+		default:
+			return nil, d.Errf("unrecognized database subdirective: %s", d.Val())
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+	}
+	return db, nil
+}
+
+// Interface guards
+var (
+	_ caddy.App          = (*DuckDB)(nil)
+	_ caddy.Provisioner  = (*DuckDB)(nil)
+	_ caddy.CleanerUpper = (*DuckDB)(nil)
+)
@@ -0,0 +1,143 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+func TestDuckDBApp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('1', '<p>One</p>');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	cfg, _, err := caddyconfig.GetAdapter("caddyfile").Adapt([]byte(`{
+		admin off
+		duckdb {
+			database works `+path+` {
+				pool_size 4
+				probe_interval 1h
+			}
+		}
+	}`), nil)
+	if err != nil {
+		t.Fatalf("Adapt: %v", err)
+	}
+	if err := caddy.Load(cfg, false); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer caddy.Stop()
+	ctx := caddy.ActiveContext()
+
+	app, err := ctx.App("duckdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := app.(*DuckDB).Databases["works"]
+
+	h := &HTMLFromDuckDB{Database: "works", Table: "html", HealthEnabled: true}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	if h.pool != shared.pool {
+		t.Error("handler didn't use the app's pool")
+	}
+	if refs, _ := pools.References(shared.pool.key); refs != 2 {
+		t.Errorf("pool references = %d, want 2", refs)
+	}
+	if !*h.ReadOnly {
+		t.Error("read_only not taken from the database")
+	}
+
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/works/1", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+	if rec.Body.String() != "<p>One</p>" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+
+	// The first probe runs in the background when the app starts.
+	for deadline := time.Now().Add(5 * time.Second); shared.lastProbe() == nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec = httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_health", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("health: %v", err)
+	}
+	var health HealthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if probe := health.Checks["database_probe"]; probe == nil || probe.Status != "ok" {
+		t.Errorf("database_probe = %+v", probe)
+	}
+
+	for _, tc := range []struct {
+		h    *HTMLFromDuckDB
+		want string
+	}{
+		{&HTMLFromDuckDB{Database: "works", Table: "html", DatabasePath: path}, "database_path can't be combined with database"},
+		{&HTMLFromDuckDB{Database: "works", Table: "html", Threads: 2}, "threads can't be combined with database"},
+		{&HTMLFromDuckDB{Database: "works", Table: "html", ConnectionPoolSize: 50}, "connection_pool_size can't be combined with database"},
+		{&HTMLFromDuckDB{Database: "works", Table: "html", ConnMaxLifetime: "5m"}, "conn_max_lifetime can't be combined with database"},
+		{&HTMLFromDuckDB{Database: "other", Table: "html"}, `database "other" is not defined`},
+		{&HTMLFromDuckDB{Database: "works", Table: "html", GeoJSONMacro: "geo"}, "needs spatial"},
+	} {
+		if err := tc.h.Provision(ctx); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("error = %v, want %q", err, tc.want)
+			if err == nil {
+				tc.h.Cleanup()
+			}
+		}
+	}
+}
+
+func TestParseDuckDBApp(t *testing.T) {
+	for caddyfile, want := range map[string]string{
+		"{\n\tduckdb {\n\t\tdatabase a a.db\n\t\tdatabase a b.db\n\t}\n}":           "database a defined more than once",
+		"{\n\tduckdb {\n\t\tdatabase a {\n\t\t\tpool_size ten\n\t\t}\n\t}\n}":       `invalid pool_size: "ten" is not an integer`,
+		"{\n\tduckdb {\n\t\tdatabase a {\n\t\t\tread_only yes\n\t\t}\n\t}\n}":       `invalid read_only: "yes"`,
+		"{\n\tduckdb {\n\t\tpool_size 4\n\t}\n}":                                    "unrecognized duckdb subdirective: pool_size",
+		"{\n\tduckdb {\n\t\tdatabase a {\n\t\t\tprobe_interval soon\n\t\t}\n\t}\n}": `invalid probe_interval: "soon" is not a duration`,
+	} {
+		_, _, err := caddyconfig.GetAdapter("caddyfile").Adapt([]byte(caddyfile), nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+
+	cfg, _, err := caddyconfig.GetAdapter("caddyfile").Adapt([]byte(`{
+		duckdb {
+			database works {
+				path works.db
+				read_only false
+				spatial true
+			}
+		}
+	}`), nil)
+	if err != nil {
+		t.Fatalf("Adapt: %v", err)
+	}
+	if !strings.Contains(string(cfg), `"duckdb":{"databases":{"works":{"path":"works.db","read_only":false,"spatial":true}}}`) {
+		t.Errorf("config = %s", cfg)
+	}
+}
@@ -0,0 +1,137 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// LoadShedding turns requests away with 503 when too many are in flight,
+// low priority endpoints first, so a storm of expensive queries doesn't make
+// record pages unresponsive.
+type LoadShedding struct {
+	// LowPriorityLimit is the number of requests in flight above which
+	// requests to low priority endpoints (search, table, api, query, export,
+	// explain and changes) are shed. 0 means they are only shed at
+	// MaxRequests.
+	LowPriorityLimit int `json:"low_priority_limit,omitempty"`
+
+	// MaxRequests is the number of requests in flight above which requests
+	// to any endpoint are shed. 0 means no limit.
+	MaxRequests int `json:"max_requests,omitempty"`
+
+	// RetryAfter is sent in the Retry-After header of shed requests.
+	// Default: "5s"
+	RetryAfter string `json:"retry_after,omitempty"`
+}
+
+// shedRequests counts requests turned away by load shedding.
+var shedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "caddy_html_duckdb_shed_requests_total",
+	Help: "Requests rejected by load shedding, by priority (low, or all at max_requests).",
+}, []string{"instance", "priority"})
+
+// provisionLoadShedding checks the load_shedding configuration.
+func (h *HTMLFromDuckDB) provisionLoadShedding() error {
+	ls := h.LoadShedding
+	if ls == nil {
+		return nil
+	}
+	if ls.LowPriorityLimit < 0 || ls.MaxRequests < 0 {
+		return fmt.Errorf("load_shedding limits must not be negative")
+	}
+	if ls.LowPriorityLimit == 0 && ls.MaxRequests == 0 {
+		return fmt.Errorf("load_shedding requires low_priority_limit or max_requests")
+	}
+	if ls.MaxRequests > 0 && ls.LowPriorityLimit > ls.MaxRequests {
+		return fmt.Errorf("load_shedding low_priority_limit must not exceed max_requests")
+	}
+	if ls.RetryAfter == "" {
+		ls.RetryAfter = "5s"
+	}
+	retryAfter, err := time.ParseDuration(ls.RetryAfter)
+	if err != nil || retryAfter < 0 {
+		return fmt.Errorf("invalid load_shedding retry_after: %q", ls.RetryAfter)
+	}
+	h.shedRetry = retryAfter
+	return nil
+}
+
+// admitAny sheds any request once more than max_requests are in flight,
+// counting this one. It writes the 503 and returns false if the request
+// must not be served.
+func (h *HTMLFromDuckDB) admitAny(w http.ResponseWriter, r *http.Request) bool {
+	if h.LoadShedding == nil || h.drain == nil || h.LoadShedding.MaxRequests == 0 {
+		return true
+	}
+	return h.admitBelow(w, r, "all", h.LoadShedding.MaxRequests)
+}
+
+// admitLowPriority sheds a request to a low priority endpoint (search,
+// table, api, query, export, explain or changes) once more than
+// low_priority_limit requests are in flight. Call it after routing and
+// before running any query.
+func (h *HTMLFromDuckDB) admitLowPriority(w http.ResponseWriter, r *http.Request) bool {
+	if h.LoadShedding == nil || h.drain == nil || h.LoadShedding.LowPriorityLimit == 0 {
+		return true
+	}
+	return h.admitBelow(w, r, "low", h.LoadShedding.LowPriorityLimit)
+}
+
+// admitBelow serves the request if at most limit requests are in flight,
+// and otherwise answers 503 with Retry-After.
+func (h *HTMLFromDuckDB) admitBelow(w http.ResponseWriter, r *http.Request, priority string, limit int) bool {
+	inFlight := h.drain.active.Load()
+	if inFlight <= int64(limit) {
+		return true
+	}
+	shedRequests.WithLabelValues(h.instanceName(), priority).Inc()
+	h.log(r.Context()).Debug("request shed",
+		zap.String("priority", priority),
+		zap.Int64("in_flight", inFlight),
+		zap.Int("limit", limit))
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.shedRetry.Seconds()))))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, "server busy, retry later", http.StatusServiceUnavailable)
+	return false
+}
+
+// parseLoadShedding parses a load_shedding block. A single argument sets
+// low_priority_limit.
+func parseLoadShedding(d *caddyfile.Dispenser) (*LoadShedding, error) {
+	ls := &LoadShedding{}
+	if d.CountRemainingArgs() > 0 {
+		if err := parseIntArg(d, &ls.LowPriorityLimit); err != nil {
+			return nil, err
+		}
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "low_priority_limit":
+			if err := parseIntArg(d, &ls.LowPriorityLimit); err != nil {
+				return nil, err
+			}
+
+		case "max_requests":
+			if err := parseIntArg(d, &ls.MaxRequests); err != nil {
+				return nil, err
+			}
+
+		case "retry_after":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			ls.RetryAfter = d.Val()
+
+		default:
+			return nil, d.Errf("unrecognized load_shedding subdirective: %s", d.Val())
+		}
+	}
+	return ls, nil
+}
@@ -0,0 +1,121 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_LoadShedding(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html AS SELECT 'a' AS id, '<p>a</p>' AS html;
+		CREATE MACRO render_rows(base_path := '') AS TABLE SELECT 1 AS n;
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		Name:         "shed-test",
+		Table:        "html",
+		IDColumn:     "id",
+		HTMLColumn:   "html",
+		TableMacro:   "render_rows",
+		TablePath:    "_rows",
+		LoadShedding: &LoadShedding{LowPriorityLimit: 1, MaxRequests: 3, RetryAfter: "1500ms"},
+		db:           db,
+		drain:        newDrainState(),
+		logger:       zap.NewNop(),
+	}
+	if err := h.provisionLoadShedding(); err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP %s: %v", path, err)
+		}
+		return rec
+	}
+	low := shedRequests.WithLabelValues("shed-test", "low")
+	all := shedRequests.WithLabelValues("shed-test", "all")
+	lowBefore, allBefore := testutil.ToFloat64(low), testutil.ToFloat64(all)
+
+	if rec := get("/_rows"); rec.Code != http.StatusOK {
+		t.Errorf("idle table request: status = %d", rec.Code)
+	}
+
+	// Another request in flight: over low_priority_limit
+	h.drain.active.Add(1)
+	rec := get("/_rows")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("busy table request: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := get("/a"); rec.Code != http.StatusOK {
+		t.Errorf("busy record request: status = %d", rec.Code)
+	}
+
+	// Over max_requests, records are shed too
+	h.drain.active.Add(2)
+	if rec := get("/a"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("saturated record request: status = %d", rec.Code)
+	}
+	h.drain.active.Add(-3)
+
+	if got := testutil.ToFloat64(low) - lowBefore; got != 1 {
+		t.Errorf("low priority sheds = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(all) - allBefore; got != 1 {
+		t.Errorf("max_requests sheds = %v, want 1", got)
+	}
+}
+
+func TestProvision_LoadSheddingErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for name, ls := range map[string]*LoadShedding{
+		"no limits":            {},
+		"low above max":        {LowPriorityLimit: 10, MaxRequests: 5},
+		"negative":             {MaxRequests: -1},
+		"invalid retry_after":  {MaxRequests: 5, RetryAfter: "soon"},
+		"negative retry_after": {MaxRequests: 5, RetryAfter: "-1s"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := &HTMLFromDuckDB{Table: "html", LoadShedding: ls}
+			if err := h.Provision(ctx); err == nil {
+				h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestParseLoadShedding(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		load_shedding 20 {
+			max_requests 100
+			retry_after 10s
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	ls := h.LoadShedding
+	if ls == nil || ls.LowPriorityLimit != 20 || ls.MaxRequests != 100 || ls.RetryAfter != "10s" {
+		t.Errorf("LoadShedding = %+v", ls)
+	}
+}
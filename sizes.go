@@ -0,0 +1,88 @@
+package caddyhtmlduckdb
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// responseSizes is a histogram of response body sizes, exported with the
+// database info metrics.
+var responseSizes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "caddy_html_duckdb_response_size_bytes",
+	Help:    "Size of response bodies served by a handler, by endpoint.",
+	Buckets: prometheus.ExponentialBuckets(1<<10, 4, 9), // 1 KiB to 64 MiB
+}, []string{"instance", "endpoint"})
+
+// EndpointSizes sums up the responses an endpoint served.
+type EndpointSizes struct {
+	Responses    int64 `json:"responses"`
+	Bytes        int64 `json:"bytes"`
+	LargestBytes int64 `json:"largest_bytes"`
+}
+
+// servedSizes tracks response sizes per endpoint for the health check.
+type servedSizes struct {
+	mu        sync.Mutex
+	endpoints map[string]*EndpointSizes
+}
+
+func newServedSizes() *servedSizes {
+	return &servedSizes{endpoints: make(map[string]*EndpointSizes)}
+}
+
+func (s *servedSizes) add(endpoint string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.endpoints[endpoint]
+	if e == nil {
+		e = &EndpointSizes{}
+		s.endpoints[endpoint] = e
+	}
+	e.Responses++
+	e.Bytes += size
+	e.LargestBytes = max(e.LargestBytes, size)
+}
+
+func (s *servedSizes) snapshot() map[string]EndpointSizes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]EndpointSizes, len(s.endpoints))
+	for name, e := range s.endpoints {
+		out[name] = *e
+	}
+	return out
+}
+
+// serveAndMeasure serves the request and records the size of the response
+// body under the endpoint that served it. Failed requests are left out:
+// their body is written by Caddy's error handling, not by the handler.
+func (h *HTMLFromDuckDB) serveAndMeasure(w http.ResponseWriter, r *http.Request) error {
+	err := h.serveHTTP(w, r)
+	if clientGone(r.Context()) {
+		err = h.clientAborted(r, err)
+	}
+	info := requestInfoFrom(r.Context())
+	if err != nil || info == nil {
+		return err
+	}
+	endpoint := info.endpoint
+	if endpoint == "" {
+		endpoint = "record"
+	}
+	responseSizes.WithLabelValues(h.instanceName(), endpoint).Observe(float64(info.bytes))
+	if h.served != nil {
+		h.served.add(endpoint, info.bytes)
+	}
+	if h.WarnResponseSize > 0 && info.bytes > h.WarnResponseSize {
+		h.log(r.Context()).Warn("large response",
+			zap.String("endpoint", endpoint),
+			zap.String("path", r.URL.Path),
+			zap.String("id", info.id),
+			zap.Int64("bytes", info.bytes),
+			zap.Int64("warn_response_size", h.WarnResponseSize))
+	}
+	return nil
+}
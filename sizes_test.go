@@ -0,0 +1,108 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestServeHTTP_ResponseSizes(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('small', '<p>hi</p>'), ('big', repeat('x', 5000));
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE SELECT '<ul></ul>' AS html;
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	h := &HTMLFromDuckDB{
+		db:               db,
+		Name:             "sizes-test",
+		Table:            "html",
+		IDColumn:         "id",
+		HTMLColumn:       "html",
+		IndexEnabled:     true,
+		IndexMacro:       "render_index",
+		WarnResponseSize: 4096,
+		served:           newServedSizes(),
+		logger:           zap.New(core),
+	}
+	for _, path := range []string{"/small", "/big", "/big", "/", "/missing"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler())
+	}
+
+	served := h.served.snapshot()
+	if got := served["record"]; got != (EndpointSizes{Responses: 3, Bytes: 10009, LargestBytes: 5000}) {
+		t.Errorf("record = %+v", got)
+	}
+	if got := served["index"]; got.Responses != 1 || got.Bytes != 9 {
+		t.Errorf("index = %+v", got)
+	}
+	if _, ok := served["not_found"]; ok {
+		t.Error("failed requests should not be counted")
+	}
+
+	warnings := logs.FilterMessage("large response").All()
+	if len(warnings) != 2 || warnings[0].ContextMap()["id"] != "big" || warnings[0].ContextMap()["bytes"] != int64(5000) {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(responseSizes)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var count uint64
+	var sum float64
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["instance"] == "sizes-test" && labels["endpoint"] == "record" {
+				count, sum = m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	if count != 3 || sum != 10009 {
+		t.Errorf("histogram count = %d, sum = %v", count, sum)
+	}
+}
+
+func TestParseWarnResponseSize(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		warn_response_size 512KiB
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.WarnResponseSize != 512<<10 {
+		t.Errorf("WarnResponseSize = %d", h.WarnResponseSize)
+	}
+
+	d = caddyfile.NewTestDispenser(`html_from_duckdb {
+		warn_response_size lots
+	}`)
+	if err := (&HTMLFromDuckDB{}).UnmarshalCaddyfile(d); err == nil || !strings.Contains(err.Error(), "warn_response_size") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// querier runs queries: a pool, or a request's pinned connection or
+// transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// pinnedSnapshotKey is the context key of a request's snapshotPin.
+type pinnedSnapshotKey struct{}
+
+// snapshotPin is a request's connection, with its session variables set
+// and, with pin_snapshot, a read transaction begun. It is taken on the
+// request's first query, so that requests that don't query don't hold a
+// connection.
+type snapshotPin struct {
+	// ctx is the request's context; the connection is taken with it
+	// rather than a query's, whose timeout ends with the query.
+	ctx  context.Context
+	vars []sessionVariable
+
+	once sync.Once
+	conn *sql.Conn
+	tx   *sql.Tx
+	q    querier
+	// failed is set instead of conn if the session variables couldn't be
+	// set.
+	failed *sql.DB
+}
+
+// pinSnapshot makes the request's queries share one connection: to set
+// session_variables on it and, with pin_snapshot, one transaction, so that
+// a page composed of several queries (fragments, includes, headers, the
+// not found page) sees a single state of the database, even across a
+// replica swap. The returned function releases the connection.
+func (h *HTMLFromDuckDB) pinSnapshot(r *http.Request) (*http.Request, func()) {
+	if !h.PinSnapshot && len(h.SessionVariables) == 0 {
+		return r, func() {}
+	}
+	pin := &snapshotPin{ctx: r.Context(), vars: h.sessionValues(r)}
+	return r.WithContext(context.WithValue(r.Context(), pinnedSnapshotKey{}, pin)), func() {
+		if pin.tx != nil {
+			// Only reads ran, so there is nothing to commit.
+			pin.tx.Rollback()
+		}
+		if pin.conn != nil {
+			h.releaseConn(pin.conn, pin.vars)
+		}
+		if pin.failed != nil {
+			pin.failed.Close()
+		}
+	}
+}
+
+// queries returns what the endpoint's queries should run on: the request's
+// pinned connection, or requestDatabase(ctx, endpoint). Without session variables,
+// analytics endpoints run a single query on their own pool and are never
+// pinned.
+func (h *HTMLFromDuckDB) queries(ctx context.Context, endpoint string) querier {
+	pin, ok := ctx.Value(pinnedSnapshotKey{}).(*snapshotPin)
+	if !ok || (analyticsEndpoints[endpoint] && len(pin.vars) == 0) {
+		return h.requestDatabase(ctx, endpoint)
+	}
+	pin.once.Do(func() {
+		db := h.requestDatabase(ctx, endpoint)
+		conn, err := h.sessionConn(pin.ctx, db, pin.vars)
+		if err != nil {
+			h.logger.Warn("taking a connection for the request failed", zap.Error(err))
+			pin.q = db
+			if len(pin.vars) > 0 {
+				// Running the queries without the variables could show
+				// rows they filter out, so they fail instead.
+				pin.failed = failingDB(err)
+				pin.q = pin.failed
+			}
+			return
+		}
+		pin.conn, pin.q = conn, conn
+		if h.PinSnapshot {
+			if pin.tx, err = conn.BeginTx(pin.ctx, nil); err != nil {
+				h.logger.Warn("pinning snapshot failed, running unpinned", zap.Error(err))
+				pin.tx = nil
+				return
+			}
+			pin.q = pin.tx
+		}
+	})
+	return pin.q
+}
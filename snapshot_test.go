@@ -0,0 +1,88 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestPinSnapshot(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	initFile := writeInitSQL(t, `CREATE TABLE IF NOT EXISTS html AS SELECT 'a' AS id, '<p>a</p>' AS html`)
+	readWrite := false
+	h := &HTMLFromDuckDB{
+		DatabasePath: filepath.Join(t.TempDir(), "works.duckdb"),
+		ReadOnly:     &readWrite,
+		InitSQLFile:  initFile,
+		Table:        "html",
+		PinSnapshot:  true,
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	r, unpin := h.pinSnapshot(httptest.NewRequest(http.MethodGet, "/a", nil))
+	count := func(q querier) int {
+		t.Helper()
+		var n int
+		if err := q.QueryRowContext(r.Context(), "SELECT count(*) FROM html").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	pinned := h.queries(r.Context(), "record")
+	if n := count(pinned); n != 1 {
+		t.Fatalf("count = %d", n)
+	}
+	if _, err := h.database().Exec("INSERT INTO html VALUES ('b', '<p>b</p>')"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(h.queries(r.Context(), "record")); n != 1 {
+		t.Errorf("pinned request saw a later write: count = %d", n)
+	}
+	if h.queries(r.Context(), "record") != pinned {
+		t.Error("request's queries ran on different connections")
+	}
+	if h.queries(r.Context(), "table") == pinned {
+		t.Error("analytics endpoint pinned")
+	}
+	unpin()
+	if n := count(h.database()); n != 2 {
+		t.Errorf("unpinned count = %d", n)
+	}
+	if inUse := h.database().Stats().InUse; inUse != 0 {
+		t.Errorf("%d connections still in use after unpinning", inUse)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/b", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+	if rec.Body.String() != "<p>b</p>" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+	if inUse := h.database().Stats().InUse; inUse != 0 {
+		t.Errorf("%d connections still in use after the request", inUse)
+	}
+}
+
+func TestParsePinSnapshot(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		pin_snapshot true
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if !h.PinSnapshot {
+		t.Error("PinSnapshot not set")
+	}
+}
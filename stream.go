@@ -0,0 +1,160 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// defaultStreamBuffer is how much of a JSON or CSV result is buffered
+// before the response is streamed instead.
+const defaultStreamBuffer = 1 << 20
+
+// streamWriter buffers a response body up to a memory budget, so small
+// results are sent with a Content-Length and can still be replaced by an
+// error response. Once the body outgrows the budget, the headers are sent
+// and the rest is written through as rows are scanned. Writes then block
+// while the client is slow to read, which pauses the scan instead of
+// piling rows up in memory.
+type streamWriter struct {
+	w         http.ResponseWriter
+	budget    int64
+	buf       bytes.Buffer
+	streaming bool
+
+	// conditional, if set, is the request a buffered body is answered
+	// with an ETag for, and 304 if it matches.
+	conditional *http.Request
+}
+
+// newStreamWriter returns a streamWriter for w. A negative budget buffers
+// the whole body, zero means defaultStreamBuffer. The caller sets the
+// response headers before writing.
+func newStreamWriter(w http.ResponseWriter, budget int64) *streamWriter {
+	if budget == 0 {
+		budget = defaultStreamBuffer
+	}
+	return &streamWriter{w: w, budget: budget}
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.streaming {
+		return s.w.Write(p)
+	}
+	s.buf.Write(p)
+	if s.budget < 0 || int64(s.buf.Len()) <= s.budget {
+		return len(p), nil
+	}
+	// Truncation is only known at the end, so it goes in a trailer.
+	s.streaming = true
+	s.w.Header().Add("Trailer", "X-Truncated")
+	s.w.WriteHeader(http.StatusOK)
+	_, err := s.w.Write(s.buf.Bytes())
+	s.buf = bytes.Buffer{}
+	return len(p), err
+}
+
+// flush sends what has been written so far to the client, starting the
+// streamed response if it hasn't started yet.
+func (s *streamWriter) flush() error {
+	if !s.streaming {
+		s.streaming = true
+		s.w.Header().Add("Trailer", "X-Truncated")
+		s.w.WriteHeader(http.StatusOK)
+		if _, err := s.w.Write(s.buf.Bytes()); err != nil {
+			return err
+		}
+		s.buf = bytes.Buffer{}
+	}
+	if err := http.NewResponseController(s.w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// started reports whether the response has been committed, so a failure
+// can no longer be reported with an error status.
+func (s *streamWriter) started() bool {
+	return s.streaming
+}
+
+// finish completes the response: a buffered body is sent with its
+// Content-Length (and ETag, for a conditional request), and omitted rows are reported in X-Truncated, as a
+// header or, for a streamed body, a trailer.
+func (s *streamWriter) finish(omitted int) error {
+	if omitted > 0 {
+		s.w.Header().Set("X-Truncated", strconv.Itoa(omitted))
+	}
+	if s.streaming {
+		return nil
+	}
+	if s.conditional != nil && notModified(s.w, s.conditional, generateETag(s.buf.String())) {
+		return nil
+	}
+	s.w.Header().Set("Content-Length", strconv.Itoa(s.buf.Len()))
+	s.w.WriteHeader(http.StatusOK)
+	_, err := s.w.Write(s.buf.Bytes())
+	return err
+}
+
+// abort ends a streamed response that failed part way. The connection is
+// closed without completing the body, so the client sees a failed
+// transfer rather than a cut-off result that looks complete.
+func (s *streamWriter) abort() {
+	panic(http.ErrAbortHandler)
+}
+
+// serveTableRows serves the table macro's result as JSON or CSV, streamed
+// once it outgrows stream_buffer, or as NDJSON, flushed every
+// ndjson_flush_rows rows.
+func (h *HTMLFromDuckDB) serveTableRows(ctx context.Context, w http.ResponseWriter, r *http.Request, format, query string) error {
+	start := time.Now()
+	rows, err := h.queryRetry(ctx, "table", h.queries(ctx, "table"), query)
+	if err != nil {
+		h.logFailure(ctx, "table macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Cache-Control", h.tableCacheControl())
+	sw := newStreamWriter(w, h.StreamBuffer)
+	if h.TableETag {
+		sw.conditional = r
+	}
+	var omitted int
+	switch format {
+	case formatJSON:
+		omitted, err = writeJSONRows(sw, rows, h.ValueEncoding, h.TableMaxRows)
+	case formatNDJSON:
+		omitted, err = writeNDJSONRows(sw, rows, h.ValueEncoding, h.TableMaxRows, h.NDJSONFlushRows, sw.flush)
+	default:
+		omitted, err = writeCSVRows(sw, rows, h.ValueEncoding, h.TableMaxRows)
+	}
+	h.observeQuery(ctx, "table", query, time.Since(start))
+	if err != nil {
+		if sw.started() {
+			if clientGone(ctx) {
+				return nil
+			}
+			h.log(ctx).Error("table stream failed", zap.Error(err))
+			sw.abort()
+		}
+		w.Header().Del("Content-Type")
+		h.logFailure(ctx, "table macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if omitted > 0 {
+		h.log(ctx).Warn("table output truncated",
+			zap.String("macro", h.TableMacro),
+			zap.Int("max_rows", h.TableMaxRows),
+			zap.Int("omitted_rows", omitted))
+	}
+	return sw.finish(omitted)
+}
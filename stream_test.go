@@ -0,0 +1,254 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestStreamWriter(t *testing.T) {
+	t.Run("buffered", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		sw := newStreamWriter(rec, 10)
+		sw.Write([]byte("0123456789"))
+		if sw.started() || rec.Body.Len() != 0 {
+			t.Fatal("response committed within budget")
+		}
+		if err := sw.finish(2); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Header().Get("Content-Length") != "10" || rec.Header().Get("X-Truncated") != "2" || rec.Body.String() != "0123456789" {
+			t.Errorf("headers = %v, body = %q", rec.Header(), rec.Body.String())
+		}
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		sw := newStreamWriter(rec, 10)
+		sw.Write([]byte("0123456789"))
+		sw.Write([]byte("a"))
+		if !sw.started() || rec.Body.String() != "0123456789a" {
+			t.Fatalf("started = %v, body = %q", sw.started(), rec.Body.String())
+		}
+		sw.Write([]byte("b"))
+		if err := sw.finish(3); err != nil {
+			t.Fatal(err)
+		}
+		res := rec.Result()
+		if res.Header.Get("Content-Length") != "" || res.Trailer.Get("X-Truncated") != "3" || rec.Body.String() != "0123456789ab" {
+			t.Errorf("headers = %v, trailers = %v, body = %q", res.Header, res.Trailer, rec.Body.String())
+		}
+	})
+
+	t.Run("never", func(t *testing.T) {
+		sw := newStreamWriter(httptest.NewRecorder(), -1)
+		sw.Write(make([]byte, 2*defaultStreamBuffer))
+		if sw.started() {
+			t.Error("a negative budget should buffer everything")
+		}
+	})
+}
+
+func TestServeHTTP_QueryStream(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	h := &HTMLFromDuckDB{
+		Table:         "html",
+		QueryPath:     "_query",
+		AuthTokens:    []string{"secret"},
+		QueryMaxRows:  -1,
+		QueryMaxBytes: -1,
+		StreamBuffer:  1024,
+		db:            db,
+		logger:        zap.NewNop(),
+	}
+	run := func(t *testing.T, sqlText string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/_query?sql="+url.QueryEscape(sqlText), nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("large results are streamed", func(t *testing.T) {
+		rec := run(t, "SELECT i, 'row ' || i AS label FROM range(100000) t(i)")
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "" {
+			t.Fatalf("status = %d, headers = %v", rec.Code, rec.Header())
+		}
+		var got []map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(got) != 100000 || got[99999]["label"] != "row 99999" {
+			t.Errorf("got %d rows", len(got))
+		}
+	})
+
+	t.Run("small results are buffered", func(t *testing.T) {
+		rec := run(t, "SELECT 1 AS one")
+		if rec.Header().Get("Content-Length") == "" {
+			t.Errorf("headers = %v", rec.Header())
+		}
+	})
+
+	t.Run("failure after streaming aborts", func(t *testing.T) {
+		h.QueryMaxBytes = 4096
+		defer func() { h.QueryMaxBytes = -1 }()
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+			}
+		}()
+		run(t, "SELECT i FROM range(100000) t(i)")
+		t.Error("expected the response to be aborted")
+	})
+}
+
+func TestServeHTTP_TableStream(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE MACRO render_rows(n := 10, base_path := '') AS TABLE
+		SELECT i AS id, 'Item ' || i AS name FROM range(n) t(i)
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		Table:        "html",
+		TableMacro:   "render_rows",
+		TablePath:    "_rows",
+		TableMaxRows: 50000,
+		StreamBuffer: 1024,
+		db:           db,
+		logger:       zap.NewNop(),
+
+		NDJSONFlushRows: 3,
+	}
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		rec := get(t, "/_rows?n=60000&format=csv")
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("invalid CSV: %v", err)
+		}
+		if len(records) != 50001 || strings.Join(records[1], ",") != "0,Item 0" {
+			t.Errorf("got %d records, first %q", len(records), records[1])
+		}
+		if got := rec.Result().Trailer.Get("X-Truncated"); got != "10000" {
+			t.Errorf("X-Truncated trailer = %q", got)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		rec := get(t, "/_rows?n=2&format=json")
+		if rec.Body.String() != "[\n{\"id\":0,\"name\":\"Item 0\"},\n{\"id\":1,\"name\":\"Item 1\"}\n]\n" {
+			t.Errorf("body = %q", rec.Body.String())
+		}
+		if rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("Content-Length") == "" {
+			t.Errorf("headers = %v", rec.Header())
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		rec := get(t, "/_rows?n=2&format=ndjson")
+		if rec.Body.String() != "{\"id\":0,\"name\":\"Item 0\"}\n{\"id\":1,\"name\":\"Item 1\"}\n" {
+			t.Errorf("body = %q", rec.Body.String())
+		}
+		if rec.Header().Get("Content-Type") != "application/x-ndjson" || rec.Header().Get("Content-Length") == "" || rec.Flushed {
+			t.Errorf("fewer rows than ndjson_flush_rows: headers = %v, flushed = %v", rec.Header(), rec.Flushed)
+		}
+
+		// Streamed from the first flush, though far below stream_buffer.
+		rec = get(t, "/_rows?n=7&format=ndjson")
+		if lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n"); len(lines) != 7 || lines[6] != `{"id":6,"name":"Item 6"}` {
+			t.Errorf("lines = %q", lines)
+		}
+		if !rec.Flushed || rec.Header().Get("Content-Length") != "" {
+			t.Errorf("not streamed: headers = %v, flushed = %v", rec.Header(), rec.Flushed)
+		}
+	})
+}
+
+func TestWriteNDJSONRows_Flush(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT i FROM range(10) t(i)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var buf strings.Builder
+	var flushed []int
+	omitted, err := writeNDJSONRows(&buf, rows, nil, 8, 3, func() error {
+		flushed = append(flushed, strings.Count(buf.String(), "\n"))
+		return nil
+	})
+	if err != nil || omitted != 2 {
+		t.Fatalf("omitted = %d, err = %v", omitted, err)
+	}
+	// Each flush sees every row written before it.
+	if fmt.Sprint(flushed) != "[3 6]" || strings.Count(buf.String(), "\n") != 8 {
+		t.Errorf("flushed after %v lines, wrote %q", flushed, buf.String())
+	}
+}
+
+func TestParseStreamBuffer(t *testing.T) {
+	for value, want := range map[string]int64{"64KB": 64000, "-1": -1} {
+		d := caddyfile.NewTestDispenser(`html_from_duckdb {
+			stream_buffer ` + value + `
+		}`)
+		h := &HTMLFromDuckDB{}
+		if err := h.UnmarshalCaddyfile(d); err != nil {
+			t.Fatalf("UnmarshalCaddyfile: %v", err)
+		}
+		if h.StreamBuffer != want {
+			t.Errorf("stream_buffer %s: StreamBuffer = %d, want %d", value, h.StreamBuffer, want)
+		}
+	}
+}
+
+func TestParseNDJSONFlushRows(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		ndjson_flush_rows 500
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.NDJSONFlushRows != 500 {
+		t.Errorf("NDJSONFlushRows = %d, want 500", h.NDJSONFlushRows)
+	}
+}
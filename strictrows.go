@@ -0,0 +1,65 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Modes for strict_rows.
+const (
+	strictRowsWarn  = "warn"
+	strictRowsError = "error"
+)
+
+// queryRow runs a query that should return a single row and scans its first
+// row into dest, returning sql.ErrNoRows if there is none. With strict_rows
+// the remaining rows are counted, and a query that returned more than one is
+// logged, or with "error" fails, since which row comes first is then up to
+// DuckDB's plan. Transient failures are retried.
+func (h *HTMLFromDuckDB) queryRow(ctx context.Context, endpoint, query string, args []any, dest ...any) error {
+	return h.retryTransient(ctx, endpoint, func() error {
+		return h.queryRowOnce(ctx, endpoint, query, args, dest...)
+	})
+}
+
+// queryRowOnce runs queryRow's query a single time.
+func (h *HTMLFromDuckDB) queryRowOnce(ctx context.Context, endpoint, query string, args []any, dest ...any) error {
+	if h.StrictRows == "" {
+		return h.queries(ctx, endpoint).QueryRowContext(ctx, tagQuery(ctx, query), args...).Scan(dest...)
+	}
+
+	rows, err := h.queries(ctx, endpoint).QueryContext(ctx, tagQuery(ctx, query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	n := 1
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if n > 1 {
+		h.log(ctx).Warn("query returned more than one row",
+			zap.String("endpoint", endpoint),
+			zap.Int("rows", n),
+			zap.String("sql", truncateForLog(query, 200)))
+		if h.StrictRows == strictRowsError {
+			return fmt.Errorf("%s query returned %d rows, expected one", endpoint, n)
+		}
+	}
+	return nil
+}
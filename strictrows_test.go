@@ -0,0 +1,130 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestServeHTTP_StrictRows(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('w1', '<p>one</p>'), ('w1', '<p>duplicate</p>'), ('w2', '<p>two</p>');
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE SELECT html FROM html;
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	serve := func(t *testing.T, mode, path string) (int, *observer.ObservedLogs, error) {
+		t.Helper()
+		core, logs := observer.New(zapcore.WarnLevel)
+		h := &HTMLFromDuckDB{
+			db:           db,
+			Table:        "html",
+			IDColumn:     "id",
+			HTMLColumn:   "html",
+			IndexEnabled: true,
+			IndexMacro:   "render_index",
+			StrictRows:   mode,
+			logger:       zap.New(core),
+		}
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler())
+		return rec.Code, logs, err
+	}
+	rowCount := func(logs *observer.ObservedLogs) int64 {
+		entries := logs.FilterMessage("query returned more than one row").All()
+		if len(entries) != 1 {
+			return 0
+		}
+		n, _ := entries[0].ContextMap()["rows"].(int64)
+		return n
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		status, logs, err := serve(t, "", "/w1")
+		if err != nil || status != http.StatusOK || logs.Len() != 0 {
+			t.Errorf("status = %d, err = %v, logs = %d", status, err, logs.Len())
+		}
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		status, logs, err := serve(t, strictRowsWarn, "/w1")
+		if err != nil || status != http.StatusOK {
+			t.Fatalf("status = %d, err = %v", status, err)
+		}
+		if n := rowCount(logs); n != 2 {
+			t.Errorf("logged row count = %d, want 2", n)
+		}
+	})
+
+	t.Run("warn on index", func(t *testing.T) {
+		_, logs, err := serve(t, strictRowsWarn, "/")
+		if err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		if n := rowCount(logs); n != 3 {
+			t.Errorf("logged row count = %d, want 3", n)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, _, err := serve(t, strictRowsError, "/w1")
+		var herr caddyhttp.HandlerError
+		if !errors.As(err, &herr) || herr.StatusCode != http.StatusInternalServerError || !strings.Contains(err.Error(), "returned 2 rows") {
+			t.Errorf("err = %v, want 500", err)
+		}
+	})
+
+	t.Run("single and missing rows", func(t *testing.T) {
+		if status, logs, err := serve(t, strictRowsError, "/w2"); err != nil || status != http.StatusOK || logs.Len() != 0 {
+			t.Errorf("w2: status = %d, err = %v", status, err)
+		}
+		_, _, err := serve(t, strictRowsError, "/w3")
+		var herr caddyhttp.HandlerError
+		if !errors.As(err, &herr) || herr.StatusCode != http.StatusNotFound {
+			t.Errorf("w3: err = %v, want 404", err)
+		}
+	})
+}
+
+func TestProvision_InvalidStrictRows(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &HTMLFromDuckDB{Table: "html", StrictRows: "panic"}
+	if err := h.Provision(ctx); err == nil {
+		h.Cleanup()
+		t.Fatal("expected Provision to fail")
+	}
+}
+
+func TestParseStrictRows(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		strict_rows error
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.StrictRows != strictRowsError {
+		t.Errorf("StrictRows = %q", h.StrictRows)
+	}
+}
@@ -0,0 +1,490 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// replicaCloseDelay is how long a replaced replica pool stays open, so
+// requests that picked it up just before a swap can finish.
+var replicaCloseDelay = time.Minute
+
+// Sync turns the handler into a read replica: the database is downloaded
+// from Source on a schedule and swapped in when it has changed. Each copy is
+// stored next to database_path as <database_path>.<sha256 prefix>, and
+// database_path becomes a symlink to the one in use, so a restart serves
+// the last good copy right away.
+type Sync struct {
+	// Source is an http(s) URL to download the database file from, such as
+	// another instance's export_path, or a path DuckDB can attach (s3://,
+	// gs://, a local file), which is copied with COPY FROM DATABASE using the
+	// extensions and secrets set up by init_sql_file.
+	Source string `json:"source"`
+
+	// Interval is how often Source is checked.
+	// Default: "5m"
+	Interval string `json:"interval,omitempty"`
+
+	// Token is sent as a bearer token with http(s) requests. Optional.
+	Token string `json:"token,omitempty"`
+
+	// Checksum is the URL of the file's SHA-256 checksum (hex, optionally
+	// followed by a file name as written by sha256sum). Downloads that don't
+	// match are discarded. A Repr-Digest header in the download response is
+	// always checked. Optional.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Timeout bounds each sync: the download and checksum, or the copy. A
+	// source that doesn't answer in time fails the sync, which is tried
+	// again at the next interval.
+	// Default: "10m"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// replica is the state of a handler that swaps copies of its database in:
+// a read replica (sync) or an in-memory copy (load_into_memory, where
+// config is nil).
+type replica struct {
+	config   *Sync
+	interval time.Duration
+	timeout  time.Duration
+	pool     poolConfig
+	settings poolSettings
+	current  atomic.Pointer[dbPool]
+	stop     context.CancelFunc
+
+	// mu serializes syncs and guards the fields below. sum identifies the
+	// copy in use: the source's SHA-256, or for an in-memory copy the file's
+	// fileStamp.
+	mu   sync.Mutex
+	sum  string
+	etag string
+}
+
+// database returns the pool queries should use. For replicas it is the most
+// recently synced or loaded copy, which may change between calls.
+func (h *HTMLFromDuckDB) database() *sql.DB {
+	if h.replica != nil {
+		return h.replica.current.Load().db
+	}
+	return h.db
+}
+
+// currentPool returns the replica's current pool or the shared pool.
+func (h *HTMLFromDuckDB) currentPool() *dbPool {
+	if h.replica != nil {
+		return h.replica.current.Load()
+	}
+	return h.pool
+}
+
+// provisionSync opens the local copy of a replica, fetching it first if
+// there is none yet, and starts syncing it every interval until Cleanup.
+func (h *HTMLFromDuckDB) provisionSync(ctx context.Context, cfg poolConfig, settings poolSettings) error {
+	if h.DatabasePath == "" {
+		return fmt.Errorf("sync requires database_path")
+	}
+	if !*h.ReadOnly {
+		return fmt.Errorf("sync requires read_only")
+	}
+	if h.Sync.Source == "" {
+		return fmt.Errorf("sync requires a source")
+	}
+	if h.Sync.Interval == "" {
+		h.Sync.Interval = "5m"
+	}
+	interval, err := time.ParseDuration(h.Sync.Interval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid sync interval: %q", h.Sync.Interval)
+	}
+	if h.Sync.Timeout == "" {
+		h.Sync.Timeout = "10m"
+	}
+	timeout, err := time.ParseDuration(h.Sync.Timeout)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid sync timeout: %q", h.Sync.Timeout)
+	}
+	rep := &replica{config: h.Sync, interval: interval, timeout: timeout, pool: cfg, settings: settings}
+	h.replica = rep
+
+	path, err := filepath.EvalSymlinks(h.DatabasePath)
+	if os.IsNotExist(err) {
+		h.logger.Info("no local replica yet, syncing", zap.String("source", h.Sync.Source))
+		if _, err := h.syncReplica(ctx); err != nil {
+			h.replica = nil
+			return fmt.Errorf("initial sync failed: %v", err)
+		}
+	} else if err != nil {
+		h.replica = nil
+		return err
+	} else {
+		pool, err := h.openReplica(path)
+		if err != nil {
+			h.replica = nil
+			return err
+		}
+		rep.current.Store(pool)
+	}
+
+	syncCtx, stop := context.WithCancel(ctx)
+	rep.stop = stop
+	// A copy left from an earlier run may be stale, so check the source right
+	// away; a fresh initial sync can wait for the first tick.
+	syncNow := rep.sum == ""
+	go func() {
+		if syncNow {
+			rep.mu.Lock()
+			if sum, err := hashFile(path); err == nil && rep.sum == "" {
+				rep.sum = sum
+			}
+			rep.mu.Unlock()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if syncNow {
+				if _, err := h.syncReplica(syncCtx); err != nil && syncCtx.Err() == nil {
+					h.logger.Warn("sync failed", zap.String("source", h.Sync.Source), zap.Error(err))
+				}
+			}
+			syncNow = true
+			select {
+			case <-syncCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// closeReplica stops syncing and closes the current replica pool.
+func (h *HTMLFromDuckDB) closeReplica() error {
+	if h.replica.stop != nil {
+		h.replica.stop()
+	}
+	if pool := h.replica.current.Load(); pool != nil {
+		return pool.Destruct()
+	}
+	return nil
+}
+
+// syncReplica fetches the source and, if it changed and passes validation,
+// swaps it in. It reports whether it did.
+func (h *HTMLFromDuckDB) syncReplica(ctx context.Context) (bool, error) {
+	rep := h.replica
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	// Without a deadline a hanging source would hold up Provision on the
+	// initial sync, and rep.mu afterwards.
+	ctx, cancel := context.WithTimeout(ctx, rep.timeout)
+	defer cancel()
+	dir, base := filepath.Split(h.DatabasePath)
+	tmp, err := os.CreateTemp(dir, "."+base+".sync-*")
+	if err != nil {
+		return false, err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	var sum string
+	if isHTTPURL(rep.config.Source) {
+		var changed bool
+		sum, changed, err = h.downloadReplica(ctx, tmpPath)
+		if err != nil || !changed {
+			return false, err
+		}
+	} else {
+		if err := h.copyReplica(ctx, tmpPath); err != nil {
+			return false, err
+		}
+		if sum, err = hashFile(tmpPath); err != nil {
+			return false, err
+		}
+	}
+	if sum == rep.sum {
+		return false, nil
+	}
+
+	path := h.DatabasePath + "." + sum[:12]
+	if err := os.Rename(tmpPath, path); err != nil {
+		return false, err
+	}
+	pool, err := h.openReplica(path)
+	if err != nil {
+		os.Remove(path)
+		return false, fmt.Errorf("invalid database: %v", err)
+	}
+	if err := replaceSymlink(h.DatabasePath, filepath.Base(path)); err != nil {
+		pool.Destruct()
+		os.Remove(path)
+		return false, err
+	}
+
+	old := rep.current.Swap(pool)
+	rep.sum = sum
+	if old != nil {
+		oldPath := strings.TrimSuffix(old.key.connStr, "?access_mode=READ_ONLY")
+		time.AfterFunc(replicaCloseDelay, func() {
+			old.Destruct()
+			// The same content may have come back in the meantime.
+			if rep.current.Load().key.connStr != old.key.connStr && strings.HasPrefix(oldPath, h.DatabasePath+".") {
+				os.Remove(oldPath)
+			}
+		})
+	}
+	h.flushCaches()
+	h.logger.Info("replica synced",
+		zap.String("source", rep.config.Source),
+		zap.String("database", path),
+		zap.String("sha256", sum))
+	h.notify(eventDatabaseSwap, map[string]any{"database": h.DatabasePath, "source": rep.config.Source, "sha256": sum})
+	h.audit(ctx, auditDatabaseSwap, "sync", nil, map[string]any{"database": path, "source": rep.config.Source, "sha256": sum})
+	return true, nil
+}
+
+// openReplica opens a pool on the database file at path and checks that it
+// has the handler's table.
+func (h *HTMLFromDuckDB) openReplica(path string) (*dbPool, error) {
+	cfg := h.replica.pool
+	cfg.connStr = path + "?access_mode=READ_ONLY"
+	pool, err := openPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	if err := pool.db.QueryRow("SELECT count(*) FROM " + sanitizeIdentifier(h.Table)).Scan(&n); err != nil {
+		pool.Destruct()
+		return nil, err
+	}
+	pool.apply(h.replica.settings)
+	return pool, nil
+}
+
+// downloadReplica downloads an http(s) source to path and verifies its
+// checksum. It reports false if the source says nothing has changed.
+func (h *HTMLFromDuckDB) downloadReplica(ctx context.Context, path string) (string, bool, error) {
+	rep := h.replica
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rep.config.Source, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if rep.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+rep.config.Token)
+	}
+	if rep.etag != "" {
+		req.Header.Set("If-None-Match", rep.etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("download failed: status %d", resp.StatusCode)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", false, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", false, err
+	}
+	digest := hash.Sum(nil)
+	sum := hex.EncodeToString(digest)
+
+	if want, ok := reprDigestSHA256(resp.Header.Get("Repr-Digest")); ok && want != base64.StdEncoding.EncodeToString(digest) {
+		return "", false, fmt.Errorf("checksum mismatch: Repr-Digest %s", want)
+	}
+	if rep.config.Checksum != "" {
+		want, err := h.fetchChecksum(ctx)
+		if err != nil {
+			return "", false, fmt.Errorf("checksum: %v", err)
+		}
+		if want != sum {
+			return "", false, fmt.Errorf("checksum mismatch: expected %s, got %s", want, sum)
+		}
+	}
+	rep.etag = resp.Header.Get("ETag")
+	return sum, true, nil
+}
+
+// fetchChecksum returns the hex SHA-256 published at the checksum URL.
+func (h *HTMLFromDuckDB) fetchChecksum(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.replica.config.Checksum, nil)
+	if err != nil {
+		return "", err
+	}
+	if h.replica.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.replica.config.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("no SHA-256 checksum found")
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", fmt.Errorf("no SHA-256 checksum found")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// reprDigestSHA256 returns the base64 sha-256 value of a Repr-Digest header.
+func reprDigestSHA256(header string) (string, bool) {
+	for _, item := range strings.Split(header, ",") {
+		alg, value, found := strings.Cut(strings.TrimSpace(item), "=")
+		if found && strings.EqualFold(alg, "sha-256") {
+			return strings.Trim(value, ":"), true
+		}
+	}
+	return "", false
+}
+
+// copyReplica copies a source DuckDB can attach (s3://, gs://, a local
+// file) into a new database file at path.
+func (h *HTMLFromDuckDB) copyReplica(ctx context.Context, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// An in-memory database with the handler's init SQL, which sets up
+	// httpfs and the credentials to reach the source.
+	cfg := h.replica.pool
+	cfg.connStr = ""
+	pool, err := openPool(cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Destruct()
+	conn, err := pool.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		fmt.Sprintf("ATTACH '%s' AS sync_source (READ_ONLY)", escapeSQLString(h.replica.config.Source)),
+		fmt.Sprintf("ATTACH '%s' AS sync_target", escapeSQLString(path)),
+		"COPY FROM DATABASE sync_source TO sync_target",
+		"DETACH sync_target",
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceSymlink atomically points the symlink at path to target, replacing
+// whatever is at path.
+func replaceSymlink(path, target string) error {
+	dir, base := filepath.Split(path)
+	link := filepath.Join(dir, fmt.Sprintf(".%s.link-%d", base, time.Now().UnixNano()))
+	if err := os.Symlink(target, link); err != nil {
+		return err
+	}
+	if err := os.Rename(link, path); err != nil {
+		os.Remove(link)
+		return err
+	}
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// parseSync parses a sync directive:
+//
+//	sync <source> {
+//	    interval <duration>
+//	    token <token>
+//	    checksum <url>
+//	    timeout <duration>
+//	}
+func parseSync(d *caddyfile.Dispenser) (*Sync, error) {
+	s := &Sync{}
+	if d.NextArg() {
+		s.Source = d.Val()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "source":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			s.Source = d.Val()
+
+		case "interval":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			s.Interval = d.Val()
+
+		case "token":
+			if d.NextArg() {
+				s.Token = d.Val()
+			}
+			// No error if empty - allows {$SYNC_TOKEN:} with empty default
+
+		case "checksum":
+			if d.NextArg() {
+				s.Checksum = d.Val()
+			}
+			// No error if empty - allows {$SYNC_CHECKSUM:} with empty default
+
+		case "timeout":
+			if err := parseDurationArg(d, &s.Timeout); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, d.Errf("unrecognized sync subdirective: %s", d.Val())
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,348 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// writeSyncSource writes a DuckDB file with a single html row and returns
+// its content.
+func writeSyncSource(t *testing.T, html string) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "source.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE html (id VARCHAR, html VARCHAR); INSERT INTO html VALUES ('a', ?)`, html)
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestSync(t *testing.T) {
+	replicaCloseDelay = 0
+	defer func() { replicaCloseDelay = time.Minute }()
+
+	var content atomic.Pointer[[]byte]
+	var digest atomic.Pointer[string]
+	setSource := func(b []byte) {
+		sum := sha256.Sum256(b)
+		d := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+		content.Store(&b)
+		digest.Store(&d)
+	}
+	var checksum atomic.Pointer[string]
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/db.sha256" {
+			w.Write([]byte(*checksum.Load() + "  db.duckdb\n"))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Repr-Digest", *digest.Load())
+		w.Write(*content.Load())
+	}))
+	defer srv.Close()
+
+	setSource(writeSyncSource(t, "<p>first</p>"))
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	readOnly := true
+	path := filepath.Join(t.TempDir(), "replica.duckdb")
+	h := &HTMLFromDuckDB{
+		DatabasePath: path,
+		Table:        "html",
+		HTMLColumn:   "html",
+		IDColumn:     "id",
+		ReadOnly:     &readOnly,
+		Sync:         &Sync{Source: srv.URL + "/db.duckdb", Interval: "1h", Token: "secret"},
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	get := func(t *testing.T) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a", nil), emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		return rec.Body.String()
+	}
+
+	t.Run("initial sync", func(t *testing.T) {
+		if target, err := os.Readlink(path); err != nil || !strings.HasPrefix(target, "replica.duckdb.") {
+			t.Errorf("database_path links to %q, %v", target, err)
+		}
+		if body := get(t); !strings.Contains(body, "first") {
+			t.Errorf("body = %q", body)
+		}
+	})
+
+	t.Run("unchanged source", func(t *testing.T) {
+		if swapped, err := h.syncReplica(ctx); err != nil || swapped {
+			t.Errorf("swapped = %v, err = %v", swapped, err)
+		}
+	})
+
+	t.Run("changed source", func(t *testing.T) {
+		old, _ := os.Readlink(path)
+		setSource(writeSyncSource(t, "<p>second</p>"))
+		if swapped, err := h.syncReplica(ctx); err != nil || !swapped {
+			t.Fatalf("swapped = %v, err = %v", swapped, err)
+		}
+		if body := get(t); !strings.Contains(body, "second") {
+			t.Errorf("body = %q", body)
+		}
+		// The previous copy is removed once its pool is closed.
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(filepath.Join(filepath.Dir(path), old)); os.IsNotExist(err) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("old copy %s was not removed", old)
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		b := writeSyncSource(t, "<p>third</p>")
+		content.Store(&b)
+		if _, err := h.syncReplica(ctx); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("err = %v", err)
+		}
+		if body := get(t); !strings.Contains(body, "second") {
+			t.Errorf("body = %q", body)
+		}
+	})
+
+	t.Run("checksum file", func(t *testing.T) {
+		b := writeSyncSource(t, "<p>fourth</p>")
+		setSource(b)
+		h.Sync.Checksum = srv.URL + "/db.sha256"
+		defer func() { h.Sync.Checksum = "" }()
+
+		wrong := strings.Repeat("0", 64)
+		checksum.Store(&wrong)
+		if _, err := h.syncReplica(ctx); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("err = %v", err)
+		}
+
+		sum := sha256.Sum256(b)
+		right := hex.EncodeToString(sum[:])
+		checksum.Store(&right)
+		if swapped, err := h.syncReplica(ctx); err != nil || !swapped {
+			t.Fatalf("swapped = %v, err = %v", swapped, err)
+		}
+		if body := get(t); !strings.Contains(body, "fourth") {
+			t.Errorf("body = %q", body)
+		}
+	})
+
+	t.Run("invalid database", func(t *testing.T) {
+		setSource([]byte("not a database"))
+		if _, err := h.syncReplica(ctx); err == nil {
+			t.Error("expected error for invalid database")
+		}
+		if body := get(t); !strings.Contains(body, "fourth") {
+			t.Errorf("body = %q", body)
+		}
+	})
+}
+
+func TestSync_Restart(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	// A local copy from an earlier run is served even if the source is
+	// unreachable.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "replica.duckdb")
+	if err := os.WriteFile(path+".abc", writeSyncSource(t, "<p>cached</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("replica.duckdb.abc", path); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly := true
+	h := &HTMLFromDuckDB{
+		DatabasePath: path,
+		Table:        "html",
+		HTMLColumn:   "html",
+		IDColumn:     "id",
+		ReadOnly:     &readOnly,
+		Sync:         &Sync{Source: "http://127.0.0.1:1/db.duckdb"},
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), "cached") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
+
+func TestSync_ProvisionErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	readOnly, writable := true, false
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		h    *HTMLFromDuckDB
+	}{
+		{"in-memory", &HTMLFromDuckDB{ReadOnly: &readOnly, Sync: &Sync{Source: "x.duckdb"}}},
+		{"writable", &HTMLFromDuckDB{DatabasePath: filepath.Join(dir, "w.duckdb"), ReadOnly: &writable, Sync: &Sync{Source: "x.duckdb"}}},
+		{"bad interval", &HTMLFromDuckDB{DatabasePath: filepath.Join(dir, "i.duckdb"), ReadOnly: &readOnly, Sync: &Sync{Source: "x.duckdb", Interval: "soon"}}},
+		{"bad timeout", &HTMLFromDuckDB{DatabasePath: filepath.Join(dir, "t.duckdb"), ReadOnly: &readOnly, Sync: &Sync{Source: "x.duckdb", Timeout: "0s"}}},
+		{"unreachable source", &HTMLFromDuckDB{DatabasePath: filepath.Join(dir, "u.duckdb"), ReadOnly: &readOnly, Sync: &Sync{Source: filepath.Join(dir, "missing.duckdb")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.h.Table = "html"
+			if err := tt.h.Provision(ctx); err == nil {
+				tt.h.Cleanup()
+				t.Error("expected Provision to fail")
+			}
+		})
+	}
+}
+
+func TestSync_Timeout(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	// A source that sends headers and then nothing
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	readOnly := true
+	h := &HTMLFromDuckDB{
+		DatabasePath: filepath.Join(t.TempDir(), "replica.duckdb"),
+		Table:        "html",
+		ReadOnly:     &readOnly,
+		Sync:         &Sync{Source: srv.URL + "/_export", Timeout: "100ms"},
+	}
+	start := time.Now()
+	err := h.Provision(ctx)
+	if err == nil {
+		h.Cleanup()
+		t.Fatal("expected Provision to fail")
+	}
+	if !strings.Contains(err.Error(), "initial sync failed") || time.Since(start) > 5*time.Second {
+		t.Errorf("error = %v after %v", err, time.Since(start))
+	}
+}
+
+func TestSync_CopySource(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	// Sources that aren't http(s) are attached and copied by DuckDB.
+	source := filepath.Join(t.TempDir(), "source.duckdb")
+	if err := os.WriteFile(source, writeSyncSource(t, "<p>copied</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := true
+	h := &HTMLFromDuckDB{
+		DatabasePath: filepath.Join(t.TempDir(), "replica.duckdb"),
+		Table:        "html",
+		HTMLColumn:   "html",
+		IDColumn:     "id",
+		ReadOnly:     &readOnly,
+		Sync:         &Sync{Source: source},
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	rec := httptest.NewRecorder()
+	if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), "copied") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+	if swapped, err := h.syncReplica(ctx); err != nil || swapped {
+		t.Errorf("unchanged source: swapped = %v, err = %v", swapped, err)
+	}
+}
+
+func TestParseSync(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		sync https://primary.example/_export {
+			interval 10m
+			token secret
+			checksum https://primary.example/db.sha256
+			timeout 2m
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	want := Sync{Source: "https://primary.example/_export", Interval: "10m", Token: "secret", Checksum: "https://primary.example/db.sha256", Timeout: "2m"}
+	if h.Sync == nil || *h.Sync != want {
+		t.Errorf("Sync = %+v, want %+v", h.Sync, want)
+	}
+
+	d = caddyfile.NewTestDispenser(`html_from_duckdb {
+		sync {
+			source s3://bucket/db.duckdb
+		}
+	}`)
+	h = &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil || h.Sync.Source != "s3://bucket/db.duckdb" {
+		t.Errorf("Sync = %+v, err = %v", h.Sync, err)
+	}
+
+	d = caddyfile.NewTestDispenser(`html_from_duckdb {
+		sync x {
+			every 1m
+		}
+	}`)
+	if err := (&HTMLFromDuckDB{}).UnmarshalCaddyfile(d); err == nil {
+		t.Error("expected error for unknown subdirective")
+	}
+}
@@ -0,0 +1,454 @@
+package caddyhtmlduckdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clipperhouse/displaywidth"
+)
+
+// duckbox is a result set scanned for rendering as a borderless ASCII table.
+// Cell text is stored back to back in a single buffer, so large results
+// don't allocate a string per cell, and duckboxes are pooled across requests.
+//
+// Rendering is a second pass over the scanned cells that writes straight to
+// the destination; it can run more than once (e.g. into a hash for the ETag,
+// then into the response) without formatting the values again.
+type duckbox struct {
+	names  []string
+	types  []string // DuckDB type name of each column
+	right  []bool   // right-align the column (numeric types)
+	widths []int    // display width of each column
+	links  []int    // column holding each column's link target, or -1
+	hidden []bool   // link target columns, which aren't shown
+
+	// format renders the cells; nil means defaultTableFormat.
+	format *TableFormat
+
+	// grid adds sort and page links to the HTML table (table_grid).
+	grid *tableGrid
+
+	text   []byte // all cell text, row-major
+	ends   []int  // end offset in text of each cell
+	cellWs []int  // display width of each cell, its widest line if several
+	rows   int
+
+	// multiline is set when a cell has several lines, which are written on
+	// lines of their own with the other cells of the row left blank.
+	multiline bool
+
+	// footers is the number of footer rows (totals, averages) stored after
+	// the data rows and rendered below a rule.
+	footers int
+
+	// truncated is the number of rows left out because of the row limit.
+	truncated int
+
+	values []any
+	ptrs   []any
+}
+
+// maxPooledDuckbox is the cell text capacity above which a duckbox is not
+// returned to the pool, so one huge result doesn't pin memory forever.
+const maxPooledDuckbox = 4 << 20
+
+var duckboxPool = sync.Pool{New: func() any { return new(duckbox) }}
+
+// getDuckbox returns an empty duckbox from the pool.
+func getDuckbox() *duckbox {
+	return duckboxPool.Get().(*duckbox)
+}
+
+// putDuckbox resets b and returns it to the pool.
+func putDuckbox(b *duckbox) {
+	if cap(b.text) > maxPooledDuckbox {
+		return
+	}
+	b.names = b.names[:0]
+	b.types = b.types[:0]
+	b.format = nil
+	b.grid = nil
+	b.right = b.right[:0]
+	b.widths = b.widths[:0]
+	b.links = b.links[:0]
+	b.hidden = b.hidden[:0]
+	b.text = b.text[:0]
+	b.ends = b.ends[:0]
+	b.cellWs = b.cellWs[:0]
+	b.rows = 0
+	b.multiline = false
+	b.footers = 0
+	b.truncated = 0
+	clear(b.values)
+	b.values = b.values[:0]
+	b.ptrs = b.ptrs[:0]
+	duckboxPool.Put(b)
+}
+
+// bufWriterPool holds bufio.Writers used to batch the many small writes of
+// table rendering before they reach the ResponseWriter.
+var bufWriterPool = sync.Pool{New: func() any { return bufio.NewWriterSize(nil, 32*1024) }}
+
+// scan reads rows into the duckbox. After maxRows rows (if positive) the
+// remaining rows are only counted, so an unbounded result can't exhaust
+// memory.
+func (b *duckbox) scan(rows *sql.Rows, maxRows int) error {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	format := b.cellFormat()
+	for _, col := range cols {
+		b.names = append(b.names, col.Name())
+		b.types = append(b.types, col.DatabaseTypeName())
+		b.right = append(b.right, isNumericType(col.DatabaseTypeName()))
+		b.widths = append(b.widths, displaywidth.String(col.Name()))
+		b.values = append(b.values, nil)
+	}
+	for i := range b.values {
+		b.ptrs = append(b.ptrs, &b.values[i])
+	}
+	b.pairLinks()
+
+	for rows.Next() {
+		if maxRows > 0 && b.rows >= maxRows {
+			b.truncated++
+			continue
+		}
+		if err := rows.Scan(b.ptrs...); err != nil {
+			return err
+		}
+		for i, v := range b.values {
+			start := len(b.text)
+			b.text = b.appendCell(format, i, v, b.types[i])
+			b.endCell(format, i, start)
+		}
+		b.rows++
+	}
+	return rows.Err()
+}
+
+// cellFormat returns the format cells are rendered with.
+func (b *duckbox) cellFormat() *TableFormat {
+	if b.format == nil {
+		return defaultTableFormat
+	}
+	return b.format
+}
+
+// appendCell appends the text of a value of column col to the cell text.
+// Link targets are kept as they are, neither formatted nor cut.
+func (b *duckbox) appendCell(format *TableFormat, col int, v any, typeName string) []byte {
+	if b.hidden[col] {
+		return appendValue(b.text, v)
+	}
+	return format.appendCell(b.text, v, typeName)
+}
+
+// endCell finishes the cell of column col whose text starts at start,
+// cutting it to the format's max_cell_width and widening the column to fit.
+// A cell with several lines is as wide as its widest line, and each line is
+// cut on its own.
+func (b *duckbox) endCell(format *TableFormat, col, start int) {
+	if b.hidden[col] {
+		b.ends = append(b.ends, len(b.text))
+		b.cellWs = append(b.cellWs, 0)
+		return
+	}
+	var width int
+	if cell := b.text[start:]; bytes.IndexByte(cell, '\n') < 0 {
+		width = displaywidth.Bytes(cell)
+		if format.MaxCellWidth > 0 && width > format.MaxCellWidth {
+			var cut []byte
+			cut, width = truncateCell(cell, format.MaxCellWidth)
+			b.text = append(b.text[:start], cut...)
+		}
+	} else {
+		b.multiline = true
+		var lines []byte
+		// bytes.Split caps each line, so cutting one can't overwrite the next
+		for i, line := range bytes.Split(cell, []byte{'\n'}) {
+			lineWidth := displaywidth.Bytes(line)
+			if format.MaxCellWidth > 0 && lineWidth > format.MaxCellWidth {
+				line, lineWidth = truncateCell(line, format.MaxCellWidth)
+			}
+			if i > 0 {
+				lines = append(lines, '\n')
+			}
+			lines = append(lines, line...)
+			width = max(width, lineWidth)
+		}
+		b.text = append(b.text[:start], lines...)
+	}
+	b.ends = append(b.ends, len(b.text))
+	b.cellWs = append(b.cellWs, width)
+	if width > b.widths[col] {
+		b.widths[col] = width
+	}
+}
+
+// isNumericType reports whether a DuckDB type is rendered right-aligned.
+func isNumericType(typeName string) bool {
+	switch typeName {
+	case "INTEGER", "BIGINT", "DOUBLE", "FLOAT", "DECIMAL", "HUGEINT", "SMALLINT", "TINYINT", "UBIGINT", "UHUGEINT", "UINTEGER", "USMALLINT", "UTINYINT":
+		return true
+	default:
+		// DECIMAL columns are named with their width and scale
+		return strings.HasPrefix(typeName, "DECIMAL(")
+	}
+}
+
+// appendValue appends the text form of a scanned value to dst, using
+// type-specific formatting instead of fmt for the common types.
+func appendValue(dst []byte, v any) []byte {
+	switch x := v.(type) {
+	case nil:
+		return dst
+	case string:
+		return append(dst, x...)
+	case bool:
+		return strconv.AppendBool(dst, x)
+	case int:
+		return strconv.AppendInt(dst, int64(x), 10)
+	case int8:
+		return strconv.AppendInt(dst, int64(x), 10)
+	case int16:
+		return strconv.AppendInt(dst, int64(x), 10)
+	case int32:
+		return strconv.AppendInt(dst, int64(x), 10)
+	case int64:
+		return strconv.AppendInt(dst, x, 10)
+	case uint8:
+		return strconv.AppendUint(dst, uint64(x), 10)
+	case uint16:
+		return strconv.AppendUint(dst, uint64(x), 10)
+	case uint32:
+		return strconv.AppendUint(dst, uint64(x), 10)
+	case uint64:
+		return strconv.AppendUint(dst, x, 10)
+	case float32:
+		return strconv.AppendFloat(dst, float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.AppendFloat(dst, x, 'g', -1, 64)
+	case *big.Int:
+		return x.Append(dst, 10)
+	case time.Time:
+		return x.AppendFormat(dst, "2006-01-02 15:04:05.999999999 -0700 MST")
+	default:
+		return fmt.Append(dst, v)
+	}
+}
+
+// cell returns the text and display width of the cell at row, col.
+func (b *duckbox) cell(row, col int) ([]byte, int) {
+	i := row*len(b.names) + col
+	start := 0
+	if i > 0 {
+		start = b.ends[i-1]
+	}
+	return b.text[start:b.ends[i]], b.cellWs[i]
+}
+
+var (
+	spaces = []byte("                                                                ")
+	rule   = []byte("────────────────────────────────")
+)
+
+// writeRepeated writes n copies of the unit pattern found in chunk.
+func writeRepeated(w *bufio.Writer, chunk []byte, unitLen, n int) {
+	for n > 0 {
+		k := min(n, len(chunk)/unitLen)
+		w.Write(chunk[:k*unitLen])
+		n -= k
+	}
+}
+
+// writeCell writes one padded cell: a space on each side and the content
+// aligned within the column width.
+func (b *duckbox) writeCell(w *bufio.Writer, col int, text []byte, width int) {
+	pad := b.widths[col] - width
+	w.WriteByte(' ')
+	if b.right[col] {
+		writeRepeated(w, spaces, 1, pad)
+		w.Write(text)
+	} else {
+		w.Write(text)
+		writeRepeated(w, spaces, 1, pad)
+	}
+	w.WriteByte(' ')
+}
+
+// render writes the table wrapped in <pre class="duckbox"> tags.
+func (b *duckbox) render(w *bufio.Writer) error {
+	w.WriteString(`<pre class="duckbox">`)
+	w.WriteByte('\n')
+	b.writeLines(w, true)
+	w.WriteString(`</pre>`)
+	return w.Flush()
+}
+
+// writeLines writes the table lines: the header, a rule, a blank line, the
+// data rows, for truncated tables a "… N more rows" line, the footer rows
+// below a rule and, for paged grids, the page links. In HTML, cells with a
+// link target become anchors.
+func (b *duckbox) writeLines(w *bufio.Writer, html bool) {
+	total := 0
+	for col := range b.names {
+		if b.hidden[col] {
+			continue
+		}
+		// Headers are always left-aligned
+		w.WriteByte(' ')
+		width := b.writeHeader(w, col, html)
+		writeRepeated(w, spaces, 1, b.widths[col]-width)
+		w.WriteByte(' ')
+		total += b.widths[col] + 2
+	}
+	w.WriteByte('\n')
+	writeRepeated(w, rule, len("─"), total)
+	w.WriteByte('\n')
+	writeRepeated(w, spaces, 1, total)
+	w.WriteByte('\n')
+
+	b.writeRows(w, 0, b.rows, html)
+
+	if b.truncated > 0 {
+		b.writeFooter(w, total)
+	}
+
+	if b.footers > 0 {
+		writeRepeated(w, rule, len("─"), total)
+		w.WriteByte('\n')
+		b.writeRows(w, b.rows, b.rows+b.footers, html)
+	}
+
+	if html && b.grid != nil && b.grid.paged {
+		b.writePager(w, total)
+	}
+}
+
+// writeRows writes the stored rows from up to (not including) to. A row
+// with multi-line cells takes as many lines as its tallest cell, with the
+// cells' lines aligned and shorter cells padded with blanks.
+func (b *duckbox) writeRows(w *bufio.Writer, from, to int, html bool) {
+	for row := from; row < to; row++ {
+		lines := 1
+		if b.multiline {
+			for col := range b.names {
+				if !b.hidden[col] {
+					text, _ := b.cell(row, col)
+					lines = max(lines, bytes.Count(text, []byte{'\n'})+1)
+				}
+			}
+		}
+		for line := range lines {
+			for col := range b.names {
+				if b.hidden[col] {
+					continue
+				}
+				text, width := b.cell(row, col)
+				if lines > 1 {
+					text = cellLine(text, line)
+					width = displaywidth.Bytes(text)
+				}
+				if html && b.links[col] >= 0 && len(text) > 0 {
+					if href, _ := b.cell(row, b.links[col]); safeHref(href) {
+						b.writeLinkCell(w, col, text, width, href)
+						continue
+					}
+				}
+				b.writeCell(w, col, text, width)
+			}
+			w.WriteByte('\n')
+		}
+	}
+}
+
+// cellLine returns line n of a cell's text, or nothing if it has fewer
+// lines.
+func cellLine(text []byte, n int) []byte {
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(text, '\n')
+		if i < 0 {
+			return nil
+		}
+		text = text[i+1:]
+	}
+	if i := bytes.IndexByte(text, '\n'); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
+
+// writeFooter writes the "… N more rows" line for a truncated table, padded
+// to the table width like the other lines.
+func (b *duckbox) writeFooter(w *bufio.Writer, total int) {
+	var num [20]byte
+	n := strconv.AppendInt(num[:0], int64(b.truncated), 10)
+	w.WriteString(" … ")
+	w.Write(n)
+	label := " more rows"
+	if b.truncated == 1 {
+		label = " more row"
+	}
+	w.WriteString(label)
+	writeRepeated(w, spaces, 1, total-3-len(n)-len(label))
+	w.WriteByte('\n')
+}
+
+// renderTo renders the table to dst through a pooled buffer.
+func (b *duckbox) renderTo(dst io.Writer) error {
+	bw := bufWriterPool.Get().(*bufio.Writer)
+	bw.Reset(dst)
+	defer func() {
+		bw.Reset(nil)
+		bufWriterPool.Put(bw)
+	}()
+	return b.render(bw)
+}
+
+// renderTextTo renders the table to dst as plain text, without HTML tags.
+func (b *duckbox) renderTextTo(dst io.Writer) error {
+	bw := bufWriterPool.Get().(*bufio.Writer)
+	bw.Reset(dst)
+	defer func() {
+		bw.Reset(nil)
+		bufWriterPool.Put(bw)
+	}()
+	b.writeLines(bw, false)
+	return bw.Flush()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// etag renders the table into a hash and returns its ETag and size in bytes,
+// without holding the rendered output in memory.
+func (b *duckbox) etag() (string, int64, error) {
+	hash := md5.New()
+	cw := &countingWriter{w: hash}
+	if err := b.renderTo(cw); err != nil {
+		return "", 0, err
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, cw.n, nil
+}
@@ -0,0 +1,72 @@
+//go:build duckdb_arrow
+
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+	"go.uber.org/zap"
+)
+
+// serveTableArrow streams the table macro result as an Arrow IPC stream,
+// record batch by record batch, using DuckDB's native Arrow export.
+func (h *HTMLFromDuckDB) serveTableArrow(ctx context.Context, w http.ResponseWriter, query string) error {
+	conn, release, err := h.requestConn(ctx, "table")
+	if err != nil {
+		return caddyhttp.Error(http.StatusServiceUnavailable, err)
+	}
+	defer release()
+
+	// The driver connection may only be used inside Raw, so the whole
+	// response is streamed from within it.
+	var streamErr error
+	err = conn.Raw(func(driverConn any) error {
+		arrow, err := duckdb.NewArrowFromConn(driverConn.(driver.Conn))
+		if err != nil {
+			return err
+		}
+		reader, err := arrow.QueryContext(ctx, tagQuery(ctx, query))
+		if err != nil {
+			return err
+		}
+		defer reader.Release()
+
+		w.Header().Set("Cache-Control", h.tableCacheControl())
+		w.Header().Set("Content-Type", "application/vnd.apache.arrow.stream")
+		w.WriteHeader(http.StatusOK)
+
+		writer := ipc.NewWriter(w, ipc.WithSchema(reader.Schema()))
+		for reader.Next() {
+			if streamErr = writer.Write(reader.RecordBatch()); streamErr != nil {
+				break
+			}
+		}
+		if streamErr == nil {
+			streamErr = reader.Err()
+		}
+		if closeErr := writer.Close(); streamErr == nil {
+			streamErr = closeErr
+		}
+		return nil
+	})
+	if err != nil {
+		h.logFailure(ctx, "table macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if streamErr != nil {
+		// Headers are already sent; all we can do is log and abort.
+		h.logFailure(ctx, "arrow stream failed", zap.Error(streamErr))
+		return streamErr
+	}
+
+	h.log(ctx).Debug("served table",
+		zap.String("macro", h.TableMacro),
+		zap.String("format", "arrow"))
+
+	return nil
+}
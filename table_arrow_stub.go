@@ -0,0 +1,18 @@
+//go:build !duckdb_arrow
+
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// serveTableArrow reports that Arrow output is unavailable. DuckDB's Arrow
+// interface is only compiled in with the duckdb_arrow build tag.
+func (h *HTMLFromDuckDB) serveTableArrow(ctx context.Context, w http.ResponseWriter, query string) error {
+	return caddyhttp.Error(http.StatusNotAcceptable,
+		fmt.Errorf("arrow output requires a build with -tags duckdb_arrow"))
+}
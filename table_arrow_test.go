@@ -0,0 +1,64 @@
+//go:build duckdb_arrow
+
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_TableArrow(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE OR REPLACE MACRO render_chart(max_items := 10, base_path := '') AS TABLE
+		SELECT 'Item ' || i AS name, i * 10 AS value
+		FROM range(1, max_items + 1) t(i)
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		Table:      "html",
+		TableMacro: "render_chart",
+		TablePath:  "_chart",
+		db:         db,
+		logger:     zap.NewNop(),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/_chart?max_items=5&format=arrow", nil)
+	rec := httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/vnd.apache.arrow.stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	reader, err := ipc.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("invalid Arrow stream: %v", err)
+	}
+	defer reader.Release()
+
+	if names := reader.Schema().Fields(); len(names) != 2 || names[0].Name != "name" || names[1].Name != "value" {
+		t.Errorf("unexpected schema: %v", reader.Schema())
+	}
+	var rows int64
+	for reader.Next() {
+		rows += reader.RecordBatch().NumRows()
+	}
+	if rows != 5 {
+		t.Errorf("got %d rows, want 5", rows)
+	}
+}
@@ -0,0 +1,73 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// serveTableParquet serves the table macro result as a Parquet file. DuckDB
+// writes the file to a temporary location, which is then streamed to the
+// client and removed.
+func (h *HTMLFromDuckDB) serveTableParquet(ctx context.Context, w http.ResponseWriter, r *http.Request, query string) error {
+	f, err := os.CreateTemp(h.TempDirectory, "html_from_duckdb-*.parquet")
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+
+	copyStmt := fmt.Sprintf("COPY (%s) TO '%s' (FORMAT parquet)", query, escapeSQLString(name))
+	if _, err := h.queries(ctx, "table").ExecContext(ctx, tagQuery(ctx, copyStmt)); err != nil {
+		h.logFailure(ctx, "table macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	f, err = os.Open(name)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	w.Header().Set("Cache-Control", h.tableCacheControl())
+	if h.TableETag {
+		hash := md5.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		if notModified(w, r, `"`+hex.EncodeToString(hash.Sum(nil))+`"`) {
+			return nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.parquet"`, sanitizeIdentifier(h.TableMacro)))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, f); err != nil {
+		h.logFailure(ctx, "failed to write response", zap.Error(err))
+		return err
+	}
+
+	h.log(ctx).Debug("served table",
+		zap.String("macro", h.TableMacro),
+		zap.String("format", "parquet"),
+		zap.Int64("size", info.Size()))
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package caddyhtmlduckdb
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestDuckbox_Render(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT * FROM (VALUES
+		('Item 1', 10, '█', NULL::INTEGER, 1.5::DOUBLE),
+		('日本', -3, '', 42, 0.1::DOUBLE)
+	) t(name, value, chart, n, d)`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	box := getDuckbox()
+	defer putDuckbox(box)
+	if err := box.scan(rows, -1); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	var out strings.Builder
+	if err := box.renderTo(&out); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+
+	// Output must stay identical to the tablewriter layout it replaced:
+	// left-aligned headers, numeric columns right-aligned, wide runes
+	// counted by display width and NULL rendered as an empty cell.
+	want := "<pre class=\"duckbox\">\n" +
+		" name    value  chart  n   d   \n" +
+		"───────────────────────────────\n" +
+		"                               \n" +
+		" Item 1     10  █          1.5 \n" +
+		" 日本       -3         42  0.1 \n" +
+		"</pre>"
+	if out.String() != want {
+		t.Errorf("render mismatch\ngot:\n%q\nwant:\n%q", out.String(), want)
+	}
+
+	t.Run("etag matches rendered output", func(t *testing.T) {
+		etag, size, err := box.etag()
+		if err != nil {
+			t.Fatalf("etag failed: %v", err)
+		}
+		if etag != generateETag(want) {
+			t.Errorf("etag = %s, want %s", etag, generateETag(want))
+		}
+		if size != int64(len(want)) {
+			t.Errorf("size = %d, want %d", size, len(want))
+		}
+	})
+
+	t.Run("render does not allocate", func(t *testing.T) {
+		bw := bufio.NewWriter(io.Discard)
+		allocs := testing.AllocsPerRun(100, func() {
+			bw.Reset(io.Discard)
+			box.render(bw)
+		})
+		if allocs != 0 {
+			t.Errorf("render allocated %.0f times per run, want 0", allocs)
+		}
+	})
+}
+
+func TestDuckbox_MultilineCells(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT * FROM (VALUES
+		('Ann', 'line one' || chr(10) || 'second line here', 31),
+		('Bob', 'single', 4),
+		('Cid', 'a' || chr(10) || 'b' || chr(10) || 'c', 1234)
+	) t(name, note, n)`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	box := getDuckbox()
+	defer putDuckbox(box)
+	if err := box.scan(rows, -1); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	var out strings.Builder
+	if err := box.renderTo(&out); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+
+	// As tablewriter rendered the same rows: each line of a cell on a line
+	// of its own, the column as wide as the widest line.
+	want := "<pre class=\"duckbox\">\n" +
+		" name  note              n    \n" +
+		"──────────────────────────────\n" +
+		"                              \n" +
+		" Ann   line one            31 \n" +
+		"       second line here       \n" +
+		" Bob   single               4 \n" +
+		" Cid   a                 1234 \n" +
+		"       b                      \n" +
+		"       c                      \n" +
+		"</pre>"
+	if out.String() != want {
+		t.Errorf("render mismatch\ngot:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"nil", nil, ""},
+		{"string", "hello", "hello"},
+		{"bool", true, "true"},
+		{"int32", int32(-7), "-7"},
+		{"int64", int64(1) << 40, "1099511627776"},
+		{"uint64", uint64(18446744073709551615), "18446744073709551615"},
+		{"float64", 1.5, "1.5"},
+		{"float64 exponent", 1e300, "1e+300"},
+		{"float32", float32(3.25), "3.25"},
+		{"big int", new(big.Int).Lsh(big.NewInt(1), 100), "1267650600228229401496703205376"},
+		{"time", ts, ts.String()},
+		{"list", []any{int32(1), int32(2)}, "[1 2]"},
+		{"blob", []byte("x"), "[120]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(appendValue(nil, tt.value)); got != tt.want {
+				t.Errorf("appendValue(%v) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_TableFormats(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE OR REPLACE MACRO render_chart(max_items := 10, base_path := '') AS TABLE
+		SELECT 'Item ' || i AS name, i * 10 AS value
+		FROM range(1, max_items + 1) t(i)
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+
+	handler := &HTMLFromDuckDB{
+		Table:      "html",
+		TableMacro: "render_chart",
+		TablePath:  "_chart",
+		db:         db,
+		logger:     zap.NewNop(),
+	}
+
+	t.Run("parquet", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/_chart?max_items=4&format=parquet", nil)
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, req, emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/vnd.apache.parquet" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+
+		path := filepath.Join(t.TempDir(), "out.parquet")
+		if err := os.WriteFile(path, rec.Body.Bytes(), 0o644); err != nil {
+			t.Fatalf("write parquet: %v", err)
+		}
+		var n, sum int
+		err := db.QueryRow(fmt.Sprintf("SELECT count(*), sum(value) FROM read_parquet('%s')", path)).Scan(&n, &sum)
+		if err != nil {
+			t.Fatalf("read back parquet: %v", err)
+		}
+		if n != 4 || sum != 100 {
+			t.Errorf("parquet has %d rows summing to %d, want 4 and 100", n, sum)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/_chart?format=xml", nil)
+		rec := httptest.NewRecorder()
+		err := handler.ServeHTTP(rec, req, emptyNextHandler())
+		if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected 400 handler error, got %v", err)
+		}
+	})
+}
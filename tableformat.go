@@ -0,0 +1,345 @@
+package caddyhtmlduckdb
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/clipperhouse/displaywidth"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// TableFormat controls how values are written in ASCII tables, those of
+// the table endpoint and of the query endpoint's text and HTML formats.
+// JSON, CSV and the other formats are not affected.
+type TableFormat struct {
+	// NullDisplay is written for NULL values.
+	// Default: "" (an empty cell)
+	NullDisplay string `json:"null_display,omitempty"`
+
+	// ThousandsSeparator groups the integer digits of numbers in threes,
+	// e.g. "," or " ".
+	// Default: "" (no grouping)
+	ThousandsSeparator string `json:"thousands_separator,omitempty"`
+
+	// DecimalPlaces rounds or pads floating point and DECIMAL numbers to
+	// this many digits after the point.
+	// Default: unset (DOUBLEs as short as possible, DECIMALs at their scale)
+	DecimalPlaces *int `json:"decimal_places,omitempty"`
+
+	// Timestamps is "iso" for ISO 8601 timestamps (2024-01-02T03:04:05,
+	// with the offset for TIMESTAMPTZ), or "local" for 2024-01-02 03:04:05
+	// with TIMESTAMPTZ values converted to TimeZone.
+	// Default: "iso"
+	Timestamps string `json:"timestamps,omitempty"`
+
+	// TimeZone is the IANA time zone "local" timestamps are shown in.
+	// Default: the server's time zone
+	TimeZone string `json:"time_zone,omitempty"`
+
+	// MaxCellWidth cuts cells wider than this many columns, ending them
+	// with an ellipsis.
+	// Default: 0 (no limit)
+	MaxCellWidth int `json:"max_cell_width,omitempty"`
+
+	// True and False are written for boolean values.
+	// Default: "true" and "false"
+	True  string `json:"true,omitempty"`
+	False string `json:"false,omitempty"`
+
+	loc *time.Location
+}
+
+// provision validates the format and loads its time zone.
+func (f *TableFormat) provision() error {
+	switch f.Timestamps {
+	case "":
+		f.Timestamps = "iso"
+	case "iso", "local":
+	default:
+		return fmt.Errorf("invalid table_format timestamps %q, must be iso or local", f.Timestamps)
+	}
+	f.loc = time.Local
+	if f.TimeZone != "" {
+		loc, err := time.LoadLocation(f.TimeZone)
+		if err != nil {
+			return fmt.Errorf("invalid table_format time_zone: %v", err)
+		}
+		f.loc = loc
+	}
+	if f.DecimalPlaces != nil && (*f.DecimalPlaces < 0 || *f.DecimalPlaces > 38) {
+		return fmt.Errorf("invalid table_format decimal_places %d", *f.DecimalPlaces)
+	}
+	if f.MaxCellWidth < 0 || f.MaxCellWidth == 1 {
+		return fmt.Errorf("invalid table_format max_cell_width %d", f.MaxCellWidth)
+	}
+	if f.True == "" {
+		f.True = "true"
+	}
+	if f.False == "" {
+		f.False = "false"
+	}
+	return nil
+}
+
+// defaultTableFormat is used when table_format isn't set.
+var defaultTableFormat = &TableFormat{Timestamps: "iso", True: "true", False: "false", loc: time.UTC}
+
+// appendCell appends the ASCII table text of a value scanned from a column
+// of type typeName.
+func (f *TableFormat) appendCell(dst []byte, v any, typeName string) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(dst, f.NullDisplay...)
+	case bool:
+		if x {
+			return append(dst, f.True...)
+		}
+		return append(dst, f.False...)
+	case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64, *big.Int:
+		return f.appendGrouped(dst, appendValue(nil, x))
+	case float32:
+		return f.appendFloat(dst, float64(x), 32)
+	case float64:
+		return f.appendFloat(dst, x, 64)
+	case duckdb.Decimal:
+		if f.DecimalPlaces != nil {
+			x = rescaleDecimal(x, *f.DecimalPlaces)
+		}
+		return f.appendGrouped(dst, []byte(decimalString(x)))
+	case time.Time:
+		return append(dst, f.timeString(x, typeName)...)
+	case []byte:
+		if typeName == "UUID" && len(x) == 16 {
+			u := duckdb.UUID(x)
+			return append(dst, u.String()...)
+		}
+		return appendBlob(dst, x)
+	case duckdb.Interval:
+		return append(dst, intervalString(x)...)
+	case []any, map[string]any, duckdb.OrderedMap, duckdb.Union:
+		return f.appendNested(dst, x, typeName)
+	default:
+		return appendValue(dst, v)
+	}
+}
+
+// appendFloat appends a floating point number, rounded to DecimalPlaces if
+// set and grouped.
+func (f *TableFormat) appendFloat(dst []byte, x float64, bitSize int) []byte {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return strconv.AppendFloat(dst, x, 'g', -1, bitSize)
+	}
+	if f.DecimalPlaces != nil {
+		return f.appendGrouped(dst, strconv.AppendFloat(nil, x, 'f', *f.DecimalPlaces, bitSize))
+	}
+	return f.appendGrouped(dst, strconv.AppendFloat(nil, x, 'g', -1, bitSize))
+}
+
+// appendGrouped appends the number num with ThousandsSeparator between
+// each three digits of its integer part. Numbers in exponent form are
+// appended as they are.
+func (f *TableFormat) appendGrouped(dst, num []byte) []byte {
+	if f.ThousandsSeparator == "" || strings.ContainsAny(string(num), "eE") {
+		return append(dst, num...)
+	}
+	if len(num) > 0 && num[0] == '-' {
+		dst = append(dst, '-')
+		num = num[1:]
+	}
+	digits := len(num)
+	if i := strings.IndexByte(string(num), '.'); i >= 0 {
+		digits = i
+	}
+	for i := 0; i < digits; i++ {
+		if i > 0 && (digits-i)%3 == 0 {
+			dst = append(dst, f.ThousandsSeparator...)
+		}
+		dst = append(dst, num[i])
+	}
+	return append(dst, num[digits:]...)
+}
+
+// timeString formats a DATE, TIME or TIMESTAMP value.
+func (f *TableFormat) timeString(t time.Time, typeName string) string {
+	sep := "T"
+	if f.Timestamps == "local" {
+		sep = " "
+	}
+	switch {
+	case typeName == "DATE":
+		return t.Format("2006-01-02")
+	case typeName == "TIME":
+		return t.Format("15:04:05.999999")
+	case typeName == "TIMETZ":
+		return t.Format("15:04:05.999999Z07:00")
+	case typeName == "TIMESTAMPTZ" && f.Timestamps == "local":
+		return t.In(f.loc).Format("2006-01-02 15:04:05.999999")
+	case typeName == "TIMESTAMPTZ":
+		return t.Format("2006-01-02T15:04:05.999999Z07:00")
+	default:
+		return t.Format("2006-01-02" + sep + "15:04:05.999999")
+	}
+}
+
+// decimalString formats a DECIMAL at its scale, keeping trailing zeros
+// (1.50 for DECIMAL(5,2)), as DuckDB does.
+func decimalString(d duckdb.Decimal) string {
+	if d.Value == nil {
+		return "0"
+	}
+	s := new(big.Int).Abs(d.Value).String()
+	sign := ""
+	if d.Value.Sign() < 0 {
+		sign = "-"
+	}
+	scale := int(d.Scale)
+	if scale == 0 {
+		return sign + s
+	}
+	if len(s) <= scale {
+		s = strings.Repeat("0", scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-scale] + "." + s[len(s)-scale:]
+}
+
+// rescaleDecimal returns d with scale places, rounding half away from zero.
+func rescaleDecimal(d duckdb.Decimal, places int) duckdb.Decimal {
+	if d.Value == nil || int(d.Scale) == places {
+		return d
+	}
+	v := new(big.Int)
+	if places > int(d.Scale) {
+		v.Mul(d.Value, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places-int(d.Scale))), nil))
+	} else {
+		div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(int(d.Scale)-places)), nil)
+		rem := new(big.Int)
+		v.QuoRem(d.Value, div, rem)
+		if rem.Abs(rem).Lsh(rem, 1).Cmp(div) >= 0 {
+			v.Add(v, big.NewInt(int64(d.Value.Sign())))
+		}
+	}
+	return duckdb.Decimal{Width: d.Width, Scale: uint8(places), Value: v}
+}
+
+// intervalString formats an INTERVAL as DuckDB does, e.g.
+// "1 year 2 months 3 days 04:05:06".
+func intervalString(iv duckdb.Interval) string {
+	var parts []string
+	unit := func(n int32, name string) {
+		if n == 0 {
+			return
+		}
+		if n != 1 && n != -1 {
+			name += "s"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", n, name))
+	}
+	unit(iv.Months/12, "year")
+	unit(iv.Months%12, "month")
+	unit(iv.Days, "day")
+	if iv.Micros != 0 || len(parts) == 0 {
+		micros, sign := iv.Micros, ""
+		if micros < 0 {
+			micros, sign = -micros, "-"
+		}
+		d := time.Duration(micros) * time.Microsecond
+		clock := fmt.Sprintf("%s%02d:%02d:%02d", sign, int64(d.Hours()), int64(d.Minutes())%60, int64(d.Seconds())%60)
+		if frac := micros % 1e6; frac != 0 {
+			clock += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+		}
+		parts = append(parts, clock)
+	}
+	return strings.Join(parts, " ")
+}
+
+// appendBlob appends a BLOB as DuckDB shows it: printable ASCII as is and
+// other bytes as \xNN.
+func appendBlob(dst, b []byte) []byte {
+	const hexDigits = "0123456789ABCDEF"
+	for _, c := range b {
+		if c >= 0x20 && c < 0x7f && c != '\\' {
+			dst = append(dst, c)
+		} else {
+			dst = append(dst, '\\', 'x', hexDigits[c>>4], hexDigits[c&0xf])
+		}
+	}
+	return dst
+}
+
+// truncateCell cuts text to at most width display columns, replacing the
+// end with an ellipsis, and returns it with its display width.
+func truncateCell(text []byte, width int) ([]byte, int) {
+	w := 0
+	g := displaywidth.BytesGraphemes(text)
+	for end := 0; g.Next(); {
+		if w+g.Width() > width-1 {
+			return append(text[:end], "…"...), w + 1
+		}
+		w += g.Width()
+		end += len(g.Value())
+	}
+	return text, w
+}
+
+// parseTableFormat parses a table_format block:
+//
+//	table_format {
+//	    null_display <text>
+//	    thousands_separator <text>
+//	    decimal_places <n>
+//	    timestamps iso|local
+//	    time_zone <zone>
+//	    max_cell_width <n>
+//	    booleans <true> <false>
+//	}
+func parseTableFormat(d *caddyfile.Dispenser) (*TableFormat, error) {
+	f := &TableFormat{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "null_display":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			f.NullDisplay = d.Val()
+		case "thousands_separator":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			f.ThousandsSeparator = d.Val()
+		case "decimal_places":
+			var n int
+			if err := parseIntArg(d, &n); err != nil {
+				return nil, err
+			}
+			f.DecimalPlaces = &n
+		case "timestamps":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			f.Timestamps = d.Val()
+		case "time_zone":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			f.TimeZone = d.Val()
+		case "max_cell_width":
+			if err := parseIntArg(d, &f.MaxCellWidth); err != nil {
+				return nil, err
+			}
+		case "booleans":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return nil, d.ArgErr()
+			}
+			f.True, f.False = args[0], args[1]
+		default:
+			return nil, d.Errf("unknown table_format option: %s", d.Val())
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,213 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// scanCells runs query and returns its single row as rendered by a
+// duckbox with format f.
+func scanCells(t *testing.T, db *sql.DB, f *TableFormat, query string) []string {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	box := getDuckbox()
+	defer putDuckbox(box)
+	box.format = f
+	if err := box.scan(rows, -1); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	var cells []string
+	for col := range box.names {
+		text, _ := box.cell(0, col)
+		cells = append(cells, string(text))
+	}
+	return cells
+}
+
+func TestTableFormat_Types(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	query := `SELECT
+		NULL::INTEGER,
+		1.50::DECIMAL(5,2),
+		DATE '2024-03-01',
+		TIME '13:45:00.25',
+		TIMESTAMP '2024-03-01 13:45:00',
+		TIMESTAMPTZ '2024-03-01 13:45:00+00',
+		'550e8400-e29b-41d4-a716-446655440000'::UUID,
+		'\xAA\x41'::BLOB,
+		INTERVAL 14 MONTH + INTERVAL 3 DAY + INTERVAL 90 MINUTE,
+		true`
+
+	got := scanCells(t, db, nil, query)
+	want := []string{
+		"",
+		"1.50",
+		"2024-03-01",
+		"13:45:00.25",
+		"2024-03-01T13:45:00",
+		"2024-03-01T13:45:00Z",
+		"550e8400-e29b-41d4-a716-446655440000",
+		`\xAAA`,
+		"1 year 2 months 3 days 01:30:00",
+		"true",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("default cells\ngot:  %q\nwant: %q", got, want)
+	}
+
+	f := &TableFormat{NullDisplay: "∅", Timestamps: "local", TimeZone: "Europe/Stockholm", True: "yes", False: "no"}
+	if err := f.provision(); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	got = scanCells(t, db, f, query)
+	for i, w := range map[int]string{0: "∅", 4: "2024-03-01 13:45:00", 5: "2024-03-01 14:45:00", 9: "yes"} {
+		if got[i] != w {
+			t.Errorf("cell %d = %q, want %q", i, got[i], w)
+		}
+	}
+}
+
+func TestTableFormat_Numbers(t *testing.T) {
+	two := 2
+	f := &TableFormat{ThousandsSeparator: ",", DecimalPlaces: &two}
+	if err := f.provision(); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{int64(1234567), "1,234,567"},
+		{int32(-1000), "-1,000"},
+		{int16(999), "999"},
+		{big.NewInt(12345678901), "12,345,678,901"},
+		{1234.5678, "1,234.57"},
+		{float32(-0.5), "-0.50"},
+		{duckdb.Decimal{Width: 10, Scale: 3, Value: big.NewInt(1234565)}, "1,234.57"},
+		{duckdb.Decimal{Width: 10, Scale: 3, Value: big.NewInt(-1234565)}, "-1,234.57"},
+		{duckdb.Decimal{Width: 10, Scale: 0, Value: big.NewInt(7)}, "7.00"},
+	}
+	for _, tt := range tests {
+		if got := string(f.appendCell(nil, tt.value, "")); got != tt.want {
+			t.Errorf("appendCell(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	if got := string(defaultTableFormat.appendCell(nil, 1e21, "DOUBLE")); got != "1e+21" {
+		t.Errorf("exponent form = %q", got)
+	}
+	if got := decimalString(duckdb.Decimal{Scale: 4, Value: big.NewInt(-5)}); got != "-0.0005" {
+		t.Errorf("decimalString = %q", got)
+	}
+}
+
+func TestIntervalString(t *testing.T) {
+	tests := []struct {
+		iv   duckdb.Interval
+		want string
+	}{
+		{duckdb.Interval{}, "00:00:00"},
+		{duckdb.Interval{Days: 1}, "1 day"},
+		{duckdb.Interval{Months: 12}, "1 year"},
+		{duckdb.Interval{Micros: -int64(time.Hour/time.Microsecond) - 500}, "-01:00:00.0005"},
+		{duckdb.Interval{Days: -2, Micros: 61e6}, "-2 days 00:01:01"},
+	}
+	for _, tt := range tests {
+		if got := intervalString(tt.iv); got != tt.want {
+			t.Errorf("intervalString(%+v) = %q, want %q", tt.iv, got, tt.want)
+		}
+	}
+}
+
+func TestTableFormat_MaxCellWidth(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	f := &TableFormat{MaxCellWidth: 6}
+	if err := f.provision(); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	rows, err := db.Query(`SELECT * FROM (VALUES ('short', 'a long description'), ('日本語の文字', 'x')) t(name, note)`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	box := getDuckbox()
+	defer putDuckbox(box)
+	box.format = f
+	if err := box.scan(rows, -1); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	for _, c := range []struct {
+		row, col int
+		text     string
+		width    int
+	}{
+		{0, 0, "short", 5},
+		{0, 1, "a lon…", 6},
+		{1, 0, "日本…", 5},
+		{1, 1, "x", 1},
+	} {
+		text, width := box.cell(c.row, c.col)
+		if string(text) != c.text || width != c.width {
+			t.Errorf("cell(%d, %d) = %q (%d), want %q (%d)", c.row, c.col, text, width, c.text, c.width)
+		}
+	}
+	if box.widths[1] != 6 {
+		t.Errorf("column width = %d, want 6", box.widths[1])
+	}
+}
+
+func TestParseTableFormat(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		table_format {
+			null_display ∅
+			thousands_separator ","
+			decimal_places 2
+			timestamps local
+			time_zone Europe/Stockholm
+			max_cell_width 40
+			booleans ✓ ✗
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	f := h.TableFormat
+	if f == nil || f.NullDisplay != "∅" || f.ThousandsSeparator != "," || f.DecimalPlaces == nil || *f.DecimalPlaces != 2 ||
+		f.Timestamps != "local" || f.TimeZone != "Europe/Stockholm" || f.MaxCellWidth != 40 || f.True != "✓" || f.False != "✗" {
+		t.Errorf("TableFormat = %+v", f)
+	}
+	if err := f.provision(); err != nil {
+		t.Errorf("provision: %v", err)
+	}
+
+	for _, bad := range []*TableFormat{
+		{Timestamps: "utc"},
+		{TimeZone: "Nowhere/Special"},
+		{MaxCellWidth: 1},
+	} {
+		if err := bad.provision(); err == nil {
+			t.Errorf("expected error for %+v", bad)
+		}
+	}
+}
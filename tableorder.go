@@ -0,0 +1,68 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// table_order modes.
+const (
+	tableOrderRequire = "require"
+	tableOrderAuto    = "auto"
+)
+
+// orderTable applies table_order to a table endpoint query: a query whose
+// plan sorts its rows is kept as it is, and one that doesn't either fails
+// with 500 ("require") or is sorted by all its columns ("auto"), so the
+// same data always renders the same output and the same ETag.
+func (h *HTMLFromDuckDB) orderTable(ctx context.Context, query string) (string, error) {
+	if h.TableOrder == "" {
+		return query, nil
+	}
+	ordered, err := h.queryOrdered(ctx, query)
+	if err != nil {
+		h.logFailure(ctx, "table order check failed", zap.Error(err))
+		return "", caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if ordered {
+		return query, nil
+	}
+	if h.TableOrder == tableOrderRequire {
+		return "", caddyhttp.Error(http.StatusInternalServerError,
+			fmt.Errorf("table macro %s returns its rows in no particular order; add an ORDER BY or set table_order auto", h.TableMacro))
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) ORDER BY ALL", query), nil
+}
+
+// queryOrdered reports whether the physical plan of query sorts its rows,
+// with an ORDER_BY or TOP_N operator.
+func (h *HTMLFromDuckDB) queryOrdered(ctx context.Context, query string) (bool, error) {
+	rows, err := h.queries(ctx, "table").QueryContext(ctx, tagQuery(ctx, "EXPLAIN "+query))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	ordered := false
+	for rows.Next() {
+		var key, plan string
+		if err := rows.Scan(&key, &plan); err != nil {
+			return false, err
+		}
+		ordered = ordered || strings.Contains(plan, "ORDER_BY") || strings.Contains(plan, "TOP_N")
+	}
+	return ordered, rows.Err()
+}
+
+// tableCacheControl returns the Cache-Control header of table endpoint
+// responses.
+func (h *HTMLFromDuckDB) tableCacheControl() string {
+	if h.TableCacheControl != "" {
+		return h.TableCacheControl
+	}
+	return "no-cache"
+}
@@ -0,0 +1,123 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP_TableOrder(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE people AS SELECT * FROM (VALUES ('Cid', 47), ('Ann', 31), ('Bob', 25)) t(name, age);
+		CREATE MACRO unordered(base_path := '') AS TABLE SELECT name, age FROM people;
+		CREATE MACRO by_age(base_path := '') AS TABLE SELECT name, age FROM people ORDER BY age;
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macros: %v", err)
+	}
+	get := func(order, macro, query string) (*httptest.ResponseRecorder, error) {
+		handler := &HTMLFromDuckDB{
+			Table:      "html",
+			TableMacro: macro,
+			TablePath:  "_table",
+			TableOrder: order,
+			db:         db,
+			logger:     zap.NewNop(),
+		}
+		rec := httptest.NewRecorder()
+		err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_table?"+query, nil), emptyNextHandler())
+		return rec, err
+	}
+
+	_, err = get(tableOrderRequire, "unordered", "")
+	if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("require, unordered: expected 500, got %v", err)
+	}
+	for _, tc := range []struct{ order, macro, query, want string }{
+		{tableOrderRequire, "by_age", "format=csv", "name,age\nBob,25\nAnn,31\nCid,47\n"},
+		{tableOrderAuto, "by_age", "format=csv", "name,age\nBob,25\nAnn,31\nCid,47\n"},
+		{tableOrderAuto, "unordered", "format=csv", "name,age\nAnn,31\nBob,25\nCid,47\n"},
+	} {
+		rec, err := get(tc.order, tc.macro, tc.query)
+		if err != nil {
+			t.Fatalf("%s, %s: ServeHTTP error: %v", tc.order, tc.macro, err)
+		}
+		if got := strings.ReplaceAll(rec.Body.String(), "\r\n", "\n"); got != tc.want {
+			t.Errorf("%s, %s: body = %q, want %q", tc.order, tc.macro, got, tc.want)
+		}
+	}
+}
+
+func TestServeHTTP_TableETag(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE MACRO report(base_path := '') AS TABLE SELECT 'a' AS k, 1 AS v`); err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+	handler := &HTMLFromDuckDB{
+		Table:             "html",
+		TableMacro:        "report",
+		TablePath:         "_report",
+		TableETag:         true,
+		TableCacheControl: "public, max-age=300",
+		TempDirectory:     t.TempDir(),
+		db:                db,
+		logger:            zap.NewNop(),
+	}
+	for _, format := range []string{"json", "csv", "xlsx", "parquet"} {
+		get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+			t.Helper()
+			r := httptest.NewRequest(http.MethodGet, "/_report?format="+format, nil)
+			if ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			if err := handler.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+				t.Fatalf("%s: ServeHTTP error: %v", format, err)
+			}
+			return rec
+		}
+		rec := get("")
+		etag := rec.Header().Get("ETag")
+		if etag == "" || rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, ETag %q", format, rec.Code, etag)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=300" {
+			t.Errorf("%s: Cache-Control = %q", format, got)
+		}
+		if rec := get(etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s: If-None-Match: status %d, %d bytes", format, rec.Code, rec.Body.Len())
+		}
+	}
+}
+
+func TestParseTableOrder(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		table_order auto
+		table_etag true
+		table_cache_control "public, max-age=300"
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.TableOrder != tableOrderAuto || !h.TableETag || h.TableCacheControl != "public, max-age=300" {
+		t.Errorf("TableOrder = %q, TableETag = %v, TableCacheControl = %q", h.TableOrder, h.TableETag, h.TableCacheControl)
+	}
+}
@@ -0,0 +1,102 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// defaultTagsHeaders are the response headers cache tags are sent in by
+// default: Fastly's Surrogate-Key and Cloudflare's Cache-Tag.
+var defaultTagsHeaders = []string{"Surrogate-Key", "Cache-Tag"}
+
+// parseTags converts a tags column value into a list of tags. It accepts the
+// same values as a preload column; list items are split on separators too,
+// since the tag headers can't carry tags containing them.
+func parseTags(value any) ([]string, error) {
+	items, err := parseList(value, "tags")
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, item := range items {
+		tags = append(tags, strings.FieldsFunc(item, isListSeparator)...)
+	}
+	return tags, nil
+}
+
+// rowTags adds the tags in a tags column value to the request, logging and
+// ignoring values that can't be parsed.
+func (h *HTMLFromDuckDB) rowTags(ctx context.Context, endpoint string, value any) []string {
+	tags, err := parseTags(value)
+	if err != nil {
+		h.log(ctx).Warn("ignoring invalid tags column",
+			zap.String("endpoint", endpoint),
+			zap.Error(err))
+		return nil
+	}
+	requestInfoFrom(ctx).addTags(tags...)
+	return tags
+}
+
+// addTags adds tags to the request's cache tags, skipping duplicates.
+func (info *requestInfo) addTags(tags ...string) {
+	if info == nil {
+		return
+	}
+	for _, tag := range tags {
+		if !slices.Contains(info.tags, tag) {
+			info.tags = append(info.tags, tag)
+		}
+	}
+}
+
+// setTagHeaders sends the request's cache tags in the tags_headers. Cache-Tag
+// lists them separated by commas, the others by spaces.
+func (h *HTMLFromDuckDB) setTagHeaders(w http.ResponseWriter, r *http.Request) {
+	info := requestInfoFrom(r.Context())
+	if h.TagsColumn == "" || info == nil || len(info.tags) == 0 {
+		return
+	}
+	headers := h.TagsHeaders
+	if len(headers) == 0 {
+		headers = defaultTagsHeaders
+	}
+	for _, name := range headers {
+		sep := " "
+		if strings.EqualFold(name, "Cache-Tag") {
+			sep = ","
+		}
+		w.Header().Set(name, strings.Join(info.tags, sep))
+	}
+}
+
+// purgeTags removes the cached index pages and ESI responses carrying any
+// of tags, and returns how many entries it removed. The admin API audits
+// the purge.
+func (h *HTMLFromDuckDB) purgeTags(tags ...string) int {
+	tagged := func(entryTags []string) bool {
+		for _, tag := range tags {
+			if slices.Contains(entryTags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	n := 0
+	if h.indexCache != nil {
+		n += h.indexCache.removeFunc(func(page indexPage) bool { return tagged(page.tags) })
+	}
+	if h.esiCache != nil {
+		n += h.esiCache.removeFunc(func(entry esiEntry) bool { return tagged(entry.tags) })
+	}
+	return n
+}
+
+// PurgeResult reports the outcome of a purge by tag.
+type PurgeResult struct {
+	Purged int `json:"purged"`
+}
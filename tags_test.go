@@ -0,0 +1,163 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		value any
+		want  []string
+	}{
+		{nil, nil},
+		{"work:1 author:7", []string{"work:1", "author:7"}},
+		{`["work:1", "author:7 author:8"]`, []string{"work:1", "author:7", "author:8"}},
+		{[]any{"work:1", " nav "}, []string{"work:1", "nav"}},
+	}
+	for _, tt := range tests {
+		got, err := parseTags(tt.value)
+		if err != nil {
+			t.Fatalf("parseTags(%v): %v", tt.value, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseTags(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+	if _, err := parseTags(42); err == nil {
+		t.Error("expected error for a number")
+	}
+}
+
+func TestServeHTTP_Tags(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR, tags VARCHAR[]);
+		INSERT INTO html VALUES
+			('w1', '<p>w1</p><esi:include src="/nav"/>', ['work:1', 'author:7']),
+			('nav', '<nav>menu</nav>', ['nav']),
+			('plain', '<p>plain</p>', NULL);
+		CREATE MACRO render_index(page := 1, base_path := '') AS TABLE
+			SELECT '<ul></ul>' AS html, 'index' AS tags;
+	`)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:              db,
+		Name:            "tags-test",
+		Table:           "html",
+		IDColumn:        "id",
+		HTMLColumn:      "html",
+		TagsColumn:      "tags",
+		IndexEnabled:    true,
+		IndexMacro:      "render_index",
+		ESI:             true,
+		IncludeMaxDepth: 5,
+		indexCache:      newLRUCache[indexPage](indexCacheSize, time.Hour),
+		esiCache:        newLRUCache[esiEntry](esiCacheSize, time.Hour),
+		logger:          zap.NewNop(),
+	}
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil), emptyNextHandler()); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("headers include fragment tags", func(t *testing.T) {
+		rec := get(t, "/w1")
+		if got := rec.Header().Get("Surrogate-Key"); got != "work:1 author:7 nav" {
+			t.Errorf("Surrogate-Key = %q", got)
+		}
+		if got := rec.Header().Get("Cache-Tag"); got != "work:1,author:7,nav" {
+			t.Errorf("Cache-Tag = %q", got)
+		}
+	})
+
+	t.Run("no tags", func(t *testing.T) {
+		if got := get(t, "/plain").Header().Get("Surrogate-Key"); got != "" {
+			t.Errorf("Surrogate-Key = %q", got)
+		}
+	})
+
+	t.Run("index cache hit keeps tags", func(t *testing.T) {
+		get(t, "/")
+		if got := get(t, "/").Header().Get("Surrogate-Key"); got != "index" {
+			t.Errorf("Surrogate-Key = %q", got)
+		}
+	})
+
+	t.Run("purge by tag", func(t *testing.T) {
+		registerInstance(h)
+		defer unregisterInstance(h)
+
+		api := &AdminAPI{}
+		rec := httptest.NewRecorder()
+		if err := api.serveAdmin(rec, httptest.NewRequest(http.MethodPost, "/duckdb/purge?tag=nav&tag=missing", nil)); err != nil {
+			t.Fatalf("purge: %v", err)
+		}
+		var out map[string]PurgeResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if out["tags-test"].Purged != 1 {
+			t.Errorf("purged = %+v", out)
+		}
+		if h.esiCache.stats().Entries != 0 || h.indexCache.stats().Entries != 1 {
+			t.Errorf("esi = %+v, index = %+v", h.esiCache.stats(), h.indexCache.stats())
+		}
+		if n := h.purgeTags("index"); n != 1 || h.indexCache.stats().Entries != 0 {
+			t.Errorf("purgeTags(index) = %d", n)
+		}
+
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodPost, "/duckdb/purge", nil),
+			httptest.NewRequest(http.MethodGet, "/duckdb/purge?tag=nav", nil),
+		} {
+			err := api.serveAdmin(httptest.NewRecorder(), req)
+			if _, ok := err.(caddy.APIError); !ok {
+				t.Errorf("%s %s: err = %v", req.Method, req.URL, err)
+			}
+		}
+	})
+
+	t.Run("custom headers", func(t *testing.T) {
+		h.TagsHeaders = []string{"Edge-Cache-Tag"}
+		defer func() { h.TagsHeaders = nil }()
+		rec := get(t, "/w1")
+		if rec.Header().Get("Edge-Cache-Tag") != "work:1 author:7 nav" || rec.Header().Get("Surrogate-Key") != "" {
+			t.Errorf("headers = %v", rec.Header())
+		}
+	})
+}
+
+func TestParseTagsColumn(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		tags_column tags
+		tags_headers Surrogate-Key Edge-Cache-Tag
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.TagsColumn != "tags" || !slices.Equal(h.TagsHeaders, []string{"Surrogate-Key", "Edge-Cache-Tag"}) {
+		t.Errorf("TagsColumn = %q, TagsHeaders = %v", h.TagsColumn, h.TagsHeaders)
+	}
+}
@@ -0,0 +1,74 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// templateQuery runs a read-only SQL statement for the duckdbQueryRow and
+// duckdbQueryRows template functions on instance's pool, with args bound to
+// its ? parameters, and returns up to maxRows rows (all if not positive) as
+// maps from column name to value. Values are converted as in JSON
+// responses (see ValueEncoding), except that timestamps stay time.Time so
+// templates can format them.
+func templateQuery(instance, query string, args []any, maxRows int) ([]map[string]any, error) {
+	h := lookupInstance(instance)
+	if h == nil {
+		return nil, fmt.Errorf("no html_from_duckdb instance named %q", instance)
+	}
+	if !h.TemplateQueries {
+		return nil, fmt.Errorf("html_from_duckdb instance %q does not allow template_queries", instance)
+	}
+	if h.QueryMaxRows > 0 && (maxRows <= 0 || maxRows > h.QueryMaxRows) {
+		maxRows = h.QueryMaxRows
+	}
+
+	ctx, cancel := h.queryContext(context.Background())
+	defer cancel()
+	conn, release, err := h.requestConn(ctx, "template")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := validateReadOnlyQuery(ctx, conn, query); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	rows, err := conn.QueryContext(ctx, tagQuery(ctx, query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result, err := h.scanTemplateRows(rows, maxRows)
+	h.observeQuery(ctx, "template", query, time.Since(start))
+	return result, err
+}
+
+// scanTemplateRows reads up to maxRows rows (all if not positive) as maps.
+func (h *HTMLFromDuckDB) scanTemplateRows(rows *sql.Rows, maxRows int) ([]map[string]any, error) {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	result := []map[string]any{}
+	for (maxRows <= 0 || len(result) < maxRows) && rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(cols))
+		for i, col := range cols {
+			row[col.Name()] = h.ValueEncoding.jsonValue(values[i], col.DatabaseTypeName())
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
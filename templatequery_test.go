@@ -0,0 +1,82 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"text/template"
+
+	"go.uber.org/zap"
+)
+
+func TestTemplateQueries(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE works AS SELECT * FROM (VALUES
+		('w1', 'Dune', 1965, TIMESTAMP '2024-03-01 12:00:00'),
+		('w2', 'Emma', 1815, TIMESTAMP '2024-03-02 12:00:00'),
+		('w3', 'Ulysses', 1922, TIMESTAMP '2024-03-03 12:00:00')
+	) t(id, title, year, updated)`); err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+
+	h := &HTMLFromDuckDB{
+		db:              db,
+		Name:            "works",
+		Table:           "works",
+		TemplateQueries: true,
+		QueryMaxRows:    2,
+		logger:          zap.NewNop(),
+	}
+	registerInstance(h)
+	defer unregisterInstance(h)
+
+	execute := func(text string) (string, error) {
+		tpl, err := template.New("page").
+			Funcs(TemplateFunctions{}.CustomTemplateFunctions()).
+			Parse(text)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		var b strings.Builder
+		err = tpl.Execute(&b, nil)
+		return b.String(), err
+	}
+
+	got, err := execute(`{{with duckdbQueryRow "works" "SELECT title, year, updated FROM works WHERE id = ?" "w2"}}` +
+		`{{.title}} ({{.year}}), {{.updated.Format "2006-01-02"}}{{end}}` +
+		`{{if not (duckdbQueryRow "works" "SELECT * FROM works WHERE id = ?" "w9")}}; none{{end}}`)
+	if err != nil || got != "Emma (1815), 2024-03-02; none" {
+		t.Errorf("duckdbQueryRow = %q, %v", got, err)
+	}
+
+	// Rows are capped at query_max_rows.
+	got, err = execute(`{{range duckdbQueryRows "works" "SELECT id FROM works WHERE year > ? ORDER BY id" 1800}}{{.id}} {{end}}`)
+	if err != nil || got != "w1 w2 " {
+		t.Errorf("duckdbQueryRows = %q, %v", got, err)
+	}
+
+	for name, text := range map[string]string{
+		"write":        `{{duckdbQueryRows "works" "DELETE FROM works"}}`,
+		"two":          `{{duckdbQueryRows "works" "SELECT 1; SELECT 2"}}`,
+		"bad sql":      `{{duckdbQueryRow "works" "SELECT nope FROM works"}}`,
+		"unknown name": `{{duckdbQueryRow "nope" "SELECT 1"}}`,
+	} {
+		if _, err := execute(text); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM works`).Scan(&n); err != nil || n != 3 {
+		t.Errorf("works has %d rows, %v", n, err)
+	}
+
+	h.TemplateQueries = false
+	if _, err := execute(`{{duckdbQueryRow "works" "SELECT 1"}}`); err == nil || !strings.Contains(err.Error(), "template_queries") {
+		t.Errorf("expected template_queries error, got %v", err)
+	}
+}
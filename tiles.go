@@ -0,0 +1,203 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// tileCacheSize is the number of tiles kept per handler.
+const tileCacheSize = 4096
+
+// maxTileZoom is the highest zoom level served. Web maps stop well before
+// it.
+const maxTileZoom = 30
+
+// tileFormats maps a tile's file extension to its content type.
+var tileFormats = map[string]string{
+	"mvt":     "application/vnd.mapbox-vector-tile",
+	"geojson": "application/geo+json",
+}
+
+// tile is a tile request's coordinates and format.
+type tile struct {
+	z, x, y int
+	format  string
+}
+
+// key returns the tile's path below tile_path, which is also its cache key.
+func (t tile) key() string {
+	return fmt.Sprintf("%d/%d/%d.%s", t.z, t.x, t.y, t.format)
+}
+
+// tileEntry is a tile in the tile cache. A nil body is an empty tile.
+type tileEntry struct {
+	body []byte
+	etag string
+}
+
+// parseTile parses a tile path, {z}/{x}/{y}.mvt or {z}/{x}/{y}.geojson.
+func parseTile(path string) (tile, error) {
+	var t tile
+	s, format, _ := strings.Cut(path, ".")
+	t.format = format
+	if _, ok := tileFormats[t.format]; !ok {
+		return t, fmt.Errorf("invalid tile %q: want {z}/{x}/{y}.mvt or {z}/{x}/{y}.geojson", path)
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return t, fmt.Errorf("invalid tile %q: want {z}/{x}/{y}.%s", path, t.format)
+	}
+	coords := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return t, fmt.Errorf("invalid tile %q: %q is not a tile coordinate", path, part)
+		}
+		coords[i] = n
+	}
+	t.z, t.x, t.y = coords[0], coords[1], coords[2]
+	if t.z > maxTileZoom {
+		return t, fmt.Errorf("invalid tile %q: zoom exceeds %d", path, maxTileZoom)
+	}
+	if n := 1 << t.z; t.x >= n || t.y >= n {
+		return t, fmt.Errorf("invalid tile %q: x and y must be below %d at zoom %d", path, n, t.z)
+	}
+	return t, nil
+}
+
+// tilePath returns the request's path below tile_path.
+func (h *HTMLFromDuckDB) tilePath(r *http.Request) string {
+	rest, ok := h.endpointRest(r, h.TilePath)
+	if !ok {
+		_, rest, _ = strings.Cut(r.URL.Path, "/"+h.TilePath+"/")
+	}
+	return strings.Trim(rest, "/")
+}
+
+// serveTile serves a tile of the tile macro, called with the tile's z, x
+// and y: an .mvt tile is the first column of the macro's first row, a BLOB
+// such as ST_AsMVT returns, and a .geojson tile its rows as a FeatureCollection, as for
+// geojson_macro. Empty .mvt tiles are 204 No Content. Tiles are kept in
+// the tile cache, if enabled.
+func (h *HTMLFromDuckDB) serveTile(w http.ResponseWriter, r *http.Request) error {
+	t, err := parseTile(h.tilePath(r))
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+	requestInfoFrom(r.Context()).setID(t.key())
+
+	var entry tileEntry
+	var cached bool
+	if h.tileCache != nil {
+		entry, cached = h.tileCache.get(t.key())
+	}
+	if !cached {
+		ctx := r.Context()
+		if h.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+		}
+		var omitted int
+		entry.body, omitted, err = h.queryTile(ctx, t)
+		if err != nil {
+			h.logFailure(r.Context(), "tile macro failed",
+				zap.String("tile", t.key()),
+				zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		if omitted > 0 {
+			h.log(r.Context()).Warn("tile truncated",
+				zap.String("tile", t.key()),
+				zap.Int("max_rows", h.TableMaxRows),
+				zap.Int("omitted_rows", omitted))
+		}
+		if entry.body != nil {
+			entry.etag = generateETag(string(entry.body))
+		}
+		if h.tileCache != nil {
+			h.tileCache.add(t.key(), entry)
+		}
+	}
+
+	if entry.body == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	if notModified(w, r, entry.etag) {
+		return nil
+	}
+	w.Header().Set("Content-Type", tileFormats[t.format])
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.body)))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = w.Write(entry.body)
+	return err
+}
+
+// queryTile runs the tile macro for t and returns the tile, nil for an
+// empty .mvt tile, and for GeoJSON tiles the number of features left out by
+// table_max_rows.
+func (h *HTMLFromDuckDB) queryTile(ctx context.Context, t tile) ([]byte, int, error) {
+	call := fmt.Sprintf("SELECT * FROM %s(z := %d, x := %d, y := %d)", sanitizeIdentifier(h.TileMacro), t.z, t.x, t.y)
+	db := h.queries(ctx, "tile")
+	query := call
+	if t.format == "geojson" {
+		var err error
+		if query, err = h.featuresQuery(ctx, db, call); err != nil {
+			return nil, 0, err
+		}
+	}
+	h.log(ctx).Debug("executing tile macro", zap.String("query", query))
+
+	start := time.Now()
+	defer func() { h.observeQuery(ctx, "tile", query, time.Since(start)) }()
+	rows, err := h.queryRetry(ctx, "tile", db, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	if t.format == "mvt" {
+		body, err := firstBlob(rows)
+		return body, 0, err
+	}
+	var buf bytes.Buffer
+	omitted, err := writeFeatures(&buf, rows, h.GeoJSONGeometryColumn, h.ValueEncoding, h.TableMaxRows)
+	return buf.Bytes(), omitted, err
+}
+
+// firstBlob returns the first column of the first row, or nil if there are
+// no rows or it is NULL or empty.
+func firstBlob(rows *sql.Rows) ([]byte, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var body []byte
+	dest := make([]any, len(cols))
+	dest[0] = &body
+	for i := 1; i < len(dest); i++ {
+		dest[i] = new(any)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, nil
+	}
+	return body, nil
+}
@@ -0,0 +1,111 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestTileEndpoint(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	// A stand-in for ST_AsMVT: tiles at zoom 0 and 1 hold their
+	// coordinates, deeper ones are empty.
+	initFile := writeInitSQL(t, `CREATE OR REPLACE MACRO tiles(z, x, y) AS TABLE
+		SELECT CASE WHEN z < 2 THEN ('tile ' || z || '/' || x || '/' || y)::BLOB END AS mvt,
+			1 AS id,
+			json_object('type', 'Point', 'coordinates', [x, y])::VARCHAR AS geom`)
+	h := &HTMLFromDuckDB{
+		InitSQLFile:  initFile,
+		Table:        "html",
+		TileMacro:    "tiles",
+		TileCacheTTL: "1m",
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, r, emptyNextHandler())
+		var herr caddyhttp.HandlerError
+		if errors.As(err, &herr) {
+			rec.Code = herr.StatusCode
+		} else if err != nil {
+			t.Fatalf("ServeHTTP error: %v", err)
+		}
+		return rec
+	}
+
+	rec := get("/_tiles/1/0/1.mvt", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "tile 1/0/1" {
+		t.Fatalf("mvt: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/vnd.mapbox-vector-tile" {
+		t.Errorf("mvt Content-Type = %q", ct)
+	}
+	if rec := get("/_tiles/1/0/1.mvt", rec.Header().Get("ETag")); rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status = %d", rec.Code)
+	}
+	if stats := h.tileCache.stats(); stats.Hits != 1 || stats.Entries != 1 {
+		t.Errorf("cache stats = %+v", stats)
+	}
+	if rec := get("/_tiles/5/3/7.mvt", ""); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("empty tile: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	rec = get("/_tiles/3/4/5.geojson", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/geo+json" ||
+		!strings.Contains(rec.Body.String(), `"coordinates":[4,5]`) {
+		t.Errorf("geojson: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	for _, path := range []string{"/_tiles/1/2/0.mvt", "/_tiles/1/0.mvt", "/_tiles/1/0/0.png", "/_tiles/a/0/0.mvt", "/_tiles/31/0/0.mvt"} {
+		if rec := get(path, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", path, rec.Code)
+		}
+	}
+}
+
+func TestParseTile(t *testing.T) {
+	tl, err := parseTile("14/8802/4783.mvt")
+	if err != nil || tl != (tile{z: 14, x: 8802, y: 4783, format: "mvt"}) {
+		t.Errorf("parseTile = %+v, %v", tl, err)
+	}
+	if tl.key() != "14/8802/4783.mvt" {
+		t.Errorf("key = %q", tl.key())
+	}
+	for _, s := range []string{"", "0/0/0", "0/0/0.pbf", "0/0/-1.mvt", "2/4/0.mvt", "0/0/0/0.mvt"} {
+		if _, err := parseTile(s); err == nil {
+			t.Errorf("parseTile(%q): expected error", s)
+		}
+	}
+}
+
+func TestParseTiles(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		tile_macro tiles
+		tile_path map/tiles
+		tile_cache_ttl 10m
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.TileMacro != "tiles" || h.TilePath != "map/tiles" || h.TileCacheTTL != "10m" {
+		t.Errorf("TileMacro = %q, TilePath = %q, TileCacheTTL = %q", h.TileMacro, h.TilePath, h.TileCacheTTL)
+	}
+}
@@ -0,0 +1,123 @@
+package caddyhtmlduckdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// ResponseTransformer post-processes a rendered response body before it is
+// sent, e.g. to rewrite links or inject a tracking snippet. Transformers
+// are Caddy modules in the duckdb.transformers namespace, so other
+// modules can add them without changes to this one:
+//
+//	func init() { caddy.RegisterModule(LinkRewriter{}) }
+//
+//	func (LinkRewriter) CaddyModule() caddy.ModuleInfo {
+//		return caddy.ModuleInfo{
+//			ID:  "duckdb.transformers.link_rewriter",
+//			New: func() caddy.Module { return new(LinkRewriter) },
+//		}
+//	}
+//
+// Transformers may implement caddy.Provisioner, caddy.Validator and
+// caddy.CleanerUpper like any module, and caddyfile.Unmarshaler to be
+// configured with the transform directive.
+type ResponseTransformer interface {
+	// TransformResponse returns the body to send for r in place of body,
+	// whose media type is contentType (text/html or application/vnd.api+json).
+	// ETags are computed from the result, so it should be the same for
+	// requests that should share a cached response. An error fails the
+	// request with 500.
+	TransformResponse(r *http.Request, contentType string, body []byte) ([]byte, error)
+}
+
+// transformerNamespace is the Caddy module namespace of transformers.
+const transformerNamespace = "duckdb.transformers"
+
+// provisionTransformers loads the configured transformers, in order.
+func (h *HTMLFromDuckDB) provisionTransformers(ctx caddy.Context) error {
+	for i, raw := range h.TransformersRaw {
+		mod, name, err := loadInlineModule(ctx, transformerNamespace, "transformer", raw)
+		if err != nil {
+			return fmt.Errorf("transformer %d: %v", i, err)
+		}
+		t, ok := mod.(ResponseTransformer)
+		if !ok {
+			return fmt.Errorf("module %s is not a ResponseTransformer", name)
+		}
+		h.transformers = append(h.transformers, t)
+	}
+	return nil
+}
+
+// loadInlineModule loads the module in namespace that raw names with its
+// inlineKey field. It's loaded by ID as endpoint matchers are, with the
+// inline key removed, since module configs are decoded strictly.
+func loadInlineModule(ctx caddy.Context, namespace, inlineKey string, raw json.RawMessage) (any, string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, "", err
+	}
+	var name string
+	if err := json.Unmarshal(fields[inlineKey], &name); err != nil || name == "" {
+		return nil, "", fmt.Errorf("missing %s name", inlineKey)
+	}
+	delete(fields, inlineKey)
+	config, err := json.Marshal(fields)
+	if err != nil {
+		return nil, "", err
+	}
+	mod, err := ctx.LoadModuleByID(namespace+"."+name, config)
+	if err != nil {
+		return nil, "", fmt.Errorf("loading %s: %v", name, err)
+	}
+	return mod, name, nil
+}
+
+// transform runs body through the transformers.
+func (h *HTMLFromDuckDB) transform(r *http.Request, contentType string, body []byte) ([]byte, error) {
+	for _, t := range h.transformers {
+		var err error
+		body, err = t.TransformResponse(r, contentType, body)
+		if err != nil {
+			h.logFailure(r.Context(), "response transformer failed", zap.String("transformer", fmt.Sprintf("%T", t)), zap.Error(err))
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// transformHTML runs an HTML page through the transformers.
+func (h *HTMLFromDuckDB) transformHTML(r *http.Request, html string) (string, error) {
+	if len(h.transformers) == 0 {
+		return html, nil
+	}
+	out, err := h.transform(r, "text/html", []byte(html))
+	return string(out), err
+}
+
+// parseInlineModule parses a directive whose arguments and block are the
+// Caddyfile configuration of a module in namespace, as for transform:
+//
+//	transform <name> [<args...>] [{
+//	    ...
+//	}]
+//
+// and returns the module's JSON, naming it with inlineKey.
+func parseInlineModule(d *caddyfile.Dispenser, namespace, inlineKey string) (json.RawMessage, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	name := d.Val()
+	unm, err := caddyfile.UnmarshalModule(d, namespace+"."+name)
+	if err != nil {
+		return nil, err
+	}
+	return caddyconfig.JSONModuleObject(unm, inlineKey, name, nil), nil
+}
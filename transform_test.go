@@ -0,0 +1,165 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(testSuffixTransformer{})
+}
+
+// testSuffixTransformer appends Suffix to HTML and fails on other types
+// if Strict is set.
+type testSuffixTransformer struct {
+	Suffix string `json:"suffix,omitempty"`
+	Strict bool   `json:"strict,omitempty"`
+}
+
+func (testSuffixTransformer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "duckdb.transformers.test_suffix",
+		New: func() caddy.Module { return new(testSuffixTransformer) },
+	}
+}
+
+func (t *testSuffixTransformer) TransformResponse(r *http.Request, contentType string, body []byte) ([]byte, error) {
+	if contentType != "text/html" {
+		if t.Strict {
+			return nil, fmt.Errorf("unexpected %s", contentType)
+		}
+		return body, nil
+	}
+	return append(body, t.Suffix+r.URL.Query().Get("ref")...), nil
+}
+
+func (t *testSuffixTransformer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next()
+	if d.NextArg() {
+		t.Suffix = d.Val()
+	}
+	for d.NextBlock(0) {
+		if d.Val() == "strict" {
+			t.Strict = true
+		}
+	}
+	return nil
+}
+
+func TestServeHTTP_Transformers(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "content.duckdb")
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE html (id VARCHAR, html VARCHAR);
+		INSERT INTO html VALUES ('a', '<p>A</p>');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	h := &HTMLFromDuckDB{
+		DatabasePath: path,
+		Table:        "html",
+		APIPath:      "api",
+		TransformersRaw: []json.RawMessage{
+			json.RawMessage(`{"transformer": "test_suffix", "suffix": "<!-- 1 -->"}`),
+			json.RawMessage(`{"transformer": "test_suffix", "suffix": "<!-- 2 -->"}`),
+		},
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	defer h.Cleanup()
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.ServeHTTP(rec, r, emptyNextHandler()); err != nil {
+			t.Fatalf("%s: ServeHTTP error: %v", target, err)
+		}
+		return rec
+	}
+	rec := get("/a?ref=x", "")
+	if want := "<p>A</p><!-- 1 -->x<!-- 2 -->x"; rec.Body.String() != want {
+		t.Errorf("body = %q, want %q", rec.Body.String(), want)
+	}
+	// The ETag follows the transformed page.
+	etag := rec.Header().Get("ETag")
+	if rec := get("/a?ref=x", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("same transform: status %d, want 304", rec.Code)
+	}
+	if rec := get("/a?ref=y", etag); rec.Code != http.StatusOK {
+		t.Errorf("different transform: status %d, want 200", rec.Code)
+	}
+	if rec := get("/api/a", ""); !strings.Contains(rec.Body.String(), `"id":"a"`) {
+		t.Errorf("api body = %q", rec.Body.String())
+	}
+
+	// A failing transformer fails the request.
+	h.transformers = append(h.transformers, &testSuffixTransformer{Strict: true})
+	err = h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/a", nil), emptyNextHandler())
+	if err == nil {
+		t.Error("expected error from failing transformer")
+	}
+}
+
+func TestProvisionTransformers(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, raw := range []string{
+		`{"suffix": "x"}`,
+		`{"transformer": "no_such_transformer"}`,
+		`{"transformer": "test_suffix", "unknown": 1}`,
+	} {
+		h := &HTMLFromDuckDB{TransformersRaw: []json.RawMessage{json.RawMessage(raw)}}
+		if err := h.provisionTransformers(ctx); err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
+
+func TestParseTransform(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		transform test_suffix "-a"
+		transform test_suffix {
+			strict
+		}
+		table html
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if len(h.TransformersRaw) != 2 || h.Table != "html" {
+		t.Fatalf("TransformersRaw = %s, Table = %q", h.TransformersRaw, h.Table)
+	}
+	for i, want := range []string{
+		`{"suffix":"-a","transformer":"test_suffix"}`,
+		`{"strict":true,"transformer":"test_suffix"}`,
+	} {
+		if got := string(h.TransformersRaw[i]); got != want {
+			t.Errorf("TransformersRaw[%d] = %s, want %s", i, got, want)
+		}
+	}
+}
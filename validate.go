@@ -0,0 +1,158 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Validate checks for options that have no effect without another one,
+// which usually means a typo or a forgotten option rather than intent, and
+// that search_enabled has a search macro to call. It runs after Provision,
+// so options are compared with their defaults and the database is open.
+// Options inherited from the global defaults are exempt, since they are
+// shared by sites that don't all use them.
+func (h *HTMLFromDuckDB) Validate() error {
+	if h.SearchEnabled && h.database() != nil {
+		exists, err := h.macroExists(h.SearchMacro)
+		if err != nil {
+			return fmt.Errorf("checking search_macro: %v", err)
+		}
+		if !exists {
+			return fmt.Errorf("search_enabled requires the table macro %q (set search_macro to use another)", h.SearchMacro)
+		}
+	}
+	for _, c := range []struct {
+		set      bool
+		option   string
+		requires string
+		enabled  bool
+	}{
+		{h.SearchParam != "q", "search_param", "search_enabled", h.SearchEnabled},
+		{h.IndexCacheTTL != "", "index_cache_ttl", "index_enabled", h.IndexEnabled},
+		{h.HealthDetailed, "health_detailed", "health_enabled", h.HealthEnabled},
+		{h.HealthPath != "_health", "health_path", "health_enabled", h.HealthEnabled},
+		{h.OpenAPIPath != "_openapi.json", "openapi_path", "openapi_enabled", h.OpenAPIEnabled},
+		{h.ESICacheTTL != "", "esi_cache_ttl", "esi", h.ESI},
+		{h.IncludeMaxDepth != 5, "include_max_depth", "includes", h.Includes},
+		{len(h.MarkdownExtensions) > 0, "markdown_extensions", "markdown_column or render_markdown", h.MarkdownColumn != "" || h.RenderMarkdown},
+		{h.TableOrder != "", "table_order", "table_macro", h.TableMacro != ""},
+		{h.TableETag, "table_etag", "table_macro", h.TableMacro != ""},
+		{h.TableCacheControl != "", "table_cache_control", "table_macro", h.TableMacro != ""},
+		{h.TableGrid, "table_grid", "table_macro", h.TableMacro != ""},
+	} {
+		if c.set && !c.enabled && !h.inherited[c.option] {
+			return fmt.Errorf("%s has no effect without %s", c.option, c.requires)
+		}
+	}
+	return nil
+}
+
+// macroExists reports whether the database defines the table macro name.
+func (h *HTMLFromDuckDB) macroExists(name string) (bool, error) {
+	ctx, cancel := h.queryContext(context.Background())
+	defer cancel()
+	query := "SELECT 1 FROM duckdb_functions() WHERE function_name = ? AND function_type = 'table_macro' LIMIT 1"
+	var exists int
+	err := h.database().QueryRowContext(ctx, tagQuery(ctx, query), name).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// parseStringArg parses the required argument of a string subdirective
+// into dst.
+func parseStringArg(d *caddyfile.Dispenser, dst *string) error {
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	*dst = d.Val()
+	return nil
+}
+
+// parseBoolArg parses the argument of a boolean subdirective, which must be
+// true or false, into dst.
+func parseBoolArg(d *caddyfile.Dispenser, dst *bool) error {
+	name := d.Val()
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	switch d.Val() {
+	case "true":
+		*dst = true
+	case "false":
+		*dst = false
+	default:
+		return d.Errf("invalid %s: %q is not true or false", name, d.Val())
+	}
+	return nil
+}
+
+// parseIntArg parses the argument of an integer subdirective into dst.
+func parseIntArg(d *caddyfile.Dispenser, dst *int) error {
+	name := d.Val()
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	return checkIntArg(d, name, dst)
+}
+
+// parseOptionalIntArg is parseIntArg for subdirectives whose argument may
+// be missing or empty, as {$ENV:} with an empty default is.
+func parseOptionalIntArg(d *caddyfile.Dispenser, dst *int) error {
+	name := d.Val()
+	if !d.NextArg() || d.Val() == "" {
+		return nil
+	}
+	return checkIntArg(d, name, dst)
+}
+
+// checkIntArg checks the current argument of the subdirective name and
+// stores it in dst.
+func checkIntArg(d *caddyfile.Dispenser, name string, dst *int) error {
+	n, err := strconv.Atoi(d.Val())
+	if err != nil {
+		return d.Errf("invalid %s: %q is not an integer", name, d.Val())
+	}
+	*dst = n
+	return nil
+}
+
+// parseDurationArg parses the argument of a duration subdirective into
+// dst, checking that it's a duration such as "500ms" or "1h30m".
+func parseDurationArg(d *caddyfile.Dispenser, dst *string) error {
+	name := d.Val()
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	return checkDurationArg(d, name, dst)
+}
+
+// parseOptionalDurationArg is parseDurationArg for subdirectives whose
+// argument may be missing or empty, as {$ENV:} with an empty default is.
+func parseOptionalDurationArg(d *caddyfile.Dispenser, dst *string) error {
+	name := d.Val()
+	if !d.NextArg() || d.Val() == "" {
+		return nil
+	}
+	return checkDurationArg(d, name, dst)
+}
+
+// checkDurationArg checks the current argument of the subdirective name
+// and stores it in dst.
+func checkDurationArg(d *caddyfile.Dispenser, name string, dst *string) error {
+	if _, err := time.ParseDuration(d.Val()); err != nil {
+		return d.Errf("invalid %s: %q is not a duration", name, d.Val())
+	}
+	*dst = d.Val()
+	return nil
+}
+
+// Interface guards
+var _ caddy.Validator = (*HTMLFromDuckDB)(nil)
@@ -0,0 +1,120 @@
+package caddyhtmlduckdb
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestUnmarshalCaddyfile_StrictValues(t *testing.T) {
+	for _, tc := range []struct {
+		line, want string
+	}{
+		{"index_enabled yes", `invalid index_enabled: "yes" is not true or false`},
+		{"read_only 1", `invalid read_only: "1" is not true or false`},
+		{"connection_pool_size 10x", `invalid connection_pool_size: "10x" is not an integer`},
+		{"query_max_rows 1e3", `invalid query_max_rows: "1e3" is not an integer`},
+		{"query_timeout 5", `invalid query_timeout: "5" is not a duration`},
+		{"negative_cache_ttl 1 minute", `invalid negative_cache_ttl: "1" is not a duration`},
+		{"threads four", `invalid threads: "four" is not an integer`},
+		{"load_shedding 5.5", `invalid load_shedding: "5.5" is not an integer`},
+		{"base_path docs/", `invalid base_path: "docs/" must start with / and not end with one`},
+	} {
+		d := caddyfile.NewTestDispenser("html_from_duckdb {\n\ttable html\n\t" + tc.line + "\n}")
+		err := (&HTMLFromDuckDB{}).UnmarshalCaddyfile(d)
+		if err == nil {
+			t.Errorf("%s: expected error", tc.line)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), "Testfile:3") {
+			t.Errorf("%s: error = %q, want %q at Testfile:3", tc.line, err, tc.want)
+		}
+	}
+
+	// Integers in blocks are checked the same way
+	for _, tc := range []struct {
+		block, want, at string
+	}{
+		{"quota {\n\t\tper_minute ten\n\t}", `invalid per_minute: "ten" is not an integer`, "Testfile:4"},
+		{"table_format {\n\t\tdecimal_places two\n\t}", `invalid decimal_places: "two" is not an integer`, "Testfile:4"},
+		{"table_format {\n\t\tmax_cell_width wide\n\t}", `invalid max_cell_width: "wide" is not an integer`, "Testfile:4"},
+		{"experiments {\n\t\tlayout {\n\t\t\tvariant cards heavy\n\t\t}\n\t}", `invalid variant weight: "heavy" is not an integer`, "Testfile:5"},
+	} {
+		d := caddyfile.NewTestDispenser("html_from_duckdb {\n\ttable html\n\t" + tc.block + "\n}")
+		err := (&HTMLFromDuckDB{}).UnmarshalCaddyfile(d)
+		if err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), tc.at) {
+			t.Errorf("%s: error = %v, want %q at %s", tc.block, err, tc.want, tc.at)
+		}
+	}
+
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		index_enabled false
+		read_only false
+		connection_pool_size 4
+		query_timeout 2s
+		negative_cache_ttl ""
+		load_shedding 3
+	}`)
+	h := &HTMLFromDuckDB{IndexEnabled: true}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	if h.IndexEnabled || h.ReadOnly == nil || *h.ReadOnly || h.ConnectionPoolSize != 4 || h.QueryTimeout != "2s" ||
+		h.NegativeCacheTTL != "" || h.LoadShedding.LowPriorityLimit != 3 {
+		t.Errorf("parsed %+v", h)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// Defaults as Provision sets them
+	base := func() *HTMLFromDuckDB {
+		return &HTMLFromDuckDB{
+			SearchMacro:     "render_search",
+			SearchParam:     "q",
+			HealthPath:      "_health",
+			OpenAPIPath:     "_openapi.json",
+			IncludeMaxDepth: 5,
+		}
+	}
+	if err := base().Validate(); err != nil {
+		t.Errorf("defaults: %v", err)
+	}
+	for _, tc := range []struct {
+		set  func(h *HTMLFromDuckDB)
+		want string
+	}{
+		{func(h *HTMLFromDuckDB) { h.SearchParam = "query" }, "search_param has no effect without search_enabled"},
+		{func(h *HTMLFromDuckDB) { h.IndexCacheTTL = "1m" }, "index_cache_ttl has no effect without index_enabled"},
+		{func(h *HTMLFromDuckDB) { h.HealthDetailed = true }, "health_detailed has no effect without health_enabled"},
+		{func(h *HTMLFromDuckDB) { h.ESICacheTTL = "1m" }, "esi_cache_ttl has no effect without esi"},
+		{func(h *HTMLFromDuckDB) { h.TableETag = true }, "table_etag has no effect without table_macro"},
+		{func(h *HTMLFromDuckDB) { h.MarkdownExtensions = []string{"table"} }, "markdown_extensions has no effect"},
+	} {
+		h := base()
+		tc.set(h)
+		if err := h.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("error = %v, want %q", err, tc.want)
+		}
+	}
+
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE MACRO find(q := '') AS TABLE SELECT q AS html`); err != nil {
+		t.Fatal(err)
+	}
+	h := base()
+	h.db = db
+	h.SearchEnabled = true
+	if err := h.Validate(); err == nil || !strings.Contains(err.Error(), `search_enabled requires the table macro "render_search"`) {
+		t.Errorf("search_enabled without search macro: error = %v", err)
+	}
+	h.SearchMacro = "find"
+	if err := h.Validate(); err != nil {
+		t.Errorf("search_enabled with search macro: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package caddyhtmlduckdb
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// ValueEncoding sets how DuckDB types JSON has no equivalent for are
+// written in JSON (the table and query endpoints' JSON and NDJSON, the
+// JSON:API and GeoJSON properties) and CSV. UUIDs are always written as
+// strings and DECIMALs at their scale, so 1.50 stays 1.50.
+type ValueEncoding struct {
+	// Decimals is "number" to write DECIMALs as JSON numbers, or "string"
+	// for clients that parse numbers as doubles.
+	// Default: "number"
+	Decimals string `json:"decimals,omitempty"`
+
+	// LargeIntegers is "auto" to write BIGINT, UBIGINT, HUGEINT and UHUGEINT
+	// values beyond ±2^53, which a JavaScript number can't hold exactly, as
+	// strings; "string" to write all of them as strings; or "number".
+	// Default: "auto"
+	LargeIntegers string `json:"large_integers,omitempty"`
+
+	// Blobs is "base64" or "hex".
+	// Default: "base64"
+	Blobs string `json:"blobs,omitempty"`
+
+	// Intervals is "iso" for ISO 8601 durations (P1Y2M3DT4H5M6S), "text"
+	// for DuckDB's 1 year 2 months 3 days 04:05:06, or "object" for
+	// {"months": 14, "days": 3, "micros": 14706000000}.
+	// Default: "iso"
+	Intervals string `json:"intervals,omitempty"`
+}
+
+// defaultValueEncoding is used when value_encoding isn't set.
+var defaultValueEncoding = &ValueEncoding{Decimals: "number", LargeIntegers: "auto", Blobs: "base64", Intervals: "iso"}
+
+// maxSafeInteger is the largest integer a float64, and so a JavaScript
+// number, holds exactly.
+const maxSafeInteger = 1<<53 - 1
+
+// provision validates the encoding and fills in defaults.
+func (e *ValueEncoding) provision() error {
+	for _, opt := range []struct {
+		name  string
+		value *string
+		allow []string
+	}{
+		{"decimals", &e.Decimals, []string{"number", "string"}},
+		{"large_integers", &e.LargeIntegers, []string{"auto", "number", "string"}},
+		{"blobs", &e.Blobs, []string{"base64", "hex"}},
+		{"intervals", &e.Intervals, []string{"iso", "text", "object"}},
+	} {
+		if *opt.value == "" {
+			*opt.value = opt.allow[0]
+		} else if !slices.Contains(opt.allow, *opt.value) {
+			return fmt.Errorf("invalid value_encoding %s %q, must be one of %s", opt.name, *opt.value, strings.Join(opt.allow, ", "))
+		}
+	}
+	return nil
+}
+
+// jsonValue converts a scanned DuckDB value into a form that encodes
+// naturally as JSON: UUIDs as strings, decimals as exact numbers, NaN and
+// infinities as null, maps with string keys and unions as their member's
+// value, with DECIMALs, large integers, BLOBs and INTERVALs as e says.
+// LIST, STRUCT and MAP elements are converted recursively with the element
+// types read from typeName. A nil e uses the defaults.
+func (e *ValueEncoding) jsonValue(v any, typeName string) any {
+	if e == nil {
+		e = defaultValueEncoding
+	}
+	switch x := v.(type) {
+	case []byte:
+		if typeName == "UUID" && len(x) == 16 {
+			u := duckdb.UUID(x)
+			return u.String()
+		}
+		return e.blobString(x)
+	case float64:
+		return jsonFloat(x)
+	case float32:
+		return jsonFloat(float64(x))
+	case int64:
+		return e.largeInteger(x < -maxSafeInteger || x > maxSafeInteger, strconv.FormatInt(x, 10), v)
+	case uint64:
+		return e.largeInteger(x > maxSafeInteger, strconv.FormatUint(x, 10), v)
+	case *big.Int:
+		return e.largeInteger(!x.IsInt64() || x.Int64() < -maxSafeInteger || x.Int64() > maxSafeInteger, x.String(), json.Number(x.String()))
+	case duckdb.Decimal:
+		if e.Decimals == "string" {
+			return decimalString(x)
+		}
+		return json.Number(decimalString(x))
+	case duckdb.Interval:
+		switch e.Intervals {
+		case "text":
+			return intervalString(x)
+		case "object":
+			return map[string]any{"months": x.Months, "days": x.Days, "micros": x.Micros}
+		default:
+			return isoDuration(x)
+		}
+	case duckdb.OrderedMap:
+		keyType, valueType := mapTypes(typeName)
+		m := make(map[string]any, x.Len())
+		keys, vals := x.Keys(), x.Values()
+		for i := range keys {
+			m[fmt.Sprint(e.jsonValue(keys[i], keyType))] = e.jsonValue(vals[i], valueType)
+		}
+		return m
+	case map[string]any:
+		names, types := structFields(typeName)
+		for k, val := range x {
+			var typ string
+			if i := slices.Index(names, k); i >= 0 {
+				typ = types[i]
+			}
+			x[k] = e.jsonValue(val, typ)
+		}
+		return x
+	case []any:
+		elem := listElemType(typeName)
+		for i, val := range x {
+			x[i] = e.jsonValue(val, elem)
+		}
+		return x
+	case duckdb.Union:
+		return e.jsonValue(x.Value, fieldType(typeName, x.Tag))
+	default:
+		return v
+	}
+}
+
+// largeInteger returns digits as a string if large_integers asks for it,
+// and the number otherwise.
+func (e *ValueEncoding) largeInteger(unsafe bool, digits string, number any) any {
+	if e.LargeIntegers == "string" || (e.LargeIntegers == "auto" && unsafe) {
+		return digits
+	}
+	return number
+}
+
+// blobString encodes a BLOB as base64 or hex.
+func (e *ValueEncoding) blobString(b []byte) string {
+	if e.Blobs == "hex" {
+		return hex.EncodeToString(b)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// appendCSV appends the CSV field text of a value: NULL as nothing, times
+// in ISO 8601 by type, and the types JSON has no equivalent for as in
+// jsonValue, so CSV and JSON of the same rows agree. LIST, STRUCT, MAP and
+// UNION values are written as JSON.
+func (e *ValueEncoding) appendCSV(dst []byte, v any, typeName string) ([]byte, error) {
+	if e == nil {
+		e = defaultValueEncoding
+	}
+	switch x := v.(type) {
+	case time.Time:
+		return append(dst, defaultTableFormat.timeString(x, typeName)...), nil
+	case []byte:
+		if typeName == "UUID" && len(x) == 16 {
+			u := duckdb.UUID(x)
+			return append(dst, u.String()...), nil
+		}
+		return append(dst, e.blobString(x)...), nil
+	case duckdb.Decimal:
+		return append(dst, decimalString(x)...), nil
+	case duckdb.Interval:
+		if e.Intervals == "text" {
+			return append(dst, intervalString(x)...), nil
+		}
+		if e.Intervals == "iso" {
+			return append(dst, isoDuration(x)...), nil
+		}
+	case []any, map[string]any, duckdb.OrderedMap, duckdb.Union:
+	default:
+		return appendValue(dst, v), nil
+	}
+	enc, err := json.Marshal(e.jsonValue(v, typeName))
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, enc...), nil
+}
+
+// isoDuration formats an INTERVAL as an ISO 8601 duration. Negative parts
+// keep their sign, e.g. P-2DT1M.
+func isoDuration(iv duckdb.Interval) string {
+	var b strings.Builder
+	b.WriteByte('P')
+	unit := func(n int64, designator byte) {
+		if n != 0 {
+			b.WriteString(strconv.FormatInt(n, 10))
+			b.WriteByte(designator)
+		}
+	}
+	unit(int64(iv.Months/12), 'Y')
+	unit(int64(iv.Months%12), 'M')
+	unit(int64(iv.Days), 'D')
+	if iv.Micros != 0 {
+		b.WriteByte('T')
+		d := time.Duration(iv.Micros) * time.Microsecond
+		unit(int64(d/time.Hour), 'H')
+		unit(int64(d%time.Hour/time.Minute), 'M')
+		if micros := iv.Micros % 60e6; micros != 0 {
+			sign := ""
+			if micros < 0 {
+				sign, micros = "-", -micros
+			}
+			secs := strconv.FormatInt(micros/1e6, 10)
+			if frac := micros % 1e6; frac != 0 {
+				secs += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+			}
+			b.WriteString(sign + secs + "S")
+		}
+	}
+	if b.Len() == 1 {
+		return "PT0S"
+	}
+	return b.String()
+}
+
+// parseValueEncoding parses a value_encoding block:
+//
+//	value_encoding {
+//	    decimals number|string
+//	    large_integers auto|number|string
+//	    blobs base64|hex
+//	    intervals iso|text|object
+//	}
+func parseValueEncoding(d *caddyfile.Dispenser) (*ValueEncoding, error) {
+	e := &ValueEncoding{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		var field *string
+		switch d.Val() {
+		case "decimals":
+			field = &e.Decimals
+		case "large_integers":
+			field = &e.LargeIntegers
+		case "blobs":
+			field = &e.Blobs
+		case "intervals":
+			field = &e.Intervals
+		default:
+			return nil, d.Errf("unknown value_encoding option: %s", d.Val())
+		}
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		*field = d.Val()
+	}
+	return e, nil
+}
@@ -0,0 +1,138 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// fidelityQuery selects values that database/sql's default scanning would
+// write lossily or as Go internals.
+const fidelityQuery = `SELECT
+	1.50::DECIMAL(5,2) AS price,
+	9007199254740993::BIGINT AS big,
+	42::HUGEINT AS small,
+	170141183460469231731687303715884105727::HUGEINT AS huge,
+	'550e8400-e29b-41d4-a716-446655440000'::UUID AS id,
+	'\xAA\x41'::BLOB AS data,
+	INTERVAL 14 MONTH + INTERVAL 3 DAY + INTERVAL 90 MINUTE AS span,
+	DATE '2024-03-01' AS day`
+
+func TestValueEncoding_JSON(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name     string
+		encoding *ValueEncoding
+		want     string
+	}{
+		{"defaults", nil, `{"price":1.50,"big":"9007199254740993","small":42,` +
+			`"huge":"170141183460469231731687303715884105727","id":"550e8400-e29b-41d4-a716-446655440000",` +
+			`"data":"qkE=","span":"P1Y2M3DT1H30M","day":"2024-03-01T00:00:00Z"}`},
+		{"configured", &ValueEncoding{Decimals: "string", LargeIntegers: "number", Blobs: "hex", Intervals: "object"},
+			`{"price":"1.50","big":9007199254740993,"small":42,` +
+				`"huge":170141183460469231731687303715884105727,"id":"550e8400-e29b-41d4-a716-446655440000",` +
+				`"data":"aa41","span":{"days":3,"micros":5400000000,"months":14},"day":"2024-03-01T00:00:00Z"}`},
+		{"all strings", &ValueEncoding{LargeIntegers: "string", Intervals: "text"}, `{"price":1.50,"big":"9007199254740993","small":"42",` +
+			`"huge":"170141183460469231731687303715884105727","id":"550e8400-e29b-41d4-a716-446655440000",` +
+			`"data":"qkE=","span":"1 year 2 months 3 days 01:30:00","day":"2024-03-01T00:00:00Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.encoding != nil {
+				if err := tt.encoding.provision(); err != nil {
+					t.Fatalf("provision: %v", err)
+				}
+			}
+			rows, err := db.Query(fidelityQuery)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			defer rows.Close()
+			var buf bytes.Buffer
+			if _, err := writeJSONRows(&buf, rows, tt.encoding, -1); err != nil {
+				t.Fatalf("writeJSONRows: %v", err)
+			}
+			if want := "[\n" + tt.want + "\n]\n"; buf.String() != want {
+				t.Errorf("JSON\ngot:  %s\nwant: %s", buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestValueEncoding_CSV(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(fidelityQuery + `, TIMESTAMP '2024-03-01 13:45:00.5' AS at_time, NULL AS nothing`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	var buf bytes.Buffer
+	if _, err := writeCSVRows(&buf, rows, nil, -1); err != nil {
+		t.Fatalf("writeCSVRows: %v", err)
+	}
+	want := "price,big,small,huge,id,data,span,day,at_time,nothing\n" +
+		"1.50,9007199254740993,42,170141183460469231731687303715884105727,550e8400-e29b-41d4-a716-446655440000," +
+		"qkE=,P1Y2M3DT1H30M,2024-03-01,2024-03-01T13:45:00.5,\n"
+	if buf.String() != want {
+		t.Errorf("CSV\ngot:  %q\nwant: %q", buf.String(), want)
+	}
+}
+
+func TestISODuration(t *testing.T) {
+	tests := []struct {
+		iv   duckdb.Interval
+		want string
+	}{
+		{duckdb.Interval{}, "PT0S"},
+		{duckdb.Interval{Months: 12}, "P1Y"},
+		{duckdb.Interval{Days: -2, Micros: 61e6}, "P-2DT1M1S"},
+		{duckdb.Interval{Micros: int64(26*time.Hour/time.Microsecond) + 250000}, "PT26H0.25S"},
+		{duckdb.Interval{Micros: -1500000}, "PT-1.5S"},
+	}
+	for _, tt := range tests {
+		if got := isoDuration(tt.iv); got != tt.want {
+			t.Errorf("isoDuration(%+v) = %q, want %q", tt.iv, got, tt.want)
+		}
+	}
+}
+
+func TestParseValueEncoding(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		value_encoding {
+			decimals string
+			large_integers number
+			blobs hex
+			intervals text
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	want := ValueEncoding{Decimals: "string", LargeIntegers: "number", Blobs: "hex", Intervals: "text"}
+	if h.ValueEncoding == nil || *h.ValueEncoding != want {
+		t.Errorf("ValueEncoding = %+v", h.ValueEncoding)
+	}
+
+	e := &ValueEncoding{}
+	if err := e.provision(); err != nil || *e != *defaultValueEncoding {
+		t.Errorf("provision defaults = %+v, %v", e, err)
+	}
+	if err := (&ValueEncoding{Blobs: "base32"}).provision(); err == nil {
+		t.Error("expected error for blobs base32")
+	}
+}
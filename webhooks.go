@@ -0,0 +1,312 @@
+package caddyhtmlduckdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Webhook events.
+const (
+	eventError        = "error"
+	eventHealth       = "health"
+	eventCacheFlush   = "cache_flush"
+	eventDatabaseSwap = "database_swap"
+)
+
+var webhookEvents = []string{eventError, eventHealth, eventCacheFlush, eventDatabaseSwap}
+
+// webhookQueueSize is the number of events waiting for delivery before new
+// ones are dropped.
+const webhookQueueSize = 100
+
+// Webhooks posts JSON notifications about the handler to external URLs:
+//
+//	{"event": "health", "instance": "works", "time": "...", "data": {...}}
+//
+// When a secret is set, the body is signed with HMAC-SHA256 and the hex
+// digest sent as "X-DuckDB-Signature: sha256=<digest>".
+type Webhooks struct {
+	// URLs receive every event.
+	URLs []string `json:"urls"`
+
+	// Secret signs the request bodies. Optional.
+	Secret string `json:"secret,omitempty"`
+
+	// Events limits the notifications to these events: error (5xx
+	// responses), health (health endpoint status changes), cache_flush
+	// (invalidate_query flushes) and database_swap (a reload switched the
+	// handler to a different database pool, or a replica synced a new copy).
+	// Default: all events
+	Events []string `json:"events,omitempty"`
+
+	// Timeout is the per-attempt request timeout.
+	// Default: "5s"
+	Timeout string `json:"timeout,omitempty"`
+
+	// Retries is how many times a failed delivery (network error, 429 or
+	// 5xx) is retried.
+	// Default: 3
+	Retries *int `json:"retries,omitempty"`
+
+	// Backoff is the delay before the first retry, doubled for each further
+	// retry.
+	// Default: "1s"
+	Backoff string `json:"backoff,omitempty"`
+}
+
+// webhookEvent is the body of a webhook request.
+type webhookEvent struct {
+	Event    string    `json:"event"`
+	Instance string    `json:"instance"`
+	Time     time.Time `json:"time"`
+	Data     any       `json:"data,omitempty"`
+}
+
+// webhookSender delivers events from a queue in the background until its
+// context is done.
+type webhookSender struct {
+	config  *Webhooks
+	timeout time.Duration
+	backoff time.Duration
+	retries int
+	client  *http.Client
+	queue   chan webhookEvent
+	logger  *zap.Logger
+
+	mu     sync.Mutex
+	health string
+}
+
+// provisionWebhooks validates the webhooks block and starts the sender.
+func (h *HTMLFromDuckDB) provisionWebhooks(ctx context.Context) error {
+	if h.Webhooks == nil {
+		return nil
+	}
+	c := h.Webhooks
+	if len(c.URLs) == 0 {
+		return fmt.Errorf("webhooks: at least one url is required")
+	}
+	for _, u := range c.URLs {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("webhooks: invalid url %q", u)
+		}
+	}
+	for _, event := range c.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("webhooks: unknown event %q", event)
+		}
+	}
+	if c.Timeout == "" {
+		c.Timeout = "5s"
+	}
+	if c.Backoff == "" {
+		c.Backoff = "1s"
+	}
+	s := &webhookSender{
+		config:  c,
+		retries: 3,
+		queue:   make(chan webhookEvent, webhookQueueSize),
+		logger:  h.logger,
+		health:  "healthy",
+	}
+	var err error
+	if s.timeout, err = time.ParseDuration(c.Timeout); err != nil {
+		return fmt.Errorf("webhooks: invalid timeout: %v", err)
+	}
+	if s.backoff, err = time.ParseDuration(c.Backoff); err != nil {
+		return fmt.Errorf("webhooks: invalid backoff: %v", err)
+	}
+	if c.Retries != nil {
+		if *c.Retries < 0 {
+			return fmt.Errorf("webhooks: retries must not be negative")
+		}
+		s.retries = *c.Retries
+	}
+	s.client = &http.Client{Timeout: s.timeout}
+	h.webhooks = s
+	go s.run(ctx)
+	return nil
+}
+
+// notify queues event for delivery if webhooks are configured for it. It
+// never blocks: when the queue is full the event is dropped.
+func (h *HTMLFromDuckDB) notify(event string, data any) {
+	s := h.webhooks
+	if s == nil || (len(s.config.Events) > 0 && !slices.Contains(s.config.Events, event)) {
+		return
+	}
+	select {
+	case s.queue <- webhookEvent{Event: event, Instance: h.instanceName(), Time: time.Now().UTC(), Data: data}:
+	default:
+		s.logger.Warn("webhook queue full, dropping event", zap.String("event", event))
+	}
+}
+
+// notifyHealth sends a health event when status differs from the last
+// status the health endpoint reported. The handler starts out healthy.
+func (h *HTMLFromDuckDB) notifyHealth(status string, checks map[string]*CheckResult) {
+	s := h.webhooks
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	previous := s.health
+	s.health = status
+	s.mu.Unlock()
+	if previous != status {
+		h.notify(eventHealth, map[string]any{"from": previous, "to": status, "checks": checks})
+	}
+}
+
+// run delivers queued events one at a time.
+func (s *webhookSender) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.queue:
+			body, err := json.Marshal(event)
+			if err != nil {
+				s.logger.Error("failed to marshal webhook event", zap.String("event", event.Event), zap.Error(err))
+				continue
+			}
+			for _, u := range s.config.URLs {
+				s.deliver(ctx, u, event.Event, body)
+			}
+		}
+	}
+}
+
+// deliver posts body to u, retrying with exponential backoff.
+func (s *webhookSender) deliver(ctx context.Context, u, event string, body []byte) {
+	delay := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, u, event, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= s.retries {
+			s.logger.Warn("webhook delivery failed",
+				zap.String("url", u),
+				zap.String("event", event),
+				zap.Int("attempts", attempt+1),
+				zap.Error(err))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (s *webhookSender) post(ctx context.Context, u, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "caddy-html-duckdb")
+	req.Header.Set("X-DuckDB-Event", event)
+	if s.config.Secret != "" {
+		req.Header.Set("X-DuckDB-Signature", "sha256="+signWebhook(s.config.Secret, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// signWebhook returns the hex HMAC-SHA256 of body keyed with secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseWebhooks parses a webhooks block:
+//
+//	webhooks {
+//	    url <url>...
+//	    secret <secret>
+//	    events <event>...
+//	    timeout <duration>
+//	    retries <n>
+//	    backoff <duration>
+//	}
+func parseWebhooks(d *caddyfile.Dispenser) (*Webhooks, error) {
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	w := &Webhooks{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "url":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			w.URLs = append(w.URLs, args...)
+
+		case "secret":
+			if d.NextArg() {
+				w.Secret = d.Val()
+			}
+			// No error if empty - allows {$WEBHOOK_SECRET:} with empty default
+
+		case "events":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			w.Events = append(w.Events, args...)
+
+		case "timeout":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			w.Timeout = d.Val()
+
+		case "retries":
+			var n int
+			if err := parseIntArg(d, &n); err != nil {
+				return nil, err
+			}
+			w.Retries = &n
+
+		case "backoff":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			w.Backoff = d.Val()
+
+		default:
+			return nil, d.Errf("unrecognized webhooks subdirective: %s", d.Val())
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,133 @@
+package caddyhtmlduckdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestWebhooks(t *testing.T) {
+	type delivery struct {
+		event     string
+		signature string
+		body      webhookEvent
+		raw       []byte
+	}
+	deliveries := make(chan delivery, 10)
+	failures := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-DuckDB-Event") == eventCacheFlush && failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body webhookEvent
+		json.Unmarshal(raw, &body)
+		deliveries <- delivery{r.Header.Get("X-DuckDB-Event"), r.Header.Get("X-DuckDB-Signature"), body, raw}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	retries := 2
+	h := &HTMLFromDuckDB{
+		Name: "works",
+		Webhooks: &Webhooks{
+			URLs:    []string{srv.URL},
+			Secret:  "s3cret",
+			Events:  []string{eventHealth, eventCacheFlush},
+			Retries: &retries,
+			Backoff: "10ms",
+		},
+		logger: zap.NewNop(),
+	}
+	if err := h.provisionWebhooks(ctx); err != nil {
+		t.Fatalf("provisionWebhooks: %v", err)
+	}
+	receive := func() delivery {
+		t.Helper()
+		select {
+		case d := <-deliveries:
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatal("no webhook delivered")
+			return delivery{}
+		}
+	}
+
+	h.notify(eventError, nil) // not subscribed
+	h.notifyHealth("healthy", nil)
+	h.notifyHealth("unhealthy", nil)
+	d := receive()
+	if d.event != eventHealth || d.body.Instance != "works" {
+		t.Errorf("got %s event for %q", d.event, d.body.Instance)
+	}
+	if data, _ := d.body.Data.(map[string]any); data["from"] != "healthy" || data["to"] != "unhealthy" {
+		t.Errorf("health data = %v", d.body.Data)
+	}
+	if d.signature != "sha256="+signWebhook("s3cret", d.raw) {
+		t.Errorf("bad signature %q", d.signature)
+	}
+
+	h.notify(eventCacheFlush, map[string]any{"old": "1", "new": "2"})
+	if d := receive(); d.event != eventCacheFlush || failures != 0 {
+		t.Errorf("expected retried cache_flush, got %s", d.event)
+	}
+	select {
+	case d := <-deliveries:
+		t.Errorf("unexpected %s event", d.event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for _, w := range []*Webhooks{
+		{},
+		{URLs: []string{"ftp://example.com"}},
+		{URLs: []string{srv.URL}, Events: []string{"reload"}},
+		{URLs: []string{srv.URL}, Backoff: "soon"},
+	} {
+		h := &HTMLFromDuckDB{Webhooks: w, logger: zap.NewNop()}
+		if err := h.provisionWebhooks(ctx); err == nil {
+			t.Errorf("expected error for %+v", w)
+		}
+	}
+}
+
+func TestParseWebhooks(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`html_from_duckdb {
+		webhooks {
+			url https://a.example/hook https://b.example/hook
+			secret s3cret
+			events error health
+			timeout 2s
+			retries 0
+			backoff 500ms
+		}
+	}`)
+	h := &HTMLFromDuckDB{}
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	w := h.Webhooks
+	if w == nil || len(w.URLs) != 2 || w.Secret != "s3cret" || strings.Join(w.Events, ",") != "error,health" ||
+		w.Timeout != "2s" || w.Retries == nil || *w.Retries != 0 || w.Backoff != "500ms" {
+		t.Errorf("unexpected webhooks: %+v", w)
+	}
+
+	d = caddyfile.NewTestDispenser(`html_from_duckdb {
+		webhooks {
+			topic x
+		}
+	}`)
+	if err := (&HTMLFromDuckDB{}).UnmarshalCaddyfile(d); err == nil {
+		t.Error("expected error for unknown subdirective")
+	}
+}
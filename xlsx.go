@@ -0,0 +1,306 @@
+package caddyhtmlduckdb
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	duckdb "github.com/duckdb/duckdb-go/v2"
+	"go.uber.org/zap"
+)
+
+// xlsxContentType is the media type of Excel workbooks.
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// xlsxMaxCellText is the most characters Excel holds in a cell; longer text
+// is cut off.
+const xlsxMaxCellText = 32767
+
+// Cell styles, indexes into cellXfs in xl/styles.xml.
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleHeader
+	xlsxStyleDate
+	xlsxStyleDateTime
+	xlsxStyleTime
+)
+
+// xlsxEpoch is day zero of Excel's date serial numbers, chosen so that
+// serials from 1900-03-01 on count Excel's phantom 1900-02-29.
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxParts are the workbook's fixed parts, in zip order; the worksheet
+// follows them.
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<numFmts count="3"><numFmt numFmtId="164" formatCode="yyyy-mm-dd"/><numFmt numFmtId="165" formatCode="yyyy-mm-dd hh:mm:ss"/><numFmt numFmtId="166" formatCode="hh:mm:ss"/></numFmts>` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="5">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="166" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`</cellXfs></styleSheet>`},
+}
+
+// serveTableXLSX serves the table macro's result as an Excel workbook with
+// one sheet, named after the macro: a bold, frozen header row and a typed
+// cell for each value. Rows are capped by table_max_rows and the workbook
+// is streamed once it outgrows stream_buffer.
+func (h *HTMLFromDuckDB) serveTableXLSX(ctx context.Context, w http.ResponseWriter, r *http.Request, query string) error {
+	start := time.Now()
+	rows, err := h.queryRetry(ctx, "table", h.queries(ctx, "table"), query)
+	if err != nil {
+		h.logFailure(ctx, "table macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+
+	name := sanitizeIdentifier(h.TableMacro)
+	w.Header().Set("Content-Type", xlsxContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, name))
+	w.Header().Set("Cache-Control", h.tableCacheControl())
+	sw := newStreamWriter(w, h.StreamBuffer)
+	if h.TableETag {
+		sw.conditional = r
+	}
+	omitted, err := writeXLSX(sw, name, rows, h.TableMaxRows)
+	h.observeQuery(ctx, "table", query, time.Since(start))
+	if err != nil {
+		if sw.started() {
+			if clientGone(ctx) {
+				return nil
+			}
+			h.log(ctx).Error("table stream failed", zap.Error(err))
+			sw.abort()
+		}
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Disposition")
+		h.logFailure(ctx, "table macro failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	if omitted > 0 {
+		h.log(ctx).Warn("table output truncated",
+			zap.String("macro", h.TableMacro),
+			zap.Int("max_rows", h.TableMaxRows),
+			zap.Int("omitted_rows", omitted))
+	}
+	return sw.finish(omitted)
+}
+
+// writeXLSX writes rows as a workbook with a single sheet named sheet. At
+// most maxRows rows are written (0 means no limit); the number of rows left
+// out is returned.
+func writeXLSX(w io.Writer, sheet string, rows *sql.Rows, maxRows int) (int, error) {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	if sheet == "" {
+		sheet = "Sheet1"
+	}
+	// Sheet names are limited to 31 characters.
+	if len(sheet) > 31 {
+		sheet = sheet[:31]
+	}
+
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return 0, err
+		}
+	}
+	f, err := zw.Create("xl/workbook.xml")
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`+
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`, sheet)
+
+	f, err = zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(f)
+	bw.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	bw.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	bw.WriteString("<sheetData>")
+
+	refs := make([]string, len(cols))
+	bw.WriteString(`<row r="1">`)
+	for i, col := range cols {
+		refs[i] = xlsxColumn(i)
+		writeXLSXText(bw, refs[i]+"1", xlsxStyleHeader, col.Name())
+	}
+	bw.WriteString("</row>")
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	n, omitted := 0, 0
+	for rows.Next() {
+		if maxRows > 0 && n >= maxRows {
+			omitted++
+			continue
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		row := strconv.Itoa(n + 2)
+		fmt.Fprintf(bw, `<row r="%s">`, row)
+		for i, v := range values {
+			writeXLSXCell(bw, refs[i]+row, v, cols[i].DatabaseTypeName())
+		}
+		bw.WriteString("</row>")
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	bw.WriteString("</sheetData></worksheet>")
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return omitted, zw.Close()
+}
+
+// writeXLSXCell writes v as the cell ref: numbers and booleans as such,
+// dates, timestamps and times as date serials with a matching format, and
+// everything else as text. NULLs are left out.
+func writeXLSXCell(w *bufio.Writer, ref string, v any, typeName string) {
+	var num float64
+	switch x := v.(type) {
+	case nil:
+		return
+	case bool:
+		b := 0
+		if x {
+			b = 1
+		}
+		fmt.Fprintf(w, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		return
+	case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		fmt.Fprintf(w, `<c r="%s"><v>%d</v></c>`, ref, x)
+		return
+	case float32:
+		num = float64(x)
+	case float64:
+		num = x
+	case duckdb.Decimal:
+		num = x.Float64()
+	case *big.Int:
+		// Beyond 2^53 a spreadsheet number loses digits.
+		if x.IsInt64() && math.Abs(float64(x.Int64())) <= 1<<53 {
+			fmt.Fprintf(w, `<c r="%s"><v>%d</v></c>`, ref, x.Int64())
+		} else {
+			writeXLSXText(w, ref, xlsxStyleDefault, x.String())
+		}
+		return
+	case time.Time:
+		style, serial := xlsxStyleDateTime, xlsxSerial(x)
+		switch {
+		case typeName == "DATE":
+			style = xlsxStyleDate
+		case strings.HasPrefix(typeName, "TIME") && !strings.HasPrefix(typeName, "TIMESTAMP"):
+			style, serial = xlsxStyleTime, serial-math.Floor(serial)
+		case x.Year() < 1900:
+			// Excel has no dates before 1900.
+			writeXLSXText(w, ref, xlsxStyleDefault, string(appendValue(nil, x)))
+			return
+		}
+		fmt.Fprintf(w, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(serial, 'f', -1, 64))
+		return
+	case string:
+		writeXLSXText(w, ref, xlsxStyleDefault, x)
+		return
+	case []byte:
+		writeXLSXText(w, ref, xlsxStyleDefault, defaultValueEncoding.jsonValue(x, typeName).(string))
+		return
+	default:
+		if b, err := json.Marshal(defaultValueEncoding.jsonValue(v, typeName)); err == nil {
+			writeXLSXText(w, ref, xlsxStyleDefault, string(b))
+		} else {
+			writeXLSXText(w, ref, xlsxStyleDefault, string(appendValue(nil, v)))
+		}
+		return
+	}
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		return
+	}
+	fmt.Fprintf(w, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(num, 'g', -1, 64))
+}
+
+// writeXLSXText writes s as an inline string cell.
+func writeXLSXText(w *bufio.Writer, ref string, style int, s string) {
+	if utf8.RuneCountInString(s) > xlsxMaxCellText {
+		s = string([]rune(s)[:xlsxMaxCellText])
+	}
+	fmt.Fprintf(w, `<c r="%s" t="inlineStr"`, ref)
+	if style != xlsxStyleDefault {
+		fmt.Fprintf(w, ` s="%d"`, style)
+	}
+	w.WriteString(`><is><t xml:space="preserve">`)
+	xml.EscapeText(w, []byte(s))
+	w.WriteString("</t></is></c>")
+}
+
+// xlsxColumn returns the letters of the i'th column (from 0): A to Z, AA
+// and so on.
+func xlsxColumn(i int) string {
+	var b []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		b = append([]byte{byte('A' + (i-1)%26)}, b...)
+	}
+	return string(b)
+}
+
+// xlsxSerial returns t's wall clock as an Excel date serial: days since
+// xlsxEpoch, with the time of day as the fraction.
+func xlsxSerial(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	// Not wall.Sub, whose Duration overflows after 292 years.
+	return float64(wall.Unix()-xlsxEpoch.Unix())/86400 + float64(wall.Nanosecond())/86400e9
+}
@@ -0,0 +1,140 @@
+package caddyhtmlduckdb
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// xlsxSheet is the part of a worksheet the tests look at.
+type xlsxSheet struct {
+	Pane struct {
+		YSplit string `xml:"ySplit,attr"`
+		State  string `xml:"state,attr"`
+	} `xml:"sheetViews>sheetView>pane"`
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Style  string `xml:"s,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func TestServeHTTP_TableXLSX(t *testing.T) {
+	db, err := sql.Open("duckdb", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE OR REPLACE MACRO report(base_path := '') AS TABLE
+		SELECT * FROM (VALUES
+			('Ann & Bob', 3, 1.25::DECIMAL(5,2), true, DATE '2025-01-31', TIMESTAMP '2025-01-31 12:00:00', [1, 2]),
+			(NULL, 170141183460469231731687303715884105727::HUGEINT, NULL, false, NULL, NULL, NULL),
+			('extra', 0, 0, false, NULL, NULL, NULL)
+		) t(name, n, amount, ok, day, stamp, tags)
+	`)
+	if err != nil {
+		t.Fatalf("failed to create table macro: %v", err)
+	}
+	handler := &HTMLFromDuckDB{
+		Table:        "html",
+		TableMacro:   "report",
+		TablePath:    "_report",
+		TableMaxRows: 2,
+		db:           db,
+		logger:       zap.NewNop(),
+	}
+
+	rec := httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_report?format=xlsx", nil), emptyNextHandler()); err != nil {
+		t.Fatalf("ServeHTTP error: %v", err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != xlsxContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="report.xlsx"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if rec.Header().Get("X-Truncated") != "1" {
+		t.Errorf("X-Truncated = %q", rec.Header().Get("X-Truncated"))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	parts := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		parts[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+		if err := xml.Unmarshal(parts[f.Name], new(struct{})); err != nil {
+			t.Errorf("%s is not XML: %v", f.Name, err)
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if parts[name] == nil {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	var sheet xlsxSheet
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatalf("sheet: %v", err)
+	}
+	if sheet.Pane.YSplit != "1" || sheet.Pane.State != "frozen" {
+		t.Errorf("header row not frozen: %+v", sheet.Pane)
+	}
+	if len(sheet.Rows) != 3 {
+		t.Fatalf("%d rows, want header and 2", len(sheet.Rows))
+	}
+	header := sheet.Rows[0].Cells
+	if len(header) != 7 || header[0].Inline != "name" || header[6].Ref != "G1" || header[0].Style != "1" {
+		t.Errorf("header = %+v", header)
+	}
+	type cell struct{ ref, typ, style, value, inline string }
+	want := []cell{
+		{"A2", "inlineStr", "", "", "Ann & Bob"},
+		{"B2", "", "", "3", ""},
+		{"C2", "", "", "1.25", ""},
+		{"D2", "b", "", "1", ""},
+		{"E2", "", "2", "45688", ""},
+		{"F2", "", "3", "45688.5", ""},
+		{"G2", "inlineStr", "", "", "[1,2]"},
+	}
+	for i, c := range sheet.Rows[1].Cells {
+		if got := (cell{c.Ref, c.Type, c.Style, c.Value, c.Inline}); i >= len(want) || got != want[i] {
+			t.Errorf("cell %d = %+v", i, got)
+		}
+	}
+	// NULLs are left out and a HUGEINT beyond 2^53 is kept as text.
+	if cells := sheet.Rows[2].Cells; len(cells) != 2 || cells[0].Ref != "B3" || cells[0].Inline != "170141183460469231731687303715884105727" {
+		t.Errorf("row 3 = %+v", cells)
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("xlsxColumn(%d) = %q, want %q", i, got, want)
+		}
+	}
+	if got := xlsxSerial(time.Date(2400, 1, 1, 6, 0, 0, 0, time.UTC)); got != 182623.25 {
+		t.Errorf("xlsxSerial = %v", got)
+	}
+}